@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+// maxQuoteLength truncates the conversation quoted in the report
+const maxQuoteLength = 600
+
+var annotateCmd = &cobra.Command{
+	Use:   "annotate [<env>] <transcript>",
+	Short: "Explain an environment's history from the agent's conversation",
+	Long: `Import the transcript of the agent conversation that worked in an environment, and write
+a timeline of its tool calls explaining why each change was made: the user request, what the agent
+said before the call, and the commit it produced.
+
+The transcript is a JSON export of the conversation (a Claude session log, Claude API messages or
+an OpenAI-style export such as Cursor's). Use - to read it from stdin.
+Tool calls are linked to commits by their explanation. Changes no tool call explains are listed last.`,
+	Args:              cobra.RangeArgs(1, 2),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Annotate the history of an environment
+container-use annotate fancy-mallard ~/.claude/projects/my-project/session.jsonl
+
+# Save the report
+container-use annotate fancy-mallard chat.json > fancy-mallard.md
+
+# Machine-readable timeline
+container-use annotate fancy-mallard chat.json --json`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		transcriptPath := args[len(args)-1]
+		envID, err := resolveEnvironmentID(ctx, repo, args[:len(args)-1])
+		if err != nil {
+			return err
+		}
+
+		var transcript []byte
+		if transcriptPath == "-" {
+			transcript, err = io.ReadAll(app.InOrStdin())
+		} else {
+			transcript, err = os.ReadFile(transcriptPath)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read transcript: %w", err)
+		}
+
+		timeline, err := repo.Annotate(ctx, envID, transcript)
+		if err != nil {
+			return err
+		}
+
+		if asJSON, _ := app.Flags().GetBool("json"); asJSON {
+			out, err := json.MarshalIndent(timeline, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(app.OutOrStdout(), string(out))
+			return nil
+		}
+		return writeTimeline(app.OutOrStdout(), timeline)
+	},
+}
+
+// writeTimeline writes an annotated timeline as a Markdown report
+func writeTimeline(w io.Writer, timeline *repository.AnnotatedTimeline) error {
+	var b strings.Builder
+	linked := 0
+	for _, entry := range timeline.Entries {
+		if entry.Commit != nil {
+			linked++
+		}
+	}
+	fmt.Fprintf(&b, "# Annotated history of %s\n\n", timeline.EnvironmentID)
+	fmt.Fprintf(&b, "%d tool calls, %d changes explained, %d unexplained.\n", len(timeline.Entries), linked, len(timeline.Unexplained))
+
+	request := ""
+	for i, entry := range timeline.Entries {
+		fmt.Fprintf(&b, "\n## %d. %s", i+1, entry.Tool)
+		if entry.Explanation != "" {
+			fmt.Fprintf(&b, ": %s", commitTitle(entry.Explanation))
+		}
+		b.WriteString("\n\n")
+		if !entry.Time.IsZero() {
+			fmt.Fprintf(&b, "*%s*\n\n", reportTime(entry.Time))
+		}
+		// Agents make several tool calls per request: only quote it once
+		if entry.Request != "" && entry.Request != request {
+			fmt.Fprintf(&b, "**Request:**\n\n%s\n\n", quote(entry.Request))
+		}
+		request = entry.Request
+		if entry.Reasoning != "" {
+			fmt.Fprintf(&b, "**Reasoning:**\n\n%s\n\n", quote(entry.Reasoning))
+		}
+		if entry.Commit == nil {
+			b.WriteString("**Change:** none recorded\n")
+			continue
+		}
+		fmt.Fprintf(&b, "**Change:** `%s`%s\n", shortHash(entry.Commit.Hash), formatFiles(entry.Commit.Files))
+	}
+
+	if len(timeline.Unexplained) > 0 {
+		b.WriteString("\n## Unexplained changes\n\n")
+		for _, commit := range timeline.Unexplained {
+			fmt.Fprintf(&b, "- `%s` %s (%s)%s\n", shortHash(commit.Hash), commit.Subject, reportTime(commit.Time), formatFiles(commit.Files))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// reportTime is an absolute local time: reports are read long after they are written
+func reportTime(t time.Time) string {
+	return t.Local().Format("Jan 2, 2006 15:04:05")
+}
+
+func commitTitle(explanation string) string {
+	title, _, _ := strings.Cut(explanation, "\n")
+	return title
+}
+
+func shortHash(hash string) string {
+	return hash[:min(len(hash), 7)]
+}
+
+func formatFiles(files []string) string {
+	if len(files) == 0 {
+		return ""
+	}
+	return " " + strings.Join(files, ", ")
+}
+
+// quote renders conversation text as a Markdown block quote, truncated to maxQuoteLength
+func quote(text string) string {
+	if runes := []rune(text); len(runes) > maxQuoteLength {
+		text = string(runes[:maxQuoteLength]) + "…"
+	}
+	return "> " + strings.ReplaceAll(text, "\n", "\n> ")
+}
+
+func init() {
+	annotateCmd.Flags().Bool("json", false, "Display the timeline in JSON")
+	rootCmd.AddCommand(annotateCmd)
+}
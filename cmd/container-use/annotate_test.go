@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteTimeline(t *testing.T) {
+	at := time.Date(2025, 7, 1, 10, 0, 0, 0, time.Local)
+	commit := &repository.HistoryCommit{Hash: "0123456789abcdef", Subject: "Add the email column", Files: []string{"schema.sql", "users.go"}}
+	timeline := &repository.AnnotatedTimeline{
+		EnvironmentID: "fancy-mallard",
+		Entries: []repository.TimelineEntry{
+			{Tool: "environment_file_write", Explanation: "Add the email column\n\nDetails", Time: at, Request: "Add an email column", Reasoning: "The schema\nlives in schema.sql", Commit: commit},
+			{Tool: "environment_run_cmd", Explanation: "Run the tests", Request: "Add an email column"},
+		},
+		Unexplained: []repository.HistoryCommit{{Hash: "fedcba9876543210", Subject: "Manual fix", Time: at}},
+	}
+
+	var out strings.Builder
+	require.NoError(t, writeTimeline(&out, timeline))
+	report := out.String()
+	assert.Contains(t, report, "# Annotated history of fancy-mallard\n")
+	assert.Contains(t, report, "2 tool calls, 1 changes explained, 1 unexplained.")
+	assert.Contains(t, report, "## 1. environment_file_write: Add the email column\n\n*Jul 1, 2025 10:00:00*")
+	assert.Contains(t, report, "> The schema\n> lives in schema.sql")
+	assert.Contains(t, report, "**Change:** `0123456` schema.sql, users.go")
+	assert.Equal(t, 1, strings.Count(report, "**Request:**"), "the request is quoted once")
+	assert.Contains(t, report, "## 2. environment_run_cmd: Run the tests\n\n**Change:** none recorded")
+	assert.Contains(t, report, "- `fedcba9` Manual fix (Jul 1, 2025 10:00:00)")
+}
+
+func TestQuote(t *testing.T) {
+	assert.Equal(t, "> a\n> b", quote("a\nb"))
+	long := quote(strings.Repeat("é", maxQuoteLength+10))
+	assert.True(t, strings.HasSuffix(long, "é…"))
+}
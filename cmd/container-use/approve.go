@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var approvalsCmd = &cobra.Command{
+	Use:   "approvals",
+	Short: "List the operations of agents waiting for approval",
+	Long: `List the destructive operations agents are waiting for you to approve, as required by
+the approvals of the configuration (container-use config approval). Approve or deny them with
+container-use approve.`,
+	Args: cobra.NoArgs,
+	RunE: func(app *cobra.Command, _ []string) error {
+		ctx := app.Context()
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+		approvals, err := repo.Approvals(ctx)
+		if err != nil {
+			return err
+		}
+		if len(approvals) == 0 {
+			fmt.Println("No operations waiting for approval")
+			return nil
+		}
+
+		now := time.Now()
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tENVIRONMENT\tOPERATION\tSUMMARY\tREQUESTED")
+		for _, approval := range approvals {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", approval.ID, approval.EnvironmentID, approval.Operation,
+				truncate(app, approval.Summary, 60), formatTime(approval.RequestedAt, now))
+		}
+		return tw.Flush()
+	},
+}
+
+var approveCmd = &cobra.Command{
+	Use:   "approve <id>",
+	Short: "Approve or deny an operation of an agent",
+	Long: `Approve an operation an agent is waiting for you to approve, listed by container-use approvals.
+With --deny, the operation is refused instead: the agent is told the reason, if any.`,
+	Args: cobra.ExactArgs(1),
+	Example: `# Let the agent merge its environment
+container-use approve brave-quick-otter
+
+# Refuse to delete a file, telling the agent why
+container-use approve brave-quick-otter --deny --reason "go.mod is still needed"`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+		deny, _ := app.Flags().GetBool("deny")
+		reason, _ := app.Flags().GetString("reason")
+
+		approval, err := repo.DecideApproval(ctx, args[0], !deny, reason)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s\n", approval.Summary, approval.Status)
+		return nil
+	},
+}
+
+func init() {
+	approvalsCmd.Flags().Bool("no-trunc", false, "Don't truncate summaries")
+	approveCmd.Flags().Bool("deny", false, "Deny the operation instead")
+	approveCmd.Flags().String("reason", "", "Reason given to the agent")
+
+	rootCmd.AddCommand(approvalsCmd)
+	rootCmd.AddCommand(approveCmd)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var budgetCmd = &cobra.Command{
+	Use:   "budget [<env>]",
+	Short: "Show or extend the budget of an environment",
+	Long: `Show the budget of an environment and the work agents did so far, or set its limits.
+Agents stop with a budget exceeded error once they used up the budget: only you can extend it.
+Without flags, the budget is shown. A limit of 0 is unlimited.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Show the budget and usage of an environment
+container-use budget fancy-mallard
+
+# Raise the limits to 200 tool calls and an hour of commands
+container-use budget fancy-mallard --tool-calls 200 --command-time 1h`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		envID, err := resolveEnvironmentID(ctx, repo, args)
+		if err != nil {
+			return err
+		}
+
+		envInfo, err := repo.Info(ctx, envID)
+		if err != nil {
+			return err
+		}
+
+		flags := app.Flags()
+		if flags.Changed("tool-calls") || flags.Changed("command-time") {
+			budget := environment.Budget{}
+			if envInfo.State.Budget != nil {
+				budget = *envInfo.State.Budget
+			}
+			if flags.Changed("tool-calls") {
+				budget.MaxToolCalls, _ = flags.GetInt("tool-calls")
+			}
+			if flags.Changed("command-time") {
+				budget.MaxCommandTime, _ = flags.GetDuration("command-time")
+			}
+			envInfo, err = repo.SetBudget(ctx, envID, budget)
+			if err != nil {
+				return err
+			}
+		}
+
+		usage := envInfo.State.BudgetUsage
+		budget := environment.Budget{}
+		if envInfo.State.Budget != nil {
+			budget = *envInfo.State.Budget
+		}
+		fmt.Printf("Tool calls:   %d / %s\n", usage.ToolCalls, budgetLimit(budget.MaxToolCalls, fmt.Sprint(budget.MaxToolCalls)))
+		fmt.Printf("Command time: %s / %s\n", formatDuration(usage.CommandTime, 2), budgetLimit(int(budget.MaxCommandTime), formatDuration(budget.MaxCommandTime, 2)))
+		return nil
+	},
+}
+
+func budgetLimit(limit int, formatted string) string {
+	if limit == 0 {
+		return "unlimited"
+	}
+	return formatted
+}
+
+func init() {
+	budgetCmd.Flags().Int("tool-calls", 0, "Maximum number of tool calls (0 for unlimited)")
+	budgetCmd.Flags().Duration("command-time", 0, "Maximum total run time of commands, e.g. 90m (0 for unlimited)")
+	rootCmd.AddCommand(budgetCmd)
+}
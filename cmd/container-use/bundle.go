@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export <env>",
+	Short: "Export an environment to a bundle to hand it off to another machine",
+	Long: `Write an environment to a single archive: its configuration and state, its
+branch as a git bundle, and its log. Import it with 'container-use import' on
+another machine, in a clone of the same repository, to hand the work off to
+another developer.
+
+The branch starts after the commit the environment was forked from, which the
+other repository must have. The container is built again from the configuration
+on import, unless --checkpoint names a checkpoint published to a registry: the
+environment is then imported with its image.
+
+Running processes and services, and cloud credentials, aren't exported.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Export an environment
+container-use export fancy-mallard
+
+# Export it with the image of a published checkpoint
+container-use export fancy-mallard --checkpoint deps-installed -o handoff.tar.gz`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		output, _ := app.Flags().GetString("output")
+		if output == "" {
+			output = args[0] + ".cu.tar.gz"
+		}
+		checkpoint, _ := app.Flags().GetString("checkpoint")
+
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		manifest, err := repo.ExportBundle(ctx, args[0], checkpoint, f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(output)
+			return err
+		}
+
+		fmt.Printf("Environment '%s' exported to %s.\n", args[0], output)
+		if manifest.BaseCommit != "" {
+			fmt.Printf("The importing repository must have commit %s.\n", manifest.BaseCommit)
+		}
+		fmt.Printf("  container-use import %s\n", output)
+		return nil
+	},
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import <bundle>",
+	Short: "Import an environment exported on another machine",
+	Long: `Create an environment from a bundle written by 'container-use export': its
+branch, log and configuration are the exported ones, and its container is the
+exported checkpoint image or is built again from the configuration.
+
+The environment keeps its ID, unless an environment already has it: use --into
+to choose another one.
+
+Bundles don't get access to this machine: the secrets and host paths of the
+exported configuration are left out and listed.`,
+	Args: cobra.ExactArgs(1),
+	Example: `# Import an environment handed off by another developer
+container-use import fancy-mallard.cu.tar.gz
+
+# Import it under another name
+container-use import fancy-mallard.cu.tar.gz --into review-auth`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		dag, err := connectDagger(ctx, logWriter)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		into, _ := app.Flags().GetString("into")
+		env, manifest, err := repo.ImportBundle(ctx, dag, f, into, fmt.Sprintf("Import %s", args[0]))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Environment '%s' imported from %s (exported as %s).\n", env.ID, args[0], manifest.Environment)
+		if len(manifest.Dropped) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: left out of the imported configuration, configure them again if you trust the bundle: %s\n", strings.Join(manifest.Dropped, ", "))
+		}
+		fmt.Printf("  container-use log %s\n", env.ID)
+		fmt.Printf("  container-use terminal %s\n", env.ID)
+		return nil
+	},
+}
+
+func init() {
+	exportCmd.Flags().StringP("output", "o", "", "Path of the bundle (default: <env>.cu.tar.gz)")
+	exportCmd.Flags().String("checkpoint", "", "Named checkpoint, published to a registry, to import the environment with")
+	importCmd.Flags().String("into", "", "ID of the imported environment (default: the exported ID, or random if taken)")
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(importCmd)
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
 
 	"github.com/dagger/container-use/repository"
 	"github.com/spf13/cobra"
@@ -13,6 +17,8 @@ var checkoutCmd = &cobra.Command{
 	Long: `Bring an environment's work into your local git workspace.
 This creates a local branch from the environment's state so you can
 explore files in your IDE, make changes, or continue development.
+Use --path to print the environment's worktree, where the agent's files are,
+without switching branches, and --editor to open $VISUAL or $EDITOR on it.
 
 If no environment is specified, automatically selects from environments 
 that are descendants of the current HEAD.`,
@@ -24,6 +30,12 @@ container-use checkout fancy-mallard
 # Create custom branch name
 container-use checkout fancy-mallard -b my-review-branch
 
+# Take over in your editor
+container-use checkout fancy-mallard --editor
+
+# Look at the agent's files without switching branches
+cd "$(container-use checkout fancy-mallard --path)"
+
 # Auto-select environment
 container-use checkout`,
 	RunE: func(app *cobra.Command, args []string) error {
@@ -40,6 +52,25 @@ container-use checkout`,
 			return err
 		}
 
+		editor, _ := app.Flags().GetBool("editor")
+		if printPath, _ := app.Flags().GetBool("path"); printPath {
+			if _, err := repo.Info(ctx, envID); err != nil {
+				return err
+			}
+			worktree, err := repo.WorktreePath(envID)
+			if err != nil {
+				return err
+			}
+			if _, err := os.Stat(worktree); err != nil {
+				return fmt.Errorf("worktree of %s not found, it is created when an agent opens the environment: %w", envID, err)
+			}
+			fmt.Println(worktree)
+			if editor {
+				return openEditor(worktree)
+			}
+			return nil
+		}
+
 		branchName, err := app.Flags().GetString("branch")
 		if err != nil {
 			return err
@@ -51,11 +82,36 @@ container-use checkout`,
 		}
 
 		fmt.Printf("Switched to branch '%s'\n", branch)
+		if editor {
+			return openEditor(repo.SourcePath())
+		}
 		return nil
 	},
 }
 
 func init() {
 	checkoutCmd.Flags().StringP("branch", "b", "", "Local branch name to use")
+	checkoutCmd.Flags().Bool("path", false, "Print the path of the environment's worktree instead of switching branches")
+	checkoutCmd.Flags().BoolP("editor", "e", false, "Open $VISUAL or $EDITOR on the checked out files")
 	rootCmd.AddCommand(checkoutCmd)
 }
+
+// openEditor runs $VISUAL or $EDITOR on a directory, and waits for it to exit
+func openEditor(dir string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		return errors.New("no editor: set $VISUAL or $EDITOR")
+	}
+	// Editors are commands with arguments, like "code --wait"
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "editor", dir)
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command(editor, dir)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
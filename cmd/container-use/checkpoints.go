@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var checkpointsCmd = &cobra.Command{
+	Use:   "checkpoints <env>",
+	Short: "Browse the checkpoints of an environment and restore them",
+	Long: `List the checkpoints of an environment, with the lineage linking them to other
+environments: the named checkpoints the environment was restored from, and the
+environments restored from its own checkpoints.
+
+Use --restore to create a new environment from a named checkpoint: its branch
+starts at the commit the checkpoint was taken at and its container is the
+checkpointed one, with everything installed at the time.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Browse the checkpoints of an environment
+container-use checkpoints fancy-mallard
+
+# Start over from the checkpoint taken once dependencies were installed
+container-use checkpoints fancy-mallard --restore deps-installed --into retry-auth`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		if name, _ := app.Flags().GetString("restore"); name != "" {
+			dag, err := connectDagger(ctx, logWriter)
+			if err != nil {
+				return err
+			}
+			defer dag.Close()
+
+			into, _ := app.Flags().GetString("into")
+			env, err := repo.RestoreCheckpoint(ctx, dag, args[0], name, into, fmt.Sprintf("Restore checkpoint %s/%s", args[0], name))
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Environment '%s' restored from checkpoint %s of %s.\n", env.ID, name, args[0])
+			fmt.Printf("  container-use log %s\n", env.ID)
+			fmt.Printf("  container-use terminal %s\n", env.ID)
+			return nil
+		}
+
+		lineage, err := repo.CheckpointLineage(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		if asJSON, _ := app.Flags().GetBool("json"); asJSON {
+			out, err := json.MarshalIndent(lineage, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+			return nil
+		}
+
+		for i, ancestor := range lineage.Ancestors {
+			if i == 0 {
+				fmt.Printf("Restored from %s\n", ancestor)
+			} else {
+				fmt.Printf("  which was restored from %s\n", ancestor)
+			}
+		}
+		if len(lineage.Checkpoints) == 0 {
+			fmt.Printf("No checkpoints in %s\n", args[0])
+			return nil
+		}
+		if len(lineage.Ancestors) > 0 {
+			fmt.Println()
+		}
+
+		now := time.Now()
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		defer tw.Flush()
+		fmt.Fprintln(tw, "NAME\tCREATED\tCOMMIT\tREF\tPARENT\tRESTORED AS")
+		for _, checkpoint := range lineage.Checkpoints {
+			name, parent := checkpoint.Name, "-"
+			if name == "" {
+				name = "(unnamed)"
+			}
+			if checkpoint.Parent != nil {
+				parent = checkpoint.Parent.String()
+			}
+			restored := "-"
+			if envs := lineage.Restored[checkpoint.Name]; checkpoint.Name != "" && len(envs) > 0 {
+				restored = strings.Join(envs, ", ")
+			}
+			commit := checkpoint.SourceCommit
+			if len(commit) > 8 {
+				commit = commit[:8]
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", name, formatTime(checkpoint.CreatedAt, now), commit, truncate(app, checkpoint.Ref, 60), parent, restored)
+		}
+		return nil
+	},
+}
+
+func init() {
+	checkpointsCmd.Flags().String("restore", "", "Create a new environment from the named checkpoint")
+	checkpointsCmd.Flags().String("into", "", "ID of the restored environment (default: random)")
+	checkpointsCmd.Flags().Bool("json", false, "Display the checkpoints and their lineage in JSON")
+	checkpointsCmd.Flags().Bool("no-trunc", false, "Don't truncate image references")
+	rootCmd.AddCommand(checkpointsCmd)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var combineCmd = &cobra.Command{
+	Use:   "combine <env> <env>... [--into <new-env>]",
+	Short: "Combine the work of several environments into a new one",
+	Long: `Create a new environment whose branch merges the branches of all given environments.
+The configuration of the new environment is the union of the source configurations.
+Useful when parallel agents handled separate subtasks of the same feature.
+
+Configuration conflicts are reported but don't prevent the combination.
+Merge conflicts between the environment branches abort the combination.`,
+	Args:              cobra.MinimumNArgs(2),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Combine the work of two agents into a new environment
+container-use combine frontend-work backend-work
+
+# Choose the name of the combined environment
+container-use combine frontend-work backend-work --into full-feature`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		dag, err := connectDagger(ctx, logWriter)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		into, _ := app.Flags().GetString("into")
+		title, _ := app.Flags().GetString("title")
+
+		env, conflicts, err := repo.Combine(ctx, dag, args, into, title, "Combine environments")
+		if err != nil {
+			var conflictErr *repository.MergeConflictError
+			if errors.As(err, &conflictErr) {
+				fmt.Fprintf(os.Stderr, "Unable to combine environments: %s is conflicting with the previous environments in:\n", conflictErr.Source)
+				for _, file := range conflictErr.Files {
+					fmt.Fprintf(os.Stderr, "  %s\n", file)
+				}
+				return errors.New("merge conflicts")
+			}
+			return err
+		}
+
+		for _, conflict := range conflicts {
+			fmt.Fprintf(os.Stderr, "Config conflict: %s\n", conflict)
+		}
+
+		fmt.Printf("Environment '%s' created from %d environments.\n", env.ID, len(args))
+		fmt.Printf("  container-use log %s\n", env.ID)
+		fmt.Printf("  container-use checkout %s\n", env.ID)
+		return nil
+	},
+}
+
+func init() {
+	combineCmd.Flags().String("into", "", "ID of the combined environment (default: random)")
+	combineCmd.Flags().String("title", "", "Title of the combined environment")
+	rootCmd.AddCommand(combineCmd)
+}
@@ -4,6 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"text/tabwriter"
 
 	"github.com/dagger/container-use/cmd/container-use/agent"
@@ -59,6 +63,539 @@ var configCmd = &cobra.Command{
 These settings are stored in .container-use/environment.json and apply to all new environments.`,
 }
 
+// Plan secret object commands
+var configPlanSecretCmd = &cobra.Command{
+	Use:   "plan-secret",
+	Short: "Manage infrastructure plan secrets",
+	Long: `Manage secrets that are only exposed to infrastructure plans (environment_iac_plan), such as cloud provider credentials.
+Plan secrets are never set in the environment itself.`,
+}
+
+var configPlanSecretSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Set a plan secret",
+	Long:  `Set a secret to be used by infrastructure plans (e.g., "AWS_SECRET_ACCESS_KEY" "env://AWS_SECRET_ACCESS_KEY").`,
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key := args[0]
+		value := args[1]
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.PlanSecrets.Set(key, value)
+			fmt.Printf("Plan secret set: %s=%s\n", key, value)
+			return nil
+		})
+	},
+}
+
+var configPlanSecretUnsetCmd = &cobra.Command{
+	Use:   "unset <key>",
+	Short: "Unset a plan secret",
+	Long:  `Unset a plan secret from the environment configuration.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key := args[0]
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if !config.PlanSecrets.Unset(key) {
+				return fmt.Errorf("plan secret not found: %s", key)
+			}
+			fmt.Printf("Plan secret unset: %s\n", key)
+			return nil
+		})
+	},
+}
+
+var configPlanSecretListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all plan secrets",
+	Long:  `List all secrets that will be exposed to infrastructure plans.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			keys := config.PlanSecrets.Keys()
+			if len(keys) == 0 {
+				fmt.Println("No plan secrets configured")
+				return nil
+			}
+
+			for i, key := range keys {
+				value := config.PlanSecrets.Get(key)
+				fmt.Printf("%d. %s=%s\n", i+1, key, value)
+			}
+			return nil
+		})
+	},
+}
+
+var configPlanSecretClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Clear all plan secrets",
+	Long:  `Remove all plan secrets from the environment configuration.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.PlanSecrets.Clear()
+			fmt.Println("All plan secrets cleared")
+			return nil
+		})
+	},
+}
+
+// Limit object commands
+var configLimitCmd = &cobra.Command{
+	Use:   "limit",
+	Short: "Manage resource limits",
+	Long: `Manage the resource limits of the commands and services run in environments.
+Limits apply to each process: cpu-time (e.g. 30m), memory (e.g. 4GB) and disk, the maximum size of a written file (e.g. 10GB).`,
+}
+
+// resourceLimit returns the field of the limits matching a limit name
+func resourceLimit(limits *environment.ResourceLimits, name string) (*string, error) {
+	switch name {
+	case "cpu-time":
+		return &limits.CPUTime, nil
+	case "memory":
+		return &limits.Memory, nil
+	case "disk":
+		return &limits.Disk, nil
+	}
+	return nil, fmt.Errorf("unknown limit %q: expected cpu-time, memory or disk", name)
+}
+
+var configLimitSetCmd = &cobra.Command{
+	Use:       "set <cpu-time|memory|disk> <value>",
+	Short:     "Set a resource limit",
+	Long:      `Set a resource limit for new environments (e.g., "memory" "4GB").`,
+	Args:      cobra.ExactArgs(2),
+	ValidArgs: []string{"cpu-time", "memory", "disk"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			limits := &environment.ResourceLimits{}
+			if config.Resources != nil {
+				*limits = *config.Resources
+			}
+			field, err := resourceLimit(limits, args[0])
+			if err != nil {
+				return err
+			}
+			*field = args[1]
+			if err := limits.Validate(); err != nil {
+				return err
+			}
+			config.Resources = limits
+			fmt.Printf("Limit set: %s=%s\n", args[0], args[1])
+			return nil
+		})
+	},
+}
+
+var configLimitUnsetCmd = &cobra.Command{
+	Use:       "unset <cpu-time|memory|disk>",
+	Short:     "Unset a resource limit",
+	Long:      `Remove a resource limit from the environment configuration.`,
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{"cpu-time", "memory", "disk"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if config.Resources == nil {
+				config.Resources = &environment.ResourceLimits{}
+			}
+			field, err := resourceLimit(config.Resources, args[0])
+			if err != nil {
+				return err
+			}
+			if *field == "" {
+				return fmt.Errorf("limit not set: %s", args[0])
+			}
+			*field = ""
+			if config.Resources.IsZero() {
+				config.Resources = nil
+			}
+			fmt.Printf("Limit unset: %s\n", args[0])
+			return nil
+		})
+	},
+}
+
+var configLimitListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all resource limits",
+	Long:  `List the resource limits of new environments.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if config.Resources.IsZero() {
+				fmt.Println("No limits configured")
+				return nil
+			}
+			for _, name := range []string{"cpu-time", "memory", "disk"} {
+				field, _ := resourceLimit(config.Resources, name)
+				if *field != "" {
+					fmt.Printf("%s=%s\n", name, *field)
+				}
+			}
+			return nil
+		})
+	},
+}
+
+// Cache object commands
+var configCacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage cache volumes",
+	Long: `Manage the cache volumes mounted in new environments, to keep downloaded dependencies (Go modules, npm or pip packages, ...) across environments.
+Environments mounting caches with the same name share them. Without caches configured, the caches of the languages used by the project are added when an environment is created.`,
+}
+
+var configCacheSetCmd = &cobra.Command{
+	Use:   "set <name> <path>",
+	Short: "Mount a cache volume",
+	Long:  `Mount a cache volume at a path of new environments (e.g., "gradle" "/root/.gradle/caches").`,
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			caches := slices.DeleteFunc(slices.Clone(config.Caches), func(c environment.CacheMount) bool {
+				return c.Name == args[0]
+			})
+			caches = append(caches, environment.CacheMount{Name: args[0], Path: args[1]})
+			if err := caches.Validate(); err != nil {
+				return err
+			}
+			config.Caches = caches
+			fmt.Printf("Cache set: %s at %s\n", args[0], args[1])
+			return nil
+		})
+	},
+}
+
+var configCacheUnsetCmd = &cobra.Command{
+	Use:   "unset <name>",
+	Short: "Unmount a cache volume",
+	Long:  `Remove a cache volume from the environment configuration. The volume itself is kept by the container engine.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if config.Caches.Get(args[0]) == nil {
+				return fmt.Errorf("cache not found: %s", args[0])
+			}
+			config.Caches = slices.DeleteFunc(config.Caches, func(c environment.CacheMount) bool {
+				return c.Name == args[0]
+			})
+			fmt.Printf("Cache unset: %s\n", args[0])
+			return nil
+		})
+	},
+}
+
+var configCacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all cache volumes",
+	Long:  `List the cache volumes mounted in new environments.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if len(config.Caches) == 0 {
+				fmt.Println("No caches configured")
+				return nil
+			}
+			for i, c := range config.Caches {
+				fmt.Printf("%d. %s at %s\n", i+1, c.Name, c.Path)
+			}
+			return nil
+		})
+	},
+}
+
+// Host path commands
+var configHostPathCmd = &cobra.Command{
+	Use:   "host-path",
+	Short: "Manage the host directories host environments may access",
+	Long: `Manage the directories outside the worktree that environments with the "host" base image may access, e.g. a shared dataset.
+Their filesystem operations, and the output redirections of their commands, are refused outside the worktree and these directories.
+The host files agents copy into or out of any environment, and the bundles they export or import, must be in the repository or these directories.`,
+}
+
+var configHostPathAllowCmd = &cobra.Command{
+	Use:   "allow <path>",
+	Short: "Allow a host directory",
+	Long:  `Allow host environments to read an absolute host directory, and to write it with --write.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		write, _ := cmd.Flags().GetBool("write")
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			paths := slices.DeleteFunc(slices.Clone(config.HostPaths), func(p environment.HostPath) bool {
+				return filepath.Clean(p.Path) == filepath.Clean(args[0])
+			})
+			paths = append(paths, environment.HostPath{Path: args[0], Write: write})
+			if err := paths.Validate(); err != nil {
+				return err
+			}
+			config.HostPaths = paths
+			fmt.Printf("Host path allowed: %s (%s)\n", args[0], hostPathAccess(write))
+			return nil
+		})
+	},
+}
+
+var configHostPathRemoveCmd = &cobra.Command{
+	Use:   "remove <path>",
+	Short: "Disallow a host directory",
+	Long:  `Remove a host directory from the directories host environments may access.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if config.HostPaths.Get(args[0]) == nil {
+				return fmt.Errorf("host path not found: %s", args[0])
+			}
+			config.HostPaths = slices.DeleteFunc(config.HostPaths, func(p environment.HostPath) bool {
+				return filepath.Clean(p.Path) == filepath.Clean(args[0])
+			})
+			fmt.Printf("Host path removed: %s\n", args[0])
+			return nil
+		})
+	},
+}
+
+var configHostPathListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the host directories host environments may access",
+	Long:  `List the directories outside the worktree host environments may access.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if len(config.HostPaths) == 0 {
+				fmt.Println("No host paths allowed")
+				return nil
+			}
+			for i, p := range config.HostPaths {
+				fmt.Printf("%d. %s (%s)\n", i+1, p.Path, hostPathAccess(p.Write))
+			}
+			return nil
+		})
+	},
+}
+
+// Approval commands
+var configApprovalCmd = &cobra.Command{
+	Use:   "approval",
+	Short: "Manage the operations agents must wait for you to approve",
+	Long: `Manage the destructive operations agents can only perform once you approved them with container-use approve:
+` + strings.Join(environment.ApprovalOperations, ", ") + `.`,
+}
+
+var configApprovalAddCmd = &cobra.Command{
+	Use:       "add <operation>",
+	Short:     "Require approval for an operation",
+	Long:      `Require agents to wait for you to approve an operation: ` + strings.Join(environment.ApprovalOperations, ", ") + `.`,
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: environment.ApprovalOperations,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if config.Approvals.Requires(args[0]) {
+				return fmt.Errorf("%s already requires approval", args[0])
+			}
+			approvals := append(slices.Clone(config.Approvals), args[0])
+			if err := approvals.Validate(); err != nil {
+				return err
+			}
+			config.Approvals = approvals
+			fmt.Printf("Approval required for: %s\n", args[0])
+			return nil
+		})
+	},
+}
+
+var configApprovalRemoveCmd = &cobra.Command{
+	Use:   "remove <operation>",
+	Short: "Stop requiring approval for an operation",
+	Long:  `Let agents perform an operation without waiting for your approval.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if !config.Approvals.Requires(args[0]) {
+				return fmt.Errorf("%s doesn't require approval", args[0])
+			}
+			config.Approvals = slices.DeleteFunc(config.Approvals, func(op string) bool { return op == args[0] })
+			fmt.Printf("Approval no longer required for: %s\n", args[0])
+			return nil
+		})
+	},
+}
+
+var configApprovalListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the operations requiring approval",
+	Long:  `List the operations agents must wait for you to approve.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if len(config.Approvals) == 0 {
+				fmt.Println("No operations require approval")
+				return nil
+			}
+			for i, op := range config.Approvals {
+				fmt.Printf("%d. %s\n", i+1, op)
+			}
+			return nil
+		})
+	},
+}
+
+func hostPathAccess(write bool) string {
+	if write {
+		return "read-write"
+	}
+	return "read-only"
+}
+
+// License header commands
+var configLicenseHeaderCmd = &cobra.Command{
+	Use:   "license-header",
+	Short: "Manage required license headers",
+	Long: `Manage the license headers the files changed by agents must keep.
+Files missing a header are reported when environments are reviewed and merged, along with the changes to paths
+protected by the repository's CODEOWNERS file.`,
+}
+
+var configLicenseHeaderAddCmd = &cobra.Command{
+	Use:   "add <header> <path-pattern>...",
+	Short: "Require a license header",
+	Long: `Require a text in the first 20 lines of the files matching gitignore-style patterns
+(e.g., "SPDX-License-Identifier: Apache-2.0" "*.go" "/scripts/**/*.sh").`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			headers := slices.DeleteFunc(slices.Clone(config.LicenseHeaders), func(h environment.LicenseHeader) bool {
+				return h.Header == args[0]
+			})
+			headers = append(headers, environment.LicenseHeader{Header: args[0], Paths: args[1:]})
+			if err := headers.Validate(); err != nil {
+				return err
+			}
+			config.LicenseHeaders = headers
+			fmt.Printf("License header required in %s: %s\n", strings.Join(args[1:], ", "), args[0])
+			return nil
+		})
+	},
+}
+
+var configLicenseHeaderRemoveCmd = &cobra.Command{
+	Use:   "remove <header>",
+	Short: "Stop requiring a license header",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			headers := slices.DeleteFunc(slices.Clone(config.LicenseHeaders), func(h environment.LicenseHeader) bool {
+				return h.Header == args[0]
+			})
+			if len(headers) == len(config.LicenseHeaders) {
+				return fmt.Errorf("license header not found: %s", args[0])
+			}
+			config.LicenseHeaders = headers
+			fmt.Printf("License header removed: %s\n", args[0])
+			return nil
+		})
+	},
+}
+
+var configLicenseHeaderListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List required license headers",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if len(config.LicenseHeaders) == 0 {
+				fmt.Println("No license headers required")
+				return nil
+			}
+			for i, h := range config.LicenseHeaders {
+				fmt.Printf("%d. %s in %s\n", i+1, h.Header, strings.Join(h.Paths, ", "))
+			}
+			return nil
+		})
+	},
+}
+
+// Webhook commands
+var configWebhookCmd = &cobra.Command{
+	Use:   "webhook",
+	Short: "Manage environment lifecycle webhooks",
+	Long: `Manage the webhooks called back when environments are created, deleted and checkpointed,
+for external systems such as asset inventories, cost trackers and CMDBs to track them.
+Callbacks are JSON POST requests describing the environment, signed with HMAC-SHA256 in the
+X-Container-Use-Signature-256 header when the webhook has a secret.`,
+}
+
+var configWebhookAddCmd = &cobra.Command{
+	Use:   "add <url>",
+	Short: "Add a webhook",
+	Long: `Add a webhook, or replace the one with the same URL.
+The secret is a secret reference (e.g., "env://CU_WEBHOOK_SECRET"), resolved when callbacks are sent.`,
+	Example: `# Send every event, signed
+container-use config webhook add https://cmdb.example.com/hooks/container-use --secret env://CU_WEBHOOK_SECRET
+
+# Only track creations and deletions
+container-use config webhook add https://costs.example.com/hook --event environment.created --event environment.deleted`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		secret, _ := cmd.Flags().GetString("secret")
+		events, _ := cmd.Flags().GetStringSlice("event")
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			webhooks := slices.DeleteFunc(slices.Clone(config.Webhooks), func(w environment.Webhook) bool {
+				return w.URL == args[0]
+			})
+			webhooks = append(webhooks, environment.Webhook{URL: args[0], Secret: secret, Events: events})
+			if err := webhooks.Validate(); err != nil {
+				return err
+			}
+			config.Webhooks = webhooks
+			fmt.Printf("Webhook added: %s\n", args[0])
+			return nil
+		})
+	},
+}
+
+var configWebhookRemoveCmd = &cobra.Command{
+	Use:   "remove <url>",
+	Short: "Remove a webhook",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			webhooks := slices.DeleteFunc(slices.Clone(config.Webhooks), func(w environment.Webhook) bool {
+				return w.URL == args[0]
+			})
+			if len(webhooks) == len(config.Webhooks) {
+				return fmt.Errorf("webhook not found: %s", args[0])
+			}
+			config.Webhooks = webhooks
+			fmt.Printf("Webhook removed: %s\n", args[0])
+			return nil
+		})
+	},
+}
+
+var configWebhookListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List webhooks",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if len(config.Webhooks) == 0 {
+				fmt.Println("No webhooks configured")
+				return nil
+			}
+			for i, w := range config.Webhooks {
+				fmt.Printf("%d. %s\n", i+1, formatWebhook(w))
+			}
+			return nil
+		})
+	},
+}
+
+// formatWebhook describes a webhook, without resolving its secret
+func formatWebhook(w environment.Webhook) string {
+	events := "all events"
+	if len(w.Events) > 0 {
+		events = strings.Join(w.Events, ", ")
+	}
+	signed := ""
+	if w.Secret != "" {
+		signed = ", signed with " + w.Secret
+	}
+	return fmt.Sprintf("%s (%s%s)", w.URL, events, signed)
+}
+
 func init() {
 	configShowCmd.Flags().Bool("json", false, "Dump the configuration in JSON")
 }
@@ -113,6 +650,10 @@ container-use config show my-env
 
 		fmt.Fprintf(tw, "Base Image:\t%s\n", config.BaseImage)
 		fmt.Fprintf(tw, "Workdir:\t%s\n", config.Workdir)
+		fmt.Fprintf(tw, "Secret Scan:\t%s\n", secretScanMode(config))
+		if config.Kubernetes != nil {
+			fmt.Fprintf(tw, "Kubernetes:\t%s\n", kubernetesTarget(config.Kubernetes))
+		}
 
 		if len(config.SetupCommands) > 0 {
 			fmt.Fprintf(tw, "Setup Commands:\t\n")
@@ -154,6 +695,64 @@ container-use config show my-env
 			fmt.Fprintf(tw, "Secrets:\t(none)\n")
 		}
 
+		if len(config.Ports) > 0 {
+			ports := make([]string, 0, len(config.Ports))
+			for _, port := range config.Ports {
+				ports = append(ports, strconv.Itoa(port))
+			}
+			fmt.Fprintf(tw, "Ports:\t%s\n", strings.Join(ports, ", "))
+		}
+
+		if len(config.Caches) > 0 {
+			fmt.Fprintf(tw, "Caches:\t\n")
+			for i, c := range config.Caches {
+				fmt.Fprintf(tw, "  %d.\t%s at %s\n", i+1, c.Name, c.Path)
+			}
+		}
+
+		if len(config.HostPaths) > 0 {
+			fmt.Fprintf(tw, "Host Paths:\t\n")
+			for i, p := range config.HostPaths {
+				fmt.Fprintf(tw, "  %d.\t%s (%s)\n", i+1, p.Path, hostPathAccess(p.Write))
+			}
+		}
+
+		if len(config.LicenseHeaders) > 0 {
+			fmt.Fprintf(tw, "License Headers:\t\n")
+			for i, h := range config.LicenseHeaders {
+				fmt.Fprintf(tw, "  %d.\t%s in %s\n", i+1, h.Header, strings.Join(h.Paths, ", "))
+			}
+		}
+
+		if len(config.Webhooks) > 0 {
+			fmt.Fprintf(tw, "Webhooks:\t\n")
+			for i, w := range config.Webhooks {
+				fmt.Fprintf(tw, "  %d.\t%s\n", i+1, formatWebhook(w))
+			}
+		}
+
+		if len(config.Approvals) > 0 {
+			fmt.Fprintf(tw, "Approvals:\t%s\n", strings.Join(config.Approvals, ", "))
+		}
+
+		if !config.Resources.IsZero() {
+			fmt.Fprintf(tw, "Limits:\t\n")
+			for _, name := range []string{"cpu-time", "memory", "disk"} {
+				if field, _ := resourceLimit(config.Resources, name); *field != "" {
+					fmt.Fprintf(tw, "  %s\t%s\n", name, *field)
+				}
+			}
+		}
+
+		planSecretKeys := config.PlanSecrets.Keys()
+		if len(planSecretKeys) > 0 {
+			fmt.Fprintf(tw, "Plan Secrets:\t\n")
+			for i, key := range planSecretKeys {
+				value := config.PlanSecrets.Get(key)
+				fmt.Fprintf(tw, "  %d.\t%s=%s\n", i+1, key, value)
+			}
+		}
+
 		return nil
 	},
 }
@@ -194,7 +793,174 @@ container-use config import my-env`,
 	},
 }
 
+var configAuditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Report env vars and secrets unused by agents",
+	Long: `Report how many commands run in the environments of the repository referenced each
+configured environment variable and secret, to find the ones agents don't need.
+Only references by name in the commands themselves are counted: a variable read by a
+program or script without being named in the command is reported as unreferenced.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+		envs, err := repo.List(ctx)
+		if err != nil {
+			return err
+		}
+
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			usages := environment.AuditEnvUsage(config, envs)
+			if len(usages) == 0 {
+				fmt.Println("No environment variables or secrets configured")
+				return nil
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tKIND\tCOMMANDS")
+			for _, usage := range usages {
+				fmt.Fprintf(tw, "%s\t%s\t%d\n", usage.Name, usage.Kind, usage.Commands)
+			}
+			tw.Flush()
+
+			fmt.Printf("\nAcross %d environments. Remove unneeded variables with `container-use config env unset` or `container-use config secret unset`.\n", len(envs))
+			return nil
+		})
+	},
+}
+
+var configLintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Check the configuration before building environments",
+	Long: `Check the environment configuration of the repository for mistakes that would only show up
+when building an environment: unknown fields, invalid settings, base and service images that
+don't exist, commands referencing files the project doesn't have, conflicting services and
+secret references that don't resolve.
+
+Images are resolved in their registry without being pulled, which needs the Dagger engine and
+network access: use --no-resolve to skip them. Secrets are resolved but never displayed.
+Exits with an error if the configuration has errors.`,
+	Example: `# Lint the configuration of the repository
+container-use config lint
+
+# Lint a template, with the configuration of the repository applied on top
+container-use config lint --template go-service`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+
+		// Build the configuration the way environments get it
+		config := environment.DefaultConfig()
+		if name, _ := cmd.Flags().GetString("template"); name != "" {
+			t, err := environment.GetTemplate(name)
+			if err != nil {
+				return err
+			}
+			config = t.Config()
+		}
+		if err := config.Load(repo.SourcePath()); err != nil {
+			return fmt.Errorf("failed to load configuration: %w", err)
+		}
+
+		issues, err := environment.LintConfigFile(repo.SourcePath())
+		if err != nil {
+			return err
+		}
+		issues = append(issues, environment.LintConfig(ctx, config, repo.SourcePath())...)
+		if noResolve, _ := cmd.Flags().GetBool("no-resolve"); !noResolve && !environment.IsOffline() {
+			dag, err := connectDagger(ctx, logWriter)
+			if err != nil {
+				return err
+			}
+			defer dag.Close()
+			imageIssues, err := environment.ResolveImages(ctx, dag, config)
+			if err != nil {
+				return err
+			}
+			issues = append(issues, imageIssues...)
+		}
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			out, err := json.MarshalIndent(issues, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+		} else if len(issues) == 0 {
+			fmt.Println("No issues found")
+		} else {
+			for _, issue := range issues {
+				fmt.Println(issue)
+			}
+		}
+
+		errs := 0
+		for _, issue := range issues {
+			if issue.Severity == environment.LintError {
+				errs++
+			}
+		}
+		if errs > 0 {
+			return fmt.Errorf("configuration has %d errors", errs)
+		}
+		return nil
+	},
+}
+
 // Base image object commands
+var configSecretScanCmd = &cobra.Command{
+	Use:   "secret-scan",
+	Short: "Manage secret scanning of agent changes",
+	Long: `Manage what happens when agents write credentials to the repository.
+Files written by agents are scanned as they are written, and environment changes before they are merged or exported.
+
+Modes:
+  warn   Report the secrets found to the agent and in the log (default)
+  block  Refuse to write files or merge changes with secrets
+  off    Don't scan`,
+}
+
+var configSecretScanSetCmd = &cobra.Command{
+	Use:       "set <warn|block|off>",
+	Short:     "Set the secret scan mode",
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{environment.SecretScanWarn, environment.SecretScanBlock, environment.SecretScanOff},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mode := args[0]
+		if err := environment.ValidateSecretScan(mode); err != nil {
+			return err
+		}
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.SecretScan = mode
+			fmt.Printf("Secret scan set to: %s\n", mode)
+			return nil
+		})
+	},
+}
+
+var configSecretScanGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Get the secret scan mode",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			fmt.Println(secretScanMode(config))
+			return nil
+		})
+	},
+}
+
+func secretScanMode(config *environment.EnvironmentConfig) string {
+	if config.SecretScan == "" {
+		return environment.SecretScanWarn
+	}
+	return config.SecretScan
+}
+
 var configBaseImageCmd = &cobra.Command{
 	Use:   "base-image",
 	Short: "Manage base container image",
@@ -242,6 +1008,60 @@ var configBaseImageResetCmd = &cobra.Command{
 	},
 }
 
+var configKubernetesCmd = &cobra.Command{
+	Use:   "kubernetes",
+	Short: "Manage Kubernetes mode",
+	Long: `Run new environments in pods of a Kubernetes cluster instead of containers of the
+Dagger engine, for machines that can't run a container runtime. Pods are managed
+with kubectl: its configuration (KUBECONFIG) selects the cluster.`,
+}
+
+var configKubernetesEnableCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Run new environments in Kubernetes pods",
+	Example: `# Run environments in the current namespace of the current kubeconfig context
+container-use config kubernetes enable
+
+# Run environments in the agents namespace of the dev cluster
+container-use config kubernetes enable --context dev --namespace agents`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kubeContext, _ := cmd.Flags().GetString("context")
+		namespace, _ := cmd.Flags().GetString("namespace")
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.Kubernetes = &environment.KubernetesConfig{Context: kubeContext, Namespace: namespace}
+			if err := config.Validate(); err != nil {
+				return err
+			}
+			fmt.Printf("Environments run in Kubernetes: %s\n", kubernetesTarget(config.Kubernetes))
+			return nil
+		})
+	},
+}
+
+var configKubernetesDisableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Run new environments in containers again",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.Kubernetes = nil
+			fmt.Println("Environments run in containers")
+			return nil
+		})
+	},
+}
+
+// kubernetesTarget describes where Kubernetes environments run
+func kubernetesTarget(k *environment.KubernetesConfig) string {
+	kubeContext, namespace := k.Context, k.Namespace
+	if kubeContext == "" {
+		kubeContext = "(current)"
+	}
+	if namespace == "" {
+		namespace = "(default)"
+	}
+	return fmt.Sprintf("context %s, namespace %s", kubeContext, namespace)
+}
+
 // Setup command object commands
 var configSetupCommandCmd = &cobra.Command{
 	Use:   "setup-command",
@@ -562,6 +1382,16 @@ func init() {
 	configBaseImageCmd.AddCommand(configBaseImageGetCmd)
 	configBaseImageCmd.AddCommand(configBaseImageResetCmd)
 
+	// Add kubernetes commands
+	configKubernetesCmd.AddCommand(configKubernetesEnableCmd)
+	configKubernetesCmd.AddCommand(configKubernetesDisableCmd)
+	configKubernetesEnableCmd.Flags().String("context", "", "Kubeconfig context of the cluster (default: the current one)")
+	configKubernetesEnableCmd.Flags().String("namespace", "", "Namespace of the pods (default: the one of the context)")
+
+	// Add secret-scan commands
+	configSecretScanCmd.AddCommand(configSecretScanSetCmd)
+	configSecretScanCmd.AddCommand(configSecretScanGetCmd)
+
 	// Add setup-command commands
 	configSetupCommandCmd.AddCommand(configSetupCommandAddCmd)
 	configSetupCommandCmd.AddCommand(configSetupCommandRemoveCmd)
@@ -586,14 +1416,69 @@ func init() {
 	configSecretCmd.AddCommand(configSecretListCmd)
 	configSecretCmd.AddCommand(configSecretClearCmd)
 
+	// Add limit commands
+	configLimitCmd.AddCommand(configLimitSetCmd)
+	configLimitCmd.AddCommand(configLimitUnsetCmd)
+	configLimitCmd.AddCommand(configLimitListCmd)
+
+	// Add cache commands
+	configCacheCmd.AddCommand(configCacheSetCmd)
+	configCacheCmd.AddCommand(configCacheUnsetCmd)
+	configCacheCmd.AddCommand(configCacheListCmd)
+
+	// Add host-path commands
+	configHostPathCmd.AddCommand(configHostPathAllowCmd)
+	configHostPathCmd.AddCommand(configHostPathRemoveCmd)
+	configHostPathCmd.AddCommand(configHostPathListCmd)
+	configHostPathAllowCmd.Flags().Bool("write", false, "Allow writing the directory too")
+
+	// Add approval commands
+	configApprovalCmd.AddCommand(configApprovalAddCmd)
+	configApprovalCmd.AddCommand(configApprovalRemoveCmd)
+	configApprovalCmd.AddCommand(configApprovalListCmd)
+
+	// Add webhook commands
+	configWebhookCmd.AddCommand(configWebhookAddCmd)
+	configWebhookCmd.AddCommand(configWebhookRemoveCmd)
+	configWebhookCmd.AddCommand(configWebhookListCmd)
+	configWebhookAddCmd.Flags().String("secret", "", "Secret reference signing the callbacks (e.g. env://CU_WEBHOOK_SECRET)")
+	configWebhookAddCmd.Flags().StringSlice("event", nil, "Event to send: environment.created, environment.deleted or environment.checkpointed (default all)")
+
+	// Add license-header commands
+	configLicenseHeaderCmd.AddCommand(configLicenseHeaderAddCmd)
+	configLicenseHeaderCmd.AddCommand(configLicenseHeaderRemoveCmd)
+	configLicenseHeaderCmd.AddCommand(configLicenseHeaderListCmd)
+
+	// Add plan-secret commands
+	configPlanSecretCmd.AddCommand(configPlanSecretSetCmd)
+	configPlanSecretCmd.AddCommand(configPlanSecretUnsetCmd)
+	configPlanSecretCmd.AddCommand(configPlanSecretListCmd)
+	configPlanSecretCmd.AddCommand(configPlanSecretClearCmd)
+
+	configLintCmd.Flags().String("template", "", "Lint a template, with the configuration of the repository applied on top")
+	configLintCmd.Flags().Bool("no-resolve", false, "Don't check that images exist in their registry")
+	configLintCmd.Flags().Bool("json", false, "Display the issues in JSON")
+	_ = configLintCmd.RegisterFlagCompletionFunc("template", suggestTemplates)
+
 	// Add object commands to config
 	configCmd.AddCommand(configBaseImageCmd)
+	configCmd.AddCommand(configKubernetesCmd)
 	configCmd.AddCommand(configSetupCommandCmd)
 	configCmd.AddCommand(configInstallCommandCmd)
 	configCmd.AddCommand(configEnvCmd)
 	configCmd.AddCommand(configSecretCmd)
+	configCmd.AddCommand(configPlanSecretCmd)
+	configCmd.AddCommand(configLimitCmd)
+	configCmd.AddCommand(configCacheCmd)
+	configCmd.AddCommand(configHostPathCmd)
+	configCmd.AddCommand(configApprovalCmd)
+	configCmd.AddCommand(configSecretScanCmd)
+	configCmd.AddCommand(configLicenseHeaderCmd)
+	configCmd.AddCommand(configWebhookCmd)
 	configCmd.AddCommand(configShowCmd)
 	configCmd.AddCommand(configImportCmd)
+	configCmd.AddCommand(configAuditCmd)
+	configCmd.AddCommand(configLintCmd)
 
 	// Add agent command
 	configCmd.AddCommand(agent.AgentCmd)
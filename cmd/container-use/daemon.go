@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dagger/container-use/mcpserver"
+	"github.com/gofrs/flock"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const (
+	// daemonSocketEnvVar overrides the socket the daemon of the user listens on
+	daemonSocketEnvVar = "CONTAINER_USE_DAEMON_SOCKET"
+	// daemonStartTimeout is how long stdio front-ends wait for the daemon they start, connecting to dagger included
+	daemonStartTimeout = 2 * time.Minute
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Start the MCP daemon shared by the agents of the user",
+	Long: `Start the daemon serving the Model Context Protocol server to the stdio front-ends of
+the agents of the user (container-use stdio --daemon) on a unix socket. Agents share its
+dagger connection, locks and state: ten agent clients working on the same repository use
+one engine connection instead of ten.
+
+Front-ends start the daemon when it isn't running, so it rarely needs to be started by hand.
+Daemons are keyed by their server flags: they listen on the socket suffixed with a key of
+their configuration, and front-ends only connect to the daemon with their own. Only one
+daemon runs per socket, and it stops once no front-end has been connected for --idle-timeout.`,
+	Args: cobra.NoArgs,
+	RunE: func(app *cobra.Command, _ []string) error {
+		ctx := app.Context()
+		socket, _ := app.Flags().GetString("socket")
+		socket = configuredSocket(app, socket)
+		idleTimeout, _ := app.Flags().GetDuration("idle-timeout")
+		mcpserver.CommandTimeout, _ = app.Flags().GetDuration("command-timeout")
+		mcpserver.CloudRoles, _ = app.Flags().GetStringSlice("cloud-role")
+		mcpserver.ToolMetrics, _ = app.Flags().GetBool("tool-metrics")
+		mcpserver.ServerLimits = limitsFromFlags(app)
+
+		if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+			return err
+		}
+		lock := flock.New(socket + ".lock")
+		locked, err := lock.TryLock()
+		if err != nil {
+			return fmt.Errorf("failed to lock %s: %w", lock.Path(), err)
+		}
+		if !locked {
+			fmt.Fprintf(os.Stderr, "A daemon is already running on %s\n", socket)
+			return nil
+		}
+		defer lock.Unlock()
+
+		dag, err := connectDagger(ctx, logWriter)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		// Holding the lock, the socket can only be left behind by a daemon that crashed
+		if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		listener, err := net.Listen("unix", socket)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", socket, err)
+		}
+		defer listener.Close()
+		if err := os.Chmod(socket, 0600); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Serving MCP to stdio front-ends on %s\n", socket)
+		return mcpserver.RunDaemon(ctx, dag, listener, idleTimeout)
+	},
+}
+
+// defaultDaemonSocket is the socket of the daemon of the user, in a directory only they can access
+func defaultDaemonSocket() string {
+	if socket := os.Getenv(daemonSocketEnvVar); socket != "" {
+		return socket
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "container-use", "daemon.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("container-use-%d", os.Getuid()), "daemon.sock")
+}
+
+// addDaemonSocketFlag adds the flag of the socket of the daemon to a command
+func addDaemonSocketFlag(cmd *cobra.Command) {
+	cmd.Flags().String("socket", defaultDaemonSocket(), "Unix socket of the daemon (env: "+daemonSocketEnvVar+")")
+}
+
+// daemonRunning tells whether a daemon listens on the socket
+func daemonRunning(socket string) bool {
+	conn, err := net.DialTimeout("unix", socket, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// serverFlags are the flags of front-ends and daemons configuring the MCP server
+var serverFlags = []string{
+	"command-timeout",
+	"cloud-role",
+	"tool-metrics",
+	"max-environments",
+	"max-concurrent-commands",
+	"max-background-services",
+}
+
+// configuredSocket is the socket of the daemons with the configuration of the server flags of the command.
+// Front-ends only share a daemon with the same flags, whether set on the command line or by environment variables:
+// a daemon started by a front-end with other limits or cloud roles would silently enforce those instead of their own.
+func configuredSocket(cmd *cobra.Command, socket string) string {
+	h := sha256.New()
+	for _, name := range serverFlags {
+		if f := cmd.Flags().Lookup(name); f != nil {
+			fmt.Fprintf(h, "%s=%s\n", name, f.Value.String())
+		}
+	}
+	ext := filepath.Ext(socket)
+	return strings.TrimSuffix(socket, ext) + "-" + hex.EncodeToString(h.Sum(nil))[:12] + ext
+}
+
+// ensureDaemon starts the daemon with the configuration of the front-end unless it is running, passing it the server
+// flags set on the command line of the front-end, and waits for it to listen. It returns the socket of the daemon.
+func ensureDaemon(app *cobra.Command, socket string) (string, error) {
+	configured := configuredSocket(app, socket)
+	if daemonRunning(configured) {
+		return configured, nil
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command(executable, daemonArgs(app, socket)...)
+	detachDaemon(cmd)
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("failed to start the daemon: %w", err)
+	}
+	// Front-ends starting at the same time start a daemon each: all but one exit right away
+	go cmd.Wait()
+
+	ctx := app.Context()
+	deadline := time.Now().Add(daemonStartTimeout)
+	for !daemonRunning(configured) {
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("the daemon didn't start listening on %s within %s, see its logs", configured, daemonStartTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	return configured, nil
+}
+
+// daemonArgs are the arguments of the daemon started by a front-end: the server flags set on the command line of the
+// front-end are passed on, the others are set by the same environment variables
+func daemonArgs(app *cobra.Command, socket string) []string {
+	args := []string{"daemon", "--socket", socket}
+	app.Flags().Visit(func(f *pflag.Flag) {
+		if !slices.Contains(serverFlags, f.Name) {
+			return
+		}
+		if values, ok := f.Value.(pflag.SliceValue); ok {
+			for _, value := range values.GetSlice() {
+				args = append(args, "--"+f.Name+"="+value)
+			}
+			return
+		}
+		args = append(args, "--"+f.Name+"="+f.Value.String())
+	})
+	return args
+}
+
+func init() {
+	addDaemonSocketFlag(daemonCmd)
+	daemonCmd.Flags().Duration("idle-timeout", 10*time.Minute, "Time after which the daemon stops when no front-end is connected (0 to keep it running)")
+	daemonCmd.Flags().Duration("command-timeout", mcpserver.CommandTimeout, "Time after which commands are interrupted when agents don't set a timeout (0 for no limit)")
+	addCloudRoleFlag(daemonCmd)
+	addToolMetricsFlag(daemonCmd)
+	addLimitFlags(daemonCmd)
+
+	rootCmd.AddCommand(daemonCmd)
+}
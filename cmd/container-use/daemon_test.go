@@ -0,0 +1,56 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemonArgs(t *testing.T) {
+	cmd := &cobra.Command{Use: "stdio"}
+	cmd.Flags().Duration("command-timeout", 0, "")
+	cmd.Flags().StringSlice("cloud-role", nil, "")
+	cmd.Flags().Int("max-environments", 0, "")
+	cmd.Flags().Bool("daemon", false, "")
+	addDaemonSocketFlag(cmd)
+
+	require.NoError(t, cmd.ParseFlags([]string{"--daemon", "--socket", "/tmp/other.sock", "--command-timeout", "5m", "--cloud-role", "a", "--cloud-role", "b"}))
+	assert.Equal(t, []string{
+		"daemon", "--socket", "/tmp/cu.sock",
+		"--cloud-role=a", "--cloud-role=b",
+		"--command-timeout=5m0s",
+	}, daemonArgs(cmd, "/tmp/cu.sock"), "only the server flags set on the command line are passed on")
+}
+
+func TestConfiguredSocket(t *testing.T) {
+	newFrontend := func(args ...string) *cobra.Command {
+		cmd := &cobra.Command{Use: "stdio"}
+		cmd.Flags().Duration("command-timeout", 0, "")
+		addCloudRoleFlag(cmd)
+		addLimitFlags(cmd)
+		require.NoError(t, cmd.ParseFlags(args))
+		return cmd
+	}
+
+	socket := configuredSocket(newFrontend(), "/tmp/cu/daemon.sock")
+	assert.Regexp(t, `^/tmp/cu/daemon-[0-9a-f]{12}\.sock$`, socket)
+	assert.Equal(t, socket, configuredSocket(newFrontend("--command-timeout", "0s"), "/tmp/cu/daemon.sock"), "flags set to their value don't change the daemon")
+
+	limited := configuredSocket(newFrontend("--max-environments", "3"), "/tmp/cu/daemon.sock")
+	assert.NotEqual(t, socket, limited, "front-ends with other limits get their own daemon")
+	t.Setenv("CONTAINER_USE_MAX_ENVIRONMENTS", "3")
+	assert.Equal(t, limited, configuredSocket(newFrontend(), "/tmp/cu/daemon.sock"), "flags set by environment variables count too")
+	assert.NotEqual(t, limited, configuredSocket(newFrontend("--cloud-role", "a"), "/tmp/cu/daemon.sock"))
+}
+
+func TestDefaultDaemonSocket(t *testing.T) {
+	t.Setenv(daemonSocketEnvVar, "")
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	assert.Equal(t, filepath.Join("/run/user/1000", "container-use", "daemon.sock"), defaultDaemonSocket())
+
+	t.Setenv(daemonSocketEnvVar, "/tmp/cu.sock")
+	assert.Equal(t, "/tmp/cu.sock", defaultDaemonSocket())
+}
@@ -1,8 +1,6 @@
 package main
 
 import (
-	"os"
-
 	"github.com/dagger/container-use/repository"
 	"github.com/spf13/cobra"
 )
@@ -11,7 +9,10 @@ var diffCmd = &cobra.Command{
 	Use:   "diff [<env>]",
 	Short: "Show what files an agent changed",
 	Long: `Display the code changes made by an agent in an environment.
-Shows a git diff between the environment's state and your current branch.
+Shows a git diff between the environment's state and the branch it was
+forked from. Changes made on that branch since the fork are left out.
+
+In a terminal, the diff is highlighted and paged (see --no-color and --no-pager).
 
 If no environment is specified, automatically selects from environments 
 that are descendants of the current HEAD.`,
@@ -39,10 +40,17 @@ container-use diff`,
 			return err
 		}
 
-		return repo.Diff(ctx, envID, os.Stdout)
+		diff, err := repo.UnifiedDiff(ctx, envID, repository.DiffOpts{})
+		if err != nil {
+			return err
+		}
+
+		out := newStyledOutput(app)
+		return out.Close(writeDiff(out, diff, out.Color, out.Width))
 	},
 }
 
 func init() {
+	addOutputFlags(diffCmd)
 	rootCmd.AddCommand(diffCmd)
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ANSI styles of the diff
+const (
+	styleReset   = "\x1b[0m"
+	styleFile    = "\x1b[1;34m"
+	styleHunk    = "\x1b[36m"
+	styleAdded   = "\x1b[32m"
+	styleRemoved = "\x1b[31m"
+	styleMeta    = "\x1b[2m"
+)
+
+// writeDiff writes a unified git diff.
+// Without color, the diff is written as is so it can still be applied. With color, file headers are replaced
+// by the file name underlined across the terminal width, and changed lines are highlighted.
+func writeDiff(w io.Writer, diff string, color bool, width int) error {
+	if !color {
+		_, err := io.WriteString(w, diff)
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	rule := strings.Repeat("─", max(width, 1))
+	first := true
+	// inHeader is set between the start of a file and its first hunk
+	inHeader := false
+	scanner := bufio.NewScanner(strings.NewReader(diff))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			if !first {
+				bw.WriteString("\n")
+			}
+			first = false
+			inHeader = true
+			fmt.Fprintf(bw, "%s%s%s\n%s%s%s\n", styleFile, diffHeaderFile(line), styleReset, styleFile, rule, styleReset)
+		case inHeader && (strings.HasPrefix(line, "index ") || strings.HasPrefix(line, "--- ") || strings.HasPrefix(line, "+++ ")):
+			// Redundant with the file name
+		case strings.HasPrefix(line, "@@"):
+			inHeader = false
+			fmt.Fprintf(bw, "\n%s%s%s\n", styleHunk, line, styleReset)
+		case strings.HasPrefix(line, "+"):
+			fmt.Fprintf(bw, "%s%s%s\n", styleAdded, line, styleReset)
+		case strings.HasPrefix(line, "-"):
+			fmt.Fprintf(bw, "%s%s%s\n", styleRemoved, line, styleReset)
+		case strings.HasPrefix(line, " "), line == "":
+			fmt.Fprintf(bw, "%s\n", line)
+		default:
+			// new file mode, rename from, Binary files differ, \ No newline at end of file, ...
+			fmt.Fprintf(bw, "%s%s%s\n", styleMeta, line, styleReset)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// diffHeaderFile returns the file name of a "diff --git a/<old> b/<new>" line, as "old → new" for renames
+func diffHeaderFile(line string) string {
+	paths := strings.TrimPrefix(line, "diff --git ")
+	// Both paths have the same length unless the file was renamed
+	if half := len(paths) / 2; len(paths)%2 == 1 && paths[half] == ' ' {
+		oldPath, newPath := paths[:half], paths[half+1:]
+		if strings.TrimPrefix(oldPath, "a/") == strings.TrimPrefix(newPath, "b/") {
+			return strings.TrimPrefix(newPath, "b/")
+		}
+	}
+	oldPath, newPath, ok := strings.Cut(paths, " b/")
+	if !ok {
+		return paths
+	}
+	return strings.TrimPrefix(oldPath, "a/") + " → " + newPath
+}
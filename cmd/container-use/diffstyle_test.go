@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleDiff = `diff --git a/schema.sql b/schema.sql
+index 3b18e51..a9c2f4e 100644
+--- a/schema.sql
++++ b/schema.sql
+@@ -1,3 +1,3 @@ CREATE TABLE users
+ CREATE TABLE users (
+--- legacy column
++  email TEXT
+ );
+diff --git a/old.go b/new.go
+similarity index 90%
+rename from old.go
+rename to new.go
+`
+
+func TestWriteDiff(t *testing.T) {
+	var plain strings.Builder
+	require.NoError(t, writeDiff(&plain, sampleDiff, false, 80))
+	assert.Equal(t, sampleDiff, plain.String(), "the diff can still be applied without color")
+
+	var colored strings.Builder
+	require.NoError(t, writeDiff(&colored, sampleDiff, true, 10))
+	out := colored.String()
+	assert.Contains(t, out, styleFile+"schema.sql"+styleReset+"\n"+styleFile+strings.Repeat("─", 10)+styleReset)
+	assert.NotContains(t, out, "index 3b18e51")
+	assert.NotContains(t, out, "+++ b/schema.sql")
+	assert.Contains(t, out, styleHunk+"@@ -1,3 +1,3 @@ CREATE TABLE users"+styleReset)
+	assert.Contains(t, out, styleRemoved+"--- legacy column"+styleReset, "removed lines aren't mistaken for file headers")
+	assert.Contains(t, out, styleAdded+"+  email TEXT"+styleReset)
+	assert.Contains(t, out, "old.go → new.go")
+	assert.Contains(t, out, styleMeta+"rename from old.go"+styleReset)
+}
+
+func TestDiffHeaderFile(t *testing.T) {
+	tests := map[string]string{
+		"diff --git a/main.go b/main.go":            "main.go",
+		"diff --git a/a b/c/d b/a b/c/d":            "a b/c/d",
+		"diff --git a/cmd/old.go b/cmd/new_name.go": "cmd/old.go → cmd/new_name.go",
+	}
+	for line, expected := range tests {
+		assert.Equal(t, expected, diffHeaderFile(line), line)
+	}
+}
@@ -29,13 +29,37 @@ func isDockerDaemonError(err error) bool {
 		return true
 	}
 
+	// Podman: Cannot connect to Podman. Please verify your connection to the Linux system using `podman system connection list`, or try `podman machine init` and `podman machine start` to manage a new Linux VM
+	if strings.Contains(errStr, "cannot connect to podman") {
+		return true
+	}
+
 	// Generic fallbacks
 	return strings.Contains(errStr, "docker daemon") ||
 		strings.Contains(errStr, "docker.sock")
 }
 
-// handleDockerDaemonError prints a helpful error message for Docker daemon issues
-func handleDockerDaemonError() {
-	fmt.Fprintf(os.Stderr, "\nError: Docker daemon is not running.\n")
-	fmt.Fprintf(os.Stderr, "Please start Docker and try again.\n\n")
+// handleDockerDaemonError prints a helpful error message for Docker daemon issues, with the commands fixing them for
+// the detected setup
+func handleDockerDaemonError(setup *runtimeSetup) {
+	if setup == nil {
+		fmt.Fprintf(os.Stderr, "\nError: no container runtime found.\n")
+		fmt.Fprintf(os.Stderr, "Please install Docker, Podman, Colima or another container runtime and try again.\n\n")
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\nError: %s is not running", setup)
+	if setup.Socket != "" {
+		fmt.Fprintf(os.Stderr, " (%s)", setup.Socket)
+	}
+	fmt.Fprintf(os.Stderr, ".\nStart it and try again:\n\n")
+	for _, command := range setup.remediation() {
+		fmt.Fprintf(os.Stderr, "  %s\n", command)
+	}
+	if alternative := podmanAlternative(); !setup.isPodman() && len(alternative) > 0 {
+		fmt.Fprintf(os.Stderr, "\nOr use Podman, which doesn't need a daemon running as root:\n\n")
+		for _, command := range alternative {
+			fmt.Fprintf(os.Stderr, "  %s\n", command)
+		}
+	}
+	fmt.Fprintln(os.Stderr)
 }
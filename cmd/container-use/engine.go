@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// Range of Dagger engine versions supported by the SDK this binary is built with.
+// The SDK provisions an engine of its own version, but `dagger run` sessions and custom runners
+// may connect to any engine, whose API drifts between minor versions.
+const (
+	minEngineVersion = "v0.18.0"
+	// maxEngineVersion is the first unsupported version
+	maxEngineVersion = "v0.19.0"
+)
+
+var skipVersionCheck bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&skipVersionCheck, "skip-version-check", false, "Connect to Dagger engines outside of the supported version range")
+}
+
+// connectDagger connects to the Dagger engine and fails fast if its version isn't supported.
+// The container runtime is checked first, see prepareRuntime, unless the engine is a remote one, see remoteEngine.
+func connectDagger(ctx context.Context, logOutput io.Writer) (*dagger.Client, error) {
+	if engine.Host != "" {
+		if err := useRemoteEngine(ctx); err != nil {
+			return nil, err
+		}
+		dag, err := dagger.Connect(ctx, dagger.WithLogOutput(logOutput))
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to the dagger engine at %s: %w", engine.Host, err)
+		}
+		return checkEngine(ctx, dag)
+	}
+
+	setup := prepareRuntime(ctx)
+	dag, err := dagger.Connect(ctx, dagger.WithLogOutput(logOutput))
+	if err != nil {
+		if isDockerDaemonError(err) {
+			handleDockerDaemonError(setup)
+		} else if setup.isPodman() {
+			handlePodmanError(setup, err)
+		}
+		return nil, fmt.Errorf("failed to connect to dagger: %w", err)
+	}
+	return checkEngine(ctx, dag)
+}
+
+// checkEngine closes the client when the version of its engine isn't supported
+func checkEngine(ctx context.Context, dag *dagger.Client) (*dagger.Client, error) {
+	if skipVersionCheck {
+		return dag, nil
+	}
+
+	version, err := dag.Version(ctx)
+	if err != nil {
+		dag.Close()
+		return nil, fmt.Errorf("failed to get the dagger engine version: %w", err)
+	}
+	if err := checkEngineVersion(version); err != nil {
+		dag.Close()
+		return nil, err
+	}
+	return dag, nil
+}
+
+// checkEngineVersion returns an error telling how to get a supported engine if version is out of range.
+// Development engines without a release version are let through.
+func checkEngineVersion(version string) error {
+	current, ok := parseEngineVersion(version)
+	if !ok {
+		slog.Warn("Unknown dagger engine version, skipping version check", "version", version)
+		return nil
+	}
+	minimum, _ := parseEngineVersion(minEngineVersion)
+	maximum, _ := parseEngineVersion(maxEngineVersion)
+
+	if compareEngineVersions(current, minimum) < 0 {
+		return fmt.Errorf("dagger engine %s is too old: container-use %s requires an engine >= %s and < %s. "+
+			"Upgrade the dagger CLI and engine (https://docs.dagger.io/install/), or pass --skip-version-check to connect anyway",
+			version, currentVersion(), minEngineVersion, maxEngineVersion)
+	}
+	if compareEngineVersions(current, maximum) >= 0 {
+		return fmt.Errorf("dagger engine %s is too recent: container-use %s requires an engine >= %s and < %s. "+
+			"Upgrade container-use, or downgrade the dagger CLI and engine, or pass --skip-version-check to connect anyway",
+			version, currentVersion(), minEngineVersion, maxEngineVersion)
+	}
+	return nil
+}
+
+func currentVersion() string {
+	if version == "dev" && commit != "unknown" {
+		return fmt.Sprintf("%s (%s)", version, commit)
+	}
+	return version
+}
+
+// parseEngineVersion parses the major, minor and patch numbers of versions like v0.18.14,
+// ignoring pre-release and build suffixes
+func parseEngineVersion(version string) ([3]int, bool) {
+	var parsed [3]int
+	version, ok := strings.CutPrefix(version, "v")
+	if !ok {
+		return parsed, false
+	}
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return parsed, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return parsed, false
+		}
+		parsed[i] = n
+	}
+	return parsed, true
+}
+
+func compareEngineVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] - b[i]
+		}
+	}
+	return 0
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckEngineVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		err     string
+	}{
+		{version: "v0.18.0"},
+		{version: "v0.18.14"},
+		{version: "v0.18.15-250612-abcdef"},
+		{version: "v0.17.2", err: "too old"},
+		{version: "v0.19.0", err: "too recent"},
+		{version: "v1.0.0", err: "too recent"},
+		{version: "devel"},
+		{version: "v0.18"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			err := checkEngineVersion(tt.version)
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.err)
+			assert.ErrorContains(t, err, "--skip-version-check")
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Delete stale environments and reclaim disk space",
+	Long: `Delete the environments not updated for longer than --older-than, then reclaim
+what deleted environments left behind: orphan worktrees, and the git objects
+and notes of their branches.
+
+Without --older-than, no environment is deleted: only the leftovers are collected.`,
+	Args: cobra.NoArgs,
+	Example: `# See what would be collected
+container-use gc --older-than 30d --dry-run
+
+# Delete environments idle for a week, keeping the 5 most recent ones
+container-use gc --older-than 7d --keep 5`,
+	RunE: func(app *cobra.Command, _ []string) error {
+		ctx := app.Context()
+
+		olderThan, _ := app.Flags().GetString("older-than")
+		keep, _ := app.Flags().GetInt("keep")
+		dryRun, _ := app.Flags().GetBool("dry-run")
+
+		policy := repository.GCPolicy{
+			KeepLatest: keep,
+			DryRun:     dryRun,
+		}
+		if olderThan != "" {
+			maxAge, err := parseAge(olderThan)
+			if err != nil {
+				return err
+			}
+			policy.MaxAge = maxAge
+		}
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		result, err := repo.GC(ctx, policy, time.Now())
+		if result != nil {
+			verb := "Deleted"
+			if dryRun {
+				verb = "Would delete"
+			}
+			for _, id := range result.Environments {
+				fmt.Printf("%s environment %s\n", verb, id)
+			}
+			for _, worktree := range result.OrphanWorktrees {
+				fmt.Printf("%s orphan worktree %s\n", verb, worktree)
+			}
+			if len(result.Environments) == 0 && len(result.OrphanWorktrees) == 0 {
+				fmt.Println("Nothing to collect.")
+			}
+		}
+		return err
+	},
+}
+
+// parseAge parses durations, with support for days (e.g. 30d)
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid age %q: expected a number of days like 30d, or a duration like 12h", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid age %q: expected a number of days like 30d, or a duration like 12h", s)
+	}
+	return d, nil
+}
+
+func init() {
+	gcCmd.Flags().String("older-than", "", "Delete environments not updated for longer than this age (e.g. 30d, 12h)")
+	gcCmd.Flags().Int("keep", 0, "Keep the given number of most recently updated environments whatever their age")
+	gcCmd.Flags().Bool("dry-run", false, "Show what would be collected without deleting anything")
+
+	rootCmd.AddCommand(gcCmd)
+}
@@ -33,6 +33,9 @@ var inspectCmd = &cobra.Command{
 		}
 
 		envInfo.State.Container = ""
+		for i := range envInfo.State.BackgroundServices {
+			envInfo.State.BackgroundServices[i].Container = ""
+		}
 		out, err := json.MarshalIndent(envInfo, "", "  ")
 		if err != nil {
 			return err
@@ -1,20 +1,34 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"text/tabwriter"
+	"time"
 
 	"github.com/dagger/container-use/repository"
-	"github.com/dustin/go-humanize"
 	"github.com/spf13/cobra"
 )
 
+// listEntry is an environment in the JSON output of list
+type listEntry struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+	// Staleness is set with --stale
+	Staleness *repository.Staleness `json:"staleness,omitempty"`
+}
+
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all environments",
 	Long: `Display all active environments with their IDs, titles, and timestamps.
-Use -q for environment IDs only, useful for scripting.`,
+Timestamps are relative to now, or dates in your local timezone once older than a week.
+Use -q for environment IDs only, or --json for RFC3339 timestamps, useful for scripting.
+Use --stale to show how many commits the default branch moved on since each environment
+was forked, and flag the environments stale once it is 20 commits ahead.`,
 	RunE: func(app *cobra.Command, _ []string) error {
 		ctx := app.Context()
 		repo, err := repository.Open(ctx, ".")
@@ -31,13 +45,62 @@ Use -q for environment IDs only, useful for scripting.`,
 			}
 			return nil
 		}
+		stale, _ := app.Flags().GetBool("stale")
+		staleness := map[string]*repository.Staleness{}
+		if stale {
+			for _, envInfo := range envInfos {
+				s, err := repo.Staleness(ctx, envInfo)
+				if err != nil {
+					return err
+				}
+				staleness[envInfo.ID] = s
+			}
+		}
 
+		if asJSON, _ := app.Flags().GetBool("json"); asJSON {
+			entries := []listEntry{}
+			for _, envInfo := range envInfos {
+				entries = append(entries, listEntry{
+					ID:        envInfo.ID,
+					Title:     envInfo.State.Title,
+					CreatedAt: rfc3339(envInfo.State.CreatedAt),
+					UpdatedAt: rfc3339(envInfo.State.UpdatedAt),
+					Staleness: staleness[envInfo.ID],
+				})
+			}
+			out, err := json.MarshalIndent(entries, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+			return nil
+		}
+
+		now := time.Now()
 		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-		fmt.Fprintln(tw, "ID\tTITLE\tCREATED\tUPDATED")
+		if !stale {
+			fmt.Fprintln(tw, "ID\tTITLE\tCREATED\tUPDATED")
+			defer tw.Flush()
+			for _, envInfo := range envInfos {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", envInfo.ID, truncate(app, envInfo.State.Title, 40), formatTime(envInfo.State.CreatedAt, now), formatTime(envInfo.State.UpdatedAt, now))
+			}
+			return nil
+		}
 
-		defer tw.Flush()
+		fmt.Fprintln(tw, "ID\tTITLE\tCREATED\tUPDATED\tBEHIND")
+		staleCount := 0
 		for _, envInfo := range envInfos {
-			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", envInfo.ID, truncate(app, envInfo.State.Title, 40), humanize.Time(envInfo.State.CreatedAt), humanize.Time(envInfo.State.UpdatedAt))
+			s := staleness[envInfo.ID]
+			behind := fmt.Sprint(s.Behind)
+			if s.Stale {
+				behind = fmt.Sprintf("stale by %d commits", s.Behind)
+				staleCount++
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", envInfo.ID, truncate(app, envInfo.State.Title, 40), formatTime(envInfo.State.CreatedAt, now), formatTime(envInfo.State.UpdatedAt, now), behind)
+		}
+		tw.Flush()
+		if staleCount > 0 {
+			fmt.Fprintf(os.Stderr, "\n%d stale environments: review them, then bring them up to date with `container-use merge --strategy rebase <env>` or delete them with `container-use delete <env>`\n", staleCount)
 		}
 		return nil
 	},
@@ -56,5 +119,7 @@ func truncate(app *cobra.Command, s string, max int) string {
 func init() {
 	listCmd.Flags().BoolP("quiet", "q", false, "Display only environment IDs")
 	listCmd.Flags().BoolP("no-trunc", "", false, "Don't truncate output")
+	listCmd.Flags().Bool("json", false, "Display environments in JSON")
+	listCmd.Flags().Bool("stale", false, "Show how far behind the default branch environments are")
 	rootCmd.AddCommand(listCmd)
 }
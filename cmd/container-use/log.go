@@ -1,18 +1,30 @@
 package main
 
 import (
-	"os"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
 
 	"github.com/dagger/container-use/repository"
 	"github.com/spf13/cobra"
 )
 
+// logFollowInterval is how often --follow checks for new activity
+const logFollowInterval = time.Second
+
 var logCmd = &cobra.Command{
 	Use:   "log [<env>]",
 	Short: "View what an agent did step-by-step",
 	Long: `Display the complete development history for an environment.
 Shows all commits made by the agent plus command execution notes.
 Use -p to include code patches in the output.
+Use --json for the commands, file operations, configuration changes and snapshots,
+one JSON object per line, and --follow to keep printing them as the agent works.
+In a terminal, the log is colored and paged (see --no-color and --no-pager).
 
 If no environment is specified, automatically selects from environments 
 that are descendants of the current HEAD.`,
@@ -24,6 +36,12 @@ container-use log fancy-mallard
 # Include code changes
 container-use log fancy-mallard -p
 
+# Watch what the agent does, until Ctrl+C
+container-use log fancy-mallard --follow
+
+# Activity as JSON lines, for scripts
+container-use log fancy-mallard --json
+
 # Auto-select environment
 container-use log`,
 	RunE: func(app *cobra.Command, args []string) error {
@@ -41,12 +59,139 @@ container-use log`,
 		}
 
 		patch, _ := app.Flags().GetBool("patch")
+		follow, _ := app.Flags().GetBool("follow")
+		asJSON, _ := app.Flags().GetBool("json")
+		if follow || asJSON {
+			if patch {
+				return errors.New("--patch can't be used with --follow or --json")
+			}
+			// The activity is printed as it comes, which a pager would hold back
+			app.Flags().Set("no-pager", "true")
+			out := newStyledOutput(app)
+			return out.Close(followActivity(ctx, repo, envID, out, asJSON, follow))
+		}
 
-		return repo.Log(ctx, envID, patch, os.Stdout)
+		out := newStyledOutput(app)
+		if err := repo.Log(ctx, envID, repository.LogOpts{Patch: patch, Color: out.Color}, out); err != nil {
+			return out.Close(err)
+		}
+
+		// Surface review comments left with `container-use review` or environment_review_comment
+		envInfo, err := repo.Info(ctx, envID)
+		if err != nil {
+			return out.Close(err)
+		}
+		if len(envInfo.State.ReviewComments) > 0 {
+			fmt.Fprintf(out, "\nReview comments (%d):\n", len(envInfo.State.ReviewComments))
+			writeReviewComments(out, envInfo.State.ReviewComments)
+		}
+		return out.Close(nil)
 	},
 }
 
 func init() {
+	addOutputFlags(logCmd)
 	logCmd.Flags().BoolP("patch", "p", false, "Generate patch")
+	logCmd.Flags().BoolP("follow", "f", false, "Keep printing new activity as the agent works")
+	logCmd.Flags().Bool("json", false, "Display the activity in JSON, one object per line")
 	rootCmd.AddCommand(logCmd)
 }
+
+// followActivity prints the activity of an environment, oldest first. With follow, it then prints new activity
+// until the context is cancelled.
+func followActivity(ctx context.Context, repo *repository.Repository, envID string, out *styledOutput, asJSON, follow bool) error {
+	seen := map[string]bool{}
+	print := func() error {
+		entries, err := loadActivity(ctx, repo, envID)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			key := activityKey(entry)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if err := writeActivity(out, entry, asJSON, out.Color); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := print(); err != nil || !follow {
+		return err
+	}
+	ticker := time.NewTicker(logFollowInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := print(); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+}
+
+// loadActivity returns all the activity of an environment, oldest first
+func loadActivity(ctx context.Context, repo *repository.Repository, envID string) ([]repository.ActivityEntry, error) {
+	var entries []repository.ActivityEntry
+	// 500 is the largest page
+	opts := repository.ActivityOpts{Limit: 500}
+	for {
+		page, err := repo.Activity(ctx, envID, opts)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, page.Entries...)
+		if page.NextOffset == 0 {
+			break
+		}
+		opts.Offset = page.NextOffset
+	}
+	slices.Reverse(entries)
+	return entries, nil
+}
+
+// activityKey identifies an activity across reloads
+func activityKey(entry repository.ActivityEntry) string {
+	return fmt.Sprintf("%s %s %s %s", entry.Commit, entry.Time.Format(time.RFC3339Nano), entry.Kind, entry.Summary)
+}
+
+// writeActivity prints an activity as a line of JSON, or as a line for people to read
+func writeActivity(w io.Writer, entry repository.ActivityEntry, asJSON, color bool) error {
+	if asJSON {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", line)
+		return err
+	}
+
+	commit := entry.Commit
+	if len(commit) > 7 {
+		commit = commit[:7]
+	}
+	timestamp := entry.Time.Local().Format("2006-01-02 15:04:05")
+	kind := fmt.Sprintf("%-8s", entry.Kind)
+	exit := ""
+	if entry.ExitCode != nil && *entry.ExitCode != 0 {
+		exit = fmt.Sprintf(" (exit code %d)", *entry.ExitCode)
+	}
+	if color {
+		commit = styleMeta + commit + styleReset
+		kind = styleHunk + kind + styleReset
+		if exit != "" {
+			exit = styleRemoved + exit + styleReset
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s  %s  %s %s%s\n", commit, timestamp, kind, entry.Summary, exit)
+	return err
+}
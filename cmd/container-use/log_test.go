@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteActivity(t *testing.T) {
+	exitCode := 1
+	entry := repository.ActivityEntry{
+		Activity: environment.Activity{
+			Kind:     environment.ActivityCommand,
+			Time:     time.Date(2025, 7, 15, 12, 0, 0, 0, time.Local),
+			Summary:  "$ go test ./...",
+			ExitCode: &exitCode,
+		},
+		Commit: "0123456789abcdef",
+	}
+
+	var out strings.Builder
+	require.NoError(t, writeActivity(&out, entry, false, false))
+	assert.Equal(t, "0123456  2025-07-15 12:00:00  command  $ go test ./... (exit code 1)\n", out.String())
+
+	out.Reset()
+	require.NoError(t, writeActivity(&out, entry, true, false))
+	assert.JSONEq(t, `{"kind":"command","time":"`+entry.Time.Format(time.RFC3339)+`","summary":"$ go test ./...","exit_code":1,"commit":"0123456789abcdef"}`, out.String())
+	assert.True(t, strings.HasSuffix(out.String(), "}\n"))
+
+	assert.NotEqual(t, activityKey(entry), activityKey(repository.ActivityEntry{Activity: entry.Activity, Commit: "fedcba"}))
+}
@@ -7,10 +7,21 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/dagger/container-use/mcpserver"
+	"github.com/spf13/cobra"
 )
 
 var (
 	logWriter = io.Discard
+	logLevel  = slog.LevelInfo
+	logFormat string
+)
+
+// Formats of the logs
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
 )
 
 func parseLogLevel(levelStr string) slog.Level {
@@ -46,12 +57,38 @@ func setupLogger() error {
 		fmt.Fprintf(os.Stderr, "%s Logging disabled. Set CONTAINER_USE_STDERR_FILE and CONTAINER_USE_LOG_LEVEL environment variables\n", time.Now().Format(time.DateTime))
 	}
 
-	logLevel := parseLogLevel(os.Getenv("CONTAINER_USE_LOG_LEVEL"))
+	logLevel = parseLogLevel(os.Getenv("CONTAINER_USE_LOG_LEVEL"))
 	logWriter = io.MultiWriter(writers...)
-	handler := slog.NewTextHandler(logWriter, &slog.HandlerOptions{
-		Level: logLevel,
-	})
-	slog.SetDefault(slog.New(handler))
+	return setLogFormat(logFormatText)
+}
 
+// setLogFormat logs records as text or as JSON objects, one per line, tagged with the tool call they were logged in
+func setLogFormat(format string) error {
+	opts := &slog.HandlerOptions{
+		Level: logLevel,
+	}
+	var handler slog.Handler
+	switch format {
+	case logFormatText:
+		handler = slog.NewTextHandler(logWriter, opts)
+	case logFormatJSON:
+		handler = slog.NewJSONHandler(logWriter, opts)
+	default:
+		return fmt.Errorf("unknown log format %q: expected text or json", format)
+	}
+	slog.SetDefault(slog.New(mcpserver.LogHandler(handler)))
 	return nil
 }
+
+func init() {
+	defaultFormat := os.Getenv("CONTAINER_USE_LOG_FORMAT")
+	if defaultFormat == "" {
+		defaultFormat = logFormatText
+	}
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", defaultFormat, "Format of the logs: text or json (env: CONTAINER_USE_LOG_FORMAT)")
+	cobra.OnInitialize(func() {
+		if err := setLogFormat(logFormat); err != nil {
+			slog.Warn("Ignoring log format", "err", err)
+		}
+	})
+}
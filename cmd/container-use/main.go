@@ -32,7 +32,8 @@ func main() {
 	// FIXME(aluzzardi): `fang` misbehaves with the `stdio` command.
 	// It hangs on Ctrl-C. Traced the hang back to `lipgloss.HasDarkBackground(os.Stdin, os.Stdout)`
 	// I'm assuming it's not playing nice the mcpserver listening on stdio.
-	if len(os.Args) > 1 && os.Args[1] == "stdio" {
+	// The daemon started by stdio front-ends has no terminal either.
+	if len(os.Args) > 1 && (os.Args[1] == "stdio" || os.Args[1] == "daemon") {
 		if err := rootCmd.ExecuteContext(ctx); err != nil {
 			os.Exit(1)
 		}
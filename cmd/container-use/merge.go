@@ -10,7 +10,9 @@ import (
 )
 
 var (
-	mergeDelete bool
+	mergeDelete    bool
+	mergeStrategy  string
+	mergePatchFile string
 )
 
 var mergeCmd = &cobra.Command{
@@ -20,6 +22,12 @@ var mergeCmd = &cobra.Command{
 This makes the agent's work permanent in your repository.
 Your working directory will be automatically stashed and restored.
 
+Strategies:
+  merge   Merge commit preserving the environment's history (default)
+  squash  Stage all changes for you to commit, like 'apply'
+  rebase  Replay the environment's commits on top of your branch
+  patch   Write the changes as a patch series for 'git am', without touching your branch
+
 If no environment is specified, automatically selects from environments 
 that are descendants of the current HEAD.`,
 	Args:              cobra.MaximumNArgs(1),
@@ -31,6 +39,12 @@ container-use merge backend-api
 container-use merge -d backend-api
 container-use merge --delete backend-api
 
+# Keep a linear history
+container-use merge --strategy rebase backend-api
+
+# Export the changes for review
+container-use merge --strategy patch --patch-file backend-api.patch backend-api
+
 # Auto-select environment
 container-use merge`,
 	RunE: func(app *cobra.Command, args []string) error {
@@ -47,7 +61,19 @@ container-use merge`,
 			return err
 		}
 
-		if err := repo.Merge(ctx, envID, os.Stdout); err != nil {
+		switch mergeStrategy {
+		case "merge":
+			err = repo.Merge(ctx, envID, os.Stdout)
+		case "squash":
+			err = repo.Apply(ctx, envID, os.Stdout)
+		case "rebase":
+			err = repo.Rebase(ctx, envID, os.Stdout)
+		case "patch":
+			return writePatch(ctx, repo, envID, mergePatchFile)
+		default:
+			return fmt.Errorf("unknown merge strategy %q: expected merge, squash, rebase or patch", mergeStrategy)
+		}
+		if err != nil {
 			return fmt.Errorf("failed to merge environment: %w", err)
 		}
 
@@ -55,6 +81,25 @@ container-use merge`,
 	},
 }
 
+func writePatch(ctx context.Context, repo *repository.Repository, envID, path string) error {
+	if path == "" {
+		return repo.Patch(ctx, envID, os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := repo.Patch(ctx, envID, f); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write patch: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Patch written to %s, apply it with: git am %s\n", path, path)
+	return nil
+}
+
 func deleteAfterMerge(ctx context.Context, repo *repository.Repository, env string, delete bool, verb string) error {
 	if !delete {
 		fmt.Printf("Environment '%s' %s successfully.\n", env, verb)
@@ -69,6 +114,8 @@ func deleteAfterMerge(ctx context.Context, repo *repository.Repository, env stri
 
 func init() {
 	mergeCmd.Flags().BoolVarP(&mergeDelete, "delete", "d", false, "Delete the environment after successful merge")
+	mergeCmd.Flags().StringVar(&mergeStrategy, "strategy", "merge", "How to bring the changes in: merge, squash, rebase or patch")
+	mergeCmd.Flags().StringVar(&mergePatchFile, "patch-file", "", "File to write the patch to with --strategy patch (default: stdout)")
 
 	rootCmd.AddCommand(mergeCmd)
 }
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/spf13/cobra"
+)
+
+var offlineMode bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&offlineMode, "offline", os.Getenv("CONTAINER_USE_OFFLINE") == "1", "Refuse operations requiring network access, such as image pulls and publishes (env: CONTAINER_USE_OFFLINE=1)")
+	cobra.OnInitialize(func() {
+		environment.SetOffline(offlineMode)
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+const defaultWidth = 80
+
+// styledOutput is where commands write long output meant to be read in a terminal.
+// When stdout is a terminal, output goes through a pager and is colored, unless disabled.
+type styledOutput struct {
+	io.Writer
+	// Color reports whether to use ANSI colors
+	Color bool
+	// Width is the width of the terminal
+	Width int
+
+	pager      *exec.Cmd
+	pagerStdin io.WriteCloser
+	// pagerDone is closed once the pager exits
+	pagerDone chan struct{}
+	closed    bool
+}
+
+// addOutputFlags adds the flags controlling styled output to a command
+func addOutputFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("no-color", false, "Disable colored output (also disabled by NO_COLOR)")
+	cmd.Flags().Bool("no-pager", false, "Don't pipe output into a pager")
+}
+
+// newStyledOutput sets up the output of a command. Callers must call Close once done writing.
+// The pager is $CONTAINER_USE_PAGER, $PAGER or less, like git: an empty value or cat disables it.
+func newStyledOutput(app *cobra.Command) *styledOutput {
+	out := &styledOutput{Writer: os.Stdout, Width: defaultWidth}
+
+	tty := term.IsTerminal(int(os.Stdout.Fd()))
+	if tty {
+		if width, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && width > 0 {
+			out.Width = width
+		}
+	} else if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		out.Width = columns
+	}
+
+	noColor, _ := app.Flags().GetBool("no-color")
+	_, noColorEnv := os.LookupEnv("NO_COLOR")
+	out.Color = tty && !noColor && !noColorEnv
+
+	noPager, _ := app.Flags().GetBool("no-pager")
+	if tty && !noPager && runtime.GOOS != "windows" {
+		out.startPager()
+	}
+	return out
+}
+
+func pagerCommand() string {
+	if pager, ok := os.LookupEnv("CONTAINER_USE_PAGER"); ok {
+		return pager
+	}
+	if pager, ok := os.LookupEnv("PAGER"); ok {
+		return pager
+	}
+	return "less"
+}
+
+func (out *styledOutput) startPager() {
+	command := pagerCommand()
+	if command == "" || command == "cat" {
+		return
+	}
+	pager := exec.Command("sh", "-c", command)
+	pager.Stdout = os.Stdout
+	pager.Stderr = os.Stderr
+	pager.Env = os.Environ()
+	if _, ok := os.LookupEnv("LESS"); !ok {
+		// Quit if the output fits on screen, keep colors and leave the output on screen, like git
+		pager.Env = append(pager.Env, "LESS=FRX")
+	}
+	stdin, err := pager.StdinPipe()
+	if err != nil {
+		return
+	}
+	if err := pager.Start(); err != nil {
+		// Fall back to stdout when the pager is missing
+		return
+	}
+
+	out.pager = pager
+	out.pagerStdin = stdin
+	out.pagerDone = make(chan struct{})
+	out.Writer = stdin
+	go func() {
+		pager.Wait()
+		close(out.pagerDone)
+	}()
+}
+
+// Close waits for the user to quit the pager.
+// err is the error of the command writing the output: it is dropped if the user quit the pager
+// before the output was complete, as writing to the closed pager failed.
+func (out *styledOutput) Close(err error) error {
+	if out.pager == nil || out.closed {
+		return err
+	}
+	out.closed = true
+
+	quitEarly := false
+	select {
+	case <-out.pagerDone:
+		quitEarly = true
+	default:
+	}
+	out.pagerStdin.Close()
+	<-out.pagerDone
+	if quitEarly {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var rootlessPodmanSocketRe = regexp.MustCompile(`^/run/user/\d+/podman/podman\.sock$`)
+
+// rootfulPodmanSocket is the Docker API socket of the system Podman service
+const rootfulPodmanSocket = "/run/podman/podman.sock"
+
+// xdgRuntimeDir is the directory of the sockets of the services of the user, where rootless runtimes listen
+func xdgRuntimeDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return dir
+	}
+	return fmt.Sprintf("/run/user/%d", os.Getuid())
+}
+
+// isRootlessPodmanSocket tells whether the socket is served by the Podman service of a user rather than the system one
+func isRootlessPodmanSocket(socket string) bool {
+	return rootlessPodmanSocketRe.MatchString(socket) || socket == filepath.Join(xdgRuntimeDir(), "podman", "podman.sock")
+}
+
+// podmanSocket is the Docker API socket of the Podman service of the user, the system one for root
+func podmanSocket() string {
+	if os.Getuid() == 0 {
+		return rootfulPodmanSocket
+	}
+	return filepath.Join(xdgRuntimeDir(), "podman", "podman.sock")
+}
+
+// podmanAlternative returns the commands serving the Docker API with Podman instead, when it's installed
+func podmanAlternative() []string {
+	if _, err := exec.LookPath("podman"); err != nil {
+		return nil
+	}
+	return (&runtimeSetup{Name: localPodmanSetup().Name, Socket: podmanSocket()}).remediation()
+}
+
+// localPodmanSetup is the setup of the Podman CLI running containers on this machine, rootless unless run by root.
+// Dagger runs the podman CLI, which doesn't need the API socket.
+func localPodmanSetup() *runtimeSetup {
+	if os.Getuid() == 0 {
+		return &runtimeSetup{Name: setupPodman}
+	}
+	return &runtimeSetup{Name: setupPodmanRootless}
+}
+
+// isPodmanShim tells whether the docker CLI is Podman emulating it, e.g. the podman-docker package
+func isPodmanShim(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "docker", "--version").Output()
+	return err == nil && strings.Contains(strings.ToLower(string(out)), "podman")
+}
+
+// isPodman tells whether the setup runs containers with Podman
+func (s *runtimeSetup) isPodman() bool {
+	return s != nil && (s.Name == setupPodman || s.Name == setupPodmanRootless || s.Name == setupPodmanMachine)
+}
+
+// rootlessPodmanHints returns how to fix what keeps rootless Podman from running the Dagger engine, which needs a
+// privileged container, from the error of the connection
+func rootlessPodmanHints(err error) []string {
+	errStr := strings.ToLower(err.Error())
+	hints := []string{}
+	if strings.Contains(errStr, "iptables") || strings.Contains(errStr, "iptable_nat") {
+		hints = append(hints, "Load the NAT kernel module the engine network needs: sudo modprobe iptable_nat")
+	}
+	if strings.Contains(errStr, "newuidmap") || strings.Contains(errStr, "subuid") || strings.Contains(errStr, "subgid") ||
+		strings.Contains(errStr, "user namespace") {
+		hints = append(hints, "Give your user subordinate IDs, then reset Podman: sudo usermod --add-subuids 100000-165535 --add-subgids 100000-165535 $USER && podman system migrate")
+	}
+	if strings.Contains(errStr, "cgroup") {
+		hints = append(hints, "Rootless Podman needs cgroups v2 with its controllers delegated to your user: check that podman info --format '{{.Host.CgroupsVersion}}' prints v2")
+	}
+	if strings.Contains(errStr, "permission denied") || strings.Contains(errStr, "operation not permitted") {
+		hints = append(hints, "Check that podman run --rm --privileged alpine true succeeds: the engine runs in a privileged container")
+	}
+	return hints
+}
+
+// handlePodmanError prints how to get Podman to run the Dagger engine, when it failed for another reason than Podman
+// not running
+func handlePodmanError(setup *runtimeSetup, err error) {
+	if setup.Name != setupPodmanRootless {
+		return
+	}
+	hints := rootlessPodmanHints(err)
+	if len(hints) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\nError: the Dagger engine failed to start with %s. To fix it:\n\n", setup)
+	for _, hint := range hints {
+		fmt.Fprintf(os.Stderr, "  %s\n", hint)
+	}
+	fmt.Fprintf(os.Stderr, "\nOr connect to an engine running elsewhere with --engine.\n\n")
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRootlessPodmanSocket(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", "/tmp/runtime-me")
+
+	assert.True(t, isRootlessPodmanSocket("/run/user/1000/podman/podman.sock"))
+	assert.True(t, isRootlessPodmanSocket("/tmp/runtime-me/podman/podman.sock"), "the socket is in the runtime directory of the user")
+	assert.False(t, isRootlessPodmanSocket(rootfulPodmanSocket))
+	assert.Equal(t, setupPodmanRootless, classifyDockerHost("unix:///tmp/runtime-me/podman/podman.sock").Name)
+}
+
+func TestRootlessPodmanHints(t *testing.T) {
+	hints := rootlessPodmanHints(errors.New("netavark: code: 3, msg: iptables v1.8.9 (legacy): can't initialize iptables table `nat': Table does not exist"))
+	assert.Len(t, hints, 1)
+	assert.Contains(t, hints[0], "modprobe iptable_nat")
+
+	hints = rootlessPodmanHints(errors.New("cannot find UID/GID for user me: no subuid ranges found for user \"me\" in /etc/subuid"))
+	assert.Len(t, hints, 1)
+	assert.Contains(t, hints[0], "podman system migrate")
+
+	hints = rootlessPodmanHints(errors.New("crun: opening file `memory.max` for writing: Permission denied: OCI permission denied"))
+	assert.Len(t, hints, 1)
+	assert.Contains(t, hints[0], "--privileged")
+
+	assert.Empty(t, rootlessPodmanHints(errors.New("context deadline exceeded")))
+}
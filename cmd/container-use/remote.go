@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// daggerRunnerHostEnvVar tells the dagger CLI running the session of the SDK which engine to connect to, instead of
+// provisioning one in the local container runtime
+const daggerRunnerHostEnvVar = "_EXPERIMENTAL_DAGGER_RUNNER_HOST"
+
+// remoteEngine is a Dagger engine shared by several machines, e.g. a build server laptops delegate builds to
+type remoteEngine struct {
+	// Host is the address of the engine, e.g. tcp://builds.example.com:1234, or any runner host Dagger supports
+	Host string
+	// CA verifies the certificate of the engine, and Cert and Key authenticate the client to it.
+	// With any of them, the engine is reached over TLS: Host must be a tcp:// address.
+	CA   string
+	Cert string
+	Key  string
+}
+
+var engine remoteEngine
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&engine.Host, "engine", os.Getenv("CONTAINER_USE_ENGINE"), "Remote Dagger engine to connect to instead of the local container runtime, e.g. tcp://builds.example.com:1234 (env: CONTAINER_USE_ENGINE)")
+	rootCmd.PersistentFlags().StringVar(&engine.CA, "engine-tls-ca", os.Getenv("CONTAINER_USE_ENGINE_TLS_CA"), "CA certificate verifying the remote engine, to reach it over TLS (env: CONTAINER_USE_ENGINE_TLS_CA)")
+	rootCmd.PersistentFlags().StringVar(&engine.Cert, "engine-tls-cert", os.Getenv("CONTAINER_USE_ENGINE_TLS_CERT"), "Client certificate authenticating to the remote engine (env: CONTAINER_USE_ENGINE_TLS_CERT)")
+	rootCmd.PersistentFlags().StringVar(&engine.Key, "engine-tls-key", os.Getenv("CONTAINER_USE_ENGINE_TLS_KEY"), "Key of the client certificate (env: CONTAINER_USE_ENGINE_TLS_KEY)")
+	cobra.OnInitialize(func() {
+		// Processes started by this one, such as the daemon, connect to the same engine
+		for name, value := range map[string]string{
+			"CONTAINER_USE_ENGINE":          engine.Host,
+			"CONTAINER_USE_ENGINE_TLS_CA":   engine.CA,
+			"CONTAINER_USE_ENGINE_TLS_CERT": engine.Cert,
+			"CONTAINER_USE_ENGINE_TLS_KEY":  engine.Key,
+		} {
+			if value != "" {
+				os.Setenv(name, value)
+			}
+		}
+	})
+}
+
+func (e *remoteEngine) usesTLS() bool {
+	return e.CA != "" || e.Cert != "" || e.Key != ""
+}
+
+// tlsConfig is the configuration of the TLS connections to the engine at the host
+func (e *remoteEngine) tlsConfig(host string) (*tls.Config, error) {
+	config := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	if e.CA != "" {
+		pem, err := os.ReadFile(e.CA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the engine CA certificate: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in the engine CA certificate %s", e.CA)
+		}
+	}
+	if (e.Cert == "") != (e.Key == "") {
+		return nil, errors.New("the engine client certificate and key must be set together")
+	}
+	if e.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(e.Cert, e.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the engine client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// runnerHost returns the runner host the dagger CLI connects to. The dagger CLI doesn't speak TLS to tcp:// engines:
+// they are then reached through a proxy on the loopback interface, open until ctx is done, wrapping the connections
+// in TLS.
+func (e *remoteEngine) runnerHost(ctx context.Context) (string, error) {
+	if !e.usesTLS() {
+		return e.Host, nil
+	}
+	u, err := url.Parse(e.Host)
+	if err != nil || u.Scheme != "tcp" || u.Host == "" {
+		return "", fmt.Errorf("invalid engine %q: TLS requires a tcp://host:port address", e.Host)
+	}
+	config, err := e.tlsConfig(u.Hostname())
+	if err != nil {
+		return "", err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("failed to start the engine TLS proxy: %w", err)
+	}
+	context.AfterFunc(ctx, func() { listener.Close() })
+	dialer := &tls.Dialer{NetDialer: &net.Dialer{Timeout: 30 * time.Second}, Config: config}
+	go proxyTLS(ctx, listener, dialer, u.Host)
+	return "tcp://" + listener.Addr().String(), nil
+}
+
+// proxyTLS relays the connections accepted by the listener to the address, over TLS
+func proxyTLS(ctx context.Context, listener net.Listener, dialer *tls.Dialer, address string) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			remote, err := dialer.DialContext(ctx, "tcp", address)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to connect to the remote engine", "address", address, "err", err)
+				return
+			}
+			defer remote.Close()
+			done := make(chan struct{}, 2)
+			relay := func(dst, src net.Conn) {
+				io.Copy(dst, src)
+				// Let the other side know nothing more is coming, keeping the other direction open
+				if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+					cw.CloseWrite()
+				}
+				done <- struct{}{}
+			}
+			go relay(remote, conn)
+			go relay(conn, remote)
+			<-done
+			<-done
+		}()
+	}
+}
+
+// useRemoteEngine points the dagger session at the remote engine
+func useRemoteEngine(ctx context.Context) error {
+	host, err := engine.runnerHost(ctx)
+	if err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Using remote dagger engine", "engine", engine.Host, "tls", engine.usesTLS())
+	return os.Setenv(daggerRunnerHostEnvVar, host)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteEngineRunnerHost(t *testing.T) {
+	ctx := context.Background()
+
+	e := &remoteEngine{Host: "kube-pod://dagger-engine?namespace=builds"}
+	host, err := e.runnerHost(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "kube-pod://dagger-engine?namespace=builds", host, "engines without TLS are connected to directly")
+
+	e = &remoteEngine{Host: "unix:///run/dagger/engine.sock", CA: "ca.pem"}
+	_, err = e.runnerHost(ctx)
+	assert.ErrorContains(t, err, "TLS requires a tcp://host:port address")
+
+	e = &remoteEngine{Host: "tcp://builds.example.com:1234", Cert: "client.pem"}
+	_, err = e.runnerHost(ctx)
+	assert.ErrorContains(t, err, "must be set together")
+}
+
+func TestRemoteEngineTLSProxy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	engineSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "engine")
+	}))
+	defer engineSrv.Close()
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: engineSrv.Certificate().Raw}), 0600))
+
+	e := &remoteEngine{Host: strings.Replace(engineSrv.URL, "https://", "tcp://", 1), CA: ca}
+	host, err := e.runnerHost(ctx)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(host, "tcp://127.0.0.1:"), host)
+
+	// The proxy speaks TLS to the engine for its plain text clients
+	resp, err := http.Get("http://" + strings.TrimPrefix(host, "tcp://"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "engine", string(body))
+
+	e.CA = filepath.Join(t.TempDir(), "missing.pem")
+	_, err = e.runnerHost(ctx)
+	assert.ErrorContains(t, err, "failed to read the engine CA certificate")
+}
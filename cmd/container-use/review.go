@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var reviewCmd = &cobra.Command{
+	Use:   "review [<env>]",
+	Short: "Review an environment's changes chunk by chunk",
+	Long: `Display the changes made by an agent split into chunks, along with review comments.
+The output is Markdown, ready to be pasted in a pull request body.
+Use --chunk and --comment to leave a comment the agent can read with environment_review.
+
+If no environment is specified, automatically selects from environments
+that are descendants of the current HEAD.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Review the changes of an environment
+container-use review fancy-mallard
+
+# Comment on a chunk
+container-use review fancy-mallard --chunk 3f2a9c1e --comment "Please handle the error here"`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		envID, err := resolveEnvironmentID(ctx, repo, args)
+		if err != nil {
+			return err
+		}
+
+		chunkID, _ := app.Flags().GetString("chunk")
+		comment, _ := app.Flags().GetString("comment")
+		if (chunkID == "") != (comment == "") {
+			return errors.New("--chunk and --comment must be used together")
+		}
+
+		if chunkID != "" {
+			author, err := repository.RunGitCommand(ctx, repo.SourcePath(), "config", "user.name")
+			if err != nil || strings.TrimSpace(author) == "" {
+				author = "user"
+			}
+			c, err := repo.AddReviewComment(ctx, envID, chunkID, strings.TrimSpace(author), comment)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Comment added to chunk %s of %s\n", c.ChunkID, c.File)
+			return nil
+		}
+
+		review, err := repo.Review(ctx, envID)
+		if err != nil {
+			return err
+		}
+
+		writeReviewMarkdown(os.Stdout, envID, review)
+		return nil
+	},
+}
+
+func writeReviewMarkdown(w io.Writer, envID string, review *repository.Review) {
+	fmt.Fprintf(w, "# Review of %s\n", envID)
+	if len(review.Chunks) == 0 {
+		fmt.Fprintln(w, "\nNo changes.")
+	}
+
+	if len(review.Policy) > 0 {
+		fmt.Fprintln(w, "\n## Policy\n")
+		for _, v := range review.Policy {
+			fmt.Fprintf(w, "- `%s` %s: %s\n", v.File, v.Rule, v.Message)
+		}
+	}
+
+	file := ""
+	for _, chunk := range review.Chunks {
+		if chunk.File != file {
+			file = chunk.File
+			fmt.Fprintf(w, "\n## %s\n", file)
+		}
+		fmt.Fprintf(w, "\n### Chunk `%s`\n\n```diff\n%s\n```\n", chunk.ID, chunk.Patch)
+		writeReviewComments(w, chunk.Comments)
+	}
+
+	if len(review.OutdatedComments) > 0 {
+		fmt.Fprintln(w, "\n## Outdated comments")
+		writeReviewComments(w, review.OutdatedComments)
+	}
+}
+
+func writeReviewComments(w io.Writer, comments []environment.ReviewComment) {
+	now := time.Now()
+	for _, c := range comments {
+		author := c.Author
+		if author == "" {
+			author = "unknown"
+		}
+		fmt.Fprintf(w, "\n> **%s** on `%s` (%s): %s\n", author, c.File, formatTime(c.CreatedAt, now), strings.ReplaceAll(c.Body, "\n", "\n> "))
+	}
+}
+
+func init() {
+	reviewCmd.Flags().String("chunk", "", "ID of the chunk to comment on")
+	reviewCmd.Flags().String("comment", "", "Comment to attach to the chunk")
+	rootCmd.AddCommand(reviewCmd)
+}
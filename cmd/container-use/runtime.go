@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// Container runtime setups, which are started and fixed differently
+const (
+	setupDocker         = "Docker"
+	setupDockerDesktop  = "Docker Desktop"
+	setupDockerRootless = "rootless Docker"
+	setupColima         = "Colima"
+	setupLima           = "Lima"
+	setupPodman         = "Podman"
+	setupPodmanRootless = "rootless Podman"
+	setupPodmanMachine  = "Podman machine"
+)
+
+// runtimeSetup is how the container runtime Dagger provisions its engine with is set up
+type runtimeSetup struct {
+	Name string
+	// Instance is the Colima profile, Lima instance or Podman machine
+	Instance string
+	// Socket is the path of the Docker API socket of the setup, if known
+	Socket string
+}
+
+func (s *runtimeSetup) String() string {
+	if s.Instance != "" {
+		return fmt.Sprintf("%s (%s)", s.Name, s.Instance)
+	}
+	return s.Name
+}
+
+var (
+	colimaSocketRe   = regexp.MustCompile(`/\.colima/([^/]+)/docker\.sock$`)
+	limaSocketRe     = regexp.MustCompile(`/\.lima/([^/]+)/sock/docker\.sock$`)
+	rootlessSocketRe = regexp.MustCompile(`^/run/user/(\d+)/docker\.sock$`)
+	podmanMachineRe  = regexp.MustCompile(`(podman-machine-[^/]*?)(?:-api)?\.sock$`)
+)
+
+// classifyDockerHost identifies the setup serving a Docker API endpoint (e.g. unix:///Users/me/.colima/default/docker.sock)
+// from its path
+func classifyDockerHost(host string) *runtimeSetup {
+	socket, ok := strings.CutPrefix(host, "unix://")
+	if !ok {
+		if strings.Contains(host, "dockerDesktop") {
+			return &runtimeSetup{Name: setupDockerDesktop}
+		}
+		return &runtimeSetup{Name: setupDocker}
+	}
+	setup := &runtimeSetup{Name: setupDocker, Socket: socket}
+	switch {
+	case colimaSocketRe.MatchString(socket):
+		setup.Name, setup.Instance = setupColima, colimaSocketRe.FindStringSubmatch(socket)[1]
+	case limaSocketRe.MatchString(socket):
+		setup.Name, setup.Instance = setupLima, limaSocketRe.FindStringSubmatch(socket)[1]
+	case rootlessSocketRe.MatchString(socket):
+		setup.Name = setupDockerRootless
+	case strings.Contains(socket, "podman"):
+		setup.Name = setupPodman
+		if match := podmanMachineRe.FindStringSubmatch(socket); match != nil {
+			setup.Name, setup.Instance = setupPodmanMachine, match[1]
+		} else if isRootlessPodmanSocket(socket) {
+			setup.Name = setupPodmanRootless
+		}
+	case strings.Contains(socket, "/.docker/run/") || strings.Contains(socket, "/.docker/desktop/"):
+		setup.Name = setupDockerDesktop
+	}
+	return setup
+}
+
+// detectRuntimeSetup finds the setup of the container runtime Dagger uses: the Docker endpoint of DOCKER_HOST or of the
+// current Docker context, else Podman, also behind a docker CLI emulated by Podman. It returns nil when there is no
+// container runtime.
+func detectRuntimeSetup(ctx context.Context) *runtimeSetup {
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		return classifyDockerHost(host)
+	}
+	if _, err := exec.LookPath("docker"); err == nil {
+		ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+		out, err := exec.CommandContext(ctx, "docker", "context", "inspect", "--format", "{{.Endpoints.docker.Host}}").Output()
+		if host := strings.TrimSpace(string(out)); err == nil && host != "" {
+			return classifyDockerHost(host)
+		}
+		if runtime.GOOS == "linux" && isPodmanShim(ctx) {
+			return localPodmanSetup()
+		}
+		return &runtimeSetup{Name: setupDocker}
+	}
+	if _, err := exec.LookPath("podman"); err == nil {
+		if runtime.GOOS == "linux" {
+			return localPodmanSetup()
+		}
+		return &runtimeSetup{Name: setupPodmanMachine}
+	}
+	return nil
+}
+
+// remediation returns the commands getting the container runtime of the setup to run and reachable by Dagger
+func (s *runtimeSetup) remediation() []string {
+	switch s.Name {
+	case setupColima:
+		if s.Instance == "" || s.Instance == "default" {
+			return []string{"colima start", "docker context use colima"}
+		}
+		return []string{"colima start --profile " + s.Instance, "docker context use colima-" + s.Instance}
+	case setupLima:
+		return []string{"limactl start " + s.Instance, "export DOCKER_HOST=unix://" + s.Socket}
+	case setupDockerRootless:
+		return []string{"systemctl --user start docker", "export DOCKER_HOST=unix://" + s.Socket}
+	case setupPodmanMachine:
+		return []string{strings.TrimSpace("podman machine start " + s.Instance)}
+	case setupPodmanRootless:
+		// The podman CLI runs containers without the service, which serves the Docker API of the socket
+		if s.Socket == "" {
+			return []string{"podman info"}
+		}
+		return []string{"systemctl --user enable --now podman.socket", "export DOCKER_HOST=unix://" + s.Socket}
+	case setupPodman:
+		if s.Socket == "" {
+			return []string{"podman info"}
+		}
+		return []string{"sudo systemctl enable --now podman.socket", "export DOCKER_HOST=unix://" + s.Socket}
+	case setupDockerDesktop:
+		switch runtime.GOOS {
+		case "darwin":
+			return []string{"open -a Docker"}
+		case "windows":
+			return []string{`start "" "C:\Program Files\Docker\Docker\Docker Desktop.exe"`}
+		}
+		return []string{"systemctl --user start docker-desktop"}
+	}
+	if runtime.GOOS == "linux" {
+		return []string{"sudo systemctl start docker"}
+	}
+	return []string{"Start Docker and try again"}
+}
+
+// candidateDockerSockets are the sockets of the setups Dagger can't find without DOCKER_HOST, in order of preference.
+// Podman serves the Docker API too, for the docker CLI to use it.
+func candidateDockerSockets() []string {
+	var sockets []string
+	if home, err := homedir.Dir(); err == nil {
+		sockets = append(sockets,
+			filepath.Join(home, ".colima", "default", "docker.sock"),
+			filepath.Join(home, ".colima", "docker.sock"),
+			filepath.Join(home, ".lima", "docker", "sock", "docker.sock"),
+		)
+	}
+	if runtime.GOOS == "linux" {
+		sockets = append(sockets, filepath.Join(xdgRuntimeDir(), "docker.sock"), podmanSocket())
+	}
+	return sockets
+}
+
+// socketReachable tells whether something accepts connections on the unix socket
+func socketReachable(socket string) bool {
+	conn, err := net.DialTimeout("unix", socket, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// prepareRuntime detects the setup of the container runtime before connecting to Dagger. When the Docker endpoint
+// Dagger would use isn't reachable but the socket of another setup is, e.g. Colima without its Docker context,
+// DOCKER_HOST is set to it for Dagger to use it.
+func prepareRuntime(ctx context.Context) *runtimeSetup {
+	setup := detectRuntimeSetup(ctx)
+	if setup == nil || os.Getenv("DOCKER_HOST") != "" {
+		return setup
+	}
+	if setup.isPodman() || setup.Socket == "" || socketReachable(setup.Socket) {
+		return setup
+	}
+	for _, socket := range candidateDockerSockets() {
+		if socket == setup.Socket || !socketReachable(socket) {
+			continue
+		}
+		found := classifyDockerHost("unix://" + socket)
+		slog.InfoContext(ctx, "Using the Docker socket of another container runtime", "setup", found.String(), "socket", socket, "unreachable", setup.Socket)
+		fmt.Fprintf(os.Stderr, "%s isn't reachable at %s: using %s at %s\n", setup, setup.Socket, found, socket)
+		os.Setenv("DOCKER_HOST", "unix://"+socket)
+		return found
+	}
+	return setup
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyDockerHost(t *testing.T) {
+	tests := []struct {
+		host     string
+		name     string
+		instance string
+	}{
+		{host: "unix:///var/run/docker.sock", name: setupDocker},
+		{host: "unix:///Users/me/.colima/default/docker.sock", name: setupColima, instance: "default"},
+		{host: "unix:///Users/me/.colima/work/docker.sock", name: setupColima, instance: "work"},
+		{host: "unix:///Users/me/.lima/docker/sock/docker.sock", name: setupLima, instance: "docker"},
+		{host: "unix:///run/user/1000/docker.sock", name: setupDockerRootless},
+		{host: "unix:///run/user/1000/podman/podman.sock", name: setupPodmanRootless},
+		{host: "unix:///run/podman/podman.sock", name: setupPodman},
+		{host: "unix:///var/folders/xy/T/podman/podman-machine-default-api.sock", name: setupPodmanMachine, instance: "podman-machine-default"},
+		{host: "unix:///Users/me/.docker/run/docker.sock", name: setupDockerDesktop},
+		{host: "npipe:////./pipe/dockerDesktopLinuxEngine", name: setupDockerDesktop},
+		{host: "tcp://10.0.0.2:2375", name: setupDocker},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			setup := classifyDockerHost(tt.host)
+			assert.Equal(t, tt.name, setup.Name)
+			assert.Equal(t, tt.instance, setup.Instance)
+		})
+	}
+}
+
+func TestRuntimeSetupRemediation(t *testing.T) {
+	assert.Equal(t, []string{"colima start", "docker context use colima"},
+		classifyDockerHost("unix:///Users/me/.colima/default/docker.sock").remediation())
+	assert.Equal(t, []string{"colima start --profile work", "docker context use colima-work"},
+		classifyDockerHost("unix:///Users/me/.colima/work/docker.sock").remediation())
+	assert.Equal(t, []string{"limactl start docker", "export DOCKER_HOST=unix:///Users/me/.lima/docker/sock/docker.sock"},
+		classifyDockerHost("unix:///Users/me/.lima/docker/sock/docker.sock").remediation())
+	assert.Equal(t, []string{"systemctl --user start docker", "export DOCKER_HOST=unix:///run/user/1000/docker.sock"},
+		classifyDockerHost("unix:///run/user/1000/docker.sock").remediation())
+	assert.Equal(t, []string{"podman machine start podman-machine-default"},
+		classifyDockerHost("unix:///var/folders/xy/T/podman/podman-machine-default-api.sock").remediation())
+	assert.Equal(t, []string{"podman machine start"}, (&runtimeSetup{Name: setupPodmanMachine}).remediation())
+	assert.Equal(t, []string{"systemctl --user enable --now podman.socket", "export DOCKER_HOST=unix:///run/user/1000/podman/podman.sock"},
+		classifyDockerHost("unix:///run/user/1000/podman/podman.sock").remediation())
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "Run commands scheduled in environments",
+	Long: `Agents schedule recurring commands in their environments with environment_schedule_add
+(e.g. refreshing fixtures nightly). Scheduled commands only run while the scheduler is running.`,
+}
+
+var scheduleListCmd = &cobra.Command{
+	Use:               "list [<env>]",
+	Short:             "List scheduled commands and their last run",
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		envInfos, err := repo.List(ctx)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		defer tw.Flush()
+		fmt.Fprintln(tw, "ENVIRONMENT\tID\tCRON\tCOMMAND\tLAST RUN\tNEXT RUN")
+		for _, envInfo := range envInfos {
+			if len(args) > 0 && envInfo.ID != args[0] {
+				continue
+			}
+			for _, s := range envInfo.State.Schedules {
+				last := "never"
+				if run := s.LastRun(); run != nil {
+					last = formatTime(run.StartedAt, now)
+					if run.Failed() {
+						last += fmt.Sprintf(" (failed, exit code %d)", run.ExitCode)
+					}
+				}
+				next := "never"
+				if t := s.Next(); !t.IsZero() {
+					next = formatTime(t, now)
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", envInfo.ID, s.ID, s.Cron, truncate(app, s.Command, 40), last, next)
+			}
+		}
+		return nil
+	},
+}
+
+var scheduleRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Run the scheduler",
+	Long: `Run the commands scheduled in the environments of the repository when they are due.
+The scheduler checks every minute until interrupted. Use --once to run the commands due now and exit,
+e.g. from the system crontab.
+
+Failures are recorded in the environment log and delivered to the environment mailbox.`,
+	Example: `# Keep running scheduled commands
+container-use schedule run
+
+# From the system crontab
+* * * * * cd /path/to/repo && container-use schedule run --once`,
+	RunE: func(app *cobra.Command, _ []string) error {
+		ctx := app.Context()
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		dag, err := connectDagger(ctx, logWriter)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		once, _ := app.Flags().GetBool("once")
+		for {
+			runs, err := repo.RunDueSchedules(ctx, dag, time.Now())
+			for _, run := range runs {
+				status := "ok"
+				if run.Failed() {
+					status = fmt.Sprintf("failed (exit code %d) %s", run.ExitCode, run.Error)
+				}
+				fmt.Printf("%s %s/%s: %s: %s\n", run.StartedAt.Format(time.DateTime), run.EnvironmentID, run.ScheduleID, run.Command, status)
+			}
+			if err != nil {
+				if once {
+					return err
+				}
+				slog.Error("Failed to run scheduled commands", "err", err)
+			}
+			if once {
+				return nil
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Until(time.Now().Truncate(time.Minute).Add(time.Minute))):
+			}
+		}
+	},
+}
+
+func init() {
+	scheduleRunCmd.Flags().Bool("once", false, "Run the commands due now and exit")
+	scheduleListCmd.Flags().BoolP("no-trunc", "", false, "Don't truncate output")
+
+	scheduleCmd.AddCommand(scheduleListCmd, scheduleRunCmd)
+	rootCmd.AddCommand(scheduleCmd)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var selftestCmd = &cobra.Command{
+	Use:   "selftest",
+	Short: "Check this machine supports many agents working at once",
+	Long: `Run concurrent workers against a temporary repository, each creating host
+environments, running a command, writing a file and deleting them like an agent
+would. Once they are done, check the invariants agents sharing a repository rely
+on: environment IDs are unique, no lock is left held and the git state is
+consistent.
+
+Run it before letting multiple agents loose, e.g. after upgrading git or moving
+the container-use configuration to a network filesystem. Your repositories and
+environments are left untouched.`,
+	Args: cobra.NoArgs,
+	Example: `# Simulate 8 agents creating 5 environments each
+container-use selftest --concurrency 8 --iterations 5`,
+	RunE: func(app *cobra.Command, _ []string) error {
+		ctx := app.Context()
+
+		concurrency, _ := app.Flags().GetInt("concurrency")
+		iterations, _ := app.Flags().GetInt("iterations")
+
+		dir, err := os.MkdirTemp("", "container-use-selftest-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		fmt.Printf("Running %d workers, %d environments each...\n", concurrency, iterations)
+		result, err := repository.SelfTest(ctx, dir, repository.SelfTestOpts{
+			Concurrency: concurrency,
+			Iterations:  iterations,
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Created and deleted %d environments in %s\n", result.Environments, result.Duration.Round(time.Millisecond))
+		if len(result.Violations) == 0 {
+			fmt.Println("All invariants hold: unique environment IDs, no lock leaked, consistent git state.")
+			return nil
+		}
+		for _, violation := range result.Violations {
+			fmt.Printf("Violation: %s\n", violation)
+		}
+		return fmt.Errorf("self-test failed with %d violations", len(result.Violations))
+	},
+}
+
+func init() {
+	selftestCmd.Flags().Int("concurrency", 4, "Number of workers running at the same time")
+	selftestCmd.Flags().Int("iterations", 3, "Number of environments each worker creates and deletes")
+
+	rootCmd.AddCommand(selftestCmd)
+}
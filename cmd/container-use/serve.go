@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/dagger/container-use/mcpserver"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// tokenEnvVar holds the token of the HTTP server, to keep it out of the process list
+	tokenEnvVar = "CONTAINER_USE_TOKEN"
+	// payloadKeyEnvVar holds the key encrypting file contents in tool results, base64 encoded
+	payloadKeyEnvVar = "CONTAINER_USE_PAYLOAD_KEY"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Start MCP server over HTTP for agents and remote IDEs",
+	Long: `Start the Model Context Protocol server over Server-Sent Events, so several agents
+and remote IDEs can share one long-lived server.
+
+Clients authenticate with a token, sent as a bearer token (Authorization: Bearer <token>)
+or as the token query parameter of the SSE endpoint. The token is read from ` + tokenEnvVar + `,
+or generated and printed at startup.
+
+With --tls-cert and --tls-key, the server is served over HTTPS. With --tls-client-ca, clients
+must also present a certificate signed by one of the given authorities.
+
+When ` + payloadKeyEnvVar + ` is set to a base64 encoded 256-bit key, the results of the tools
+returning file contents are encrypted with it (AES-256-GCM), so they stay private to the clients
+sharing the key even through proxies terminating TLS.`,
+	Args: cobra.NoArgs,
+	Example: `# Serve on localhost
+container-use serve --listen 127.0.0.1:8080
+
+# Serve remote IDEs with a fixed token
+CONTAINER_USE_TOKEN=secret container-use serve --listen :8080 --base-url http://devbox:8080
+
+# Serve an agent fleet over HTTPS, to clients with certificates
+container-use serve --listen :8443 --tls-cert server.pem --tls-key server-key.pem --tls-client-ca fleet-ca.pem`,
+	RunE: func(app *cobra.Command, _ []string) error {
+		ctx := app.Context()
+
+		listen, _ := app.Flags().GetString("listen")
+		baseURL, _ := app.Flags().GetString("base-url")
+		mcpserver.CommandTimeout, _ = app.Flags().GetDuration("command-timeout")
+		mcpserver.CloudRoles, _ = app.Flags().GetStringSlice("cloud-role")
+		mcpserver.ToolMetrics, _ = app.Flags().GetBool("tool-metrics")
+		mcpserver.ServerLimits = limitsFromFlags(app)
+		certFile, _ := app.Flags().GetString("tls-cert")
+		keyFile, _ := app.Flags().GetString("tls-key")
+		clientCAFile, _ := app.Flags().GetString("tls-client-ca")
+
+		tlsConfig, err := serverTLSConfig(certFile, keyFile, clientCAFile)
+		if err != nil {
+			return err
+		}
+		if key := os.Getenv(payloadKeyEnvVar); key != "" {
+			if mcpserver.PayloadKey, err = mcpserver.ParsePayloadKey(key); err != nil {
+				return fmt.Errorf("%s: %w", payloadKeyEnvVar, err)
+			}
+		}
+
+		token := os.Getenv(tokenEnvVar)
+		generated := token == ""
+		if generated {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				return err
+			}
+			token = hex.EncodeToString(b)
+		}
+
+		listener, err := net.Listen("tcp", listen)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", listen, err)
+		}
+		defer listener.Close()
+		scheme := "http"
+		if tlsConfig != nil {
+			listener = tls.NewListener(listener, tlsConfig)
+			scheme = "https"
+		}
+		if baseURL == "" {
+			baseURL = scheme + "://" + localAddress(listener.Addr())
+		}
+
+		dag, err := connectDagger(ctx, logWriter)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		fmt.Fprintf(os.Stderr, "Serving MCP over SSE on %s/sse\n", baseURL)
+		if generated {
+			fmt.Fprintf(os.Stderr, "Token: %s\n", token)
+		}
+		return mcpserver.RunSSEServer(ctx, dag, listener, baseURL, token)
+	},
+}
+
+// serverTLSConfig loads the certificate of the server, and the authorities client certificates must be signed by.
+// It returns nil when the server isn't configured for TLS.
+func serverTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		if clientCAFile != "" {
+			return nil, errors.New("--tls-client-ca requires --tls-cert and --tls-key")
+		}
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("--tls-cert and --tls-key must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// localAddress returns the address to reach a listener from the local machine
+func localAddress(addr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func init() {
+	serveCmd.Flags().String("listen", "127.0.0.1:8080", "Address to listen on")
+	serveCmd.Flags().String("base-url", "", "URL clients reach the server at (default: http://<listen address>)")
+	serveCmd.Flags().String("tls-cert", "", "Certificate of the server, in PEM format, to serve over HTTPS")
+	serveCmd.Flags().String("tls-key", "", "Private key of the certificate of the server, in PEM format")
+	serveCmd.Flags().String("tls-client-ca", "", "Certificates of the authorities client certificates must be signed by, in PEM format")
+	serveCmd.Flags().Duration("command-timeout", mcpserver.CommandTimeout, "Time after which commands are interrupted when agents don't set a timeout (0 for no limit)")
+	addCloudRoleFlag(serveCmd)
+	addToolMetricsFlag(serveCmd)
+	addLimitFlags(serveCmd)
+
+	rootCmd.AddCommand(serveCmd)
+}
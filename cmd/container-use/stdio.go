@@ -5,8 +5,8 @@ import (
 	"log/slog"
 	"os"
 	"strconv"
+	"strings"
 
-	"dagger.io/dagger"
 	"github.com/dagger/container-use/mcpserver"
 	"github.com/spf13/cobra"
 )
@@ -17,17 +17,26 @@ var stdioCmd = &cobra.Command{
 	Long:  `Start the Model Context Protocol server that enables AI agents to create and manage containerized environments. This is typically used by agents like Claude Code, Cursor, or VSCode.`,
 	RunE: func(app *cobra.Command, _ []string) error {
 		ctx := app.Context()
+		mcpserver.CommandTimeout, _ = app.Flags().GetDuration("command-timeout")
+		mcpserver.CloudRoles, _ = app.Flags().GetStringSlice("cloud-role")
+		mcpserver.ToolMetrics, _ = app.Flags().GetBool("tool-metrics")
+		mcpserver.ServerLimits = limitsFromFlags(app)
+
+		if useDaemon, _ := app.Flags().GetBool("daemon"); useDaemon {
+			socket, _ := app.Flags().GetString("socket")
+			socket, err := ensureDaemon(app, socket)
+			if err != nil {
+				return err
+			}
+			return mcpserver.ProxyStdio(ctx, socket, os.Stdin, os.Stdout)
+		}
 
 		slog.Info("connecting to dagger")
 
-		dag, err := dagger.Connect(ctx, dagger.WithLogOutput(logWriter))
+		dag, err := connectDagger(ctx, logWriter)
 		if err != nil {
 			slog.Error("Error starting dagger", "error", err)
-
-			if isDockerDaemonError(err) {
-				handleDockerDaemonError()
-			}
-
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 		defer dag.Close()
@@ -53,6 +62,43 @@ var killBackgroundCmd = &cobra.Command{
 }
 
 func init() {
+	stdioCmd.Flags().Duration("command-timeout", mcpserver.CommandTimeout, "Time after which commands are interrupted when agents don't set a timeout (0 for no limit)")
+	addCloudRoleFlag(stdioCmd)
+	addToolMetricsFlag(stdioCmd)
+	addLimitFlags(stdioCmd)
+	stdioCmd.Flags().Bool("daemon", os.Getenv("CONTAINER_USE_DAEMON") == "1", "Relay to the daemon shared by the agents of the user, starting it if needed (env: CONTAINER_USE_DAEMON=1)")
+	addDaemonSocketFlag(stdioCmd)
 	rootCmd.AddCommand(stdioCmd)
 	rootCmd.AddCommand(killBackgroundCmd)
 }
+
+// addCloudRoleFlag adds the flag allowing agents to be granted credentials of cloud roles to a server command
+func addCloudRoleFlag(cmd *cobra.Command) {
+	roles := strings.FieldsFunc(os.Getenv("CONTAINER_USE_CLOUD_ROLES"), func(r rune) bool { return r == ',' })
+	cmd.Flags().StringSlice("cloud-role", roles, "AWS role ARN or GCP service account agents may be granted short-lived credentials of, repeatable (env: CONTAINER_USE_CLOUD_ROLES)")
+}
+
+// addToolMetricsFlag adds the flag reporting the metrics of tool calls in their results to a server command
+func addToolMetricsFlag(cmd *cobra.Command) {
+	enabled := os.Getenv("CONTAINER_USE_TOOL_METRICS") == "1"
+	cmd.Flags().Bool("tool-metrics", enabled, "Add the timing, state revision and size of each tool call to its result (env: CONTAINER_USE_TOOL_METRICS=1)")
+}
+
+// addLimitFlags adds the flags limiting what the clients of a server command can run
+func addLimitFlags(cmd *cobra.Command) {
+	envLimit := func(name string) int {
+		limit, _ := strconv.Atoi(os.Getenv(name))
+		return limit
+	}
+	cmd.Flags().Int("max-environments", envLimit("CONTAINER_USE_MAX_ENVIRONMENTS"), "Maximum number of environments of a repository, 0 for no limit (env: CONTAINER_USE_MAX_ENVIRONMENTS)")
+	cmd.Flags().Int("max-concurrent-commands", envLimit("CONTAINER_USE_MAX_CONCURRENT_COMMANDS"), "Maximum number of commands a client runs at the same time, 0 for no limit (env: CONTAINER_USE_MAX_CONCURRENT_COMMANDS)")
+	cmd.Flags().Int("max-background-services", envLimit("CONTAINER_USE_MAX_BACKGROUND_SERVICES"), "Maximum number of background commands and services running in an environment, 0 for no limit (env: CONTAINER_USE_MAX_BACKGROUND_SERVICES)")
+}
+
+func limitsFromFlags(cmd *cobra.Command) mcpserver.Limits {
+	var limits mcpserver.Limits
+	limits.Environments, _ = cmd.Flags().GetInt("max-environments")
+	limits.ConcurrentCommands, _ = cmd.Flags().GetInt("max-concurrent-commands")
+	limits.BackgroundServices, _ = cmd.Flags().GetInt("max-background-services")
+	return limits
+}
@@ -102,19 +102,46 @@ func (s *MCPServerProcess) CreateEnvironment(title, explanation string) (string,
 		return "", err
 	}
 
-	if len(result.Content) > 0 {
-		if textContent, ok := result.Content[0].(mcp.TextContent); ok {
-			var envResponse struct {
-				ID string `json:"id"`
-			}
-			if err := json.Unmarshal([]byte(textContent.Text), &envResponse); err != nil {
-				return "", fmt.Errorf("failed to parse environment response (content: %q): %w", textContent.Text, err)
-			}
-			return envResponse.ID, nil
-		}
+	data, err := resultData(result)
+	if err != nil {
+		return "", err
 	}
+	var envResponse struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(data), &envResponse); err != nil {
+		return "", fmt.Errorf("failed to parse environment response (content: %q): %w", data, err)
+	}
+	return envResponse.ID, nil
+}
 
-	return "", fmt.Errorf("no valid response content found")
+// resultData returns the data of the envelope tool results are wrapped in: JSON results as is, the others as text
+func resultData(result *mcp.CallToolResult) (string, error) {
+	if len(result.Content) == 0 {
+		return "", fmt.Errorf("no valid response content found")
+	}
+	textContent, ok := result.Content[0].(mcp.TextContent)
+	if !ok {
+		return "", fmt.Errorf("no valid response content found")
+	}
+	var response struct {
+		Status string          `json:"status"`
+		Data   json.RawMessage `json:"data"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(textContent.Text), &response); err != nil {
+		return "", fmt.Errorf("failed to parse tool response (content: %q): %w", textContent.Text, err)
+	}
+	if response.Error != nil {
+		return "", fmt.Errorf("tool failed: %s", response.Error.Message)
+	}
+	var text string
+	if json.Unmarshal(response.Data, &text) == nil {
+		return text, nil
+	}
+	return string(response.Data), nil
 }
 
 // FileRead reads a file from an environment via MCP
@@ -136,13 +163,7 @@ func (s *MCPServerProcess) FileRead(envID, targetFile string) (string, error) {
 		return "", err
 	}
 
-	if len(result.Content) > 0 {
-		if textContent, ok := result.Content[0].(mcp.TextContent); ok {
-			return textContent.Text, nil
-		}
-	}
-
-	return "", nil
+	return resultData(result)
 }
 
 // FileWrite writes a file to an environment via MCP
@@ -181,13 +202,7 @@ func (s *MCPServerProcess) RunCommand(envID, command, explanation string) (strin
 		return "", err
 	}
 
-	if len(result.Content) > 0 {
-		if textContent, ok := result.Content[0].(mcp.TextContent); ok {
-			return textContent.Text, nil
-		}
-	}
-
-	return "", nil
+	return resultData(result)
 }
 
 func TestSharedRepositoryContention(t *testing.T) {
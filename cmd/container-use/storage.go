@@ -0,0 +1,24 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var storageDriver string
+
+func init() {
+	defaultDriver := os.Getenv("CONTAINER_USE_STORAGE_DRIVER")
+	if defaultDriver == "" {
+		defaultDriver = string(repository.StorageAuto)
+	}
+	rootCmd.PersistentFlags().StringVar(&storageDriver, "storage-driver", defaultDriver, "How environment worktrees are stored: auto, checkout or reflink (env: CONTAINER_USE_STORAGE_DRIVER)")
+	cobra.OnInitialize(func() {
+		if err := repository.SetStorageDriver(storageDriver); err != nil {
+			slog.Warn("Ignoring storage driver", "err", err)
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/spf13/cobra"
+)
+
+var templatesCmd = &cobra.Command{
+	Use:   "templates",
+	Short: "Manage environment templates",
+	Long: `Templates are preset environment configurations (base image, setup commands, services)
+that agents can create environments from with the template argument of environment_create.`,
+}
+
+var templatesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List environment templates",
+	Args:  cobra.NoArgs,
+	RunE: func(app *cobra.Command, _ []string) error {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		defer tw.Flush()
+
+		fmt.Fprintln(tw, "NAME\tBASE IMAGE\tSERVICES\tDESCRIPTION")
+		for _, t := range environment.Templates() {
+			config := t.Config()
+			services := []string{}
+			for _, svc := range config.Services {
+				services = append(services, svc.Name)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.Name, config.BaseImage, strings.Join(services, ","), t.Description)
+		}
+		return nil
+	},
+}
+
+var templatesShowCmd = &cobra.Command{
+	Use:               "show <name>",
+	Short:             "Show the configuration of an environment template",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: suggestTemplates,
+	RunE: func(app *cobra.Command, args []string) error {
+		t, err := environment.GetTemplate(args[0])
+		if err != nil {
+			return err
+		}
+		out, err := json.MarshalIndent(t.Config(), "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	},
+}
+
+// suggestTemplates completes the names of the environment templates
+func suggestTemplates(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	names := []string{}
+	for _, t := range environment.Templates() {
+		names = append(names, t.Name)
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+func init() {
+	templatesCmd.AddCommand(templatesListCmd, templatesShowCmd)
+	rootCmd.AddCommand(templatesCmd)
+}
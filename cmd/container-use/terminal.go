@@ -1,12 +1,13 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 
-	"dagger.io/dagger"
+	"github.com/dagger/container-use/environment"
 	"github.com/dagger/container-use/repository"
 	"github.com/spf13/cobra"
 )
@@ -27,7 +28,10 @@ container-use terminal fancy-mallard
 container-use terminal backend-api
 
 # Auto-select environment
-container-use terminal`,
+container-use terminal
+
+# Serve the terminal over WebSocket for an editor
+container-use terminal fancy-mallard --listen 127.0.0.1:7681`,
 	RunE: func(app *cobra.Command, args []string) error {
 		ctx := app.Context()
 
@@ -36,6 +40,10 @@ container-use terminal`,
 			return err
 		}
 
+		if listen, _ := app.Flags().GetString("listen"); listen != "" {
+			return serveRemoteTerminal(ctx, repo, args, listen)
+		}
+
 		// FIXME(aluzzardi): This is a hack to make sure we're wrapped in `dagger run` since `Terminal()` only works with the CLI.
 		// If not, it will auto-wrap this command in a `dagger run`.
 		if _, ok := os.LookupEnv("DAGGER_SESSION_TOKEN"); !ok {
@@ -49,12 +57,9 @@ container-use terminal`,
 			return execDaggerRun(daggerBin, append([]string{"dagger", "run"}, os.Args...), os.Environ())
 		}
 
-		dag, err := dagger.Connect(ctx, dagger.WithLogOutput(os.Stderr))
+		dag, err := connectDagger(ctx, os.Stderr)
 		if err != nil {
-			if isDockerDaemonError(err) {
-				handleDockerDaemonError()
-			}
-			return fmt.Errorf("failed to connect to dagger: %w", err)
+			return err
 		}
 		defer dag.Close()
 
@@ -72,6 +77,39 @@ container-use terminal`,
 	},
 }
 
+// serveRemoteTerminal serves a terminal to the environment over WebSocket until interrupted.
+// It doesn't need `dagger run`: shells run on pseudo-terminals of their own.
+func serveRemoteTerminal(ctx context.Context, repo *repository.Repository, args []string, listen string) error {
+	dag, err := connectDagger(ctx, logWriter)
+	if err != nil {
+		return err
+	}
+	defer dag.Close()
+
+	envID, err := resolveEnvironmentID(ctx, repo, args)
+	if err != nil {
+		return err
+	}
+
+	terminal, err := environment.StartRemoteTerminal(envID, listen, environment.RemoteTerminalSession{
+		Open: func(ctx context.Context) (*environment.Environment, error) {
+			return repo.Get(ctx, dag, envID)
+		},
+		Save: func(ctx context.Context, env *environment.Environment) error {
+			return repo.Update(ctx, env, "Run shell from remote terminal")
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer terminal.Close()
+
+	fmt.Printf("Serving a terminal to environment %s on %s\n", envID, terminal.URL)
+	<-ctx.Done()
+	return nil
+}
+
 func init() {
+	terminalCmd.Flags().String("listen", "", "Serve the terminal over WebSocket on this address (e.g. 127.0.0.1:0) for remote clients")
 	rootCmd.AddCommand(terminalCmd)
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// formatTime renders a timestamp for people scanning the output: relative to now when recent ("3m ago", "in 5m"),
+// otherwise a date in the local timezone. Machine-readable output uses RFC3339 instead.
+func formatTime(t, now time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	d := now.Sub(t)
+	suffix := " ago"
+	if d < 0 {
+		d = -d
+		suffix = ""
+	}
+	if d < time.Minute {
+		return "just now"
+	}
+	if d < 7*24*time.Hour {
+		relative := formatDuration(d.Truncate(time.Minute), 1)
+		if suffix == "" {
+			return "in " + relative
+		}
+		return relative + suffix
+	}
+
+	local := t.Local()
+	if local.Year() == now.Local().Year() {
+		return local.Format("Jan 2 15:04")
+	}
+	return local.Format("Jan 2, 2006")
+}
+
+// formatDuration renders a duration compactly, with up to the given number of its most significant units:
+// 3m4s or 2h5m for 2 units
+func formatDuration(d time.Duration, units int) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	parts := []struct {
+		unit time.Duration
+		name string
+	}{
+		{24 * time.Hour, "d"},
+		{time.Hour, "h"},
+		{time.Minute, "m"},
+		{time.Second, "s"},
+	}
+	out := ""
+	for _, p := range parts {
+		if units == 0 {
+			break
+		}
+		if d < p.unit {
+			if out != "" {
+				// Don't skip a unit between two shown ones: 1h rather than 1h5s
+				units--
+			}
+			continue
+		}
+		out += fmt.Sprintf("%d%s", d/p.unit, p.name)
+		d %= p.unit
+		units--
+	}
+	return out
+}
+
+// rfc3339 renders a timestamp for JSON output, empty when unset
+func rfc3339(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatTime(t *testing.T) {
+	now := time.Date(2025, 7, 15, 12, 0, 0, 0, time.Local)
+	tests := map[string]time.Time{
+		"-":            {},
+		"just now":     now.Add(-30 * time.Second),
+		"3m ago":       now.Add(-3*time.Minute - 20*time.Second),
+		"2h ago":       now.Add(-2*time.Hour - 59*time.Minute),
+		"6d ago":       now.Add(-6 * 24 * time.Hour),
+		"in 5m":        now.Add(5 * time.Minute),
+		"Jun 1 09:30":  time.Date(2025, 6, 1, 9, 30, 0, 0, time.Local),
+		"Dec 24, 2024": time.Date(2024, 12, 24, 9, 30, 0, 0, time.Local),
+	}
+	for expected, ts := range tests {
+		assert.Equal(t, expected, formatTime(ts, now))
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := map[time.Duration]string{
+		0:                                 "0s",
+		250 * time.Millisecond:            "250ms",
+		42 * time.Second:                  "42s",
+		3*time.Minute + 4*time.Second:     "3m4s",
+		2*time.Hour + 5*time.Minute + 10:  "2h5m",
+		time.Hour + 5*time.Second:         "1h",
+		50*time.Hour + 30*time.Minute + 1: "2d2h",
+	}
+	for d, expected := range tests {
+		assert.Equal(t, expected, formatDuration(d, 2), d.String())
+	}
+}
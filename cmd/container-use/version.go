@@ -59,6 +59,15 @@ var versionCmd = &cobra.Command{
 			} else {
 				cmd.Printf("  Container Runtime: not found\n")
 			}
+			if setup := detectRuntimeSetup(cmd.Context()); setup != nil {
+				cmd.Printf("  Runtime Setup: %s\n", setup)
+				if setup.Socket != "" && !socketReachable(setup.Socket) {
+					cmd.Printf("    %s is not reachable, start it with:\n", setup.Socket)
+					for _, command := range setup.remediation() {
+						cmd.Printf("      %s\n", command)
+					}
+				}
+			}
 
 			// Check Git
 			if version := getToolVersion(cmd.Context(), "git", "--version"); version != "" {
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// dashboardRefreshInterval is how often the dashboard reloads the environments
+	dashboardRefreshInterval = 2 * time.Second
+	// workingWithin is how recently an environment must have changed for an agent to be working in it
+	workingWithin = 2 * time.Minute
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Watch environment activity in real-time",
+	Long: `Display a dashboard of the environments of one or more repositories as agents work:
+their status, last command and services, refreshed every few seconds.
+Select an environment to open a shell in it, view its log or delete it.
+Use --git-log for the graph of the commits of the environments instead.
+Press q or Ctrl+C to stop watching.`,
+	Example: `# Watch the environments of the current repository
+container-use watch
+
+# Watch the environments of several repositories
+container-use watch --repo ~/src/api --repo ~/src/web
+
+# Watch the commits of all environments
+container-use watch --git-log`,
+	RunE: func(app *cobra.Command, _ []string) error {
+		ctx := app.Context()
+
+		paths, _ := app.Flags().GetStringSlice("repo")
+		repos := []*repository.Repository{}
+		for _, path := range paths {
+			repo, err := repository.Open(ctx, path)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			repos = append(repos, repo)
+		}
+
+		if gitLog, _ := app.Flags().GetBool("git-log"); gitLog {
+			return watchGitLog(ctx)
+		}
+
+		_, err := tea.NewProgram(dashboardModel{ctx: ctx, repos: repos}, tea.WithAltScreen(), tea.WithContext(ctx)).Run()
+		if err != nil && ctx.Err() != nil {
+			// Interrupted
+			return nil
+		}
+		return err
+	},
+}
+
+// dashboardRow is an environment of the dashboard
+type dashboardRow struct {
+	repo        *repository.Repository
+	env         *environment.EnvironmentInfo
+	lastCommand string
+}
+
+type dashboardModel struct {
+	ctx   context.Context
+	repos []*repository.Repository
+
+	rows   []dashboardRow
+	cursor int
+	width  int
+	// confirmDelete is the environment the user is asked whether to delete, as the rows move on refresh
+	confirmDelete *dashboardRow
+	message       string
+	err           error
+}
+
+type dashboardLoadedMsg struct {
+	rows []dashboardRow
+	err  error
+}
+
+type dashboardTickMsg struct{}
+
+// dashboardDoneMsg reports the end of an action on an environment
+type dashboardDoneMsg struct {
+	message string
+	err     error
+}
+
+func (m dashboardModel) Init() tea.Cmd {
+	return tea.Batch(m.load(), dashboardTick())
+}
+
+func dashboardTick() tea.Cmd {
+	return tea.Tick(dashboardRefreshInterval, func(time.Time) tea.Msg { return dashboardTickMsg{} })
+}
+
+// load reads the environments of the repositories, most recently updated first
+func (m dashboardModel) load() tea.Cmd {
+	return func() tea.Msg {
+		rows := []dashboardRow{}
+		for _, repo := range m.repos {
+			envs, err := repo.List(m.ctx)
+			if err != nil {
+				return dashboardLoadedMsg{err: err}
+			}
+			for _, env := range envs {
+				row := dashboardRow{repo: repo, env: env}
+				// Environments without activity notes, like older ones, show no last command
+				if page, err := repo.Activity(m.ctx, env.ID, repository.ActivityOpts{Kind: environment.ActivityCommand, Limit: 1}); err == nil && len(page.Entries) > 0 {
+					row.lastCommand = page.Entries[0].Summary
+				}
+				rows = append(rows, row)
+			}
+		}
+		slices.SortStableFunc(rows, func(a, b dashboardRow) int {
+			return b.env.State.UpdatedAt.Compare(a.env.State.UpdatedAt)
+		})
+		return dashboardLoadedMsg{rows: rows}
+	}
+}
+
+func (m dashboardModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+	case dashboardTickMsg:
+		return m, tea.Batch(m.load(), dashboardTick())
+	case dashboardLoadedMsg:
+		m.err = msg.err
+		if msg.err == nil {
+			m.rows = msg.rows
+			m.cursor = max(min(m.cursor, len(m.rows)-1), 0)
+		}
+	case dashboardDoneMsg:
+		m.message, m.err = msg.message, msg.err
+		return m, m.load()
+	case tea.KeyMsg:
+		return m.handleKey(msg)
+	}
+	return m, nil
+}
+
+func (m dashboardModel) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.confirmDelete != nil {
+		row := *m.confirmDelete
+		m.confirmDelete = nil
+		if msg.String() != "y" {
+			m.message = "Deletion cancelled"
+			return m, nil
+		}
+		m.message = fmt.Sprintf("Deleting %s...", row.env.ID)
+		return m, func() tea.Msg {
+			if err := row.repo.Delete(m.ctx, row.env.ID); err != nil {
+				return dashboardDoneMsg{err: err}
+			}
+			return dashboardDoneMsg{message: fmt.Sprintf("Deleted %s", row.env.ID)}
+		}
+	}
+
+	switch msg.String() {
+	case "ctrl+c", "q", "esc":
+		return m, tea.Quit
+	case "up", "k":
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case "down", "j":
+		if m.cursor < len(m.rows)-1 {
+			m.cursor++
+		}
+	case "r":
+		return m, m.load()
+	case "enter", "s", "l":
+		if len(m.rows) == 0 {
+			return m, nil
+		}
+		row := m.rows[m.cursor]
+		command := "terminal"
+		if msg.String() == "l" {
+			command = "log"
+		}
+		cmd, err := containerUseCommand(row.repo, command, row.env.ID)
+		if err != nil {
+			m.err = err
+			return m, nil
+		}
+		// The dashboard gives the terminal to the command until it exits
+		return m, tea.ExecProcess(cmd, func(err error) tea.Msg { return dashboardDoneMsg{err: err} })
+	case "d":
+		if len(m.rows) > 0 {
+			m.confirmDelete = &m.rows[m.cursor]
+			m.message = ""
+		}
+	}
+	return m, nil
+}
+
+// containerUseCommand runs this executable in a repository
+func containerUseCommand(repo *repository.Repository, args ...string) (*exec.Cmd, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(executable, args...)
+	cmd.Dir = repo.SourcePath()
+	return cmd, nil
+}
+
+func (m dashboardModel) View() string {
+	titleStyle := lipgloss.NewStyle().Bold(true)
+	selectedStyle := lipgloss.NewStyle().Reverse(true)
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#626262"))
+	errorStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5F5F"))
+
+	var s strings.Builder
+	s.WriteString(titleStyle.Render(fmt.Sprintf("Container Use: %d environments", len(m.rows))))
+	s.WriteString("\n\n")
+
+	multiRepo := len(m.repos) > 1
+	s.WriteString(titleStyle.Render(m.fit(dashboardHeader(multiRepo))))
+	s.WriteString("\n")
+	now := time.Now()
+	for i, row := range m.rows {
+		line := m.fit(dashboardLine(row, multiRepo, now))
+		if i == m.cursor {
+			line = selectedStyle.Render(line)
+		}
+		s.WriteString(line + "\n")
+	}
+	if len(m.rows) == 0 {
+		s.WriteString(dimStyle.Render("No environments yet") + "\n")
+	}
+
+	s.WriteString("\n")
+	switch {
+	case m.confirmDelete != nil:
+		s.WriteString(errorStyle.Render(fmt.Sprintf("Delete %s? (y/N)", m.confirmDelete.env.ID)))
+	case m.err != nil:
+		s.WriteString(errorStyle.Render("Error: " + m.err.Error()))
+	case m.message != "":
+		s.WriteString(m.message)
+	}
+	s.WriteString("\n")
+	s.WriteString(dimStyle.Render("↑/↓ select • enter shell • l log • d delete • r refresh • q quit"))
+	return s.String()
+}
+
+// fit cuts a line to the width of the terminal
+func (m dashboardModel) fit(line string) string {
+	if m.width <= 0 {
+		return line
+	}
+	return clip(line, m.width)
+}
+
+func dashboardHeader(multiRepo bool) string {
+	header := fmt.Sprintf("%-24s  %-30s  %-8s  %-12s  %-30s  %s", "ID", "TITLE", "STATUS", "UPDATED", "LAST COMMAND", "SERVICES")
+	if multiRepo {
+		header = fmt.Sprintf("%-16s  %s", "REPOSITORY", header)
+	}
+	return header
+}
+
+// dashboardLine renders an environment of the dashboard
+func dashboardLine(row dashboardRow, multiRepo bool, now time.Time) string {
+	state := row.env.State
+	status := "idle"
+	if now.Sub(state.UpdatedAt) < workingWithin {
+		status = "working"
+	}
+	services := []string{}
+	if state.Config != nil {
+		for _, svc := range state.Config.Services {
+			services = append(services, svc.Name)
+		}
+	}
+	services = append(services, state.BuiltServices...)
+
+	line := fmt.Sprintf("%-24s  %-30s  %-8s  %-12s  %-30s  %s",
+		clip(row.env.ID, 24),
+		clip(state.Title, 30),
+		status,
+		formatTime(state.UpdatedAt, now),
+		clip(strings.TrimPrefix(row.lastCommand, "$ "), 30),
+		strings.Join(services, ", "),
+	)
+	if multiRepo {
+		line = fmt.Sprintf("%-16s  %s", clip(filepath.Base(row.repo.SourcePath()), 16), line)
+	}
+	return line
+}
+
+// clip cuts a string to at most n runes, marking the cut with an ellipsis
+func clip(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+func init() {
+	watchCmd.Flags().StringSlice("repo", []string{"."}, "Repository to watch the environments of, repeatable")
+	watchCmd.Flags().Bool("git-log", false, "Watch the graph of the commits of the environments instead")
+	rootCmd.AddCommand(watchCmd)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/dagger/container-use/environment"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDashboardLine(t *testing.T) {
+	now := time.Date(2025, 7, 15, 12, 0, 0, 0, time.Local)
+	row := dashboardRow{
+		env: &environment.EnvironmentInfo{
+			ID: "fancy-mallard",
+			State: &environment.State{
+				Title:     "Add a login form with validation and tests",
+				UpdatedAt: now.Add(-30 * time.Second),
+				Config: &environment.EnvironmentConfig{
+					Services: environment.ServiceConfigs{{Name: "postgres"}},
+				},
+				BuiltServices: []string{"api"},
+			},
+		},
+		lastCommand: "$ go test ./...",
+	}
+
+	line := dashboardLine(row, false, now)
+	assert.Contains(t, line, "fancy-mallard")
+	assert.Contains(t, line, "Add a login form with validat…")
+	assert.Contains(t, line, "working")
+	assert.Contains(t, line, "go test ./...")
+	assert.Contains(t, line, "postgres, api")
+
+	row.env.State.UpdatedAt = now.Add(-time.Hour)
+	assert.Contains(t, dashboardLine(row, false, now), "idle")
+}
+
+func TestDashboardConfirmDelete(t *testing.T) {
+	rows := []dashboardRow{
+		{env: &environment.EnvironmentInfo{ID: "a", State: &environment.State{}}},
+		{env: &environment.EnvironmentInfo{ID: "b", State: &environment.State{}}},
+	}
+	var model tea.Model = dashboardModel{rows: rows}
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyDown})
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("d")})
+	require.NotNil(t, model.(dashboardModel).confirmDelete)
+	assert.Contains(t, model.View(), "Delete b? (y/N)")
+
+	model, cmd := model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("n")})
+	assert.Nil(t, cmd)
+	assert.Nil(t, model.(dashboardModel).confirmDelete)
+	assert.Contains(t, model.View(), "Deletion cancelled")
+}
@@ -13,54 +13,33 @@ import (
 	"time"
 
 	"golang.org/x/term"
-
-	"github.com/dagger/container-use/repository"
-	"github.com/spf13/cobra"
 )
 
-var watchCmd = &cobra.Command{
-	Use:   "watch",
-	Short: "Watch environment activity in real-time",
-	Long: `Continuously display environment activity as agents work.
-Shows new commits and environment changes updated every second.
-Press Ctrl+C to stop watching.`,
-	Example: `# Watch all environment activity
-container-use watch
-
-# Monitor agents while they work
-container-use watch`,
-	RunE: func(app *cobra.Command, _ []string) error {
-		ctx := app.Context()
-
-		// Ensure we're in a git repository
-		if _, err := repository.Open(ctx, "."); err != nil {
-			return err
-		}
-
-		// Enter alternate screen buffer and hide cursor
-		fmt.Print("\x1b[?1049h\x1b[?25l")
-		defer fmt.Print("\x1b[?25h\x1b[?1049l") // restore screen + show cursor
+// watchGitLog shows the graph of the commits of all environments, refreshed every second
+func watchGitLog(ctx context.Context) error {
+	// Enter alternate screen buffer and hide cursor
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l") // restore screen + show cursor
 
-		ticker := time.NewTicker(time.Second)
-		defer ticker.Stop()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 
-		// Run once immediately
-		if err := runGitLogWindows(ctx); err != nil {
-			return err
-		}
+	// Run once immediately
+	if err := runGitLogWindows(ctx); err != nil {
+		return err
+	}
 
-		for {
-			select {
-			case <-ctx.Done():
-				return nil
-			case <-ticker.C:
-				if err := runGitLogWindows(ctx); err != nil {
-					// Don't exit on git errors, just display them and continue
-					fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
-				}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := runGitLogWindows(ctx); err != nil {
+				// Don't exit on git errors, just display them and continue
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
 			}
 		}
-	},
+	}
 }
 
 // runGitLogWindows executes the git log command with output matching Unix watch format
@@ -148,7 +127,3 @@ func runGitLogWindows(ctx context.Context) error {
 
 	return nil
 }
-
-func init() {
-	rootCmd.AddCommand(watchCmd)
-}
@@ -0,0 +1,43 @@
+package environment
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Destructive operations of agents the user can require to approve
+const (
+	ApprovalFileDelete = "file_delete"
+	ApprovalCheckpoint = "checkpoint"
+	ApprovalMerge      = "merge"
+	ApprovalDelete     = "delete"
+)
+
+// ApprovalCommand is the operation of the commands the command policy requires confirmation for. The policy decides
+// which commands the user approves, so it isn't one of the ApprovalOperations.
+const ApprovalCommand = "command"
+
+// ApprovalOperations are the operations that can require approval
+var ApprovalOperations = []string{ApprovalFileDelete, ApprovalCheckpoint, ApprovalMerge, ApprovalDelete}
+
+// Approvals are the operations agents can only perform once the user approved them
+type Approvals []string
+
+// Validate checks the operations can require approval, once each
+func (approvals Approvals) Validate() error {
+	for i, op := range approvals {
+		if !slices.Contains(ApprovalOperations, op) {
+			return fmt.Errorf("invalid operation %q: expected %s", op, strings.Join(ApprovalOperations, ", "))
+		}
+		if slices.Contains(approvals[:i], op) {
+			return fmt.Errorf("operation %s requires approval several times", op)
+		}
+	}
+	return nil
+}
+
+// Requires tells whether the operation requires approval
+func (approvals Approvals) Requires(op string) bool {
+	return slices.Contains(approvals, op)
+}
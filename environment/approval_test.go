@@ -0,0 +1,28 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApprovals(t *testing.T) {
+	approvals := Approvals{ApprovalMerge, ApprovalDelete}
+	assert.NoError(t, approvals.Validate())
+	assert.True(t, approvals.Requires(ApprovalMerge))
+	assert.False(t, approvals.Requires(ApprovalFileDelete))
+
+	assert.Error(t, Approvals{"publish"}.Validate(), "unknown operations are rejected")
+	assert.Error(t, Approvals{ApprovalMerge, ApprovalMerge}.Validate(), "operations are listed once")
+
+	config := DefaultConfig()
+	config.Approvals = Approvals{ApprovalMerge}
+	other := DefaultConfig()
+	other.Approvals = Approvals{ApprovalMerge, ApprovalCheckpoint}
+	config.Union(other)
+	assert.Equal(t, Approvals{ApprovalMerge, ApprovalCheckpoint}, config.Approvals)
+
+	copied := config.Copy()
+	copied.Approvals[0] = ApprovalDelete
+	assert.Equal(t, ApprovalMerge, config.Approvals[0], "copies don't share approvals")
+}
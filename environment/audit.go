@@ -0,0 +1,62 @@
+package environment
+
+import (
+	"regexp"
+	"slices"
+)
+
+const (
+	EnvVarKindEnv    = "env"
+	EnvVarKindSecret = "secret"
+)
+
+// recordEnvUsage counts the configured env vars and secrets referenced by a command, by name.
+// Variables read by programs without being named in the command itself are not seen.
+func (env *Environment) recordEnvUsage(command string) {
+	config := env.State.Config
+	for _, key := range slices.Concat(config.Env.Keys(), config.Secrets.Keys()) {
+		if !referencesEnvVar(command, key) {
+			continue
+		}
+		if env.State.EnvUsage == nil {
+			env.State.EnvUsage = map[string]int{}
+		}
+		env.State.EnvUsage[key]++
+	}
+}
+
+func referencesEnvVar(command, name string) bool {
+	if name == "" {
+		return false
+	}
+	return regexp.MustCompile(`(^|[^A-Za-z0-9_])` + regexp.QuoteMeta(name) + `($|[^A-Za-z0-9_])`).MatchString(command)
+}
+
+// EnvVarUsage is the number of commands referencing a configured env var or secret
+type EnvVarUsage struct {
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	Commands int    `json:"commands"`
+}
+
+// AuditEnvUsage reports how many commands of the given environments referenced each env var and secret of the configuration.
+// Unreferenced variables come first: they are candidates for removal.
+func AuditEnvUsage(config *EnvironmentConfig, envs []*EnvironmentInfo) []EnvVarUsage {
+	usages := []EnvVarUsage{}
+	add := func(kind string, keys []string) {
+		for _, key := range keys {
+			usage := EnvVarUsage{Name: key, Kind: kind}
+			for _, env := range envs {
+				usage.Commands += env.State.EnvUsage[key]
+			}
+			usages = append(usages, usage)
+		}
+	}
+	add(EnvVarKindSecret, config.Secrets.Keys())
+	add(EnvVarKindEnv, config.Env.Keys())
+
+	slices.SortStableFunc(usages, func(a, b EnvVarUsage) int {
+		return a.Commands - b.Commands
+	})
+	return usages
+}
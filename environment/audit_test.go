@@ -0,0 +1,32 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvUsage(t *testing.T) {
+	env := newHostEnvironment(t, "env-audit")
+	config := env.State.Config
+	config.Env.Set("GOFLAGS", "-mod=mod")
+	config.Env.Set("PORT", "8080")
+	config.Secrets.Set("GITHUB_TOKEN", "env://GITHUB_TOKEN")
+	config.Secrets.Set("NPM_TOKEN", "env://NPM_TOKEN")
+
+	env.recordEnvUsage(`curl -H "Authorization: Bearer $GITHUB_TOKEN" https://api.github.com`)
+	env.recordEnvUsage(`echo ${GITHUB_TOKEN:-none} $PORT`)
+	env.recordEnvUsage(`python -c 'import os; print(os.environ["GOFLAGS"])'`)
+	env.recordEnvUsage(`echo $GITHUB_TOKEN_2 $MY_PORT PORTS`)
+	assert.Equal(t, map[string]int{"GITHUB_TOKEN": 2, "PORT": 1, "GOFLAGS": 1}, env.State.EnvUsage)
+
+	other := newHostEnvironment(t, "env-audit-other")
+	other.State.EnvUsage = map[string]int{"PORT": 3, "UNCONFIGURED": 1}
+
+	assert.Equal(t, []EnvVarUsage{
+		{Name: "NPM_TOKEN", Kind: EnvVarKindSecret, Commands: 0},
+		{Name: "GOFLAGS", Kind: EnvVarKindEnv, Commands: 1},
+		{Name: "GITHUB_TOKEN", Kind: EnvVarKindSecret, Commands: 2},
+		{Name: "PORT", Kind: EnvVarKindEnv, Commands: 4},
+	}, AuditEnvUsage(config, []*EnvironmentInfo{env.EnvironmentInfo, other.EnvironmentInfo}))
+}
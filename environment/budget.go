@@ -0,0 +1,127 @@
+package environment
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Budget caps the work of agents in an environment, as a brake on runaway autonomous sessions.
+// Zero values are unlimited.
+type Budget struct {
+	MaxToolCalls   int           `json:"max_tool_calls,omitempty"`
+	MaxCommandTime time.Duration `json:"max_command_time,omitempty"`
+}
+
+// BudgetUsage is the work done by agents in an environment so far
+type BudgetUsage struct {
+	ToolCalls   int           `json:"tool_calls"`
+	CommandTime time.Duration `json:"command_time"`
+}
+
+// BudgetExceededError is returned once an environment used up its budget.
+// Only users can extend the budget, agents must stop and ask them.
+type BudgetExceededError struct {
+	EnvironmentID string `json:"environment_id"`
+	// Exceeded is the limit reached, tool_calls or command_time
+	Exceeded string      `json:"exceeded"`
+	Budget   Budget      `json:"budget"`
+	Usage    BudgetUsage `json:"usage"`
+}
+
+func (e *BudgetExceededError) Error() string {
+	var used, flag string
+	switch e.Exceeded {
+	case "tool_calls":
+		used = fmt.Sprintf("%d tool calls", e.Budget.MaxToolCalls)
+		flag = "--tool-calls"
+	default:
+		used = fmt.Sprintf("%s of command time", e.Budget.MaxCommandTime)
+		flag = "--command-time"
+	}
+	return fmt.Sprintf("environment %s used up its budget of %s. Stop and ask the user to extend it with `container-use budget %s %s <limit>`", e.EnvironmentID, used, e.EnvironmentID, flag)
+}
+
+var (
+	// pendingToolCalls are the tool calls made since each environment was last saved, by budgetKey.
+	// Tool calls that don't change the environment are only recorded in its state by the next update.
+	pendingToolCalls   = map[string]int{}
+	pendingToolCallsMu sync.Mutex
+)
+
+// SetRepository sets the repository of the environment. Environment IDs are only unique within a repository:
+// a server serving several repositories keeps their pending tool calls apart with it.
+func (env *Environment) SetRepository(path string) {
+	env.repository = path
+}
+
+// budgetKey identifies the environment in pendingToolCalls
+func (env *Environment) budgetKey() string {
+	return env.repository + "\x00" + env.ID
+}
+
+// BudgetUsage returns the work done in the environment so far, including tool calls not saved yet
+func (env *Environment) BudgetUsage() BudgetUsage {
+	pendingToolCallsMu.Lock()
+	defer pendingToolCallsMu.Unlock()
+
+	return env.budgetUsage()
+}
+
+func (env *Environment) budgetUsage() BudgetUsage {
+	usage := env.State.BudgetUsage
+	usage.ToolCalls += pendingToolCalls[env.budgetKey()]
+	return usage
+}
+
+// ChargeToolCall counts a tool call against the budget of the environment.
+// It returns a *BudgetExceededError, without counting the call, once the budget is used up.
+// Only the pending tool calls change, not the state: calls reading the environment in parallel can charge it.
+func (env *Environment) ChargeToolCall() error {
+	pendingToolCallsMu.Lock()
+	defer pendingToolCallsMu.Unlock()
+
+	// The budget is checked and charged at once, so parallel calls can't both take its last call
+	if err := env.checkBudget(); err != nil {
+		return err
+	}
+	pendingToolCalls[env.budgetKey()]++
+	return nil
+}
+
+// checkBudget returns a *BudgetExceededError once the budget is used up. pendingToolCallsMu must be held.
+func (env *Environment) checkBudget() error {
+	budget := env.State.Budget
+	if budget == nil {
+		return nil
+	}
+	usage := env.budgetUsage()
+	exceeded := ""
+	switch {
+	case budget.MaxToolCalls > 0 && usage.ToolCalls >= budget.MaxToolCalls:
+		exceeded = "tool_calls"
+	case budget.MaxCommandTime > 0 && usage.CommandTime >= budget.MaxCommandTime:
+		exceeded = "command_time"
+	default:
+		return nil
+	}
+	return &BudgetExceededError{
+		EnvironmentID: env.ID,
+		Exceeded:      exceeded,
+		Budget:        *budget,
+		Usage:         usage,
+	}
+}
+
+// RecordToolCalls records the tool calls made so far in the state, so they outlive the server
+func (env *Environment) RecordToolCalls() {
+	pendingToolCallsMu.Lock()
+	defer pendingToolCallsMu.Unlock()
+
+	env.State.BudgetUsage.ToolCalls += pendingToolCalls[env.budgetKey()]
+	delete(pendingToolCalls, env.budgetKey())
+}
+
+func (env *Environment) chargeCommandTime(started time.Time) {
+	env.State.BudgetUsage.CommandTime += time.Since(started)
+}
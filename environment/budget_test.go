@@ -0,0 +1,75 @@
+package environment
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChargeToolCall(t *testing.T) {
+	env := newHostEnvironment(t, "env-budget-calls")
+	t.Cleanup(env.RecordToolCalls)
+
+	// Without a budget, tool calls are counted but never refused
+	require.NoError(t, env.ChargeToolCall())
+	assert.Equal(t, 1, env.BudgetUsage().ToolCalls)
+	assert.Equal(t, 0, env.State.BudgetUsage.ToolCalls, "pending until the environment is saved")
+
+	env.RecordToolCalls()
+	assert.Equal(t, 1, env.State.BudgetUsage.ToolCalls)
+	assert.Equal(t, 1, env.BudgetUsage().ToolCalls)
+
+	env.State.Budget = &Budget{MaxToolCalls: 3}
+	require.NoError(t, env.ChargeToolCall())
+	require.NoError(t, env.ChargeToolCall())
+
+	err := env.ChargeToolCall()
+	var budgetErr *BudgetExceededError
+	require.True(t, errors.As(err, &budgetErr))
+	assert.Equal(t, "tool_calls", budgetErr.Exceeded)
+	assert.Equal(t, BudgetUsage{ToolCalls: 3}, budgetErr.Usage)
+	assert.Contains(t, err.Error(), "container-use budget env-budget-calls --tool-calls")
+	assert.Equal(t, 3, env.BudgetUsage().ToolCalls, "refused calls are not counted")
+
+	// Extending the budget lets the agent go on
+	env.State.Budget.MaxToolCalls = 4
+	require.NoError(t, env.ChargeToolCall())
+}
+
+func TestPendingToolCallsByRepository(t *testing.T) {
+	// Environments of different repositories can have the same ID
+	env := newHostEnvironment(t, "env-budget-repos")
+	env.SetRepository("/repos/one")
+	other := newHostEnvironment(t, "env-budget-repos")
+	other.SetRepository("/repos/two")
+	t.Cleanup(env.RecordToolCalls)
+	t.Cleanup(other.RecordToolCalls)
+
+	require.NoError(t, env.ChargeToolCall())
+	require.NoError(t, env.ChargeToolCall())
+	require.NoError(t, other.ChargeToolCall())
+	assert.Equal(t, 2, env.BudgetUsage().ToolCalls)
+	assert.Equal(t, 1, other.BudgetUsage().ToolCalls)
+
+	other.RecordToolCalls()
+	assert.Equal(t, 2, env.BudgetUsage().ToolCalls, "recording the calls of one leaves the other pending")
+	assert.Equal(t, 0, env.State.BudgetUsage.ToolCalls)
+}
+
+func TestCommandTimeBudget(t *testing.T) {
+	env := newHostEnvironment(t, "env-budget-time")
+	t.Cleanup(env.RecordToolCalls)
+	env.State.Budget = &Budget{MaxCommandTime: 50 * time.Millisecond}
+
+	_, _, err := env.Run(t.Context(), "sleep 0.1", "sh", false)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, env.State.BudgetUsage.CommandTime, 100*time.Millisecond)
+
+	var budgetErr *BudgetExceededError
+	require.ErrorAs(t, env.ChargeToolCall(), &budgetErr)
+	assert.Equal(t, "command_time", budgetErr.Exceeded)
+	assert.Contains(t, budgetErr.Error(), "--command-time")
+}
@@ -0,0 +1,129 @@
+package environment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+type BuildImageOptions struct {
+	// Context is the build context directory, relative to the workdir. Defaults to the workdir.
+	Context string
+	// Dockerfile is the path of the Dockerfile, relative to the build context. Defaults to "Dockerfile".
+	Dockerfile string
+	Target     string
+	// BuildArgs are build arguments in the KEY=VALUE format
+	BuildArgs []string
+
+	// Service, if set, starts the built image as a service reachable from the environment
+	Service *ServiceConfig
+	// Destination, if set, publishes the built image to this address (e.g. registry.com/user/image:tag)
+	Destination string
+}
+
+type BuildImageResult struct {
+	Published string   `json:"published,omitempty"`
+	Service   *Service `json:"service,omitempty"`
+}
+
+// BuildImage builds a Dockerfile from the environment's workdir, and optionally
+// starts the result as a service of the environment and/or publishes it.
+func (env *Environment) BuildImage(ctx context.Context, explanation string, opts BuildImageOptions) (*BuildImageResult, error) {
+	if env.IsHost() {
+		return nil, fmt.Errorf("building images is not supported in host mode")
+	}
+	// Base images of the Dockerfile are pulled
+	if err := requireNetwork("building images"); err != nil {
+		return nil, err
+	}
+
+	if opts.Service != nil && env.hasService(opts.Service.Name) {
+		return nil, fmt.Errorf("service %s already exists", opts.Service.Name)
+	}
+
+	buildArgs := []dagger.BuildArg{}
+	for _, arg := range opts.BuildArgs {
+		k, v, found := strings.Cut(arg, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid build arg: %s", arg)
+		}
+		buildArgs = append(buildArgs, dagger.BuildArg{Name: k, Value: v})
+	}
+
+	buildContext := env.Workdir()
+	if opts.Context != "" && opts.Context != "." {
+		buildContext = buildContext.Directory(opts.Context)
+	}
+	dockerfile := opts.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+
+	contextDir := opts.Context
+	if contextDir == "" {
+		contextDir = "."
+	}
+	displayCommand := fmt.Sprintf("docker build -f %s %s", dockerfile, contextDir)
+	if err := env.checkCommand(ctx, displayCommand); err != nil {
+		return nil, err
+	}
+	image, err := buildContext.DockerBuild(dagger.DirectoryDockerBuildOpts{
+		Dockerfile: dockerfile,
+		Target:     opts.Target,
+		BuildArgs:  buildArgs,
+	}).Sync(ctx)
+	if err != nil {
+		var exitErr *dagger.ExecError
+		if errors.As(err, &exitErr) {
+			env.Notes.AddCommand(displayCommand, exitErr.ExitCode, exitErr.Stdout, exitErr.Stderr)
+			return nil, fmt.Errorf("build failed with exit code %d.\nstdout: %s\nstderr: %s", exitErr.ExitCode, exitErr.Stdout, exitErr.Stderr)
+		}
+		env.Notes.AddCommand(displayCommand, 1, "", err.Error())
+		return nil, fmt.Errorf("build failed: %w", err)
+	}
+	env.Notes.AddCommand(displayCommand, 0, "", "")
+
+	result := &BuildImageResult{}
+
+	if opts.Service != nil {
+		svc, err := env.startServiceFromContainer(ctx, opts.Service, image)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start service: %w", err)
+		}
+		// Built images can't be pulled again by reference, so the service is bound to the
+		// container state rather than recorded in the environment configuration.
+		// The binding survives reloads of the environment, but not configuration updates.
+		if err := env.apply(ctx, env.container().WithServiceBinding(opts.Service.Name, svc.svc)); err != nil {
+			return nil, err
+		}
+		env.Services = append(env.Services, svc)
+		env.State.BuiltServices = append(env.State.BuiltServices, opts.Service.Name)
+		env.Notes.Add("Start built image as service %s\n%s\n\n", opts.Service.Name, explanation)
+		result.Service = svc
+	}
+
+	if opts.Destination != "" {
+		ref, err := image.Publish(ctx, opts.Destination)
+		if err != nil {
+			return nil, fmt.Errorf("failed to publish image: %w", err)
+		}
+		env.Notes.Add("Publish built image to %s", ref)
+		result.Published = ref
+	}
+
+	return result, nil
+}
+
+// hasService reports whether a service with the given name is configured, running or bound to the container
+func (env *Environment) hasService(name string) bool {
+	if env.State.Config.Services.Get(name) != nil || slices.Contains(env.State.BuiltServices, name) {
+		return true
+	}
+	return slices.ContainsFunc(env.Services, func(svc *Service) bool {
+		return svc.Config.Name == name
+	})
+}
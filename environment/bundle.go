@@ -0,0 +1,99 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// Portable returns a copy of the state to import the environment on another machine. What only makes sense on this
+// one is left out: the container or pod, which only this dagger engine or cluster knows, the running processes and
+// services, and the cloud credentials.
+func (s *State) Portable() (*State, error) {
+	data, err := s.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	portable := &State{}
+	if err := portable.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	portable.Container = ""
+	portable.Pod = nil
+	portable.BackgroundProcesses = nil
+	portable.BackgroundServices = nil
+	portable.BuiltServices = nil
+	portable.CloudGrants = nil
+	return portable, nil
+}
+
+// DropHostAccess removes what gives access to the host from a configuration that comes from someone else, e.g. in a
+// bundle: secrets, resolved on the host from its files, variables or password managers, and host paths.
+// It returns what was removed, for the user to configure it again if they trust the configuration.
+func (config *EnvironmentConfig) DropHostAccess() []string {
+	var dropped []string
+	for _, key := range config.Secrets.Keys() {
+		dropped = append(dropped, "secret "+key)
+	}
+	for _, key := range config.PlanSecrets.Keys() {
+		dropped = append(dropped, "plan secret "+key)
+	}
+	for _, p := range config.HostPaths {
+		dropped = append(dropped, "host path "+p.Path)
+	}
+	config.Secrets = nil
+	config.PlanSecrets = nil
+	config.HostPaths = nil
+	return dropped
+}
+
+// Import creates an environment from the portable state of an environment exported from another machine.
+// Its container is the image the environment was exported with if any, with the source files, the environment's
+// branch, copied over its workdir. Else it is built again from the configuration and the source files.
+// Host environments run in their worktree as is, and Kubernetes environments always get a new pod.
+func Import(ctx context.Context, dag *dagger.Client, id string, state *State, image string, source *dagger.Directory) (*Environment, error) {
+	if err := state.Config.Validate(); err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "Importing environment", "id", id, "image", image)
+
+	host := strings.EqualFold(state.Config.BaseImage, "host")
+	if !host && (image == "" || state.Config.Kubernetes != nil) {
+		env, err := New(ctx, dag, id, state.Title, state.Config, source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build the environment: %w", err)
+		}
+		imported := *state
+		imported.Container = env.State.Container
+		imported.Pod = env.State.Pod
+		imported.UpdatedAt = env.State.UpdatedAt
+		env.State = &imported
+		env.Notes.Add("Import environment, rebuilt from its configuration")
+		return env, nil
+	}
+
+	env := &Environment{
+		EnvironmentInfo: &EnvironmentInfo{ID: id, State: state},
+		dag:             dag,
+	}
+	if env.IsHost() {
+		if err := env.applyHost(ctx); err != nil {
+			return nil, err
+		}
+		env.Notes.Add("Import environment")
+		return env, nil
+	}
+
+	container, err := checkpointContainer(ctx, dag, image)
+	if err != nil {
+		return nil, err
+	}
+	if err := env.apply(ctx, container.WithDirectory(state.Config.Workdir, source).WithWorkdir(state.Config.Workdir)); err != nil {
+		return nil, fmt.Errorf("failed to import image %s: %w", image, err)
+	}
+	env.Notes.AddSnapshot("Import environment from %s", image)
+	return env, nil
+}
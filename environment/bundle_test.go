@@ -0,0 +1,36 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatePortable(t *testing.T) {
+	state := &State{
+		Title:               "Add login",
+		Config:              &EnvironmentConfig{BaseImage: "golang:1.24", Workdir: "/workdir"},
+		Container:           "core.Container:abc",
+		BackgroundProcesses: []BackgroundProcess{{PID: 42, Command: "npm start"}},
+		BackgroundServices:  []BackgroundService{{ID: "happy-server", Command: "npm start"}},
+		BuiltServices:       []string{"api"},
+		CloudGrants:         []*CloudGrant{{Provider: "aws"}},
+		Checkpoints:         []Checkpoint{{Ref: "registry.example.com/app@sha256:01", Name: "deps"}},
+	}
+
+	portable, err := state.Portable()
+	require.NoError(t, err)
+	assert.Equal(t, "Add login", portable.Title)
+	assert.Equal(t, "golang:1.24", portable.Config.BaseImage)
+	assert.Equal(t, state.Checkpoints, portable.Checkpoints)
+	assert.Empty(t, portable.Container)
+	assert.Empty(t, portable.BackgroundProcesses)
+	assert.Empty(t, portable.BackgroundServices)
+	assert.Empty(t, portable.BuiltServices)
+	assert.Empty(t, portable.CloudGrants, "credentials stay on this machine")
+
+	portable.Config.BaseImage = "golang:1.25"
+	assert.Equal(t, "golang:1.24", state.Config.BaseImage, "the state is copied")
+	assert.Equal(t, "core.Container:abc", state.Container)
+}
@@ -0,0 +1,110 @@
+package environment
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// CacheMount is a Dagger cache volume mounted in the environment, to keep downloaded dependencies across environments.
+// Environments mounting caches with the same name share them.
+type CacheMount struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+type CacheMounts []CacheMount
+
+var cacheNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Validate checks the cache names and paths
+func (caches CacheMounts) Validate() error {
+	paths := map[string]bool{}
+	for _, c := range caches {
+		if !cacheNameRe.MatchString(c.Name) {
+			return fmt.Errorf("invalid cache name %q: use letters, digits, '.', '-' and '_'", c.Name)
+		}
+		if !strings.HasPrefix(c.Path, "/") {
+			return fmt.Errorf("invalid path %q of cache %s: must be absolute", c.Path, c.Name)
+		}
+		if paths[c.Path] {
+			return fmt.Errorf("path %s is mounted by several caches", c.Path)
+		}
+		paths[c.Path] = true
+	}
+	return nil
+}
+
+// Get returns the cache with the given name
+func (caches CacheMounts) Get(name string) *CacheMount {
+	for i := range caches {
+		if caches[i].Name == name {
+			return &caches[i]
+		}
+	}
+	return nil
+}
+
+// languageCache is the dependency cache of a language, and the env var pointing its tools at the cache
+type languageCache struct {
+	name   string
+	path   string
+	envVar string
+	// transient caches only speed up downloads and builds: tools don't need them afterwards
+	transient bool
+}
+
+// ProjectCacheKey identifies a project in the names of its cache volumes, so projects don't share caches.
+// It is made of the project directory name and a hash of its path.
+func ProjectCacheKey(projectDir string) string {
+	sum := sha256.Sum256([]byte(projectDir))
+	name := regexp.MustCompile(`[^A-Za-z0-9_.-]+`).ReplaceAllString(filepath.Base(projectDir), "-")
+	name = strings.Trim(name, "-.")
+	if name == "" {
+		name = "project"
+	}
+	return name + "-" + hex.EncodeToString(sum[:])[:8]
+}
+
+// AddDefaultCaches adds the dependency caches of the languages used in dir, named after the project, and
+// points the language tools at them. It does nothing if the configuration already declares caches or runs on the host.
+// It returns the names of the caches added.
+func (config *EnvironmentConfig) AddDefaultCaches(dir, projectKey string) []string {
+	// The env vars pointing at the caches would break the tools of the host
+	host := strings.EqualFold(config.BaseImage, "host") || os.Getenv("CONTAINER_USE_DEFAULT_HOST") == "1"
+	if len(config.Caches) > 0 || host {
+		return nil
+	}
+	added := []string{}
+	detected, _ := detectStacks(dir)
+	for _, lang := range detected {
+		for _, c := range lang.caches {
+			if c.envVar != "" && slices.Contains(config.Env.Keys(), c.envVar) {
+				// The user already chose where this cache lives
+				continue
+			}
+			name := projectKey + "-" + c.name
+			config.Caches = append(config.Caches, CacheMount{Name: name, Path: c.path})
+			if c.envVar != "" {
+				config.Env.Set(c.envVar, c.path)
+			}
+			added = append(added, name)
+		}
+	}
+	return added
+}
+
+// withCaches mounts the cache volumes of the configuration
+func (env *Environment) withCaches(container *dagger.Container) *dagger.Container {
+	for _, c := range env.State.Config.Caches {
+		container = container.WithMountedCache(c.Path, env.dag.CacheVolume("container-use-"+c.Name))
+	}
+	return container
+}
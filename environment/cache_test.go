@@ -0,0 +1,61 @@
+package environment
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheMountsValidate(t *testing.T) {
+	assert.NoError(t, CacheMounts{{Name: "go-mod", Path: "/cache/go-mod"}, {Name: "npm", Path: "/root/.npm"}}.Validate())
+	assert.Error(t, CacheMounts{{Name: "../escape", Path: "/cache"}}.Validate())
+	assert.Error(t, CacheMounts{{Name: "npm", Path: "relative"}}.Validate())
+	assert.Error(t, CacheMounts{{Name: "a", Path: "/cache"}, {Name: "b", Path: "/cache"}}.Validate())
+}
+
+func TestProjectCacheKey(t *testing.T) {
+	key := ProjectCacheKey("/home/user/My Project")
+	assert.Regexp(t, `^My-Project-[0-9a-f]{8}$`, key)
+	assert.Equal(t, key, ProjectCacheKey("/home/user/My Project"))
+	assert.NotEqual(t, key, ProjectCacheKey("/src/My Project"), "projects with the same name don't share caches")
+	assert.Regexp(t, `^project-[0-9a-f]{8}$`, ProjectCacheKey("/"))
+}
+
+func TestAddDefaultCaches(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/app\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "requirements.txt"), []byte("requests\n"), 0600))
+
+	config := DefaultConfig()
+	config.Env = KVList{"PIP_CACHE_DIR=/opt/pip"}
+	added := config.AddDefaultCaches(dir, "app-1234")
+	assert.Equal(t, []string{"app-1234-go-mod", "app-1234-go-build"}, added, "the user's pip cache is kept")
+	assert.Equal(t, CacheMounts{
+		{Name: "app-1234-go-mod", Path: "/cache/go-mod"},
+		{Name: "app-1234-go-build", Path: "/cache/go-build"},
+	}, config.Caches)
+	assert.Equal(t, "/cache/go-mod", config.Env.Get("GOMODCACHE"))
+	assert.Equal(t, "/opt/pip", config.Env.Get("PIP_CACHE_DIR"))
+	require.NoError(t, config.Caches.Validate())
+
+	// Configured caches are left alone
+	assert.Empty(t, config.AddDefaultCaches(dir, "app-1234"))
+
+	host := DefaultConfig()
+	host.BaseImage = "host"
+	assert.Empty(t, host.AddDefaultCaches(dir, "app-1234"))
+	assert.Empty(t, host.Caches)
+}
+
+func TestUnionCaches(t *testing.T) {
+	config := &EnvironmentConfig{Caches: CacheMounts{{Name: "npm", Path: "/cache/npm"}}}
+	conflicts := config.Union(&EnvironmentConfig{Caches: CacheMounts{
+		{Name: "npm", Path: "/root/.npm"},
+		{Name: "pip", Path: "/cache/pip"},
+	}})
+	assert.Equal(t, CacheMounts{{Name: "npm", Path: "/cache/npm"}, {Name: "pip", Path: "/cache/pip"}}, config.Caches)
+	assert.Equal(t, []string{`cache npm: keeping path "/cache/npm", ignoring path "/root/.npm"`}, conflicts)
+}
@@ -0,0 +1,201 @@
+package environment
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CatalogService is a preset service, with a healthcheck and the variables environments connect to it with
+type CatalogService struct {
+	Name        string
+	Description string
+	// Image is the image of the service without its tag, DefaultVersion the tag used when no version is asked for
+	Image          string
+	DefaultVersion string
+	// config returns the service reachable at host, and the variables of the environment to connect to it
+	config func(cfg *ServiceConfig, host string) KVList
+}
+
+// Config returns the configuration of the service at the given version (the default one if empty),
+// and the environment variables holding its address and credentials
+func (c *CatalogService) Config(version string) (*ServiceConfig, KVList) {
+	if version == "" {
+		version = c.DefaultVersion
+	}
+	cfg := &ServiceConfig{
+		Name:  c.Name,
+		Image: c.Image + ":" + version,
+	}
+	return cfg, c.config(cfg, cfg.Name)
+}
+
+var catalog = []*CatalogService{
+	{
+		Name:           "postgres",
+		Description:    "PostgreSQL database (user postgres, password postgres, database app)",
+		Image:          "postgres",
+		DefaultVersion: "17",
+		config: func(cfg *ServiceConfig, host string) KVList {
+			cfg.ExposedPorts = []int{5432}
+			cfg.Env = []string{"POSTGRES_USER=postgres", "POSTGRES_PASSWORD=postgres", "POSTGRES_DB=app"}
+			cfg.Healthcheck = &HealthcheckConfig{
+				Command:  "pg_isready -h " + host + " -U postgres -d app",
+				Interval: 2 * time.Second,
+				Retries:  15,
+			}
+			return KVList{
+				"DATABASE_URL=postgres://postgres:postgres@" + host + ":5432/app?sslmode=disable",
+				"PGHOST=" + host,
+				"PGPORT=5432",
+				"PGUSER=postgres",
+				"PGPASSWORD=postgres",
+				"PGDATABASE=app",
+			}
+		},
+	},
+	{
+		Name:           "mysql",
+		Description:    "MySQL database (user app, password app, database app, root password mysql)",
+		Image:          "mysql",
+		DefaultVersion: "8.4",
+		config: func(cfg *ServiceConfig, host string) KVList {
+			cfg.ExposedPorts = []int{3306}
+			cfg.Env = []string{"MYSQL_ROOT_PASSWORD=mysql", "MYSQL_DATABASE=app", "MYSQL_USER=app", "MYSQL_PASSWORD=app"}
+			cfg.Healthcheck = &HealthcheckConfig{
+				// The server is reachable on its socket before the network while it initializes: ping it through the network
+				Command:  "mysqladmin ping -h " + host + " -u app -papp --silent",
+				Interval: 2 * time.Second,
+				Retries:  30,
+			}
+			return KVList{
+				"DATABASE_URL=mysql://app:app@" + host + ":3306/app",
+				"MYSQL_HOST=" + host,
+				"MYSQL_PORT=3306",
+				"MYSQL_USER=app",
+				"MYSQL_PASSWORD=app",
+				"MYSQL_DATABASE=app",
+			}
+		},
+	},
+	{
+		Name:           "redis",
+		Description:    "Redis key-value store",
+		Image:          "redis",
+		DefaultVersion: "7",
+		config: func(cfg *ServiceConfig, host string) KVList {
+			cfg.ExposedPorts = []int{6379}
+			cfg.Healthcheck = &HealthcheckConfig{
+				Command:  "redis-cli -h " + host + " ping",
+				Interval: time.Second,
+				Retries:  15,
+			}
+			return KVList{
+				"REDIS_URL=redis://" + host + ":6379",
+				"REDIS_HOST=" + host,
+				"REDIS_PORT=6379",
+			}
+		},
+	},
+	{
+		Name:           "kafka",
+		Description:    "Kafka broker, single node in KRaft mode",
+		Image:          "apache/kafka",
+		DefaultVersion: "3.9.0",
+		config: func(cfg *ServiceConfig, host string) KVList {
+			cfg.ExposedPorts = []int{9092}
+			cfg.Env = []string{
+				"KAFKA_NODE_ID=1",
+				"KAFKA_PROCESS_ROLES=broker,controller",
+				"KAFKA_LISTENERS=PLAINTEXT://:9092,CONTROLLER://:9093",
+				// Clients connect to the address advertised by the broker: the service name
+				"KAFKA_ADVERTISED_LISTENERS=PLAINTEXT://" + host + ":9092",
+				"KAFKA_CONTROLLER_LISTENER_NAMES=CONTROLLER",
+				"KAFKA_LISTENER_SECURITY_PROTOCOL_MAP=CONTROLLER:PLAINTEXT,PLAINTEXT:PLAINTEXT",
+				"KAFKA_CONTROLLER_QUORUM_VOTERS=1@localhost:9093",
+				"KAFKA_OFFSETS_TOPIC_REPLICATION_FACTOR=1",
+				"KAFKA_TRANSACTION_STATE_LOG_REPLICATION_FACTOR=1",
+				"KAFKA_TRANSACTION_STATE_LOG_MIN_ISR=1",
+				"KAFKA_GROUP_INITIAL_REBALANCE_DELAY_MS=0",
+			}
+			cfg.Healthcheck = &HealthcheckConfig{
+				Command:  "/opt/kafka/bin/kafka-broker-api-versions.sh --bootstrap-server " + host + ":9092",
+				Interval: 3 * time.Second,
+				Timeout:  20 * time.Second,
+				Retries:  20,
+			}
+			return KVList{
+				"KAFKA_BOOTSTRAP_SERVERS=" + host + ":9092",
+			}
+		},
+	},
+	{
+		Name:           "minio",
+		Description:    "MinIO S3-compatible object storage (access key minioadmin, secret key minioadmin), console on port 9001",
+		Image:          "minio/minio",
+		DefaultVersion: "latest",
+		config: func(cfg *ServiceConfig, host string) KVList {
+			// The entrypoint of the image prepends `minio` to commands
+			cfg.Command = "minio server /data --console-address :9001"
+			cfg.NoEntrypoint = true
+			cfg.ExposedPorts = []int{9000, 9001}
+			cfg.Env = []string{"MINIO_ROOT_USER=minioadmin", "MINIO_ROOT_PASSWORD=minioadmin"}
+			cfg.Healthcheck = &HealthcheckConfig{
+				// Setting an alias checks the credentials against the server
+				Command:  "mc alias set local http://" + host + ":9000 minioadmin minioadmin",
+				Interval: 2 * time.Second,
+				Retries:  15,
+			}
+			return KVList{
+				"AWS_ENDPOINT_URL=http://" + host + ":9000",
+				"AWS_ACCESS_KEY_ID=minioadmin",
+				"AWS_SECRET_ACCESS_KEY=minioadmin",
+				"AWS_REGION=us-east-1",
+			}
+		},
+	},
+	{
+		Name:           "elasticsearch",
+		Description:    "Elasticsearch, single node without security",
+		Image:          "docker.elastic.co/elasticsearch/elasticsearch",
+		DefaultVersion: "8.15.3",
+		config: func(cfg *ServiceConfig, host string) KVList {
+			cfg.ExposedPorts = []int{9200}
+			cfg.Env = []string{
+				"discovery.type=single-node",
+				"xpack.security.enabled=false",
+				"ES_JAVA_OPTS=-Xms512m -Xmx512m",
+			}
+			cfg.Healthcheck = &HealthcheckConfig{
+				Command:  "curl -fs 'http://" + host + ":9200/_cluster/health?wait_for_status=yellow'",
+				Interval: 3 * time.Second,
+				Timeout:  20 * time.Second,
+				Retries:  30,
+			}
+			return KVList{
+				"ELASTICSEARCH_URL=http://" + host + ":9200",
+			}
+		},
+	},
+}
+
+// Catalog returns the preset services services can be added from
+func Catalog() []*CatalogService {
+	return slices.Clone(catalog)
+}
+
+// GetCatalogService returns the preset service of a `name[@version]` reference, and the version asked for
+func GetCatalogService(ref string) (*CatalogService, string, error) {
+	name, version, _ := strings.Cut(ref, "@")
+	for _, c := range catalog {
+		if c.Name == name {
+			return c, version, nil
+		}
+	}
+	names := []string{}
+	for _, c := range catalog {
+		names = append(names, c.Name)
+	}
+	return nil, "", fmt.Errorf("unknown service %q: available services are %s", name, strings.Join(names, ", "))
+}
@@ -0,0 +1,292 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+// Checkpoint records an image published from the environment
+type Checkpoint struct {
+	// Ref is the content addressed reference of the published image, or where it was saved for
+	// local daemons (docker://image:tag) and tarballs (oci:///path.tar)
+	Ref string `json:"ref"`
+	// Digest is the manifest digest of published images, and the image ID of images loaded in a local daemon
+	Digest string `json:"digest,omitempty"`
+	// SourceCommit is the commit of the environment branch the image was checkpointed at
+	SourceCommit string    `json:"source_commit,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	// Name identifies the checkpoint in its environment, for it to be restored. Unnamed checkpoints can't be.
+	Name string `json:"name,omitempty"`
+	// Parent is the named checkpoint the environment descended from when this one was taken: the previous named
+	// checkpoint of the environment, or the one the environment was restored from
+	Parent *CheckpointOrigin `json:"parent,omitempty"`
+}
+
+// CheckpointOrigin identifies a named checkpoint of an environment, linking checkpoints and environments in a lineage
+type CheckpointOrigin struct {
+	Environment string `json:"environment"`
+	Checkpoint  string `json:"checkpoint"`
+}
+
+func (o CheckpointOrigin) String() string {
+	return o.Environment + "/" + o.Checkpoint
+}
+
+var checkpointNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// FindCheckpoint returns the checkpoint of the environment with the given name
+func (s *State) FindCheckpoint(name string) *Checkpoint {
+	for i := range s.Checkpoints {
+		if s.Checkpoints[i].Name == name {
+			return &s.Checkpoints[i]
+		}
+	}
+	return nil
+}
+
+// checkpointParent is the parent of the next checkpoint of the environment
+func (env *Environment) checkpointParent() *CheckpointOrigin {
+	for i := len(env.State.Checkpoints) - 1; i >= 0; i-- {
+		if name := env.State.Checkpoints[i].Name; name != "" {
+			return &CheckpointOrigin{Environment: env.ID, Checkpoint: name}
+		}
+	}
+	return env.State.RestoredFrom
+}
+
+// checkpointAnnotationPrefix namespaces the annotations specific to container-use
+const checkpointAnnotationPrefix = "com.dagger.container-use."
+
+type annotation struct {
+	Name  string
+	Value string
+}
+
+// checkpointAnnotations trace published images back to the environment they were checkpointed from
+func (env *Environment) checkpointAnnotations(sourceCommit string, createdAt time.Time) []annotation {
+	annotations := []annotation{
+		{"org.opencontainers.image.created", createdAt.UTC().Format(time.RFC3339)},
+		{checkpointAnnotationPrefix + "environment", env.ID},
+		{checkpointAnnotationPrefix + "created-by", "container-use"},
+	}
+	if env.State.Title != "" {
+		annotations = append(annotations, annotation{"org.opencontainers.image.title", env.State.Title})
+	}
+	if sourceCommit != "" {
+		annotations = append(annotations, annotation{"org.opencontainers.image.revision", sourceCommit})
+	}
+	return annotations
+}
+
+// Checkpoint destinations other than registries
+const (
+	// CheckpointDockerScheme loads the image in the local Docker daemon, e.g. docker://my-app:latest
+	CheckpointDockerScheme = "docker://"
+	// CheckpointPodmanScheme loads the image in the local Podman store, e.g. podman://my-app:latest
+	CheckpointPodmanScheme = "podman://"
+	// CheckpointOCIScheme exports the image as an OCI tarball on disk, e.g. oci:///tmp/my-app.tar
+	CheckpointOCIScheme = "oci://"
+)
+
+// Checkpoint saves the container of the environment to target, annotated with the environment it comes from.
+// Target is a registry reference the image is published to, or a destination that needs no registry credentials:
+// the local Docker or Podman daemon (docker://image:tag, podman://image:tag) or an OCI tarball (oci://path.tar).
+// The checkpoint is recorded in the state of the environment, under name if not empty.
+func (env *Environment) Checkpoint(ctx context.Context, target, name, sourceCommit string) (*Checkpoint, error) {
+	if name != "" {
+		if !checkpointNameRe.MatchString(name) {
+			return nil, fmt.Errorf("invalid checkpoint name %q: use letters, digits, '.', '-' and '_'", name)
+		}
+		if env.State.FindCheckpoint(name) != nil {
+			return nil, fmt.Errorf("checkpoint %q already exists in environment %s", name, env.ID)
+		}
+	}
+	if env.IsHost() {
+		return nil, fmt.Errorf("checkpoint is not supported in host mode")
+	}
+
+	createdAt := time.Now()
+	container := env.container()
+	for _, a := range env.checkpointAnnotations(sourceCommit, createdAt) {
+		container = container.WithAnnotation(a.Name, a.Value)
+	}
+
+	checkpoint := Checkpoint{
+		SourceCommit: sourceCommit,
+		CreatedAt:    createdAt,
+		Name:         name,
+		Parent:       env.checkpointParent(),
+	}
+	var err error
+	switch {
+	case strings.HasPrefix(target, CheckpointOCIScheme):
+		checkpoint.Ref, err = exportCheckpoint(ctx, container, strings.TrimPrefix(target, CheckpointOCIScheme))
+	case strings.HasPrefix(target, CheckpointDockerScheme):
+		checkpoint.Ref, checkpoint.Digest, err = loadCheckpoint(ctx, container, "docker", strings.TrimPrefix(target, CheckpointDockerScheme))
+	case strings.HasPrefix(target, CheckpointPodmanScheme):
+		checkpoint.Ref, checkpoint.Digest, err = loadCheckpoint(ctx, container, "podman", strings.TrimPrefix(target, CheckpointPodmanScheme))
+	default:
+		if err := requireNetwork("publishing checkpoint " + target); err != nil {
+			return nil, err
+		}
+		checkpoint.Ref, err = container.Publish(ctx, target)
+		_, checkpoint.Digest, _ = strings.Cut(checkpoint.Ref, "@")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	env.State.Checkpoints = append(env.State.Checkpoints, checkpoint)
+	env.Notes.AddSnapshot("Checkpoint to %s", checkpoint.Ref)
+	return &checkpoint, nil
+}
+
+// Restore creates an environment from the container of a named checkpoint of another environment. Its worktree is
+// expected to be at the source commit of the checkpoint. Services aren't part of checkpoints: they aren't started.
+func Restore(ctx context.Context, dag *dagger.Client, id, title string, config *EnvironmentConfig, origin CheckpointOrigin, checkpoint *Checkpoint) (*Environment, error) {
+	if strings.EqualFold(config.BaseImage, "host") {
+		return nil, fmt.Errorf("checkpoints can't be restored in host mode")
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	container, err := checkpointContainer(ctx, dag, checkpoint.Ref)
+	if err != nil {
+		return nil, err
+	}
+	env := &Environment{
+		EnvironmentInfo: &EnvironmentInfo{
+			ID: id,
+			State: &State{
+				Config:       config,
+				Title:        title,
+				CreatedAt:    time.Now(),
+				UpdatedAt:    time.Now(),
+				RestoredFrom: &origin,
+			},
+		},
+		dag: dag,
+	}
+
+	slog.InfoContext(ctx, "Restoring environment", "id", env.ID, "checkpoint", origin.String(), "ref", checkpoint.Ref)
+	if err := env.apply(ctx, container.WithWorkdir(config.Workdir)); err != nil {
+		return nil, fmt.Errorf("failed to restore checkpoint %s: %w", origin, err)
+	}
+	env.Notes.AddSnapshot("Restore checkpoint %s", origin)
+	return env, nil
+}
+
+// checkpointContainer loads the container of a checkpoint from its reference
+func checkpointContainer(ctx context.Context, dag *dagger.Client, ref string) (*dagger.Container, error) {
+	switch {
+	case strings.HasPrefix(ref, CheckpointOCIScheme):
+		return dag.Container().Import(dag.Host().File(strings.TrimPrefix(ref, CheckpointOCIScheme))), nil
+	case strings.HasPrefix(ref, CheckpointDockerScheme):
+		return savedCheckpoint(ctx, dag, "docker", strings.TrimPrefix(ref, CheckpointDockerScheme))
+	case strings.HasPrefix(ref, CheckpointPodmanScheme):
+		return savedCheckpoint(ctx, dag, "podman", strings.TrimPrefix(ref, CheckpointPodmanScheme))
+	}
+	if err := requireNetwork("pulling checkpoint " + ref); err != nil {
+		return nil, err
+	}
+	return dag.Container().From(ref), nil
+}
+
+// savedCheckpoint loads the container of a checkpoint from the image store of a local container engine CLI
+func savedCheckpoint(ctx context.Context, dag *dagger.Client, cli, image string) (*dagger.Container, error) {
+	if _, err := exec.LookPath(cli); err != nil {
+		return nil, fmt.Errorf("%s is required to restore checkpoints from its image store: %w", cli, err)
+	}
+	tmp, err := os.MkdirTemp("", "container-use-checkpoint-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	tarball := filepath.Join(tmp, "image.tar")
+	if out, err := exec.CommandContext(ctx, cli, "save", "-o", tarball, image).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s save failed: %w: %s", cli, err, strings.TrimSpace(string(out)))
+	}
+	// The tarball is removed once loaded: sync the container before returning
+	container, err := dag.Container().Import(dag.Host().File(tarball)).Sync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import checkpoint %s: %w", image, err)
+	}
+	return container, nil
+}
+
+// exportCheckpoint writes the container as an OCI tarball to path, on the host
+func exportCheckpoint(ctx context.Context, container *dagger.Container, path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("missing path of the OCI tarball, e.g. %s/tmp/image.tar", CheckpointOCIScheme)
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := container.Export(ctx, path); err != nil {
+		return "", fmt.Errorf("failed to export checkpoint to %s: %w", path, err)
+	}
+	return CheckpointOCIScheme + path, nil
+}
+
+// loadCheckpoint loads the container in the image store of a local container engine CLI (docker or podman),
+// tagged as image. It returns the reference of the checkpoint and the ID of the loaded image.
+func loadCheckpoint(ctx context.Context, container *dagger.Container, cli, image string) (string, string, error) {
+	if image == "" {
+		return "", "", fmt.Errorf("missing image name, e.g. %smy-app:latest", cli+"://")
+	}
+	if _, err := exec.LookPath(cli); err != nil {
+		return "", "", fmt.Errorf("%s is required to load checkpoints in its image store: %w", cli, err)
+	}
+
+	tmp, err := os.MkdirTemp("", "container-use-checkpoint-")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(tmp)
+	tarball := filepath.Join(tmp, "image.tar")
+	// Docker only loads OCI tarballs since 25.0
+	if _, err := container.Export(ctx, tarball, dagger.ContainerExportOpts{MediaTypes: dagger.ImageMediaTypesDockerMediaTypes}); err != nil {
+		return "", "", fmt.Errorf("failed to export checkpoint: %w", err)
+	}
+
+	out, err := exec.CommandContext(ctx, cli, "load", "-i", tarball).CombinedOutput()
+	if err != nil {
+		return "", "", fmt.Errorf("%s load failed: %w: %s", cli, err, strings.TrimSpace(string(out)))
+	}
+	id, err := parseLoadedImage(string(out))
+	if err != nil {
+		return "", "", fmt.Errorf("%s load: %w", cli, err)
+	}
+	if out, err := exec.CommandContext(ctx, cli, "tag", id, image).CombinedOutput(); err != nil {
+		return "", "", fmt.Errorf("%s tag failed: %w: %s", cli, err, strings.TrimSpace(string(out)))
+	}
+	return cli + "://" + image, id, nil
+}
+
+// parseLoadedImage returns the image loaded by `docker load` ("Loaded image ID: sha256:...")
+// or `podman load` ("Loaded image: sha256:..."): untagged images are loaded by ID.
+func parseLoadedImage(output string) (string, error) {
+	loaded := ""
+	for line := range strings.SplitSeq(output, "\n") {
+		if !strings.HasPrefix(line, "Loaded image") {
+			continue
+		}
+		if _, image, ok := strings.Cut(line, ": "); ok {
+			loaded = strings.TrimSpace(image)
+		}
+	}
+	if loaded == "" {
+		return "", fmt.Errorf("no image loaded: %s", strings.TrimSpace(output))
+	}
+	return loaded, nil
+}
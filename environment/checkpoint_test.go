@@ -0,0 +1,63 @@
+package environment
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpointAnnotations(t *testing.T) {
+	env := newHostEnvironment(t, "fancy-mallard")
+	createdAt := time.Date(2025, 6, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	assert.Equal(t, []annotation{
+		{"org.opencontainers.image.created", "2025-06-01T10:00:00Z"},
+		{"com.dagger.container-use.environment", "fancy-mallard"},
+		{"com.dagger.container-use.created-by", "container-use"},
+	}, env.checkpointAnnotations("", createdAt))
+
+	env.State.Title = "Add login page"
+	annotations := env.checkpointAnnotations("0123abcd", createdAt)
+	assert.Contains(t, annotations, annotation{"org.opencontainers.image.title", "Add login page"})
+	assert.Contains(t, annotations, annotation{"org.opencontainers.image.revision", "0123abcd"})
+
+	_, err := env.Checkpoint(context.Background(), "registry.example.com/app:latest", "", "0123abcd")
+	assert.Error(t, err, "host environments have no container to checkpoint")
+	assert.Empty(t, env.State.Checkpoints)
+}
+
+func TestCheckpointLineage(t *testing.T) {
+	env := newHostEnvironment(t, "fancy-mallard")
+	assert.Nil(t, env.checkpointParent())
+
+	env.State.RestoredFrom = &CheckpointOrigin{Environment: "calm-owl", Checkpoint: "deps"}
+	assert.Equal(t, &CheckpointOrigin{Environment: "calm-owl", Checkpoint: "deps"}, env.checkpointParent())
+
+	env.State.Checkpoints = []Checkpoint{{Ref: "registry.example.com/app@sha256:01", Name: "green"}, {Ref: "registry.example.com/app@sha256:02"}}
+	assert.Equal(t, &CheckpointOrigin{Environment: "fancy-mallard", Checkpoint: "green"}, env.checkpointParent(), "unnamed checkpoints are skipped")
+	assert.Equal(t, "registry.example.com/app@sha256:01", env.State.FindCheckpoint("green").Ref)
+	assert.Nil(t, env.State.FindCheckpoint("red"))
+
+	_, err := env.Checkpoint(context.Background(), "registry.example.com/app:latest", "green", "")
+	assert.ErrorContains(t, err, "already exists")
+	_, err = env.Checkpoint(context.Background(), "registry.example.com/app:latest", "tests green", "")
+	assert.ErrorContains(t, err, "invalid checkpoint name")
+}
+
+func TestParseLoadedImage(t *testing.T) {
+	tests := map[string]string{
+		"Loaded image ID: sha256:0123abcd\n":                           "sha256:0123abcd",
+		"Getting image source signatures\nLoaded image: sha256:4567\n": "sha256:4567",
+		"Loaded image: localhost/app:latest\n":                         "localhost/app:latest",
+	}
+	for output, expected := range tests {
+		image, err := parseLoadedImage(output)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, image)
+	}
+
+	_, err := parseLoadedImage("open image.tar: no such file or directory")
+	assert.Error(t, err)
+}
@@ -0,0 +1,231 @@
+package environment
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+const (
+	CloudAWS = "aws"
+	CloudGCP = "gcp"
+
+	defaultCloudGrantDuration = 15 * time.Minute
+	maxCloudGrantDuration     = time.Hour
+	// minAWSGrantDuration is the shortest session AWS STS issues
+	minAWSGrantDuration = 15 * time.Minute
+)
+
+// CloudGrant is short-lived cloud credentials granted to an environment, set in the environment variables of the
+// commands it runs until they expire. The credentials are minted on the host with the CLI of the cloud and are
+// only held in memory: the state records the grant and its expiry.
+type CloudGrant struct {
+	// Provider is aws or gcp
+	Provider string `json:"provider"`
+	// Role is the ARN of the AWS role to assume, or the email of the GCP service account to impersonate
+	Role string `json:"role"`
+	// Policy is an AWS session policy, narrowing the permissions of the role
+	Policy string `json:"policy,omitempty"`
+	// Scopes are the OAuth scopes of GCP access tokens
+	Scopes    []string  `json:"scopes,omitempty"`
+	GrantedAt time.Time `json:"granted_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Variables are the environment variables holding the credentials
+	Variables []string `json:"variables"`
+}
+
+// cloudMinters mint the credentials of a grant, as the values of its variables, valid for the given duration
+var cloudMinters = map[string]func(ctx context.Context, envID string, grant *CloudGrant, duration time.Duration) (map[string]string, error){
+	CloudAWS: mintAWSCredentials,
+	CloudGCP: mintGCPCredentials,
+}
+
+// cloudCredentials caches the minted credentials of grants, by environment and role
+var cloudCredentials = struct {
+	sync.Mutex
+	values map[string]map[string]string
+}{values: map[string]map[string]string{}}
+
+func cloudCredentialsKey(envID string, grant *CloudGrant) string {
+	return envID + "\x00" + grant.Provider + "\x00" + grant.Role
+}
+
+// GrantCloudAccess mints credentials of a cloud role for the commands of the environment, replacing any previous
+// grant of the role. A zero duration is the default of 15 minutes.
+func (env *Environment) GrantCloudAccess(ctx context.Context, grant CloudGrant, duration time.Duration) (*CloudGrant, error) {
+	mint, ok := cloudMinters[grant.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unsupported cloud %q: expected aws or gcp", grant.Provider)
+	}
+	if grant.Role == "" {
+		return nil, fmt.Errorf("no role to grant access to")
+	}
+	if duration == 0 {
+		duration = defaultCloudGrantDuration
+	}
+	switch {
+	case duration > maxCloudGrantDuration:
+		return nil, fmt.Errorf("duration %s exceeds the maximum of %s", duration, maxCloudGrantDuration)
+	case grant.Provider == CloudAWS && duration < minAWSGrantDuration:
+		return nil, fmt.Errorf("duration %s is below the minimum of AWS STS, %s", duration, minAWSGrantDuration)
+	case duration < time.Minute:
+		return nil, fmt.Errorf("duration %s is below the minimum of 1m", duration)
+	}
+
+	values, err := mint(ctx, env.ID, &grant, duration)
+	if err != nil {
+		return nil, err
+	}
+	grant.GrantedAt = time.Now()
+	grant.ExpiresAt = grant.GrantedAt.Add(duration)
+	grant.Variables = slices.Sorted(maps.Keys(values))
+
+	cloudCredentials.Lock()
+	cloudCredentials.values[cloudCredentialsKey(env.ID, &grant)] = values
+	cloudCredentials.Unlock()
+
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.State.CloudGrants = slices.DeleteFunc(env.State.CloudGrants, func(g *CloudGrant) bool {
+		return g.Provider == grant.Provider && g.Role == grant.Role
+	})
+	env.State.CloudGrants = append(env.State.CloudGrants, &grant)
+	return &grant, nil
+}
+
+// cloudCredentialValues returns the variables of the grants of the environment that haven't expired, and forgets
+// expired grants. Credentials lost with the process that minted them are minted again for the rest of the grant.
+func (env *Environment) cloudCredentialValues(ctx context.Context) (map[string]string, error) {
+	env.mu.Lock()
+	now := time.Now()
+	env.State.CloudGrants = slices.DeleteFunc(env.State.CloudGrants, func(g *CloudGrant) bool {
+		if now.Before(g.ExpiresAt) {
+			return false
+		}
+		cloudCredentials.Lock()
+		delete(cloudCredentials.values, cloudCredentialsKey(env.ID, g))
+		cloudCredentials.Unlock()
+		return true
+	})
+	grants := slices.Clone(env.State.CloudGrants)
+	env.mu.Unlock()
+
+	values := map[string]string{}
+	for _, grant := range grants {
+		key := cloudCredentialsKey(env.ID, grant)
+		cloudCredentials.Lock()
+		credentials, ok := cloudCredentials.values[key]
+		cloudCredentials.Unlock()
+		if !ok {
+			remaining := time.Until(grant.ExpiresAt).Truncate(time.Second)
+			if grant.Provider == CloudAWS && remaining < minAWSGrantDuration {
+				// Minting again would outlive the grant
+				continue
+			}
+			mint, ok := cloudMinters[grant.Provider]
+			if !ok {
+				continue
+			}
+			var err error
+			if credentials, err = mint(ctx, env.ID, grant, remaining); err != nil {
+				return nil, err
+			}
+			cloudCredentials.Lock()
+			cloudCredentials.values[key] = credentials
+			cloudCredentials.Unlock()
+		}
+		maps.Copy(values, credentials)
+	}
+	return values, nil
+}
+
+// withCloudCredentials sets the credentials of the grants of the environment as secret variables of a container
+func (env *Environment) withCloudCredentials(ctx context.Context, container *dagger.Container) (*dagger.Container, []string, error) {
+	values, err := env.cloudCredentialValues(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := []string{}
+	for name, value := range values {
+		// Names are derived from the value, as credentials minted again must not reuse the secret of the previous ones
+		sum := sha256.Sum256([]byte(env.ID + "\x00" + name + "\x00" + value))
+		container = container.WithSecretVariable(name, env.dag.SetSecret("container-use-cloud-"+hex.EncodeToString(sum[:8]), value))
+		names = append(names, name)
+	}
+	return container, names, nil
+}
+
+// mintAWSCredentials assumes the role with the AWS CLI, which must be authenticated on the host
+func mintAWSCredentials(ctx context.Context, envID string, grant *CloudGrant, duration time.Duration) (map[string]string, error) {
+	args := []string{"sts", "assume-role",
+		"--role-arn", grant.Role,
+		"--role-session-name", "container-use-" + envID,
+		"--duration-seconds", strconv.Itoa(int(duration.Seconds())),
+		"--output", "json",
+	}
+	if grant.Policy != "" {
+		args = append(args, "--policy", grant.Policy)
+	}
+	out, err := runSecretCommand(ctx, "aws", args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseAWSCredentials(out)
+}
+
+// parseAWSCredentials reads the output of aws sts assume-role
+func parseAWSCredentials(out string) (map[string]string, error) {
+	var result struct {
+		Credentials struct {
+			AccessKeyID     string `json:"AccessKeyId"`
+			SecretAccessKey string `json:"SecretAccessKey"`
+			SessionToken    string `json:"SessionToken"`
+		} `json:"Credentials"`
+	}
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		return nil, fmt.Errorf("invalid output of aws sts assume-role: %w", err)
+	}
+	credentials := result.Credentials
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" || credentials.SessionToken == "" {
+		return nil, fmt.Errorf("aws sts assume-role returned no credentials")
+	}
+	return map[string]string{
+		"AWS_ACCESS_KEY_ID":     credentials.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY": credentials.SecretAccessKey,
+		"AWS_SESSION_TOKEN":     credentials.SessionToken,
+	}, nil
+}
+
+// mintGCPCredentials impersonates the service account with gcloud, which must be authenticated on the host.
+// The access token is set for gcloud and the client libraries reading GOOGLE_OAUTH_ACCESS_TOKEN.
+func mintGCPCredentials(ctx context.Context, _ string, grant *CloudGrant, duration time.Duration) (map[string]string, error) {
+	args := []string{"auth", "print-access-token",
+		"--impersonate-service-account=" + grant.Role,
+		"--lifetime=" + strconv.Itoa(int(duration.Seconds())) + "s",
+	}
+	if len(grant.Scopes) > 0 {
+		args = append(args, "--scopes="+strings.Join(grant.Scopes, ","))
+	}
+	token, err := runSecretCommand(ctx, "gcloud", args...)
+	if err != nil {
+		return nil, err
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, fmt.Errorf("gcloud returned no access token")
+	}
+	return map[string]string{
+		"CLOUDSDK_AUTH_ACCESS_TOKEN": token,
+		"GOOGLE_OAUTH_ACCESS_TOKEN":  token,
+	}, nil
+}
@@ -0,0 +1,65 @@
+package environment
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAWSCredentials(t *testing.T) {
+	credentials, err := parseAWSCredentials(`{"Credentials": {"AccessKeyId": "ASIA1", "SecretAccessKey": "secret", "SessionToken": "token", "Expiration": "2025-07-15T12:15:00+00:00"}}`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"AWS_ACCESS_KEY_ID":     "ASIA1",
+		"AWS_SECRET_ACCESS_KEY": "secret",
+		"AWS_SESSION_TOKEN":     "token",
+	}, credentials)
+
+	_, err = parseAWSCredentials(`{}`)
+	assert.Error(t, err)
+}
+
+func TestGrantCloudAccess(t *testing.T) {
+	ctx := context.Background()
+	mints := 0
+	previous := cloudMinters[CloudGCP]
+	cloudMinters[CloudGCP] = func(_ context.Context, _ string, grant *CloudGrant, duration time.Duration) (map[string]string, error) {
+		mints++
+		return map[string]string{"CLOUDSDK_AUTH_ACCESS_TOKEN": grant.Role + "-token"}, nil
+	}
+	t.Cleanup(func() { cloudMinters[CloudGCP] = previous })
+
+	env := &Environment{EnvironmentInfo: &EnvironmentInfo{ID: "grant-test", State: &State{}}}
+	_, err := env.GrantCloudAccess(ctx, CloudGrant{Provider: "azure", Role: "reader"}, 0)
+	assert.Error(t, err)
+	_, err = env.GrantCloudAccess(ctx, CloudGrant{Provider: CloudAWS, Role: "arn:aws:iam::1:role/deploy"}, time.Minute)
+	assert.Error(t, err, "AWS STS doesn't issue sessions this short")
+
+	grant, err := env.GrantCloudAccess(ctx, CloudGrant{Provider: CloudGCP, Role: "deployer@project.iam.gserviceaccount.com"}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"CLOUDSDK_AUTH_ACCESS_TOKEN"}, grant.Variables)
+	assert.WithinDuration(t, time.Now().Add(defaultCloudGrantDuration), grant.ExpiresAt, time.Minute)
+	assert.Len(t, env.State.CloudGrants, 1)
+
+	values, err := env.cloudCredentialValues(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"CLOUDSDK_AUTH_ACCESS_TOKEN": "deployer@project.iam.gserviceaccount.com-token"}, values)
+	assert.Equal(t, 1, mints, "credentials are minted once")
+
+	// Credentials lost with the server are minted again
+	cloudCredentials.Lock()
+	delete(cloudCredentials.values, cloudCredentialsKey(env.ID, grant))
+	cloudCredentials.Unlock()
+	_, err = env.cloudCredentialValues(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, mints)
+
+	grant.ExpiresAt = time.Now().Add(-time.Second)
+	values, err = env.cloudCredentialValues(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, values)
+	assert.Empty(t, env.State.CloudGrants, "expired grants are forgotten")
+}
@@ -0,0 +1,176 @@
+package environment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// commandPolicyFile is the command policy of a project, in its container-use configuration directory
+const commandPolicyFile = "policy.yaml"
+
+// Rules of the command policy a command can break
+const (
+	CommandPolicyDeny    = "deny"
+	CommandPolicyAllow   = "allow"
+	CommandPolicyConfirm = "confirm"
+)
+
+// CommandPolicy lists the commands agents may run, as regular expressions searched in the command line: anchor them
+// with ^ and $ to match it whole. Denied commands are refused, then commands must match an allowed pattern when there are some, and commands
+// requiring confirmation only run once the user confirmed them.
+type CommandPolicy struct {
+	Allow   []string `yaml:"allow,omitempty"`
+	Deny    []string `yaml:"deny,omitempty"`
+	Confirm []string `yaml:"confirm,omitempty"`
+
+	allow, deny, confirm []*regexp.Regexp
+}
+
+// CommandPolicyError is returned for the commands the policy doesn't let run
+type CommandPolicyError struct {
+	Command string `json:"command"`
+	// Rule is the rule the command breaks: deny, allow or confirm
+	Rule string `json:"rule"`
+	// Pattern is the pattern matched by denied commands and commands requiring confirmation, empty for interactive shells
+	Pattern string `json:"pattern,omitempty"`
+}
+
+func (e *CommandPolicyError) Error() string {
+	switch e.Rule {
+	case CommandPolicyDeny:
+		return fmt.Sprintf("command denied by the policy of the repository (matches %q): don't try to work around it, ask the user", e.Pattern)
+	case CommandPolicyConfirm:
+		if e.Pattern == "" {
+			return "interactive shells require confirmation under the command policy of the repository and the user didn't confirm it: ask the user"
+		}
+		return fmt.Sprintf("command requires confirmation by the policy of the repository (matches %q) and the user didn't confirm it: don't try to work around it, ask the user", e.Pattern)
+	}
+	return "command not allowed by the policy of the repository: only commands matching its allow list can run, ask the user"
+}
+
+// LoadCommandPolicy loads the command policy of the project in baseDir. Projects without one have a nil policy.
+func LoadCommandPolicy(baseDir string) (*CommandPolicy, error) {
+	data, err := os.ReadFile(filepath.Join(baseDir, configDir, commandPolicyFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return ParseCommandPolicy(data)
+}
+
+// ParseCommandPolicy parses a command policy, returning an *InvalidConfigError for every invalid pattern
+func ParseCommandPolicy(data []byte) (*CommandPolicy, error) {
+	policy := &CommandPolicy{}
+	if err := yaml.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("invalid command policy %s: %w", commandPolicyFile, err)
+	}
+
+	var errs []ConfigError
+	compile := func(field string, patterns []string) []*regexp.Regexp {
+		compiled := make([]*regexp.Regexp, 0, len(patterns))
+		for i, pattern := range patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				errs = append(errs, ConfigError{Field: fmt.Sprintf("%s[%d]", field, i), Message: err.Error()})
+				continue
+			}
+			compiled = append(compiled, re)
+		}
+		return compiled
+	}
+	policy.allow = compile("allow", policy.Allow)
+	policy.deny = compile("deny", policy.Deny)
+	policy.confirm = compile("confirm", policy.Confirm)
+	if len(errs) > 0 {
+		return nil, &InvalidConfigError{Errors: errs}
+	}
+	return policy, nil
+}
+
+// Check returns a *CommandPolicyError if the policy doesn't let the command run, with the confirm rule for the
+// commands that only run once the user confirmed them
+func (p *CommandPolicy) Check(command string) error {
+	return p.check(command, true)
+}
+
+// check checks the command like Check, only enforcing the allow list with allowList
+func (p *CommandPolicy) check(command string, allowList bool) error {
+	if p == nil {
+		return nil
+	}
+	for i, re := range p.deny {
+		if re.MatchString(command) {
+			return &CommandPolicyError{Command: command, Rule: CommandPolicyDeny, Pattern: p.Deny[i]}
+		}
+	}
+	if allowList && len(p.allow) > 0 && !matchesAny(p.allow, command) {
+		return &CommandPolicyError{Command: command, Rule: CommandPolicyAllow}
+	}
+	for i, re := range p.confirm {
+		if re.MatchString(command) {
+			return &CommandPolicyError{Command: command, Rule: CommandPolicyConfirm, Pattern: p.Confirm[i]}
+		}
+	}
+	return nil
+}
+
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// CommandConfirmer asks the user to confirm a command the policy requires confirmation for, returning an error
+// unless they confirmed it
+type CommandConfirmer func(ctx context.Context, command string) error
+
+// SetCommandPolicy enforces the command policy on the commands run in the environment, whatever runs them.
+// Commands requiring confirmation run once confirm returns no error; without confirm, they are refused.
+func (env *Environment) SetCommandPolicy(policy *CommandPolicy, confirm CommandConfirmer) {
+	env.policy = policy
+	env.confirmCommand = confirm
+}
+
+// checkCommand returns an error unless the command policy lets the command run, asking the user to confirm it when
+// the policy requires it
+func (env *Environment) checkCommand(ctx context.Context, command string) error {
+	if strings.TrimSpace(command) == "" {
+		return nil
+	}
+	return env.confirmPolicy(ctx, env.policy.Check(command))
+}
+
+// checkReply checks a reply to an interactive job like a command, except for the allow list: replies to prompts are
+// seldom commands, but shells read them as such
+func (env *Environment) checkReply(ctx context.Context, reply string) error {
+	return env.confirmPolicy(ctx, env.policy.check(reply, false))
+}
+
+// checkShell lets an interactive shell start. The commands typed in it can't be checked one by one: under a command
+// policy, the user must confirm the shell itself.
+func (env *Environment) checkShell(ctx context.Context, shell string) error {
+	if env.policy == nil {
+		return nil
+	}
+	return env.confirmPolicy(ctx, &CommandPolicyError{Command: shell, Rule: CommandPolicyConfirm})
+}
+
+func (env *Environment) confirmPolicy(ctx context.Context, err error) error {
+	var policyErr *CommandPolicyError
+	if !errors.As(err, &policyErr) || policyErr.Rule != CommandPolicyConfirm || env.confirmCommand == nil {
+		return err
+	}
+	return env.confirmCommand(ctx, policyErr.Command)
+}
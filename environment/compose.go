@@ -0,0 +1,266 @@
+package environment
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// composeFiles are the compose file names looked up in the repository root, by order of precedence
+var composeFiles = []string{
+	"compose.yaml",
+	"compose.yml",
+	"docker-compose.yml",
+	"docker-compose.yaml",
+}
+
+type composeProject struct {
+	Services map[string]composeService `yaml:"services"`
+}
+
+type composeService struct {
+	Image       string              `yaml:"image"`
+	Build       any                 `yaml:"build"`
+	Command     composeCommand      `yaml:"command"`
+	Ports       []composePort       `yaml:"ports"`
+	Expose      []composePort       `yaml:"expose"`
+	Environment composeEnvironment  `yaml:"environment"`
+	Healthcheck *composeHealthcheck `yaml:"healthcheck"`
+}
+
+// composeCommand accepts both the string and the list forms
+type composeCommand []string
+
+func (c *composeCommand) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*c = composeCommand{value.Value}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*c = list
+	return nil
+}
+
+// String returns the command as a shell command line
+func (c composeCommand) String() string {
+	if len(c) <= 1 {
+		return strings.Join(c, "")
+	}
+	quoted := make([]string, len(c))
+	for i, arg := range c {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// composeEnvironment accepts both the map and the list (KEY=VALUE) forms
+type composeEnvironment []string
+
+func (e *composeEnvironment) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.MappingNode {
+		var m map[string]*string
+		if err := value.Decode(&m); err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v := ""
+			if m[k] != nil {
+				v = *m[k]
+			}
+			*e = append(*e, k+"="+v)
+		}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*e = list
+	return nil
+}
+
+// composePort is the container side of a port mapping, in short or long syntax
+type composePort int
+
+func (p *composePort) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.MappingNode {
+		var long struct {
+			Target int `yaml:"target"`
+		}
+		if err := value.Decode(&long); err != nil {
+			return err
+		}
+		*p = composePort(long.Target)
+		return nil
+	}
+
+	// Short syntax: [HOST:]CONTAINER[/PROTOCOL], where HOST may include an IP
+	spec, _, _ := strings.Cut(value.Value, "/")
+	parts := strings.Split(spec, ":")
+	target := parts[len(parts)-1]
+	if strings.Contains(target, "-") {
+		return fmt.Errorf("port ranges are not supported: %s", value.Value)
+	}
+	port, err := strconv.Atoi(target)
+	if err != nil {
+		return fmt.Errorf("invalid port: %s", value.Value)
+	}
+	*p = composePort(port)
+	return nil
+}
+
+type composeHealthcheck struct {
+	Test     composeCommand `yaml:"test"`
+	Interval string         `yaml:"interval"`
+	Timeout  string         `yaml:"timeout"`
+	Retries  int            `yaml:"retries"`
+	Disable  bool           `yaml:"disable"`
+}
+
+// FindComposeFile returns the path of the compose file in baseDir, or an empty string if there is none
+func FindComposeFile(baseDir string) string {
+	for _, name := range composeFiles {
+		path := filepath.Join(baseDir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// LoadComposeServices converts the services of a compose file into service configurations.
+// Services without an image (build-only services) can't be started and are returned as warnings.
+func LoadComposeServices(path string) (ServiceConfigs, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var project composeProject
+	if err := yaml.Unmarshal(data, &project); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %w", filepath.Base(path), err)
+	}
+
+	names := make([]string, 0, len(project.Services))
+	for name := range project.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	services := ServiceConfigs{}
+	warnings := []string{}
+	for _, name := range names {
+		svc := project.Services[name]
+		if svc.Image == "" {
+			warnings = append(warnings, fmt.Sprintf("skipping service %s: services built from source are not supported", name))
+			continue
+		}
+
+		ports := []int{}
+		for _, p := range append(svc.Ports, svc.Expose...) {
+			if !slices.Contains(ports, int(p)) {
+				ports = append(ports, int(p))
+			}
+		}
+
+		cfg := &ServiceConfig{
+			Name:         name,
+			Image:        svc.Image,
+			Command:      svc.Command.String(),
+			ExposedPorts: ports,
+			Env:          svc.Environment,
+		}
+		if hc := svc.Healthcheck; hc != nil && !hc.Disable {
+			healthcheck, err := hc.toConfig()
+			if err != nil {
+				return nil, nil, fmt.Errorf("service %s: %w", name, err)
+			}
+			cfg.Healthcheck = healthcheck
+		}
+		services = append(services, cfg)
+	}
+
+	return services, warnings, nil
+}
+
+func (hc *composeHealthcheck) toConfig() (*HealthcheckConfig, error) {
+	if len(hc.Test) == 0 || hc.Test[0] == "NONE" {
+		return nil, nil
+	}
+
+	var command string
+	switch hc.Test[0] {
+	case "CMD-SHELL":
+		command = strings.Join(hc.Test[1:], " ")
+	case "CMD":
+		command = composeCommand(hc.Test[1:]).String()
+	default:
+		// String form is run with the shell
+		command = hc.Test.String()
+	}
+
+	cfg := &HealthcheckConfig{
+		Command: command,
+		Retries: hc.Retries,
+	}
+	for _, d := range []struct {
+		raw string
+		dst *time.Duration
+	}{
+		{hc.Interval, &cfg.Interval},
+		{hc.Timeout, &cfg.Timeout},
+	} {
+		if d.raw == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid healthcheck duration %q: %w", d.raw, err)
+		}
+		*d.dst = parsed
+	}
+	return cfg, nil
+}
+
+// ImportCompose adds the services defined in the compose file of baseDir, if any, to the configuration.
+// Services already defined in the configuration take precedence.
+func (config *EnvironmentConfig) ImportCompose(baseDir string) ([]string, error) {
+	path := FindComposeFile(baseDir)
+	if path == "" {
+		return nil, nil
+	}
+
+	services, warnings, err := LoadComposeServices(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, svc := range services {
+		if config.Services.Get(svc.Name) != nil {
+			continue
+		}
+		config.Services = append(config.Services, svc)
+	}
+	return warnings, nil
+}
+
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\$`!*?[]{}()<>|&;#~") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
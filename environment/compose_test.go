@@ -0,0 +1,118 @@
+package environment
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const composeTestFile = `services:
+  app:
+    build: .
+  cache:
+    image: redis:7
+    command: ["redis-server", "--save", ""]
+    ports:
+      - "6379"
+    healthcheck:
+      test: ["CMD", "redis-cli", "ping"]
+  db:
+    image: postgres:16
+    ports:
+      - "127.0.0.1:5433:5432/tcp"
+      - target: 5432
+        published: 5434
+    expose:
+      - "9187"
+    environment:
+      POSTGRES_USER: app
+      POSTGRES_PASSWORD: secret
+    healthcheck:
+      test: ["CMD-SHELL", "pg_isready -h db -U app"]
+      interval: 5s
+      timeout: 3s
+      retries: 10
+  queue:
+    image: rabbitmq:3
+    command: rabbitmq-server
+    environment:
+      - RABBITMQ_DEFAULT_USER=app
+    healthcheck:
+      disable: true
+`
+
+func TestLoadComposeServices(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "compose.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(composeTestFile), 0644))
+
+	services, warnings, err := LoadComposeServices(path)
+	require.NoError(t, err)
+	require.Len(t, services, 3)
+	require.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "app")
+
+	cache := services.Get("cache")
+	require.NotNil(t, cache)
+	assert.Equal(t, "redis:7", cache.Image)
+	assert.Equal(t, `redis-server --save ''`, cache.Command)
+	assert.Equal(t, []int{6379}, cache.ExposedPorts)
+	require.NotNil(t, cache.Healthcheck)
+	assert.Equal(t, "redis-cli ping", cache.Healthcheck.Command)
+
+	db := services.Get("db")
+	require.NotNil(t, db)
+	assert.Equal(t, []int{5432, 9187}, db.ExposedPorts, "short and long port syntaxes should be deduplicated")
+	assert.Equal(t, []string{"POSTGRES_PASSWORD=secret", "POSTGRES_USER=app"}, db.Env)
+	require.NotNil(t, db.Healthcheck)
+	assert.Equal(t, "pg_isready -h db -U app", db.Healthcheck.Command)
+	assert.Equal(t, 5*time.Second, db.Healthcheck.Interval)
+	assert.Equal(t, 3*time.Second, db.Healthcheck.Timeout)
+	assert.Equal(t, 10, db.Healthcheck.Retries)
+
+	queue := services.Get("queue")
+	require.NotNil(t, queue)
+	assert.Equal(t, "rabbitmq-server", queue.Command)
+	assert.Equal(t, []string{"RABBITMQ_DEFAULT_USER=app"}, queue.Env)
+	assert.Nil(t, queue.Healthcheck)
+
+	t.Run("port_ranges", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "compose.yaml")
+		require.NoError(t, os.WriteFile(path, []byte("services:\n  web:\n    image: nginx\n    ports: [\"8000-8010:80\"]\n"), 0644))
+		_, _, err := LoadComposeServices(path)
+		assert.NoError(t, err, "ranges on the host side only are fine")
+
+		require.NoError(t, os.WriteFile(path, []byte("services:\n  web:\n    image: nginx\n    ports: [\"8000-8010\"]\n"), 0644))
+		_, _, err = LoadComposeServices(path)
+		assert.Error(t, err)
+	})
+}
+
+func TestEnvironmentConfig_ImportCompose(t *testing.T) {
+	t.Run("no_compose_file", func(t *testing.T) {
+		config := DefaultConfig()
+		warnings, err := config.ImportCompose(t.TempDir())
+		require.NoError(t, err)
+		assert.Empty(t, warnings)
+		assert.Empty(t, config.Services)
+	})
+
+	t.Run("configured_services_win", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "docker-compose.yml"), []byte(composeTestFile), 0644))
+
+		config := DefaultConfig()
+		config.Services = ServiceConfigs{{Name: "db", Image: "postgres:15"}}
+		_, err := config.ImportCompose(dir)
+		require.NoError(t, err)
+
+		require.Len(t, config.Services, 3)
+		assert.Equal(t, "postgres:15", config.Services.Get("db").Image)
+		assert.NotNil(t, config.Services.Get("cache"))
+		assert.NotNil(t, config.Services.Get("queue"))
+	})
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 )
 
 const (
@@ -31,6 +33,29 @@ type EnvironmentConfig struct {
 	Env             KVList         `json:"env,omitempty"`
 	Secrets         KVList         `json:"secrets,omitempty"`
 	Services        ServiceConfigs `json:"services,omitempty"`
+	// Ports the application listens on, exposed by previews unless other ports are requested
+	Ports []int `json:"ports,omitempty"`
+	// Resources limit the resources used by commands and services
+	Resources *ResourceLimits `json:"resources,omitempty"`
+	// Caches are mounted after the setup commands, for install commands and agents to reuse downloaded dependencies
+	Caches CacheMounts `json:"caches,omitempty"`
+	// HostPaths are the directories outside the worktree host environments may access, e.g. a shared dataset.
+	// Tools copying host files into or out of any environment are confined to them and the repository.
+	HostPaths HostPaths `json:"host_paths,omitempty"`
+	// Kubernetes runs the environment in a pod of a Kubernetes cluster instead of a container of the Dagger engine
+	Kubernetes *KubernetesConfig `json:"kubernetes,omitempty"`
+
+	// SecretScan is what to do with credentials agents write to the repository: warn (default), block or off
+	SecretScan string `json:"secret_scan,omitempty"`
+	// LicenseHeaders are required in the files agents change, they are checked when changes are reviewed and merged
+	LicenseHeaders LicenseHeaders `json:"license_headers,omitempty"`
+	// Webhooks are called back when environments are created, deleted and checkpointed
+	Webhooks Webhooks `json:"webhooks,omitempty"`
+	// Approvals are the destructive operations agents wait for the user to approve, e.g. merge
+	Approvals Approvals `json:"approvals,omitempty"`
+
+	// PlanSecrets are only exposed to infrastructure plans (e.g. cloud provider credentials), never to the environment
+	PlanSecrets KVList `json:"plan_secrets,omitempty"`
 }
 
 type ServiceConfig struct {
@@ -39,6 +64,19 @@ type ServiceConfig struct {
 	Command      string   `json:"command,omitempty"`
 	ExposedPorts []int    `json:"exposed_ports,omitempty"`
 	Env          []string `json:"env,omitempty"`
+	// NoEntrypoint runs the command directly, rather than through the entrypoint of the image
+	NoEntrypoint bool `json:"no_entrypoint,omitempty"`
+
+	Healthcheck *HealthcheckConfig `json:"healthcheck,omitempty"`
+}
+
+// HealthcheckConfig is a command run against a service after it starts, until it succeeds
+type HealthcheckConfig struct {
+	// Command is run with `sh -c` in a container of the service image, where the service is reachable using its name as hostname
+	Command  string        `json:"command"`
+	Interval time.Duration `json:"interval,omitempty"`
+	Timeout  time.Duration `json:"timeout,omitempty"`
+	Retries  int           `json:"retries,omitempty"`
 }
 
 type ServiceConfigs []*ServiceConfig
@@ -117,6 +155,19 @@ func (config *EnvironmentConfig) Copy() *EnvironmentConfig {
 		svcCopy := *svc
 		copy.Services[i] = &svcCopy
 	}
+	if config.Resources != nil {
+		resources := *config.Resources
+		copy.Resources = &resources
+	}
+	if config.Kubernetes != nil {
+		kubernetes := *config.Kubernetes
+		copy.Kubernetes = &kubernetes
+	}
+	copy.Caches = slices.Clone(config.Caches)
+	copy.HostPaths = slices.Clone(config.HostPaths)
+	copy.LicenseHeaders = slices.Clone(config.LicenseHeaders)
+	copy.Webhooks = slices.Clone(config.Webhooks)
+	copy.Approvals = slices.Clone(config.Approvals)
 	return &copy
 }
 
@@ -143,6 +194,12 @@ func (config *EnvironmentConfig) Save(baseDir string) error {
 	return nil
 }
 
+// HasConfig reports whether baseDir has a saved configuration
+func HasConfig(baseDir string) bool {
+	_, err := os.Stat(filepath.Join(baseDir, configDir, environmentFile))
+	return err == nil
+}
+
 func (config *EnvironmentConfig) Load(baseDir string) error {
 	configPath := filepath.Join(baseDir, configDir)
 
@@ -158,3 +215,118 @@ func (config *EnvironmentConfig) Load(baseDir string) error {
 
 	return nil
 }
+
+// Union merges other into config and returns a description of every conflict
+// that could not be merged automatically. On conflict, the value already in
+// config wins.
+// Commands are appended in order, skipping duplicates. Env, secrets and services
+// are merged by key.
+func (config *EnvironmentConfig) Union(other *EnvironmentConfig) []string {
+	conflicts := []string{}
+
+	unionValue := func(name string, dst *string, src string) {
+		switch {
+		case src == "" || src == *dst:
+		case *dst == "":
+			*dst = src
+		default:
+			conflicts = append(conflicts, fmt.Sprintf("%s: keeping %q, ignoring %q", name, *dst, src))
+		}
+	}
+	unionValue("base_image", &config.BaseImage, other.BaseImage)
+	unionValue("workdir", &config.Workdir, other.Workdir)
+	unionValue("secret_scan", &config.SecretScan, other.SecretScan)
+	if !other.Resources.IsZero() {
+		if config.Resources == nil {
+			config.Resources = &ResourceLimits{}
+		}
+		unionValue("cpu_time", &config.Resources.CPUTime, other.Resources.CPUTime)
+		unionValue("memory", &config.Resources.Memory, other.Resources.Memory)
+		unionValue("disk", &config.Resources.Disk, other.Resources.Disk)
+	}
+
+	config.SetupCommands = unionSlices(config.SetupCommands, other.SetupCommands)
+	config.InstallCommands = unionSlices(config.InstallCommands, other.InstallCommands)
+	config.Ports = unionSlices(config.Ports, other.Ports)
+
+	unionKV := func(name string, dst *KVList, src KVList) {
+		for _, item := range src {
+			key, value := src.parseKeyValue(item)
+			if !slices.Contains(dst.Keys(), key) {
+				dst.Set(key, value)
+				continue
+			}
+			if existing := dst.Get(key); existing != value {
+				conflicts = append(conflicts, fmt.Sprintf("%s %s: keeping %q, ignoring %q", name, key, existing, value))
+			}
+		}
+	}
+	unionKV("env", &config.Env, other.Env)
+	unionKV("secret", &config.Secrets, other.Secrets)
+	unionKV("plan secret", &config.PlanSecrets, other.PlanSecrets)
+
+	for _, c := range other.Caches {
+		existing := config.Caches.Get(c.Name)
+		if existing == nil {
+			config.Caches = append(config.Caches, c)
+			continue
+		}
+		if existing.Path != c.Path {
+			conflicts = append(conflicts, fmt.Sprintf("cache %s: keeping path %q, ignoring path %q", c.Name, existing.Path, c.Path))
+		}
+	}
+
+	for _, p := range other.HostPaths {
+		existing := config.HostPaths.Get(p.Path)
+		if existing == nil {
+			config.HostPaths = append(config.HostPaths, p)
+			continue
+		}
+		if existing.Write != p.Write {
+			conflicts = append(conflicts, fmt.Sprintf("host path %s: keeping write access %t, ignoring %t", p.Path, existing.Write, p.Write))
+		}
+	}
+
+	for _, h := range other.LicenseHeaders {
+		if !slices.ContainsFunc(config.LicenseHeaders, func(existing LicenseHeader) bool {
+			return existing.Header == h.Header && slices.Equal(existing.Paths, h.Paths)
+		}) {
+			config.LicenseHeaders = append(config.LicenseHeaders, h)
+		}
+	}
+
+	config.Approvals = unionSlices(config.Approvals, other.Approvals)
+
+	for _, w := range other.Webhooks {
+		if !slices.ContainsFunc(config.Webhooks, func(existing Webhook) bool { return existing.URL == w.URL }) {
+			config.Webhooks = append(config.Webhooks, w)
+		}
+	}
+
+	for _, svc := range other.Services {
+		existing := config.Services.Get(svc.Name)
+		if existing == nil {
+			svcCopy := *svc
+			config.Services = append(config.Services, &svcCopy)
+			continue
+		}
+		if existing.Image != svc.Image {
+			conflicts = append(conflicts, fmt.Sprintf("service %s: keeping image %q, ignoring image %q", svc.Name, existing.Image, svc.Image))
+		}
+		if existing.Command != svc.Command {
+			conflicts = append(conflicts, fmt.Sprintf("service %s: keeping command %q, ignoring command %q", svc.Name, existing.Command, svc.Command))
+		}
+	}
+
+	return conflicts
+}
+
+func unionSlices[T comparable](a, b []T) []T {
+	out := slices.Clone(a)
+	for _, item := range b {
+		if !slices.Contains(out, item) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -154,6 +154,62 @@ func TestEnvironmentConfig_PreservesShellOperators(t *testing.T) {
 	}
 }
 
+// TestEnvironmentConfig_Union tests merging two configurations and reporting conflicts
+func TestEnvironmentConfig_Union(t *testing.T) {
+	config := &EnvironmentConfig{
+		BaseImage:     "golang:1.24",
+		Workdir:       "/workdir",
+		SetupCommands: []string{"apt update", "apt install -y git"},
+		Env:           KVList{"FOO=bar", "SHARED=1"},
+		Services: ServiceConfigs{
+			{Name: "db", Image: "postgres:16"},
+		},
+	}
+	other := &EnvironmentConfig{
+		BaseImage:     "node:22",
+		Workdir:       "/workdir",
+		SetupCommands: []string{"apt update", "npm install -g pnpm"},
+		Env:           KVList{"BAZ=qux", "SHARED=2"},
+		Secrets:       KVList{"TOKEN=env://TOKEN"},
+		Services: ServiceConfigs{
+			{Name: "db", Image: "postgres:15"},
+			{Name: "cache", Image: "redis"},
+		},
+	}
+
+	conflicts := config.Union(other)
+
+	assert.Equal(t, "golang:1.24", config.BaseImage)
+	assert.Equal(t, []string{"apt update", "apt install -y git", "npm install -g pnpm"}, config.SetupCommands)
+	assert.Equal(t, "bar", config.Env.Get("FOO"))
+	assert.Equal(t, "qux", config.Env.Get("BAZ"))
+	assert.Equal(t, "1", config.Env.Get("SHARED"))
+	assert.Equal(t, "env://TOKEN", config.Secrets.Get("TOKEN"))
+	require.Len(t, config.Services, 2)
+	assert.Equal(t, "postgres:16", config.Services.Get("db").Image)
+	assert.NotNil(t, config.Services.Get("cache"))
+
+	require.Len(t, conflicts, 3)
+	assert.Contains(t, conflicts[0], "base_image")
+	assert.Contains(t, conflicts[1], "env SHARED")
+	assert.Contains(t, conflicts[2], "service db: keeping image")
+
+	t.Run("empty_values_are_adopted", func(t *testing.T) {
+		config := &EnvironmentConfig{}
+		conflicts := config.Union(&EnvironmentConfig{BaseImage: "node:22", Workdir: "/src"})
+		assert.Empty(t, conflicts)
+		assert.Equal(t, "node:22", config.BaseImage)
+		assert.Equal(t, "/src", config.Workdir)
+	})
+
+	t.Run("service_command_conflict", func(t *testing.T) {
+		config := &EnvironmentConfig{Services: ServiceConfigs{{Name: "db", Image: "postgres:16"}}}
+		conflicts := config.Union(&EnvironmentConfig{Services: ServiceConfigs{{Name: "db", Image: "postgres:16", Command: "postgres -c fsync=off"}}})
+		require.Len(t, conflicts, 1)
+		assert.Contains(t, conflicts[0], "service db: keeping command")
+	})
+}
+
 // Test helper functions
 func createInstructionsFile(t *testing.T, dir, content string) {
 	t.Helper()
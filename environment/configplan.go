@@ -0,0 +1,123 @@
+package environment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ConfigPlan describes what applying a configuration to an environment would do
+type ConfigPlan struct {
+	// Valid tells whether the configuration can be applied: none of its issues is an error
+	Valid  bool        `json:"valid"`
+	Issues []LintIssue `json:"issues"`
+	// Changes are the fields of the configuration that change
+	Changes []ConfigChange `json:"changes"`
+	// SetupRerun tells whether the setup commands run again on a new base, rather than being reused:
+	// the base image, setup commands, variables, secrets or workdir change
+	SetupRerun bool `json:"setup_rerun"`
+	// ServicesRestarted are the configured services started again, ServicesStopped the running ones that won't be
+	ServicesRestarted []string `json:"services_restarted,omitempty"`
+	ServicesStopped   []string `json:"services_stopped,omitempty"`
+}
+
+// ConfigChange is a field of the configuration that changes, with its JSON values
+type ConfigChange struct {
+	Field string          `json:"field"`
+	From  json.RawMessage `json:"from,omitempty"`
+	To    json.RawMessage `json:"to,omitempty"`
+}
+
+// setupFields are the fields the results of the setup commands depend on, see setupCacheKey
+var setupFields = []string{"base_image", "setup_commands", "env", "secrets", "workdir"}
+
+// PlanConfig validates a configuration for the environment of the project in baseDir, and describes what applying
+// it would change without touching the environment. Applying a configuration always rebuilds the environment:
+// install commands run again and background commands are stopped.
+func (env *Environment) PlanConfig(ctx context.Context, newConfig *EnvironmentConfig, baseDir string) (*ConfigPlan, error) {
+	host := strings.EqualFold(newConfig.BaseImage, "host")
+	plan := &ConfigPlan{
+		Issues: LintConfig(ctx, newConfig, baseDir),
+	}
+	if !host {
+		issues, err := ResolveImages(ctx, env.dag, newConfig)
+		if err != nil {
+			// e.g. offline: the images are checked when the configuration is applied
+			issues = []LintIssue{{Severity: LintWarning, Field: "base_image", Message: fmt.Sprintf("images not checked: %s", err)}}
+		}
+		plan.Issues = append(plan.Issues, issues...)
+	}
+	plan.Valid = !slices.ContainsFunc(plan.Issues, func(issue LintIssue) bool {
+		return issue.Severity == LintError
+	})
+
+	changes, err := configChanges(env.State.Config, newConfig)
+	if err != nil {
+		return nil, err
+	}
+	plan.Changes = changes
+	// There's no setup cache on the host
+	plan.SetupRerun = host
+	for _, change := range changes {
+		if slices.Contains(setupFields, change.Field) {
+			plan.SetupRerun = true
+		}
+	}
+
+	if !host {
+		for _, svc := range newConfig.Services {
+			plan.ServicesRestarted = append(plan.ServicesRestarted, svc.Name)
+		}
+	}
+	for _, svc := range env.Services {
+		if !slices.Contains(plan.ServicesRestarted, svc.ID) {
+			plan.ServicesStopped = append(plan.ServicesStopped, svc.ID)
+		}
+	}
+	for _, name := range env.State.BuiltServices {
+		if !slices.Contains(plan.ServicesStopped, name) {
+			plan.ServicesStopped = append(plan.ServicesStopped, name)
+		}
+	}
+	return plan, nil
+}
+
+// configChanges compares the top-level fields of two configurations
+func configChanges(from, to *EnvironmentConfig) ([]ConfigChange, error) {
+	fields := func(config *EnvironmentConfig) (map[string]json.RawMessage, error) {
+		data, err := json.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+		fields := map[string]json.RawMessage{}
+		return fields, json.Unmarshal(data, &fields)
+	}
+	before, err := fields(from)
+	if err != nil {
+		return nil, err
+	}
+	after, err := fields(to)
+	if err != nil {
+		return nil, err
+	}
+
+	names := slices.Sorted(maps.Keys(before))
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	changes := []ConfigChange{}
+	for _, name := range names {
+		if !bytes.Equal(before[name], after[name]) {
+			changes = append(changes, ConfigChange{Field: name, From: before[name], To: after[name]})
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,56 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigChanges(t *testing.T) {
+	from := DefaultConfig()
+	from.SetupCommands = []string{"apt-get update"}
+	to := from.Copy()
+	to.BaseImage = "alpine"
+	to.SetupCommands = nil
+	to.Ports = []int{8080}
+
+	changes, err := configChanges(from, to)
+	require.NoError(t, err)
+	require.Len(t, changes, 3)
+	assert.Equal(t, ConfigChange{Field: "base_image", From: []byte(`"ubuntu:24.04"`), To: []byte(`"alpine"`)}, changes[0])
+	assert.Equal(t, ConfigChange{Field: "ports", To: []byte(`[8080]`)}, changes[1])
+	assert.Equal(t, ConfigChange{Field: "setup_commands", From: []byte(`["apt-get update"]`)}, changes[2])
+
+	changes, err = configChanges(from, from.Copy())
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestPlanConfig(t *testing.T) {
+	env := newHostEnvironment(t, "plan")
+	env.State.BuiltServices = []string{"api"}
+
+	config := env.State.Config.Copy()
+	config.InstallCommands = []string{"make deps", "if true; then echo"}
+	config.Ports = []int{70000}
+
+	plan, err := env.PlanConfig(t.Context(), config, t.TempDir())
+	require.NoError(t, err)
+	assert.False(t, plan.Valid)
+	fields := []string{}
+	for _, issue := range plan.Issues {
+		fields = append(fields, issue.Field)
+	}
+	assert.Equal(t, []string{"ports[0]", "install_commands[1]"}, fields)
+	assert.True(t, plan.SetupRerun, "commands run again on the host")
+	assert.Equal(t, []string{"api"}, plan.ServicesStopped)
+	assert.Len(t, plan.Changes, 2)
+
+	config.InstallCommands = []string{"make deps"}
+	config.Ports = []int{8080}
+	plan, err = env.PlanConfig(t.Context(), config, t.TempDir())
+	require.NoError(t, err)
+	assert.True(t, plan.Valid)
+	assert.Empty(t, plan.Issues)
+}
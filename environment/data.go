@@ -0,0 +1,333 @@
+package environment
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	DataFormatCSV     = "csv"
+	DataFormatTSV     = "tsv"
+	DataFormatJSON    = "json"
+	DataFormatJSONL   = "jsonl"
+	DataFormatParquet = "parquet"
+
+	defaultDataPreviewRows = 10
+	maxDataPreviewRows     = 100
+)
+
+// DataPreview is the schema and first rows of a data file
+type DataPreview struct {
+	Format  string       `json:"format"`
+	Columns []DataColumn `json:"columns"`
+	Rows    [][]any      `json:"rows"`
+	// RowCount is the total number of rows in the file
+	RowCount int64  `json:"row_count"`
+	Note     string `json:"note,omitempty"`
+}
+
+type DataColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// DataPreview returns the schema and first rows of a CSV, TSV, JSON, JSONL or Parquet file.
+// The format is detected from the file extension unless specified.
+func (env *Environment) DataPreview(ctx context.Context, targetFile, format string, rows int) (*DataPreview, error) {
+	if format == "" {
+		format = detectDataFormat(targetFile)
+		if format == "" {
+			return nil, fmt.Errorf("unable to detect the format of %s, specify it explicitly", targetFile)
+		}
+	}
+	if rows <= 0 {
+		rows = defaultDataPreviewRows
+	}
+	rows = min(rows, maxDataPreviewRows)
+
+	var path string
+	if env.IsHost() {
+		var err error
+		if path, err = env.hostPath(targetFile, false); err != nil {
+			return nil, err
+		}
+	} else {
+		// Files are exported to the host rather than read as strings, so binary formats survive the transfer
+		dir, err := os.MkdirTemp("", "container-use-data-*")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		path = filepath.Join(dir, filepath.Base(targetFile))
+		if _, err := env.container().File(targetFile).Export(ctx, path); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", targetFile, err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return previewData(f, format, rows)
+}
+
+func detectDataFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return DataFormatCSV
+	case ".tsv":
+		return DataFormatTSV
+	case ".json":
+		return DataFormatJSON
+	case ".jsonl", ".ndjson":
+		return DataFormatJSONL
+	case ".parquet":
+		return DataFormatParquet
+	}
+	return ""
+}
+
+func previewData(f *os.File, format string, rows int) (*DataPreview, error) {
+	switch format {
+	case DataFormatCSV:
+		return previewCSV(f, ',', rows)
+	case DataFormatTSV:
+		return previewCSV(f, '\t', rows)
+	case DataFormatJSON:
+		return previewJSON(f, rows)
+	case DataFormatJSONL:
+		return previewJSONL(f, rows)
+	case DataFormatParquet:
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return previewParquet(f, info.Size())
+	}
+	return nil, fmt.Errorf("unsupported data format %q", format)
+}
+
+func previewCSV(r io.Reader, comma rune, rows int) (*DataPreview, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = comma
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("file is empty")
+		}
+		return nil, err
+	}
+	preview := &DataPreview{Format: DataFormatCSV, Rows: [][]any{}}
+	if comma == '\t' {
+		preview.Format = DataFormatTSV
+	}
+	for _, name := range header {
+		preview.Columns = append(preview.Columns, DataColumn{Name: name})
+	}
+
+	types := make([]string, len(header))
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", preview.RowCount+2, err)
+		}
+		preview.RowCount++
+		if len(preview.Rows) >= rows {
+			continue
+		}
+
+		row := make([]any, len(header))
+		for i := range header {
+			if i >= len(record) {
+				continue
+			}
+			row[i] = record[i]
+			types[i] = mergeDataTypes(types[i], csvValueType(record[i]))
+		}
+		preview.Rows = append(preview.Rows, row)
+	}
+
+	// Types are inferred from the previewed rows
+	for i := range preview.Columns {
+		preview.Columns[i].Type = types[i]
+		if types[i] == "" || types[i] == "null" || types[i] == "mixed" {
+			preview.Columns[i].Type = "string"
+		}
+	}
+	return preview, nil
+}
+
+func csvValueType(value string) string {
+	if value == "" {
+		return "null"
+	}
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return "integer"
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return "number"
+	}
+	if _, err := strconv.ParseBool(value); err == nil {
+		return "boolean"
+	}
+	return "string"
+}
+
+// mergeDataTypes returns the type of a column holding values of both types
+func mergeDataTypes(a, b string) string {
+	switch {
+	case a == "" || a == "null" || a == b:
+		return b
+	case b == "null":
+		return a
+	case (a == "integer" && b == "number") || (a == "number" && b == "integer"):
+		return "number"
+	}
+	return "mixed"
+}
+
+func jsonValueType(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return "mixed"
+}
+
+// jsonRecords accumulates JSON objects into a preview, with columns in order of appearance
+type jsonRecords struct {
+	preview *DataPreview
+	index   map[string]int
+	rows    int
+}
+
+func newJSONRecords(format string, rows int) *jsonRecords {
+	return &jsonRecords{
+		preview: &DataPreview{Format: format, Columns: []DataColumn{}, Rows: [][]any{}},
+		index:   map[string]int{},
+		rows:    rows,
+	}
+}
+
+func (j *jsonRecords) add(record any) {
+	j.preview.RowCount++
+	if len(j.preview.Rows) >= j.rows {
+		return
+	}
+
+	object, ok := record.(map[string]any)
+	if !ok {
+		object = map[string]any{"value": record}
+	}
+	// Keys are sorted for a stable column order, Go maps being unordered
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		if _, ok := j.index[key]; !ok {
+			j.index[key] = len(j.preview.Columns)
+			j.preview.Columns = append(j.preview.Columns, DataColumn{Name: key})
+		}
+	}
+
+	row := make([]any, len(j.preview.Columns))
+	for key, value := range object {
+		i := j.index[key]
+		row[i] = value
+		j.preview.Columns[i].Type = mergeDataTypes(j.preview.Columns[i].Type, jsonValueType(value))
+	}
+	j.preview.Rows = append(j.preview.Rows, row)
+}
+
+func (j *jsonRecords) result() *DataPreview {
+	// Rows previewed before a column first appeared are shorter than the header
+	for i, row := range j.preview.Rows {
+		if len(row) < len(j.preview.Columns) {
+			j.preview.Rows[i] = append(row, make([]any, len(j.preview.Columns)-len(row))...)
+		}
+	}
+	for i, column := range j.preview.Columns {
+		if column.Type == "" {
+			j.preview.Columns[i].Type = "null"
+		}
+	}
+	return j.preview
+}
+
+func previewJSONL(r io.Reader, rows int) (*DataPreview, error) {
+	records := newJSONRecords(DataFormatJSONL, rows)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.UseNumber()
+		var record any
+		if err := decoder.Decode(&record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records.add(record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return records.result(), nil
+}
+
+// previewJSON previews a JSON array of records. Any other document is previewed as a single record.
+func previewJSON(r io.Reader, rows int) (*DataPreview, error) {
+	records := newJSONRecords(DataFormatJSON, rows)
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	if array, ok := document.([]any); ok {
+		for _, record := range array {
+			records.add(record)
+		}
+	} else {
+		records.add(document)
+	}
+	return records.result(), nil
+}
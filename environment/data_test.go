@@ -0,0 +1,184 @@
+package environment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreviewCSV(t *testing.T) {
+	data := "id,name,score,active\n1,alice,9.5,true\n2,bob,7,false\n3,,8,true\n4,dave,n/a,false\n"
+
+	preview, err := previewCSV(strings.NewReader(data), ',', 2)
+	require.NoError(t, err)
+	assert.Equal(t, DataFormatCSV, preview.Format)
+	assert.Equal(t, int64(4), preview.RowCount)
+	assert.Equal(t, []DataColumn{
+		{Name: "id", Type: "integer"},
+		{Name: "name", Type: "string"},
+		{Name: "score", Type: "number"},
+		{Name: "active", Type: "boolean"},
+	}, preview.Columns, "types are inferred from the previewed rows only")
+	assert.Equal(t, [][]any{
+		{"1", "alice", "9.5", "true"},
+		{"2", "bob", "7", "false"},
+	}, preview.Rows)
+
+	preview, err = previewCSV(strings.NewReader(data), ',', 10)
+	require.NoError(t, err)
+	assert.Len(t, preview.Rows, 4)
+	assert.Equal(t, "string", preview.Columns[2].Type, "mixed columns are strings")
+
+	t.Run("tsv", func(t *testing.T) {
+		preview, err := previewCSV(strings.NewReader("a\tb\n1\n"), '\t', 10)
+		require.NoError(t, err)
+		assert.Equal(t, DataFormatTSV, preview.Format)
+		assert.Equal(t, [][]any{{"1", nil}}, preview.Rows, "short records are padded")
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		_, err := previewCSV(strings.NewReader(""), ',', 10)
+		assert.Error(t, err)
+	})
+}
+
+func TestPreviewJSONL(t *testing.T) {
+	data := `{"id": 1, "name": "alice"}
+
+{"id": 2, "name": "bob", "tags": ["a"]}
+{"id": 3.5, "name": null}
+`
+	preview, err := previewJSONL(strings.NewReader(data), 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), preview.RowCount)
+	assert.Equal(t, []DataColumn{
+		{Name: "id", Type: "number"},
+		{Name: "name", Type: "string"},
+		{Name: "tags", Type: "array"},
+	}, preview.Columns)
+	require.Len(t, preview.Rows, 3)
+	assert.Len(t, preview.Rows[0], 3, "rows are padded to the final columns")
+	assert.Equal(t, json.Number("1"), preview.Rows[0][0])
+	assert.Nil(t, preview.Rows[0][2])
+
+	_, err = previewJSONL(strings.NewReader("{\"id\": 1}\n{oops\n"), 10)
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestPreviewJSON(t *testing.T) {
+	preview, err := previewJSON(strings.NewReader(`[{"a": 1}, {"a": 2}, {"a": 3}]`), 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), preview.RowCount)
+	assert.Len(t, preview.Rows, 2)
+	assert.Equal(t, []DataColumn{{Name: "a", Type: "integer"}}, preview.Columns)
+
+	preview, err = previewJSON(strings.NewReader(`{"a": true}`), 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), preview.RowCount)
+	assert.Equal(t, []DataColumn{{Name: "a", Type: "boolean"}}, preview.Columns)
+}
+
+// thriftWriter writes the subset of the thrift compact protocol needed to build parquet metadata
+type thriftWriter struct {
+	bytes.Buffer
+	lastIDs []int16
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	last := w.lastIDs[len(w.lastIDs)-1]
+	w.WriteByte(byte(id-last)<<4 | typ)
+	w.lastIDs[len(w.lastIDs)-1] = id
+}
+
+func (w *thriftWriter) int(id int16, typ byte, v int64) {
+	w.field(id, typ)
+	w.Write(binary.AppendVarint(nil, v))
+}
+
+func (w *thriftWriter) string(id int16, s string) {
+	w.field(id, thriftBinary)
+	w.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	w.WriteString(s)
+}
+
+func (w *thriftWriter) begin() { w.lastIDs = append(w.lastIDs, 0) }
+func (w *thriftWriter) end() {
+	w.WriteByte(0)
+	w.lastIDs = w.lastIDs[:len(w.lastIDs)-1]
+}
+
+func TestPreviewParquet(t *testing.T) {
+	type element struct {
+		name          string
+		physicalType  int64
+		children      int64
+		convertedType int64
+		logicalType   int16
+	}
+	schema := []element{
+		{name: "schema", physicalType: -1, children: 3, convertedType: -1},
+		{name: "id", physicalType: 2, convertedType: -1},
+		{name: "name", physicalType: 6, convertedType: 0},
+		{name: "location", physicalType: -1, children: 2, convertedType: -1},
+		{name: "lat", physicalType: 5, convertedType: -1},
+		{name: "seen", physicalType: 2, convertedType: -1, logicalType: 8},
+	}
+
+	w := &thriftWriter{}
+	w.begin()
+	w.int(1, thriftI32, 2) // version
+	w.field(2, thriftList)
+	w.WriteByte(byte(len(schema))<<4 | thriftStruct)
+	for _, e := range schema {
+		w.begin()
+		if e.physicalType >= 0 {
+			w.int(1, thriftI32, e.physicalType)
+		}
+		w.int(3, thriftI32, 1) // repetition type, skipped
+		w.string(4, e.name)
+		if e.children > 0 {
+			w.int(5, thriftI32, e.children)
+		}
+		if e.convertedType >= 0 {
+			w.int(6, thriftI32, e.convertedType)
+		}
+		if e.logicalType != 0 {
+			w.field(10, thriftStruct)
+			w.begin()
+			w.field(e.logicalType, thriftStruct)
+			w.begin()
+			w.field(1, thriftBoolTrue) // isAdjustedToUTC
+			w.end()
+			w.end()
+		}
+		w.end()
+	}
+	w.int(3, thriftI64, 1234) // num_rows
+	w.string(6, "parquet-go") // created_by, skipped
+	w.end()
+
+	file := []byte(parquetMagic + "column data")
+	file = append(file, w.Bytes()...)
+	file = binary.LittleEndian.AppendUint32(file, uint32(w.Len()))
+	file = append(file, parquetMagic...)
+
+	preview, err := previewParquet(bytes.NewReader(file), int64(len(file)))
+	require.NoError(t, err)
+	assert.Equal(t, DataFormatParquet, preview.Format)
+	assert.Equal(t, int64(1234), preview.RowCount)
+	assert.Equal(t, []DataColumn{
+		{Name: "id", Type: "int64"},
+		{Name: "name", Type: "string"},
+		{Name: "location.lat", Type: "double"},
+		{Name: "location.seen", Type: "timestamp"},
+	}, preview.Columns)
+	assert.NotEmpty(t, preview.Note)
+
+	_, err = previewParquet(bytes.NewReader([]byte("id,name\n1,alice\n")), 16)
+	assert.Error(t, err)
+}
@@ -0,0 +1,149 @@
+package environment
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// DetectedStack is the stack of a project without configuration, inferred from its manifest files
+type DetectedStack struct {
+	// Languages are the languages of the project, the first one chose the base image and install commands
+	Languages []string `json:"languages"`
+	// Manifests are the files the languages were detected from
+	Manifests       []string `json:"manifests"`
+	BaseImage       string   `json:"base_image"`
+	InstallCommands []string `json:"install_commands,omitempty"`
+}
+
+// stack is a language and how to set up environments for it
+type stack struct {
+	language  string
+	manifests []string
+	baseImage string
+	// install returns the package manager of a project and its install commands, given whether the project has a file
+	install func(has func(name string) bool) (manager string, commands []string)
+	caches  []languageCache
+}
+
+// stacks are the supported languages, by priority: the first one detected sets up the environment
+var stacks = []stack{
+	{
+		language:  "go",
+		manifests: []string{"go.mod"},
+		baseImage: "golang:1.24-bookworm",
+		install:   func(func(string) bool) (string, []string) { return "go mod", []string{"go mod download"} },
+		caches: []languageCache{
+			{name: "go-mod", path: "/cache/go-mod", envVar: "GOMODCACHE"},
+			{name: "go-build", path: "/cache/go-build", envVar: "GOCACHE", transient: true},
+		},
+	},
+	{
+		language:  "rust",
+		manifests: []string{"Cargo.toml"},
+		baseImage: "rust:1-bookworm",
+		install:   func(func(string) bool) (string, []string) { return "cargo", []string{"cargo fetch"} },
+		// Only the downloaded crates, CARGO_HOME also holds the installed binaries
+		caches: []languageCache{{name: "cargo-registry", path: "/usr/local/cargo/registry"}},
+	},
+	{
+		language:  "node",
+		manifests: []string{"package.json"},
+		baseImage: "node:22-bookworm",
+		install: func(has func(string) bool) (string, []string) {
+			switch {
+			case has("pnpm-lock.yaml"):
+				return "pnpm", []string{"corepack enable", "pnpm install --frozen-lockfile"}
+			case has("yarn.lock"):
+				return "yarn", []string{"corepack enable", "yarn install --frozen-lockfile"}
+			case has("package-lock.json"):
+				return "npm", []string{"npm ci"}
+			}
+			return "npm", []string{"npm install"}
+		},
+		caches: []languageCache{{name: "npm", path: "/cache/npm", envVar: "npm_config_cache", transient: true}},
+	},
+	{
+		language:  "python",
+		manifests: []string{"pyproject.toml", "requirements.txt", "setup.py", "Pipfile"},
+		baseImage: "python:3.12-bookworm",
+		install: func(has func(string) bool) (string, []string) {
+			switch {
+			case has("uv.lock"):
+				return "uv", []string{"pip install uv", "uv sync --frozen"}
+			case has("poetry.lock"):
+				return "poetry", []string{"pip install poetry", "POETRY_VIRTUALENVS_CREATE=false poetry install --no-interaction"}
+			case has("requirements.txt"):
+				return "pip", []string{"pip install -r requirements.txt"}
+			case has("Pipfile"):
+				return "pipenv", []string{"pip install pipenv", "pipenv install --dev --system"}
+			}
+			return "pip", []string{"pip install -e ."}
+		},
+		caches: []languageCache{{name: "pip", path: "/cache/pip", envVar: "PIP_CACHE_DIR", transient: true}},
+	},
+	{
+		language:  "ruby",
+		manifests: []string{"Gemfile"},
+		baseImage: "ruby:3.3-bookworm",
+		install:   func(func(string) bool) (string, []string) { return "bundler", []string{"bundle install"} },
+	},
+}
+
+func fileExists(dir, name string) bool {
+	_, err := os.Stat(filepath.Join(dir, name))
+	return err == nil
+}
+
+// detectStacks returns the stacks of the project in dir, by priority, along with the manifests found
+func detectStacks(dir string) ([]stack, []string) {
+	return detectStacksWith(func(name string) bool { return fileExists(dir, name) })
+}
+
+// detectStacksWith returns the stacks of a project, given whether it has a file
+func detectStacksWith(has func(name string) bool) ([]stack, []string) {
+	detected := []stack{}
+	manifests := []string{}
+	for _, s := range stacks {
+		found := false
+		for _, manifest := range s.manifests {
+			if has(manifest) {
+				manifests = append(manifests, manifest)
+				found = true
+			}
+		}
+		if found {
+			detected = append(detected, s)
+		}
+	}
+	return detected, manifests
+}
+
+// DetectStack inspects the project in dir to choose a base image and install commands.
+// It returns nil if no supported language is detected.
+func DetectStack(dir string) *DetectedStack {
+	detected, manifests := detectStacks(dir)
+	if len(detected) == 0 {
+		return nil
+	}
+	_, commands := detected[0].install(func(name string) bool { return fileExists(dir, name) })
+	result := &DetectedStack{
+		Manifests:       manifests,
+		BaseImage:       detected[0].baseImage,
+		InstallCommands: commands,
+	}
+	for _, s := range detected {
+		result.Languages = append(result.Languages, s.language)
+	}
+	return result
+}
+
+// ApplyStack sets up the configuration for a detected stack
+func (config *EnvironmentConfig) ApplyStack(stack *DetectedStack) {
+	config.BaseImage = stack.BaseImage
+	for _, command := range stack.InstallCommands {
+		if !slices.Contains(config.InstallCommands, command) {
+			config.InstallCommands = append(config.InstallCommands, command)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package environment
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0600))
+	}
+}
+
+func TestDetectStack(t *testing.T) {
+	assert.Nil(t, DetectStack(t.TempDir()))
+
+	dir := t.TempDir()
+	writeFiles(t, dir, "package.json", "pnpm-lock.yaml", "pyproject.toml")
+	stack := DetectStack(dir)
+	require.NotNil(t, stack)
+	assert.Equal(t, []string{"node", "python"}, stack.Languages)
+	assert.Equal(t, []string{"package.json", "pyproject.toml"}, stack.Manifests)
+	assert.Equal(t, "node:22-bookworm", stack.BaseImage, "the first language detected picks the image")
+	assert.Equal(t, []string{"corepack enable", "pnpm install --frozen-lockfile"}, stack.InstallCommands)
+
+	dir = t.TempDir()
+	writeFiles(t, dir, "requirements.txt", "go.mod")
+	stack = DetectStack(dir)
+	require.NotNil(t, stack)
+	assert.Equal(t, []string{"go", "python"}, stack.Languages)
+	assert.Equal(t, []string{"go mod download"}, stack.InstallCommands)
+}
+
+func TestApplyStack(t *testing.T) {
+	config := DefaultConfig()
+	config.InstallCommands = []string{"npm ci"}
+	config.ApplyStack(&DetectedStack{BaseImage: "node:22-bookworm", InstallCommands: []string{"npm ci"}})
+	assert.Equal(t, "node:22-bookworm", config.BaseImage)
+	assert.Equal(t, []string{"npm ci"}, config.InstallCommands)
+}
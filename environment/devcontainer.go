@@ -0,0 +1,254 @@
+package environment
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// devcontainerFiles are the locations of the dev container configuration, in order of precedence
+var devcontainerFiles = []string{
+	filepath.Join(".devcontainer", "devcontainer.json"),
+	".devcontainer.json",
+}
+
+// devcontainer is the subset of the dev container specification that maps to an environment:
+// https://containers.dev/implementors/json_reference/
+type devcontainer struct {
+	Image             string                     `json:"image"`
+	Build             json.RawMessage            `json:"build"`
+	DockerComposeFile json.RawMessage            `json:"dockerComposeFile"`
+	Features          map[string]json.RawMessage `json:"features"`
+	WorkspaceFolder   string                     `json:"workspaceFolder"`
+	ForwardPorts      []json.RawMessage          `json:"forwardPorts"`
+	ContainerEnv      map[string]string          `json:"containerEnv"`
+	RemoteEnv         map[string]*string         `json:"remoteEnv"`
+
+	OnCreateCommand      devcontainerCommand `json:"onCreateCommand"`
+	UpdateContentCommand devcontainerCommand `json:"updateContentCommand"`
+	PostCreateCommand    devcontainerCommand `json:"postCreateCommand"`
+}
+
+// devcontainerCommand is a lifecycle command: a shell string, an array of arguments,
+// or an object of named commands (run in parallel by dev container tools, in order here)
+type devcontainerCommand []string
+
+func (c *devcontainerCommand) UnmarshalJSON(data []byte) error {
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	toCommand := func(v any) (string, error) {
+		switch v := v.(type) {
+		case string:
+			return v, nil
+		case []any:
+			args := make([]string, 0, len(v))
+			for _, arg := range v {
+				s, ok := arg.(string)
+				if !ok {
+					return "", fmt.Errorf("invalid command argument %v", arg)
+				}
+				args = append(args, shellQuote(s))
+			}
+			return strings.Join(args, " "), nil
+		}
+		return "", fmt.Errorf("invalid command %v", v)
+	}
+
+	switch v := raw.(type) {
+	case nil:
+		*c = nil
+	case map[string]any:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			command, err := toCommand(v[name])
+			if err != nil {
+				return err
+			}
+			*c = append(*c, command)
+		}
+	default:
+		command, err := toCommand(v)
+		if err != nil {
+			return err
+		}
+		*c = devcontainerCommand{command}
+	}
+	return nil
+}
+
+// FindDevcontainerFile returns the path of the dev container configuration of baseDir, or an empty string if there is none
+func FindDevcontainerFile(baseDir string) string {
+	for _, name := range devcontainerFiles {
+		path := filepath.Join(baseDir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+var (
+	devcontainerVariable = regexp.MustCompile(`\$\{[^}]*\}`)
+	localEnvVariable     = regexp.MustCompile(`^\$\{localEnv:([^}:]+)\}$`)
+)
+
+// ImportDevcontainer sets up the configuration from the dev container configuration of baseDir, if any.
+// The image, lifecycle commands, environment variables and forwarded ports are imported.
+// Returns warnings for the settings that can't be imported.
+func (config *EnvironmentConfig) ImportDevcontainer(baseDir string) ([]string, error) {
+	file := FindDevcontainerFile(baseDir)
+	if file == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	dc := devcontainer{}
+	if err := json.Unmarshal(stripJSONC(data), &dc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+
+	warnings := []string{}
+	switch {
+	case dc.Image != "":
+		config.BaseImage = dc.Image
+	case len(dc.DockerComposeFile) > 0:
+		warnings = append(warnings, "dev containers based on compose files are not supported, keeping the base image "+config.BaseImage)
+	case len(dc.Build) > 0:
+		warnings = append(warnings, "dev containers built from a Dockerfile are not supported, keeping the base image "+config.BaseImage)
+	}
+	if len(dc.Features) > 0 {
+		features := make([]string, 0, len(dc.Features))
+		for feature := range dc.Features {
+			features = append(features, feature)
+		}
+		slices.Sort(features)
+		warnings = append(warnings, "dev container features are not supported, add the tools they provide to the setup commands: "+strings.Join(features, ", "))
+	}
+	if dc.WorkspaceFolder != "" {
+		config.Workdir = dc.WorkspaceFolder
+	}
+
+	expand := func(name, value string) (string, bool) {
+		value = strings.ReplaceAll(value, "${containerWorkspaceFolder}", config.Workdir)
+		value = strings.ReplaceAll(value, "${containerWorkspaceFolderBasename}", path.Base(config.Workdir))
+		if devcontainerVariable.MatchString(value) {
+			warnings = append(warnings, fmt.Sprintf("environment variable %s: unsupported substitution in %q", name, value))
+			return "", false
+		}
+		return value, true
+	}
+	setEnv := func(env map[string]*string) {
+		names := make([]string, 0, len(env))
+		for name := range env {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			// null values unset variables
+			if env[name] == nil {
+				config.Env.Unset(name)
+				continue
+			}
+			value := *env[name]
+			// Host variables are passed as secrets, so their values aren't stored in the configuration
+			if match := localEnvVariable.FindStringSubmatch(value); match != nil {
+				config.Secrets.Set(name, "env://"+match[1])
+				continue
+			}
+			if value, ok := expand(name, value); ok {
+				config.Env.Set(name, value)
+			}
+		}
+	}
+	containerEnv := map[string]*string{}
+	for name, value := range dc.ContainerEnv {
+		containerEnv[name] = &value
+	}
+	setEnv(containerEnv)
+	setEnv(dc.RemoteEnv)
+
+	for _, commands := range []devcontainerCommand{dc.OnCreateCommand, dc.UpdateContentCommand, dc.PostCreateCommand} {
+		config.InstallCommands = unionSlices(config.InstallCommands, commands)
+	}
+
+	for _, raw := range dc.ForwardPorts {
+		var port int
+		if err := json.Unmarshal(raw, &port); err != nil {
+			// "service:port" forwards a port of a compose service
+			warnings = append(warnings, fmt.Sprintf("forwarded port %s: only ports of the dev container are supported", string(raw)))
+			continue
+		}
+		if !slices.Contains(config.Ports, port) {
+			config.Ports = append(config.Ports, port)
+		}
+	}
+
+	return warnings, nil
+}
+
+// stripJSONC removes the comments and trailing commas allowed in dev container configurations
+func stripJSONC(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if inString {
+			out = append(out, c)
+			switch c {
+			case '\\':
+				if i+1 < len(data) {
+					i++
+					out = append(out, data[i])
+				}
+			case '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch {
+		case c == '"':
+			inString = true
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			if i < len(data) {
+				out = append(out, '\n')
+			}
+			continue
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := strings.Index(string(data[i+2:]), "*/")
+			if end < 0 {
+				return out
+			}
+			i += end + 3
+			continue
+		case c == '}' || c == ']':
+			// Drop the trailing comma, if any, before the closing bracket
+			j := len(out) - 1
+			for j >= 0 && strings.ContainsRune(" \t\r\n", rune(out[j])) {
+				j--
+			}
+			if j >= 0 && out[j] == ',' {
+				out = append(out[:j], out[j+1:]...)
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
@@ -0,0 +1,100 @@
+package environment
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const devcontainerTestFile = `// Dev container for the project
+{
+	"name": "app",
+	"image": "mcr.microsoft.com/devcontainers/go:1.24",
+	/* Features are installed by the dev container CLI */
+	"features": {
+		"ghcr.io/devcontainers/features/node:1": {"version": "20"},
+	},
+	"workspaceFolder": "/workspaces/app",
+	"forwardPorts": [8080, "db:5432", 8080],
+	"containerEnv": {
+		"GOFLAGS": "-mod=mod",
+		"APP_ROOT": "${containerWorkspaceFolder}/cmd",
+	},
+	"remoteEnv": {
+		"GITHUB_TOKEN": "${localEnv:GITHUB_TOKEN}",
+		"PATH": "${containerEnv:PATH}:/go/bin",
+		"URL": "http://localhost:8080/path//not-a-comment",
+	},
+	"onCreateCommand": ["go", "mod", "download"],
+	"postCreateCommand": {
+		"tools": "go install golang.org/x/tools/gopls@latest",
+		"deps": "make deps",
+	},
+}
+`
+
+func TestEnvironmentConfig_ImportDevcontainer(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ".devcontainer"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".devcontainer", "devcontainer.json"), []byte(devcontainerTestFile), 0644))
+
+	config := DefaultConfig()
+	warnings, err := config.ImportDevcontainer(dir)
+	require.NoError(t, err)
+
+	assert.Equal(t, "mcr.microsoft.com/devcontainers/go:1.24", config.BaseImage)
+	assert.Equal(t, "/workspaces/app", config.Workdir)
+	assert.Equal(t, []int{8080}, config.Ports)
+	assert.Equal(t, []string{"go mod download", "make deps", "go install golang.org/x/tools/gopls@latest"}, config.InstallCommands)
+	assert.Equal(t, "/workspaces/app/cmd", config.Env.Get("APP_ROOT"))
+	assert.Equal(t, "-mod=mod", config.Env.Get("GOFLAGS"))
+	assert.Equal(t, "http://localhost:8080/path//not-a-comment", config.Env.Get("URL"))
+	assert.NotContains(t, config.Env.Keys(), "PATH")
+	assert.NotContains(t, config.Env.Keys(), "GITHUB_TOKEN")
+	assert.Equal(t, "env://GITHUB_TOKEN", config.Secrets.Get("GITHUB_TOKEN"))
+
+	require.Len(t, warnings, 3)
+	assert.Contains(t, warnings[0], "ghcr.io/devcontainers/features/node:1")
+	assert.Contains(t, warnings[1], "PATH")
+	assert.Contains(t, warnings[2], "db:5432")
+
+	t.Run("no_devcontainer", func(t *testing.T) {
+		config := DefaultConfig()
+		warnings, err := config.ImportDevcontainer(t.TempDir())
+		require.NoError(t, err)
+		assert.Empty(t, warnings)
+		assert.Equal(t, DefaultConfig(), config)
+	})
+
+	t.Run("dockerfile", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, ".devcontainer.json"), []byte(`{"build": {"dockerfile": "Dockerfile"}, "postCreateCommand": "npm ci"}`), 0644))
+
+		config := DefaultConfig()
+		warnings, err := config.ImportDevcontainer(dir)
+		require.NoError(t, err)
+		assert.Equal(t, defaultImage, config.BaseImage)
+		assert.Equal(t, []string{"npm ci"}, config.InstallCommands)
+		require.Len(t, warnings, 1)
+		assert.Contains(t, warnings[0], "Dockerfile")
+	})
+}
+
+func TestStripJSONC(t *testing.T) {
+	for _, tc := range []struct {
+		name, input, expected string
+	}{
+		{"line_comment", "{\"a\": 1 // one\n}", "{\"a\": 1 \n}"},
+		{"block_comment", `{/* a */"a": 1}`, `{"a": 1}`},
+		{"trailing_commas", "{\"a\": [1, 2,],\n}", "{\"a\": [1, 2]\n}"},
+		{"strings", `{"a": "// /* */ ,}", "b": "\"//"}`, `{"a": "// /* */ ,}", "b": "\"//"}`},
+		{"unterminated_comment", `{"a": 1} /* oops`, `{"a": 1} `},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, string(stripJSONC([]byte(tc.input))))
+		})
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"dagger.io/dagger"
+	petname "github.com/dustinkirkland/golang-petname"
 )
 
 // EnvironmentInfo contains basic metadata about an environment
@@ -34,9 +35,21 @@ type Environment struct {
 	Notes    Notes
 
 	mu sync.RWMutex
+	// secretFindings are the secrets written since the agent was last warned about them
+	secretFindings []SecretFinding
+	// onApply is called with the state of the environment every time it gets a new container, see OnApply
+	onApply func(ctx context.Context, phase string, state []byte)
+	// policy restricts the commands run in the environment, confirmed by confirmCommand, see SetCommandPolicy
+	policy         *CommandPolicy
+	confirmCommand CommandConfirmer
+	// repository tells the environment apart from the ones of other repositories with the same ID, see SetRepository
+	repository string
 }
 
 func New(ctx context.Context, dag *dagger.Client, id, title string, config *EnvironmentConfig, initialSourceDir *dagger.Directory) (*Environment, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 	env := &Environment{
 		EnvironmentInfo: &EnvironmentInfo{
 			ID: id,
@@ -56,7 +69,7 @@ func New(ctx context.Context, dag *dagger.Client, id, title string, config *Envi
 		return nil, err
 	}
 
-	slog.Info("Creating environment", "id", env.ID, "workdir", env.State.Config.Workdir)
+	slog.InfoContext(ctx, "Creating environment", "id", env.ID, "workdir", env.State.Config.Workdir)
 
 	if env.IsHost() {
 		if err := env.applyHost(ctx); err != nil {
@@ -64,6 +77,10 @@ func New(ctx context.Context, dag *dagger.Client, id, title string, config *Envi
 		}
 		return env, nil
 	}
+	// The pod was set by the build
+	if env.IsKubernetes() {
+		return env, nil
+	}
 
 	if err := env.apply(ctx, container); err != nil {
 		return nil, err
@@ -133,25 +150,65 @@ func LoadInfo(ctx context.Context, id string, state []byte, worktree string) (*E
 }
 
 func (env *Environment) apply(ctx context.Context, newState *dagger.Container) error {
-	// TODO(braa): is this sync redundant with newState.ID?
-	if _, err := newState.Sync(ctx); err != nil {
+	containerID, err := newState.ID(ctx)
+	if err != nil {
 		return err
 	}
 
-	containerID, err := newState.ID(ctx)
+	// The state with the new container is announced before the container is synced, then confirmed once it is,
+	// so an operation interrupted in-between never leaves the environment with a container that doesn't exist
+	env.mu.Lock()
+	next := *env.State
+	next.UpdatedAt = time.Now()
+	next.Container = string(containerID)
+	onApply := env.onApply
+	var state []byte
+	if onApply != nil {
+		state, err = next.Marshal()
+	}
+	env.mu.Unlock()
 	if err != nil {
+		slog.WarnContext(ctx, "Failed to encode environment state", "environment.id", env.ID, "err", err)
+		onApply = nil
+	}
+	if onApply != nil {
+		onApply(ctx, ApplyIntent, state)
+	}
+
+	if _, err := newState.Sync(ctx); err != nil {
 		return err
 	}
 
 	env.mu.Lock()
-	defer env.mu.Unlock()
-	env.State.UpdatedAt = time.Now()
-	env.State.Container = string(containerID)
+	env.State.UpdatedAt = next.UpdatedAt
+	env.State.Container = next.Container
+	dropReads(env.ID)
+	env.mu.Unlock()
 
+	if onApply != nil {
+		onApply(ctx, ApplyCommit, state)
+	}
 	return nil
 }
 
-func containerWithEnvAndSecrets(dag *dagger.Client, container *dagger.Container, envs, secrets []string) (*dagger.Container, error) {
+// Phases of applying a new container to an environment
+const (
+	// ApplyIntent is before the container is synced, which may never happen
+	ApplyIntent = "intent"
+	// ApplyCommit is once the container is synced and set in the state
+	ApplyCommit = "commit"
+)
+
+// OnApply sets the function called with the state of the environment every time it gets a new container,
+// e.g. to save it before the end of the operation. It is called with the same state for both phases of the apply:
+// states of intents without commit must be discarded.
+func (env *Environment) OnApply(fn func(ctx context.Context, phase string, state []byte)) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.onApply = fn
+}
+
+func containerWithEnvAndSecrets(ctx context.Context, dag *dagger.Client, container *dagger.Container, envs, secrets []string) (*dagger.Container, error) {
 	for _, env := range envs {
 		k, v, found := strings.Cut(env, "=")
 		if !found {
@@ -168,19 +225,44 @@ func containerWithEnvAndSecrets(dag *dagger.Client, container *dagger.Container,
 		if !found {
 			return nil, fmt.Errorf("invalid secret: %s", secret)
 		}
-		container = container.WithSecretVariable(k, dag.Secret(v))
+		secret, err := daggerSecret(ctx, dag, v)
+		if err != nil {
+			return nil, err
+		}
+		container = container.WithSecretVariable(k, secret)
 	}
 
 	return container, nil
 }
 
 func (env *Environment) buildBase(ctx context.Context, baseSourceDir *dagger.Directory) (*dagger.Container, error) {
+	if err := env.State.Config.Resources.Validate(); err != nil {
+		return nil, err
+	}
+	if err := env.State.Config.Caches.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Kubernetes execution path: the same steps in a new pod, no container to return
+	if env.IsKubernetes() {
+		return nil, env.buildPod(ctx, baseSourceDir)
+	}
+
 	// Host execution path: run setup/install directly in worktree and skip containers/services
 	if env.IsHost() {
-		hostEnv := env.buildHostEnv()
+		hostEnv, err := env.buildHostEnv(ctx)
+		if err != nil {
+			return nil, err
+		}
 		runCommands := func(commands []string) error {
 			for _, command := range commands {
-				cmd := exec.CommandContext(ctx, "sh", "-c", command)
+				// Stop between commands when the build is cancelled
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				env.recordEnvUsage(command)
+				args := env.limit([]string{"sh", "-c", command})
+				cmd := exec.CommandContext(ctx, args[0], args[1:]...)
 				cmd.Dir = env.State.Config.Workdir
 				cmd.Env = hostEnv
 
@@ -217,21 +299,31 @@ func (env *Environment) buildBase(ctx context.Context, baseSourceDir *dagger.Dir
 		return nil, nil
 	}
 
-	container := env.dag.
-		Container().
-		From(env.State.Config.BaseImage).
-		WithWorkdir(env.State.Config.Workdir)
+	if err := requireNetwork("pulling base image " + env.State.Config.BaseImage); err != nil {
+		return nil, err
+	}
+	base := env.dag.Container().From(env.State.Config.BaseImage)
+	// The digest of the image identifies the setup results that can be reused
+	imageRef, err := base.ImageRef(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve base image %s: %w", env.State.Config.BaseImage, err)
+	}
+	container := base.WithWorkdir(env.State.Config.Workdir)
 
-	container, err := containerWithEnvAndSecrets(env.dag, container, env.State.Config.Env, env.State.Config.Secrets)
+	container, err = containerWithEnvAndSecrets(ctx, env.dag, container, env.State.Config.Env, env.State.Config.Secrets)
 	if err != nil {
 		return nil, err
 	}
 
 	runCommands := func(commands []string) error {
 		for _, command := range commands {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			var err error
+			env.recordEnvUsage(command)
 
-			container = container.WithExec([]string{"sh", "-c", command})
+			container = container.WithExec(env.limit([]string{"sh", "-c", command}))
 
 			exitCode, err := container.ExitCode(ctx)
 			if err != nil {
@@ -259,10 +351,19 @@ func (env *Environment) buildBase(ctx context.Context, baseSourceDir *dagger.Dir
 		return nil
 	}
 
-	// Run setup commands without the source directory for caching purposes
-	if err := runCommands(env.State.Config.SetupCommands); err != nil {
-		return nil, fmt.Errorf("setup command failed: %w", err)
+	// Run setup commands without the source directory for caching purposes.
+	// Their result is shared by the environments created from the same image digest and commands.
+	setupKey := setupCacheKey(imageRef, env.State.Config.Workdir, env.State.Config.SetupCommands, env.State.Config.Env, env.State.Config.Secrets)
+	if cached := env.loadCachedSetup(ctx, setupKey, imageRef); cached != nil {
+		container = cached
+	} else {
+		if err := runCommands(env.State.Config.SetupCommands); err != nil {
+			return nil, fmt.Errorf("setup command failed: %w", err)
+		}
+		env.cacheSetup(ctx, setupKey, container)
 	}
+	// Caches are mounted after the setup commands so they don't change the setup results shared by environments
+	container = env.withCaches(container)
 
 	env.Services, err = env.startServices(ctx)
 	if err != nil {
@@ -283,12 +384,25 @@ func (env *Environment) buildBase(ctx context.Context, baseSourceDir *dagger.Dir
 }
 
 func (env *Environment) UpdateConfig(ctx context.Context, newConfig *EnvironmentConfig) error {
+	if err := newConfig.Validate(); err != nil {
+		return err
+	}
+	if changes, err := configChanges(env.State.Config, newConfig); err == nil && len(changes) > 0 {
+		fields := make([]string, 0, len(changes))
+		for _, change := range changes {
+			fields = append(fields, change.Field)
+		}
+		env.Notes.AddConfigChange("Update config: %s", strings.Join(fields, ", "))
+	}
+	if env.State.Pod != nil && (newConfig.Kubernetes == nil || strings.EqualFold(newConfig.BaseImage, "host")) {
+		return errors.New("the files of Kubernetes environments stay in their pod: create a new environment to leave Kubernetes mode")
+	}
 	env.State.Config = newConfig
 
-	// Re-build the base image with the new config
+	// Re-build the base image with the new config. New pods get the files of the previous one.
 	var container *dagger.Container
 	var err error
-	if env.IsHost() {
+	if env.IsHost() || env.State.Pod != nil {
 		container, err = env.buildBase(ctx, nil)
 	} else {
 		container, err = env.buildBase(ctx, env.Workdir())
@@ -296,6 +410,8 @@ func (env *Environment) UpdateConfig(ctx context.Context, newConfig *Environment
 	if err != nil {
 		return err
 	}
+	// Services started from built images are not part of the rebuilt container
+	env.State.BuiltServices = nil
 
 	if env.IsHost() {
 		if err := env.applyHost(ctx); err != nil {
@@ -303,6 +419,10 @@ func (env *Environment) UpdateConfig(ctx context.Context, newConfig *Environment
 		}
 		return nil
 	}
+	// The pod was replaced by the build
+	if env.IsKubernetes() {
+		return nil
+	}
 
 	if err := env.apply(ctx, container); err != nil {
 		return err
@@ -311,15 +431,40 @@ func (env *Environment) UpdateConfig(ctx context.Context, newConfig *Environment
 	return nil
 }
 
-func (env *Environment) Run(ctx context.Context, command, shell string, useEntrypoint bool) (string, error) {
+// Run runs a command in the environment and returns its output, along with why it failed when it exits with a non-zero code
+func (env *Environment) Run(ctx context.Context, command, shell string, useEntrypoint bool) (string, *FailureAnalysis, error) {
+	return env.run(ctx, command, shell, useEntrypoint, nil)
+}
+
+// commandCache is a cache volume mounted for a single command, with the variable pointing tools at it
+type commandCache struct {
+	volume string
+	path   string
+	envVar string
+}
+
+// run runs a command like Run. In containers, the caches are mounted for the command only.
+func (env *Environment) run(ctx context.Context, command, shell string, useEntrypoint bool, caches []commandCache) (string, *FailureAnalysis, error) {
+	if err := env.checkCommand(ctx, command); err != nil {
+		return "", nil, err
+	}
+	env.recordEnvUsage(command)
+	defer env.chargeCommandTime(time.Now())
 	if env.IsHost() {
 		if strings.TrimSpace(command) == "" {
-			return "", nil
+			return "", nil, nil
 		}
-		args := []string{shell, "-c", command}
+		if err := env.checkHostCommand(command); err != nil {
+			return "", nil, err
+		}
+		args := env.limit([]string{shell, "-c", command})
 		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
 		cmd.Dir = env.State.Config.Workdir
-		cmd.Env = env.buildHostEnv()
+		hostEnv, err := env.buildHostEnv(ctx)
+		if err != nil {
+			return "", nil, err
+		}
+		cmd.Env = hostEnv
 		output, err := cmd.CombinedOutput()
 		exitCode := 0
 		if err != nil {
@@ -335,14 +480,31 @@ func (env *Environment) Run(ctx context.Context, command, shell string, useEntry
 			stderr = err.Error()
 		}
 		env.Notes.AddCommand(command, exitCode, stdout, stderr)
-		return combineStdoutStderr(stdout, stderr), nil
+		// Commands killed because the call was cancelled or timed out didn't fail on their own
+		if err := ctx.Err(); err != nil {
+			return combineStdoutStderr(stdout, stderr), nil, err
+		}
+		return combineStdoutStderr(stdout, stderr), AnalyzeFailure(exitCode, stdout, stderr), nil
+	}
+	if env.IsKubernetes() {
+		return env.runInPod(ctx, command, shell)
 	}
 
 	args := []string{}
 	if command != "" {
 		args = []string{shell, "-c", command}
 	}
-	newState := env.container().WithExec(args, dagger.ContainerWithExecOpts{
+	container, cloudVariables, err := env.withCloudCredentials(ctx, env.container())
+	if err != nil {
+		return "", nil, err
+	}
+	for _, c := range caches {
+		container = container.WithMountedCache(c.path, env.dag.CacheVolume(c.volume))
+		if c.envVar != "" {
+			container = container.WithEnvVariable(c.envVar, c.path)
+		}
+	}
+	newState := container.WithExec(env.limit(args), dagger.ContainerWithExecOpts{
 		UseEntrypoint:                 useEntrypoint,
 		Expect:                        dagger.ReturnTypeAny, // Don't treat non-zero exit as error
 		ExperimentalPrivilegedNesting: true,
@@ -350,25 +512,36 @@ func (env *Environment) Run(ctx context.Context, command, shell string, useEntry
 
 	exitCode, err := newState.ExitCode(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to get exit code: %w", err)
+		return "", nil, fmt.Errorf("failed to get exit code: %w", err)
 	}
 
 	stdout, err := newState.Stdout(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to get stdout: %w", err)
+		return "", nil, fmt.Errorf("failed to get stdout: %w", err)
 	}
 
 	stderr, err := newState.Stderr(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to get stderr: %w", err)
+		return "", nil, fmt.Errorf("failed to get stderr: %w", err)
 	}
 
 	// Log the command execution with all details
 	env.Notes.AddCommand(command, exitCode, stdout, stderr)
 
+	// Credentials are set for each command, so they expire with their grant
+	for _, name := range cloudVariables {
+		newState = newState.WithoutSecretVariable(name)
+	}
+	for _, c := range caches {
+		newState = newState.WithoutMount(c.path)
+		if c.envVar != "" {
+			newState = newState.WithoutEnvVariable(c.envVar)
+		}
+	}
+
 	// Always apply the container state (preserving changes even on non-zero exit)
 	if err := env.apply(ctx, newState); err != nil {
-		return stdout, fmt.Errorf("failed to apply container state: %w", err)
+		return stdout, nil, fmt.Errorf("failed to apply container state: %w", err)
 	}
 
 	// Return combined output (stdout + stderr if there was stderr)
@@ -379,14 +552,21 @@ func (env *Environment) Run(ctx context.Context, command, shell string, useEntry
 		}
 		combinedOutput += "stderr: " + stderr
 	}
-	return combinedOutput, nil
+	return combinedOutput, AnalyzeFailure(exitCode, stdout, stderr), nil
 }
 
 func (env *Environment) RunBackground(ctx context.Context, command, shell string, ports []int, useEntrypoint bool) (EndpointMappings, error) {
+	if err := env.checkCommand(ctx, command); err != nil {
+		return nil, err
+	}
+	env.recordEnvUsage(command)
 	if env.IsHost() {
 		if strings.TrimSpace(command) == "" {
 			return nil, fmt.Errorf("background command is empty")
 		}
+		if err := env.checkHostCommand(command); err != nil {
+			return nil, err
+		}
 		// Choose ports; set PORT for single-port commands
 		chosen := make([]int, 0, len(ports))
 		for _, p := range ports {
@@ -396,21 +576,38 @@ func (env *Environment) RunBackground(ctx context.Context, command, shell string
 			}
 			chosen = append(chosen, cp)
 		}
-		envVars := env.buildHostEnv()
+		envVars, err := env.buildHostEnv(ctx)
+		if err != nil {
+			return nil, err
+		}
 		if len(chosen) == 1 {
 			// Add/override PORT
 			envVars = append(envVars, "PORT="+strconv.Itoa(chosen[0]))
 		}
 		displayCommand := command + " &"
-		args := []string{shell, "-c", command}
-		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		logFile, err := createProcessLog(env.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create process log: %w", err)
+		}
+		args := env.limit([]string{shell, "-c", command})
+		// Background processes outlive the tool call that started them
+		cmd := exec.CommandContext(context.WithoutCancel(ctx), args[0], args[1:]...)
 		cmd.Dir = env.State.Config.Workdir
 		cmd.Env = envVars
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
 		if err := cmd.Start(); err != nil {
+			logFile.Close()
+			os.Remove(logFile.Name())
 			// Record failure
 			env.Notes.AddCommand(displayCommand, 1, "", err.Error())
 			return nil, err
 		}
+		// Reap the process once it exits
+		go func() {
+			cmd.Wait()
+			logFile.Close()
+		}()
 		// Record PID
 		env.mu.Lock()
 		env.State.BackgroundProcesses = append(env.State.BackgroundProcesses, BackgroundProcess{
@@ -419,10 +616,12 @@ func (env *Environment) RunBackground(ctx context.Context, command, shell string
 			Shell:     shell,
 			Ports:     chosen,
 			Workdir:   env.State.Config.Workdir,
+			LogFile:   logFile.Name(),
 			StartedAt: time.Now(),
 		})
 		env.State.UpdatedAt = time.Now()
 		env.mu.Unlock()
+		env.sampleHostProcess(cmd.Process.Pid)
 
 		// Do not wait; treat as started
 		env.Notes.AddCommand(displayCommand, 0, "", "")
@@ -435,13 +634,55 @@ func (env *Environment) RunBackground(ctx context.Context, command, shell string
 		}
 		return endpoints, nil
 	}
+	if env.IsKubernetes() {
+		return env.runBackgroundInPod(ctx, command, shell, ports)
+	}
+
+	displayCommand := command + " &"
+	svc, err := env.startBackground(ctx, command, shell, ports, useEntrypoint)
+	if err != nil {
+		var exitErr *dagger.ExecError
+		if errors.As(err, &exitErr) {
+			env.Notes.AddCommand(displayCommand, exitErr.ExitCode, exitErr.Stdout, exitErr.Stderr)
+			return nil, fmt.Errorf("command failed with exit code %d.\nstdout: %s\nstderr: %s", exitErr.ExitCode, exitErr.Stdout, exitErr.Stderr)
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("service failed to start within %s timeout", serviceStartTimeout)
+			env.Notes.AddCommand(displayCommand, 137, "", err.Error())
+			return nil, err
+		}
+		return nil, err
+	}
+
+	// Recorded for a restarted server to start it again, see Resume
+	env.mu.Lock()
+	env.State.BackgroundServices = append(env.State.BackgroundServices, BackgroundService{
+		ID:            svc.ID,
+		Command:       command,
+		Shell:         shell,
+		Ports:         ports,
+		UseEntrypoint: useEntrypoint,
+		Container:     env.State.Container,
+		StartedAt:     svc.StartedAt,
+	})
+	env.State.UpdatedAt = time.Now()
+	env.mu.Unlock()
+	env.Notes.AddCommand(displayCommand, 0, "", "")
+
+	return svc.Endpoints, nil
+}
+
+// startBackground runs a command as a service on top of the environment's container, exposing the given ports on the host
+func (env *Environment) startBackground(ctx context.Context, command, shell string, ports []int, useEntrypoint bool) (*Service, error) {
+	return env.startBackgroundOn(ctx, env.container(), petname.Generate(2, "-"), command, shell, ports, useEntrypoint)
+}
 
+// startBackgroundOn runs a command as a service with the given ID on top of a container
+func (env *Environment) startBackgroundOn(ctx context.Context, serviceState *dagger.Container, id, command, shell string, ports []int, useEntrypoint bool) (*Service, error) {
 	args := []string{}
 	if command != "" {
 		args = []string{shell, "-c", command}
 	}
-	displayCommand := command + " &"
-	serviceState := env.container()
 
 	// Expose ports
 	for _, port := range ports {
@@ -455,50 +696,27 @@ func (env *Environment) RunBackground(ctx context.Context, command, shell string
 	startCtx, cancel := context.WithTimeout(ctx, serviceStartTimeout)
 	defer cancel()
 	svc, err := serviceState.AsService(dagger.ContainerAsServiceOpts{
-		Args:          args,
+		Args:          env.limit(args),
 		UseEntrypoint: useEntrypoint,
 	}).Start(startCtx)
 	if err != nil {
-		var exitErr *dagger.ExecError
-		if errors.As(err, &exitErr) {
-			env.Notes.AddCommand(displayCommand, exitErr.ExitCode, exitErr.Stdout, exitErr.Stderr)
-			return nil, fmt.Errorf("command failed with exit code %d.\nstdout: %s\nstderr: %s", exitErr.ExitCode, exitErr.Stdout, exitErr.Stderr)
-		}
-		if errors.Is(err, context.DeadlineExceeded) {
-			err = fmt.Errorf("service failed to start within %s timeout", serviceStartTimeout)
-			env.Notes.AddCommand(displayCommand, 137, "", err.Error())
-			return nil, err
-		}
 		return nil, err
 	}
 
-	env.Notes.AddCommand(displayCommand, 0, "", "")
-
-	endpoints := EndpointMappings{}
+	service := &Service{
+		ID:        id,
+		Config:    &ServiceConfig{Command: command, ExposedPorts: ports},
+		Endpoints: EndpointMappings{},
+		StartedAt: time.Now(),
+		svc:       svc,
+	}
 	for _, port := range ports {
-		endpoint := &EndpointMapping{}
-		endpoints[port] = endpoint
-
 		// Expose port on the host
-		tunnel, err := env.dag.Host().Tunnel(svc, dagger.HostTunnelOpts{
-			Ports: []dagger.PortForward{
-				{
-					Backend:  port,
-					Protocol: dagger.NetworkProtocolTcp,
-				},
-			},
-		}).Start(ctx)
+		tunnel, externalEndpoint, err := env.tunnel(ctx, svc, port)
 		if err != nil {
 			return nil, err
 		}
-
-		externalEndpoint, err := tunnel.Endpoint(ctx, dagger.ServiceEndpointOpts{
-			Scheme: "tcp",
-		})
-		if err != nil {
-			return nil, err
-		}
-		endpoint.HostExternal = externalEndpoint
+		service.tunnels = append(service.tunnels, tunnel)
 
 		internalEndpoint, err := svc.Endpoint(ctx, dagger.ServiceEndpointOpts{
 			Port:   port,
@@ -507,39 +725,25 @@ func (env *Environment) RunBackground(ctx context.Context, command, shell string
 		if err != nil {
 			return nil, err
 		}
-		endpoint.EnvironmentInternal = internalEndpoint
+		service.Endpoints[port] = &EndpointMapping{
+			EnvironmentInternal: internalEndpoint,
+			HostExternal:        externalEndpoint,
+		}
 	}
 
-	return endpoints, nil
+	env.track(service)
+	return service, nil
 }
 
 func (env *Environment) Terminal(ctx context.Context) error {
 	if env.IsHost() {
 		return fmt.Errorf("interactive terminal is not supported in host mode")
 	}
-	container := env.container()
-	var cmd []string
-	var sourceRC string
-	if shells, err := container.File("/etc/shells").Contents(ctx); err == nil {
-		for shell := range strings.Lines(shells) {
-			if shell[0] == '#' {
-				continue
-			}
-			shell = strings.TrimRight(shell, "\n")
-			if strings.HasSuffix(shell, "/bash") {
-				sourceRC = fmt.Sprintf("[ -f ~/.bashrc ] && . ~/.bashrc; %q --version | head -4; ", shell)
-				cmd = []string{shell, "--rcfile", "/cu/rc.sh", "-i"}
-				break
-			}
-		}
-	}
-	// Try to show the same pretty PS1 as for the default /bin/sh terminal in dagger
-	container = container.WithNewFile("/cu/rc.sh", sourceRC+`export PS1="\033[33mcu\033[0m \033[02m\$(pwd | sed \"s|^\$HOME|~|\")\033[0m \$ "`+"\n")
-	if cmd == nil {
-		// If bash not available, assume POSIX shell
-		container = container.WithEnvVariable("ENV", "/cu/rc.sh")
-		cmd = []string{"sh"}
+	if env.IsKubernetes() {
+		return env.podTerminal(ctx)
 	}
+	cmd, rc := interactiveShell(ctx, env.container())
+	container := env.container().WithNewFile(shellRCPath, rc).WithEnvVariable("ENV", shellRCPath)
 	if _, err := container.Terminal(dagger.ContainerTerminalOpts{
 		ExperimentalPrivilegedNesting: true,
 		Cmd:                           cmd,
@@ -549,13 +753,6 @@ func (env *Environment) Terminal(ctx context.Context) error {
 	return nil
 }
 
-func (env *Environment) Checkpoint(ctx context.Context, target string) (string, error) {
-	if env.IsHost() {
-		return "", fmt.Errorf("checkpoint is not supported in host mode")
-	}
-	return env.container().Publish(ctx, target)
-}
-
 // IsHost reports whether this environment runs directly on the host (no containers)
 func (env *Environment) IsHost() bool {
 	return strings.EqualFold(env.State.Config.BaseImage, "host")
@@ -588,23 +785,32 @@ func execEnv() []string {
 }
 
 // buildHostEnv merges host environment with configured env vars and secrets
-func (env *Environment) buildHostEnv() []string {
+func (env *Environment) buildHostEnv(ctx context.Context) ([]string, error) {
 	base := os.Environ()
 	// Add/override regular env vars
 	for _, kv := range env.State.Config.Env {
 		base = append(base, kv)
 	}
-	// Secrets are provided as KEY=ENV_NAME; we resolve from the host's environment
+	// Secrets are provided as KEY=REFERENCE and resolved by their provider for every command
 	for _, kv := range env.State.Config.Secrets {
-		k, v, ok := strings.Cut(kv, "=")
+		k, ref, ok := strings.Cut(kv, "=")
 		if !ok {
-			continue
+			return nil, fmt.Errorf("invalid secret: %s", kv)
 		}
-		if val, found := os.LookupEnv(v); found {
-			base = append(base, fmt.Sprintf("%s=%s", k, val))
+		val, err := ResolveSecret(ctx, ref)
+		if err != nil {
+			return nil, err
 		}
+		base = append(base, fmt.Sprintf("%s=%s", k, val))
+	}
+	credentials, err := env.cloudCredentialValues(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range credentials {
+		base = append(base, fmt.Sprintf("%s=%s", name, value))
 	}
-	return base
+	return base, nil
 }
 
 // chooseHostPort returns a usable port; 0 or unavailable port picks a random free port
@@ -651,6 +857,8 @@ func (env *Environment) KillBackground(pid int) error {
 	for _, bp := range env.State.BackgroundProcesses {
 		if bp.PID != pid {
 			newList = append(newList, bp)
+		} else if bp.LogFile != "" {
+			os.Remove(bp.LogFile)
 		}
 	}
 	env.State.BackgroundProcesses = newList
@@ -0,0 +1,176 @@
+package environment
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Categories of command failures
+const (
+	FailureMissingBinary    = "missing_binary"
+	FailureMissingModule    = "missing_module"
+	FailurePortInUse        = "port_in_use"
+	FailurePermissionDenied = "permission_denied"
+	FailureOOMKilled        = "oom_killed"
+	FailureUnknown          = "unknown"
+)
+
+// FailureAnalysis tells why a command failed, so agents can correct it without parsing its output
+type FailureAnalysis struct {
+	Category string `json:"category"`
+	ExitCode int    `json:"exit_code"`
+	// Subject is what the failure is about when known: the binary, module or port
+	Subject string `json:"subject,omitempty"`
+	// Evidence is the line of the output the failure was recognized from
+	Evidence   string `json:"evidence,omitempty"`
+	Suggestion string `json:"suggestion"`
+}
+
+type failureRule struct {
+	category string
+	// re matches a line of the output, its first group being the subject
+	re *regexp.Regexp
+	// exitCode, when set, is the only exit code the rule applies to
+	exitCode   int
+	suggestion func(subject string) string
+}
+
+// failureRules are tried in order: the more specific failures first, since e.g. binding a port may be denied
+var failureRules = []failureRule{
+	{
+		category:   FailureOOMKilled,
+		re:         regexp.MustCompile(`(?i)(out of memory|oomkilled|cannot allocate memory|heap out of memory|MemoryError|fatal error: runtime: out of memory)`),
+		suggestion: oomSuggestion,
+	},
+	{
+		category:   FailureOOMKilled,
+		re:         regexp.MustCompile(`^\s*Killed\s*$`),
+		exitCode:   137,
+		suggestion: oomSuggestion,
+	},
+	{
+		category: FailureMissingModule,
+		re: regexp.MustCompile(`(?:ModuleNotFoundError: No module named '([^']+)'|` +
+			`ImportError: No module named '?([\w.]+)|` +
+			`Cannot find (?:module|package) '([^']+)'|` +
+			`no required module provides package ([^\s;:]+)|` +
+			`cannot find package "([^"]+)"|` +
+			`cannot load such file -- (\S+)|` +
+			`Can't locate (\S+) in @INC)`),
+		suggestion: func(module string) string {
+			return fmt.Sprintf("Install %s with the package manager of the project (e.g. pip install, npm install, go get), "+
+				"and add it to the install commands of the environment with environment_config so it's kept.", subjectOr(module, "the missing dependency"))
+		},
+	},
+	{
+		category: FailureMissingBinary,
+		re: regexp.MustCompile(`(?:^(?:\S+: )?(?:line \d+: |\d+: )?([^\s:]+): (?:command )?not found$|` +
+			`command not found: (\S+)|` +
+			`exec: "([^"]+)": executable file not found)`),
+		suggestion: missingBinarySuggestion,
+	},
+	{
+		// Files that don't exist are only missing binaries when the shell couldn't run them
+		category:   FailureMissingBinary,
+		re:         regexp.MustCompile(`^(?:\S+: )?(?:line \d+: |\d+: )?([^\s:]+): No such file or directory$`),
+		exitCode:   127,
+		suggestion: missingBinarySuggestion,
+	},
+	{
+		category: FailurePortInUse,
+		re:       regexp.MustCompile(`(?i)(?:address already in use|EADDRINUSE|port (\d+) is already (?:in use|allocated))`),
+		suggestion: func(port string) string {
+			if port == "" {
+				port = "The port"
+			} else {
+				port = "Port " + port
+			}
+			return fmt.Sprintf("%s is used by another process, likely a background command of the environment: "+
+				"stop it with environment_kill_background, or run the command on another port.", port)
+		},
+	},
+	{
+		category: FailurePermissionDenied,
+		re:       regexp.MustCompile(`(?i)(?:permission denied|EACCES|operation not permitted)`),
+		suggestion: func(string) string {
+			return "Check the permissions of the files involved (e.g. chmod +x for scripts) and the user the command runs as. " +
+				"Privileged ports (below 1024) and system directories need root."
+		},
+	},
+}
+
+func oomSuggestion(string) string {
+	return "The command ran out of memory, or was killed. Reduce the memory it uses (e.g. fewer parallel jobs), " +
+		"or raise resources.memory in the configuration of the environment with environment_config."
+}
+
+func missingBinarySuggestion(binary string) string {
+	return fmt.Sprintf("Install %s through the install commands of environment_config (e.g. with the package manager of the base image), "+
+		"or use a base image that ships it. If it's a script of the project, check its path and that it is executable.", subjectOr(binary, "the missing command"))
+}
+
+// AnalyzeFailure classifies the failure of a command from its exit code and output. It returns nil when the command succeeded.
+func AnalyzeFailure(exitCode int, stdout, stderr string) *FailureAnalysis {
+	if exitCode == 0 {
+		return nil
+	}
+	// Errors are usually on stderr, but not always
+	lines := append(strings.Split(stderr, "\n"), strings.Split(stdout, "\n")...)
+	for _, rule := range failureRules {
+		if rule.exitCode != 0 && rule.exitCode != exitCode {
+			continue
+		}
+		for _, line := range lines {
+			line = strings.TrimSpace(line)
+			match := rule.re.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			subject := ""
+			for _, group := range match[1:] {
+				if group != "" {
+					subject = group
+					break
+				}
+			}
+			if rule.category == FailureOOMKilled {
+				subject = ""
+			}
+			return &FailureAnalysis{
+				Category:   rule.category,
+				ExitCode:   exitCode,
+				Subject:    subject,
+				Evidence:   line,
+				Suggestion: rule.suggestion(subject),
+			}
+		}
+	}
+
+	// Shells exit with 127 for commands not found, and processes killed by the OOM killer with 137 (SIGKILL)
+	analysis := &FailureAnalysis{Category: FailureUnknown, ExitCode: exitCode}
+	switch exitCode {
+	case 127:
+		analysis.Category = FailureMissingBinary
+	case 137:
+		analysis.Category = FailureOOMKilled
+	default:
+		analysis.Suggestion = "Read the output of the command to find the cause of the failure."
+		return analysis
+	}
+	for _, rule := range failureRules {
+		if rule.category == analysis.Category {
+			analysis.Suggestion = rule.suggestion("")
+			break
+		}
+	}
+	return analysis
+}
+
+// subjectOr quotes the subject of a failure, or returns a generic name when it's unknown
+func subjectOr(subject, fallback string) string {
+	if subject == "" {
+		return fallback
+	}
+	return fmt.Sprintf("%q", subject)
+}
@@ -0,0 +1,49 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzeFailure(t *testing.T) {
+	assert.Nil(t, AnalyzeFailure(0, "ok", "sh: 1: foo: not found"), "successful commands are not analyzed")
+
+	for _, tc := range []struct {
+		name     string
+		exitCode int
+		stdout   string
+		stderr   string
+		category string
+		subject  string
+	}{
+		{"dash", 127, "", "sh: 1: rg: not found", FailureMissingBinary, "rg"},
+		{"bash", 127, "", "bash: line 1: jq: command not found", FailureMissingBinary, "jq"},
+		{"script", 127, "", "sh: 1: ./run.sh: No such file or directory", FailureMissingBinary, "./run.sh"},
+		{"exit_code_only", 127, "", "", FailureMissingBinary, ""},
+		{"python", 1, "", "Traceback (most recent call last):\nModuleNotFoundError: No module named 'requests'", FailureMissingModule, "requests"},
+		{"node", 1, "", "Error: Cannot find module 'express'\nRequire stack:", FailureMissingModule, "express"},
+		{"go", 1, "", "main.go:3:8: no required module provides package github.com/pkg/errors; to add it:", FailureMissingModule, "github.com/pkg/errors"},
+		{"port", 1, "listen tcp :8080: bind: address already in use", "", FailurePortInUse, ""},
+		{"port_number", 1, "", "Error: port 3000 is already in use", FailurePortInUse, "3000"},
+		{"permission", 126, "", "sh: 1: ./build.sh: Permission denied", FailurePermissionDenied, ""},
+		{"killed", 137, "", "Killed", FailureOOMKilled, ""},
+		{"heap", 134, "", "FATAL ERROR: Reached heap limit Allocation failed - JavaScript heap out of memory", FailureOOMKilled, ""},
+		{"unknown", 1, "", "FAIL: TestFoo", FailureUnknown, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			failure := AnalyzeFailure(tc.exitCode, tc.stdout, tc.stderr)
+			require.NotNil(t, failure)
+			assert.Equal(t, tc.category, failure.Category)
+			assert.Equal(t, tc.subject, failure.Subject)
+			assert.Equal(t, tc.exitCode, failure.ExitCode)
+			assert.NotEmpty(t, failure.Suggestion)
+		})
+	}
+
+	t.Run("missing_files_are_not_missing_binaries", func(t *testing.T) {
+		failure := AnalyzeFailure(1, "", "cat: notes.txt: No such file or directory")
+		assert.Equal(t, FailureUnknown, failure.Category)
+	})
+}
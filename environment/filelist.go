@@ -0,0 +1,161 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+const (
+	defaultListMaxDepth   = 3
+	defaultListMaxEntries = 1000
+)
+
+type FileListOptions struct {
+	// Path is the directory to list, absolute or relative to the workdir. Defaults to the workdir.
+	Path string
+	// MaxDepth is the number of levels listed, 1 listing the direct entries of Path. Defaults to 3.
+	MaxDepth int
+	// Include and Exclude are glob patterns (e.g. `**/*.go`) matched against paths relative to Path.
+	// With Include, only the matching files are listed, not the directories.
+	Include    []string
+	Exclude    []string
+	MaxEntries int
+}
+
+type FileListEntry struct {
+	// Path is relative to the listed directory
+	Path    string    `json:"path"`
+	Dir     bool      `json:"dir"`
+	Symlink bool      `json:"symlink,omitempty"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"mtime"`
+}
+
+type FileListResult struct {
+	Entries   []FileListEntry `json:"entries"`
+	Truncated bool            `json:"truncated,omitempty"`
+}
+
+// FileListTree lists a directory of the environment recursively, with the metadata of the entries
+func (env *Environment) FileListTree(ctx context.Context, opts FileListOptions) (*FileListResult, error) {
+	if opts.MaxDepth <= 0 {
+		opts.MaxDepth = defaultListMaxDepth
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = defaultListMaxEntries
+	}
+
+	if env.IsHost() {
+		root, err := env.hostPath(opts.Path, false)
+		if err != nil {
+			return nil, err
+		}
+		return listDir(root, opts)
+	}
+
+	root := opts.Path
+	if root == "" {
+		root = env.State.Config.Workdir
+	}
+	if env.IsKubernetes() {
+		pod, err := env.pod()
+		if err != nil {
+			return nil, err
+		}
+		tmp, err := os.MkdirTemp("", "container-use-list-*")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmp)
+		if !filepath.IsAbs(root) {
+			root = filepath.Join(env.State.Config.Workdir, root)
+		}
+		if err := pod.copyOut(ctx, root, tmp); err != nil {
+			return nil, fmt.Errorf("failed to load files: %w", err)
+		}
+		return listDir(tmp, opts)
+	}
+	key := fmt.Sprintf("tree:%s:%d:%q:%q:%d", root, opts.MaxDepth, opts.Include, opts.Exclude, opts.MaxEntries)
+	return cachedRead(env, key, func(container *dagger.Container) (*FileListResult, error) {
+		// Filters are pushed down to dagger so only the files to list are exported, see FileSearch
+		filter := dagger.DirectoryFilterOpts{
+			Exclude: append(normalizeGlobs(opts.Exclude), "**/.git"),
+		}
+		if len(opts.Include) > 0 {
+			filter.Include = normalizeGlobs(opts.Include)
+		}
+
+		tmp, err := os.MkdirTemp("", "container-use-list-*")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmp)
+		if _, err := container.Directory(root).Filter(filter).Export(ctx, tmp); err != nil {
+			return nil, fmt.Errorf("failed to load files: %w", err)
+		}
+		return listDir(tmp, opts)
+	})
+}
+
+func listDir(root string, opts FileListOptions) (*FileListResult, error) {
+	include := compileGlobs(opts.Include)
+	exclude := compileGlobs(opts.Exclude)
+
+	result := &FileListResult{Entries: []FileListEntry{}}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+
+		if d.Name() == ".git" || matchAny(exclude, rel) {
+			// .git is a file in worktrees
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if len(include) == 0 || (!d.IsDir() && matchAny(include, rel)) {
+			if len(result.Entries) >= opts.MaxEntries {
+				result.Truncated = true
+				return filepath.SkipAll
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			result.Entries = append(result.Entries, FileListEntry{
+				Path:    rel,
+				Dir:     d.IsDir(),
+				Symlink: d.Type()&fs.ModeSymlink != 0,
+				Size:    info.Size(),
+				Mode:    fmt.Sprintf("%04o", info.Mode().Perm()),
+				ModTime: info.ModTime().UTC(),
+			})
+		}
+		if d.IsDir() && strings.Count(rel, "/")+1 >= opts.MaxDepth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
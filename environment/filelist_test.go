@@ -0,0 +1,56 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListDir(t *testing.T) {
+	root := t.TempDir()
+	writeSearchFile(t, root, "main.go", "package main\n")
+	writeSearchFile(t, root, "cmd/app/main.go", "package main\n")
+	writeSearchFile(t, root, "cmd/app/deep/util.go", "package deep\n")
+	writeSearchFile(t, root, "node_modules/foo/index.js", "")
+	writeSearchFile(t, root, ".git", "gitdir: /repos/project/worktrees/env")
+
+	paths := func(result *FileListResult) []string {
+		res := []string{}
+		for _, entry := range result.Entries {
+			res = append(res, entry.Path)
+		}
+		return res
+	}
+
+	t.Run("depth", func(t *testing.T) {
+		result, err := listDir(root, FileListOptions{MaxDepth: 2, MaxEntries: 100})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"cmd", "cmd/app", "main.go", "node_modules", "node_modules/foo"}, paths(result))
+
+		assert.True(t, result.Entries[0].Dir)
+		main := result.Entries[2]
+		assert.False(t, main.Dir)
+		assert.Equal(t, int64(len("package main\n")), main.Size)
+		assert.Equal(t, "0644", main.Mode)
+		assert.False(t, main.ModTime.IsZero())
+	})
+
+	t.Run("include_exclude", func(t *testing.T) {
+		result, err := listDir(root, FileListOptions{
+			MaxDepth:   10,
+			Include:    []string{"*.go"},
+			Exclude:    []string{"deep"},
+			MaxEntries: 100,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"cmd/app/main.go", "main.go"}, paths(result))
+	})
+
+	t.Run("max_entries", func(t *testing.T) {
+		result, err := listDir(root, FileListOptions{MaxDepth: 10, MaxEntries: 3})
+		require.NoError(t, err)
+		assert.Len(t, result.Entries, 3)
+		assert.True(t, result.Truncated)
+	})
+}
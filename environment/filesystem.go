@@ -8,14 +8,15 @@ import (
 	"path/filepath"
 	"strings"
 
+	"dagger.io/dagger"
 	godiffpatch "github.com/sourcegraph/go-diff-patch"
 )
 
 func (env *Environment) FileRead(ctx context.Context, targetFile string, shouldReadEntireFile bool, startLineOneIndexedInclusive int, endLineOneIndexedInclusive int) (string, error) {
 	if env.IsHost() {
-		path := targetFile
-		if !filepath.IsAbs(path) {
-			path = filepath.Join(env.State.Config.Workdir, targetFile)
+		path, err := env.hostPath(targetFile, false)
+		if err != nil {
+			return "", err
 		}
 		data, err := os.ReadFile(path)
 		if err != nil {
@@ -44,7 +45,7 @@ func (env *Environment) FileRead(ctx context.Context, targetFile string, shouldR
 		return strings.Join(lines[start:end], "\n"), nil
 	}
 
-	file, err := env.container().File(targetFile).Contents(ctx)
+	file, err := env.readFile(ctx, targetFile)
 	if err != nil {
 		return "", err
 	}
@@ -74,10 +75,13 @@ func (env *Environment) FileRead(ctx context.Context, targetFile string, shouldR
 }
 
 func (env *Environment) FileWrite(ctx context.Context, explanation, targetFile, contents string) error {
+	if err := env.checkSecrets(targetFile, contents, 1); err != nil {
+		return err
+	}
 	if env.IsHost() {
-		path := targetFile
-		if !filepath.IsAbs(path) {
-			path = filepath.Join(env.State.Config.Workdir, targetFile)
+		path, err := env.hostPath(targetFile, true)
+		if err != nil {
+			return err
 		}
 		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 			return fmt.Errorf("failed to create directories: %w", err)
@@ -85,22 +89,29 @@ func (env *Environment) FileWrite(ctx context.Context, explanation, targetFile,
 		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
 			return fmt.Errorf("failed writing file: %w", err)
 		}
-		env.Notes.Add("Write %s", targetFile)
+		env.Notes.AddFileChange([]string{targetFile}, "Write %s", targetFile)
+		return nil
+	}
+	if env.IsKubernetes() {
+		if err := env.writePodFile(ctx, targetFile, contents); err != nil {
+			return fmt.Errorf("failed applying file write, skipping git propagation: %w", err)
+		}
+		env.Notes.AddFileChange([]string{targetFile}, "Write %s", targetFile)
 		return nil
 	}
 	err := env.apply(ctx, env.container().WithNewFile(targetFile, contents))
 	if err != nil {
 		return fmt.Errorf("failed applying file write, skipping git propagation: %w", err)
 	}
-	env.Notes.Add("Write %s", targetFile)
+	env.Notes.AddFileChange([]string{targetFile}, "Write %s", targetFile)
 	return nil
 }
 
 func (env *Environment) FileEdit(ctx context.Context, explanation, targetFile, search, replace, matchID string) error {
 	if env.IsHost() {
-		path := targetFile
-		if !filepath.IsAbs(path) {
-			path = filepath.Join(env.State.Config.Workdir, targetFile)
+		path, err := env.hostPath(targetFile, true)
+		if err != nil {
+			return err
 		}
 		contentsBytes, err := os.ReadFile(path)
 		if err != nil {
@@ -164,17 +175,20 @@ func (env *Environment) FileEdit(ctx context.Context, explanation, targetFile, s
 
 		// Replace the specific match
 		newContents := contents[:targetMatchIndex] + replace + contents[targetMatchIndex+len(search):]
+		if err := env.checkEditSecrets(targetFile, newContents, targetMatchIndex, replace); err != nil {
+			return err
+		}
 		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 			return fmt.Errorf("failed to create directories: %w", err)
 		}
 		if err := os.WriteFile(path, []byte(newContents), 0644); err != nil {
 			return fmt.Errorf("failed writing file: %w", err)
 		}
-		env.Notes.Add("Edit %s", targetFile)
+		env.Notes.AddFileChange([]string{targetFile}, "Edit %s", targetFile)
 		return nil
 	}
 
-	contents, err := env.container().File(targetFile).Contents(ctx)
+	contents, err := env.readFile(ctx, targetFile)
 	if err != nil {
 		return err
 	}
@@ -235,6 +249,17 @@ func (env *Environment) FileEdit(ctx context.Context, explanation, targetFile, s
 
 	// Replace the specific match
 	newContents := contents[:targetMatchIndex] + replace + contents[targetMatchIndex+len(search):]
+	if err := env.checkEditSecrets(targetFile, newContents, targetMatchIndex, replace); err != nil {
+		return err
+	}
+
+	if env.IsKubernetes() {
+		if err := env.writePodFile(ctx, targetFile, newContents); err != nil {
+			return fmt.Errorf("failed applying file edit, skipping git propagation: %w", err)
+		}
+		env.Notes.AddFileChange([]string{targetFile}, "Edit %s", targetFile)
+		return nil
+	}
 
 	// Apply the changes using `Directory.withPatch` so we don't have to spit out
 	// the entire contents
@@ -244,35 +269,42 @@ func (env *Environment) FileEdit(ctx context.Context, explanation, targetFile, s
 	if err != nil {
 		return fmt.Errorf("failed applying file edit, skipping git propagation: %w", err)
 	}
-	env.Notes.Add("Edit %s", targetFile)
+	env.Notes.AddFileChange([]string{targetFile}, "Edit %s", targetFile)
 	return nil
 }
 
 func (env *Environment) FileDelete(ctx context.Context, explanation, targetFile string) error {
 	if env.IsHost() {
-		path := targetFile
-		if !filepath.IsAbs(path) {
-			path = filepath.Join(env.State.Config.Workdir, targetFile)
+		path, err := env.hostPath(targetFile, true)
+		if err != nil {
+			return err
 		}
 		if err := os.Remove(path); err != nil {
 			return fmt.Errorf("failed deleting file: %w", err)
 		}
-		env.Notes.Add("Delete %s", targetFile)
+		env.Notes.AddFileChange([]string{targetFile}, "Delete %s", targetFile)
+		return nil
+	}
+	if env.IsKubernetes() {
+		if err := env.deletePodFile(ctx, targetFile); err != nil {
+			return fmt.Errorf("failed applying file delete, skipping git propagation: %w", err)
+		}
+		env.Notes.AddFileChange([]string{targetFile}, "Delete %s", targetFile)
 		return nil
 	}
 	err := env.apply(ctx, env.container().WithoutFile(targetFile))
 	if err != nil {
 		return fmt.Errorf("failed applying file delete, skipping git propagation: %w", err)
 	}
-	env.Notes.Add("Delete %s", targetFile)
+	env.Notes.AddFileChange([]string{targetFile}, "Delete %s", targetFile)
 	return nil
 }
 
 func (env *Environment) FileList(ctx context.Context, path string) (string, error) {
 	if env.IsHost() {
-		dirPath := path
-		if !filepath.IsAbs(dirPath) {
-			dirPath = filepath.Join(env.State.Config.Workdir, path)
+		dirPath, err := env.hostPath(path, false)
+		if err != nil {
+			return "", err
 		}
 		entries, err := os.ReadDir(dirPath)
 		if err != nil {
@@ -284,7 +316,15 @@ func (env *Environment) FileList(ctx context.Context, path string) (string, erro
 		}
 		return out.String(), nil
 	}
-	entries, err := env.container().Directory(path).Entries(ctx)
+	var entries []string
+	var err error
+	if env.IsKubernetes() {
+		entries, err = env.listPodDir(ctx, path)
+	} else {
+		entries, err = cachedRead(env, "dir:"+path, func(container *dagger.Container) ([]string, error) {
+			return container.Directory(path).Entries(ctx)
+		})
+	}
 	if err != nil {
 		return "", err
 	}
@@ -295,6 +335,16 @@ func (env *Environment) FileList(ctx context.Context, path string) (string, erro
 	return out.String(), nil
 }
 
+// readFile reads a file of the container, from the read cache if it was already read, or a file of the pod
+func (env *Environment) readFile(ctx context.Context, targetFile string) (string, error) {
+	if env.IsKubernetes() {
+		return env.readPodFile(ctx, targetFile)
+	}
+	return cachedRead(env, "file:"+targetFile, func(container *dagger.Container) (string, error) {
+		return container.File(targetFile).Contents(ctx)
+	})
+}
+
 // generateMatchID creates a unique ID for a match based on file, search, replace, and index
 func generateMatchID(targetFile, search, replace string, index int) string {
 	data := fmt.Sprintf("%s:%s:%s:%d", targetFile, search, replace, index)
@@ -317,6 +367,11 @@ func getMatchContext(contents string, matchIndex int) string {
 		currentPos += len(line) + 1 // +1 for newline
 	}
 
+	return getLinesContext(lines, matchLine)
+}
+
+// getLinesContext formats the lines around matchLine (zero-indexed) with line numbers
+func getLinesContext(lines []string, matchLine int) string {
 	// Get context lines (3 before, match line, 3 after)
 	start := max(0, matchLine-3)
 	end := min(len(lines), matchLine+4)
@@ -0,0 +1,164 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// formatter formats the files with its extensions. Its script receives the files as arguments and only reports
+// errors, on stderr, printing "missing <name>" when none of its tools is installed.
+type formatter struct {
+	name       string
+	extensions []string
+	script     string
+}
+
+var formatters = []formatter{
+	{
+		name:       "gofmt",
+		extensions: []string{".go"},
+		script: `if command -v goimports >/dev/null 2>&1; then goimports -w "$@" >/dev/null
+elif command -v gofmt >/dev/null 2>&1; then gofmt -w "$@" >/dev/null
+else echo 'missing gofmt'; fi`,
+	},
+	{
+		name:       "ruff",
+		extensions: []string{".py", ".pyi"},
+		script: `if command -v ruff >/dev/null 2>&1; then ruff format -q -- "$@" >/dev/null
+elif command -v black >/dev/null 2>&1; then black -q -- "$@" >/dev/null
+else echo 'missing ruff'; fi`,
+	},
+	{
+		name: "prettier",
+		extensions: []string{".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx", ".mts", ".cts", ".json", ".css", ".scss",
+			".less", ".html", ".vue", ".md", ".mdx", ".yaml", ".yml", ".graphql"},
+		// npx would download prettier: only the one of the project or of the image is used
+		script: `if [ -x node_modules/.bin/prettier ]; then node_modules/.bin/prettier --write -- "$@" >/dev/null
+elif command -v prettier >/dev/null 2>&1; then prettier --write -- "$@" >/dev/null
+else echo 'missing prettier'; fi`,
+	},
+	{
+		name:       "rustfmt",
+		extensions: []string{".rs"},
+		script: `if command -v rustfmt >/dev/null 2>&1; then rustfmt --edition 2021 -- "$@" >/dev/null
+else echo 'missing rustfmt'; fi`,
+	},
+}
+
+// FormatResult is the result of formatting the files changed in an environment
+type FormatResult struct {
+	// Reformatted are the files the formatters changed
+	Reformatted []string `json:"reformatted"`
+	// Formatters are the formatters run
+	Formatters []string `json:"formatters,omitempty"`
+	// Missing are the formatters not installed in the environment, with the files they would have formatted
+	Missing map[string][]string `json:"missing,omitempty"`
+	// Errors is what the formatters reported, e.g. syntax errors of files they couldn't format
+	Errors string `json:"errors,omitempty"`
+}
+
+// formatPlan groups files by formatter, in the order of formatters. Files without formatter are left out.
+func formatPlan(files []string) map[string][]string {
+	plan := map[string][]string{}
+	for _, file := range files {
+		ext := strings.ToLower(filepath.Ext(file))
+		for _, f := range formatters {
+			if slices.Contains(f.extensions, ext) {
+				plan[f.name] = append(plan[f.name], file)
+				break
+			}
+		}
+	}
+	return plan
+}
+
+// formatScript formats the files of the plan, printing their checksums before and after along with the
+// formatters missing, so the files reformatted can be told
+func formatScript(plan map[string][]string) string {
+	var script strings.Builder
+	all := []string{}
+	for _, f := range formatters {
+		for _, file := range plan[f.name] {
+			all = append(all, shellQuote(file))
+		}
+	}
+	checksums := "cksum -- " + strings.Join(all, " ") + " 2>/dev/null"
+	fmt.Fprintf(&script, "%s | sed 's/^/before /'\n", checksums)
+	for _, f := range formatters {
+		files := plan[f.name]
+		if len(files) == 0 {
+			continue
+		}
+		quoted := make([]string, len(files))
+		for i, file := range files {
+			quoted[i] = shellQuote(file)
+		}
+		fmt.Fprintf(&script, "set -- %s\n%s\n", strings.Join(quoted, " "), f.script)
+	}
+	fmt.Fprintf(&script, "%s | sed 's/^/after /'\n", checksums)
+	return script.String()
+}
+
+// parseFormatOutput reads the output of formatScript
+func parseFormatOutput(plan map[string][]string, stdout, stderr string) *FormatResult {
+	result := &FormatResult{Reformatted: []string{}, Errors: strings.TrimSpace(stderr)}
+	before := map[string]string{}
+	after := map[string]string{}
+	for line := range strings.SplitSeq(stdout, "\n") {
+		kind, rest, _ := strings.Cut(line, " ")
+		switch kind {
+		case "before", "after":
+			// cksum prints the checksum, the size and the file
+			fields := strings.SplitN(rest, " ", 3)
+			if len(fields) != 3 {
+				continue
+			}
+			sums := before
+			if kind == "after" {
+				sums = after
+			}
+			sums[fields[2]] = fields[0] + " " + fields[1]
+		case "missing":
+			if result.Missing == nil {
+				result.Missing = map[string][]string{}
+			}
+			result.Missing[rest] = plan[rest]
+		}
+	}
+	for _, f := range formatters {
+		files := plan[f.name]
+		if len(files) == 0 {
+			continue
+		}
+		if _, missing := result.Missing[f.name]; !missing {
+			result.Formatters = append(result.Formatters, f.name)
+		}
+		for _, file := range files {
+			if sum, ok := after[file]; ok && sum != before[file] {
+				result.Reformatted = append(result.Reformatted, file)
+			}
+		}
+	}
+	return result
+}
+
+// Format runs the formatter of each language over the given files, relative to the workdir: gofmt or goimports,
+// ruff format (or black), prettier and rustfmt. Formatters must be installed in the environment.
+func (env *Environment) Format(ctx context.Context, files []string) (*FormatResult, error) {
+	plan := formatPlan(files)
+	if len(plan) == 0 {
+		return &FormatResult{Reformatted: []string{}}, nil
+	}
+	output, _, err := env.Run(ctx, formatScript(plan), "sh", false)
+	if err != nil {
+		return nil, err
+	}
+	stdout, stderr := output, ""
+	if i := strings.Index(output, "stderr: "); i >= 0 && (i == 0 || output[i-1] == '\n') {
+		stdout, stderr = output[:i], output[i+len("stderr: "):]
+	}
+	return parseFormatOutput(plan, stdout, stderr), nil
+}
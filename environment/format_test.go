@@ -0,0 +1,59 @@
+package environment
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatPlan(t *testing.T) {
+	plan := formatPlan([]string{"main.go", "app/views.py", "web/App.TSX", "README.md", "Makefile", "src/lib.rs"})
+	assert.Equal(t, map[string][]string{
+		"gofmt":    {"main.go"},
+		"ruff":     {"app/views.py"},
+		"prettier": {"web/App.TSX", "README.md"},
+		"rustfmt":  {"src/lib.rs"},
+	}, plan)
+	assert.Empty(t, formatPlan([]string{"Dockerfile"}))
+}
+
+func TestParseFormatOutput(t *testing.T) {
+	plan := map[string][]string{"gofmt": {"main.go", "my file.go"}, "rustfmt": {"src/lib.rs"}}
+	stdout := `before 123 10 main.go
+before 456 20 my file.go
+before 789 30 src/lib.rs
+missing rustfmt
+after 124 11 main.go
+after 456 20 my file.go
+after 789 30 src/lib.rs
+`
+	result := parseFormatOutput(plan, stdout, "main.go:3:1: expected declaration\n")
+	assert.Equal(t, []string{"main.go"}, result.Reformatted)
+	assert.Equal(t, []string{"gofmt"}, result.Formatters)
+	assert.Equal(t, map[string][]string{"rustfmt": {"src/lib.rs"}}, result.Missing)
+	assert.Equal(t, "main.go:3:1: expected declaration", result.Errors)
+}
+
+func TestFormatScript(t *testing.T) {
+	if _, err := exec.LookPath("gofmt"); err != nil {
+		t.Skip("gofmt not installed")
+	}
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.go"), []byte("package a\nfunc  A() {}\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b c.go"), []byte("package a\n\nfunc B() {}\n"), 0600))
+
+	plan := formatPlan([]string{"a.go", "b c.go"})
+	cmd := exec.Command("sh", "-c", formatScript(plan))
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	require.NoError(t, err)
+
+	result := parseFormatOutput(plan, string(out), "")
+	assert.Equal(t, []string{"a.go"}, result.Reformatted)
+	assert.Equal(t, []string{"gofmt"}, result.Formatters)
+	assert.Empty(t, result.Missing)
+}
@@ -0,0 +1,128 @@
+package environment
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// HostPath is a host directory outside the worktree that host environments may read, and write if allowed.
+// Commands always run on the host: only filesystem operations and output redirections of commands are checked.
+type HostPath struct {
+	Path  string `json:"path"`
+	Write bool   `json:"write,omitempty"`
+}
+
+type HostPaths []HostPath
+
+// Validate checks the paths are absolute and allowed once
+func (paths HostPaths) Validate() error {
+	seen := map[string]bool{}
+	for _, p := range paths {
+		if !filepath.IsAbs(p.Path) {
+			return fmt.Errorf("invalid host path %q: must be absolute", p.Path)
+		}
+		if filepath.Dir(p.Path) == p.Path {
+			return fmt.Errorf("invalid host path %q: allowing the root directory would allow everything", p.Path)
+		}
+		if seen[filepath.Clean(p.Path)] {
+			return fmt.Errorf("host path %s is allowed several times", p.Path)
+		}
+		seen[filepath.Clean(p.Path)] = true
+	}
+	return nil
+}
+
+// Get returns the allowed host path with the given path
+func (paths HostPaths) Get(path string) *HostPath {
+	for i := range paths {
+		if filepath.Clean(paths[i].Path) == filepath.Clean(path) {
+			return &paths[i]
+		}
+	}
+	return nil
+}
+
+// Check resolves a host path, checking it is in one of the directories, but not in their git metadata, or in a
+// directory allowing the access
+func (paths HostPaths) Check(path string, write bool, dirs ...string) (string, error) {
+	resolved := resolveHostPath(path)
+	for _, dir := range dirs {
+		dir = resolveHostPath(dir)
+		if withinDir(resolved, dir) && !withinDir(resolved, filepath.Join(dir, ".git")) {
+			return resolved, nil
+		}
+	}
+	for _, allowed := range paths {
+		if (allowed.Write || !write) && withinDir(resolved, resolveHostPath(allowed.Path)) {
+			return resolved, nil
+		}
+	}
+	return "", &HostPathError{Path: path, Write: write}
+}
+
+// HostPathError is returned for the host paths outside the directories environments are allowed to access
+type HostPathError struct {
+	Path  string
+	Write bool
+}
+
+func (e *HostPathError) Error() string {
+	access, flag := "read", ""
+	if e.Write {
+		access, flag = "written", " --write"
+	}
+	return fmt.Sprintf("%s is outside the directories environments may access and can't be %s: the user can allow it with `container-use config host-path allow <dir>%s`",
+		e.Path, access, flag)
+}
+
+// resolveHostPath returns the absolute path with its symlinks resolved, so links can't escape the allowed directories.
+// The part of the path that doesn't exist yet, e.g. a file about to be written, is kept as is.
+func resolveHostPath(path string) string {
+	path = filepath.Clean(path)
+	missing := []string{}
+	for existing := path; ; existing = filepath.Dir(existing) {
+		if resolved, err := filepath.EvalSymlinks(existing); err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...)
+		}
+		if filepath.Dir(existing) == existing {
+			return path
+		}
+		missing = append([]string{filepath.Base(existing)}, missing...)
+	}
+}
+
+// withinDir tells whether the path is the directory or one of its descendants
+func withinDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// hostPath resolves a path of a host environment, relative to its workdir, checking it is in the worktree or in a
+// directory of the configuration allowing the access
+func (env *Environment) hostPath(path string, write bool) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(env.State.Config.Workdir, path)
+	}
+	return env.State.Config.HostPaths.Check(path, write, env.State.Config.Workdir)
+}
+
+// redirectionRe matches the output redirections of shell commands to absolute paths, e.g. `> /data/out.csv`
+var redirectionRe = regexp.MustCompile(`>>?\|?\s*(/[^\s;|&<>()'"]+)`)
+
+// checkHostCommand checks the files commands of host environments redirect their output to can be written.
+// The devices and the temporary directory are always writable.
+func (env *Environment) checkHostCommand(command string) error {
+	for _, match := range redirectionRe.FindAllStringSubmatch(command, -1) {
+		target := match[1]
+		if strings.HasPrefix(target, "/dev/") || withinDir(resolveHostPath(target), resolveHostPath(os.TempDir())) {
+			continue
+		}
+		if _, err := env.hostPath(target, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package environment
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostPathsValidate(t *testing.T) {
+	assert.NoError(t, HostPaths{{Path: "/srv/data"}, {Path: "/srv/results", Write: true}}.Validate())
+	assert.ErrorContains(t, HostPaths{{Path: "data"}}.Validate(), "must be absolute")
+	assert.ErrorContains(t, HostPaths{{Path: "/"}}.Validate(), "root directory")
+	assert.ErrorContains(t, HostPaths{{Path: "/srv/data"}, {Path: "/srv/data/", Write: true}}.Validate(), "several times")
+}
+
+func TestHostPathsCheck(t *testing.T) {
+	root := t.TempDir()
+	repo := filepath.Join(root, "repo")
+	data := filepath.Join(root, "data")
+	require.NoError(t, os.MkdirAll(filepath.Join(repo, ".git"), 0755))
+	require.NoError(t, os.Mkdir(data, 0755))
+	paths := HostPaths{{Path: data}}
+
+	path, err := paths.Check(filepath.Join(repo, "handoff.cu.tar.gz"), true, repo)
+	require.NoError(t, err)
+	assert.Equal(t, "handoff.cu.tar.gz", filepath.Base(path))
+	_, err = paths.Check(filepath.Join(data, "weights.bin"), false, repo)
+	assert.NoError(t, err)
+
+	var pathErr *HostPathError
+	_, err = paths.Check(filepath.Join(data, "weights.bin"), true, repo)
+	assert.ErrorAs(t, err, &pathErr, "the data can't be written")
+	_, err = paths.Check(filepath.Join(repo, ".git", "hooks", "pre-commit"), true, repo)
+	assert.ErrorAs(t, err, &pathErr, "the git metadata of the repository can't be accessed")
+	_, err = paths.Check(filepath.Join(root, "elsewhere"), false, repo)
+	assert.ErrorAs(t, err, &pathErr)
+}
+
+func TestHostPath(t *testing.T) {
+	root := t.TempDir()
+	worktree := filepath.Join(root, "worktree")
+	data := filepath.Join(root, "data")
+	results := filepath.Join(root, "results")
+	secrets := filepath.Join(root, "secrets")
+	for _, dir := range []string{worktree, data, results, secrets} {
+		require.NoError(t, os.Mkdir(dir, 0755))
+	}
+	require.NoError(t, os.Symlink(secrets, filepath.Join(worktree, "link")))
+
+	env := &Environment{
+		EnvironmentInfo: &EnvironmentInfo{
+			State: &State{
+				Config: &EnvironmentConfig{
+					BaseImage: "host",
+					Workdir:   worktree,
+					HostPaths: HostPaths{{Path: data}, {Path: results, Write: true}},
+				},
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		path  string
+		write bool
+		ok    bool
+	}{
+		{"main.go", true, true},
+		{"new/dir/file.go", true, true},
+		{filepath.Join(data, "train.csv"), false, true},
+		{filepath.Join(data, "train.csv"), true, false},
+		{filepath.Join(results, "out.csv"), true, true},
+		{filepath.Join(secrets, "key"), false, false},
+		{"../secrets/key", false, false},
+		{"link/key", false, false},
+		{filepath.Join(root, "datasets"), false, false},
+	} {
+		_, err := env.hostPath(tc.path, tc.write)
+		if tc.ok {
+			assert.NoError(t, err, tc.path)
+			continue
+		}
+		var pathErr *HostPathError
+		require.ErrorAs(t, err, &pathErr, tc.path)
+		assert.Equal(t, tc.write, pathErr.Write)
+	}
+
+	// Files are accessed through the resolved path, so the link can't be swapped after the check
+	require.NoError(t, os.Symlink(data, filepath.Join(worktree, "data")))
+	resolved, err := env.hostPath("data/train.csv", false)
+	require.NoError(t, err)
+	expected, err := filepath.EvalSymlinks(data)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(expected, "train.csv"), resolved)
+
+	// The temporary directory of the test holds the worktree: only its tmp directory is writable by redirections
+	t.Setenv("TMPDIR", filepath.Join(root, "tmp"))
+	assert.NoError(t, env.checkHostCommand("go test ./... > out.txt 2>/dev/null"))
+	assert.NoError(t, env.checkHostCommand("echo done >> "+filepath.Join(results, "log.txt")))
+	assert.NoError(t, env.checkHostCommand("sort > "+filepath.Join(os.TempDir(), "sorted")))
+	assert.Error(t, env.checkHostCommand("cat data.csv > "+filepath.Join(data, "copy.csv")))
+	assert.Error(t, env.checkHostCommand("echo key &>"+filepath.Join(secrets, "key")))
+}
@@ -0,0 +1,234 @@
+package environment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+const (
+	IaCToolTerraform = "terraform"
+	IaCToolPulumi    = "pulumi"
+)
+
+type IaCPlanOptions struct {
+	// Tool is either terraform or pulumi. If empty, it is detected from the files in Dir.
+	Tool string
+	// Dir is the directory of the terraform module or pulumi project, relative to the workdir
+	Dir string
+	// Stack is the pulumi stack to preview
+	Stack string
+}
+
+// IaCPlan summarizes the changes an infrastructure plan would make
+type IaCPlan struct {
+	Tool    string          `json:"tool"`
+	Dir     string          `json:"dir"`
+	Add     int             `json:"add"`
+	Change  int             `json:"change"`
+	Destroy int             `json:"destroy"`
+	Replace int             `json:"replace"`
+	Changes []IaCPlanChange `json:"changes"`
+	// Output is the human readable output of the plan
+	Output string `json:"output,omitempty"`
+}
+
+type IaCPlanChange struct {
+	Address string `json:"address"`
+	Type    string `json:"type,omitempty"`
+	// Action is one of create, update, delete, replace or read
+	Action string `json:"action"`
+}
+
+// PlanIaC runs a terraform plan or pulumi preview of the environment's workdir.
+//
+// Plans run in a throwaway container: nothing is committed to the environment.
+// The plan secrets of the configuration (typically provider credentials) are only
+// ever exposed to these containers, never to the environment itself, so agents can
+// propose infrastructure changes but not apply them.
+func (env *Environment) PlanIaC(ctx context.Context, opts IaCPlanOptions) (*IaCPlan, error) {
+	if env.IsHost() {
+		return nil, fmt.Errorf("infrastructure plans are not supported in host mode")
+	}
+	// Providers are downloaded and query the real infrastructure
+	if err := requireNetwork("infrastructure plans"); err != nil {
+		return nil, err
+	}
+
+	dir := opts.Dir
+	if dir == "" {
+		dir = "."
+	}
+	tool := opts.Tool
+	if tool == "" {
+		entries, err := env.Workdir().Directory(dir).Entries(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+		}
+		tool, err = detectIaCTool(entries)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var command string
+	switch tool {
+	case IaCToolTerraform:
+		// The plan is only ever written inside the throwaway container, and the state is not locked.
+		command = "terraform init -input=false -no-color >&2 && " +
+			"terraform plan -input=false -lock=false -no-color -out=/tmp/container-use.tfplan >&2 && " +
+			"terraform show -json /tmp/container-use.tfplan"
+	case IaCToolPulumi:
+		command = "pulumi preview --json --non-interactive"
+		if opts.Stack != "" {
+			command += " --stack " + shellQuote(opts.Stack)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported infrastructure tool %q, expected %s or %s", tool, IaCToolTerraform, IaCToolPulumi)
+	}
+
+	if err := env.checkCommand(ctx, command); err != nil {
+		return nil, err
+	}
+	container, err := containerWithEnvAndSecrets(ctx, env.dag, env.container(), nil, env.State.Config.PlanSecrets)
+	if err != nil {
+		return nil, err
+	}
+	container = container.
+		WithWorkdir(path.Join(env.State.Config.Workdir, dir)).
+		WithEnvVariable("TF_IN_AUTOMATION", "1").
+		WithExec([]string{"sh", "-c", command}, dagger.ContainerWithExecOpts{
+			Expect: dagger.ReturnTypeAny,
+		})
+
+	displayCommand := fmt.Sprintf("%s plan (%s)", tool, dir)
+	exitCode, err := container.ExitCode(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get exit code: %w", err)
+	}
+	stdout, err := container.Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stdout: %w", err)
+	}
+	stderr, err := container.Stderr(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stderr: %w", err)
+	}
+	env.Notes.AddCommand(displayCommand, exitCode, "", stderr)
+	if exitCode != 0 {
+		return nil, fmt.Errorf("%s failed with exit code %d.\nstdout: %s\nstderr: %s", tool, exitCode, stdout, stderr)
+	}
+
+	var plan *IaCPlan
+	switch tool {
+	case IaCToolTerraform:
+		plan, err = parseTerraformPlan([]byte(stdout))
+	case IaCToolPulumi:
+		plan, err = parsePulumiPreview([]byte(stdout))
+	}
+	if err != nil {
+		return nil, err
+	}
+	plan.Dir = dir
+	plan.Output = stderr
+	return plan, nil
+}
+
+func detectIaCTool(entries []string) (string, error) {
+	if slices.Contains(entries, "Pulumi.yaml") || slices.Contains(entries, "Pulumi.yml") {
+		return IaCToolPulumi, nil
+	}
+	if slices.ContainsFunc(entries, func(entry string) bool { return strings.HasSuffix(entry, ".tf") }) {
+		return IaCToolTerraform, nil
+	}
+	return "", fmt.Errorf("no terraform module or pulumi project found, specify the tool explicitly")
+}
+
+func (p *IaCPlan) add(address, typ, action string) {
+	switch action {
+	case "create":
+		p.Add++
+	case "update":
+		p.Change++
+	case "delete":
+		p.Destroy++
+	case "replace":
+		p.Replace++
+	case "read":
+	default:
+		return
+	}
+	p.Changes = append(p.Changes, IaCPlanChange{Address: address, Type: typ, Action: action})
+}
+
+// parseTerraformPlan summarizes the output of `terraform show -json` on a plan file
+func parseTerraformPlan(data []byte) (*IaCPlan, error) {
+	var tfPlan struct {
+		ResourceChanges []struct {
+			Address string `json:"address"`
+			Type    string `json:"type"`
+			Change  struct {
+				Actions []string `json:"actions"`
+			} `json:"change"`
+		} `json:"resource_changes"`
+	}
+	if err := json.Unmarshal(data, &tfPlan); err != nil {
+		return nil, fmt.Errorf("failed to parse terraform plan: %w", err)
+	}
+
+	plan := &IaCPlan{Tool: IaCToolTerraform, Changes: []IaCPlanChange{}}
+	for _, rc := range tfPlan.ResourceChanges {
+		action := strings.Join(rc.Change.Actions, ",")
+		switch action {
+		// Replacements are either create-before-destroy or destroy-before-create
+		case "delete,create", "create,delete":
+			action = "replace"
+		case "no-op":
+			continue
+		}
+		plan.add(rc.Address, rc.Type, action)
+	}
+	return plan, nil
+}
+
+// parsePulumiPreview summarizes the output of `pulumi preview --json`
+func parsePulumiPreview(data []byte) (*IaCPlan, error) {
+	var preview struct {
+		Steps []struct {
+			Op       string `json:"op"`
+			URN      string `json:"urn"`
+			NewState struct {
+				Type string `json:"type"`
+			} `json:"newState"`
+			OldState struct {
+				Type string `json:"type"`
+			} `json:"oldState"`
+		} `json:"steps"`
+	}
+	if err := json.Unmarshal(data, &preview); err != nil {
+		return nil, fmt.Errorf("failed to parse pulumi preview: %w", err)
+	}
+
+	plan := &IaCPlan{Tool: IaCToolPulumi, Changes: []IaCPlanChange{}}
+	for _, step := range preview.Steps {
+		typ := step.NewState.Type
+		if typ == "" {
+			typ = step.OldState.Type
+		}
+		action := step.Op
+		switch action {
+		// A replacement is reported as a replace step surrounded by create-replacement and delete-replaced steps
+		case "create-replacement", "delete-replaced", "same", "refresh":
+			continue
+		case "read", "read-replacement":
+			action = "read"
+		}
+		plan.add(step.URN, typ, action)
+	}
+	return plan, nil
+}
@@ -0,0 +1,76 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTerraformPlan(t *testing.T) {
+	plan, err := parseTerraformPlan([]byte(`{
+  "format_version": "1.2",
+  "resource_changes": [
+    {"address": "aws_s3_bucket.logs", "type": "aws_s3_bucket", "change": {"actions": ["create"]}},
+    {"address": "aws_instance.web", "type": "aws_instance", "change": {"actions": ["update"]}},
+    {"address": "aws_instance.db", "type": "aws_instance", "change": {"actions": ["delete", "create"]}},
+    {"address": "aws_iam_role.old", "type": "aws_iam_role", "change": {"actions": ["delete"]}},
+    {"address": "aws_vpc.main", "type": "aws_vpc", "change": {"actions": ["no-op"]}},
+    {"address": "data.aws_ami.ubuntu", "type": "aws_ami", "change": {"actions": ["read"]}}
+  ]
+}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, IaCToolTerraform, plan.Tool)
+	assert.Equal(t, 1, plan.Add)
+	assert.Equal(t, 1, plan.Change)
+	assert.Equal(t, 1, plan.Destroy)
+	assert.Equal(t, 1, plan.Replace)
+	assert.Equal(t, []IaCPlanChange{
+		{Address: "aws_s3_bucket.logs", Type: "aws_s3_bucket", Action: "create"},
+		{Address: "aws_instance.web", Type: "aws_instance", Action: "update"},
+		{Address: "aws_instance.db", Type: "aws_instance", Action: "replace"},
+		{Address: "aws_iam_role.old", Type: "aws_iam_role", Action: "delete"},
+		{Address: "data.aws_ami.ubuntu", Type: "aws_ami", Action: "read"},
+	}, plan.Changes)
+
+	_, err = parseTerraformPlan([]byte("Error: no configuration files"))
+	assert.Error(t, err)
+}
+
+func TestParsePulumiPreview(t *testing.T) {
+	plan, err := parsePulumiPreview([]byte(`{
+  "steps": [
+    {"op": "same", "urn": "urn:pulumi:dev::app::pulumi:pulumi:Stack::app-dev"},
+    {"op": "create", "urn": "urn:pulumi:dev::app::aws:s3/bucket:Bucket::logs", "newState": {"type": "aws:s3/bucket:Bucket"}},
+    {"op": "create-replacement", "urn": "urn:pulumi:dev::app::aws:ec2/instance:Instance::web", "newState": {"type": "aws:ec2/instance:Instance"}},
+    {"op": "replace", "urn": "urn:pulumi:dev::app::aws:ec2/instance:Instance::web", "newState": {"type": "aws:ec2/instance:Instance"}},
+    {"op": "delete-replaced", "urn": "urn:pulumi:dev::app::aws:ec2/instance:Instance::web", "oldState": {"type": "aws:ec2/instance:Instance"}},
+    {"op": "delete", "urn": "urn:pulumi:dev::app::aws:iam/role:Role::old", "oldState": {"type": "aws:iam/role:Role"}}
+  ],
+  "changeSummary": {"create": 1, "replace": 1, "delete": 1, "same": 1}
+}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, IaCToolPulumi, plan.Tool)
+	assert.Equal(t, 1, plan.Add)
+	assert.Equal(t, 0, plan.Change)
+	assert.Equal(t, 1, plan.Destroy)
+	assert.Equal(t, 1, plan.Replace)
+	require.Len(t, plan.Changes, 3)
+	assert.Equal(t, "aws:ec2/instance:Instance", plan.Changes[1].Type)
+	assert.Equal(t, "aws:iam/role:Role", plan.Changes[2].Type, "deleted resources only have an old state")
+}
+
+func TestDetectIaCTool(t *testing.T) {
+	tool, err := detectIaCTool([]string{"main.tf", "variables.tf", "README.md"})
+	require.NoError(t, err)
+	assert.Equal(t, IaCToolTerraform, tool)
+
+	tool, err = detectIaCTool([]string{"Pulumi.yaml", "Pulumi.dev.yaml", "index.ts"})
+	require.NoError(t, err)
+	assert.Equal(t, IaCToolPulumi, tool)
+
+	_, err = detectIaCTool([]string{"main.go"})
+	assert.Error(t, err)
+}
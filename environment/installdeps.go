@@ -0,0 +1,127 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// DependencyInstall is the result of installing the dependencies of a project of an environment
+type DependencyInstall struct {
+	// Dir is the directory of the project, relative to the workdir
+	Dir       string   `json:"dir"`
+	Languages []string `json:"languages"`
+	// PackageManagers are the package managers used, by language
+	PackageManagers []string `json:"package_managers"`
+	Commands        []string `json:"commands"`
+	// Caches are the names of the cache volumes mounted during the install
+	Caches []string `json:"caches,omitempty"`
+	Output string   `json:"output"`
+	// Failure tells why the install failed, reported in the failure of the tool response
+	Failure *FailureAnalysis `json:"-"`
+}
+
+// dependencyPlan is how to install the dependencies of a project
+type dependencyPlan struct {
+	languages []string
+	managers  []string
+	commands  []string
+	caches    []languageCache
+}
+
+// planDependencyInstall picks the install commands of the languages of a project, given whether it has a file.
+// An empty language installs the dependencies of every language detected.
+func planDependencyInstall(has func(name string) bool, language string) (*dependencyPlan, error) {
+	detected, _ := detectStacksWith(has)
+	if language != "" {
+		detected = slices.DeleteFunc(detected, func(s stack) bool { return s.language != language })
+		if len(detected) == 0 {
+			return nil, fmt.Errorf("no %s project found", language)
+		}
+	}
+	if len(detected) == 0 {
+		return nil, fmt.Errorf("no project found: expected one of go.mod, Cargo.toml, package.json, pyproject.toml, requirements.txt, setup.py, Pipfile or Gemfile")
+	}
+	plan := &dependencyPlan{}
+	for _, s := range detected {
+		manager, commands := s.install(has)
+		plan.languages = append(plan.languages, s.language)
+		plan.managers = append(plan.managers, manager)
+		plan.commands = append(plan.commands, commands...)
+		plan.caches = append(plan.caches, s.caches...)
+	}
+	return plan, nil
+}
+
+// installCaches returns the caches to mount for an install, and the names of all the caches it uses.
+// Caches of the configuration are already mounted. Other caches are only mounted for the install when the tools
+// don't need them afterwards, and when the user didn't point the tools elsewhere.
+func (config *EnvironmentConfig) installCaches(caches []languageCache, projectKey string) ([]commandCache, []string) {
+	mounted := []commandCache{}
+	names := []string{}
+	for _, c := range caches {
+		if i := slices.IndexFunc(config.Caches, func(m CacheMount) bool { return m.Path == c.path }); i >= 0 {
+			names = append(names, config.Caches[i].Name)
+			continue
+		}
+		if !c.transient || (c.envVar != "" && slices.Contains(config.Env.Keys(), c.envVar)) {
+			continue
+		}
+		name := projectKey + "-" + c.name
+		mounted = append(mounted, commandCache{volume: "container-use-" + name, path: c.path, envVar: c.envVar})
+		names = append(names, name)
+	}
+	return mounted, names
+}
+
+// InstallDependencies detects the package manager of the project in dir and runs its install commands, with the
+// dependency caches of the project mounted. The language picks one of the languages of the project.
+func (env *Environment) InstallDependencies(ctx context.Context, dir, language, projectKey string) (*DependencyInstall, error) {
+	if dir == "" {
+		dir = "."
+	}
+	var files []string
+	if env.IsHost() {
+		root, err := env.hostPath(dir, false)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+		}
+		for _, entry := range entries {
+			files = append(files, entry.Name())
+		}
+	} else {
+		var err error
+		if files, err = env.Workdir().Directory(dir).Entries(ctx); err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+		}
+	}
+
+	plan, err := planDependencyInstall(func(name string) bool { return slices.Contains(files, name) }, language)
+	if err != nil {
+		return nil, err
+	}
+	result := &DependencyInstall{
+		Dir:             dir,
+		Languages:       plan.languages,
+		PackageManagers: plan.managers,
+		Commands:        plan.commands,
+	}
+
+	var caches []commandCache
+	if !env.IsHost() {
+		// The tools of the host use their own caches
+		caches, result.Caches = env.State.Config.installCaches(plan.caches, projectKey)
+	}
+	command := "cd " + shellQuote(dir) + " && " + strings.Join(plan.commands, " && ")
+	result.Output, result.Failure, err = env.run(ctx, command, "sh", false, caches)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,50 @@
+package environment
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hasFiles(names ...string) func(string) bool {
+	return func(name string) bool { return slices.Contains(names, name) }
+}
+
+func TestPlanDependencyInstall(t *testing.T) {
+	plan, err := planDependencyInstall(hasFiles("pyproject.toml", "uv.lock"), "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"python"}, plan.languages)
+	assert.Equal(t, []string{"uv"}, plan.managers)
+	assert.Equal(t, []string{"pip install uv", "uv sync --frozen"}, plan.commands)
+
+	plan, err = planDependencyInstall(hasFiles("go.mod", "package.json", "yarn.lock"), "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"go mod", "yarn"}, plan.managers)
+	assert.Equal(t, []string{"go mod download", "corepack enable", "yarn install --frozen-lockfile"}, plan.commands)
+
+	plan, err = planDependencyInstall(hasFiles("go.mod", "package.json"), "node")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"npm install"}, plan.commands)
+
+	_, err = planDependencyInstall(hasFiles("go.mod"), "rust")
+	assert.ErrorContains(t, err, "no rust project")
+	_, err = planDependencyInstall(hasFiles("README.md"), "")
+	assert.ErrorContains(t, err, "no project found")
+}
+
+func TestInstallCaches(t *testing.T) {
+	caches := []languageCache{
+		{name: "go-mod", path: "/cache/go-mod", envVar: "GOMODCACHE"},
+		{name: "go-build", path: "/cache/go-build", envVar: "GOCACHE", transient: true},
+		{name: "npm", path: "/cache/npm", envVar: "npm_config_cache", transient: true},
+	}
+
+	config := DefaultConfig()
+	config.Caches = CacheMounts{{Name: "shared-go-mod", Path: "/cache/go-mod"}}
+	config.Env = KVList{"npm_config_cache=/opt/npm"}
+	mounted, names := config.installCaches(caches, "app-1234")
+	assert.Equal(t, []commandCache{{volume: "container-use-app-1234-go-build", path: "/cache/go-build", envVar: "GOCACHE"}}, mounted)
+	assert.Equal(t, []string{"shared-go-mod", "app-1234-go-build"}, names, "the modules must stay in the container when not cached")
+}
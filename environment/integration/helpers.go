@@ -217,7 +217,7 @@ func (u *UserActions) RunCommand(envID, command, explanation string) string {
 	env, err := u.repo.Get(u.ctx, u.dag, envID)
 	require.NoError(u.t, err, "Failed to get environment %s", envID)
 
-	output, err := env.Run(u.ctx, command, "/bin/sh", false)
+	output, _, err := env.Run(u.ctx, command, "/bin/sh", false)
 	require.NoError(u.t, err, "Run command should succeed")
 
 	err = u.repo.Update(u.ctx, env, explanation)
@@ -228,7 +228,7 @@ func (u *UserActions) RunCommand(envID, command, explanation string) string {
 
 // CreateEnvironment mirrors environment_create MCP tool behavior
 func (u *UserActions) CreateEnvironment(title, explanation string) *environment.Environment {
-	env, err := u.repo.Create(u.ctx, u.dag, title, explanation)
+	env, err := u.repo.Create(u.ctx, u.dag, title, explanation, repository.CreateOpts{})
 	require.NoError(u.t, err, "Create environment should succeed")
 	return env
 }
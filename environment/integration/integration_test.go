@@ -379,7 +379,7 @@ func TestWeirdUserScenarios(t *testing.T) {
 		repo1, err := repository.OpenWithBasePath(ctx, repoDir1, configDir1)
 		require.NoError(t, err)
 
-		env1, err := repo1.Create(ctx, testDaggerClient, "App", "Creating app in repo1")
+		env1, err := repo1.Create(ctx, testDaggerClient, "App", "Creating app in repo1", repository.CreateOpts{})
 		require.NoError(t, err)
 		defer repo1.Delete(ctx, env1.ID)
 
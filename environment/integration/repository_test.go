@@ -143,7 +143,7 @@ func TestRepositoryLog(t *testing.T) {
 
 		// Get commit log without patches
 		var logBuf bytes.Buffer
-		err := repo.Log(ctx, env.ID, false, &logBuf)
+		err := repo.Log(ctx, env.ID, repository.LogOpts{}, &logBuf)
 		logOutput := logBuf.String()
 		require.NoError(t, err, logOutput)
 
@@ -154,7 +154,7 @@ func TestRepositoryLog(t *testing.T) {
 
 		// Get commit log with patches
 		logBuf.Reset()
-		err = repo.Log(ctx, env.ID, true, &logBuf)
+		err = repo.Log(ctx, env.ID, repository.LogOpts{Patch: true}, &logBuf)
 		logWithPatchOutput := logBuf.String()
 		require.NoError(t, err, logWithPatchOutput)
 
@@ -163,7 +163,7 @@ func TestRepositoryLog(t *testing.T) {
 		assert.Contains(t, logWithPatchOutput, "+updated content")
 
 		// Test log for non-existent environment
-		err = repo.Log(ctx, "non-existent-env", false, &logBuf)
+		err = repo.Log(ctx, "non-existent-env", repository.LogOpts{}, &logBuf)
 		assert.Error(t, err)
 	})
 }
@@ -0,0 +1,416 @@
+package environment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"dagger.io/dagger"
+	petname "github.com/dustinkirkland/golang-petname"
+)
+
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	// JobWaitingInput is the state of interactive jobs waiting for a reply to a prompt
+	JobWaitingInput = "waiting_input"
+
+	// maxJobOutput is the size of the tail of the output returned by job status and results
+	maxJobOutput = 64 * 1024
+)
+
+// jobs are the jobs of all environments by environment and job ID.
+// Like background commands, they live as long as the server that started them.
+var (
+	jobs   = map[string]*Job{}
+	jobsMu sync.Mutex
+)
+
+// Job is a command running detached from the tool call that started it,
+// for commands expected to outlast tool call timeouts (model training, large builds, ...).
+// Its output is spooled to disk until its result is collected.
+type Job struct {
+	ID         string    `json:"id"`
+	Command    string    `json:"command"`
+	State      string    `json:"state"`
+	ExitCode   int       `json:"exit_code"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+	// Interactive jobs keep their standard input open for replies to their prompts
+	Interactive bool `json:"interactive,omitempty"`
+	// Prompt is what a job waiting for input last printed
+	Prompt string `json:"prompt,omitempty"`
+
+	envID     string
+	spoolDir  string
+	container *dagger.Container
+	// baseContainer is the state of the environment the job started from
+	baseContainer string
+	// done is closed once the job has finished
+	done chan struct{}
+	// stdin and outputs are set for interactive jobs, see watchPrompts
+	stdin    io.WriteCloser
+	outputs  []*promptWriter
+	onPrompt func(*Job)
+
+	mu sync.Mutex
+}
+
+// JobResult is the outcome of a finished job
+type JobResult struct {
+	*Job
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+	// Applied reports whether the changes made by the job were applied to the environment
+	Applied bool `json:"applied"`
+	// Failure tells why the job failed, once it has
+	Failure *FailureAnalysis `json:"failure,omitempty"`
+}
+
+func jobKey(envID, jobID string) string {
+	return envID + "/" + jobID
+}
+
+// StartJob runs a command in the background and returns immediately.
+// Unlike background commands, the changes a job makes are applied to the environment when its result is collected.
+func (env *Environment) StartJob(ctx context.Context, command, shell string) (*Job, error) {
+	return env.startJob(ctx, command, shell, nil)
+}
+
+// StartInteractiveJob is StartJob keeping the standard input of the command open: when the command prompts for input,
+// the job waits for a reply (see RespondJob) and onPrompt is called.
+// Commands run in containers get no input at all, so interactive jobs are only supported in host mode.
+func (env *Environment) StartInteractiveJob(ctx context.Context, command, shell string, onPrompt func(*Job)) (*Job, error) {
+	if !env.IsHost() {
+		return nil, fmt.Errorf("interactive jobs are only supported in host mode: commands run in containers read no input, their prompts fail rather than wait")
+	}
+	if onPrompt == nil {
+		onPrompt = func(*Job) {}
+	}
+	return env.startJob(ctx, command, shell, onPrompt)
+}
+
+func (env *Environment) startJob(ctx context.Context, command, shell string, onPrompt func(*Job)) (*Job, error) {
+	if strings.TrimSpace(command) == "" {
+		return nil, fmt.Errorf("job command is empty")
+	}
+	if err := env.checkCommand(ctx, command); err != nil {
+		return nil, err
+	}
+	env.recordEnvUsage(command)
+
+	spoolDir, err := os.MkdirTemp("", "container-use-job-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create job spool: %w", err)
+	}
+	job := &Job{
+		ID:            petname.Generate(2, "-"),
+		Command:       command,
+		State:         JobRunning,
+		StartedAt:     time.Now(),
+		envID:         env.ID,
+		spoolDir:      spoolDir,
+		baseContainer: env.State.Container,
+		done:          make(chan struct{}),
+		Interactive:   onPrompt != nil,
+		onPrompt:      onPrompt,
+	}
+
+	// Jobs outlive the tool call that started them
+	ctx = context.WithoutCancel(ctx)
+	if env.IsHost() {
+		err = env.startHostJob(ctx, job, shell)
+	} else {
+		go env.runContainerJob(ctx, job, shell)
+	}
+	if err != nil {
+		os.RemoveAll(spoolDir)
+		return nil, err
+	}
+
+	jobsMu.Lock()
+	jobs[jobKey(env.ID, job.ID)] = job
+	jobsMu.Unlock()
+
+	env.Notes.Add("Start job %s\n$ %s", job.ID, command)
+	return job.snapshot(), nil
+}
+
+func (env *Environment) startHostJob(ctx context.Context, job *Job, shell string) error {
+	stdout, err := os.Create(filepath.Join(job.spoolDir, "stdout"))
+	if err != nil {
+		return err
+	}
+	stderr, err := os.Create(filepath.Join(job.spoolDir, "stderr"))
+	if err != nil {
+		stdout.Close()
+		return err
+	}
+
+	args := env.limit([]string{shell, "-c", job.Command})
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = env.State.Config.Workdir
+	hostEnv, err := env.buildHostEnv(ctx)
+	if err != nil {
+		stdout.Close()
+		stderr.Close()
+		return err
+	}
+	cmd.Env = hostEnv
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if job.Interactive {
+		job.outputs = []*promptWriter{{w: stdout}, {w: stderr}}
+		cmd.Stdout = job.outputs[0]
+		cmd.Stderr = job.outputs[1]
+		if job.stdin, err = cmd.StdinPipe(); err != nil {
+			stdout.Close()
+			stderr.Close()
+			return err
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		stdout.Close()
+		stderr.Close()
+		return err
+	}
+	if job.Interactive {
+		go job.watchPrompts()
+	}
+
+	go func() {
+		err := cmd.Wait()
+		stdout.Close()
+		stderr.Close()
+
+		exitCode := 0
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+			err = nil
+		}
+		job.finish(exitCode, err)
+	}()
+	return nil
+}
+
+func (env *Environment) runContainerJob(ctx context.Context, job *Job, shell string) {
+	container := env.container().WithExec(env.limit([]string{shell, "-c", job.Command}), dagger.ContainerWithExecOpts{
+		Expect:                        dagger.ReturnTypeAny, // Don't treat non-zero exit as error
+		ExperimentalPrivilegedNesting: true,
+	})
+
+	// Output is only available once the command exits
+	exitCode, err := container.ExitCode(ctx)
+	if err != nil {
+		job.finish(0, err)
+		return
+	}
+	for name, output := range map[string]func(context.Context) (string, error){
+		"stdout": container.Stdout,
+		"stderr": container.Stderr,
+	} {
+		out, err := output(ctx)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(job.spoolDir, name), []byte(out), 0600)
+		}
+		if err != nil {
+			job.finish(0, fmt.Errorf("failed to spool %s: %w", name, err))
+			return
+		}
+	}
+
+	job.mu.Lock()
+	job.container = container
+	job.mu.Unlock()
+	job.finish(exitCode, nil)
+}
+
+func (job *Job) finish(exitCode int, err error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	defer close(job.done)
+
+	job.FinishedAt = time.Now()
+	job.ExitCode = exitCode
+	job.Prompt = ""
+	job.State = JobSucceeded
+	if err != nil {
+		job.State = JobFailed
+		job.Error = err.Error()
+	} else if exitCode != 0 {
+		job.State = JobFailed
+	}
+}
+
+// snapshot returns a copy of the public state of the job, safe to use while it runs
+func (job *Job) snapshot() *Job {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	return &Job{
+		ID:          job.ID,
+		Command:     job.Command,
+		State:       job.State,
+		ExitCode:    job.ExitCode,
+		Error:       job.Error,
+		StartedAt:   job.StartedAt,
+		FinishedAt:  job.FinishedAt,
+		Interactive: job.Interactive,
+		Prompt:      job.Prompt,
+	}
+}
+
+// readSpool returns the tail of a spooled output, noting how much was cut
+func (job *Job) readSpool(name string) (string, error) {
+	return readTail(filepath.Join(job.spoolDir, name), maxJobOutput)
+}
+
+// readTail returns the last size bytes of a file, noting how much was cut
+func readTail(path string, size int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	skipped := info.Size() - size
+	if skipped > 0 {
+		if _, err := f.Seek(skipped, io.SeekStart); err != nil {
+			return "", err
+		}
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	if skipped > 0 {
+		return fmt.Sprintf("[%d bytes truncated]\n%s", skipped, data), nil
+	}
+	return string(data), nil
+}
+
+func (env *Environment) getJob(id string) (*Job, error) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	job, ok := jobs[jobKey(env.ID, id)]
+	if !ok {
+		return nil, fmt.Errorf("job %s not found", id)
+	}
+	return job, nil
+}
+
+// Jobs returns the jobs of the environment whose results haven't been collected, oldest first
+func (env *Environment) Jobs() []*Job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	envJobs := []*Job{}
+	for _, job := range jobs {
+		if job.envID == env.ID {
+			envJobs = append(envJobs, job.snapshot())
+		}
+	}
+	slices.SortFunc(envJobs, func(a, b *Job) int {
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+	return envJobs
+}
+
+// JobStatus returns the state of a job along with the tail of its output so far.
+// In container mode, output is only available once the job has finished.
+func (env *Environment) JobStatus(id string) (*JobResult, error) {
+	job, err := env.getJob(id)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &JobResult{Job: job.snapshot()}
+	if status.Stdout, err = job.readSpool("stdout"); err != nil {
+		return nil, err
+	}
+	if status.Stderr, err = job.readSpool("stderr"); err != nil {
+		return nil, err
+	}
+	if status.State == JobFailed {
+		status.Failure = AnalyzeFailure(status.ExitCode, status.Stdout, status.Stderr)
+	}
+	return status, nil
+}
+
+// WaitJob waits for a job to finish and collects its result
+func (env *Environment) WaitJob(ctx context.Context, id string) (*JobResult, error) {
+	job, err := env.getJob(id)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-job.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return env.JobResult(ctx, id)
+}
+
+// JobResult collects the result of a finished job and forgets about it.
+// The changes made by the job are applied to the environment, unless the
+// environment changed since the job started.
+func (env *Environment) JobResult(ctx context.Context, id string) (*JobResult, error) {
+	result, err := env.JobStatus(id)
+	if err != nil {
+		return nil, err
+	}
+	if result.State == JobWaitingInput {
+		return nil, fmt.Errorf("job %s is waiting for input: %s", id, result.Prompt)
+	}
+	if result.State == JobRunning {
+		return nil, fmt.Errorf("job %s is still running, started %s ago", id, time.Since(result.StartedAt).Round(time.Second))
+	}
+
+	// Claim the job, so its changes are applied only once
+	jobsMu.Lock()
+	job, ok := jobs[jobKey(env.ID, id)]
+	delete(jobs, jobKey(env.ID, id))
+	jobsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("job %s not found", id)
+	}
+	defer os.RemoveAll(job.spoolDir)
+
+	// Host jobs write directly to the worktree
+	result.Applied = env.IsHost()
+	job.mu.Lock()
+	container := job.container
+	job.mu.Unlock()
+	if container != nil {
+		if env.State.Container == job.baseContainer {
+			if err := env.apply(ctx, container); err != nil {
+				return nil, fmt.Errorf("failed to apply job changes: %w", err)
+			}
+			result.Applied = true
+		} else {
+			env.Notes.Add("Job %s: the environment changed while the job was running, its changes were discarded", id)
+		}
+	}
+
+	env.Notes.AddCommand(fmt.Sprintf("%s (job %s)", job.Command, id), result.ExitCode, result.Stdout, result.Stderr)
+	return result, nil
+}
@@ -0,0 +1,101 @@
+package environment
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newHostEnvironment(t *testing.T, id string) *Environment {
+	return &Environment{
+		EnvironmentInfo: &EnvironmentInfo{
+			ID: id,
+			State: &State{
+				Config: &EnvironmentConfig{BaseImage: "host", Workdir: t.TempDir()},
+			},
+		},
+	}
+}
+
+func waitJob(t *testing.T, env *Environment, id string) *JobResult {
+	var status *JobResult
+	require.Eventually(t, func() bool {
+		var err error
+		status, err = env.JobStatus(id)
+		require.NoError(t, err)
+		return status.State != JobRunning
+	}, 10*time.Second, 10*time.Millisecond)
+	return status
+}
+
+func TestJobs(t *testing.T) {
+	ctx := context.Background()
+	env := newHostEnvironment(t, "env-jobs")
+
+	t.Run("succeeded", func(t *testing.T) {
+		job, err := env.StartJob(ctx, "echo building; echo warning >&2; touch artifact", "sh")
+		require.NoError(t, err)
+		assert.Equal(t, JobRunning, job.State)
+
+		_, err = env.StartJob(ctx, " ", "sh")
+		assert.Error(t, err)
+
+		status := waitJob(t, env, job.ID)
+		assert.Equal(t, JobSucceeded, status.State)
+		assert.Equal(t, "building\n", status.Stdout, "output is available before the result is collected")
+
+		result, err := env.JobResult(ctx, job.ID)
+		require.NoError(t, err)
+		assert.Equal(t, 0, result.ExitCode)
+		assert.Equal(t, "warning\n", result.Stderr)
+		assert.True(t, result.Applied)
+		assert.FileExists(t, filepath.Join(env.State.Config.Workdir, "artifact"))
+		assert.Contains(t, env.Notes.String(), "touch artifact (job "+job.ID+")")
+
+		_, err = env.JobStatus(job.ID)
+		assert.Error(t, err, "collected jobs are forgotten")
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		job, err := env.StartJob(ctx, "exit 3", "sh")
+		require.NoError(t, err)
+
+		status := waitJob(t, env, job.ID)
+		assert.Equal(t, JobFailed, status.State)
+		assert.Equal(t, 3, status.ExitCode)
+	})
+
+	t.Run("running", func(t *testing.T) {
+		job, err := env.StartJob(ctx, "sleep 2", "sh")
+		require.NoError(t, err)
+
+		_, err = env.JobResult(ctx, job.ID)
+		assert.ErrorContains(t, err, "still running")
+
+		jobs := env.Jobs()
+		require.Len(t, jobs, 2)
+		assert.Equal(t, "exit 3", jobs[0].Command)
+		assert.Equal(t, job.ID, jobs[1].ID)
+
+		assert.Empty(t, newHostEnvironment(t, "other").Jobs(), "jobs are per environment")
+	})
+}
+
+func TestJobReadSpool(t *testing.T) {
+	job := &Job{spoolDir: t.TempDir()}
+
+	out, err := job.readSpool("stdout")
+	require.NoError(t, err)
+	assert.Empty(t, out, "nothing spooled yet")
+
+	require.NoError(t, os.WriteFile(filepath.Join(job.spoolDir, "stdout"), []byte(strings.Repeat("a", 10)+strings.Repeat("b", maxJobOutput)), 0600))
+	out, err = job.readSpool("stdout")
+	require.NoError(t, err)
+	assert.Equal(t, "[10 bytes truncated]\n"+strings.Repeat("b", maxJobOutput), out)
+}
@@ -0,0 +1,550 @@
+package environment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+// KubernetesConfig runs the environment in a pod of a Kubernetes cluster instead of a Dagger container, for machines
+// that can't run a container runtime. Pods are managed with kubectl: its configuration (KUBECONFIG) selects the
+// cluster.
+type KubernetesConfig struct {
+	// Context is the kubeconfig context of the cluster, the current one if empty
+	Context string `json:"context,omitempty"`
+	// Namespace is the namespace of the pods, the one of the context if empty
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// KubernetesPod is the pod a Kubernetes environment runs in
+type KubernetesPod struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Context   string `json:"context,omitempty"`
+}
+
+const (
+	// podContainer is the name of the container running the environment in its pod
+	podContainer = "environment"
+	// podReadyTimeout is how long the image of a pod has to be pulled and started
+	podReadyTimeout = 5 * time.Minute
+)
+
+// kubectlBinary runs the kubectl commands, replaced in tests
+var kubectlBinary = "kubectl"
+
+var dnsLabelRe = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// validate checks the settings can be passed to kubectl
+func (k *KubernetesConfig) validate() error {
+	if k.Namespace != "" && (len(k.Namespace) > 63 || !dnsLabelRe.MatchString(k.Namespace)) {
+		return fmt.Errorf("invalid namespace %q", k.Namespace)
+	}
+	if strings.HasPrefix(k.Context, "-") {
+		return fmt.Errorf("invalid context %q", k.Context)
+	}
+	return nil
+}
+
+// IsKubernetes reports whether this environment runs in a Kubernetes pod
+func (env *Environment) IsKubernetes() bool {
+	return env.State.Config.Kubernetes != nil && !env.IsHost()
+}
+
+// podName names the pod of an environment built at the given time. Rebuilds get a new pod: the workdir is copied
+// from the previous one before it is deleted.
+func podName(id string, at time.Time) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, id)
+	suffix := "-" + strconv.FormatInt(at.Unix(), 36)
+	name = strings.Trim("cu-"+name, "-")
+	if len(name) > 63-len(suffix) {
+		name = strings.TrimRight(name[:63-len(suffix)], "-")
+	}
+	return name + suffix
+}
+
+// podManifest is the pod of an environment: its base image idling until commands are executed in it, with the
+// variables of the configuration and an empty workdir
+func podManifest(pod *KubernetesPod, id string, config *EnvironmentConfig) ([]byte, error) {
+	env := []map[string]string{}
+	for _, variable := range config.Env {
+		name, value, _ := strings.Cut(variable, "=")
+		env = append(env, map[string]string{"name": name, "value": value})
+	}
+	metadata := map[string]any{
+		"name": pod.Name,
+		"labels": map[string]string{
+			"app.kubernetes.io/managed-by":        "container-use",
+			"container-use.dagger.io/environment": id,
+		},
+	}
+	if pod.Namespace != "" {
+		metadata["namespace"] = pod.Namespace
+	}
+	return json.Marshal(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   metadata,
+		"spec": map[string]any{
+			"restartPolicy":                 "Never",
+			"terminationGracePeriodSeconds": 5,
+			"containers": []map[string]any{{
+				"name":  podContainer,
+				"image": config.BaseImage,
+				// The shell of the image is what every command runs with anyway
+				"command":    []string{"sh", "-c", "trap 'exit 0' TERM; while :; do sleep 3600 & wait; done"},
+				"workingDir": config.Workdir,
+				"env":        env,
+				"volumeMounts": []map[string]string{
+					{"name": "workdir", "mountPath": config.Workdir},
+				},
+			}},
+			"volumes": []map[string]any{
+				{"name": "workdir", "emptyDir": map[string]any{}},
+			},
+		},
+	})
+}
+
+// kubectl prepares a kubectl command on the cluster and namespace of the pod
+func (p *KubernetesPod) kubectl(ctx context.Context, args ...string) *exec.Cmd {
+	flags := []string{}
+	if p.Context != "" {
+		flags = append(flags, "--context", p.Context)
+	}
+	if p.Namespace != "" {
+		flags = append(flags, "--namespace", p.Namespace)
+	}
+	return exec.CommandContext(ctx, kubectlBinary, append(flags, args...)...)
+}
+
+// command prepares the execution of a command in the pod, reading its input if interactive
+func (p *KubernetesPod) command(ctx context.Context, interactive bool, args ...string) *exec.Cmd {
+	kargs := []string{"exec"}
+	if interactive {
+		kargs = append(kargs, "-i")
+	}
+	kargs = append(kargs, p.Name, "-c", podContainer, "--")
+	return p.kubectl(ctx, append(kargs, args...)...)
+}
+
+// run runs a command in a directory of the pod and returns its output and exit code.
+// kubectl can't tell its own failures, e.g. a pod that doesn't exist anymore, from those of the command: they are
+// reported with exit code 1.
+func (p *KubernetesPod) run(ctx context.Context, stdin io.Reader, dir string, args ...string) (stdout, stderr string, exitCode int, err error) {
+	cmd := p.command(ctx, stdin != nil, append([]string{"sh", "-c", `cd "$0" && exec "$@"`, dir}, args...)...)
+	cmd.Stdin = stdin
+	var out, errOut bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &errOut
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && ctx.Err() == nil {
+		return out.String(), errOut.String(), exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to run in pod %s: %w", p.Name, err)
+	}
+	return out.String(), errOut.String(), 0, nil
+}
+
+// output runs a command in a directory of the pod and returns its output, failing if it exits with a non-zero code
+func (p *KubernetesPod) output(ctx context.Context, stdin io.Reader, dir string, args ...string) (string, error) {
+	stdout, stderr, exitCode, err := p.run(ctx, stdin, dir, args...)
+	if err != nil {
+		return "", err
+	}
+	if exitCode != 0 {
+		return "", fmt.Errorf("exit code %d: %s", exitCode, strings.TrimSpace(stderr))
+	}
+	return stdout, nil
+}
+
+// copyIn copies the files of a host directory to a directory of the pod
+func (p *KubernetesPod) copyIn(ctx context.Context, src, dst string) error {
+	return pipeCommands(
+		exec.CommandContext(ctx, "tar", "-C", src, "-cf", "-", "."),
+		p.command(ctx, true, "tar", "-C", dst, "-xf", "-"),
+	)
+}
+
+// copyOut copies the files of a directory of the pod to a host directory
+func (p *KubernetesPod) copyOut(ctx context.Context, src, dst string) error {
+	return pipeCommands(
+		p.command(ctx, false, "tar", "-C", src, "-cf", "-", "."),
+		exec.CommandContext(ctx, "tar", "-C", dst, "-xf", "-"),
+	)
+}
+
+// Delete deletes the pod, without waiting for its processes to stop
+func (p *KubernetesPod) Delete(ctx context.Context) error {
+	out, err := p.kubectl(ctx, "delete", "pod", p.Name, "--ignore-not-found", "--wait=false").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to delete pod %s: %s", p.Name, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// pipeCommands runs two commands, the output of the first one being the input of the second one
+func pipeCommands(from, to *exec.Cmd) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	var fromErr, toErr bytes.Buffer
+	from.Stdout, from.Stderr = w, &fromErr
+	to.Stdin, to.Stderr = r, &toErr
+	if err := from.Start(); err != nil {
+		r.Close()
+		w.Close()
+		return err
+	}
+	err = to.Start()
+	// The commands hold the ends of the pipe they use: either one exiting ends the other one
+	r.Close()
+	w.Close()
+	if err != nil {
+		from.Process.Kill()
+		from.Wait()
+		return err
+	}
+	fromErrs, toErrs := from.Wait(), to.Wait()
+	if fromErrs != nil {
+		return fmt.Errorf("%w: %s", fromErrs, strings.TrimSpace(fromErr.String()))
+	}
+	if toErrs != nil {
+		return fmt.Errorf("%w: %s", toErrs, strings.TrimSpace(toErr.String()))
+	}
+	return nil
+}
+
+// buildPod creates a pod for the configuration of the environment and replaces the previous one if any.
+// Setup commands run first, then the workdir is filled with the source directory, or else with the workdir of the
+// previous pod, and install commands run.
+func (env *Environment) buildPod(ctx context.Context, source *dagger.Directory) (err error) {
+	config := env.State.Config
+	pod := &KubernetesPod{
+		Name:      podName(env.ID, time.Now()),
+		Namespace: config.Kubernetes.Namespace,
+		Context:   config.Kubernetes.Context,
+	}
+	manifest, err := podManifest(pod, env.ID, config)
+	if err != nil {
+		return err
+	}
+	apply := pod.kubectl(ctx, "apply", "-f", "-")
+	apply.Stdin = bytes.NewReader(manifest)
+	if out, err := apply.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create pod %s: %s", pod.Name, strings.TrimSpace(string(out)))
+	}
+	defer func() {
+		if err != nil {
+			if derr := pod.Delete(context.WithoutCancel(ctx)); derr != nil {
+				slog.WarnContext(ctx, "Failed to delete the pod of a failed build", "pod", pod.Name, "err", derr)
+			}
+		}
+	}()
+	slog.InfoContext(ctx, "Waiting for pod", "environment", env.ID, "pod", pod.Name, "namespace", pod.Namespace)
+	if out, err := pod.kubectl(ctx, "wait", "--for=condition=Ready", "pod/"+pod.Name, "--timeout="+podReadyTimeout.String()).CombinedOutput(); err != nil {
+		return fmt.Errorf("pod %s didn't start: %s", pod.Name, strings.TrimSpace(string(out)))
+	}
+
+	runCommands := func(commands []string) error {
+		for _, command := range commands {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			env.recordEnvUsage(command)
+			stdout, stderr, exitCode, err := pod.run(ctx, nil, config.Workdir, env.limit([]string{"sh", "-c", command})...)
+			if err != nil {
+				return err
+			}
+			env.Notes.AddCommand(command, exitCode, stdout, stderr)
+			if exitCode != 0 {
+				return fmt.Errorf("exit code %d.\nstdout: %s\nstderr: %s", exitCode, stdout, stderr)
+			}
+		}
+		return nil
+	}
+
+	if err := runCommands(config.SetupCommands); err != nil {
+		return fmt.Errorf("setup command failed: %w", err)
+	}
+	previous := env.State.Pod
+	switch {
+	case source != nil:
+		tmp, err := os.MkdirTemp("", "container-use-pod-*")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmp)
+		if _, err := source.Export(ctx, tmp); err != nil {
+			return fmt.Errorf("failed to export the source: %w", err)
+		}
+		if err := pod.copyIn(ctx, tmp, config.Workdir); err != nil {
+			return fmt.Errorf("failed to copy the source to pod %s: %w", pod.Name, err)
+		}
+	case previous != nil:
+		if err := pipeCommands(
+			previous.command(ctx, false, "tar", "-C", config.Workdir, "-cf", "-", "."),
+			pod.command(ctx, true, "tar", "-C", config.Workdir, "-xf", "-"),
+		); err != nil {
+			return fmt.Errorf("failed to copy the workdir of pod %s: %w", previous.Name, err)
+		}
+	}
+	if err := runCommands(config.InstallCommands); err != nil {
+		return fmt.Errorf("install command failed: %w", err)
+	}
+
+	env.mu.Lock()
+	env.State.Pod = pod
+	// Background processes ran in the previous pod, deleted with it
+	env.State.BackgroundProcesses = nil
+	env.State.UpdatedAt = time.Now()
+	dropReads(env.ID)
+	env.mu.Unlock()
+	if previous != nil {
+		if err := previous.Delete(ctx); err != nil {
+			slog.WarnContext(ctx, "Failed to delete the previous pod of the environment", "environment", env.ID, "err", err)
+		}
+	}
+	return nil
+}
+
+// pod returns the pod of the environment
+func (env *Environment) pod() (*KubernetesPod, error) {
+	env.mu.RLock()
+	defer env.mu.RUnlock()
+	if env.State.Pod == nil {
+		return nil, fmt.Errorf("environment %s has no pod: update its configuration to create one", env.ID)
+	}
+	return env.State.Pod, nil
+}
+
+// runInPod runs a command in the workdir of the pod, like Run
+func (env *Environment) runInPod(ctx context.Context, command, shell string) (string, *FailureAnalysis, error) {
+	pod, err := env.pod()
+	if err != nil {
+		return "", nil, err
+	}
+	if strings.TrimSpace(command) == "" {
+		return "", nil, nil
+	}
+	stdout, stderr, exitCode, err := pod.run(ctx, nil, env.State.Config.Workdir, env.limit([]string{shell, "-c", command})...)
+	if err != nil {
+		env.Notes.AddCommand(command, 1, "", err.Error())
+		return "", nil, err
+	}
+	env.Notes.AddCommand(command, exitCode, stdout, stderr)
+	return combineStdoutStderr(stdout, stderr), AnalyzeFailure(exitCode, stdout, stderr), nil
+}
+
+// runBackgroundInPod starts a command in the pod without waiting for it, its output going to a file of the pod
+func (env *Environment) runBackgroundInPod(ctx context.Context, command, shell string, ports []int) (EndpointMappings, error) {
+	pod, err := env.pod()
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(command) == "" {
+		return nil, fmt.Errorf("background command is empty")
+	}
+	displayCommand := command + " &"
+	logFile := fmt.Sprintf("/tmp/container-use-%d.log", time.Now().UnixNano())
+	args := append([]string{"sh", "-c", `log=$1; shift; nohup "$@" >"$log" 2>&1 </dev/null & echo $!`, "sh", logFile}, env.limit([]string{shell, "-c", command})...)
+	out, err := pod.output(ctx, nil, env.State.Config.Workdir, args...)
+	if err != nil {
+		env.Notes.AddCommand(displayCommand, 1, "", err.Error())
+		return nil, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		return nil, fmt.Errorf("unexpected PID %q of the background command", out)
+	}
+
+	env.mu.Lock()
+	env.State.BackgroundProcesses = append(env.State.BackgroundProcesses, BackgroundProcess{
+		PID:       pid,
+		Command:   command,
+		Shell:     shell,
+		Ports:     ports,
+		Workdir:   env.State.Config.Workdir,
+		LogFile:   logFile,
+		StartedAt: time.Now(),
+	})
+	env.State.UpdatedAt = time.Now()
+	env.mu.Unlock()
+	env.Notes.AddCommand(displayCommand, 0, "", "")
+
+	// Pods aren't reachable from the host: ports are forwarded by the user, with kubectl port-forward
+	endpoints := EndpointMappings{}
+	for _, port := range ports {
+		endpoints[port] = &EndpointMapping{
+			EnvironmentInternal: fmt.Sprintf("tcp://127.0.0.1:%d", port),
+		}
+	}
+	return endpoints, nil
+}
+
+// podProcessRunningScript exits with 0 if the process runs. Processes that exited stay zombies when the first
+// process of the pod doesn't reap them: their state is checked.
+const podProcessRunningScript = `stat=$(cat "/proc/$1/stat" 2>/dev/null) || exit 1; stat=${stat##*) }; [ "${stat%% *}" != Z ]`
+
+// podProcessRunning tells whether a background process of the pod is still running
+func (env *Environment) podProcessRunning(ctx context.Context, pid int) bool {
+	pod, err := env.pod()
+	if err != nil {
+		return false
+	}
+	_, _, exitCode, err := pod.run(ctx, nil, "/", "sh", "-c", podProcessRunningScript, "sh", strconv.Itoa(pid))
+	return err == nil && exitCode == 0
+}
+
+// stopPodProcess stops a background process of the pod and removes it from the state
+func (env *Environment) stopPodProcess(ctx context.Context, bp *BackgroundProcess) error {
+	pod, err := env.pod()
+	if err != nil {
+		return err
+	}
+	pid := strconv.Itoa(bp.PID)
+	script := `kill "$1" 2>/dev/null || exit 0; sleep 1; kill -9 "$1" 2>/dev/null; rm -f "$2"; exit 0`
+	if _, err := pod.output(ctx, nil, "/", "sh", "-c", script, "sh", pid, bp.LogFile); err != nil {
+		return fmt.Errorf("failed to stop process %s: %w", pid, err)
+	}
+
+	env.mu.Lock()
+	env.State.BackgroundProcesses = slices.DeleteFunc(env.State.BackgroundProcesses, func(p BackgroundProcess) bool { return p.PID == bp.PID })
+	env.State.UpdatedAt = time.Now()
+	env.mu.Unlock()
+	env.Notes.Add("Stopped background process PID=%s", pid)
+	return nil
+}
+
+// readPodFile reads a file of the pod, relative to the workdir
+func (env *Environment) readPodFile(ctx context.Context, targetFile string) (string, error) {
+	pod, err := env.pod()
+	if err != nil {
+		return "", err
+	}
+	contents, err := pod.output(ctx, nil, env.State.Config.Workdir, "cat", "--", targetFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", targetFile, err)
+	}
+	return contents, nil
+}
+
+// writePodFile writes a file of the pod, relative to the workdir, creating its directories
+func (env *Environment) writePodFile(ctx context.Context, targetFile, contents string) error {
+	pod, err := env.pod()
+	if err != nil {
+		return err
+	}
+	script := `mkdir -p "$(dirname "$1")" && cat > "$1"`
+	if _, err := pod.output(ctx, strings.NewReader(contents), env.State.Config.Workdir, "sh", "-c", script, "sh", targetFile); err != nil {
+		return fmt.Errorf("failed to write %s: %w", targetFile, err)
+	}
+	env.touchPod()
+	return nil
+}
+
+// deletePodFile deletes a file of the pod, relative to the workdir
+func (env *Environment) deletePodFile(ctx context.Context, targetFile string) error {
+	pod, err := env.pod()
+	if err != nil {
+		return err
+	}
+	if _, err := pod.output(ctx, nil, env.State.Config.Workdir, "rm", "--", targetFile); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", targetFile, err)
+	}
+	env.touchPod()
+	return nil
+}
+
+// listPodDir lists the entries of a directory of the pod, directories ending with a slash
+func (env *Environment) listPodDir(ctx context.Context, path string) ([]string, error) {
+	pod, err := env.pod()
+	if err != nil {
+		return nil, err
+	}
+	out, err := pod.output(ctx, nil, env.State.Config.Workdir, "ls", "-1Ap", "--", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", path, err)
+	}
+	if out == "" {
+		return nil, nil
+	}
+	return strings.Split(strings.TrimSuffix(out, "\n"), "\n"), nil
+}
+
+// touchPod records that the files of the pod changed
+func (env *Environment) touchPod() {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.State.UpdatedAt = time.Now()
+}
+
+// ExportPod copies the workdir of the pod to a host directory, replacing its contents
+func (env *Environment) ExportPod(ctx context.Context, dir string) error {
+	pod, err := env.pod()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	if err := pod.copyOut(ctx, env.State.Config.Workdir, dir); err != nil {
+		return fmt.Errorf("failed to copy the workdir of pod %s: %w", pod.Name, err)
+	}
+	return nil
+}
+
+// podTerminal opens an interactive shell in the workdir of the pod
+func (env *Environment) podTerminal(ctx context.Context) error {
+	cmd, err := env.podShell(ctx)
+	if err != nil {
+		return err
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// podShell prepares an interactive shell in the workdir of the pod. kubectl allocates a TTY in the pod, so its
+// standard input must be a terminal.
+func (env *Environment) podShell(ctx context.Context) (*exec.Cmd, error) {
+	pod, err := env.pod()
+	if err != nil {
+		return nil, err
+	}
+	return pod.kubectl(ctx, "exec", "-it", pod.Name, "-c", podContainer, "--", "sh", "-c", `cd "$0" && exec sh`, env.State.Config.Workdir), nil
+}
@@ -0,0 +1,180 @@
+package environment
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKubectl runs the commands executed in pods on the host, as if the pod was the host
+const fakeKubectl = `#!/bin/sh
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--context|--namespace) shift 2 ;;
+	exec)
+		shift
+		[ "$1" = -i ] && shift
+		shift 4
+		exec "$@"
+		;;
+	*) echo "unexpected kubectl argument $1" >&2; exit 2 ;;
+	esac
+done
+`
+
+func newKubernetesEnvironment(t *testing.T, id string) *Environment {
+	kubectl := filepath.Join(t.TempDir(), "kubectl")
+	require.NoError(t, os.WriteFile(kubectl, []byte(fakeKubectl), 0755))
+	previous := kubectlBinary
+	kubectlBinary = kubectl
+	t.Cleanup(func() { kubectlBinary = previous })
+
+	return &Environment{
+		EnvironmentInfo: &EnvironmentInfo{
+			ID: id,
+			State: &State{
+				Config: &EnvironmentConfig{
+					BaseImage:  "golang:1.24",
+					Workdir:    t.TempDir(),
+					Kubernetes: &KubernetesConfig{Context: "dev", Namespace: "agents"},
+				},
+				Pod: &KubernetesPod{Name: podName(id, time.Now()), Namespace: "agents", Context: "dev"},
+			},
+		},
+	}
+}
+
+func TestPodName(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	assert.Equal(t, "cu-fancy-mallard-s44we8", podName("fancy-mallard", at))
+	assert.Equal(t, "cu-my-env-s44we8", podName("My_Env", at), "names are DNS labels")
+
+	long := podName(strings.Repeat("a", 80), at)
+	assert.Len(t, long, 63)
+	assert.True(t, strings.HasSuffix(long, "-s44we8"), "the generation is kept")
+	assert.NotEqual(t, podName("fancy-mallard", at), podName("fancy-mallard", at.Add(time.Second)), "rebuilds get a new pod")
+}
+
+func TestPodManifest(t *testing.T) {
+	config := &EnvironmentConfig{
+		BaseImage:  "golang:1.24",
+		Workdir:    "/workdir",
+		Env:        KVList{"GOFLAGS=-mod=mod", "EMPTY="},
+		Kubernetes: &KubernetesConfig{Namespace: "agents"},
+	}
+	data, err := podManifest(&KubernetesPod{Name: "cu-fancy-mallard-1", Namespace: "agents"}, "fancy-mallard", config)
+	require.NoError(t, err)
+
+	var pod struct {
+		Metadata struct {
+			Name      string            `json:"name"`
+			Namespace string            `json:"namespace"`
+			Labels    map[string]string `json:"labels"`
+		} `json:"metadata"`
+		Spec struct {
+			Containers []struct {
+				Name         string              `json:"name"`
+				Image        string              `json:"image"`
+				WorkingDir   string              `json:"workingDir"`
+				Env          []map[string]string `json:"env"`
+				VolumeMounts []map[string]string `json:"volumeMounts"`
+			} `json:"containers"`
+		} `json:"spec"`
+	}
+	require.NoError(t, json.Unmarshal(data, &pod))
+	assert.Equal(t, "cu-fancy-mallard-1", pod.Metadata.Name)
+	assert.Equal(t, "agents", pod.Metadata.Namespace)
+	assert.Equal(t, "fancy-mallard", pod.Metadata.Labels["container-use.dagger.io/environment"])
+	require.Len(t, pod.Spec.Containers, 1)
+	container := pod.Spec.Containers[0]
+	assert.Equal(t, podContainer, container.Name)
+	assert.Equal(t, "golang:1.24", container.Image)
+	assert.Equal(t, "/workdir", container.WorkingDir)
+	assert.Equal(t, []map[string]string{{"name": "GOFLAGS", "value": "-mod=mod"}, {"name": "EMPTY", "value": ""}}, container.Env)
+	assert.Equal(t, []map[string]string{{"name": "workdir", "mountPath": "/workdir"}}, container.VolumeMounts)
+}
+
+func TestKubernetesConfigValidate(t *testing.T) {
+	config := DefaultConfig()
+	config.Kubernetes = &KubernetesConfig{Namespace: "agents"}
+	assert.NoError(t, config.Validate())
+
+	config.Kubernetes.Namespace = "Agents"
+	config.Secrets = KVList{"TOKEN=env://GITHUB_TOKEN"}
+	err := config.Validate()
+	assert.ErrorContains(t, err, `invalid namespace "Agents"`)
+	assert.ErrorContains(t, err, "secrets aren't supported in Kubernetes mode")
+
+	copied := config.Copy()
+	copied.Kubernetes.Namespace = "agents"
+	assert.Equal(t, "Agents", config.Kubernetes.Namespace, "the configuration is copied")
+}
+
+func TestKubernetesFiles(t *testing.T) {
+	ctx := context.Background()
+	env := newKubernetesEnvironment(t, "env-files")
+	require.True(t, env.IsKubernetes())
+
+	require.NoError(t, env.FileWrite(ctx, "", "src/main.go", "package main\n"))
+	contents, err := env.FileRead(ctx, "src/main.go", true, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "package main\n", contents)
+
+	require.NoError(t, env.FileEdit(ctx, "", "src/main.go", "main", "app", ""))
+	contents, err = env.FileRead(ctx, "src/main.go", true, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "package app\n", contents)
+
+	list, err := env.FileList(ctx, ".")
+	require.NoError(t, err)
+	assert.Equal(t, "src/\n", list)
+
+	export := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(export, "stale.txt"), []byte("stale"), 0600))
+	require.NoError(t, env.ExportPod(ctx, export))
+	exported, err := os.ReadFile(filepath.Join(export, "src", "main.go"))
+	require.NoError(t, err)
+	assert.Equal(t, "package app\n", string(exported))
+	assert.NoFileExists(t, filepath.Join(export, "stale.txt"), "the workdir of the pod replaces the directory")
+
+	require.NoError(t, env.FileDelete(ctx, "", "src/main.go"))
+	_, err = env.FileRead(ctx, "src/main.go", true, 0, 0)
+	assert.ErrorContains(t, err, "failed to read src/main.go")
+}
+
+func TestKubernetesRun(t *testing.T) {
+	ctx := context.Background()
+	env := newKubernetesEnvironment(t, "env-run")
+
+	out, failure, err := env.Run(ctx, "echo hello && pwd", "sh", false)
+	require.NoError(t, err)
+	assert.Nil(t, failure)
+	assert.Equal(t, "hello\n"+env.State.Config.Workdir+"\n", out, "commands run in the workdir")
+
+	_, failure, err = env.Run(ctx, "exit 3", "sh", false)
+	require.NoError(t, err)
+	require.NotNil(t, failure)
+	assert.Equal(t, 3, failure.ExitCode)
+
+	_, err = env.RunBackground(ctx, "echo started && sleep 30", "sh", nil, false)
+	require.NoError(t, err)
+	processes, err := env.ProcessesWithUsage(ctx)
+	require.NoError(t, err)
+	require.Len(t, processes, 1)
+	assert.True(t, processes[0].Running)
+
+	require.Eventually(t, func() bool {
+		logs, err := env.ProcessLogs(ctx, processes[0].ID)
+		return err == nil && logs == "started\n"
+	}, 5*time.Second, 50*time.Millisecond)
+
+	require.NoError(t, env.StopProcess(ctx, processes[0].ID))
+	assert.Empty(t, env.Processes())
+}
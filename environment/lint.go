@@ -0,0 +1,292 @@
+package environment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// Severities of lint issues
+const (
+	// LintError is a configuration that fails to build environments
+	LintError = "error"
+	// LintWarning is a configuration that builds, but likely not as intended
+	LintWarning = "warning"
+)
+
+// LintIssue is a problem found in a configuration before it is used to build environments
+type LintIssue struct {
+	Severity string `json:"severity"`
+	// Field is where the issue is, e.g. base_image, install_commands[1] or services[postgres]
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (i LintIssue) String() string {
+	return fmt.Sprintf("%s: %s: %s", i.Severity, i.Field, i.Message)
+}
+
+// imageRefRe matches image references: [registry[:port]/]name[:tag][@digest]
+var imageRefRe = regexp.MustCompile(`^(?:[a-zA-Z0-9.-]+(?::[0-9]+)?/)?[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*(?::[A-Za-z0-9_][A-Za-z0-9_.-]{0,127})?(?:@sha256:[a-f0-9]{64})?$`)
+
+// lintFileExtensions are the extensions of the files commands are checked to reference
+var lintFileExtensions = []string{
+	".sh", ".bash", ".py", ".rb", ".js", ".mjs", ".cjs", ".ts", ".txt", ".toml", ".json", ".lock",
+	".yaml", ".yml", ".cfg", ".ini", ".mod", ".sum", ".xml", ".gradle", ".sql", ".gemspec",
+}
+
+// LintConfigFile reports the fields of the project's configuration file that container-use doesn't know:
+// they are ignored, usually because of a typo.
+func LintConfigFile(baseDir string) ([]LintIssue, error) {
+	data, err := os.ReadFile(filepath.Join(baseDir, configDir, environmentFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&EnvironmentConfig{}); err != nil {
+		return []LintIssue{{Severity: LintError, Field: environmentFile, Message: err.Error()}}, nil
+	}
+	return nil, nil
+}
+
+// LintConfig checks a configuration for the project in baseDir: invalid settings, image references, commands
+// referencing files the project doesn't have, conflicting services and secret references that don't resolve.
+// Secrets are resolved, but their values aren't kept. Images are only checked by ResolveImages.
+func LintConfig(ctx context.Context, config *EnvironmentConfig, baseDir string) []LintIssue {
+	issues := []LintIssue{}
+	add := func(severity, field, format string, args ...any) {
+		issues = append(issues, LintIssue{Severity: severity, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	host := strings.EqualFold(config.BaseImage, "host")
+
+	// invalid are the fields with errors found by Validate, not checked further
+	invalid := map[string]bool{}
+	var configErr *InvalidConfigError
+	if errors.As(config.Validate(), &configErr) {
+		for _, err := range configErr.Errors {
+			add(LintError, err.Field, "%s", err.Message)
+			invalid[err.Field] = true
+		}
+	}
+
+	for i, command := range config.SetupCommands {
+		field := fmt.Sprintf("setup_commands[%d]", i)
+		if err := shellSyntaxError(command); err != nil {
+			add(LintError, field, "%s", err)
+		}
+		for _, file := range commandFiles(command) {
+			exists := fileExists(baseDir, file)
+			switch {
+			case host && !exists:
+				add(LintError, field, "%s not found in the project", file)
+			case !host && exists:
+				// Setup results are shared by environments, so they run before the source is copied
+				add(LintError, field, "%s is not available to setup commands, which run before the source is copied: use an install command", file)
+			}
+		}
+	}
+	for i, command := range config.InstallCommands {
+		if err := shellSyntaxError(command); err != nil {
+			add(LintError, fmt.Sprintf("install_commands[%d]", i), "%s", err)
+		}
+		for _, file := range commandFiles(command) {
+			if !fileExists(baseDir, file) {
+				add(LintError, fmt.Sprintf("install_commands[%d]", i), "%s not found in the project", file)
+			}
+		}
+	}
+
+	issues = append(issues, lintServices(config.Services, host)...)
+
+	for _, kind := range []struct {
+		field   string
+		secrets KVList
+	}{{"secrets", config.Secrets}, {"plan_secrets", config.PlanSecrets}} {
+		for _, key := range kind.secrets.Keys() {
+			field := fmt.Sprintf("%s[%s]", kind.field, key)
+			if invalid[field] || !validEnvVarName(key) {
+				continue
+			}
+			if _, err := ResolveSecret(ctx, kind.secrets.Get(key)); err != nil {
+				add(LintError, field, "%s", err)
+			}
+		}
+	}
+	for _, w := range config.Webhooks {
+		if w.Secret == "" {
+			continue
+		}
+		if _, err := ResolveSecret(ctx, w.Secret); err != nil {
+			add(LintError, fmt.Sprintf("webhooks[%s]", w.URL), "%s", err)
+		}
+	}
+	return issues
+}
+
+// lintServices checks that services can be reached by their name and ports, once Validate checked they can be started
+func lintServices(services ServiceConfigs, host bool) []LintIssue {
+	issues := []LintIssue{}
+	add := func(severity, field, format string, args ...any) {
+		issues = append(issues, LintIssue{Severity: severity, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	if host && len(services) > 0 {
+		add(LintWarning, "services", "services are not started on the host")
+	}
+
+	// portServices are the services exposing each port
+	portServices := map[int][]string{}
+	for i, svc := range services {
+		field := fmt.Sprintf("services[%d]", i)
+		if svc.Name != "" {
+			field = fmt.Sprintf("services[%s]", svc.Name)
+		}
+		if err := shellSyntaxError(svc.Command); err != nil {
+			add(LintError, field, "command: %s", err)
+		}
+		if svc.Healthcheck != nil {
+			if err := shellSyntaxError(svc.Healthcheck.Command); err != nil {
+				add(LintError, field, "healthcheck: %s", err)
+			}
+		}
+		exposed := map[int]bool{}
+		for _, port := range svc.ExposedPorts {
+			switch {
+			case port < 1 || port > 65535:
+				// Reported by Validate
+			case exposed[port]:
+				add(LintWarning, field, "port %d is exposed twice", port)
+			default:
+				portServices[port] = append(portServices[port], svc.Name)
+			}
+			exposed[port] = true
+		}
+	}
+
+	ports := make([]int, 0, len(portServices))
+	for port := range portServices {
+		ports = append(ports, port)
+	}
+	slices.Sort(ports)
+	for _, port := range ports {
+		if names := portServices[port]; len(names) > 1 {
+			add(LintWarning, "services", "port %d is exposed by %s: they can only be told apart by hostname, not through localhost", port, strings.Join(names, ", "))
+		}
+	}
+	return issues
+}
+
+// shellSyntaxError checks the syntax of a command with `sh -n`, which doesn't run it.
+// Commands aren't checked when sh isn't available.
+func shellSyntaxError(command string) error {
+	if strings.TrimSpace(command) == "" {
+		return nil
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		return nil
+	}
+	if out, err := exec.Command(sh, "-n", "-c", command).CombinedOutput(); err != nil {
+		return fmt.Errorf("invalid shell syntax: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// commandFiles returns the project files a command references by relative path. Files the command checks the
+// existence of (e.g. `[ -f go.mod ]`) or writes to are skipped, as are the ones using variables or globs.
+func commandFiles(command string) []string {
+	files := []string{}
+	for _, file := range commandPaths(command) {
+		if strings.HasPrefix(file, "/") || strings.HasPrefix(file, "~") ||
+			strings.ContainsAny(file, "$*?`<>=:@{}[]") {
+			continue
+		}
+		if !strings.HasPrefix(file, "./") && !strings.HasPrefix(file, "../") && !slices.Contains(lintFileExtensions, filepath.Ext(file)) {
+			continue
+		}
+		if file = filepath.Clean(file); !slices.Contains(files, file) {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// commandPaths returns the arguments of a command that may be paths it reads, unquoted.
+// Paths the command checks the existence of or writes to are skipped.
+func commandPaths(command string) []string {
+	tokens := strings.FieldsFunc(command, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == '\n' || r == ';' || r == '&' || r == '|' || r == '(' || r == ')'
+	})
+	skip := map[string]bool{}
+	for i, token := range tokens {
+		if i+1 >= len(tokens) {
+			break
+		}
+		switch token {
+		case "-f", "-e", "-d", "-s":
+			if i > 0 && slices.Contains([]string{"[", "[[", "test", "!"}, tokens[i-1]) {
+				skip[strings.Trim(tokens[i+1], `"'`)] = true
+			}
+		case ">", ">>", "-o", "-O", "--output":
+			skip[strings.Trim(tokens[i+1], `"'`)] = true
+		}
+	}
+
+	paths := []string{}
+	for _, token := range tokens {
+		if strings.HasPrefix(token, "-") {
+			// --requirement=requirements.txt
+			_, value, ok := strings.Cut(token, "=")
+			if !ok {
+				continue
+			}
+			token = value
+		}
+		if path := strings.Trim(token, `"'`); path != "" && !skip[path] {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// ResolveImages checks that the base image and the images of the services exist, by resolving them in their
+// registry. Images aren't pulled.
+func ResolveImages(ctx context.Context, dag *dagger.Client, config *EnvironmentConfig) ([]LintIssue, error) {
+	if err := requireNetwork("resolving images"); err != nil {
+		return nil, err
+	}
+	issues := []LintIssue{}
+	resolve := func(field, image string) {
+		if image == "" || !imageRefRe.MatchString(image) {
+			// Reported by LintConfig
+			return
+		}
+		if _, err := dag.Container().From(image).ImageRef(ctx); err != nil {
+			issues = append(issues, LintIssue{Severity: LintError, Field: field, Message: fmt.Sprintf("unknown image %s: %s", image, err)})
+		}
+	}
+	if !strings.EqualFold(config.BaseImage, "host") {
+		resolve("base_image", config.BaseImage)
+	}
+	for i, svc := range config.Services {
+		field := fmt.Sprintf("services[%d]", i)
+		if svc.Name != "" {
+			field = fmt.Sprintf("services[%s]", svc.Name)
+		}
+		resolve(field, svc.Image)
+	}
+	return issues, nil
+}
@@ -0,0 +1,80 @@
+package environment
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandFiles(t *testing.T) {
+	tests := []struct {
+		command string
+		files   []string
+	}{
+		{"pip install -r requirements.txt", []string{"requirements.txt"}},
+		{"pip install --requirement=requirements/dev.txt", []string{"requirements/dev.txt"}},
+		{"./scripts/setup && bash ./scripts/seed.sh", []string{"scripts/setup", "scripts/seed.sh"}},
+		{"if [ -f go.mod ]; then go mod download; fi", []string{}},
+		{"curl -o install.sh https://example.com/install.sh && sh install.sh", []string{}},
+		{"apt-get install -y python3.12 && cat /etc/os-release.txt $HOME/x.sh *.txt", []string{}},
+		{"npm ci", []string{}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.files, commandFiles(tt.command), tt.command)
+	}
+}
+
+func TestLintConfig(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "requirements.txt"), nil, 0600))
+	t.Setenv("LINT_TEST_TOKEN", "secret")
+
+	config := DefaultConfig()
+	config.BaseImage = "Python:3.12"
+	config.SetupCommands = []string{"pip install -r requirements.txt"}
+	config.InstallCommands = []string{"pip install -r requirements.txt", "./bootstrap.sh"}
+	config.Secrets = KVList{"TOKEN=env://LINT_TEST_TOKEN", "MISSING=env://LINT_TEST_MISSING", "OTHER=keychain://other"}
+	config.Services = ServiceConfigs{
+		{Name: "postgres", Image: "postgres:17", ExposedPorts: []int{5432}},
+		{Name: "replica", Image: "postgres:17", ExposedPorts: []int{5432, 5432}},
+		{Name: "postgres", Image: "postgres:16"},
+	}
+
+	fields := map[string]string{}
+	for _, issue := range LintConfig(context.Background(), config, dir) {
+		fields[issue.Field] += issue.Severity + " "
+	}
+	assert.Equal(t, map[string]string{
+		"base_image":          "error ",
+		"setup_commands[0]":   "error ",
+		"install_commands[1]": "error ",
+		"secrets[MISSING]":    "error ",
+		"secrets[OTHER]":      "error ",
+		"services[replica]":   "warning ",
+		"services[postgres]":  "error ",
+		"services":            "warning ",
+	}, fields)
+
+	assert.Empty(t, LintConfig(context.Background(), DefaultConfig(), dir))
+	for _, template := range Templates() {
+		assert.Empty(t, LintConfig(context.Background(), template.Config(), dir), template.Name)
+	}
+}
+
+func TestLintConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	issues, err := LintConfigFile(dir)
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, configDir), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, configDir, environmentFile), []byte(`{"base_image": "alpine", "setup_command": ["apk add git"]}`), 0600))
+	issues, err = LintConfigFile(dir)
+	require.NoError(t, err)
+	require.Len(t, issues, 1)
+	assert.Contains(t, issues[0].Message, "setup_command")
+}
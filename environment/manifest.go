@@ -0,0 +1,279 @@
+package environment
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+const (
+	// maxOutputManifests is the number of commands whose output manifest is kept in the state
+	maxOutputManifests = 20
+	// maxManifestFiles caps the files hashed for a manifest
+	maxManifestFiles = 10000
+)
+
+// OutputManifest records the hashes of the files produced by a command, to verify that running it again from
+// scratch produces the same files
+type OutputManifest struct {
+	Command string `json:"command"`
+	Shell   string `json:"shell"`
+	// Outputs are the files and directories produced by the command, relative to the workdir
+	Outputs []string `json:"outputs"`
+	// Files are the SHA-256 of the output files, by path relative to the workdir
+	Files     map[string]string `json:"files"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// ReproducibilityReport compares the files a command produced in a fresh fork of the environment with its manifest
+type ReproducibilityReport struct {
+	Command      string `json:"command"`
+	Reproducible bool   `json:"reproducible"`
+	// Files is the number of files of the manifest
+	Files int `json:"files"`
+	// Differing files have different contents, Missing files weren't produced again and Unexpected files weren't
+	// in the manifest
+	Differing  []string `json:"differing"`
+	Missing    []string `json:"missing"`
+	Unexpected []string `json:"unexpected"`
+}
+
+// checkOutputs cleans the output paths of a manifest, which must be within the workdir
+func checkOutputs(outputs []string) ([]string, error) {
+	if len(outputs) == 0 {
+		return nil, errors.New("no outputs to hash")
+	}
+	cleaned := make([]string, 0, len(outputs))
+	for _, output := range outputs {
+		output = path.Clean(filepath.ToSlash(output))
+		if !filepath.IsLocal(output) || output == "." {
+			return nil, fmt.Errorf("output %q must be a file or directory within the workdir", output)
+		}
+		cleaned = append(cleaned, output)
+	}
+	return cleaned, nil
+}
+
+// RecordManifest hashes the outputs of a command that ran successfully, and keeps the manifest for the command
+func (env *Environment) RecordManifest(ctx context.Context, command, shell string, outputs []string) (*OutputManifest, error) {
+	outputs, err := checkOutputs(outputs)
+	if err != nil {
+		return nil, err
+	}
+	files, err := env.hashOutputs(ctx, outputs)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files found in the outputs %s", strings.Join(outputs, ", "))
+	}
+	manifest := &OutputManifest{
+		Command:   strings.TrimSpace(command),
+		Shell:     shell,
+		Outputs:   outputs,
+		Files:     files,
+		CreatedAt: time.Now(),
+	}
+
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if env.State.OutputManifests == nil {
+		env.State.OutputManifests = map[string]*OutputManifest{}
+	}
+	env.State.OutputManifests[manifest.Command] = manifest
+	if len(env.State.OutputManifests) > maxOutputManifests {
+		oldest := ""
+		for cmd, m := range env.State.OutputManifests {
+			if oldest == "" || m.CreatedAt.Before(env.State.OutputManifests[oldest].CreatedAt) {
+				oldest = cmd
+			}
+		}
+		delete(env.State.OutputManifests, oldest)
+	}
+	return manifest, nil
+}
+
+// hashOutputs returns the SHA-256 of the files under the outputs, by path relative to the workdir.
+// Missing outputs have no files.
+func (env *Environment) hashOutputs(ctx context.Context, outputs []string) (map[string]string, error) {
+	if env.IsHost() {
+		return hashHostOutputs(env.State.Config.Workdir, outputs)
+	}
+
+	script := `for o in "$@"; do if [ -e "$o" ]; then find "$o" -type f -exec sha256sum {} + || exit 1; fi; done`
+	args := append([]string{"sh", "-c", script, "sh"}, outputs...)
+	if env.IsKubernetes() {
+		pod, err := env.pod()
+		if err != nil {
+			return nil, err
+		}
+		out, err := pod.output(ctx, nil, env.State.Config.Workdir, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash the outputs: %w", err)
+		}
+		return parseChecksums(out)
+	}
+	out, err := env.container().
+		WithWorkdir(env.State.Config.Workdir).
+		WithExec(args).
+		Stdout(ctx)
+	if err != nil {
+		var exitErr *dagger.ExecError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("failed to hash the outputs: %s", strings.TrimSpace(exitErr.Stderr))
+		}
+		return nil, fmt.Errorf("failed to hash the outputs: %w", err)
+	}
+	return parseChecksums(out)
+}
+
+// parseChecksums parses the output of sha256sum
+func parseChecksums(out string) (map[string]string, error) {
+	files := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		sum, name, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			continue
+		}
+		files[path.Clean(name)] = sum
+		if len(files) > maxManifestFiles {
+			return nil, fmt.Errorf("more than %d output files: narrow down the outputs", maxManifestFiles)
+		}
+	}
+	return files, scanner.Err()
+}
+
+// hashHostOutputs hashes the outputs in a workdir on the host
+func hashHostOutputs(workdir string, outputs []string) (map[string]string, error) {
+	files := map[string]string{}
+	for _, output := range outputs {
+		err := filepath.WalkDir(filepath.Join(workdir, filepath.FromSlash(output)), func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if d == nil && errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			if len(files) >= maxManifestFiles {
+				return fmt.Errorf("more than %d output files: narrow down the outputs", maxManifestFiles)
+			}
+			sum, err := hashFile(p)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(workdir, p)
+			if err != nil {
+				return err
+			}
+			files[filepath.ToSlash(rel)] = sum
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash the outputs: %w", err)
+		}
+	}
+	return files, nil
+}
+
+func hashFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// CompareManifests compares the files produced by a fresh run of a command with its manifest
+func CompareManifests(manifest *OutputManifest, files map[string]string) *ReproducibilityReport {
+	report := &ReproducibilityReport{
+		Command:    manifest.Command,
+		Files:      len(manifest.Files),
+		Differing:  []string{},
+		Missing:    []string{},
+		Unexpected: []string{},
+	}
+	for name, sum := range manifest.Files {
+		fresh, ok := files[name]
+		switch {
+		case !ok:
+			report.Missing = append(report.Missing, name)
+		case fresh != sum:
+			report.Differing = append(report.Differing, name)
+		}
+	}
+	for name := range files {
+		if _, ok := manifest.Files[name]; !ok {
+			report.Unexpected = append(report.Unexpected, name)
+		}
+	}
+	slices.Sort(report.Differing)
+	slices.Sort(report.Missing)
+	slices.Sort(report.Unexpected)
+	report.Reproducible = len(report.Differing) == 0 && len(report.Missing) == 0 && len(report.Unexpected) == 0
+	return report
+}
+
+// VerifyReproducible runs the command of a manifest of the environment again in a fresh fork, created with its
+// configuration from its source files without the outputs of the manifest, and compares the files it produces with
+// the manifest. The fork is discarded.
+func (env *Environment) VerifyReproducible(ctx context.Context, source *dagger.Directory, command string) (*ReproducibilityReport, error) {
+	if env.IsHost() || env.IsKubernetes() {
+		return nil, errors.New("reproducibility can only be verified in container mode: host and Kubernetes mode environments can't be forked from scratch")
+	}
+	manifest := env.State.OutputManifests[strings.TrimSpace(command)]
+	if manifest == nil {
+		return nil, fmt.Errorf("no output manifest for %q: run it with environment_run_cmd and its outputs first", command)
+	}
+	// The fork doesn't have the command policy of the environment
+	if err := env.checkCommand(ctx, manifest.Command); err != nil {
+		return nil, err
+	}
+
+	for _, output := range manifest.Outputs {
+		source = source.WithoutDirectory(output).WithoutFile(output)
+	}
+	fork, err := New(ctx, env.dag, env.ID+"-reproduce", env.State.Title, env.State.Config.Copy(), source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fork the environment: %w", err)
+	}
+	defer func() {
+		for _, svc := range fork.RunningServices() {
+			svc.Stop(context.WithoutCancel(ctx))
+		}
+	}()
+
+	_, failure, err := fork.Run(ctx, manifest.Command, manifest.Shell, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run %q in the fork: %w", manifest.Command, err)
+	}
+	if failure != nil {
+		return nil, fmt.Errorf("%q failed in the fork with exit code %d (%s): %s", manifest.Command, failure.ExitCode, failure.Category, failure.Evidence)
+	}
+	files, err := fork.hashOutputs(ctx, manifest.Outputs)
+	if err != nil {
+		return nil, err
+	}
+	return CompareManifests(manifest, files), nil
+}
@@ -0,0 +1,71 @@
+package environment
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckOutputs(t *testing.T) {
+	outputs, err := checkOutputs([]string{"./dist/", "build/app"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dist", "build/app"}, outputs)
+
+	for _, output := range []string{"/tmp/dist", "../dist", ".", "dist/../.."} {
+		_, err := checkOutputs([]string{output})
+		assert.Error(t, err, output)
+	}
+	_, err = checkOutputs(nil)
+	assert.Error(t, err)
+}
+
+func TestParseChecksums(t *testing.T) {
+	files, err := parseChecksums("e3b0c442  dist/app.js\n9f86d081  ./dist/assets/logo.svg\n")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"dist/app.js": "e3b0c442", "dist/assets/logo.svg": "9f86d081"}, files)
+}
+
+func TestRecordManifest(t *testing.T) {
+	ctx := context.Background()
+	env := newHostEnvironment(t, "env-manifest")
+	workdir := env.State.Config.Workdir
+	require.NoError(t, os.MkdirAll(filepath.Join(workdir, "dist", "assets"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(workdir, "dist", "app.js"), []byte("console.log(1)\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(workdir, "dist", "assets", "logo.svg"), []byte("<svg/>"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(workdir, "main.go"), []byte("package main\n"), 0644))
+
+	manifest, err := env.RecordManifest(ctx, " npm run build ", "sh", []string{"dist", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, "npm run build", manifest.Command)
+	require.Len(t, manifest.Files, 2, "only the files of the outputs are hashed, missing outputs have none")
+	assert.Equal(t, "3879a5d930ae1999b278a3a498f7de3fd83ba8dae59330fcfa2db31c103ac21d", manifest.Files["dist/app.js"])
+	assert.Len(t, manifest.Files["dist/assets/logo.svg"], 64)
+	assert.Same(t, manifest, env.State.OutputManifests["npm run build"])
+
+	_, err = env.RecordManifest(ctx, "npm run build", "sh", []string{"missing"})
+	assert.ErrorContains(t, err, "no files found")
+
+	_, err = env.VerifyReproducible(ctx, nil, "npm run build")
+	assert.ErrorContains(t, err, "container mode")
+}
+
+func TestCompareManifests(t *testing.T) {
+	manifest := &OutputManifest{
+		Command: "make",
+		Files:   map[string]string{"bin/app": "aaaa", "bin/tool": "bbbb", "bin/old": "cccc"},
+	}
+
+	report := CompareManifests(manifest, map[string]string{"bin/app": "aaaa", "bin/tool": "bbbb", "bin/old": "cccc"})
+	assert.True(t, report.Reproducible)
+	assert.Equal(t, 3, report.Files)
+
+	report = CompareManifests(manifest, map[string]string{"bin/app": "aaaa", "bin/tool": "dddd", "bin/new": "eeee"})
+	assert.False(t, report.Reproducible)
+	assert.Equal(t, []string{"bin/tool"}, report.Differing)
+	assert.Equal(t, []string{"bin/old"}, report.Missing)
+	assert.Equal(t, []string{"bin/new"}, report.Unexpected)
+}
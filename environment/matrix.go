@@ -0,0 +1,184 @@
+package environment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+const (
+	// MaxMatrixVariants caps the number of variants of a matrix run, as they all run at once
+	MaxMatrixVariants = 16
+	// maxMatrixOutput is the size of the tail of the output of each variant
+	maxMatrixOutput = 16 * 1024
+)
+
+// MatrixVariant is a set of parameters to run a command with in a matrix run
+type MatrixVariant struct {
+	Name string `json:"name,omitempty"`
+	// Env are the environment variables of the variant, as KEY=VALUE
+	Env []string `json:"env,omitempty"`
+	// Command replaces the command of the matrix run for the variant
+	Command string `json:"command,omitempty"`
+}
+
+// MatrixResult is the outcome of a variant of a matrix run
+type MatrixResult struct {
+	Name     string        `json:"name"`
+	Command  string        `json:"command"`
+	Env      []string      `json:"env,omitempty"`
+	ExitCode int           `json:"exit_code"`
+	Stdout   string        `json:"stdout"`
+	Stderr   string        `json:"stderr"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	// Failure tells why the variant exited with a non-zero code
+	Failure *FailureAnalysis `json:"failure,omitempty"`
+}
+
+// Failed reports whether the variant failed to run or exited with a non-zero code
+func (r *MatrixResult) Failed() bool {
+	return r.Error != "" || r.ExitCode != 0
+}
+
+// RunMatrix runs a command in clones of the environment, one per variant, in parallel.
+// Variants are isolated from each other and their changes are discarded: the environment is left untouched.
+// In container mode, each variant runs on top of the current container state.
+// In host mode, each variant runs in a copy of the workdir.
+func (env *Environment) RunMatrix(ctx context.Context, command, shell string, variants []MatrixVariant) ([]MatrixResult, error) {
+	if len(variants) == 0 {
+		return nil, errors.New("matrix run has no variants")
+	}
+	if len(variants) > MaxMatrixVariants {
+		return nil, fmt.Errorf("matrix run has %d variants, at most %d are allowed", len(variants), MaxMatrixVariants)
+	}
+	results := make([]MatrixResult, len(variants))
+	names := map[string]bool{}
+	for i, v := range variants {
+		r := MatrixResult{Name: v.Name, Command: v.Command, Env: v.Env}
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("variant-%d", i+1)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("duplicate variant name %q", r.Name)
+		}
+		names[r.Name] = true
+		if r.Command == "" {
+			r.Command = command
+		}
+		if strings.TrimSpace(r.Command) == "" {
+			return nil, fmt.Errorf("variant %s has no command", r.Name)
+		}
+		for _, kv := range r.Env {
+			if k, _, ok := strings.Cut(kv, "="); !ok || k == "" {
+				return nil, fmt.Errorf("invalid environment variable %q of variant %s: expected KEY=VALUE", kv, r.Name)
+			}
+		}
+		if err := env.checkCommand(ctx, r.Command); err != nil {
+			return nil, err
+		}
+		env.recordEnvUsage(r.Command)
+		results[i] = r
+	}
+
+	started := time.Now()
+	defer env.chargeCommandTime(started)
+
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(r *MatrixResult) {
+			defer wg.Done()
+			start := time.Now()
+			var err error
+			if env.IsHost() {
+				err = env.runHostVariant(ctx, r, shell)
+			} else {
+				err = env.runContainerVariant(ctx, r, shell)
+			}
+			if err != nil {
+				r.Error = err.Error()
+			}
+			r.Failure = AnalyzeFailure(r.ExitCode, r.Stdout, r.Stderr)
+			r.Stdout = tail(r.Stdout, maxMatrixOutput)
+			r.Stderr = tail(r.Stderr, maxMatrixOutput)
+			r.Duration = time.Since(start)
+		}(&results[i])
+	}
+	wg.Wait()
+
+	failed := []string{}
+	for _, r := range results {
+		if r.Failed() {
+			failed = append(failed, r.Name)
+		}
+	}
+	note := fmt.Sprintf("Matrix run of %d variants in %s", len(results), time.Since(started).Round(time.Second))
+	if len(failed) > 0 {
+		note += fmt.Sprintf(", failed: %s", strings.Join(failed, ", "))
+	}
+	env.Notes.Add("%s", note)
+	return results, nil
+}
+
+func (env *Environment) runContainerVariant(ctx context.Context, r *MatrixResult, shell string) error {
+	container := env.container()
+	for _, kv := range r.Env {
+		k, v, _ := strings.Cut(kv, "=")
+		container = container.WithEnvVariable(k, v)
+	}
+	container = container.WithExec(env.limit([]string{shell, "-c", r.Command}), dagger.ContainerWithExecOpts{
+		Expect:                        dagger.ReturnTypeAny, // Don't treat non-zero exit as error
+		ExperimentalPrivilegedNesting: true,
+	})
+
+	var err error
+	if r.ExitCode, err = container.ExitCode(ctx); err != nil {
+		return fmt.Errorf("failed to get exit code: %w", err)
+	}
+	if r.Stdout, err = container.Stdout(ctx); err != nil {
+		return fmt.Errorf("failed to get stdout: %w", err)
+	}
+	if r.Stderr, err = container.Stderr(ctx); err != nil {
+		return fmt.Errorf("failed to get stderr: %w", err)
+	}
+	return nil
+}
+
+func (env *Environment) runHostVariant(ctx context.Context, r *MatrixResult, shell string) error {
+	workdir, err := os.MkdirTemp("", "container-use-matrix-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workdir)
+	if out, err := exec.CommandContext(ctx, "cp", "-a", env.State.Config.Workdir+"/.", workdir).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to copy the workdir: %w: %s", err, out)
+	}
+
+	args := env.limit([]string{shell, "-c", r.Command})
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = workdir
+	hostEnv, err := env.buildHostEnv(ctx)
+	if err != nil {
+		return err
+	}
+	cmd.Env = append(hostEnv, r.Env...)
+	var stdout, stderr strings.Builder
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	r.Stdout, r.Stderr = stdout.String(), stderr.String()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		r.ExitCode = exitErr.ExitCode()
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+package environment
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunMatrixHost(t *testing.T) {
+	env := newHostEnvironment(t, "env-matrix")
+	workdir := env.State.Config.Workdir
+	require.NoError(t, os.WriteFile(filepath.Join(workdir, "input"), []byte("data"), 0600))
+
+	results, err := env.RunMatrix(t.Context(), `echo "$PY-$(cat input)" > output && cat output`, "sh", []MatrixVariant{
+		{Env: []string{"PY=3.11"}},
+		{Name: "py312", Env: []string{"PY=3.12"}},
+		{Name: "broken", Command: "echo oops >&2; exit 3"},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+
+	assert.Equal(t, "variant-1", results[0].Name)
+	assert.Equal(t, "3.11-data\n", results[0].Stdout)
+	assert.False(t, results[0].Failed())
+	assert.Equal(t, "3.12-data\n", results[1].Stdout)
+
+	assert.Equal(t, 3, results[2].ExitCode)
+	assert.Equal(t, "oops\n", results[2].Stderr)
+	assert.True(t, results[2].Failed())
+
+	assert.NoFileExists(t, filepath.Join(workdir, "output"), "variants run in copies of the workdir")
+	assert.Contains(t, env.Notes.String(), "Matrix run of 3 variants")
+	assert.Contains(t, env.Notes.String(), "failed: broken")
+}
+
+func TestRunMatrixValidation(t *testing.T) {
+	env := newHostEnvironment(t, "env-matrix-validation")
+	for name, variants := range map[string][]MatrixVariant{
+		"no variants":   nil,
+		"duplicate":     {{Name: "a"}, {Name: "a"}},
+		"invalid env":   {{Env: []string{"NOVALUE"}}},
+		"too many":      make([]MatrixVariant, MaxMatrixVariants+1),
+		"empty command": {{Command: " "}},
+	} {
+		command := "true"
+		if name == "empty command" {
+			command = ""
+		}
+		_, err := env.RunMatrix(t.Context(), command, "sh", variants)
+		assert.Error(t, err, name)
+	}
+}
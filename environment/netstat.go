@@ -0,0 +1,263 @@
+package environment
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tcpStates are the states of /proc/net/tcp sockets
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+}
+
+const portCheckTimeout = 2 * time.Second
+
+// Socket is a network socket of the environment
+type Socket struct {
+	Protocol      string `json:"protocol"`
+	LocalAddress  string `json:"local_address"`
+	RemoteAddress string `json:"remote_address,omitempty"`
+	State         string `json:"state"`
+}
+
+// EndpointCheck reports whether a service bound to the environment, or a port of a host background process, is reachable
+type EndpointCheck struct {
+	Name string `json:"name"`
+	// Addresses are the addresses the name resolves to
+	Addresses []string    `json:"addresses,omitempty"`
+	Ports     []PortCheck `json:"ports,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+type PortCheck struct {
+	Port int `json:"port"`
+	// Open is unset when no tool was available in the container to check it
+	Open *bool `json:"open,omitempty"`
+}
+
+// NetworkInfo is the network state of an environment
+type NetworkInfo struct {
+	Listening   []Socket        `json:"listening"`
+	Established []Socket        `json:"established"`
+	Endpoints   []EndpointCheck `json:"endpoints,omitempty"`
+}
+
+// Netstat returns the sockets of the environment and checks its endpoints.
+// In container mode, no process outlives a command: sockets are those of the command checking them, and the
+// endpoints are the services, resolved and connected to from the environment.
+// In host mode, sockets are those of the host (Linux only), and the endpoints are the ports of the background processes.
+func (env *Environment) Netstat(ctx context.Context) (*NetworkInfo, error) {
+	if env.IsHost() {
+		return env.hostNetstat()
+	}
+
+	output, err := env.container().
+		// The network changes over time, don't cache it
+		WithEnvVariable("CU_NETSTAT_AT", time.Now().String()).
+		WithExec([]string{"sh", "-c", netstatScript(env.State.Config.Services)}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get network state: %w", err)
+	}
+	return parseNetstat(output, env.State.Config.Services), nil
+}
+
+// netstatScript prints the sockets of the container, then the addresses and open ports of the services
+func netstatScript(services ServiceConfigs) string {
+	var script strings.Builder
+	script.WriteString(`for proto in tcp tcp6 udp udp6; do
+  [ -r /proc/net/$proto ] && sed "1d; s/^/$proto /" /proc/net/$proto
+done
+check_port() {
+  if command -v nc >/dev/null 2>&1; then
+    nc -z -w 2 "$1" "$2" >/dev/null 2>&1 && echo open || echo closed
+  elif command -v bash >/dev/null 2>&1; then
+    timeout 2 bash -c "echo > /dev/tcp/$1/$2" >/dev/null 2>&1 && echo open || echo closed
+  else
+    echo unknown
+  fi
+}
+resolve() {
+  if command -v getent >/dev/null 2>&1; then
+    getent hosts "$1" | awk '{print $1}'
+  else
+    nslookup "$1" 2>/dev/null | awk 'NR > 2 && /^Address/ {print $NF}'
+  fi
+}
+`)
+	for _, svc := range services {
+		name := shellQuote(svc.Name)
+		fmt.Fprintf(&script, "printf 'dns %%s %%s\\n' %s \"$(resolve %s | tr '\\n' ' ')\"\n", name, name)
+		for _, port := range svc.ExposedPorts {
+			fmt.Fprintf(&script, "printf 'port %%s %d %%s\\n' %s \"$(check_port %s %d)\"\n", port, name, name, port)
+		}
+	}
+	script.WriteString("true\n")
+	return script.String()
+}
+
+func parseNetstat(output string, services ServiceConfigs) *NetworkInfo {
+	info := &NetworkInfo{
+		Listening:   []Socket{},
+		Established: []Socket{},
+	}
+	endpoints := map[string]*EndpointCheck{}
+	for _, svc := range services {
+		info.Endpoints = append(info.Endpoints, EndpointCheck{Name: svc.Name})
+	}
+	for i := range info.Endpoints {
+		endpoints[info.Endpoints[i].Name] = &info.Endpoints[i]
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "dns":
+			if e, ok := endpoints[fields[1]]; ok {
+				if len(fields) == 2 {
+					e.Error = fmt.Sprintf("%s doesn't resolve from the environment", fields[1])
+					continue
+				}
+				e.Addresses = fields[2:]
+			}
+		case "port":
+			e, ok := endpoints[fields[1]]
+			if !ok || len(fields) < 4 {
+				continue
+			}
+			port, _ := strconv.Atoi(fields[2])
+			check := PortCheck{Port: port}
+			if fields[3] != "unknown" {
+				open := fields[3] == "open"
+				check.Open = &open
+			}
+			e.Ports = append(e.Ports, check)
+		default:
+			info.addSocket(fields)
+		}
+	}
+	return info
+}
+
+// addSocket records a line of /proc/net/{tcp,udp}[6], prefixed with the protocol
+func (info *NetworkInfo) addSocket(fields []string) {
+	// protocol, sl, local_address, rem_address, st
+	if len(fields) < 5 {
+		return
+	}
+	protocol := fields[0]
+	local, err := parseProcAddress(fields[2])
+	if err != nil {
+		return
+	}
+	remote, err := parseProcAddress(fields[3])
+	if err != nil {
+		return
+	}
+
+	socket := Socket{Protocol: protocol, LocalAddress: local}
+	if strings.HasPrefix(protocol, "udp") {
+		// UDP sockets are connectionless: unconnected ones receive datagrams from anyone
+		if fields[4] == "07" {
+			socket.State = "UNCONN"
+			info.Listening = append(info.Listening, socket)
+			return
+		}
+		socket.State = "ESTABLISHED"
+	} else {
+		socket.State = tcpStates[fields[4]]
+		if socket.State == "LISTEN" {
+			info.Listening = append(info.Listening, socket)
+			return
+		}
+		if socket.State != "ESTABLISHED" {
+			return
+		}
+	}
+	socket.RemoteAddress = remote
+	info.Established = append(info.Established, socket)
+}
+
+// parseProcAddress parses the hexadecimal addresses of /proc/net, e.g. 0100007F:1F90 for 127.0.0.1:8080.
+// Addresses are made of 32 bits words in host byte order.
+func parseProcAddress(s string) (string, error) {
+	hexIP, hexPort, ok := strings.Cut(s, ":")
+	if !ok {
+		return "", fmt.Errorf("invalid address %q", s)
+	}
+	raw, err := hex.DecodeString(hexIP)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return "", fmt.Errorf("invalid address %q", s)
+	}
+	port, err := strconv.ParseUint(hexPort, 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid port %q", s)
+	}
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], binary.LittleEndian.Uint32(raw[i:]))
+	}
+	return net.JoinHostPort(ip.String(), strconv.FormatUint(port, 10)), nil
+}
+
+func (env *Environment) hostNetstat() (*NetworkInfo, error) {
+	info := &NetworkInfo{
+		Listening:   []Socket{},
+		Established: []Socket{},
+	}
+	for _, protocol := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		f, err := os.Open("/proc/net/" + protocol)
+		if err != nil {
+			if protocol == "tcp" {
+				return nil, fmt.Errorf("listing sockets is only supported on Linux in host mode: %w", err)
+			}
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		// Skip the header
+		scanner.Scan()
+		for scanner.Scan() {
+			info.addSocket(append([]string{protocol}, strings.Fields(scanner.Text())...))
+		}
+		f.Close()
+	}
+
+	for _, bp := range env.State.BackgroundProcesses {
+		check := EndpointCheck{
+			Name:      fmt.Sprintf("process %d (%s)", bp.PID, bp.Command),
+			Addresses: []string{"127.0.0.1"},
+		}
+		for _, port := range bp.Ports {
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), portCheckTimeout)
+			open := err == nil
+			if open {
+				conn.Close()
+			}
+			check.Ports = append(check.Ports, PortCheck{Port: port, Open: &open})
+		}
+		info.Endpoints = append(info.Endpoints, check)
+	}
+	return info, nil
+}
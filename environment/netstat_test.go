@@ -0,0 +1,113 @@
+package environment
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProcAddress(t *testing.T) {
+	for raw, expected := range map[string]string{
+		"0100007F:1F90":                         "127.0.0.1:8080",
+		"00000000:0035":                         "0.0.0.0:53",
+		"00000000000000000000000001000000:0016": "[::1]:22",
+		"0000000000000000FFFF00000100007F:1F90": "127.0.0.1:8080",
+	} {
+		address, err := parseProcAddress(raw)
+		require.NoError(t, err, raw)
+		assert.Equal(t, expected, address, raw)
+	}
+	for _, invalid := range []string{"", "0100007F", "zz00007F:1F90", "0100007F:zz"} {
+		_, err := parseProcAddress(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestParseNetstat(t *testing.T) {
+	output := `tcp    0: 00000000:1538 00000000:0000 0A 00000000:00000000 00:00000000 00000000 0 0 1 1
+tcp    1: 0200A8C0:D2F0 0300A8C0:1538 01 00000000:00000000 00:00000000 00000000 0 0 2 1
+tcp    2: 0200A8C0:D2F2 0300A8C0:1538 06 00000000:00000000 00:00000000 00000000 0 0 3 1
+udp    3: 0B00007F:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000 0 0 4 2
+dns db 192.168.0.3
+port db 5432 closed
+dns cache
+port cache 6379 unknown
+`
+	info := parseNetstat(output, ServiceConfigs{
+		{Name: "db", ExposedPorts: []int{5432}},
+		{Name: "cache", ExposedPorts: []int{6379}},
+	})
+
+	assert.Equal(t, []Socket{
+		{Protocol: "tcp", LocalAddress: "0.0.0.0:5432", State: "LISTEN"},
+		{Protocol: "udp", LocalAddress: "127.0.0.11:53", State: "UNCONN"},
+	}, info.Listening)
+	assert.Equal(t, []Socket{
+		{Protocol: "tcp", LocalAddress: "192.168.0.2:54000", RemoteAddress: "192.168.0.3:5432", State: "ESTABLISHED"},
+	}, info.Established, "closing connections are left out")
+
+	require.Len(t, info.Endpoints, 2)
+	closed := false
+	assert.Equal(t, EndpointCheck{
+		Name:      "db",
+		Addresses: []string{"192.168.0.3"},
+		Ports:     []PortCheck{{Port: 5432, Open: &closed}},
+	}, info.Endpoints[0])
+	assert.Equal(t, EndpointCheck{
+		Name:  "cache",
+		Error: "cache doesn't resolve from the environment",
+		Ports: []PortCheck{{Port: 6379}},
+	}, info.Endpoints[1])
+}
+
+func TestNetstatScript(t *testing.T) {
+	if _, err := os.Stat("/proc/net/tcp"); err != nil {
+		t.Skip("requires /proc/net")
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	services := ServiceConfigs{{Name: "localhost", ExposedPorts: []int{port}}}
+	out, err := exec.Command("sh", "-c", netstatScript(services)).Output()
+	require.NoError(t, err)
+	info := parseNetstat(string(out), services)
+
+	assert.Contains(t, info.Listening, Socket{Protocol: "tcp", LocalAddress: "127.0.0.1:" + strconv.Itoa(port), State: "LISTEN"})
+	require.Len(t, info.Endpoints, 1)
+	assert.NotEmpty(t, info.Endpoints[0].Addresses)
+	require.Len(t, info.Endpoints[0].Ports, 1)
+	if open := info.Endpoints[0].Ports[0].Open; open != nil {
+		assert.True(t, *open)
+	}
+}
+
+func TestHostNetstat(t *testing.T) {
+	if _, err := os.Stat("/proc/net/tcp"); err != nil {
+		t.Skip("requires /proc/net")
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	env := newHostEnvironment(t, "env-netstat")
+	env.State.BackgroundProcesses = []BackgroundProcess{{PID: 42, Command: "npm start", Ports: []int{port}}}
+
+	info, err := env.Netstat(t.Context())
+	require.NoError(t, err)
+	assert.Contains(t, info.Listening, Socket{Protocol: "tcp", LocalAddress: "127.0.0.1:" + strconv.Itoa(port), State: "LISTEN"})
+	require.Len(t, info.Endpoints, 1)
+	assert.Equal(t, "process 42 (npm start)", info.Endpoints[0].Name)
+	assert.True(t, *info.Endpoints[0].Ports[0].Open)
+
+	listener.Close()
+	info, err = env.Netstat(t.Context())
+	require.NoError(t, err)
+	assert.False(t, *info.Endpoints[0].Ports[0].Open, "connection refused once the process stops listening")
+}
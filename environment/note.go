@@ -1,24 +1,94 @@
 package environment
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// maxInlineOutput is the size of a command output kept in the notes.
+	// Larger outputs are spilled to disk and only a preview of their head and tail is kept.
+	maxInlineOutput = 8 * 1024
+	// inlineOutputHead is the size of the head of a spilled output kept in its preview
+	inlineOutputHead = 2 * 1024
+	// spillRetention is how long spilled outputs are kept on disk
+	spillRetention = 24 * time.Hour
 )
 
+// spillDir holds the full outputs spilled from the notes, one directory per command
+var spillDir = filepath.Join(os.TempDir(), "container-use-outputs")
+
+// Kinds of activities
+const (
+	ActivityCommand  = "command"
+	ActivityFile     = "file"
+	ActivityConfig   = "config"
+	ActivitySnapshot = "snapshot"
+	ActivityEvent    = "event"
+)
+
+// Activity is an operation recorded in the notes, kept apart in a structured form so the history of the
+// environment can be queried
+type Activity struct {
+	Kind string    `json:"kind"`
+	Time time.Time `json:"time"`
+	// Summary is the first line of the note of the operation
+	Summary  string `json:"summary"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+	// Files are the files changed by file operations
+	Files []string `json:"files,omitempty"`
+}
+
 type Notes struct {
-	items []string
-	mu    sync.Mutex
+	items      []string
+	activities []Activity
+	commands   int
+	mu         sync.Mutex
 }
 
 func (n *Notes) Add(format string, a ...any) {
+	n.add(Activity{Kind: ActivityEvent}, fmt.Sprintf(format, a...))
+}
+
+// AddFileChange records an operation changing files
+func (n *Notes) AddFileChange(files []string, format string, a ...any) {
+	n.add(Activity{Kind: ActivityFile, Files: files}, fmt.Sprintf(format, a...))
+}
+
+// AddConfigChange records a change of the configuration
+func (n *Notes) AddConfigChange(format string, a ...any) {
+	n.add(Activity{Kind: ActivityConfig}, fmt.Sprintf(format, a...))
+}
+
+// AddSnapshot records a snapshot of the environment, like a checkpoint
+func (n *Notes) AddSnapshot(format string, a ...any) {
+	n.add(Activity{Kind: ActivitySnapshot}, fmt.Sprintf(format, a...))
+}
+
+func (n *Notes) add(activity Activity, note string) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
-	n.items = append(n.items, fmt.Sprintf(format, a...))
+	activity.Time = time.Now()
+	activity.Summary, _, _ = strings.Cut(strings.TrimSpace(note), "\n")
+	n.items = append(n.items, note)
+	n.activities = append(n.activities, activity)
 }
 
+// AddCommand records a command and its output.
+// Outputs larger than maxInlineOutput are spilled to disk, see CommandOutput.
 func (n *Notes) AddCommand(command string, exitCode int, stdout, stderr string) {
+	stdout, stderr = spillOutputs(stdout, stderr)
+
 	msg := fmt.Sprintf("$ %s", strings.TrimSpace(command))
 	if exitCode != 0 {
 		msg += fmt.Sprintf("\nexit %d", exitCode)
@@ -30,7 +100,19 @@ func (n *Notes) AddCommand(command string, exitCode int, stdout, stderr string)
 		msg += fmt.Sprintf("\nstderr: %s", stderr)
 	}
 
-	n.Add("%s", msg)
+	n.add(Activity{Kind: ActivityCommand, ExitCode: &exitCode}, msg)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.commands++
+}
+
+// Commands returns the number of commands recorded since the notes were last cleared
+func (n *Notes) Commands() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.commands
 }
 
 func (n *Notes) Clear() {
@@ -38,6 +120,8 @@ func (n *Notes) Clear() {
 	defer n.mu.Unlock()
 
 	n.items = []string{}
+	n.activities = nil
+	n.commands = 0
 }
 
 func (n *Notes) String() string {
@@ -53,6 +137,149 @@ func (n *Notes) Pop() string {
 
 	out := strings.TrimSpace(strings.Join(n.items, "\n"))
 	n.items = []string{}
+	n.commands = 0
 
 	return out
 }
+
+// PopActivities returns the activities recorded since they were last popped, and forgets them
+func (n *Notes) PopActivities() []Activity {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	activities := n.activities
+	n.activities = nil
+	return activities
+}
+
+// spillOutputs writes outputs too large for the notes to disk and returns their previews
+func spillOutputs(stdout, stderr string) (string, string) {
+	if len(stdout) <= maxInlineOutput && len(stderr) <= maxInlineOutput {
+		return stdout, stderr
+	}
+
+	pruneSpilledOutputs()
+	if err := os.MkdirAll(spillDir, 0700); err != nil {
+		slog.Warn("Failed to spill command output", "err", err)
+		return outputPreview(stdout, ""), outputPreview(stderr, "")
+	}
+	dir, err := os.MkdirTemp(spillDir, "")
+	if err != nil {
+		slog.Warn("Failed to spill command output", "err", err)
+		return outputPreview(stdout, ""), outputPreview(stderr, "")
+	}
+
+	id := filepath.Base(dir)
+	spill := func(stream, output string) string {
+		if len(output) <= maxInlineOutput {
+			return output
+		}
+		if err := writeCompressed(filepath.Join(dir, stream+".zst"), output); err != nil {
+			slog.Warn("Failed to spill command output", "err", err)
+			return outputPreview(output, "")
+		}
+		return outputPreview(output, id)
+	}
+	return spill("stdout", stdout), spill("stderr", stderr)
+}
+
+// writeCompressed writes an output compressed with zstd: build and test logs are highly redundant
+func writeCompressed(path, output string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	zw, err := zstd.NewWriter(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := io.WriteString(zw, output); err != nil {
+		zw.Close()
+		f.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// outputPreview keeps the head and tail of an output, referring to its spilled copy if any
+func outputPreview(output, id string) string {
+	if len(output) <= maxInlineOutput {
+		return output
+	}
+	head := output[:inlineOutputHead]
+	tail := output[len(output)-(maxInlineOutput-inlineOutputHead):]
+	marker := fmt.Sprintf("[%d bytes truncated]", len(output)-len(head)-len(tail))
+	if id != "" {
+		marker = fmt.Sprintf("[%d bytes truncated, full output %s]", len(output)-len(head)-len(tail), id)
+	}
+	return head + "\n" + marker + "\n" + tail
+}
+
+// pruneSpilledOutputs removes the outputs spilled longer than spillRetention ago
+func pruneSpilledOutputs() {
+	entries, err := os.ReadDir(spillDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err == nil && time.Since(info.ModTime()) > spillRetention {
+			os.RemoveAll(filepath.Join(spillDir, entry.Name()))
+		}
+	}
+}
+
+// CommandOutput reads up to limit bytes from offset of a command output spilled from the notes.
+// It returns the output read and the total size of the output.
+func CommandOutput(id, stream string, offset, limit int64) (string, int64, error) {
+	if id == "" || filepath.Base(id) != id || id == "." || id == ".." {
+		return "", 0, fmt.Errorf("invalid output ID %q", id)
+	}
+	if stream != "stdout" && stream != "stderr" {
+		return "", 0, fmt.Errorf("invalid output stream %q: expected stdout or stderr", stream)
+	}
+	if offset < 0 {
+		return "", 0, fmt.Errorf("offset %d out of range", offset)
+	}
+
+	f, err := os.Open(filepath.Join(spillDir, id, stream+".zst"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", 0, fmt.Errorf("%s of output %s not found: outputs are kept for %s", stream, id, spillRetention)
+		}
+		return "", 0, err
+	}
+	defer f.Close()
+
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read %s of output %s: %w", stream, id, err)
+	}
+	defer zr.Close()
+	// The size is only known once the output is decompressed: skip to offset, read, then count the rest
+	skipped, err := io.CopyN(io.Discard, zr, offset)
+	if errors.Is(err, io.EOF) {
+		return "", 0, fmt.Errorf("offset %d out of range: the output is %d bytes", offset, skipped)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	var r io.Reader = zr
+	if limit > 0 {
+		r = io.LimitReader(zr, limit)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", 0, err
+	}
+	rest, err := io.Copy(io.Discard, zr)
+	if err != nil {
+		return "", 0, err
+	}
+	return string(data), offset + int64(len(data)) + rest, nil
+}
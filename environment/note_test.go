@@ -0,0 +1,95 @@
+package environment
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotesSpillLargeOutputs(t *testing.T) {
+	previous := spillDir
+	spillDir = t.TempDir()
+	t.Cleanup(func() { spillDir = previous })
+
+	notes := &Notes{}
+	notes.AddCommand("echo small", 0, "small\n", "")
+	assert.Equal(t, "$ echo small\nsmall", notes.Pop())
+	entries, err := os.ReadDir(spillDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "small outputs stay inline")
+
+	large := strings.Repeat("a", inlineOutputHead) + strings.Repeat("b", 20000) + strings.Repeat("c", maxInlineOutput-inlineOutputHead)
+	notes.AddCommand("make", 2, large, "warning\n")
+	note := notes.Pop()
+	assert.Less(t, len(note), maxInlineOutput+200)
+	assert.Contains(t, note, strings.Repeat("a", inlineOutputHead)+"\n[20000 bytes truncated, full output ")
+	assert.Contains(t, note, "]\n"+strings.Repeat("c", maxInlineOutput-inlineOutputHead))
+	assert.Contains(t, note, "stderr: warning")
+
+	match := regexp.MustCompile(`full output (\S+)\]`).FindStringSubmatch(note)
+	require.Len(t, match, 2)
+	id := match[1]
+
+	output, size, err := CommandOutput(id, "stdout", 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, large, output)
+	assert.EqualValues(t, len(large), size)
+
+	output, _, err = CommandOutput(id, "stdout", inlineOutputHead, 3)
+	require.NoError(t, err)
+	assert.Equal(t, "bbb", output)
+
+	_, _, err = CommandOutput(id, "stderr", 0, 0)
+	assert.Error(t, err, "small outputs aren't spilled")
+	_, _, err = CommandOutput(id, "stdout", int64(len(large))+1, 0)
+	assert.Error(t, err)
+	_, _, err = CommandOutput("../"+id, "stdout", 0, 0)
+	assert.Error(t, err)
+	_, _, err = CommandOutput(id, "logs", 0, 0)
+	assert.Error(t, err)
+
+	compressed, err := os.Stat(filepath.Join(spillDir, id, "stdout.zst"))
+	require.NoError(t, err)
+	assert.Less(t, compressed.Size(), int64(len(large)/10), "spilled outputs are compressed")
+
+	t.Run("prune", func(t *testing.T) {
+		old := time.Now().Add(-2 * spillRetention)
+		require.NoError(t, os.Chtimes(filepath.Join(spillDir, id), old, old))
+
+		notes.AddCommand("make", 0, large, "")
+		_, _, err := CommandOutput(id, "stdout", 0, 0)
+		assert.ErrorContains(t, err, "not found")
+	})
+}
+
+func TestNotesActivities(t *testing.T) {
+	notes := &Notes{}
+	notes.AddCommand("go test ./...", 1, "FAIL\n", "")
+	notes.AddFileChange([]string{"main.go"}, "Write %s", "main.go")
+	notes.AddConfigChange("Update config: %s", "base_image")
+	notes.AddSnapshot("Checkpoint to %s", "ttl.sh/env:1h")
+	notes.Add("Start preview\n%s", "http://localhost:8080")
+
+	assert.Contains(t, notes.Pop(), "Write main.go")
+	activities := notes.PopActivities()
+	require.Len(t, activities, 5)
+	assert.Equal(t, ActivityCommand, activities[0].Kind)
+	assert.Equal(t, "$ go test ./...", activities[0].Summary)
+	require.NotNil(t, activities[0].ExitCode)
+	assert.Equal(t, 1, *activities[0].ExitCode)
+	assert.Equal(t, ActivityFile, activities[1].Kind)
+	assert.Equal(t, []string{"main.go"}, activities[1].Files)
+	assert.Equal(t, ActivityConfig, activities[2].Kind)
+	assert.Equal(t, ActivitySnapshot, activities[3].Kind)
+	assert.Equal(t, ActivityEvent, activities[4].Kind)
+	assert.Equal(t, "Start preview", activities[4].Summary)
+	assert.False(t, activities[4].Time.IsZero())
+
+	assert.Empty(t, notes.PopActivities())
+}
@@ -0,0 +1,33 @@
+package environment
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrOffline is returned by operations needing network access while offline
+var ErrOffline = errors.New("container-use is offline")
+
+// offline is process wide: the MCP server and CLI commands are either online or not
+var offline atomic.Bool
+
+// SetOffline enables or disables offline mode.
+// Offline, image pulls and publishes are refused, but commands still run in environments
+// whose containers are in the local engine cache, and files and metadata remain available.
+func SetOffline(enabled bool) {
+	offline.Store(enabled)
+}
+
+// IsOffline reports whether offline mode is enabled
+func IsOffline() bool {
+	return offline.Load()
+}
+
+// requireNetwork fails operations needing network access while offline
+func requireNetwork(operation string) error {
+	if IsOffline() {
+		return fmt.Errorf("%s requires network access: %w", operation, ErrOffline)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOffline(t *testing.T) {
+	t.Cleanup(func() { SetOffline(false) })
+
+	assert.NoError(t, requireNetwork("pulling base image alpine"))
+
+	SetOffline(true)
+	assert.True(t, IsOffline())
+	err := requireNetwork("pulling base image alpine")
+	assert.ErrorIs(t, err, ErrOffline)
+	assert.ErrorContains(t, err, "pulling base image alpine requires network access")
+}
@@ -0,0 +1,83 @@
+package environment
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ConfigOverlay tweaks the configuration of a single environment, on top of the configuration it would otherwise get.
+// Base image and workdir replace the configured ones, env vars are set or unset one by one, and commands and ports are
+// added after the configured ones. Secrets, resource limits, caches and services can't be overridden: they stay
+// under the user's control.
+type ConfigOverlay struct {
+	BaseImage       string   `json:"base_image,omitempty"`
+	Workdir         string   `json:"workdir,omitempty"`
+	SetupCommands   []string `json:"setup_commands,omitempty"`
+	InstallCommands []string `json:"install_commands,omitempty"`
+	// Env are the env vars to set, as KEY=VALUE
+	Env      KVList   `json:"env,omitempty"`
+	UnsetEnv []string `json:"unset_env,omitempty"`
+	Ports    []int    `json:"ports,omitempty"`
+}
+
+// ParseConfigOverlay parses an overlay from JSON, rejecting the fields it can't override
+func ParseConfigOverlay(data []byte) (*ConfigOverlay, error) {
+	overlay := &ConfigOverlay{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(overlay); err != nil {
+		return nil, fmt.Errorf("invalid overrides: %w (only base_image, workdir, setup_commands, install_commands, env, unset_env and ports can be overridden)", err)
+	}
+	if err := overlay.Validate(); err != nil {
+		return nil, err
+	}
+	return overlay, nil
+}
+
+// Validate checks the overlay
+func (overlay *ConfigOverlay) Validate() error {
+	if strings.EqualFold(overlay.BaseImage, "host") {
+		return fmt.Errorf("invalid overrides: only the user can run environments on the host")
+	}
+	if overlay.Workdir != "" && !strings.HasPrefix(overlay.Workdir, "/") {
+		return fmt.Errorf("invalid overrides: workdir %q must be absolute", overlay.Workdir)
+	}
+	for _, item := range overlay.Env {
+		if key, _, ok := strings.Cut(item, "="); !ok || key == "" {
+			return fmt.Errorf("invalid overrides: env var %q must be KEY=VALUE", item)
+		}
+	}
+	for _, port := range overlay.Ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid overrides: invalid port %d", port)
+		}
+	}
+	return nil
+}
+
+// ApplyOverlay applies an overlay on top of the configuration
+func (config *EnvironmentConfig) ApplyOverlay(overlay *ConfigOverlay) {
+	if overlay.BaseImage != "" {
+		config.BaseImage = overlay.BaseImage
+	}
+	if overlay.Workdir != "" {
+		config.Workdir = overlay.Workdir
+	}
+	config.SetupCommands = append(config.SetupCommands, overlay.SetupCommands...)
+	config.InstallCommands = append(config.InstallCommands, overlay.InstallCommands...)
+	for _, key := range overlay.UnsetEnv {
+		config.Env.Unset(key)
+	}
+	for _, item := range overlay.Env {
+		key, value, _ := strings.Cut(item, "=")
+		config.Env.Set(key, value)
+	}
+	for _, port := range overlay.Ports {
+		if !slices.Contains(config.Ports, port) {
+			config.Ports = append(config.Ports, port)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfigOverlay(t *testing.T) {
+	overlay, err := ParseConfigOverlay([]byte(`{"env": ["LOG_LEVEL=debug"], "ports": [8080]}`))
+	require.NoError(t, err)
+	assert.Equal(t, &ConfigOverlay{Env: KVList{"LOG_LEVEL=debug"}, Ports: []int{8080}}, overlay)
+
+	_, err = ParseConfigOverlay([]byte(`{"secrets": ["TOKEN=env://TOKEN"]}`))
+	assert.ErrorContains(t, err, "only base_image")
+	_, err = ParseConfigOverlay([]byte(`{"base_image": "HOST"}`))
+	assert.Error(t, err, "agents can't escape the container")
+	_, err = ParseConfigOverlay([]byte(`{"env": ["LOG_LEVEL"]}`))
+	assert.Error(t, err)
+	_, err = ParseConfigOverlay([]byte(`{"workdir": "src"}`))
+	assert.Error(t, err)
+}
+
+func TestApplyOverlay(t *testing.T) {
+	config := DefaultConfig()
+	config.SetupCommands = []string{"apt-get install -y make"}
+	config.Env = KVList{"LOG_LEVEL=info", "DEBUG=1", "PORT=80"}
+	config.Secrets = KVList{"TOKEN=env://TOKEN"}
+	config.Ports = []int{80}
+
+	config.ApplyOverlay(&ConfigOverlay{
+		BaseImage:     "golang:1.24",
+		SetupCommands: []string{"go install gotest.tools/gotestsum@latest"},
+		Env:           KVList{"LOG_LEVEL=debug", "EXTRA=a=b"},
+		UnsetEnv:      []string{"DEBUG"},
+		Ports:         []int{80, 8080},
+	})
+
+	assert.Equal(t, "golang:1.24", config.BaseImage)
+	assert.Equal(t, "/workdir", config.Workdir, "unset fields keep the configured values")
+	assert.Equal(t, []string{"apt-get install -y make", "go install gotest.tools/gotestsum@latest"}, config.SetupCommands)
+	assert.Equal(t, KVList{"PORT=80", "LOG_LEVEL=debug", "EXTRA=a=b"}, config.Env)
+	assert.Equal(t, KVList{"TOKEN=env://TOKEN"}, config.Secrets)
+	assert.Equal(t, []int{80, 8080}, config.Ports)
+}
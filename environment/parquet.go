@@ -0,0 +1,350 @@
+package environment
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Parquet files end with their metadata, a thrift (compact protocol) encoded FileMetaData:
+// https://github.com/apache/parquet-format/blob/master/src/main/thrift/parquet.thrift
+//
+// Only the schema and the row count are decoded: reading rows would require
+// implementing the page encodings and compression codecs.
+
+const parquetMagic = "PAR1"
+
+// maxParquetMetadataSize guards against allocating huge buffers on corrupted files
+const maxParquetMetadataSize = 64 * 1024 * 1024
+
+func previewParquet(r io.ReaderAt, size int64) (*DataPreview, error) {
+	if size < 12 {
+		return nil, fmt.Errorf("not a parquet file: too small")
+	}
+	footer := make([]byte, 8)
+	if _, err := r.ReadAt(footer, size-8); err != nil {
+		return nil, err
+	}
+	if string(footer[4:]) != parquetMagic {
+		return nil, fmt.Errorf("not a parquet file: missing magic number")
+	}
+	metadataSize := int64(binary.LittleEndian.Uint32(footer[:4]))
+	if metadataSize > maxParquetMetadataSize || metadataSize > size-12 {
+		return nil, fmt.Errorf("invalid parquet metadata size %d", metadataSize)
+	}
+	metadata := make([]byte, metadataSize)
+	if _, err := r.ReadAt(metadata, size-8-metadataSize); err != nil {
+		return nil, err
+	}
+
+	schema, rowCount, err := parseParquetMetadata(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse parquet metadata: %w", err)
+	}
+	if len(schema) == 0 {
+		return nil, fmt.Errorf("failed to parse parquet metadata: empty schema")
+	}
+
+	preview := &DataPreview{
+		Format:   DataFormatParquet,
+		Columns:  []DataColumn{},
+		Rows:     [][]any{},
+		RowCount: rowCount,
+		Note:     "rows of parquet files are not decoded, only the schema and row count",
+	}
+	// The first element is the root of the schema tree, which is flattened into dotted column names
+	var walk func(i int, prefix []string) (int, error)
+	walk = func(i int, prefix []string) (int, error) {
+		if i >= len(schema) {
+			return 0, fmt.Errorf("truncated schema")
+		}
+		element := schema[i]
+		path := append(slices.Clone(prefix), element.name)
+		next := i + 1
+		if element.numChildren == 0 {
+			preview.Columns = append(preview.Columns, DataColumn{Name: strings.Join(path, "."), Type: element.typeName()})
+			return next, nil
+		}
+		for range element.numChildren {
+			var err error
+			if next, err = walk(next, path); err != nil {
+				return 0, err
+			}
+		}
+		return next, nil
+	}
+	next := 1
+	for range schema[0].numChildren {
+		if next, err = walk(next, nil); err != nil {
+			return nil, err
+		}
+	}
+	return preview, nil
+}
+
+type parquetSchemaElement struct {
+	physicalType  int32
+	convertedType int32
+	// logicalType is the field ID of the logical type union, 0 if not set
+	logicalType int16
+	name        string
+	numChildren int32
+}
+
+var (
+	parquetPhysicalTypes = []string{"boolean", "int32", "int64", "int96", "float", "double", "binary", "fixed_len_byte_array"}
+	parquetLogicalTypes  = map[int16]string{1: "string", 2: "map", 3: "list", 4: "enum", 5: "decimal", 6: "date", 7: "time", 8: "timestamp", 10: "integer", 12: "json", 13: "bson", 14: "uuid", 15: "float16"}
+	// parquetConvertedTypes are the legacy logical types
+	parquetConvertedTypes = map[int32]string{0: "string", 1: "map", 3: "list", 4: "enum", 5: "decimal", 6: "date", 7: "time", 8: "time", 9: "timestamp", 10: "timestamp", 19: "json", 20: "bson"}
+)
+
+func (e parquetSchemaElement) typeName() string {
+	if name, ok := parquetLogicalTypes[e.logicalType]; ok {
+		return name
+	}
+	if name, ok := parquetConvertedTypes[e.convertedType]; ok {
+		return name
+	}
+	if e.physicalType >= 0 && int(e.physicalType) < len(parquetPhysicalTypes) {
+		return parquetPhysicalTypes[e.physicalType]
+	}
+	return "unknown"
+}
+
+func parseParquetMetadata(data []byte) ([]parquetSchemaElement, int64, error) {
+	tr := &thriftCompactReader{r: bufio.NewReader(bytes.NewReader(data))}
+	var (
+		schema   []parquetSchemaElement
+		rowCount int64
+	)
+	err := tr.readStruct(func(id int16, typ byte) error {
+		switch {
+		case id == 2 && typ == thriftList:
+			elemType, size, err := tr.readListHeader()
+			if err != nil {
+				return err
+			}
+			if elemType != thriftStruct {
+				return fmt.Errorf("unexpected schema element type %d", elemType)
+			}
+			for range size {
+				element, err := readParquetSchemaElement(tr)
+				if err != nil {
+					return err
+				}
+				schema = append(schema, element)
+			}
+			return nil
+		case id == 3 && typ == thriftI64:
+			var err error
+			rowCount, err = tr.readInt()
+			return err
+		}
+		return tr.skip(typ)
+	})
+	return schema, rowCount, err
+}
+
+func readParquetSchemaElement(tr *thriftCompactReader) (parquetSchemaElement, error) {
+	element := parquetSchemaElement{physicalType: -1, convertedType: -1}
+	err := tr.readStruct(func(id int16, typ byte) error {
+		var err error
+		var v int64
+		switch {
+		case id == 1 && typ == thriftI32:
+			v, err = tr.readInt()
+			element.physicalType = int32(v)
+		case id == 4 && typ == thriftBinary:
+			element.name, err = tr.readString()
+		case id == 5 && typ == thriftI32:
+			v, err = tr.readInt()
+			element.numChildren = int32(v)
+		case id == 6 && typ == thriftI32:
+			v, err = tr.readInt()
+			element.convertedType = int32(v)
+		case id == 10 && typ == thriftStruct:
+			// LogicalType is a union: the ID of its only field is the type
+			err = tr.readStruct(func(id int16, typ byte) error {
+				element.logicalType = id
+				return tr.skip(typ)
+			})
+		default:
+			err = tr.skip(typ)
+		}
+		return err
+	})
+	return element, err
+}
+
+// Thrift compact protocol types
+const (
+	thriftBoolTrue  = 1
+	thriftBoolFalse = 2
+	thriftByte      = 3
+	thriftI16       = 4
+	thriftI32       = 5
+	thriftI64       = 6
+	thriftDouble    = 7
+	thriftBinary    = 8
+	thriftList      = 9
+	thriftSet       = 10
+	thriftMap       = 11
+	thriftStruct    = 12
+)
+
+// maxThriftDepth bounds the nesting of skipped values, so corrupted files can't overflow the stack
+const maxThriftDepth = 64
+
+type thriftCompactReader struct {
+	r     *bufio.Reader
+	depth int
+}
+
+// readStruct calls fn for each field of a struct until its stop field
+func (tr *thriftCompactReader) readStruct(fn func(id int16, typ byte) error) error {
+	tr.depth++
+	defer func() { tr.depth-- }()
+	if tr.depth > maxThriftDepth {
+		return errors.New("thrift structure nested too deeply")
+	}
+
+	var lastID int16
+	for {
+		header, err := tr.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if header == 0 {
+			return nil
+		}
+		typ := header & 0x0f
+		id := lastID + int16(header>>4)
+		if header>>4 == 0 {
+			v, err := tr.readInt()
+			if err != nil {
+				return err
+			}
+			id = int16(v)
+		}
+		if err := fn(id, typ); err != nil {
+			return err
+		}
+		lastID = id
+	}
+}
+
+func (tr *thriftCompactReader) readListHeader() (byte, int, error) {
+	header, err := tr.r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	size := int(header >> 4)
+	if size == 15 {
+		v, err := binary.ReadUvarint(tr.r)
+		if err != nil {
+			return 0, 0, err
+		}
+		if v > math.MaxInt32 {
+			return 0, 0, fmt.Errorf("invalid list size %d", v)
+		}
+		size = int(v)
+	}
+	return header & 0x0f, size, nil
+}
+
+// readInt reads a zigzag encoded i16, i32 or i64
+func (tr *thriftCompactReader) readInt() (int64, error) {
+	return binary.ReadVarint(tr.r)
+}
+
+func (tr *thriftCompactReader) readString() (string, error) {
+	size, err := binary.ReadUvarint(tr.r)
+	if err != nil {
+		return "", err
+	}
+	if size > maxParquetMetadataSize {
+		return "", fmt.Errorf("invalid string size %d", size)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(tr.r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func (tr *thriftCompactReader) skip(typ byte) error {
+	switch typ {
+	case thriftBoolTrue, thriftBoolFalse:
+		// Booleans fields are encoded in the field header
+		return nil
+	case thriftByte:
+		_, err := tr.r.ReadByte()
+		return err
+	case thriftI16, thriftI32, thriftI64:
+		_, err := tr.readInt()
+		return err
+	case thriftDouble:
+		_, err := tr.r.Discard(8)
+		return err
+	case thriftBinary:
+		_, err := tr.readString()
+		return err
+	case thriftList, thriftSet:
+		elemType, size, err := tr.readListHeader()
+		if err != nil {
+			return err
+		}
+		return tr.skipElements(elemType, size)
+	case thriftMap:
+		size, err := binary.ReadUvarint(tr.r)
+		if err != nil || size == 0 {
+			return err
+		}
+		if size > math.MaxInt32 {
+			return fmt.Errorf("invalid map size %d", size)
+		}
+		types, err := tr.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		for range size {
+			if err := tr.skipElements(types>>4, 1); err != nil {
+				return err
+			}
+			if err := tr.skipElements(types&0x0f, 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	case thriftStruct:
+		return tr.readStruct(func(_ int16, typ byte) error {
+			return tr.skip(typ)
+		})
+	}
+	return fmt.Errorf("unknown thrift type %d", typ)
+}
+
+func (tr *thriftCompactReader) skipElements(typ byte, count int) error {
+	tr.depth++
+	defer func() { tr.depth-- }()
+	if tr.depth > maxThriftDepth {
+		return errors.New("thrift structure nested too deeply")
+	}
+
+	for range count {
+		// Unlike fields, booleans elements are encoded as a byte
+		if typ == thriftBoolTrue || typ == thriftBoolFalse {
+			typ = thriftByte
+		}
+		if err := tr.skip(typ); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,439 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+const devNull = "/dev/null"
+
+// FilePatch is the diff of a file in a unified diff
+type FilePatch struct {
+	// OldPath is empty for created files
+	OldPath string
+	// NewPath is empty for deleted files
+	NewPath string
+	Hunks   []PatchHunk
+}
+
+// Path is the file the patch changes
+func (p FilePatch) Path() string {
+	if p.NewPath != "" {
+		return p.NewPath
+	}
+	return p.OldPath
+}
+
+// PatchHunk is a hunk of a file diff. Its line counts are those of its lines, whatever its header says:
+// hand-written diffs often get them wrong.
+type PatchHunk struct {
+	OldStart int
+	NewStart int
+	// Lines are prefixed with ' ', '-' or '+', or are "\ No newline at end of file" markers
+	Lines []string
+}
+
+// counts returns the number of lines of the hunk in the old and new file
+func (h PatchHunk) counts() (int, int) {
+	oldCount, newCount := 0, 0
+	for _, line := range h.Lines {
+		switch line[0] {
+		case ' ':
+			oldCount++
+			newCount++
+		case '-':
+			oldCount++
+		case '+':
+			newCount++
+		}
+	}
+	return oldCount, newCount
+}
+
+// split returns the lines the hunk expects in the file, and the ones it replaces them with
+func (h PatchHunk) split() ([]string, []string) {
+	oldLines, newLines := []string{}, []string{}
+	for _, line := range h.Lines {
+		switch line[0] {
+		case ' ':
+			oldLines = append(oldLines, line[1:])
+			newLines = append(newLines, line[1:])
+		case '-':
+			oldLines = append(oldLines, line[1:])
+		case '+':
+			newLines = append(newLines, line[1:])
+		}
+	}
+	return oldLines, newLines
+}
+
+// PatchFileResult is the outcome of applying the diff of a file
+type PatchFileResult struct {
+	File    string `json:"file"`
+	Applied bool   `json:"applied"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+	Error   string `json:"error,omitempty"`
+}
+
+var patchHunkHeaderRe = regexp.MustCompile(`^@@ -(\d+)(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+
+// ParsePatch splits a unified diff, as produced by git diff or diff -u, into the diffs of its files
+func ParsePatch(diff string) ([]FilePatch, error) {
+	lines := strings.Split(strings.ReplaceAll(diff, "\r\n", "\n"), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	patches := []FilePatch{}
+	var current *FilePatch
+	var hunk *PatchHunk
+	closeHunk := func() {
+		if hunk != nil {
+			current.Hunks = append(current.Hunks, *hunk)
+			hunk = nil
+		}
+	}
+	closeFile := func() {
+		closeHunk()
+		if current != nil {
+			patches = append(patches, *current)
+			current = nil
+		}
+	}
+	// fileHeader tells whether the line starts the ---/+++ header of a file
+	fileHeader := func(i int) bool {
+		return strings.HasPrefix(lines[i], "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ")
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			closeFile()
+			current = &FilePatch{}
+			if oldPath, newPath, ok := parseGitDiffHeader(line); ok {
+				current.OldPath, current.NewPath = oldPath, newPath
+			}
+		case fileHeader(i):
+			// Files without a diff --git header start at their ---/+++ header
+			if current == nil || hunk != nil || len(current.Hunks) > 0 {
+				closeFile()
+				current = &FilePatch{}
+			}
+			current.OldPath = patchPath(strings.TrimPrefix(line, "--- "))
+			current.NewPath = patchPath(strings.TrimPrefix(lines[i+1], "+++ "))
+			i++
+		case strings.HasPrefix(line, "@@"):
+			if current == nil {
+				return nil, fmt.Errorf("line %d: hunk outside of a file diff", i+1)
+			}
+			m := patchHunkHeaderRe.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("line %d: invalid hunk header %q", i+1, line)
+			}
+			closeHunk()
+			oldStart, _ := strconv.Atoi(m[1])
+			newStart, _ := strconv.Atoi(m[2])
+			hunk = &PatchHunk{OldStart: oldStart, NewStart: newStart}
+		case hunk != nil && line == "":
+			// Blank context lines lose their space in editors and chats, unless the blank line separates files
+			if i+1 < len(lines) && !strings.HasPrefix(lines[i+1], "diff --git ") && !strings.HasPrefix(lines[i+1], "@@") && !fileHeader(i+1) {
+				hunk.Lines = append(hunk.Lines, " ")
+			} else {
+				closeHunk()
+			}
+		case hunk != nil && strings.ContainsAny(line[:1], " -+\\"):
+			hunk.Lines = append(hunk.Lines, line)
+		case strings.HasPrefix(line, "Binary files ") || strings.HasPrefix(line, "GIT binary patch"):
+			return nil, fmt.Errorf("line %d: binary diffs are not supported", i+1)
+		case current != nil && strings.HasPrefix(line, "rename from "), current != nil && strings.HasPrefix(line, "copy from "):
+			return nil, fmt.Errorf("line %d: renames and copies are not supported, delete and create the files instead", i+1)
+		default:
+			// Extended headers (index, modes) and text around the diff
+			closeHunk()
+		}
+	}
+	closeFile()
+
+	if len(patches) == 0 {
+		return nil, fmt.Errorf("no file diffs found")
+	}
+	for _, p := range patches {
+		if p.OldPath != "" && p.NewPath != "" && p.OldPath != p.NewPath {
+			return nil, fmt.Errorf("%s: renames are not supported, delete and create the files instead", p.OldPath)
+		}
+		if p.Path() == "" {
+			return nil, fmt.Errorf("file diff without a path")
+		}
+		for i, h := range p.Hunks {
+			if oldCount, newCount := h.counts(); oldCount == 0 && newCount == 0 {
+				return nil, fmt.Errorf("%s: hunk %d is empty", p.Path(), i+1)
+			}
+		}
+	}
+	return patches, nil
+}
+
+// parseGitDiffHeader returns the paths of a "diff --git a/old b/new" line, when they have no spaces
+func parseGitDiffHeader(line string) (string, string, bool) {
+	fields := strings.Fields(strings.TrimPrefix(line, "diff --git "))
+	if len(fields) != 2 {
+		return "", "", false
+	}
+	return patchPath(fields[0]), patchPath(fields[1]), true
+}
+
+// patchPath is the path of a ---/+++ header, without its a/ or b/ prefix and timestamp. /dev/null is empty.
+func patchPath(header string) string {
+	path, _, _ := strings.Cut(header, "\t")
+	path = strings.TrimSpace(path)
+	if path == devNull {
+		return ""
+	}
+	if rest, ok := strings.CutPrefix(path, "a/"); ok {
+		return rest
+	}
+	if rest, ok := strings.CutPrefix(path, "b/"); ok {
+		return rest
+	}
+	return path
+}
+
+// applyHunks applies the hunks of a file diff to its contents. Hunks are looked for at the line their header says,
+// then further and further from it, as the file may have changed since the diff was made.
+func applyHunks(contents string, hunks []PatchHunk) (string, error) {
+	lines := []string{}
+	if contents != "" {
+		lines = strings.Split(strings.TrimSuffix(contents, "\n"), "\n")
+	}
+	noFinalNewline := contents != "" && !strings.HasSuffix(contents, "\n")
+
+	// offset is how much earlier hunks moved the lines of the file
+	offset := 0
+	// minLine is the first line later hunks can change
+	minLine := 0
+	for i, h := range hunks {
+		oldLines, newLines := h.split()
+		expected := max(h.OldStart-1, 0) + offset
+		if len(oldLines) == 0 {
+			// Pure insertions have their start on the line before
+			expected = min(h.OldStart+offset, len(lines))
+		}
+		at := findLines(lines, oldLines, expected, minLine)
+		if at < 0 {
+			oldCount, _ := h.counts()
+			return "", fmt.Errorf("hunk %d (@@ -%d,%d) doesn't apply: its context and removed lines were not found", i+1, h.OldStart, oldCount)
+		}
+		lines = append(lines[:at], append(newLines, lines[at+len(oldLines):]...)...)
+		offset += len(newLines) - len(oldLines)
+		minLine = at + len(newLines)
+
+		for j, line := range h.Lines {
+			if strings.HasPrefix(line, `\`) && j > 0 {
+				// The marker applies to the line before it: removed lines had no newline, added lines don't either
+				noFinalNewline = h.Lines[j-1][0] != '-'
+			}
+		}
+	}
+
+	if len(lines) == 0 {
+		return "", nil
+	}
+	result := strings.Join(lines, "\n")
+	if !noFinalNewline {
+		result += "\n"
+	}
+	return result, nil
+}
+
+// findLines returns the index of the lines in file closest to expected, not before minLine, or -1
+func findLines(file, lines []string, expected, minLine int) int {
+	matches := func(at int) bool {
+		if at < minLine || at+len(lines) > len(file) {
+			return false
+		}
+		for i, line := range lines {
+			if file[at+i] != line {
+				return false
+			}
+		}
+		return true
+	}
+	for distance := 0; distance <= len(file); distance++ {
+		if matches(expected - distance) {
+			return expected - distance
+		}
+		if distance > 0 && matches(expected+distance) {
+			return expected + distance
+		}
+	}
+	return -1
+}
+
+// format writes the file diff back in git's format, with the line counts of its hunks
+func (p FilePatch) format() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "diff --git a/%s b/%s\n", p.Path(), p.Path())
+	oldHeader, newHeader := devNull, devNull
+	switch {
+	case p.OldPath == "":
+		b.WriteString("new file mode 100644\n")
+		newHeader = "b/" + p.NewPath
+	case p.NewPath == "":
+		b.WriteString("deleted file mode 100644\n")
+		oldHeader = "a/" + p.OldPath
+	default:
+		oldHeader, newHeader = "a/"+p.OldPath, "b/"+p.NewPath
+	}
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldHeader, newHeader)
+	for _, h := range p.Hunks {
+		oldCount, newCount := h.counts()
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", h.OldStart, oldCount, h.NewStart, newCount)
+		for _, line := range h.Lines {
+			b.WriteString(line + "\n")
+		}
+	}
+	return b.String()
+}
+
+// ApplyPatch applies a unified diff changing several files. The diff of each file is checked against the current
+// contents: the files it applies to are changed, the others are reported as failed and left untouched.
+func (env *Environment) ApplyPatch(ctx context.Context, explanation, diff string) ([]PatchFileResult, error) {
+	patches, err := ParsePatch(diff)
+	if err != nil {
+		return nil, fmt.Errorf("invalid patch: %w", err)
+	}
+
+	results := make([]PatchFileResult, len(patches))
+	applied := []FilePatch{}
+	contents := map[string]string{}
+	for i, p := range patches {
+		results[i].File = p.Path()
+		for _, h := range p.Hunks {
+			for _, line := range h.Lines {
+				switch line[0] {
+				case '+':
+					results[i].Added++
+				case '-':
+					results[i].Removed++
+				}
+			}
+		}
+
+		current, exists, err := env.readPatchedFile(ctx, p.Path())
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		switch {
+		case p.OldPath == "" && exists:
+			results[i].Error = "the diff creates the file, but it already exists"
+			continue
+		case p.OldPath != "" && !exists:
+			results[i].Error = "file not found"
+			continue
+		}
+		updated, err := applyHunks(current, p.Hunks)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		if p.NewPath == "" && updated != "" {
+			results[i].Error = "the diff deletes the file, but doesn't remove all of its lines"
+			continue
+		}
+		results[i].Applied = true
+		applied = append(applied, p)
+		contents[p.Path()] = updated
+	}
+	if len(applied) == 0 {
+		return results, nil
+	}
+
+	var combined strings.Builder
+	for _, p := range applied {
+		combined.WriteString(p.format())
+	}
+	if err := env.checkPatchSecrets(combined.String()); err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	for _, p := range applied {
+		files = append(files, p.Path())
+	}
+	if env.IsHost() {
+		// Check every file before changing any, so patches are applied entirely or not at all
+		paths := map[string]string{}
+		for _, p := range applied {
+			path, err := env.hostPath(p.Path(), true)
+			if err != nil {
+				return nil, err
+			}
+			paths[p.Path()] = path
+		}
+		for _, p := range applied {
+			path := paths[p.Path()]
+			if p.NewPath == "" {
+				if err := os.Remove(path); err != nil {
+					return nil, fmt.Errorf("failed deleting file: %w", err)
+				}
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return nil, fmt.Errorf("failed to create directories: %w", err)
+			}
+			if err := os.WriteFile(path, []byte(contents[p.Path()]), 0644); err != nil {
+				return nil, fmt.Errorf("failed writing file: %w", err)
+			}
+		}
+	} else {
+		ctr := env.container()
+		if err := env.apply(ctx, ctr.WithDirectory(".", ctr.Directory(".").WithPatch(combined.String()))); err != nil {
+			return nil, fmt.Errorf("failed applying patch, skipping git propagation: %w", err)
+		}
+	}
+	env.Notes.AddFileChange(files, "Patch %s", strings.Join(files, ", "))
+	return results, nil
+}
+
+// readPatchedFile reads a file a patch changes, and tells whether it exists
+func (env *Environment) readPatchedFile(ctx context.Context, file string) (string, bool, error) {
+	if env.IsHost() {
+		path, err := env.hostPath(file, true)
+		if err != nil {
+			return "", false, err
+		}
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return string(data), err == nil, err
+	}
+	entries, err := cachedRead(env, "dir:"+filepath.Dir(file), func(container *dagger.Container) ([]string, error) {
+		return container.Directory(filepath.Dir(file)).Entries(ctx)
+	})
+	if err != nil || !slices.Contains(entries, filepath.Base(file)) {
+		// Missing directories are created by the patch
+		return "", false, nil
+	}
+	contents, err := env.readFile(ctx, file)
+	return contents, err == nil, err
+}
+
+// checkPatchSecrets scans the lines a patch adds, according to the secret scan mode of the configuration
+func (env *Environment) checkPatchSecrets(diff string) error {
+	if env.State.Config.SecretScan == SecretScanOff {
+		return nil
+	}
+	return env.handleSecretFindings(ScanDiffSecrets(diff))
+}
@@ -0,0 +1,136 @@
+package environment
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPatch = `Here is the change:
+
+diff --git a/main.go b/main.go
+index 3b18e51..a042389 100644
+--- a/main.go
++++ b/main.go
+@@ -1,5 +1,6 @@
+ package main
+ 
++import "fmt"
+ 
+ func main() {
+-	println("hello")
++	fmt.Println("hello")
+diff --git a/README.md b/README.md
+new file mode 100644
+--- /dev/null
++++ b/README.md
+@@ -0,0 +1,2 @@
++# Hello
++Says hello.
+--- old.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-obsolete
+`
+
+func TestParsePatch(t *testing.T) {
+	patches, err := ParsePatch(testPatch)
+	require.NoError(t, err)
+	require.Len(t, patches, 3)
+
+	assert.Equal(t, "main.go", patches[0].OldPath)
+	assert.Equal(t, "main.go", patches[0].NewPath)
+	require.Len(t, patches[0].Hunks, 1)
+	oldCount, newCount := patches[0].Hunks[0].counts()
+	assert.Equal(t, 5, oldCount)
+	assert.Equal(t, 6, newCount)
+
+	assert.Equal(t, "", patches[1].OldPath)
+	assert.Equal(t, "README.md", patches[1].Path())
+	assert.Equal(t, "old.txt", patches[2].OldPath)
+	assert.Equal(t, "", patches[2].NewPath)
+
+	_, err = ParsePatch("just some text")
+	assert.Error(t, err)
+	_, err = ParsePatch("--- a/x\n+++ b/y\n@@ -1 +1 @@\n-a\n+b\n")
+	assert.ErrorContains(t, err, "renames")
+	_, err = ParsePatch("diff --git a/logo.png b/logo.png\nBinary files a/logo.png and b/logo.png differ\n")
+	assert.ErrorContains(t, err, "binary")
+}
+
+func TestApplyHunks(t *testing.T) {
+	patches, err := ParsePatch(testPatch)
+	require.NoError(t, err)
+
+	contents := "package main\n\n\nfunc main() {\n\tprintln(\"hello\")\n}\n"
+	updated, err := applyHunks(contents, patches[0].Hunks)
+	require.NoError(t, err)
+	assert.Equal(t, "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n", updated)
+
+	// Hunks still apply when lines moved since the diff was made
+	updated, err = applyHunks("// Copyright\n"+contents, patches[0].Hunks)
+	require.NoError(t, err)
+	assert.Contains(t, updated, "// Copyright\npackage main\n\nimport \"fmt\"\n")
+
+	_, err = applyHunks("package other\n", patches[0].Hunks)
+	assert.ErrorContains(t, err, "hunk 1")
+
+	created, err := applyHunks("", patches[1].Hunks)
+	require.NoError(t, err)
+	assert.Equal(t, "# Hello\nSays hello.\n", created)
+
+	deleted, err := applyHunks("obsolete\n", patches[2].Hunks)
+	require.NoError(t, err)
+	assert.Equal(t, "", deleted)
+}
+
+func TestApplyHunksNoFinalNewline(t *testing.T) {
+	patches, err := ParsePatch("--- a/f\n+++ b/f\n@@ -1 +1 @@\n-a\n\\ No newline at end of file\n+b\n")
+	require.NoError(t, err)
+	updated, err := applyHunks("a", patches[0].Hunks)
+	require.NoError(t, err)
+	assert.Equal(t, "b\n", updated)
+
+	patches, err = ParsePatch("--- a/f\n+++ b/f\n@@ -1 +1 @@\n-a\n+b\n\\ No newline at end of file\n")
+	require.NoError(t, err)
+	updated, err = applyHunks("a\n", patches[0].Hunks)
+	require.NoError(t, err)
+	assert.Equal(t, "b", updated)
+}
+
+func TestFilePatchFormat(t *testing.T) {
+	patches, err := ParsePatch(testPatch)
+	require.NoError(t, err)
+	assert.Equal(t, "diff --git a/old.txt b/old.txt\ndeleted file mode 100644\n--- a/old.txt\n+++ /dev/null\n@@ -1,1 +0,0 @@\n-obsolete\n", patches[2].format())
+	assert.Contains(t, patches[1].format(), "new file mode 100644\n--- /dev/null\n+++ b/README.md\n@@ -0,0 +1,2 @@\n")
+}
+
+func TestApplyPatchHost(t *testing.T) {
+	env := newHostEnvironment(t, "patch-host")
+	workdir := env.State.Config.Workdir
+	require.NoError(t, os.WriteFile(filepath.Join(workdir, "main.go"), []byte("package main\n\n\nfunc main() {\n\tprintln(\"hello\")\n}\n"), 0644))
+
+	results, err := env.ApplyPatch(context.Background(), "Use fmt", testPatch)
+	require.NoError(t, err)
+	assert.Equal(t, []PatchFileResult{
+		{File: "main.go", Applied: true, Added: 2, Removed: 1},
+		{File: "README.md", Applied: true, Added: 2},
+		{File: "old.txt", Removed: 1, Error: "file not found"},
+	}, results)
+
+	data, err := os.ReadFile(filepath.Join(workdir, "main.go"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "fmt.Println")
+	assert.FileExists(t, filepath.Join(workdir, "README.md"))
+
+	// Applying the same patch again fails for every file, and changes nothing
+	results, err = env.ApplyPatch(context.Background(), "Use fmt", testPatch)
+	require.NoError(t, err)
+	for _, result := range results {
+		assert.False(t, result.Applied, result.File)
+	}
+}
@@ -0,0 +1,107 @@
+package environment
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// licenseHeaderLines is how far from the top of a file its license header must be
+const licenseHeaderLines = 20
+
+// LicenseHeader requires the files agents change to keep a license header
+type LicenseHeader struct {
+	// Paths are the files the header is required in, as gitignore-style patterns (e.g. *.go, /src/**/*.ts)
+	Paths []string `json:"paths"`
+	// Header is the text that must appear in the first lines of the files, e.g. "SPDX-License-Identifier: Apache-2.0"
+	Header string `json:"header"`
+}
+
+type LicenseHeaders []LicenseHeader
+
+// Validate checks the license header rules
+func (headers LicenseHeaders) Validate() error {
+	for _, h := range headers {
+		if strings.TrimSpace(h.Header) == "" {
+			return fmt.Errorf("license header cannot be empty")
+		}
+		if len(h.Paths) == 0 {
+			return fmt.Errorf("license header %q applies to no paths", h.Header)
+		}
+		for _, pattern := range h.Paths {
+			if _, err := pathPatternRegexp(pattern); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Missing returns the license headers required in file that its contents don't have
+func (headers LicenseHeaders) Missing(file, contents string) []string {
+	lines := strings.SplitN(contents, "\n", licenseHeaderLines+1)
+	top := strings.Join(lines[:min(len(lines), licenseHeaderLines)], "\n")
+	missing := []string{}
+	for _, h := range headers {
+		for _, pattern := range h.Paths {
+			if MatchPathPattern(pattern, file) {
+				if !strings.Contains(top, strings.TrimSpace(h.Header)) {
+					missing = append(missing, h.Header)
+				}
+				break
+			}
+		}
+	}
+	return missing
+}
+
+// MatchPathPattern tells whether a file, relative to the repository root, matches a gitignore-style pattern,
+// as used in CODEOWNERS files: patterns without a slash match at any depth, patterns starting with a slash or
+// containing one are relative to the root, and patterns matching a directory match everything under it.
+func MatchPathPattern(pattern, file string) bool {
+	re, err := pathPatternRegexp(pattern)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(file)
+}
+
+func pathPatternRegexp(pattern string) (*regexp.Regexp, error) {
+	p := strings.TrimSpace(pattern)
+	dirOnly := strings.HasSuffix(p, "/")
+	p = strings.TrimSuffix(p, "/")
+	anchored := strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+	if p == "" {
+		return nil, fmt.Errorf("invalid path pattern %q", pattern)
+	}
+
+	var b strings.Builder
+	if anchored {
+		b.WriteString("^")
+	} else {
+		b.WriteString("^(?:.*/)?")
+	}
+	for i := 0; i < len(p); i++ {
+		switch {
+		case strings.HasPrefix(p[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(p[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case p[i] == '*':
+			b.WriteString("[^/]*")
+		case p[i] == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(p[i : i+1]))
+		}
+	}
+	if dirOnly {
+		b.WriteString("/.*$")
+	} else {
+		b.WriteString("(?:/.*)?$")
+	}
+	return regexp.Compile(b.String())
+}
@@ -0,0 +1,58 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchPathPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		file    string
+		match   bool
+	}{
+		{"*", "cmd/main.go", true},
+		{"*.go", "cmd/main.go", true},
+		{"*.go", "main.go", true},
+		{"*.go", "main.go.orig", false},
+		{"/docs/", "docs/index.md", true},
+		{"docs/", "docs/index.md", true},
+		{"docs/", "src/docs/index.md", true},
+		{"docs/", "docs", false},
+		{"docs", "src/docs/index.md", true},
+		{"/build/logs/", "build/logs/a/b.log", true},
+		{"apps/*.js", "apps/app.js", true},
+		{"apps/*.js", "apps/nested/app.js", false},
+		{"**/logs", "deep/down/logs/app.log", true},
+		{"/src/**/*.ts", "src/a/b/index.ts", true},
+		{"/src/**/*.ts", "src/index.ts", true},
+		{"/src/**/*.ts", "lib/src/index.ts", false},
+		{"go.mod", "go.mod", true},
+		{"go.mod", "tools/go.mod", true},
+		{"/go.mod", "tools/go.mod", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.match, MatchPathPattern(tt.pattern, tt.file), "%s %s", tt.pattern, tt.file)
+	}
+}
+
+func TestLicenseHeaders(t *testing.T) {
+	headers := LicenseHeaders{
+		{Paths: []string{"*.go"}, Header: "SPDX-License-Identifier: Apache-2.0"},
+		{Paths: []string{"/internal/"}, Header: "Copyright Acme"},
+	}
+	assert.NoError(t, headers.Validate())
+	assert.Error(t, LicenseHeaders{{Paths: []string{"*.go"}}}.Validate())
+	assert.Error(t, LicenseHeaders{{Header: "Copyright"}}.Validate())
+
+	assert.Empty(t, headers.Missing("main.go", "// SPDX-License-Identifier: Apache-2.0\n\npackage main\n"))
+	assert.Equal(t, []string{"SPDX-License-Identifier: Apache-2.0", "Copyright Acme"}, headers.Missing("internal/db.go", "package db\n"))
+	assert.Empty(t, headers.Missing("README.md", "# Readme\n"))
+
+	late := ""
+	for range licenseHeaderLines {
+		late += "\n"
+	}
+	assert.Len(t, headers.Missing("main.go", late+"// SPDX-License-Identifier: Apache-2.0\n"), 1, "the header must be at the top")
+}
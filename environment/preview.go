@@ -0,0 +1,135 @@
+package environment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// previews are the running previews by environment ID.
+// Like background commands, they live as long as the dagger session of the server that started them.
+var (
+	previews   = map[string]*Preview{}
+	previewsMu sync.Mutex
+)
+
+type PreviewOptions struct {
+	// Command starts the application. If empty, the image default command is used.
+	Command string
+	Shell   string
+	// Ports the application listens on. Defaults to the ports of the configuration.
+	Ports         []int
+	UseEntrypoint bool
+}
+
+// Preview is an ephemeral deployment of the environment: the application and its services, with all ports tunneled to the host
+type Preview struct {
+	App       EndpointMappings            `json:"app"`
+	Services  map[string]EndpointMappings `json:"services,omitempty"`
+	URLs      []string                    `json:"urls"`
+	StartedAt time.Time                   `json:"started_at"`
+
+	services []*Service
+}
+
+// PreviewUp starts the application along with the environment's services and tunnels all their ports to the host
+func (env *Environment) PreviewUp(ctx context.Context, explanation string, opts PreviewOptions) (*Preview, error) {
+	if env.IsHost() {
+		return nil, fmt.Errorf("previews are not supported in host mode")
+	}
+	if opts.Shell == "" {
+		opts.Shell = "sh"
+	}
+	if len(opts.Ports) == 0 {
+		opts.Ports = env.State.Config.Ports
+	}
+
+	previewsMu.Lock()
+	defer previewsMu.Unlock()
+	if _, ok := previews[env.ID]; ok {
+		return nil, fmt.Errorf("a preview of %s is already running, stop it first", env.ID)
+	}
+
+	preview := &Preview{
+		Services:  map[string]EndpointMappings{},
+		StartedAt: time.Now(),
+	}
+	stop := func() {
+		if err := preview.stop(context.WithoutCancel(ctx)); err != nil {
+			env.Notes.Add("Failed to stop preview: %s", err)
+		}
+	}
+
+	// The environment container is bound to services with the same definitions,
+	// which dagger deduplicates: the application talks to the instances tunneled here.
+	for _, cfg := range env.State.Config.Services {
+		svc, err := env.startService(ctx, cfg)
+		if err != nil {
+			stop()
+			return nil, fmt.Errorf("failed to start service %s: %w", cfg.Name, err)
+		}
+		preview.services = append(preview.services, svc)
+		preview.Services[cfg.Name] = svc.Endpoints
+	}
+
+	if err := env.checkCommand(ctx, opts.Command); err != nil {
+		stop()
+		return nil, err
+	}
+	env.recordEnvUsage(opts.Command)
+	app, err := env.startBackground(ctx, opts.Command, opts.Shell, opts.Ports, opts.UseEntrypoint)
+	if err != nil {
+		stop()
+		env.Notes.AddCommand("preview up: "+opts.Command, 1, "", err.Error())
+		return nil, fmt.Errorf("failed to start application: %w", err)
+	}
+	preview.services = append(preview.services, app)
+	preview.App = app.Endpoints
+
+	for _, port := range opts.Ports {
+		preview.URLs = append(preview.URLs, previewURL(app.Endpoints[port].HostExternal))
+	}
+	for _, svc := range preview.services[:len(preview.services)-1] {
+		for _, port := range svc.Config.ExposedPorts {
+			preview.URLs = append(preview.URLs, previewURL(svc.Endpoints[port].HostExternal))
+		}
+	}
+
+	previews[env.ID] = preview
+	env.Notes.Add("Start preview\n%s\n%s\n\n", explanation, strings.Join(preview.URLs, "\n"))
+	return preview, nil
+}
+
+// PreviewDown stops the running preview of the environment
+func (env *Environment) PreviewDown(ctx context.Context, explanation string) error {
+	previewsMu.Lock()
+	defer previewsMu.Unlock()
+
+	preview, ok := previews[env.ID]
+	if !ok {
+		return fmt.Errorf("no preview of %s is running", env.ID)
+	}
+	delete(previews, env.ID)
+
+	if err := preview.stop(ctx); err != nil {
+		return fmt.Errorf("failed to stop preview: %w", err)
+	}
+	env.Notes.Add("Stop preview\n%s\n\n", explanation)
+	return nil
+}
+
+func (p *Preview) stop(ctx context.Context) error {
+	var errs []error
+	for _, svc := range p.services {
+		errs = append(errs, svc.Stop(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// previewURL turns a tunnel endpoint into a URL that can be opened in a browser
+func previewURL(endpoint string) string {
+	return "http://" + strings.TrimPrefix(endpoint, "tcp://")
+}
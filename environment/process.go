@@ -0,0 +1,290 @@
+package environment
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	ProcessKindHost    = "process"
+	ProcessKindService = "service"
+)
+
+// processLogDir holds the output of host background processes
+var processLogDir = filepath.Join(os.TempDir(), "container-use-logs")
+
+func createProcessLog(envID string) (*os.File, error) {
+	if err := os.MkdirAll(processLogDir, 0700); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(processLogDir, envID+"-*.log")
+}
+
+// Process is a background process (host and Kubernetes modes) or service (container mode) of the environment
+type Process struct {
+	// ID is the PID of host processes, or the ID of services
+	ID        string           `json:"id"`
+	Kind      string           `json:"kind"`
+	Command   string           `json:"command,omitempty"`
+	Image     string           `json:"image,omitempty"`
+	Endpoints EndpointMappings `json:"endpoints,omitempty"`
+	// Running is only checked for host processes, and for the processes of pods by ProcessesWithUsage:
+	// services run until stopped
+	Running   bool      `json:"running"`
+	StartedAt time.Time `json:"started_at,omitzero"`
+
+	// CPUPercent and MemoryBytes are the usage of the host processes run by a background process
+	CPUPercent  float64 `json:"cpu_percent,omitempty"`
+	MemoryBytes uint64  `json:"memory_bytes,omitempty"`
+	// OSProcesses are the host processes run by a background process: its shell and their descendants
+	OSProcesses []OSProcess `json:"processes,omitempty"`
+}
+
+// OSProcess is a process of the operating system
+type OSProcess struct {
+	PID         int     `json:"pid"`
+	PPID        int     `json:"ppid"`
+	Command     string  `json:"command"`
+	CPUPercent  float64 `json:"cpu_percent"`
+	MemoryBytes uint64  `json:"memory_bytes"`
+}
+
+// Processes lists the background processes recorded for the environment in host and Kubernetes modes,
+// and the services started by this server in container mode.
+func (env *Environment) Processes() []*Process {
+	processes := []*Process{}
+	if env.IsKubernetes() {
+		for _, bp := range env.State.BackgroundProcesses {
+			processes = append(processes, &Process{
+				ID:        strconv.Itoa(bp.PID),
+				Kind:      ProcessKindHost,
+				Command:   bp.Command,
+				Running:   true,
+				StartedAt: bp.StartedAt,
+			})
+		}
+		return processes
+	}
+	if env.IsHost() {
+		for _, bp := range env.State.BackgroundProcesses {
+			endpoints := EndpointMappings{}
+			for _, port := range bp.Ports {
+				endpoints[port] = &EndpointMapping{
+					EnvironmentInternal: fmt.Sprintf("tcp://127.0.0.1:%d", port),
+					HostExternal:        fmt.Sprintf("tcp://127.0.0.1:%d", port),
+				}
+			}
+			processes = append(processes, &Process{
+				ID:        strconv.Itoa(bp.PID),
+				Kind:      ProcessKindHost,
+				Command:   bp.Command,
+				Endpoints: endpoints,
+				Running:   isProcessRunning(bp.PID),
+				StartedAt: bp.StartedAt,
+			})
+		}
+		return processes
+	}
+
+	for _, svc := range env.RunningServices() {
+		processes = append(processes, &Process{
+			ID:        svc.ID,
+			Kind:      ProcessKindService,
+			Command:   svc.Config.Command,
+			Image:     svc.Config.Image,
+			Endpoints: svc.Endpoints,
+			Running:   true,
+			StartedAt: svc.StartedAt,
+		})
+	}
+	return processes
+}
+
+// ProcessesWithUsage lists the processes of the environment like Processes, along with the host processes
+// run by background processes and their CPU and memory usage.
+// Dagger doesn't expose the processes of running services: they are listed without usage.
+// The processes of pods are only checked to still be running.
+func (env *Environment) ProcessesWithUsage(ctx context.Context) ([]*Process, error) {
+	processes := env.Processes()
+	if env.IsKubernetes() {
+		for _, p := range processes {
+			pid, _ := strconv.Atoi(p.ID)
+			p.Running = env.podProcessRunning(ctx, pid)
+		}
+		return processes, nil
+	}
+	if !env.IsHost() || len(processes) == 0 {
+		return processes, nil
+	}
+
+	osProcesses, err := listOSProcesses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range processes {
+		pid, _ := strconv.Atoi(p.ID)
+		p.OSProcesses = processTree(osProcesses, pid)
+		for _, osProcess := range p.OSProcesses {
+			p.CPUPercent += osProcess.CPUPercent
+			p.MemoryBytes += osProcess.MemoryBytes
+		}
+	}
+	return processes, nil
+}
+
+// psArgs list all processes as "pid ppid %cpu rss(KB) command", on Linux and macOS
+var psArgs = []string{"ps", "-A", "-o", "pid=,ppid=,pcpu=,rss=,args="}
+
+func listOSProcesses(ctx context.Context) (map[int]OSProcess, error) {
+	out, err := exec.CommandContext(ctx, psArgs[0], psArgs[1:]...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %w", err)
+	}
+	return parsePs(string(out)), nil
+}
+
+func parsePs(output string) map[int]OSProcess {
+	processes := map[int]OSProcess{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		ppid, _ := strconv.Atoi(fields[1])
+		cpu, _ := strconv.ParseFloat(fields[2], 64)
+		rss, _ := strconv.ParseUint(fields[3], 10, 64)
+		processes[pid] = OSProcess{
+			PID:         pid,
+			PPID:        ppid,
+			Command:     strings.Join(fields[4:], " "),
+			CPUPercent:  cpu,
+			MemoryBytes: rss * 1024,
+		}
+	}
+	return processes
+}
+
+// processTree returns a process followed by its descendants, or nothing if it doesn't run
+func processTree(processes map[int]OSProcess, pid int) []OSProcess {
+	if _, ok := processes[pid]; !ok {
+		return nil
+	}
+	children := map[int][]int{}
+	for _, p := range processes {
+		children[p.PPID] = append(children[p.PPID], p.PID)
+	}
+
+	tree := []OSProcess{}
+	for queue := []int{pid}; len(queue) > 0; queue = queue[1:] {
+		tree = append(tree, processes[queue[0]])
+		kids := children[queue[0]]
+		slices.Sort(kids)
+		queue = append(queue, kids...)
+	}
+	return tree
+}
+
+func isProcessRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+func (env *Environment) backgroundProcess(id string) (*BackgroundProcess, error) {
+	pid, err := strconv.Atoi(id)
+	if err == nil {
+		for i := range env.State.BackgroundProcesses {
+			if env.State.BackgroundProcesses[i].PID == pid {
+				return &env.State.BackgroundProcesses[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("background process %s not found", id)
+}
+
+func (env *Environment) runningService(id string) (*Service, error) {
+	for _, svc := range env.RunningServices() {
+		if svc.ID == id {
+			return svc, nil
+		}
+	}
+	return nil, fmt.Errorf("service %s not found", id)
+}
+
+// ProcessLogs returns the tail of the output of a host or pod background process.
+// The output of container services isn't available while they run.
+func (env *Environment) ProcessLogs(ctx context.Context, id string) (string, error) {
+	if env.IsKubernetes() {
+		bp, err := env.backgroundProcess(id)
+		if err != nil {
+			return "", err
+		}
+		pod, err := env.pod()
+		if err != nil {
+			return "", err
+		}
+		return pod.output(ctx, nil, "/", "tail", "-c", strconv.Itoa(maxJobOutput), bp.LogFile)
+	}
+	if !env.IsHost() {
+		if _, err := env.runningService(id); err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("the output of service %s isn't available: container services only report their output when they fail to start", id)
+	}
+
+	bp, err := env.backgroundProcess(id)
+	if err != nil {
+		return "", err
+	}
+	if bp.LogFile == "" {
+		return "", fmt.Errorf("no output recorded for background process %s", id)
+	}
+	return readTail(bp.LogFile, maxJobOutput)
+}
+
+// StopProcess stops a host or pod background process, or a container service
+func (env *Environment) StopProcess(ctx context.Context, id string) error {
+	if env.IsKubernetes() {
+		bp, err := env.backgroundProcess(id)
+		if err != nil {
+			return err
+		}
+		return env.stopPodProcess(ctx, bp)
+	}
+	if env.IsHost() {
+		bp, err := env.backgroundProcess(id)
+		if err != nil {
+			return err
+		}
+		return env.KillBackground(bp.PID)
+	}
+
+	svc, err := env.runningService(id)
+	if err != nil {
+		return err
+	}
+	if err := svc.Stop(ctx); err != nil {
+		return fmt.Errorf("failed to stop service %s: %w", id, err)
+	}
+	env.mu.Lock()
+	env.State.BackgroundServices = slices.DeleteFunc(env.State.BackgroundServices, func(bs BackgroundService) bool { return bs.ID == id })
+	env.mu.Unlock()
+	env.Notes.Add("Stop service %s", id)
+	return nil
+}
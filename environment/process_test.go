@@ -0,0 +1,122 @@
+package environment
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostProcesses(t *testing.T) {
+	ctx := context.Background()
+	env := newHostEnvironment(t, "env-processes")
+
+	cmd := exec.Command("sleep", "30")
+	require.NoError(t, cmd.Start())
+	done := make(chan error)
+	go func() { done <- cmd.Wait() }()
+
+	logFile := filepath.Join(t.TempDir(), "server.log")
+	require.NoError(t, os.WriteFile(logFile, []byte("listening on :8080\n"), 0600))
+	pid := strconv.Itoa(cmd.Process.Pid)
+	env.State.BackgroundProcesses = []BackgroundProcess{{
+		PID:     cmd.Process.Pid,
+		Command: "npm start",
+		Ports:   []int{8080},
+		LogFile: logFile,
+	}}
+
+	processes := env.Processes()
+	require.Len(t, processes, 1)
+	assert.Equal(t, pid, processes[0].ID)
+	assert.Equal(t, ProcessKindHost, processes[0].Kind)
+	assert.True(t, processes[0].Running)
+	assert.Equal(t, "tcp://127.0.0.1:8080", processes[0].Endpoints[8080].HostExternal)
+
+	withUsage, err := env.ProcessesWithUsage(ctx)
+	require.NoError(t, err)
+	require.Len(t, withUsage, 1)
+	require.NotEmpty(t, withUsage[0].OSProcesses)
+	assert.Equal(t, cmd.Process.Pid, withUsage[0].OSProcesses[0].PID)
+	assert.Equal(t, "sleep 30", withUsage[0].OSProcesses[0].Command)
+	assert.Positive(t, withUsage[0].MemoryBytes)
+
+	logs, err := env.ProcessLogs(ctx, pid)
+	require.NoError(t, err)
+	assert.Equal(t, "listening on :8080\n", logs)
+	_, err = env.ProcessLogs(ctx, "1")
+	assert.Error(t, err)
+
+	require.NoError(t, env.StopProcess(ctx, pid))
+	<-done
+	assert.Empty(t, env.Processes())
+	assert.NoFileExists(t, logFile)
+	assert.Error(t, env.StopProcess(ctx, pid))
+}
+
+func TestHostBackgroundProcessOutlivesCall(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	env := newHostEnvironment(t, "env-background")
+
+	_, err := env.RunBackground(ctx, "sleep 30", "sh", nil, false)
+	require.NoError(t, err)
+	// The tool call starting the process is over
+	cancel()
+
+	processes := env.Processes()
+	require.Len(t, processes, 1)
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, processes[0].Running)
+	assert.True(t, isProcessRunning(env.State.BackgroundProcesses[0].PID))
+	require.NoError(t, env.StopProcess(context.Background(), processes[0].ID))
+}
+
+func TestProcessTree(t *testing.T) {
+	processes := parsePs(`    1     0  0.0  1000 /sbin/init
+  100     1  0.1   200 sh -c npm start
+  103   100  0.0   100 tail -f log
+  101   100 50.0  4000 node server.js
+  102   101 25.0  2000 node worker.js --fast
+  200     1 10.0  8000 postgres
+`)
+	assert.Equal(t, OSProcess{PID: 102, PPID: 101, Command: "node worker.js --fast", CPUPercent: 25, MemoryBytes: 2000 * 1024}, processes[102])
+
+	pids := []int{}
+	for _, p := range processTree(processes, 100) {
+		pids = append(pids, p.PID)
+	}
+	assert.Equal(t, []int{100, 101, 103, 102}, pids, "processes are listed from the shell down")
+	assert.Empty(t, processTree(processes, 300))
+}
+
+func TestContainerServices(t *testing.T) {
+	ctx := context.Background()
+	env := newHostEnvironment(t, "env-services")
+	env.State.Config.BaseImage = "postgres"
+
+	env.track(&Service{ID: "db", Config: &ServiceConfig{Name: "db", Image: "postgres:17"}})
+	env.track(&Service{ID: "cache", Config: &ServiceConfig{Name: "cache", Image: "redis"}})
+	restarted := &Service{ID: "db", Config: &ServiceConfig{Name: "db", Image: "postgres:18"}}
+	env.track(restarted)
+
+	processes := env.Processes()
+	require.Len(t, processes, 2)
+	assert.Equal(t, "cache", processes[0].ID)
+	assert.Equal(t, "postgres:18", processes[1].Image, "restarted services replace the previous instance")
+	assert.Empty(t, newHostEnvironment(t, "other").RunningServices(), "services are per environment")
+
+	_, err := env.ProcessLogs(ctx, "db")
+	assert.ErrorContains(t, err, "isn't available")
+
+	require.NoError(t, env.StopProcess(ctx, "db"))
+	processes = env.Processes()
+	require.Len(t, processes, 1)
+	assert.Equal(t, "cache", processes[0].ID)
+	assert.Error(t, env.StopProcess(ctx, "db"))
+}
@@ -0,0 +1,146 @@
+package environment
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// promptIdle is how long a command must be silent after printing a prompt to be considered waiting for input
+	promptIdle = 2 * time.Second
+	// maxPromptLength is the length of the unfinished output line kept to look for prompts
+	maxPromptLength = 256
+)
+
+// promptRe matches the unfinished lines commands print when asking for input:
+// confirmations (`[y/N]`, `(yes/no)`) and questions or fields (`Continue?`, `Password:`, `> `).
+var promptRe = regexp.MustCompile(`(?i)(\[y/n\]|\(y/n\)|\[yes/no\]|\(yes/no(/\[fingerprint\])?\)|[?:>])\s*$`)
+
+// looksLikePrompt tells whether an unfinished output line asks for input
+func looksLikePrompt(line string) bool {
+	line = strings.TrimSpace(line)
+	return line != "" && len(line) <= maxPromptLength && promptRe.MatchString(line)
+}
+
+// promptWriter spools the output of an interactive job, keeping its unfinished last line
+type promptWriter struct {
+	w io.Writer
+
+	mu        sync.Mutex
+	line      []byte
+	lastWrite time.Time
+}
+
+func (p *promptWriter) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		p.line = append(p.line[:0], data[i+1:]...)
+	} else {
+		p.line = append(p.line, data...)
+	}
+	if len(p.line) > maxPromptLength {
+		p.line = p.line[len(p.line)-maxPromptLength:]
+	}
+	p.lastWrite = time.Now()
+	p.mu.Unlock()
+
+	return p.w.Write(data)
+}
+
+// prompt returns the unfinished last line, once the output has been idle long enough for it to be a prompt
+func (p *promptWriter) prompt(now time.Time) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if now.Sub(p.lastWrite) < promptIdle || !looksLikePrompt(string(p.line)) {
+		return ""
+	}
+	return strings.TrimSpace(string(p.line))
+}
+
+// answered forgets the unfinished last line, so a prompt is only reported once
+func (p *promptWriter) answered() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.line = p.line[:0]
+}
+
+// watchPrompts puts an interactive job waiting for input when its output stops on a prompt, until it finishes
+func (job *Job) watchPrompts() {
+	ticker := time.NewTicker(promptIdle / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-job.done:
+			return
+		case now := <-ticker.C:
+			for _, output := range job.outputs {
+				prompt := output.prompt(now)
+				if prompt == "" {
+					continue
+				}
+				job.mu.Lock()
+				waiting := job.State == JobRunning && job.Prompt == ""
+				if waiting {
+					job.State = JobWaitingInput
+					job.Prompt = prompt
+				}
+				job.mu.Unlock()
+				if waiting {
+					job.onPrompt(job.snapshot())
+				}
+				break
+			}
+		}
+	}
+}
+
+// reply writes a reply to the standard input of the job waiting for input, resumes it and returns the prompt answered
+func (job *Job) reply(reply string) (string, error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	if !job.Interactive {
+		return "", fmt.Errorf("job %s is not interactive", job.ID)
+	}
+	if job.State != JobWaitingInput {
+		return "", fmt.Errorf("job %s is not waiting for input (%s)", job.ID, job.State)
+	}
+	if _, err := io.WriteString(job.stdin, reply+"\n"); err != nil {
+		return "", fmt.Errorf("failed to reply to job %s: %w", job.ID, err)
+	}
+	for _, output := range job.outputs {
+		output.answered()
+	}
+	prompt := job.Prompt
+	job.State = JobRunning
+	job.Prompt = ""
+	return prompt, nil
+}
+
+// RespondJob replies to the prompt of an interactive job waiting for input
+func (env *Environment) RespondJob(ctx context.Context, id, reply string) (*Job, error) {
+	job, err := env.getJob(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := env.checkReply(ctx, reply); err != nil {
+		return nil, err
+	}
+
+	prompt, err := job.reply(reply)
+	if err != nil {
+		return nil, err
+	}
+	// Replies may be passwords: only the prompt is recorded
+	env.Notes.Add("Job %s: replied to %q", id, prompt)
+	return job.snapshot(), nil
+}
@@ -0,0 +1,62 @@
+package environment
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLooksLikePrompt(t *testing.T) {
+	for line, prompt := range map[string]bool{
+		"Proceed? [y/N] ":                  true,
+		"Do you want to continue (yes/no)": true,
+		"Password:":                        true,
+		"> ":                               true,
+		"Downloading 42%":                  false,
+		"":                                 false,
+	} {
+		assert.Equal(t, prompt, looksLikePrompt(line), line)
+	}
+}
+
+func TestInteractiveJob(t *testing.T) {
+	ctx := context.Background()
+	env := newHostEnvironment(t, "env-interactive")
+
+	prompts := make(chan *Job, 1)
+	job, err := env.StartInteractiveJob(ctx, `printf 'Continue? [y/N] '; read answer; echo "got $answer"`, "sh", func(job *Job) {
+		prompts <- job
+	})
+	require.NoError(t, err)
+	assert.True(t, job.Interactive)
+
+	select {
+	case prompted := <-prompts:
+		assert.Equal(t, JobWaitingInput, prompted.State)
+		assert.Equal(t, "Continue? [y/N]", prompted.Prompt)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the prompt was never reported")
+	}
+	_, err = env.JobResult(ctx, job.ID)
+	assert.ErrorContains(t, err, "waiting for input")
+
+	resumed, err := env.RespondJob(context.Background(), job.ID, "y")
+	require.NoError(t, err)
+	assert.Equal(t, JobRunning, resumed.State)
+	assert.Empty(t, resumed.Prompt)
+	_, err = env.RespondJob(context.Background(), job.ID, "y")
+	assert.Error(t, err, "the prompt was answered")
+
+	status := waitJob(t, env, job.ID)
+	assert.Equal(t, JobSucceeded, status.State)
+	assert.Equal(t, "Continue? [y/N] got y\n", status.Stdout)
+	assert.Contains(t, env.Notes.String(), `replied to "Continue? [y/N]"`)
+
+	_, err = env.StartInteractiveJob(ctx, "true", "sh", nil)
+	require.NoError(t, err)
+	_, err = (&Environment{EnvironmentInfo: &EnvironmentInfo{ID: "container", State: &State{Config: &EnvironmentConfig{BaseImage: "alpine"}}}}).StartInteractiveJob(ctx, "true", "sh", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,101 @@
+package environment
+
+import (
+	"sync"
+
+	"dagger.io/dagger"
+)
+
+const (
+	// maxReadCacheSize is the size of the files and listings kept for an environment
+	maxReadCacheSize = 16 << 20
+	// maxReadCaches is the number of environments files are kept for
+	maxReadCaches = 32
+)
+
+// Files and directory listings read from the containers of environments are kept for the lifetime of the process, so
+// agents re-reading files don't go back to the engine. Containers are immutable: reads are kept for the container they
+// come from, and dropped when the environment gets a new container.
+var (
+	readCachesMu sync.Mutex
+	readCaches   = map[string]*readCache{}
+)
+
+type readCache struct {
+	container string
+	reads     map[string]any
+	size      int
+}
+
+// lookupRead returns what was read at key from the container of an environment, if it's known
+func lookupRead(envID, container, key string) (any, bool) {
+	readCachesMu.Lock()
+	defer readCachesMu.Unlock()
+
+	cache := readCaches[envID]
+	if cache == nil || cache.container != container {
+		return nil, false
+	}
+	value, ok := cache.reads[key]
+	return value, ok
+}
+
+// storeRead keeps what was read at key from the container of an environment
+func storeRead(envID, container, key string, value any) {
+	size := 0
+	switch v := value.(type) {
+	case string:
+		size = len(v)
+	case []string:
+		for _, s := range v {
+			size += len(s)
+		}
+	}
+	if size > maxReadCacheSize {
+		return
+	}
+
+	readCachesMu.Lock()
+	defer readCachesMu.Unlock()
+
+	cache := readCaches[envID]
+	if cache == nil || cache.container != container || cache.size+size > maxReadCacheSize {
+		if cache == nil && len(readCaches) >= maxReadCaches {
+			// Any environment will do, the ones in use fill their cache again
+			for id := range readCaches {
+				delete(readCaches, id)
+				break
+			}
+		}
+		cache = &readCache{container: container, reads: map[string]any{}}
+		readCaches[envID] = cache
+	}
+	cache.reads[key] = value
+	cache.size += size
+}
+
+// dropReads forgets what was read from the containers of an environment
+func dropReads(envID string) {
+	readCachesMu.Lock()
+	defer readCachesMu.Unlock()
+
+	delete(readCaches, envID)
+}
+
+// cachedRead reads from the container of the environment, unless it was already read from the same container.
+// Failed reads aren't kept.
+func cachedRead[T any](env *Environment, key string, read func(*dagger.Container) (T, error)) (T, error) {
+	env.mu.RLock()
+	container := env.State.Container
+	env.mu.RUnlock()
+
+	if value, ok := lookupRead(env.ID, container, key); ok {
+		return value.(T), nil
+	}
+	value, err := read(env.dag.LoadContainerFromID(dagger.ContainerID(container)))
+	if err != nil {
+		return value, err
+	}
+	storeRead(env.ID, container, key, value)
+	return value, nil
+}
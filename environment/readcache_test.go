@@ -0,0 +1,50 @@
+package environment
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadCache(t *testing.T) {
+	t.Cleanup(func() { dropReads("cache-env") })
+
+	storeRead("cache-env", "ctr1", "file:main.go", "package main")
+	value, ok := lookupRead("cache-env", "ctr1", "file:main.go")
+	assert.True(t, ok)
+	assert.Equal(t, "package main", value)
+
+	_, ok = lookupRead("cache-env", "ctr2", "file:main.go")
+	assert.False(t, ok, "reads are only valid for the container they come from")
+	_, ok = lookupRead("other-env", "ctr1", "file:main.go")
+	assert.False(t, ok)
+
+	// A new container replaces the reads of the previous one
+	storeRead("cache-env", "ctr2", "dir:.", []string{"main.go", "go.mod"})
+	_, ok = lookupRead("cache-env", "ctr1", "file:main.go")
+	assert.False(t, ok)
+	value, ok = lookupRead("cache-env", "ctr2", "dir:.")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"main.go", "go.mod"}, value)
+
+	dropReads("cache-env")
+	_, ok = lookupRead("cache-env", "ctr2", "dir:.")
+	assert.False(t, ok)
+}
+
+func TestReadCacheSize(t *testing.T) {
+	t.Cleanup(func() { dropReads("cache-env") })
+
+	storeRead("cache-env", "ctr", "file:huge.bin", strings.Repeat("x", maxReadCacheSize+1))
+	_, ok := lookupRead("cache-env", "ctr", "file:huge.bin")
+	assert.False(t, ok, "files larger than the cache aren't kept")
+
+	half := strings.Repeat("x", maxReadCacheSize/2+1)
+	storeRead("cache-env", "ctr", "file:a", half)
+	storeRead("cache-env", "ctr", "file:b", half)
+	_, ok = lookupRead("cache-env", "ctr", "file:a")
+	assert.False(t, ok, "the cache is emptied when full")
+	_, ok = lookupRead("cache-env", "ctr", "file:b")
+	assert.True(t, ok)
+}
@@ -0,0 +1,214 @@
+package environment
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Types of the messages sent by remote terminal clients, given by their first byte
+const (
+	// remoteTerminalInput is followed by input for the shell
+	remoteTerminalInput = '0'
+	// remoteTerminalResize is followed by the new size of the terminal, as a JSON TerminalSize
+	remoteTerminalResize = '1'
+)
+
+// remoteTerminals are the remote terminals served by this process, by environment.
+// Like services, they live as long as the server that started them.
+var (
+	remoteTerminals   = map[string]*RemoteTerminal{}
+	remoteTerminalsMu sync.Mutex
+)
+
+// RemoteTerminalSession loads the latest state of the environment before starting a shell and saves it once the shell
+// exited, so the changes made from the terminal and by agents build on each other.
+type RemoteTerminalSession struct {
+	// Lock, when set, is held while a shell runs, so it doesn't race with the other changes of the environment
+	Lock sync.Locker
+	Open func(ctx context.Context) (*Environment, error)
+	Save func(ctx context.Context, env *Environment) error
+}
+
+// RemoteTerminal serves a shell to an environment over WebSocket, for clients that don't share
+// the TTY of the server (e.g. editors running the MCP server).
+// Each client gets an interactive shell on a pseudo-terminal, sized with the rows and cols query parameters.
+// Client messages start with their type: remoteTerminalInput followed by input, or remoteTerminalResize followed by
+// the new size of the terminal. The output of the shell is sent in binary messages.
+// The shell ends when the client disconnects, and the connection when the shell exits.
+type RemoteTerminal struct {
+	EnvID string `json:"environment_id"`
+	// URL includes the token authenticating clients
+	URL string `json:"url"`
+
+	session  RemoteTerminalSession
+	token    string
+	listener net.Listener
+	server   *http.Server
+	// mu is held by the client of the shell: there's one at a time
+	mu sync.Mutex
+}
+
+// StartRemoteTerminal serves a remote terminal to the environment on addr (e.g. 127.0.0.1:0),
+// or returns the terminal already served for it
+func StartRemoteTerminal(envID, addr string, session RemoteTerminalSession) (*RemoteTerminal, error) {
+	remoteTerminalsMu.Lock()
+	defer remoteTerminalsMu.Unlock()
+	if t, ok := remoteTerminals[envID]; ok {
+		return t, nil
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	t := &RemoteTerminal{
+		EnvID:    envID,
+		URL:      fmt.Sprintf("ws://%s/terminal?token=%s", listener.Addr(), hex.EncodeToString(token)),
+		session:  session,
+		token:    hex.EncodeToString(token),
+		listener: listener,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/terminal", t.handle)
+	t.server = &http.Server{Handler: mux}
+	go func() {
+		if err := t.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Remote terminal stopped", "id", envID, "err", err)
+		}
+	}()
+
+	remoteTerminals[envID] = t
+	return t, nil
+}
+
+// Close stops serving the terminal and disconnects its clients
+func (t *RemoteTerminal) Close() error {
+	remoteTerminalsMu.Lock()
+	if remoteTerminals[t.EnvID] == t {
+		delete(remoteTerminals, t.EnvID)
+	}
+	remoteTerminalsMu.Unlock()
+	return t.server.Close()
+}
+
+func (t *RemoteTerminal) handle(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(t.token)) != 1 {
+		http.Error(w, "invalid token", http.StatusForbidden)
+		return
+	}
+	if !t.mu.TryLock() {
+		http.Error(w, "another client is connected to the terminal", http.StatusConflict)
+		return
+	}
+	defer t.mu.Unlock()
+	conn, err := wsUpgrade(w, r)
+	if err != nil {
+		slog.Warn("Remote terminal connection failed", "id", t.EnvID, "err", err)
+		return
+	}
+	defer conn.Close()
+
+	if err := t.serve(r.Context(), conn, terminalSize(r)); err != nil {
+		// Terminals expect carriage returns
+		conn.WriteBinary([]byte("\r\nerror: " + strings.ReplaceAll(err.Error(), "\n", "\r\n") + "\r\n"))
+	}
+	conn.WriteClose()
+}
+
+// serve runs a shell for the client until it exits, or the client disconnects
+func (t *RemoteTerminal) serve(ctx context.Context, conn *wsConn, size TerminalSize) error {
+	if t.session.Lock != nil {
+		t.session.Lock.Lock()
+		defer t.session.Lock.Unlock()
+	}
+	env, err := t.session.Open(ctx)
+	if err != nil {
+		return err
+	}
+	shell, err := env.StartShell(ctx, size)
+	if err != nil {
+		return err
+	}
+	if err := conn.WriteBinary(fmt.Appendf(nil, "Connected to environment %s.\r\n", t.EnvID)); err != nil {
+		shell.Close()
+		shell.Wait(ctx)
+		return nil
+	}
+
+	output := make(chan struct{})
+	go func() {
+		defer close(output)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := shell.Read(buf)
+			if n > 0 {
+				if err := conn.WriteBinary(buf[:n]); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		relayTerminalInput(conn, shell)
+		// The client disconnected
+		shell.Close()
+	}()
+
+	err = shell.Wait(ctx)
+	<-output
+	if err != nil {
+		return err
+	}
+	if err := t.session.Save(ctx, env); err != nil {
+		return fmt.Errorf("failed to save environment: %w", err)
+	}
+	return nil
+}
+
+// relayTerminalInput writes the input of the client to the shell and resizes its terminal, until the client
+// disconnects
+func relayTerminalInput(conn *wsConn, shell Shell) {
+	for {
+		message, err := conn.ReadMessage()
+		if err != nil || len(message) == 0 {
+			return
+		}
+		switch message[0] {
+		case remoteTerminalInput:
+			if _, err := shell.Write(message[1:]); err != nil {
+				return
+			}
+		case remoteTerminalResize:
+			var size TerminalSize
+			if err := json.Unmarshal(message[1:], &size); err == nil && size.Rows > 0 && size.Cols > 0 {
+				shell.Resize(size)
+			}
+		}
+	}
+}
+
+// terminalSize returns the size of the terminal of the client given by the rows and cols query parameters
+func terminalSize(r *http.Request) TerminalSize {
+	rows, _ := strconv.ParseUint(r.URL.Query().Get("rows"), 10, 16)
+	cols, _ := strconv.ParseUint(r.URL.Query().Get("cols"), 10, 16)
+	return TerminalSize{Rows: uint16(rows), Cols: uint16(cols)}
+}
@@ -0,0 +1,175 @@
+package environment
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dialTerminal connects to a remote terminal as a WebSocket client
+func dialTerminal(t *testing.T, rawURL string, header string) (net.Conn, *bufio.Reader, *http.Response) {
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", u.Host)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(30*time.Second)))
+
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n%s\r\n", u.RequestURI(), u.Host, key, header)
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	return conn, r, resp
+}
+
+func writeClientFrame(t *testing.T, conn net.Conn, opcode byte, payload string) {
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i := range len(payload) {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+	_, err := conn.Write(frame)
+	require.NoError(t, err)
+}
+
+func readServerFrame(t *testing.T, r *bufio.Reader) (byte, string) {
+	var header [2]byte
+	_, err := io.ReadFull(r, header[:])
+	require.NoError(t, err)
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		var ext [2]byte
+		_, err := io.ReadFull(r, ext[:])
+		require.NoError(t, err)
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	require.NoError(t, err)
+	return header[0] & 0x0F, string(payload)
+}
+
+// readOutput reads the output of the shell until it contains want
+func readOutput(t *testing.T, r *bufio.Reader, want string) string {
+	output := ""
+	for !strings.Contains(output, want) {
+		opcode, payload := readServerFrame(t, r)
+		require.Equal(t, byte(wsOpBinary), opcode, "output so far: %q", output)
+		output += payload
+	}
+	return output
+}
+
+func TestRemoteTerminal(t *testing.T) {
+	env := newHostEnvironment(t, "env-remote-terminal")
+	require.NoError(t, os.Mkdir(filepath.Join(env.State.Config.Workdir, "sub"), 0755))
+	var saves atomic.Int32
+	terminal, err := StartRemoteTerminal(env.ID, "127.0.0.1:0", RemoteTerminalSession{
+		Open: func(context.Context) (*Environment, error) { return env, nil },
+		Save: func(context.Context, *Environment) error {
+			saves.Add(1)
+			return nil
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { terminal.Close() })
+
+	again, err := StartRemoteTerminal(env.ID, "127.0.0.1:0", RemoteTerminalSession{})
+	require.NoError(t, err)
+	assert.Same(t, terminal, again, "environments have a single terminal")
+
+	resp, err := http.Get(strings.Replace(strings.Replace(terminal.URL, "ws://", "http://", 1), "token=", "token=x", 1))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	_, _, resp = dialTerminal(t, terminal.URL, "Origin: http://attacker.example\r\n")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "web pages of other origins can't connect")
+
+	conn, r, resp := dialTerminal(t, terminal.URL+"&rows=30&cols=100", "")
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	readOutput(t, r, "Connected to environment env-remote-terminal.\r\n")
+
+	_, _, resp = dialTerminal(t, terminal.URL, "")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "one client at a time")
+
+	writeClientFrame(t, conn, wsOpPing, "hi")
+	opcode, payload := readServerFrame(t, r)
+	for opcode == wsOpBinary {
+		// The shell may print its prompt first
+		opcode, payload = readServerFrame(t, r)
+	}
+	assert.Equal(t, byte(wsOpPong), opcode)
+	assert.Equal(t, "hi", payload)
+
+	// The working directory and variables persist between commands
+	writeClientFrame(t, conn, wsOpBinary, "0X=41; cd sub\n")
+	writeClientFrame(t, conn, wsOpBinary, "0echo v$((X+1)) in $(basename $PWD)\n")
+	readOutput(t, r, "v42 in sub")
+
+	// The shell runs on a terminal of the size of the client's
+	writeClientFrame(t, conn, wsOpBinary, "0stty size\n")
+	readOutput(t, r, "30 100")
+	writeClientFrame(t, conn, wsOpBinary, `1{"rows":40,"cols":120}`)
+	writeClientFrame(t, conn, wsOpBinary, "0stty size\n")
+	readOutput(t, r, "40 120")
+	assert.Equal(t, int32(0), saves.Load(), "the environment is saved once the shell exits")
+
+	writeClientFrame(t, conn, wsOpBinary, "0exit\n")
+	for opcode != wsOpClose {
+		opcode, _ = readServerFrame(t, r)
+	}
+	assert.Equal(t, int32(1), saves.Load())
+
+	require.NoError(t, terminal.Close())
+	terminal, err = StartRemoteTerminal(env.ID, "127.0.0.1:0", RemoteTerminalSession{})
+	require.NoError(t, err)
+	assert.NotEqual(t, again.URL, terminal.URL, "closed terminals are served again with a new token")
+}
+
+func TestRemoteTerminalDisconnect(t *testing.T) {
+	env := newHostEnvironment(t, "env-remote-terminal-disconnect")
+	saved := make(chan struct{})
+	terminal, err := StartRemoteTerminal(env.ID, "127.0.0.1:0", RemoteTerminalSession{
+		Open: func(context.Context) (*Environment, error) { return env, nil },
+		Save: func(context.Context, *Environment) error {
+			close(saved)
+			return nil
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { terminal.Close() })
+
+	conn, r, resp := dialTerminal(t, terminal.URL, "")
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	readOutput(t, r, "Connected to environment")
+	writeClientFrame(t, conn, wsOpBinary, "0echo started; sleep 600\n")
+	readOutput(t, r, "started\r\n")
+
+	// Disconnecting hangs up the shell and its command
+	require.NoError(t, conn.Close())
+	select {
+	case <-saved:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the shell didn't exit once the client disconnected")
+	}
+}
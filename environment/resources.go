@@ -0,0 +1,174 @@
+package environment
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// ResourceLimits bound the resources used by each process run in the environment and its services.
+// They are enforced with rlimits, so they apply per process rather than to the environment as a whole.
+type ResourceLimits struct {
+	// CPUTime is the maximum CPU time of a process (e.g. "30m"). Processes exceeding it are killed.
+	CPUTime string `json:"cpu_time,omitempty"`
+	// Memory is the maximum memory a process can allocate (e.g. "4GB")
+	Memory string `json:"memory,omitempty"`
+	// Disk is the maximum size of a file written by a process (e.g. "10GB")
+	Disk string `json:"disk,omitempty"`
+}
+
+func (l *ResourceLimits) IsZero() bool {
+	return l == nil || (l.CPUTime == "" && l.Memory == "" && l.Disk == "")
+}
+
+// ulimits returns the ulimit flags and values enforcing the limits
+func (l *ResourceLimits) ulimits() ([]string, error) {
+	if l.IsZero() {
+		return nil, nil
+	}
+	flags := []string{}
+	if l.CPUTime != "" {
+		d, err := time.ParseDuration(l.CPUTime)
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("invalid cpu time limit %q: expected a duration of at least 1s", l.CPUTime)
+		}
+		flags = append(flags, fmt.Sprintf("-t %d", int64(d.Seconds())))
+	}
+	if l.Memory != "" {
+		size, err := humanize.ParseBytes(l.Memory)
+		if err != nil || size < 1024 {
+			return nil, fmt.Errorf("invalid memory limit %q: expected a size of at least 1KB", l.Memory)
+		}
+		// in kilobytes
+		flags = append(flags, fmt.Sprintf("-v %d", size/1024))
+	}
+	if l.Disk != "" {
+		size, err := humanize.ParseBytes(l.Disk)
+		if err != nil || size < 512 {
+			return nil, fmt.Errorf("invalid disk limit %q: expected a size of at least 512B", l.Disk)
+		}
+		// in the 512 bytes blocks of POSIX shells
+		flags = append(flags, fmt.Sprintf("-f %d", size/512))
+	}
+	return flags, nil
+}
+
+// Validate checks that the limits can be enforced
+func (l *ResourceLimits) Validate() error {
+	_, err := l.ulimits()
+	return err
+}
+
+// limit wraps the arguments of a command so it runs within the resource limits of the environment.
+// The limits are applied by the shell before executing the command, and can't be raised by it.
+func (env *Environment) limit(args []string) []string {
+	flags, err := env.State.Config.Resources.ulimits()
+	// Limits are validated when the environment is configured
+	if err != nil || len(flags) == 0 || len(args) == 0 {
+		return args
+	}
+	script := ""
+	for _, flag := range flags {
+		script += "ulimit " + flag + " && "
+	}
+	script += `exec "$@"`
+	return append([]string{"sh", "-c", script, "sh"}, args...)
+}
+
+// EnvironmentStats is the resource usage of an environment
+type EnvironmentStats struct {
+	Limits *ResourceLimits `json:"limits,omitempty"`
+
+	WorkdirBytes         uint64 `json:"workdir_bytes"`
+	DiskAvailableBytes   uint64 `json:"disk_available_bytes"`
+	MemoryTotalBytes     uint64 `json:"memory_total_bytes"`
+	MemoryAvailableBytes uint64 `json:"memory_available_bytes"`
+	CPUs                 int    `json:"cpus"`
+	LoadAverage          string `json:"load_average,omitempty"`
+	// Usage is sampled periodically while background processes or services run
+	Usage *ResourceUsage `json:"usage,omitempty"`
+}
+
+// statsScript prints the resource usage of the workdir and the machine running it, one "key value" per line
+const statsScript = `printf 'workdir %s\n' "$(du -sk . 2>/dev/null | cut -f1)"
+printf 'disk %s\n' "$(df -Pk . 2>/dev/null | awk 'NR==2 {print $4}')"
+printf 'cpus %s\n' "$(nproc 2>/dev/null || grep -c ^processor /proc/cpuinfo 2>/dev/null)"
+printf 'load %s\n' "$(cut -d' ' -f1-3 /proc/loadavg 2>/dev/null)"
+grep -E '^(MemTotal|MemAvailable):' /proc/meminfo 2>/dev/null
+true`
+
+// Stats returns the resource usage of the environment.
+// In container mode, memory, CPUs and load are those of the container engine, since no process outlives a command.
+// In Kubernetes mode, they are those of the node running the pod.
+func (env *Environment) Stats(ctx context.Context) (*EnvironmentStats, error) {
+	var output string
+	if env.IsHost() {
+		cmd := exec.CommandContext(ctx, "sh", "-c", statsScript)
+		cmd.Dir = env.State.Config.Workdir
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get stats: %w", err)
+		}
+		output = string(out)
+	} else if env.IsKubernetes() {
+		pod, err := env.pod()
+		if err != nil {
+			return nil, err
+		}
+		if output, err = pod.output(ctx, nil, env.State.Config.Workdir, "sh", "-c", statsScript); err != nil {
+			return nil, fmt.Errorf("failed to get stats: %w", err)
+		}
+	} else {
+		var err error
+		output, err = env.container().
+			// Usage changes over time, don't cache it
+			WithEnvVariable("CU_STATS_AT", time.Now().String()).
+			WithExec([]string{"sh", "-c", statsScript}).
+			Stdout(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get stats: %w", err)
+		}
+	}
+
+	stats := parseStats(output)
+	stats.Usage = env.Usage()
+	if !env.State.Config.Resources.IsZero() {
+		stats.Limits = env.State.Config.Resources
+	}
+	return stats, nil
+}
+
+func parseStats(output string) *EnvironmentStats {
+	stats := &EnvironmentStats{}
+	kilobytes := func(s string) uint64 {
+		v, _ := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(s), " kB"), 10, 64)
+		return v * 1024
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		value = strings.TrimSpace(value)
+		switch key {
+		case "workdir":
+			stats.WorkdirBytes = kilobytes(value)
+		case "disk":
+			stats.DiskAvailableBytes = kilobytes(value)
+		case "cpus":
+			stats.CPUs, _ = strconv.Atoi(value)
+		case "load":
+			stats.LoadAverage = value
+		case "MemTotal:":
+			stats.MemoryTotalBytes = kilobytes(value)
+		case "MemAvailable:":
+			stats.MemoryAvailableBytes = kilobytes(value)
+		}
+	}
+	return stats
+}
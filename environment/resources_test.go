@@ -0,0 +1,75 @@
+package environment
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourceLimits(t *testing.T) {
+	var none *ResourceLimits
+	flags, err := none.ulimits()
+	require.NoError(t, err)
+	assert.Empty(t, flags)
+
+	flags, err = (&ResourceLimits{CPUTime: "30m", Memory: "4GiB", Disk: "1MB"}).ulimits()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-t 1800", "-v 4194304", "-f 1953"}, flags)
+
+	for _, invalid := range []*ResourceLimits{
+		{CPUTime: "forever"},
+		{CPUTime: "10ms"},
+		{Memory: "lots"},
+		{Disk: "-1GB"},
+	} {
+		assert.Error(t, invalid.Validate(), "%+v", invalid)
+	}
+}
+
+func TestEnvironmentLimit(t *testing.T) {
+	ctx := context.Background()
+	env := newHostEnvironment(t, "env-limits")
+
+	args := []string{"bash", "-c", "make"}
+	assert.Equal(t, args, env.limit(args), "no limits configured")
+
+	env.State.Config.Resources = &ResourceLimits{Disk: "4KB"}
+	assert.Equal(t, []string{"sh", "-c", `ulimit -f 7 && exec "$@"`, "sh", "bash", "-c", "make"}, env.limit(args))
+	assert.Empty(t, env.limit(nil), "default commands are left alone")
+
+	_, _, err := env.Run(ctx, "head -c 1024 /dev/zero > small", "sh", false)
+	require.NoError(t, err)
+	_, _, err = env.Run(ctx, "head -c 65536 /dev/zero > large", "sh", false)
+	require.NoError(t, err)
+
+	small, err := os.Stat(filepath.Join(env.State.Config.Workdir, "small"))
+	require.NoError(t, err)
+	assert.EqualValues(t, 1024, small.Size())
+	large, err := os.Stat(filepath.Join(env.State.Config.Workdir, "large"))
+	require.NoError(t, err)
+	assert.Less(t, large.Size(), int64(65536), "writes past the disk limit should fail")
+}
+
+func TestParseStats(t *testing.T) {
+	stats := parseStats(`workdir 2048
+disk 1048576
+cpus 8
+load 0.52 0.58 0.59
+MemTotal:       16318412 kB
+MemAvailable:    8159206 kB
+`)
+	assert.Equal(t, &EnvironmentStats{
+		WorkdirBytes:         2048 * 1024,
+		DiskAvailableBytes:   1048576 * 1024,
+		MemoryTotalBytes:     16318412 * 1024,
+		MemoryAvailableBytes: 8159206 * 1024,
+		CPUs:                 8,
+		LoadAverage:          "0.52 0.58 0.59",
+	}, stats)
+
+	assert.Equal(t, &EnvironmentStats{}, parseStats("workdir \ncpus \n"), "missing tools leave fields empty")
+}
@@ -0,0 +1,62 @@
+package environment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"dagger.io/dagger"
+)
+
+// Resume picks up an environment whose services were lost with the server that ran them, e.g. when the stdio process
+// of the agent died. In container mode, configured services and background commands that aren't running are started
+// again, background commands on the state they were first started on: their endpoints on the host change.
+// In host mode, background processes outlive the server: the sampling of their usage resumes. In Kubernetes mode,
+// they run in the pod, which outlives the server too.
+// It returns the services it started, along with the services that failed to start again.
+func (env *Environment) Resume(ctx context.Context) ([]*Service, error) {
+	if env.IsHost() {
+		for _, bp := range env.State.BackgroundProcesses {
+			if isProcessRunning(bp.PID) {
+				env.sampleHostProcess(bp.PID)
+			}
+		}
+		return nil, nil
+	}
+	if env.IsKubernetes() {
+		return nil, nil
+	}
+
+	running := env.RunningServices()
+	isRunning := func(id string) bool {
+		return slices.ContainsFunc(running, func(svc *Service) bool { return svc.ID == id })
+	}
+	services := []*Service{}
+	var errs []error
+	for _, cfg := range env.State.Config.Services {
+		if isRunning(cfg.Name) {
+			continue
+		}
+		svc, err := env.startService(ctx, cfg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("service %s: %w", cfg.Name, err))
+			continue
+		}
+		services = append(services, svc)
+	}
+	for _, bs := range env.State.BackgroundServices {
+		if isRunning(bs.ID) {
+			continue
+		}
+		container := env.dag.LoadContainerFromID(dagger.ContainerID(bs.Container))
+		svc, err := env.startBackgroundOn(ctx, container, bs.ID, bs.Command, bs.Shell, bs.Ports, bs.UseEntrypoint)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("background command %s (%s): %w", bs.ID, bs.Command, err))
+			continue
+		}
+		services = append(services, svc)
+	}
+	env.Services = append(env.Services, services...)
+	return services, errors.Join(errs...)
+}
@@ -0,0 +1,40 @@
+package environment
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumeRunningServices(t *testing.T) {
+	ctx := context.Background()
+	env := newHostEnvironment(t, "env-resume")
+	env.State.Config.BaseImage = "postgres"
+	env.State.Config.Services = ServiceConfigs{{Name: "db", Image: "postgres:17"}}
+	env.State.BackgroundServices = []BackgroundService{
+		{ID: "happy-server", Command: "npm start", Shell: "sh", Ports: []int{3000}},
+		{ID: "quiet-worker", Command: "npm run worker", Shell: "sh"},
+	}
+	env.track(&Service{ID: "db", Config: env.State.Config.Services[0]})
+	env.track(&Service{ID: "happy-server", Config: &ServiceConfig{Command: "npm start"}})
+	env.track(&Service{ID: "quiet-worker", Config: &ServiceConfig{Command: "npm run worker"}})
+
+	services, err := env.Resume(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, services, "running services aren't started again")
+
+	require.NoError(t, env.StopProcess(ctx, "happy-server"))
+	require.Len(t, env.State.BackgroundServices, 1, "stopped background commands aren't resumed")
+	assert.Equal(t, "quiet-worker", env.State.BackgroundServices[0].ID)
+}
+
+func TestResumeHost(t *testing.T) {
+	env := newHostEnvironment(t, "env-resume-host")
+	env.State.BackgroundProcesses = []BackgroundProcess{{PID: 999999, Command: "npm start"}}
+
+	services, err := env.Resume(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, services, "host background processes outlive the server")
+}
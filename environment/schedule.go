@@ -0,0 +1,160 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	petname "github.com/dustinkirkland/golang-petname"
+)
+
+const (
+	// maxScheduleHistory is the number of runs remembered per scheduled command
+	maxScheduleHistory = 20
+	// maxScheduleOutput is the size of the tail of the output recorded per run
+	maxScheduleOutput = 4 * 1024
+)
+
+// Schedule is a command run periodically in the environment (e.g. refreshing fixtures nightly).
+// Schedules are recorded in the environment state and run by the scheduler (container-use schedule run).
+type Schedule struct {
+	ID        string        `json:"id"`
+	Cron      string        `json:"cron"`
+	Command   string        `json:"command"`
+	Shell     string        `json:"shell"`
+	CreatedAt time.Time     `json:"created_at"`
+	History   []ScheduleRun `json:"history,omitempty"`
+}
+
+// ScheduleRun is the outcome of one run of a scheduled command
+type ScheduleRun struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	ExitCode   int       `json:"exit_code"`
+	Error      string    `json:"error,omitempty"`
+	// Output is the tail of the combined output of the command
+	Output string `json:"output,omitempty"`
+}
+
+func (r *ScheduleRun) Failed() bool {
+	return r.Error != "" || r.ExitCode != 0
+}
+
+// LastRun returns the latest run of the command, if any
+func (s *Schedule) LastRun() *ScheduleRun {
+	if len(s.History) == 0 {
+		return nil
+	}
+	return &s.History[len(s.History)-1]
+}
+
+// Next returns when the command is due next, or the zero time if never.
+// Runs missed while the scheduler was stopped are caught up with a single run.
+func (s *Schedule) Next() time.Time {
+	spec, err := parseCron(s.Cron)
+	if err != nil {
+		return time.Time{}
+	}
+	last := s.CreatedAt
+	if run := s.LastRun(); run != nil {
+		last = run.StartedAt
+	}
+	return spec.next(last)
+}
+
+func (s *Schedule) Due(now time.Time) bool {
+	next := s.Next()
+	return !next.IsZero() && !next.After(now)
+}
+
+// AddSchedule registers a command to run in the environment on a cron schedule
+func (env *Environment) AddSchedule(ctx context.Context, cron, command, shell string) (*Schedule, error) {
+	if strings.TrimSpace(command) == "" {
+		return nil, fmt.Errorf("scheduled command is empty")
+	}
+	if _, err := parseCron(cron); err != nil {
+		return nil, err
+	}
+	if err := env.checkCommand(ctx, command); err != nil {
+		return nil, err
+	}
+	if shell == "" {
+		shell = "sh"
+	}
+
+	schedule := &Schedule{
+		ID:        petname.Generate(2, "-"),
+		Cron:      strings.TrimSpace(cron),
+		Command:   command,
+		Shell:     shell,
+		CreatedAt: time.Now(),
+	}
+	env.State.Schedules = append(env.State.Schedules, schedule)
+	env.Notes.Add("Schedule %s (%s)\n$ %s", schedule.ID, schedule.Cron, command)
+	return schedule, nil
+}
+
+// RemoveSchedule stops running a scheduled command
+func (env *Environment) RemoveSchedule(id string) error {
+	i := slices.IndexFunc(env.State.Schedules, func(s *Schedule) bool { return s.ID == id })
+	if i < 0 {
+		return fmt.Errorf("schedule %s not found", id)
+	}
+	env.Notes.Add("Unschedule %s\n$ %s", id, env.State.Schedules[i].Command)
+	env.State.Schedules = slices.Delete(env.State.Schedules, i, i+1)
+	return nil
+}
+
+// DueSchedules returns the scheduled commands due at the given time
+func (env *Environment) DueSchedules(now time.Time) []*Schedule {
+	due := []*Schedule{}
+	for _, s := range env.State.Schedules {
+		if s.Due(now) {
+			due = append(due, s)
+		}
+	}
+	return due
+}
+
+// RunSchedule runs a scheduled command as a job, waits for it and records the run in the schedule history.
+// Failing to run the command is recorded as a failed run rather than returned.
+func (env *Environment) RunSchedule(ctx context.Context, id string) (*ScheduleRun, error) {
+	i := slices.IndexFunc(env.State.Schedules, func(s *Schedule) bool { return s.ID == id })
+	if i < 0 {
+		return nil, fmt.Errorf("schedule %s not found", id)
+	}
+	schedule := env.State.Schedules[i]
+
+	run := ScheduleRun{StartedAt: time.Now()}
+	job, err := env.StartJob(ctx, schedule.Command, schedule.Shell)
+	if err == nil {
+		var result *JobResult
+		if result, err = env.WaitJob(ctx, job.ID); err == nil {
+			run.ExitCode = result.ExitCode
+			run.Error = result.Error
+			run.Output = tail(result.Stdout+result.Stderr, maxScheduleOutput)
+		}
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	run.FinishedAt = time.Now()
+
+	schedule.History = append(schedule.History, run)
+	if len(schedule.History) > maxScheduleHistory {
+		schedule.History = slices.Delete(schedule.History, 0, len(schedule.History)-maxScheduleHistory)
+	}
+	if run.Failed() {
+		env.Notes.Add("Scheduled command %s failed (exit code %d) %s", schedule.ID, run.ExitCode, run.Error)
+	}
+	return &run, nil
+}
+
+func tail(s string, size int) string {
+	if len(s) <= size {
+		return s
+	}
+	return s[len(s)-size:]
+}
@@ -0,0 +1,69 @@
+package environment
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedules(t *testing.T) {
+	ctx := context.Background()
+	env := newHostEnvironment(t, "env-schedules")
+
+	_, err := env.AddSchedule(context.Background(), "whenever", "make fixtures", "sh")
+	assert.Error(t, err)
+	_, err = env.AddSchedule(context.Background(), "@daily", " ", "sh")
+	assert.Error(t, err)
+
+	schedule, err := env.AddSchedule(context.Background(), "0 3 * * *", "echo refreshed >> fixtures", "")
+	require.NoError(t, err)
+	assert.Equal(t, "sh", schedule.Shell)
+	assert.Len(t, env.State.Schedules, 1)
+
+	created := schedule.CreatedAt
+	next := schedule.Next()
+	assert.Equal(t, 3, next.Hour())
+	assert.True(t, next.After(created))
+	assert.Empty(t, env.DueSchedules(next.Add(-time.Minute)))
+	assert.Equal(t, []*Schedule{schedule}, env.DueSchedules(next))
+	assert.Equal(t, []*Schedule{schedule}, env.DueSchedules(next.Add(48*time.Hour)), "missed runs are caught up")
+
+	run, err := env.RunSchedule(ctx, schedule.ID)
+	require.NoError(t, err)
+	assert.False(t, run.Failed())
+	assert.FileExists(t, filepath.Join(env.State.Config.Workdir, "fixtures"))
+	require.Len(t, schedule.History, 1)
+	assert.Equal(t, *run, *schedule.LastRun())
+	assert.Empty(t, env.DueSchedules(run.FinishedAt), "the next run is computed from the last one")
+
+	failing, err := env.AddSchedule(context.Background(), "@hourly", "echo broken; exit 2", "sh")
+	require.NoError(t, err)
+	run, err = env.RunSchedule(ctx, failing.ID)
+	require.NoError(t, err)
+	assert.True(t, run.Failed())
+	assert.Equal(t, 2, run.ExitCode)
+	assert.Equal(t, "broken\n", run.Output)
+	assert.Contains(t, env.Notes.String(), "Scheduled command "+failing.ID+" failed")
+
+	for range maxScheduleHistory {
+		_, err = env.RunSchedule(ctx, failing.ID)
+		require.NoError(t, err)
+	}
+	assert.Len(t, failing.History, maxScheduleHistory)
+
+	require.NoError(t, env.RemoveSchedule(schedule.ID))
+	assert.Equal(t, []*Schedule{failing}, env.State.Schedules)
+	assert.Error(t, env.RemoveSchedule(schedule.ID))
+	_, err = env.RunSchedule(ctx, schedule.ID)
+	assert.Error(t, err)
+}
+
+func TestTail(t *testing.T) {
+	assert.Equal(t, "short", tail("short", 10))
+	assert.Equal(t, strings.Repeat("b", 4), tail("aaaa"+strings.Repeat("b", 4), 4))
+}
@@ -0,0 +1,223 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+const (
+	defaultSearchMaxResults = 100
+	maxSearchFileSize       = 1024 * 1024 // 1MB
+)
+
+type FileSearchOptions struct {
+	// Pattern is a regular expression (RE2 syntax)
+	Pattern string
+	// Path is the directory to search, absolute or relative to the workdir. Defaults to the workdir.
+	Path            string
+	CaseInsensitive bool
+	// Include and Exclude are glob patterns (e.g. `**/*.go`) matched against paths relative to Path
+	Include    []string
+	Exclude    []string
+	MaxResults int
+}
+
+type FileSearchMatch struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Text    string `json:"text"`
+	Context string `json:"context"`
+}
+
+type FileSearchResult struct {
+	Matches   []FileSearchMatch `json:"matches"`
+	Truncated bool              `json:"truncated,omitempty"`
+}
+
+// FileSearch greps the environment's files for a regular expression without running commands in the environment
+func (env *Environment) FileSearch(ctx context.Context, opts FileSearchOptions) (*FileSearchResult, error) {
+	expr := opts.Pattern
+	if opts.CaseInsensitive {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+	if opts.MaxResults <= 0 {
+		opts.MaxResults = defaultSearchMaxResults
+	}
+
+	if env.IsHost() {
+		root, err := env.hostPath(opts.Path, false)
+		if err != nil {
+			return nil, err
+		}
+		return searchDir(root, re, opts)
+	}
+
+	root := opts.Path
+	if root == "" {
+		root = env.State.Config.Workdir
+	}
+	// Filters are pushed down to dagger so only the files to search are exported.
+	// Patterns are normalized to paths anchored at the root on both sides, where
+	// dagger and compileGlobs agree on `*`, `**` and `?`.
+	filter := dagger.DirectoryFilterOpts{
+		Exclude: append(normalizeGlobs(opts.Exclude), "**/.git"),
+	}
+	if len(opts.Include) > 0 {
+		filter.Include = normalizeGlobs(opts.Include)
+	}
+	dir := env.container().Directory(root).Filter(filter)
+
+	tmp, err := os.MkdirTemp("", "container-use-search-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	if _, err := dir.Export(ctx, tmp); err != nil {
+		return nil, fmt.Errorf("failed to load files: %w", err)
+	}
+
+	return searchDir(tmp, re, opts)
+}
+
+func searchDir(root string, re *regexp.Regexp, opts FileSearchOptions) (*FileSearchResult, error) {
+	include := compileGlobs(opts.Include)
+	exclude := compileGlobs(opts.Exclude)
+
+	result := &FileSearchResult{Matches: []FileSearchMatch{}}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.Name() == ".git" {
+			// .git is a file in worktrees
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if rel != "." && matchAny(exclude, rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || matchAny(exclude, rel) {
+			return nil
+		}
+		if len(include) > 0 && !matchAny(include, rel) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil || info.Size() > maxSearchFileSize {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || slices.Contains(data, 0) {
+			// Skip unreadable and binary files
+			return nil
+		}
+
+		lines := strings.Split(string(data), "\n")
+		for i, line := range lines {
+			if re.MatchString(line) {
+				if len(result.Matches) >= opts.MaxResults {
+					result.Truncated = true
+					return filepath.SkipAll
+				}
+				result.Matches = append(result.Matches, FileSearchMatch{
+					File:    rel,
+					Line:    i + 1,
+					Text:    line,
+					Context: getLinesContext(lines, i),
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// normalizeGlobs anchors glob patterns at the search root.
+// Patterns without a `/` match the file name at any depth (like .gitignore).
+func normalizeGlobs(patterns []string) []string {
+	res := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(strings.TrimPrefix(filepath.ToSlash(pattern), "./"), "/")
+		if !strings.Contains(pattern, "/") {
+			pattern = "**/" + pattern
+		}
+		res = append(res, pattern)
+	}
+	return res
+}
+
+// compileGlobs converts glob patterns into regular expressions.
+// `**` matches any number of directories, `*` and `?` don't match `/`.
+// A pattern matching a directory also matches everything below it.
+func compileGlobs(patterns []string) []*regexp.Regexp {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range normalizeGlobs(patterns) {
+
+		var expr strings.Builder
+		expr.WriteString("^")
+		for i := 0; i < len(pattern); i++ {
+			switch c := pattern[i]; c {
+			case '*':
+				if i+1 < len(pattern) && pattern[i+1] == '*' {
+					i++
+					if i+1 < len(pattern) && pattern[i+1] == '/' {
+						i++
+						expr.WriteString("(?:.*/)?")
+					} else {
+						expr.WriteString(".*")
+					}
+				} else {
+					expr.WriteString("[^/]*")
+				}
+			case '?':
+				expr.WriteString("[^/]")
+			default:
+				expr.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		}
+		// A pattern matching a directory also matches everything below it
+		expr.WriteString("(?:/.*)?$")
+
+		if re, err := regexp.Compile(expr.String()); err == nil {
+			res = append(res, re)
+		}
+	}
+	return res
+}
+
+func matchAny(globs []*regexp.Regexp, path string) bool {
+	for _, re := range globs {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
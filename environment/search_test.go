@@ -0,0 +1,85 @@
+package environment
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompileGlobs(t *testing.T) {
+	scenarios := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "cmd/app/main.go", true},
+		{"*.go", "main.gox", false},
+		{"cmd/*.go", "cmd/main.go", true},
+		{"cmd/*.go", "cmd/app/main.go", false},
+		{"cmd/**/*.go", "cmd/app/main.go", true},
+		{"cmd/**/*.go", "cmd/main.go", true},
+		{"node_modules", "node_modules/foo/index.js", true},
+		{"vendor/", "vendor/foo.go", true},
+		{"?.txt", "a.txt", true},
+		{"?.txt", "ab.txt", false},
+		{"./src/", "src/app.ts", true},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.pattern+"_"+scenario.path, func(t *testing.T) {
+			globs := compileGlobs([]string{scenario.pattern})
+			require.Len(t, globs, 1)
+			assert.Equal(t, scenario.match, matchAny(globs, scenario.path))
+		})
+	}
+}
+
+func TestSearchDir(t *testing.T) {
+	root := t.TempDir()
+	writeSearchFile(t, root, "main.go", "package main\n\nfunc main() {\n\tprintln(\"Hello\")\n}\n")
+	writeSearchFile(t, root, "lib/util.go", "package lib\n\n// hello helper\nfunc Hello() {}\n")
+	writeSearchFile(t, root, "README.md", "# hello\n")
+	writeSearchFile(t, root, "bin/tool", "hello\x00binary")
+	// Worktrees have a .git file rather than a directory
+	writeSearchFile(t, root, ".git", "gitdir: /repos/Hello/worktrees/env")
+
+	t.Run("case_sensitive", func(t *testing.T) {
+		result, err := searchDir(root, regexp.MustCompile("Hello"), FileSearchOptions{MaxResults: 10})
+		require.NoError(t, err)
+		require.Len(t, result.Matches, 2)
+		assert.Equal(t, "lib/util.go", result.Matches[0].File)
+		assert.Equal(t, 4, result.Matches[0].Line)
+		assert.Equal(t, "main.go", result.Matches[1].File)
+		assert.Contains(t, result.Matches[1].Context, ">    4 | ")
+	})
+
+	t.Run("include_exclude", func(t *testing.T) {
+		result, err := searchDir(root, regexp.MustCompile("(?i)hello"), FileSearchOptions{
+			Include:    []string{"*.go"},
+			Exclude:    []string{"lib"},
+			MaxResults: 10,
+		})
+		require.NoError(t, err)
+		require.Len(t, result.Matches, 1)
+		assert.Equal(t, "main.go", result.Matches[0].File)
+	})
+
+	t.Run("max_results", func(t *testing.T) {
+		result, err := searchDir(root, regexp.MustCompile("(?i)hello"), FileSearchOptions{MaxResults: 2})
+		require.NoError(t, err)
+		assert.Len(t, result.Matches, 2)
+		assert.True(t, result.Truncated)
+	})
+}
+
+func writeSearchFile(t *testing.T, root, path, contents string) {
+	t.Helper()
+	full := filepath.Join(root, path)
+	require.NoError(t, os.MkdirAll(filepath.Dir(full), 0755))
+	require.NoError(t, os.WriteFile(full, []byte(contents), 0644))
+}
@@ -0,0 +1,150 @@
+package environment
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"dagger.io/dagger"
+)
+
+// SecretProvider resolves the secret references of a scheme (e.g. op://vault/item/field) to their value.
+// Secrets are resolved when containers are built or host commands run: the configuration and
+// state of environments only ever hold their references.
+type SecretProvider interface {
+	// Resolve returns the value of the secret at path, the reference without its scheme
+	Resolve(ctx context.Context, path string) (string, error)
+}
+
+var (
+	secretProvidersMu sync.RWMutex
+	secretProviders   = map[string]SecretProvider{
+		"env":   envSecretProvider{},
+		"file":  fileSecretProvider{},
+		"op":    onePasswordSecretProvider{},
+		"vault": vaultSecretProvider{},
+	}
+	// daggerSecretSchemes are also resolved by the Dagger client. Containers using them
+	// reference the secret rather than its value, so they can be reloaded in later sessions.
+	daggerSecretSchemes = map[string]bool{"env": true, "file": true, "op": true, "vault": true}
+)
+
+// RegisterSecretProvider makes references of the scheme resolved by provider, replacing any previous provider
+func RegisterSecretProvider(scheme string, provider SecretProvider) {
+	secretProvidersMu.Lock()
+	defer secretProvidersMu.Unlock()
+	secretProviders[scheme] = provider
+	delete(daggerSecretSchemes, scheme)
+}
+
+// parseSecretRef splits a secret reference into its scheme and path.
+// References without a scheme name an environment variable of the host.
+func parseSecretRef(ref string) (string, string) {
+	scheme, path, found := strings.Cut(ref, "://")
+	if !found {
+		return "env", ref
+	}
+	return scheme, path
+}
+
+// ResolveSecret returns the value of a secret reference
+func ResolveSecret(ctx context.Context, ref string) (string, error) {
+	scheme, path := parseSecretRef(ref)
+	secretProvidersMu.RLock()
+	provider, ok := secretProviders[scheme]
+	secretProvidersMu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unsupported secret reference %q: no provider for %s://", ref, scheme)
+	}
+	value, err := provider.Resolve(ctx, path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve secret %s: %w", ref, err)
+	}
+	return value, nil
+}
+
+// daggerSecret returns the secret of a reference for containers
+func daggerSecret(ctx context.Context, dag *dagger.Client, ref string) (*dagger.Secret, error) {
+	scheme, path := parseSecretRef(ref)
+	secretProvidersMu.RLock()
+	native := daggerSecretSchemes[scheme]
+	secretProvidersMu.RUnlock()
+	if native {
+		return dag.Secret(scheme + "://" + path), nil
+	}
+
+	value, err := ResolveSecret(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	// The value is only sent to the engine, under a name derived from the reference
+	name := sha256.Sum256([]byte(ref))
+	return dag.SetSecret("container-use-"+hex.EncodeToString(name[:8]), value), nil
+}
+
+type envSecretProvider struct{}
+
+func (envSecretProvider) Resolve(_ context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+type fileSecretProvider struct{}
+
+func (fileSecretProvider) Resolve(_ context.Context, path string) (string, error) {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, rest)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// onePasswordSecretProvider reads secrets with the 1Password CLI, which must be signed in
+type onePasswordSecretProvider struct{}
+
+func (onePasswordSecretProvider) Resolve(ctx context.Context, path string) (string, error) {
+	return runSecretCommand(ctx, "op", "read", "--no-newline", "op://"+path)
+}
+
+// vaultSecretProvider reads secrets with the HashiCorp Vault CLI, which must be authenticated.
+// References are of the form vault://path.field, e.g. vault://credentials.github.
+type vaultSecretProvider struct{}
+
+func (vaultSecretProvider) Resolve(ctx context.Context, path string) (string, error) {
+	i := strings.LastIndex(path, ".")
+	if i <= 0 || i == len(path)-1 {
+		return "", fmt.Errorf("invalid vault reference %q: expected vault://path.field", path)
+	}
+	return runSecretCommand(ctx, "vault", "kv", "get", "-field="+path[i+1:], path[:i])
+}
+
+func runSecretCommand(ctx context.Context, name string, args ...string) (string, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return "", fmt.Errorf("%s CLI not found: %w", name, err)
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = os.Environ()
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
@@ -0,0 +1,78 @@
+package environment
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticSecretProvider map[string]string
+
+func (p staticSecretProvider) Resolve(_ context.Context, path string) (string, error) {
+	value, ok := p[path]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return value, nil
+}
+
+func TestResolveSecret(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("CU_TEST_TOKEN", "s3cr3t")
+
+	secretFile := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(secretFile, []byte("-----KEY-----\n"), 0600))
+
+	RegisterSecretProvider("test", staticSecretProvider{"team/api": "from-test"})
+	t.Cleanup(func() {
+		secretProvidersMu.Lock()
+		delete(secretProviders, "test")
+		secretProvidersMu.Unlock()
+	})
+
+	for _, tc := range []struct {
+		ref   string
+		value string
+	}{
+		{"env://CU_TEST_TOKEN", "s3cr3t"},
+		{"CU_TEST_TOKEN", "s3cr3t"},
+		{"file://" + secretFile, "-----KEY-----\n"},
+		{"test://team/api", "from-test"},
+	} {
+		t.Run(tc.ref, func(t *testing.T) {
+			value, err := ResolveSecret(ctx, tc.ref)
+			require.NoError(t, err)
+			assert.Equal(t, tc.value, value)
+		})
+	}
+
+	for _, ref := range []string{"env://CU_TEST_UNSET", "unknown://x", "test://team/missing", "vault://no-field"} {
+		t.Run(ref, func(t *testing.T) {
+			_, err := ResolveSecret(ctx, ref)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestBuildHostEnvResolvesSecrets(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("CU_TEST_TOKEN", "s3cr3t")
+
+	env := &Environment{EnvironmentInfo: &EnvironmentInfo{State: &State{Config: &EnvironmentConfig{
+		Env:     []string{"MODE=dev"},
+		Secrets: []string{"TOKEN=env://CU_TEST_TOKEN"},
+	}}}}
+	hostEnv, err := env.buildHostEnv(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, hostEnv, "MODE=dev")
+	assert.Contains(t, hostEnv, "TOKEN=s3cr3t")
+
+	env.State.Config.Secrets = []string{"TOKEN=env://CU_TEST_UNSET"}
+	_, err = env.buildHostEnv(ctx)
+	assert.Error(t, err, "unresolved secrets fail the command rather than being silently dropped")
+}
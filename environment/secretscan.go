@@ -0,0 +1,237 @@
+package environment
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Secret scan modes of the configuration
+const (
+	// SecretScanWarn reports the secrets found, without blocking the changes. This is the default.
+	SecretScanWarn = "warn"
+	// SecretScanBlock refuses files and merges with secrets
+	SecretScanBlock = "block"
+	SecretScanOff   = "off"
+)
+
+// secretAllowMarker marks a line whose secret-looking value is known to be safe, such as a test fixture.
+// gitleaks' marker is honored as well.
+const secretAllowMarker = "container-use:allow-secret"
+
+// secretRule is a gitleaks-style rule: a pattern, and for generic assignments, the entropy its secret must have
+type secretRule struct {
+	id      string
+	pattern *regexp.Regexp
+	// group is the capture group holding the secret, 0 for the whole match
+	group      int
+	minEntropy float64
+}
+
+var secretRules = []secretRule{
+	{id: "private-key", pattern: regexp.MustCompile(`-----BEGIN[ A-Z0-9_-]{0,100}PRIVATE KEY( BLOCK)?-----`)},
+	{id: "aws-access-key-id", pattern: regexp.MustCompile(`\b(AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`)},
+	{id: "github-token", pattern: regexp.MustCompile(`\bgh[pousr]_[0-9A-Za-z]{36,255}\b`)},
+	{id: "github-fine-grained-token", pattern: regexp.MustCompile(`\bgithub_pat_[0-9A-Za-z_]{82}\b`)},
+	{id: "gitlab-token", pattern: regexp.MustCompile(`\bglpat-[0-9A-Za-z_-]{20}\b`)},
+	{id: "slack-token", pattern: regexp.MustCompile(`\bxox[baprs]-[0-9A-Za-z-]{10,}\b`)},
+	{id: "slack-webhook", pattern: regexp.MustCompile(`https://hooks\.slack\.com/services/[A-Za-z0-9+/]{40,}`)},
+	{id: "stripe-secret-key", pattern: regexp.MustCompile(`\b(sk|rk)_live_[0-9A-Za-z]{24,}\b`)},
+	{id: "google-api-key", pattern: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{id: "anthropic-api-key", pattern: regexp.MustCompile(`\bsk-ant-[0-9A-Za-z_-]{32,}`)},
+	{id: "openai-api-key", pattern: regexp.MustCompile(`\bsk-(proj-)?[0-9A-Za-z_-]{20,}T3BlbkFJ[0-9A-Za-z_-]{20,}`)},
+	{id: "npm-token", pattern: regexp.MustCompile(`\bnpm_[0-9A-Za-z]{36}\b`)},
+	{
+		id:         "generic-secret",
+		pattern:    regexp.MustCompile(`(?i)(?:password|passwd|secret|token|api[_-]?key|access[_-]?key|client[_-]?secret)[A-Za-z0-9_-]*["']?\s*(?::|=|:=|=>)\s*["']([^"'\s]{12,})["']`),
+		group:      1,
+		minEntropy: 3.5,
+	},
+}
+
+// secretPlaceholders are found in values that only look like secrets
+var secretPlaceholders = []string{"example", "xxxx", "changeme", "placeholder", "your", "dummy", "${", "{{", "<", "process.env", "os.environ", "getenv"}
+
+// SecretFinding is a credential found in a file written by an agent
+type SecretFinding struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Rule string `json:"rule"`
+	// Secret is redacted, only its first characters are kept to recognize it
+	Secret string `json:"secret"`
+}
+
+func (f SecretFinding) String() string {
+	return fmt.Sprintf("%s:%d: %s (%s)", f.File, f.Line, f.Rule, f.Secret)
+}
+
+// SecretsFoundError is returned when the secret scan blocks changes
+type SecretsFoundError struct {
+	Findings []SecretFinding
+}
+
+func (e *SecretsFoundError) Error() string {
+	return fmt.Sprintf("possible secrets found, refusing to write them to the repository:\n%s\n"+
+		"Read credentials from environment variables or secrets instead. If these are not real secrets, mark their lines with a %q comment.",
+		FormatSecretFindings(e.Findings), secretAllowMarker)
+}
+
+// FormatSecretFindings lists findings, one per line
+func FormatSecretFindings(findings []SecretFinding) string {
+	lines := make([]string, len(findings))
+	for i, f := range findings {
+		lines[i] = "  " + f.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ValidateSecretScan checks a secret scan mode
+func ValidateSecretScan(mode string) error {
+	switch mode {
+	case "", SecretScanWarn, SecretScanBlock, SecretScanOff:
+		return nil
+	}
+	return fmt.Errorf("invalid secret scan mode %q: expected %s, %s or %s", mode, SecretScanWarn, SecretScanBlock, SecretScanOff)
+}
+
+// redactSecret keeps the start of a secret, enough to tell which one it is
+func redactSecret(secret string) string {
+	keep := min(len(secret)/4, 6)
+	return secret[:keep] + strings.Repeat("*", 8)
+}
+
+// shannonEntropy is the entropy of a string, in bits per character
+func shannonEntropy(s string) float64 {
+	counts := map[rune]int{}
+	for _, r := range s {
+		counts[r]++
+	}
+	entropy := 0.0
+	n := float64(len([]rune(s)))
+	for _, count := range counts {
+		p := float64(count) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// scanSecretLine returns the secrets found in a line of a file
+func scanSecretLine(file string, lineNumber int, line string) []SecretFinding {
+	if strings.Contains(line, secretAllowMarker) || strings.Contains(line, "gitleaks:allow") {
+		return nil
+	}
+	findings := []SecretFinding{}
+	for _, rule := range secretRules {
+		for _, match := range rule.pattern.FindAllStringSubmatch(line, -1) {
+			secret := match[rule.group]
+			if rule.minEntropy > 0 {
+				if shannonEntropy(secret) < rule.minEntropy || containsAny(strings.ToLower(secret), secretPlaceholders) {
+					continue
+				}
+			}
+			findings = append(findings, SecretFinding{File: file, Line: lineNumber, Rule: rule.id, Secret: redactSecret(secret)})
+		}
+	}
+	return findings
+}
+
+func containsAny(s string, substrings []string) bool {
+	for _, sub := range substrings {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// ScanSecrets returns the secrets found in the contents of a file. Line numbers start at firstLine, for contents
+// inserted in the middle of a file.
+func ScanSecrets(file, contents string, firstLine int) []SecretFinding {
+	findings := []SecretFinding{}
+	for i, line := range strings.Split(contents, "\n") {
+		findings = append(findings, scanSecretLine(file, firstLine+i, line)...)
+	}
+	return findings
+}
+
+var hunkHeaderRe = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+
+// ScanDiffSecrets returns the secrets found in the lines added by a unified diff
+func ScanDiffSecrets(diff string) []SecretFinding {
+	findings := []SecretFinding{}
+	file := ""
+	line := 0
+	// inHeader is set between the start of a file and its first hunk, so added lines starting with "++" aren't file headers
+	inHeader := false
+	scanner := bufio.NewScanner(strings.NewReader(diff))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		text := scanner.Text()
+		switch {
+		case strings.HasPrefix(text, "diff --git "):
+			inHeader = true
+		case inHeader && strings.HasPrefix(text, "+++ "):
+			file = strings.TrimPrefix(strings.TrimPrefix(text, "+++ "), "b/")
+		case strings.HasPrefix(text, "@@"):
+			inHeader = false
+			if m := hunkHeaderRe.FindStringSubmatch(text); m != nil {
+				line, _ = strconv.Atoi(m[1])
+			}
+		case strings.HasPrefix(text, "+"):
+			findings = append(findings, scanSecretLine(file, line, text[1:])...)
+			line++
+		case strings.HasPrefix(text, " "):
+			line++
+		}
+	}
+	return findings
+}
+
+// checkSecrets scans contents an agent is about to write to a file, according to the secret scan mode of the
+// configuration. Blocked secrets are returned as a SecretsFoundError, otherwise they are recorded in the notes.
+func (env *Environment) checkSecrets(file, contents string, firstLine int) error {
+	mode := env.State.Config.SecretScan
+	if mode == SecretScanOff {
+		return nil
+	}
+	return env.handleSecretFindings(ScanSecrets(file, contents, firstLine))
+}
+
+// handleSecretFindings blocks secrets found in what an agent is about to write, or records them in the notes
+func (env *Environment) handleSecretFindings(findings []SecretFinding) error {
+	if len(findings) == 0 {
+		return nil
+	}
+	if env.State.Config.SecretScan == SecretScanBlock {
+		return &SecretsFoundError{Findings: findings}
+	}
+	env.Notes.Add("Warning: possible secrets written:\n%s", FormatSecretFindings(findings))
+	env.mu.Lock()
+	env.secretFindings = append(env.secretFindings, findings...)
+	env.mu.Unlock()
+	return nil
+}
+
+// checkEditSecrets scans the lines of a file changed by replacing the text at index with replace
+func (env *Environment) checkEditSecrets(file, newContents string, index int, replace string) error {
+	start := strings.LastIndex(newContents[:index], "\n") + 1
+	end := index + len(replace)
+	if next := strings.Index(newContents[end:], "\n"); next >= 0 {
+		end += next
+	} else {
+		end = len(newContents)
+	}
+	return env.checkSecrets(file, newContents[start:end], strings.Count(newContents[:start], "\n")+1)
+}
+
+// PopSecretFindings returns the secrets written since the last call, when the secret scan only warns about them
+func (env *Environment) PopSecretFindings() []SecretFinding {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	findings := env.secretFindings
+	env.secretFindings = nil
+	return findings
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"sync"
 	"time"
 
 	"dagger.io/dagger"
@@ -11,13 +13,29 @@ import (
 
 var (
 	serviceStartTimeout = 30 * time.Second
+
+	defaultHealthcheckInterval = 2 * time.Second
+	defaultHealthcheckTimeout  = 10 * time.Second
+	defaultHealthcheckRetries  = 15
+)
+
+// runningServices are the container services started by this server, by environment.
+// Like jobs, they live as long as the server that started them.
+var (
+	runningServices   = map[string][]*Service{}
+	runningServicesMu sync.Mutex
 )
 
 type Service struct {
+	// ID is the name of configured services, or generated for background commands
+	ID        string           `json:"id"`
 	Config    *ServiceConfig   `json:"config"`
 	Endpoints EndpointMappings `json:"endpoints"`
+	StartedAt time.Time        `json:"started_at"`
 
-	svc *dagger.Service
+	envID   string
+	svc     *dagger.Service
+	tunnels []*dagger.Service
 }
 
 type EndpointMapping struct {
@@ -48,11 +66,19 @@ func (env *Environment) startService(ctx context.Context, cfg *ServiceConfig) (*
 		// Not supported in host mode
 		return &Service{Config: cfg, Endpoints: EndpointMappings{}}, nil
 	}
-	container := env.dag.Container().From(cfg.Image)
-	container, err := containerWithEnvAndSecrets(env.dag, container, cfg.Env, env.State.Config.Secrets)
+	if err := requireNetwork("pulling service image " + cfg.Image); err != nil {
+		return nil, err
+	}
+	return env.startServiceFromContainer(ctx, cfg, env.dag.Container().From(cfg.Image))
+}
+
+// startServiceFromContainer starts a service on top of the provided container (e.g. an image built in the environment)
+func (env *Environment) startServiceFromContainer(ctx context.Context, cfg *ServiceConfig, container *dagger.Container) (*Service, error) {
+	container, err := containerWithEnvAndSecrets(ctx, env.dag, container, cfg.Env, env.State.Config.Secrets)
 	if err != nil {
 		return nil, err
 	}
+	base := container
 
 	if cfg.Command != "" {
 		container = container.WithExec([]string{"sh", "-c", cfg.Command})
@@ -60,7 +86,7 @@ func (env *Environment) startService(ctx context.Context, cfg *ServiceConfig) (*
 
 	args := []string{}
 	if cfg.Command != "" {
-		args = []string{"sh", "-c", cfg.Command}
+		args = env.limit([]string{"sh", "-c", cfg.Command})
 	}
 
 	// Expose ports
@@ -76,7 +102,7 @@ func (env *Environment) startService(ctx context.Context, cfg *ServiceConfig) (*
 	defer cancel()
 	svc, err := container.AsService(dagger.ContainerAsServiceOpts{
 		Args:          args,
-		UseEntrypoint: true,
+		UseEntrypoint: !cfg.NoEntrypoint,
 	}).Start(startCtx)
 	if err != nil {
 		var exitErr *dagger.ExecError
@@ -89,44 +115,147 @@ func (env *Environment) startService(ctx context.Context, cfg *ServiceConfig) (*
 		return nil, err
 	}
 
-	endpoints := EndpointMappings{}
+	if cfg.Healthcheck != nil {
+		if err := waitHealthy(ctx, cfg, base, svc); err != nil {
+			return nil, err
+		}
+	}
+
+	service := &Service{
+		ID:        cfg.Name,
+		Config:    cfg,
+		Endpoints: EndpointMappings{},
+		StartedAt: time.Now(),
+		svc:       svc,
+	}
 	for _, port := range cfg.ExposedPorts {
-		endpoint := &EndpointMapping{
+		// Expose ports on the host
+		tunnel, externalEndpoint, err := env.tunnel(ctx, svc, port)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get endpoint for service %s: %w", cfg.Name, err)
+		}
+		service.tunnels = append(service.tunnels, tunnel)
+		service.Endpoints[port] = &EndpointMapping{
 			EnvironmentInternal: fmt.Sprintf("tcp://%s:%d", cfg.Name, port),
+			HostExternal:        externalEndpoint,
 		}
-		endpoints[port] = endpoint
+	}
 
-		// Expose ports on the host
-		tunnel, err := env.dag.Host().Tunnel(svc, dagger.HostTunnelOpts{
-			Ports: []dagger.PortForward{
-				{
-					Backend:  port,
-					Frontend: 0,
-					Protocol: dagger.NetworkProtocolTcp,
-				},
+	env.track(service)
+	env.sampleServices(container)
+	return service, nil
+}
+
+// track records a running service, replacing the previous instance of a restarted service
+func (env *Environment) track(service *Service) {
+	runningServicesMu.Lock()
+	defer runningServicesMu.Unlock()
+
+	service.envID = env.ID
+	services := slices.DeleteFunc(runningServices[env.ID], func(s *Service) bool { return s.ID == service.ID })
+	runningServices[env.ID] = append(services, service)
+}
+
+// RunningServices returns the container services started for the environment and not stopped since, oldest first
+func (env *Environment) RunningServices() []*Service {
+	runningServicesMu.Lock()
+	defer runningServicesMu.Unlock()
+
+	return slices.Clone(runningServices[env.ID])
+}
+
+// tunnel exposes a port of a service on the host and returns the tunnel and its endpoint
+func (env *Environment) tunnel(ctx context.Context, svc *dagger.Service, port int) (*dagger.Service, string, error) {
+	tunnel, err := env.dag.Host().Tunnel(svc, dagger.HostTunnelOpts{
+		Ports: []dagger.PortForward{
+			{
+				Backend:  port,
+				Frontend: 0,
+				Protocol: dagger.NetworkProtocolTcp,
 			},
-		}).Start(ctx)
-		if err != nil {
-			return nil, err
+		},
+	}).Start(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	endpoint, err := tunnel.Endpoint(ctx, dagger.ServiceEndpointOpts{
+		Scheme: "tcp",
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return tunnel, endpoint, nil
+}
+
+// Stop stops the service along with the tunnels exposing it on the host
+func (s *Service) Stop(ctx context.Context) error {
+	runningServicesMu.Lock()
+	runningServices[s.envID] = slices.DeleteFunc(runningServices[s.envID], func(running *Service) bool { return running == s })
+	runningServicesMu.Unlock()
+
+	var errs []error
+	for _, tunnel := range s.tunnels {
+		if _, err := tunnel.Stop(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if s.svc != nil {
+		if _, err := s.svc.Stop(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// waitHealthy runs the healthcheck of a service until it succeeds or runs out of retries
+func waitHealthy(ctx context.Context, cfg *ServiceConfig, base *dagger.Container, svc *dagger.Service) error {
+	hc := cfg.Healthcheck
+	interval, timeout, retries := hc.Interval, hc.Timeout, hc.Retries
+	if interval <= 0 {
+		interval = defaultHealthcheckInterval
+	}
+	if timeout <= 0 {
+		timeout = defaultHealthcheckTimeout
+	}
+	if retries <= 0 {
+		retries = defaultHealthcheckRetries
+	}
+
+	check := base.WithServiceBinding(cfg.Name, svc)
+	var lastErr error
+	for attempt := range retries {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(interval):
+			}
 		}
 
-		externalEndpoint, err := tunnel.Endpoint(ctx, dagger.ServiceEndpointOpts{
-			Scheme: "tcp",
-		})
-		if err != nil {
-			return nil, fmt.Errorf("failed to get endpoint for service %s: %w", cfg.Name, err)
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		_, err := check.
+			// bust the cache so every attempt actually runs
+			WithEnvVariable("CU_HEALTHCHECK_ATTEMPT", time.Now().String()).
+			WithExec([]string{"sh", "-c", hc.Command}).
+			Sync(attemptCtx)
+		cancel()
+		if err == nil {
+			return nil
 		}
-		endpoint.HostExternal = externalEndpoint
+		lastErr = err
 	}
 
-	return &Service{
-		Config:    cfg,
-		Endpoints: endpoints,
-		svc:       svc,
-	}, nil
+	var exitErr *dagger.ExecError
+	if errors.As(lastErr, &exitErr) {
+		return fmt.Errorf("service %s is unhealthy after %d attempts: exit code %d.\nstdout: %s\nstderr: %s", cfg.Name, retries, exitErr.ExitCode, exitErr.Stdout, exitErr.Stderr)
+	}
+	return fmt.Errorf("service %s is unhealthy after %d attempts: %w", cfg.Name, retries, lastErr)
 }
 
-func (env *Environment) AddService(ctx context.Context, explanation string, cfg *ServiceConfig) (*Service, error) {
+// AddService starts a service and binds it to the environment.
+// vars are set in the environment to connect to the service (e.g. DATABASE_URL), unless they're already set.
+func (env *Environment) AddService(ctx context.Context, explanation string, cfg *ServiceConfig, vars KVList) (*Service, error) {
 	if env.State.Config.Services.Get(cfg.Name) != nil {
 		return nil, fmt.Errorf("service %s already exists", cfg.Name)
 	}
@@ -142,6 +271,14 @@ func (env *Environment) AddService(ctx context.Context, explanation string, cfg
 		return svc, nil
 	}
 	state := env.container().WithServiceBinding(cfg.Name, svc.svc)
+	for _, key := range vars.Keys() {
+		if env.State.Config.Env.Get(key) != "" {
+			env.Notes.Add("Service %s: %s is already set, not overriding it", cfg.Name, key)
+			continue
+		}
+		env.State.Config.Env.Set(key, vars.Get(key))
+		state = state.WithEnvVariable(key, vars.Get(key))
+	}
 	if err := env.apply(ctx, state); err != nil {
 		return nil, err
 	}
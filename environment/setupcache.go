@@ -0,0 +1,167 @@
+package environment
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+// maxSetupCacheEntries is the number of setup results remembered, least recently used are evicted first
+const maxSetupCacheEntries = 100
+
+// setupCachePath records the containers resulting from setup commands, so environments created
+// from the same base image digest and setup commands reuse them instead of running the commands again.
+var setupCachePath = func() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "container-use", "setup-cache.json")
+}()
+
+// setupCacheMu serializes the cache updates of this process. Concurrent processes may lose entries, never corrupt them.
+var setupCacheMu sync.Mutex
+
+type setupCacheEntry struct {
+	Container string    `json:"container"`
+	UsedAt    time.Time `json:"used_at"`
+}
+
+// setupCacheKey is the checksum of the inputs of the setup commands.
+// The image reference must include its digest, so tags moving to new images don't hit stale entries.
+func setupCacheKey(imageRef, workdir string, commands, env, secrets []string) string {
+	h := sha256.New()
+	for _, part := range [][]string{{imageRef, workdir}, commands, env, secrets} {
+		h.Write([]byte(strings.Join(part, "\x00")))
+		h.Write([]byte{0xff})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func loadSetupCache() map[string]*setupCacheEntry {
+	cache := map[string]*setupCacheEntry{}
+	data, err := os.ReadFile(setupCachePath)
+	if err != nil {
+		return cache
+	}
+	// A corrupted cache is ignored: it is rebuilt as setup commands run
+	_ = json.Unmarshal(data, &cache)
+	return cache
+}
+
+func saveSetupCache(cache map[string]*setupCacheEntry) error {
+	if len(cache) > maxSetupCacheEntries {
+		keys := make([]string, 0, len(cache))
+		for key := range cache {
+			keys = append(keys, key)
+		}
+		slices.SortFunc(keys, func(a, b string) int {
+			return cache[a].UsedAt.Compare(cache[b].UsedAt)
+		})
+		for _, key := range keys[:len(keys)-maxSetupCacheEntries] {
+			delete(cache, key)
+		}
+	}
+
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(setupCachePath), 0700); err != nil {
+		return err
+	}
+	// Write atomically, other processes may be reading the cache
+	f, err := os.CreateTemp(filepath.Dir(setupCachePath), ".setup-cache-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), setupCachePath)
+}
+
+// lookupSetupCache returns the container resulting from the setup commands with the given key, if known
+func lookupSetupCache(key string) (string, bool) {
+	setupCacheMu.Lock()
+	defer setupCacheMu.Unlock()
+
+	cache := loadSetupCache()
+	entry, ok := cache[key]
+	if !ok {
+		return "", false
+	}
+	entry.UsedAt = time.Now()
+	_ = saveSetupCache(cache)
+	return entry.Container, true
+}
+
+// storeSetupCache records the container resulting from the setup commands with the given key
+func storeSetupCache(key, container string) error {
+	setupCacheMu.Lock()
+	defer setupCacheMu.Unlock()
+
+	cache := loadSetupCache()
+	cache[key] = &setupCacheEntry{Container: container, UsedAt: time.Now()}
+	return saveSetupCache(cache)
+}
+
+// deleteSetupCache removes an entry whose container can't be loaded anymore
+func deleteSetupCache(key string) error {
+	setupCacheMu.Lock()
+	defer setupCacheMu.Unlock()
+
+	cache := loadSetupCache()
+	delete(cache, key)
+	return saveSetupCache(cache)
+}
+
+// loadCachedSetup returns the cached result of the setup commands of the environment, if any
+func (env *Environment) loadCachedSetup(ctx context.Context, key, imageRef string) *dagger.Container {
+	if len(env.State.Config.SetupCommands) == 0 {
+		return nil
+	}
+	id, ok := lookupSetupCache(key)
+	if !ok {
+		return nil
+	}
+
+	container := env.dag.LoadContainerFromID(dagger.ContainerID(id))
+	if _, err := container.Sync(ctx); err != nil {
+		slog.WarnContext(ctx, "Failed to load cached setup, running setup commands", "image", imageRef, "err", err)
+		_ = deleteSetupCache(key)
+		return nil
+	}
+	env.Notes.Add("Reuse the result of the setup commands on %s", imageRef)
+	return container
+}
+
+// cacheSetup records the result of the setup commands of the environment.
+// Failing to cache is not an error: the next environment will run the commands again.
+func (env *Environment) cacheSetup(ctx context.Context, key string, container *dagger.Container) {
+	if len(env.State.Config.SetupCommands) == 0 {
+		return
+	}
+	id, err := container.ID(ctx)
+	if err == nil {
+		err = storeSetupCache(key, string(id))
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to cache the result of the setup commands", "err", err)
+	}
+}
@@ -0,0 +1,62 @@
+package environment
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupCacheKey(t *testing.T) {
+	image := "ubuntu:24.04@sha256:aaaa"
+	key := setupCacheKey(image, "/workdir", []string{"apt-get install -y git"}, []string{"DEBIAN_FRONTEND=noninteractive"}, nil)
+	assert.Equal(t, key, setupCacheKey(image, "/workdir", []string{"apt-get install -y git"}, []string{"DEBIAN_FRONTEND=noninteractive"}, nil))
+
+	for _, other := range []string{
+		setupCacheKey("ubuntu:24.04@sha256:bbbb", "/workdir", []string{"apt-get install -y git"}, []string{"DEBIAN_FRONTEND=noninteractive"}, nil),
+		setupCacheKey(image, "/src", []string{"apt-get install -y git"}, []string{"DEBIAN_FRONTEND=noninteractive"}, nil),
+		setupCacheKey(image, "/workdir", []string{"apt-get install -y curl"}, []string{"DEBIAN_FRONTEND=noninteractive"}, nil),
+		setupCacheKey(image, "/workdir", []string{"apt-get install -y git"}, nil, []string{"DEBIAN_FRONTEND=noninteractive"}),
+		setupCacheKey(image, "/workdir", []string{"apt-get install", "-y git"}, []string{"DEBIAN_FRONTEND=noninteractive"}, nil),
+	} {
+		assert.NotEqual(t, key, other)
+	}
+}
+
+func TestSetupCache(t *testing.T) {
+	previous := setupCachePath
+	setupCachePath = filepath.Join(t.TempDir(), "cache", "setup-cache.json")
+	t.Cleanup(func() { setupCachePath = previous })
+
+	_, ok := lookupSetupCache("missing")
+	assert.False(t, ok)
+
+	require.NoError(t, storeSetupCache("key", "container-id"))
+	id, ok := lookupSetupCache("key")
+	assert.True(t, ok)
+	assert.Equal(t, "container-id", id)
+
+	require.NoError(t, deleteSetupCache("key"))
+	_, ok = lookupSetupCache("key")
+	assert.False(t, ok)
+
+	t.Run("eviction", func(t *testing.T) {
+		for i := range maxSetupCacheEntries + 1 {
+			require.NoError(t, storeSetupCache(fmt.Sprintf("key-%d", i), "container-id"))
+		}
+		assert.Len(t, loadSetupCache(), maxSetupCacheEntries)
+		_, ok := lookupSetupCache("key-0")
+		assert.False(t, ok, "least recently used entries are evicted")
+	})
+
+	t.Run("corrupted", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(setupCachePath, []byte("{oops"), 0600))
+		_, ok := lookupSetupCache("key-1")
+		assert.False(t, ok)
+		require.NoError(t, storeSetupCache("key", "container-id"))
+		assert.Len(t, loadSetupCache(), 1)
+	})
+}
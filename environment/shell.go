@@ -0,0 +1,316 @@
+package environment
+
+import (
+	"context"
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"dagger.io/dagger"
+	"github.com/creack/pty"
+)
+
+// shellHelperSource is built for the platform of containers to run their shells on a pseudo-terminal, see StartShell
+//
+//go:embed shellhelper/main.go
+var shellHelperSource string
+
+const (
+	// shellHelperImage builds the shell helper
+	shellHelperImage = "golang:1.24-alpine"
+	// shellHelperPath is where the shell helper is mounted in containers
+	shellHelperPath = "/.container-use/shellhelper"
+	// shellHelperHost is the host name the shell helper reaches the remote terminal at
+	shellHelperHost = "container-use-terminal"
+	// shellRCPath is the rc file of interactive shells in containers
+	shellRCPath = "/cu/rc.sh"
+	// shellTerm is the terminal type of interactive shells, that of the terminals of editors
+	shellTerm = "xterm-256color"
+
+	// Types of the frames sent to the shell helper
+	shellFrameInput  = 0
+	shellFrameResize = 1
+)
+
+// TerminalSize is the size of a terminal in characters
+type TerminalSize struct {
+	Rows uint16 `json:"rows"`
+	Cols uint16 `json:"cols"`
+}
+
+func (s TerminalSize) winsize() *pty.Winsize {
+	return &pty.Winsize{Rows: s.Rows, Cols: s.Cols}
+}
+
+// Shell is an interactive shell running in the environment on a pseudo-terminal, e.g. for remote terminals.
+// Its output is read from it and its input written to it. Unlike commands, the shell keeps its working directory and
+// variables until it exits.
+type Shell interface {
+	io.ReadWriter
+	// Resize changes the size of the terminal of the shell
+	Resize(size TerminalSize) error
+	// Wait waits for the shell to exit and applies the changes it made to the environment
+	Wait(ctx context.Context) error
+	// Close hangs up the shell, and the commands it runs in the foreground
+	Close() error
+}
+
+// StartShell starts an interactive shell in the workdir of the environment.
+// The commands typed in a shell can't be checked one by one: when the repository has a command policy, the user must
+// confirm the shell itself.
+func (env *Environment) StartShell(ctx context.Context, size TerminalSize) (Shell, error) {
+	if err := env.checkShell(ctx, "sh"); err != nil {
+		return nil, err
+	}
+	if size.Rows == 0 || size.Cols == 0 {
+		size = TerminalSize{Rows: 24, Cols: 80}
+	}
+	env.Notes.Add("Start an interactive shell")
+
+	switch {
+	case env.IsHost():
+		args := env.limit([]string{"sh"})
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Dir = env.State.Config.Workdir
+		hostEnv, err := env.buildHostEnv(ctx)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Env = append(hostEnv, "TERM="+shellTerm)
+		return startPTYShell(cmd, size)
+	case env.IsKubernetes():
+		cmd, err := env.podShell(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return startPTYShell(cmd, size)
+	}
+	return env.startContainerShell(ctx, size)
+}
+
+// ptyShell is a shell running on a local pseudo-terminal: host shells, and kubectl attaching to the TTY of pods
+type ptyShell struct {
+	cmd *exec.Cmd
+	pty *os.File
+}
+
+func startPTYShell(cmd *exec.Cmd, size TerminalSize) (*ptyShell, error) {
+	f, err := pty.StartWithSize(cmd, size.winsize())
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the shell: %w", err)
+	}
+	return &ptyShell{cmd: cmd, pty: f}, nil
+}
+
+func (s *ptyShell) Read(p []byte) (int, error) {
+	n, err := s.pty.Read(p)
+	// Reading the terminal fails with EIO once the shell exited
+	if errors.Is(err, syscall.EIO) || errors.Is(err, os.ErrClosed) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (s *ptyShell) Write(p []byte) (int, error) {
+	return s.pty.Write(p)
+}
+
+func (s *ptyShell) Resize(size TerminalSize) error {
+	return pty.Setsize(s.pty, size.winsize())
+}
+
+func (s *ptyShell) Wait(ctx context.Context) error {
+	err := s.cmd.Wait()
+	s.pty.Close()
+	// Interactive shells exit with the status of their last command
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return nil
+	}
+	return err
+}
+
+func (s *ptyShell) Close() error {
+	// Closing the terminal sends SIGHUP to the commands in the foreground
+	err := s.pty.Close()
+	s.cmd.Process.Kill()
+	return err
+}
+
+// containerShell is a shell running in the container of the environment. Only the dagger CLI can attach to the TTY of
+// containers: the shell runs on a pseudo-terminal of the shell helper instead, which connects back to the host.
+type containerShell struct {
+	env      *Environment
+	listener net.Listener
+	conn     net.Conn
+	// state is the container once the shell exited, when done is closed, or err if it couldn't run
+	state *dagger.Container
+	err   error
+	done  chan struct{}
+
+	mu sync.Mutex
+}
+
+func (env *Environment) startContainerShell(ctx context.Context, size TerminalSize) (*containerShell, error) {
+	helper, err := env.shellHelper(ctx)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	cmd, rc := interactiveShell(ctx, env.container())
+	args := append([]string{
+		shellHelperPath,
+		fmt.Sprintf("%s:%d", shellHelperHost, port),
+		strconv.Itoa(int(size.Rows)),
+		strconv.Itoa(int(size.Cols)),
+	}, env.limit(cmd)...)
+	terminal := env.dag.Host().Service([]dagger.PortForward{
+		{
+			Backend:  port,
+			Frontend: port,
+			Protocol: dagger.NetworkProtocolTcp,
+		},
+	}, dagger.HostServiceOpts{Host: "127.0.0.1"})
+	state := env.container().
+		WithServiceBinding(shellHelperHost, terminal).
+		WithMountedFile(shellHelperPath, helper).
+		WithMountedFile(shellRCPath, env.dag.Directory().WithNewFile("rc.sh", rc).File("rc.sh")).
+		WithEnvVariable("ENV", shellRCPath).
+		WithEnvVariable("TERM", shellTerm).
+		WithExec(args, dagger.ContainerWithExecOpts{
+			Expect:                        dagger.ReturnTypeAny, // Interactive shells exit with the status of their last command
+			ExperimentalPrivilegedNesting: true,
+		})
+
+	s := &containerShell{env: env, listener: listener, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		s.state, s.err = state.Sync(ctx)
+	}()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+	select {
+	case s.conn = <-accepted:
+		return s, nil
+	case <-s.done:
+		listener.Close()
+		if s.err != nil {
+			return nil, fmt.Errorf("failed to start the shell: %w", s.err)
+		}
+		stderr, _ := state.Stderr(ctx)
+		return nil, fmt.Errorf("the shell exited before the terminal connected: %s", stderr)
+	case <-ctx.Done():
+		listener.Close()
+		return nil, ctx.Err()
+	}
+}
+
+func (s *containerShell) Read(p []byte) (int, error) {
+	return s.conn.Read(p)
+}
+
+func (s *containerShell) Write(p []byte) (int, error) {
+	if err := s.writeFrame(shellFrameInput, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *containerShell) Resize(size TerminalSize) error {
+	payload := binary.BigEndian.AppendUint16(nil, size.Rows)
+	return s.writeFrame(shellFrameResize, binary.BigEndian.AppendUint16(payload, size.Cols))
+}
+
+func (s *containerShell) writeFrame(frameType byte, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	header := binary.BigEndian.AppendUint32([]byte{frameType}, uint32(len(payload)))
+	_, err := s.conn.Write(append(header, payload...))
+	return err
+}
+
+func (s *containerShell) Wait(ctx context.Context) error {
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.listener.Close()
+	if s.err != nil {
+		return s.err
+	}
+	// Only the files changed by the shell are kept: the container of the environment isn't bound to the terminal
+	rootfs := s.state.WithoutMount(shellHelperPath).WithoutMount(shellRCPath).Rootfs()
+	return s.env.apply(ctx, s.env.container().WithRootfs(rootfs))
+}
+
+func (s *containerShell) Close() error {
+	// The shell helper hangs up the shell once disconnected
+	s.listener.Close()
+	return s.conn.Close()
+}
+
+// shellHelper builds the shell helper for the platform of the container of the environment
+func (env *Environment) shellHelper(ctx context.Context) (*dagger.File, error) {
+	platform, err := env.container().Platform(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the platform of the container: %w", err)
+	}
+	// Platforms are os/arch[/variant], e.g. linux/arm/v7
+	parts := strings.Split(string(platform), "/")
+	if len(parts) < 2 || parts[0] != "linux" {
+		return nil, fmt.Errorf("interactive shells are not supported on %s", platform)
+	}
+	builder := env.dag.Container().
+		From(shellHelperImage).
+		WithNewFile("/src/main.go", shellHelperSource).
+		WithWorkdir("/src").
+		WithEnvVariable("CGO_ENABLED", "0").
+		WithEnvVariable("GOOS", "linux").
+		WithEnvVariable("GOARCH", parts[1])
+	if parts[1] == "arm" && len(parts) > 2 {
+		builder = builder.WithEnvVariable("GOARM", strings.TrimPrefix(parts[2], "v"))
+	}
+	return builder.WithExec([]string{"go", "build", "-o", "/out/shellhelper", "main.go"}).File("/out/shellhelper"), nil
+}
+
+// interactiveShell returns the command of an interactive shell in the container, bash when it has it, and the rc file
+// it must read from shellRCPath. POSIX shells read it from $ENV.
+func interactiveShell(ctx context.Context, container *dagger.Container) ([]string, string) {
+	cmd := []string{"sh"}
+	var sourceRC string
+	if shells, err := container.File("/etc/shells").Contents(ctx); err == nil {
+		for shell := range strings.Lines(shells) {
+			if shell[0] == '#' {
+				continue
+			}
+			shell = strings.TrimRight(shell, "\n")
+			if strings.HasSuffix(shell, "/bash") {
+				sourceRC = fmt.Sprintf("[ -f ~/.bashrc ] && . ~/.bashrc; %q --version | head -4; ", shell)
+				cmd = []string{shell, "--rcfile", shellRCPath, "-i"}
+				break
+			}
+		}
+	}
+	// Try to show the same pretty PS1 as for the default /bin/sh terminal in dagger
+	return cmd, sourceRC + `export PS1="\033[33mcu\033[0m \033[02m\$(pwd | sed \"s|^\$HOME|~|\")\033[0m \$ "` + "\n"
+}
@@ -0,0 +1,186 @@
+//go:build linux
+
+// Command shellhelper runs an interactive shell on a pseudo-terminal in the container of an environment, and relays it
+// to the remote terminal serving it over TCP: the TTY of containers can only be attached to by the dagger CLI.
+// It's built by container-use for the platform of the container and only depends on the standard library.
+//
+// Usage: shellhelper <address> <rows> <cols> <command> [<argument>...]
+//
+// The remote terminal sends frames of a type byte, a big-endian uint32 length and a payload: input for the shell, or
+// the new size of the terminal as big-endian uint16 rows and columns. The output of the shell is relayed as is.
+// The helper exits with the exit code of the shell, killed once the connection is closed.
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Types of the frames sent by the remote terminal
+const (
+	frameInput  = 0
+	frameResize = 1
+
+	maxFrame = 1 << 20
+)
+
+func main() {
+	if len(os.Args) < 5 {
+		fmt.Fprintln(os.Stderr, "usage: shellhelper <address> <rows> <cols> <command> [<argument>...]")
+		os.Exit(2)
+	}
+	exitCode, err := run(os.Args[1], os.Args[2], os.Args[3], os.Args[4:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "shellhelper:", err)
+		os.Exit(1)
+	}
+	os.Exit(exitCode)
+}
+
+func run(address, rows, cols string, args []string) (int, error) {
+	r, err := strconv.ParseUint(rows, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rows %q", rows)
+	}
+	c, err := strconv.ParseUint(cols, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cols %q", cols)
+	}
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	pty, tty, err := openPTY()
+	if err != nil {
+		return 0, err
+	}
+	defer pty.Close()
+	if err := setSize(pty, uint16(r), uint16(c)); err != nil {
+		tty.Close()
+		return 0, err
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	// The shell leads a new session, with the terminal as controlling terminal, so it gets job control and SIGHUP
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	err = cmd.Start()
+	tty.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	output := make(chan struct{})
+	go func() {
+		defer close(output)
+		// Reading the terminal fails with EIO once the shell and its children closed it
+		io.Copy(conn, pty)
+	}()
+	go func() {
+		relayInput(conn, pty)
+		// The remote terminal disconnected: hang up
+		cmd.Process.Signal(syscall.SIGHUP)
+		time.Sleep(time.Second)
+		cmd.Process.Kill()
+	}()
+
+	err = cmd.Wait()
+	// Background children may keep the terminal open: the output they write after the shell exited is dropped
+	select {
+	case <-output:
+	case <-time.After(time.Second):
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	return 0, err
+}
+
+// relayInput writes the input sent by the remote terminal to the shell and resizes its terminal, until the connection
+// is closed
+func relayInput(conn net.Conn, pty *os.File) {
+	var header [5]byte
+	for {
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return
+		}
+		length := binary.BigEndian.Uint32(header[1:])
+		if length > maxFrame {
+			return
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			return
+		}
+		switch header[0] {
+		case frameInput:
+			if _, err := pty.Write(payload); err != nil {
+				return
+			}
+		case frameResize:
+			if len(payload) == 4 {
+				setSize(pty, binary.BigEndian.Uint16(payload), binary.BigEndian.Uint16(payload[2:]))
+			}
+		}
+	}
+}
+
+// openPTY opens a new pseudo-terminal, returning its controlling side and the terminal the shell uses
+func openPTY() (*os.File, *os.File, error) {
+	pty, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var unlock int32
+	if err := ioctl(pty, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		pty.Close()
+		return nil, nil, fmt.Errorf("failed to unlock the terminal: %w", err)
+	}
+	var n uint32
+	if err := ioctl(pty, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		pty.Close()
+		return nil, nil, fmt.Errorf("failed to get the terminal number: %w", err)
+	}
+	tty, err := os.OpenFile("/dev/pts/"+strconv.FormatUint(uint64(n), 10), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		pty.Close()
+		return nil, nil, err
+	}
+	return pty, tty, nil
+}
+
+func setSize(pty *os.File, rows, cols uint16) error {
+	size := struct{ rows, cols, x, y uint16 }{rows, cols, 0, 0}
+	return ioctl(pty, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&size)))
+}
+
+// ioctl runs an ioctl on the file without switching it to blocking mode like File.Fd does
+func ioctl(f *os.File, req, arg uintptr) error {
+	rawConn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	if err := rawConn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package environment
 import (
 	"encoding/json"
 	"fmt"
+	"slices"
 	"time"
 )
 
@@ -12,21 +13,124 @@ type State struct {
 
 	Config    *EnvironmentConfig `json:"config,omitempty"`
 	Container string             `json:"container,omitempty"`
-	Title     string             `json:"title,omitempty"`
+	// Pod runs the environment in Kubernetes mode, in place of the container
+	Pod   *KubernetesPod `json:"pod,omitempty"`
+	Title string         `json:"title,omitempty"`
+
+	Description string `json:"description,omitempty"`
+	// BaseBranch and BaseCommit are the user's branch and commit the environment was forked from.
+	// BaseBranch is empty if the user's HEAD was detached.
+	BaseBranch string `json:"base_branch,omitempty"`
+	BaseCommit string `json:"base_commit,omitempty"`
+	// CommandCount is the number of commands run in the environment so far
+	CommandCount int `json:"command_count,omitempty"`
+	// Summarized is set once the summarizer hook ran for the environment
+	Summarized bool `json:"summarized,omitempty"`
 
 	BackgroundProcesses []BackgroundProcess `json:"background_processes,omitempty"`
+	// BackgroundServices are the background commands run as services in container mode, started again by Resume
+	BackgroundServices []BackgroundService `json:"background_services,omitempty"`
+	// BuiltServices are the services started from images built in the environment.
+	// They are bound to the container state, not recorded in the configuration.
+	BuiltServices []string `json:"built_services,omitempty"`
+
+	ReviewComments []ReviewComment `json:"review_comments,omitempty"`
+
+	// Schedules are the commands run periodically by the scheduler
+	Schedules []*Schedule `json:"schedules,omitempty"`
+
+	// Checkpoints are the images published from the environment
+	Checkpoints []Checkpoint `json:"checkpoints,omitempty"`
+	// RestoredFrom is the named checkpoint the environment was restored from
+	RestoredFrom *CheckpointOrigin `json:"restored_from,omitempty"`
+
+	// PeakUsage is the highest resource usage sampled while background processes or services ran
+	PeakUsage *UsagePeak `json:"peak_usage,omitempty"`
+
+	// DetectedStack is the stack the environment was set up for, when the project had no configuration
+	DetectedStack *DetectedStack `json:"detected_stack,omitempty"`
+	// ConfigOverrides are the overrides the configuration was created with, on top of the project configuration
+	ConfigOverrides *ConfigOverlay `json:"config_overrides,omitempty"`
+
+	// Budget caps the work of agents in the environment. Only users change it after creation.
+	Budget *Budget `json:"budget,omitempty"`
+	// BudgetUsage is the work done by agents so far, counted against the budget
+	BudgetUsage BudgetUsage `json:"budget_usage,omitzero"`
+
+	// EnvUsage is the number of commands referencing each configured env var and secret, by name
+	EnvUsage map[string]int `json:"env_usage,omitempty"`
+
+	// TestRuns are the last results of the commands that ran tests, by command
+	TestRuns map[string]*TestRun `json:"test_runs,omitempty"`
+	// OutputManifests are the hashes of the files produced by commands, by command, to verify they are reproducible
+	OutputManifests map[string]*OutputManifest `json:"output_manifests,omitempty"`
+
+	// CloudGrants are the short-lived cloud credentials set in the environment of commands, until they expire
+	CloudGrants []*CloudGrant `json:"cloud_grants,omitempty"`
 }
 
 // BackgroundProcess records a host-mode background subprocess
 type BackgroundProcess struct {
-	PID       int       `json:"pid"`
-	Command   string    `json:"command"`
-	Shell     string    `json:"shell"`
-	Ports     []int     `json:"ports,omitempty"`
-	Workdir   string    `json:"workdir"`
+	PID     int    `json:"pid"`
+	Command string `json:"command"`
+	Shell   string `json:"shell"`
+	Ports   []int  `json:"ports,omitempty"`
+	Workdir string `json:"workdir"`
+	// LogFile receives the output of the process
+	LogFile   string    `json:"log_file,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// BackgroundService records a background command run as a service in container mode
+type BackgroundService struct {
+	ID            string `json:"id"`
+	Command       string `json:"command"`
+	Shell         string `json:"shell"`
+	Ports         []int  `json:"ports,omitempty"`
+	UseEntrypoint bool   `json:"use_entrypoint,omitempty"`
+	// Container is the state of the environment the command was started on: background commands don't see later changes
+	Container string    `json:"container"`
 	StartedAt time.Time `json:"started_at"`
 }
 
+// ReviewComment is a comment attached to a chunk of the environment's diff
+type ReviewComment struct {
+	ChunkID   string    `json:"chunk_id"`
+	File      string    `json:"file"`
+	Author    string    `json:"author,omitempty"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CommentsFor returns the review comments attached to the given chunk
+func (s *State) CommentsFor(chunkID string) []ReviewComment {
+	comments := []ReviewComment{}
+	for _, c := range s.ReviewComments {
+		if c.ChunkID == chunkID {
+			comments = append(comments, c)
+		}
+	}
+	return comments
+}
+
+// MergeReviewComments adds the comments that are not already part of the state, keeping them in creation order
+func (s *State) MergeReviewComments(comments []ReviewComment) {
+	added := false
+	for _, c := range comments {
+		if !slices.ContainsFunc(s.ReviewComments, func(existing ReviewComment) bool {
+			return existing.ChunkID == c.ChunkID && existing.Body == c.Body && existing.CreatedAt.Equal(c.CreatedAt)
+		}) {
+			s.ReviewComments = append(s.ReviewComments, c)
+			added = true
+		}
+	}
+	if added {
+		slices.SortStableFunc(s.ReviewComments, func(a, b ReviewComment) int {
+			return a.CreatedAt.Compare(b.CreatedAt)
+		})
+	}
+}
+
 func (s *State) Marshal() ([]byte, error) {
 	return json.MarshalIndent(s, "", "  ")
 }
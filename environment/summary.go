@@ -0,0 +1,131 @@
+package environment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	// summarizerEnvVar is a shell command receiving a SummaryInput as JSON on stdin.
+	// It must print the new title on the first line, optionally followed by a description.
+	summarizerEnvVar = "CONTAINER_USE_SUMMARIZER"
+	// summaryTemplateEnvVar is a Go template rendered with a SummaryInput, with the same output format.
+	summaryTemplateEnvVar = "CONTAINER_USE_SUMMARY_TEMPLATE"
+	// summarizeAfterEnvVar is the number of commands to wait for before summarizing.
+	summarizeAfterEnvVar = "CONTAINER_USE_SUMMARIZE_AFTER"
+
+	defaultSummarizeAfter   = 3
+	defaultSummarizeTimeout = 30 * time.Second
+)
+
+// SummaryInput is the information handed to the summarizer
+type SummaryInput struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	// Log is the history of the environment, including the command notes
+	Log string `json:"log"`
+}
+
+type Summary struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+}
+
+// Summarizer improves the title and description of an environment once a few commands
+// have been run, so environments remain recognizable when agents supply generic titles.
+type Summarizer struct {
+	Command  string
+	Template *template.Template
+	After    int
+	// Timeout bounds the summarizer command, if set
+	Timeout time.Duration
+}
+
+// SummarizerFromEnv returns the summarizer configured in the environment variables, or nil if none is configured.
+func SummarizerFromEnv() (*Summarizer, error) {
+	command := os.Getenv(summarizerEnvVar)
+	tmpl := os.Getenv(summaryTemplateEnvVar)
+	if command == "" && tmpl == "" {
+		return nil, nil
+	}
+
+	s := &Summarizer{
+		Command: command,
+		After:   defaultSummarizeAfter,
+		Timeout: defaultSummarizeTimeout,
+	}
+	if tmpl != "" {
+		t, err := template.New("summary").Parse(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", summaryTemplateEnvVar, err)
+		}
+		s.Template = t
+	}
+	if after := os.Getenv(summarizeAfterEnvVar); after != "" {
+		n, err := strconv.Atoi(after)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s: %q", summarizeAfterEnvVar, after)
+		}
+		s.After = n
+	}
+	return s, nil
+}
+
+// ShouldRun reports whether the environment is due for summarization
+func (s *Summarizer) ShouldRun(state *State) bool {
+	return !state.Summarized && state.CommandCount >= s.After
+}
+
+// Summarize runs the summarizer command, or renders the template if no command is configured
+func (s *Summarizer) Summarize(ctx context.Context, input *SummaryInput) (*Summary, error) {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	var out bytes.Buffer
+	if s.Command != "" {
+		data, err := json.Marshal(input)
+		if err != nil {
+			return nil, err
+		}
+		cmd := exec.CommandContext(ctx, "sh", "-c", s.Command)
+		cmd.Stdin = bytes.NewReader(data)
+		cmd.Stdout = &out
+		// Don't wait for orphaned children holding stdout once the command is killed
+		cmd.WaitDelay = time.Second
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("summarizer command failed: %w", err)
+		}
+	} else {
+		if err := s.Template.Execute(&out, input); err != nil {
+			return nil, fmt.Errorf("failed to render summary template: %w", err)
+		}
+	}
+
+	summary := parseSummary(out.String())
+	if summary.Title == "" {
+		return nil, fmt.Errorf("summarizer returned an empty title")
+	}
+	return summary, nil
+}
+
+// parseSummary uses the first non-empty line as title and the rest as description
+func parseSummary(out string) *Summary {
+	out = strings.TrimSpace(out)
+	title, description, _ := strings.Cut(out, "\n")
+	return &Summary{
+		Title:       strings.TrimSpace(title),
+		Description: strings.TrimSpace(description),
+	}
+}
@@ -0,0 +1,88 @@
+package environment
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSummary(t *testing.T) {
+	summary := parseSummary("\n  Add OAuth login  \nImplements the OAuth flow\nwith GitHub.\n")
+	assert.Equal(t, "Add OAuth login", summary.Title)
+	assert.Equal(t, "Implements the OAuth flow\nwith GitHub.", summary.Description)
+
+	summary = parseSummary("Title only")
+	assert.Equal(t, "Title only", summary.Title)
+	assert.Empty(t, summary.Description)
+}
+
+func TestSummarizerFromEnv(t *testing.T) {
+	t.Run("not_configured", func(t *testing.T) {
+		t.Setenv(summarizerEnvVar, "")
+		t.Setenv(summaryTemplateEnvVar, "")
+		s, err := SummarizerFromEnv()
+		require.NoError(t, err)
+		assert.Nil(t, s)
+	})
+
+	t.Run("template", func(t *testing.T) {
+		t.Setenv(summarizerEnvVar, "")
+		t.Setenv(summaryTemplateEnvVar, "{{.ID}}: {{.Title}}\nfrom template")
+		t.Setenv(summarizeAfterEnvVar, "5")
+		s, err := SummarizerFromEnv()
+		require.NoError(t, err)
+		require.NotNil(t, s)
+		assert.Equal(t, 5, s.After)
+		assert.False(t, s.ShouldRun(&State{CommandCount: 4}))
+		assert.True(t, s.ShouldRun(&State{CommandCount: 5}))
+		assert.False(t, s.ShouldRun(&State{CommandCount: 5, Summarized: true}))
+
+		summary, err := s.Summarize(context.Background(), &SummaryInput{ID: "fancy-mallard", Title: "work"})
+		require.NoError(t, err)
+		assert.Equal(t, "fancy-mallard: work", summary.Title)
+		assert.Equal(t, "from template", summary.Description)
+	})
+
+	t.Run("invalid_threshold", func(t *testing.T) {
+		t.Setenv(summarizerEnvVar, "cat")
+		t.Setenv(summarizeAfterEnvVar, "soon")
+		_, err := SummarizerFromEnv()
+		assert.Error(t, err)
+	})
+}
+
+func TestSummarizerCommand(t *testing.T) {
+	ctx := context.Background()
+	input := &SummaryInput{ID: "fancy-mallard", Title: "work", Log: "$ go test ./..."}
+
+	t.Run("json_on_stdin", func(t *testing.T) {
+		s := &Summarizer{Command: `grep -q '"id":"fancy-mallard"' && echo "Fix flaky tests" && echo "Retries the network calls"`}
+		summary, err := s.Summarize(ctx, input)
+		require.NoError(t, err)
+		assert.Equal(t, "Fix flaky tests", summary.Title)
+		assert.Equal(t, "Retries the network calls", summary.Description)
+	})
+
+	t.Run("empty_title", func(t *testing.T) {
+		s := &Summarizer{Command: "cat > /dev/null"}
+		_, err := s.Summarize(ctx, input)
+		assert.ErrorContains(t, err, "empty title")
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		s := &Summarizer{Command: "exit 1"}
+		_, err := s.Summarize(ctx, input)
+		assert.Error(t, err)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		s := &Summarizer{Command: "sleep 10", Timeout: 100 * time.Millisecond}
+		start := time.Now()
+		_, err := s.Summarize(ctx, input)
+		assert.Error(t, err)
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+}
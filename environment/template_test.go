@@ -0,0 +1,36 @@
+package environment
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplates(t *testing.T) {
+	names := []string{}
+	for _, tmpl := range Templates() {
+		names = append(names, tmpl.Name)
+
+		config := tmpl.Config()
+		assert.NotEmpty(t, config.BaseImage, tmpl.Name)
+		assert.Equal(t, "/workdir", config.Workdir, tmpl.Name)
+		for _, svc := range config.Services {
+			assert.NotEmpty(t, svc.Image, "%s: %s", tmpl.Name, svc.Name)
+		}
+	}
+	assert.True(t, slices.IsSorted(names), "templates are listed by name")
+	assert.Contains(t, names, "python-data-science")
+
+	tmpl, err := GetTemplate("go-service")
+	require.NoError(t, err)
+	config := tmpl.Config()
+	config.Env.Set("DATABASE_URL", "changed")
+	config.Services[0].Image = "changed"
+	assert.NotEqual(t, "changed", tmpl.Config().Env.Get("DATABASE_URL"), "configurations are never shared")
+	assert.Equal(t, "postgres:17", tmpl.Config().Services[0].Image)
+
+	_, err = GetTemplate("cobol-mainframe")
+	assert.ErrorContains(t, err, "available templates are go-service, node-web, python-data-science")
+}
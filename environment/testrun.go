@@ -0,0 +1,139 @@
+package environment
+
+import (
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxTestRuns is the number of test commands whose last results are kept in the state
+const maxTestRuns = 20
+
+// TestRun is the outcome of the last run of a test command. Only failing tests are named:
+// they are all it takes to tell what changed on the next run.
+type TestRun struct {
+	Passed int       `json:"passed"`
+	Failed []string  `json:"failed,omitempty"`
+	RanAt  time.Time `json:"ran_at"`
+}
+
+// TestRunDiff compares the results of a test command with its previous run
+type TestRunDiff struct {
+	Passed int `json:"passed"`
+	Failed int `json:"failed"`
+	// PreviousPassed and PreviousFailed are the counts of the previous run
+	PreviousPassed int `json:"previous_passed"`
+	PreviousFailed int `json:"previous_failed"`
+	// NewlyFailing failed this run but not the previous one, NewlyPassing failed the previous run and passed this one.
+	// Tests of the previous run that didn't run this time are in neither.
+	NewlyFailing []string `json:"newly_failing"`
+	NewlyPassing []string `json:"newly_passing"`
+	StillFailing []string `json:"still_failing"`
+}
+
+type testResultRule struct {
+	// re matches a line of the output, with groups for the name of the test and its status
+	re           *regexp.Regexp
+	name, status int
+	// passed tells which statuses are passing, the others failing
+	passed map[string]bool
+}
+
+// testResultRules recognize the results of common test runners
+var testResultRules = []testResultRule{
+	// go test -v: --- FAIL: TestLogin/expired (0.00s)
+	{re: regexp.MustCompile(`^\s*--- (PASS|FAIL): (\S+)`), name: 2, status: 1, passed: map[string]bool{"PASS": true}},
+	// go test: "ok  	example.com/pkg	0.01s", "FAIL	example.com/pkg [build failed]"
+	{re: regexp.MustCompile(`^(ok|FAIL)\s+(\S+)\s`), name: 2, status: 1, passed: map[string]bool{"ok": true}},
+	// pytest -v: tests/test_auth.py::test_login PASSED [ 50%]
+	{re: regexp.MustCompile(`^(\S+::\S+) (PASSED|FAILED|ERROR)\b`), name: 1, status: 2, passed: map[string]bool{"PASSED": true}},
+	// pytest summary: FAILED tests/test_auth.py::test_login - AssertionError
+	{re: regexp.MustCompile(`^(FAILED|ERROR) (\S+::\S+)`), name: 2, status: 1},
+	// cargo test: test auth::tests::login ... FAILED
+	{re: regexp.MustCompile(`^test (\S+) \.\.\. (ok|FAILED)$`), name: 1, status: 2, passed: map[string]bool{"ok": true}},
+	// jest, vitest and mocha: "✓ logs in (3 ms)", "✕ logs in"
+	{re: regexp.MustCompile(`^\s*([✓✔√✕✗×✖]) (.+?)(?: \(\d+(?:\.\d+)? ?m?s\))?$`), name: 2, status: 1, passed: map[string]bool{"✓": true, "✔": true, "√": true}},
+}
+
+// parseTestResults returns the tests passing and failing in the output of a command
+func parseTestResults(output string) (passed, failed map[string]bool) {
+	passed, failed = map[string]bool{}, map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(strings.TrimPrefix(line, "stderr: "), "\r")
+		for _, rule := range testResultRules {
+			match := rule.re.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			if rule.passed[match[rule.status]] {
+				passed[match[rule.name]] = true
+			} else {
+				failed[match[rule.name]] = true
+			}
+			break
+		}
+	}
+	// Results can be reported twice, e.g. by pytest: failing wins
+	for name := range failed {
+		delete(passed, name)
+	}
+	return passed, failed
+}
+
+// RecordTestRun keeps the results of the tests run by a command, and compares them with the previous run of the command.
+// It returns nil when the output has no test results, or the command didn't run tests before.
+func (env *Environment) RecordTestRun(command, output string) *TestRunDiff {
+	passed, failed := parseTestResults(output)
+	if len(passed) == 0 && len(failed) == 0 {
+		return nil
+	}
+	run := &TestRun{
+		Passed: len(passed),
+		Failed: slices.Sorted(maps.Keys(failed)),
+		RanAt:  time.Now(),
+	}
+
+	command = strings.TrimSpace(command)
+	previous := env.State.TestRuns[command]
+	if env.State.TestRuns == nil {
+		env.State.TestRuns = map[string]*TestRun{}
+	}
+	env.State.TestRuns[command] = run
+	if len(env.State.TestRuns) > maxTestRuns {
+		oldest := ""
+		for cmd, r := range env.State.TestRuns {
+			if oldest == "" || r.RanAt.Before(env.State.TestRuns[oldest].RanAt) {
+				oldest = cmd
+			}
+		}
+		delete(env.State.TestRuns, oldest)
+	}
+
+	if previous == nil {
+		return nil
+	}
+	diff := &TestRunDiff{
+		Passed:         run.Passed,
+		Failed:         len(run.Failed),
+		PreviousPassed: previous.Passed,
+		PreviousFailed: len(previous.Failed),
+		NewlyFailing:   []string{},
+		NewlyPassing:   []string{},
+		StillFailing:   []string{},
+	}
+	for _, name := range run.Failed {
+		if slices.Contains(previous.Failed, name) {
+			diff.StillFailing = append(diff.StillFailing, name)
+		} else {
+			diff.NewlyFailing = append(diff.NewlyFailing, name)
+		}
+	}
+	for _, name := range previous.Failed {
+		if passed[name] {
+			diff.NewlyPassing = append(diff.NewlyPassing, name)
+		}
+	}
+	return diff
+}
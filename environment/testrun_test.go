@@ -0,0 +1,85 @@
+package environment
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTestResults(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		output string
+		passed []string
+		failed []string
+	}{
+		{
+			name:   "go",
+			output: "=== RUN   TestLogin\n--- PASS: TestLogin (0.00s)\n=== RUN   TestLogout\n    --- FAIL: TestLogout/expired (0.01s)\nFAIL\nFAIL\texample.com/auth\t0.012s\nok  \texample.com/db\t(cached)\n",
+			passed: []string{"TestLogin", "example.com/db"},
+			failed: []string{"TestLogout/expired", "example.com/auth"},
+		},
+		{
+			name:   "pytest",
+			output: "tests/test_auth.py::test_login PASSED [ 50%]\ntests/test_auth.py::test_logout FAILED [100%]\n=== short test summary info ===\nFAILED tests/test_auth.py::test_logout - AssertionError\n",
+			passed: []string{"tests/test_auth.py::test_login"},
+			failed: []string{"tests/test_auth.py::test_logout"},
+		},
+		{
+			name:   "cargo",
+			output: "running 2 tests\ntest auth::login ... ok\ntest auth::logout ... FAILED\n",
+			passed: []string{"auth::login"},
+			failed: []string{"auth::logout"},
+		},
+		{
+			name:   "jest",
+			output: "stderr: PASS src/auth.test.js\n  auth\n    ✓ logs in (3 ms)\n    ✕ logs out (12 ms)\n",
+			passed: []string{"logs in"},
+			failed: []string{"logs out"},
+		},
+		{
+			name:   "no_tests",
+			output: "hello world\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			passed, failed := parseTestResults(tc.output)
+			assert.ElementsMatch(t, tc.passed, slices.Collect(maps.Keys(passed)))
+			assert.ElementsMatch(t, tc.failed, slices.Collect(maps.Keys(failed)))
+		})
+	}
+}
+
+func TestRecordTestRun(t *testing.T) {
+	env := &Environment{EnvironmentInfo: &EnvironmentInfo{State: &State{}}}
+
+	assert.Nil(t, env.RecordTestRun("go test ./...", "--- PASS: TestA\n--- FAIL: TestB\n--- FAIL: TestC\n"), "first runs have nothing to compare with")
+	assert.Nil(t, env.RecordTestRun("ls", "main.go\n"))
+	assert.Len(t, env.State.TestRuns, 1)
+
+	diff := env.RecordTestRun("go test ./... ", "--- FAIL: TestA\n--- PASS: TestB\n--- FAIL: TestC\n")
+	require.NotNil(t, diff)
+	assert.Equal(t, []string{"TestA"}, diff.NewlyFailing)
+	assert.Equal(t, []string{"TestB"}, diff.NewlyPassing)
+	assert.Equal(t, []string{"TestC"}, diff.StillFailing)
+	assert.Equal(t, 1, diff.Passed)
+	assert.Equal(t, 2, diff.Failed)
+	assert.Equal(t, 1, diff.PreviousPassed)
+	assert.Equal(t, 2, diff.PreviousFailed)
+
+	// Tests that didn't run are neither passing nor failing
+	diff = env.RecordTestRun("go test ./...", "--- PASS: TestA\n")
+	require.NotNil(t, diff)
+	assert.Equal(t, []string{"TestA"}, diff.NewlyPassing)
+	assert.Empty(t, diff.NewlyFailing)
+	assert.Empty(t, diff.StillFailing)
+
+	for i := range maxTestRuns + 5 {
+		env.RecordTestRun(fmt.Sprintf("go test ./pkg%d", i), "--- PASS: TestA\n")
+	}
+	assert.Len(t, env.State.TestRuns, maxTestRuns)
+}
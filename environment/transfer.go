@@ -0,0 +1,264 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/dustin/go-humanize"
+)
+
+// maxFileChunk is the largest chunk of a file transferred inline by FileReadBytes
+const maxFileChunk = 4 * 1024 * 1024
+
+// FileUpload copies a file from the host into the environment.
+// Unlike FileWrite, contents are copied as is, so binary files (model weights, images, archives) survive the transfer.
+// Host paths given by agents must be confined with HostPaths.Check first.
+func (env *Environment) FileUpload(ctx context.Context, hostPath, targetFile string) error {
+	if !filepath.IsAbs(hostPath) {
+		return fmt.Errorf("host path %s must be absolute", hostPath)
+	}
+	info, err := os.Stat(hostPath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", hostPath)
+	}
+
+	if env.IsHost() {
+		target, err := env.hostPath(targetFile, true)
+		if err != nil {
+			return err
+		}
+		if err := copyFile(hostPath, target); err != nil {
+			return fmt.Errorf("failed uploading file: %w", err)
+		}
+	} else {
+		err := env.apply(ctx, env.container().WithFile(targetFile, env.dag.Host().File(hostPath)))
+		if err != nil {
+			return fmt.Errorf("failed applying file upload, skipping git propagation: %w", err)
+		}
+	}
+	env.Notes.AddFileChange([]string{targetFile}, "Upload %s to %s (%s)", hostPath, targetFile, humanize.Bytes(uint64(info.Size())))
+	return nil
+}
+
+// FileWriteBytes writes binary contents to a file of the environment.
+// With appendToFile, contents are added to the end of the file, so large files can be written in chunks.
+func (env *Environment) FileWriteBytes(ctx context.Context, targetFile string, contents []byte, appendToFile bool) error {
+	if env.IsHost() {
+		target, err := env.hostPath(targetFile, true)
+		if err != nil {
+			return err
+		}
+		if err := writeFile(target, contents, appendToFile); err != nil {
+			return fmt.Errorf("failed writing file: %w", err)
+		}
+		env.Notes.AddFileChange([]string{targetFile}, "Write %s (%s)", targetFile, humanize.Bytes(uint64(len(contents))))
+		return nil
+	}
+
+	// Contents go through a file on the host: dagger only takes text for new files
+	dir, err := os.MkdirTemp("", "container-use-upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, filepath.Base(targetFile))
+	if appendToFile {
+		if _, err := env.container().File(targetFile).Export(ctx, path); err != nil {
+			return fmt.Errorf("failed to read %s: %w", targetFile, err)
+		}
+	}
+	if err := writeFile(path, contents, appendToFile); err != nil {
+		return err
+	}
+
+	err = env.apply(ctx, env.container().WithFile(targetFile, env.dag.Host().File(path)))
+	if err != nil {
+		return fmt.Errorf("failed applying file write, skipping git propagation: %w", err)
+	}
+	env.Notes.AddFileChange([]string{targetFile}, "Write %s (%s)", targetFile, humanize.Bytes(uint64(len(contents))))
+	return nil
+}
+
+// FileDownload copies a file of the environment to the host.
+// Host paths given by agents must be confined with HostPaths.Check first.
+func (env *Environment) FileDownload(ctx context.Context, targetFile, hostPath string) error {
+	if !filepath.IsAbs(hostPath) {
+		return fmt.Errorf("host path %s must be absolute", hostPath)
+	}
+	if env.IsHost() {
+		source, err := env.hostPath(targetFile, false)
+		if err != nil {
+			return err
+		}
+		return copyFile(source, hostPath)
+	}
+	if _, err := env.container().File(targetFile).Export(ctx, hostPath); err != nil {
+		return fmt.Errorf("failed downloading %s: %w", targetFile, err)
+	}
+	return nil
+}
+
+// CopyFileTo copies a file of the environment into another environment.
+// Between containers, the file is copied by the Dagger engine without touching the host.
+func (env *Environment) CopyFileTo(ctx context.Context, sourceFile string, dest *Environment, targetFile string) error {
+	var size int64
+	var source, target string
+	if env.IsHost() {
+		var err error
+		if source, err = env.hostPath(sourceFile, false); err != nil {
+			return err
+		}
+		info, err := os.Stat(source)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return fmt.Errorf("%s is a directory", sourceFile)
+		}
+		size = info.Size()
+	}
+	if dest.IsHost() {
+		var err error
+		if target, err = dest.hostPath(targetFile, true); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case env.IsHost() && dest.IsHost():
+		if err := copyFile(source, target); err != nil {
+			return fmt.Errorf("failed copying file: %w", err)
+		}
+	case env.IsHost():
+		if err := dest.apply(ctx, dest.container().WithFile(targetFile, dest.dag.Host().File(source))); err != nil {
+			return fmt.Errorf("failed applying file copy, skipping git propagation: %w", err)
+		}
+	case dest.IsHost():
+		if _, err := env.container().File(sourceFile).Export(ctx, target); err != nil {
+			return fmt.Errorf("failed copying %s: %w", sourceFile, err)
+		}
+		info, err := os.Stat(target)
+		if err != nil {
+			return err
+		}
+		size = info.Size()
+	default:
+		file := env.container().File(sourceFile)
+		fileSize, err := file.Size(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", sourceFile, err)
+		}
+		if err := dest.apply(ctx, dest.container().WithFile(targetFile, file)); err != nil {
+			return fmt.Errorf("failed applying file copy, skipping git propagation: %w", err)
+		}
+		size = int64(fileSize)
+	}
+
+	dest.Notes.AddFileChange([]string{targetFile}, "Copy %s from environment %s to %s (%s)", sourceFile, env.ID, targetFile, humanize.Bytes(uint64(size)))
+	return nil
+}
+
+// FileReadBytes reads up to limit bytes from offset of a file of the environment, as is.
+// It returns the bytes read and the size of the file.
+func (env *Environment) FileReadBytes(ctx context.Context, targetFile string, offset, limit int64) ([]byte, int64, error) {
+	if limit <= 0 || limit > maxFileChunk {
+		limit = maxFileChunk
+	}
+
+	var path string
+	if env.IsHost() {
+		var err error
+		if path, err = env.hostPath(targetFile, false); err != nil {
+			return nil, 0, err
+		}
+	} else if env.IsKubernetes() {
+		dir, err := os.MkdirTemp("", "container-use-download-*")
+		if err != nil {
+			return nil, 0, err
+		}
+		defer os.RemoveAll(dir)
+		contents, err := env.readPodFile(ctx, targetFile)
+		if err != nil {
+			return nil, 0, err
+		}
+		path = filepath.Join(dir, filepath.Base(targetFile))
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			return nil, 0, err
+		}
+	} else if !env.IsHost() {
+		dir, err := os.MkdirTemp("", "container-use-download-*")
+		if err != nil {
+			return nil, 0, err
+		}
+		defer os.RemoveAll(dir)
+		path = filepath.Join(dir, filepath.Base(targetFile))
+		if _, err := env.container().File(targetFile).Export(ctx, path); err != nil {
+			return nil, 0, fmt.Errorf("failed to read %s: %w", targetFile, err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if offset < 0 || offset > info.Size() {
+		return nil, 0, fmt.Errorf("offset %d out of range: %s is %d bytes", offset, targetFile, info.Size())
+	}
+
+	data, err := io.ReadAll(io.NewSectionReader(f, offset, limit))
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, info.Size(), nil
+}
+
+func writeFile(path string, contents []byte, appendToFile bool) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directories: %w", err)
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendToFile {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(contents); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create directories: %w", err)
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,65 @@
+package environment
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileTransfer(t *testing.T) {
+	ctx := context.Background()
+	env := newHostEnvironment(t, "env-transfer")
+	binary := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff, 0xfe, '\n', 0x00}
+
+	host := filepath.Join(t.TempDir(), "image.png")
+	require.NoError(t, os.WriteFile(host, binary, 0644))
+
+	require.NoError(t, env.FileUpload(ctx, host, "assets/image.png"))
+	uploaded, err := os.ReadFile(filepath.Join(env.State.Config.Workdir, "assets", "image.png"))
+	require.NoError(t, err)
+	assert.Equal(t, binary, uploaded)
+	assert.Error(t, env.FileUpload(ctx, "image.png", "copy.png"), "host paths must be absolute")
+	assert.Error(t, env.FileUpload(ctx, filepath.Dir(host), "copy.png"), "directories can't be uploaded")
+
+	require.NoError(t, env.FileWriteBytes(ctx, "weights.bin", binary[:4], false))
+	require.NoError(t, env.FileWriteBytes(ctx, "weights.bin", binary[4:], true))
+	data, size, err := env.FileReadBytes(ctx, "weights.bin", 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, binary, data)
+	assert.EqualValues(t, len(binary), size)
+
+	data, _, err = env.FileReadBytes(ctx, "weights.bin", 4, 2)
+	require.NoError(t, err)
+	assert.Equal(t, binary[4:6], data)
+	_, _, err = env.FileReadBytes(ctx, "weights.bin", 100, 0)
+	assert.Error(t, err)
+
+	downloaded := filepath.Join(t.TempDir(), "out", "weights.bin")
+	require.NoError(t, env.FileDownload(ctx, "weights.bin", downloaded))
+	data, err = os.ReadFile(downloaded)
+	require.NoError(t, err)
+	assert.Equal(t, binary, data)
+	assert.Error(t, env.FileDownload(ctx, "weights.bin", "relative.bin"))
+}
+
+func TestCopyFileTo(t *testing.T) {
+	ctx := context.Background()
+	source := newHostEnvironment(t, "env-producer")
+	dest := newHostEnvironment(t, "env-consumer")
+	binary := []byte{0x00, 0xff, 'o', 'u', 't', '\n'}
+	require.NoError(t, os.WriteFile(filepath.Join(source.State.Config.Workdir, "out.bin"), binary, 0644))
+
+	require.NoError(t, source.CopyFileTo(ctx, "out.bin", dest, "inputs/in.bin"))
+	copied, err := os.ReadFile(filepath.Join(dest.State.Config.Workdir, "inputs", "in.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, binary, copied)
+	assert.Contains(t, dest.Notes.Pop(), "Copy out.bin from environment env-producer to inputs/in.bin (6 B)")
+	assert.Empty(t, source.Notes.Pop(), "the source environment is left alone")
+
+	assert.Error(t, source.CopyFileTo(ctx, "missing.bin", dest, "in.bin"))
+	assert.Error(t, source.CopyFileTo(ctx, ".", dest, "in.bin"), "directories can't be copied")
+}
@@ -0,0 +1,275 @@
+package environment
+
+import (
+	"context"
+	"log/slog"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+// usageSampleInterval is the period of the resource usage sampling of running background processes and services
+var usageSampleInterval = 10 * time.Second
+
+// ProcessUsage is the resource usage of a host background process, including its children
+type ProcessUsage struct {
+	ID          string  `json:"id"`
+	CPUPercent  float64 `json:"cpu_percent"`
+	MemoryBytes uint64  `json:"memory_bytes"`
+}
+
+// UsageSample is the resource usage of an environment at a point in time.
+// In host mode, CPU and memory are those of the background processes of the environment.
+// Dagger doesn't expose the usage of individual services: in container mode, they are the
+// memory in use and the load of the engine running them.
+type UsageSample struct {
+	At           time.Time      `json:"at"`
+	CPUPercent   float64        `json:"cpu_percent"`
+	MemoryBytes  uint64         `json:"memory_bytes"`
+	WorkdirBytes uint64         `json:"workdir_bytes"`
+	Processes    []ProcessUsage `json:"processes,omitempty"`
+}
+
+// UsagePeak is the highest resource usage sampled for an environment
+type UsagePeak struct {
+	CPUPercent   float64   `json:"cpu_percent"`
+	MemoryBytes  uint64    `json:"memory_bytes"`
+	WorkdirBytes uint64    `json:"workdir_bytes"`
+	UpdatedAt    time.Time `json:"updated_at,omitzero"`
+}
+
+func (p *UsagePeak) add(sample *UsageSample) {
+	p.CPUPercent = max(p.CPUPercent, sample.CPUPercent)
+	p.MemoryBytes = max(p.MemoryBytes, sample.MemoryBytes)
+	p.WorkdirBytes = max(p.WorkdirBytes, sample.WorkdirBytes)
+	p.UpdatedAt = sample.At
+}
+
+func (p *UsagePeak) merge(other *UsagePeak) {
+	if other == nil {
+		return
+	}
+	p.CPUPercent = max(p.CPUPercent, other.CPUPercent)
+	p.MemoryBytes = max(p.MemoryBytes, other.MemoryBytes)
+	p.WorkdirBytes = max(p.WorkdirBytes, other.WorkdirBytes)
+	if other.UpdatedAt.After(p.UpdatedAt) {
+		p.UpdatedAt = other.UpdatedAt
+	}
+}
+
+// ResourceUsage is the live resource usage of an environment
+type ResourceUsage struct {
+	// Current is the latest sample, or nil if nothing runs in the background
+	Current *UsageSample `json:"current,omitempty"`
+	Peak    UsagePeak    `json:"peak"`
+}
+
+// usageSamplers sample the environments with background processes or services, by environment.
+// Like services, they live as long as the server that started them.
+var (
+	usageSamplers   = map[string]*usageSampler{}
+	usageSamplersMu sync.Mutex
+)
+
+type usageSampler struct {
+	mu      sync.Mutex
+	running bool
+	// pending is set when processes are started while sampling, so the sampler doesn't stop before sampling them
+	pending bool
+	pids    []int
+	current *UsageSample
+	peak    UsagePeak
+
+	// sample returns the usage of the tracked processes, or false once none of them runs
+	sample func(ctx context.Context, pids []int) (*UsageSample, bool, error)
+}
+
+func samplerFor(envID string) *usageSampler {
+	usageSamplersMu.Lock()
+	defer usageSamplersMu.Unlock()
+	s, ok := usageSamplers[envID]
+	if !ok {
+		s = &usageSampler{}
+		usageSamplers[envID] = s
+	}
+	return s
+}
+
+// sampleHostProcess starts sampling the usage of a host background process
+func (env *Environment) sampleHostProcess(pid int) {
+	workdir := env.State.Config.Workdir
+	s := samplerFor(env.ID)
+	s.mu.Lock()
+	s.pids = append(s.pids, pid)
+	s.sample = func(ctx context.Context, pids []int) (*UsageSample, bool, error) {
+		return sampleHostUsage(ctx, workdir, pids)
+	}
+	s.mu.Unlock()
+	s.start(env.ID)
+}
+
+// sampleServices starts sampling the usage of the engine from a service container, while the environment has services running
+func (env *Environment) sampleServices(container *dagger.Container) {
+	s := samplerFor(env.ID)
+	s.mu.Lock()
+	s.sample = func(ctx context.Context, _ []int) (*UsageSample, bool, error) {
+		if len(env.RunningServices()) == 0 {
+			return nil, false, nil
+		}
+		return sampleEngineUsage(ctx, container)
+	}
+	s.mu.Unlock()
+	s.start(env.ID)
+}
+
+func (s *usageSampler) start(envID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		s.pending = true
+		return
+	}
+	s.running = true
+	go s.loop(envID)
+}
+
+func (s *usageSampler) loop(envID string) {
+	for {
+		if !s.sampleOnce(context.Background(), envID) {
+			return
+		}
+		time.Sleep(usageSampleInterval)
+	}
+}
+
+// sampleOnce records a sample, and stops the sampler once nothing runs anymore
+func (s *usageSampler) sampleOnce(ctx context.Context, envID string) bool {
+	s.mu.Lock()
+	sample, pids := s.sample, slices.Clone(s.pids)
+	s.pending = false
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, usageSampleInterval)
+	defer cancel()
+	usage, running, err := sample(ctx, pids)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to sample resource usage", "id", envID, "err", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !running {
+		s.current = nil
+		s.pids = slices.DeleteFunc(s.pids, func(pid int) bool { return slices.Contains(pids, pid) })
+		if s.pending {
+			return true
+		}
+		s.running = false
+		return false
+	}
+	if usage != nil {
+		s.current = usage
+		s.peak.add(usage)
+	}
+	return true
+}
+
+// Usage returns the live resource usage of the environment, or nil if it was never sampled by this server
+func (env *Environment) Usage() *ResourceUsage {
+	usageSamplersMu.Lock()
+	s, ok := usageSamplers[env.ID]
+	usageSamplersMu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usage := &ResourceUsage{Peak: s.peak}
+	if s.current != nil {
+		current := *s.current
+		usage.Current = &current
+	}
+	return usage
+}
+
+// RecordPeakUsage records the peak resource usage sampled so far in the state, so it outlives the server
+func (env *Environment) RecordPeakUsage() {
+	usage := env.Usage()
+	if usage == nil || usage.Peak.UpdatedAt.IsZero() {
+		return
+	}
+	peak := usage.Peak
+	peak.merge(env.State.PeakUsage)
+	env.State.PeakUsage = &peak
+}
+
+func sampleHostUsage(ctx context.Context, workdir string, pids []int) (*UsageSample, bool, error) {
+	osProcesses, err := listOSProcesses(ctx)
+	if err != nil {
+		return nil, true, err
+	}
+	processes := processUsage(osProcesses, pids)
+	if len(processes) == 0 {
+		return nil, false, nil
+	}
+
+	sample := &UsageSample{At: time.Now(), Processes: processes}
+	for _, p := range processes {
+		sample.CPUPercent += p.CPUPercent
+		sample.MemoryBytes += p.MemoryBytes
+	}
+	du := exec.CommandContext(ctx, "du", "-sk", ".")
+	du.Dir = workdir
+	if out, err := du.Output(); err == nil {
+		kb, _, _ := strings.Cut(string(out), "\t")
+		if v, err := strconv.ParseUint(kb, 10, 64); err == nil {
+			sample.WorkdirBytes = v * 1024
+		}
+	}
+	return sample, true, nil
+}
+
+func sampleEngineUsage(ctx context.Context, container *dagger.Container) (*UsageSample, bool, error) {
+	output, err := container.
+		WithEnvVariable("CU_STATS_AT", time.Now().String()).
+		WithExec([]string{"sh", "-c", statsScript}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, true, err
+	}
+	stats := parseStats(output)
+	sample := &UsageSample{At: time.Now()}
+	if stats.MemoryTotalBytes > stats.MemoryAvailableBytes {
+		sample.MemoryBytes = stats.MemoryTotalBytes - stats.MemoryAvailableBytes
+	}
+	if load, _, _ := strings.Cut(stats.LoadAverage, " "); load != "" && stats.CPUs > 0 {
+		l, _ := strconv.ParseFloat(load, 64)
+		sample.CPUPercent = l * 100 / float64(stats.CPUs)
+	}
+	return sample, true, nil
+}
+
+// processUsage sums the usage of the running processes with their descendants,
+// since background commands are run by a shell
+func processUsage(osProcesses map[int]OSProcess, pids []int) []ProcessUsage {
+	usage := []ProcessUsage{}
+	for _, pid := range pids {
+		tree := processTree(osProcesses, pid)
+		if len(tree) == 0 {
+			continue
+		}
+		p := ProcessUsage{ID: strconv.Itoa(pid)}
+		for _, osProcess := range tree {
+			p.CPUPercent += osProcess.CPUPercent
+			p.MemoryBytes += osProcess.MemoryBytes
+		}
+		usage = append(usage, p)
+	}
+	return usage
+}
@@ -0,0 +1,64 @@
+package environment
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessUsage(t *testing.T) {
+	entries := parsePs(`    1     0  0.0  1000 /sbin/init
+  100     1  0.1   200 sh -c npm start
+  101   100 50.0  4000 node server.js
+  102   101 25.0  2000 node worker.js --fast
+  200     1 10.0  8000 postgres
+garbage
+`)
+	require.Len(t, entries, 5)
+
+	usage := processUsage(entries, []int{100, 300})
+	require.Len(t, usage, 1, "exited processes are skipped")
+	assert.Equal(t, "100", usage[0].ID)
+	assert.InDelta(t, 75.1, usage[0].CPUPercent, 0.001, "children of the shell are included")
+	assert.EqualValues(t, (200+4000+2000)*1024, usage[0].MemoryBytes)
+}
+
+func TestUsageSampling(t *testing.T) {
+	interval := usageSampleInterval
+	usageSampleInterval = 50 * time.Millisecond
+	t.Cleanup(func() { usageSampleInterval = interval })
+
+	env := newHostEnvironment(t, "env-usage")
+	require.NoError(t, os.WriteFile(env.State.Config.Workdir+"/data", make([]byte, 64*1024), 0644))
+	assert.Nil(t, env.Usage(), "nothing sampled yet")
+
+	cmd := exec.Command("sh", "-c", "sleep 30")
+	require.NoError(t, cmd.Start())
+	go cmd.Wait()
+	env.sampleHostProcess(cmd.Process.Pid)
+
+	require.Eventually(t, func() bool {
+		usage := env.Usage()
+		return usage != nil && usage.Current != nil
+	}, 5*time.Second, 20*time.Millisecond)
+	usage := env.Usage()
+	require.Len(t, usage.Current.Processes, 1)
+	assert.Positive(t, usage.Current.MemoryBytes)
+	assert.GreaterOrEqual(t, usage.Current.WorkdirBytes, uint64(64*1024))
+
+	require.NoError(t, cmd.Process.Kill())
+	require.Eventually(t, func() bool {
+		return env.Usage().Current == nil
+	}, 5*time.Second, 20*time.Millisecond, "sampling stops once the processes exit")
+
+	usage = env.Usage()
+	assert.Positive(t, usage.Peak.MemoryBytes, "peaks are kept")
+
+	env.RecordPeakUsage()
+	require.NotNil(t, env.State.PeakUsage)
+	assert.Equal(t, usage.Peak.MemoryBytes, env.State.PeakUsage.MemoryBytes)
+}
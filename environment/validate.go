@@ -0,0 +1,191 @@
+package environment
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConfigError is an invalid field of a configuration
+type ConfigError struct {
+	// Field is where the error is, e.g. base_image, env[2] or services[postgres]
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// InvalidConfigError lists all the errors of a configuration, so they can be fixed at once
+type InvalidConfigError struct {
+	Errors []ConfigError `json:"errors"`
+}
+
+func (e *InvalidConfigError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		messages = append(messages, fmt.Sprintf("%s: %s", err.Field, err.Message))
+	}
+	return "invalid configuration: " + strings.Join(messages, "; ")
+}
+
+// Validate checks the configuration before environments are built from it, without running anything:
+// image references, variables, secret references, ports, services, and setup commands reading the source, which
+// is copied after they run. It returns an *InvalidConfigError with all the errors found.
+func (config *EnvironmentConfig) Validate() error {
+	var errs []ConfigError
+	add := func(field, format string, args ...any) {
+		errs = append(errs, ConfigError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	host := strings.EqualFold(config.BaseImage, "host")
+
+	for _, check := range []struct {
+		field string
+		err   error
+	}{
+		{"resources", config.Resources.Validate()},
+		{"caches", config.Caches.Validate()},
+		{"host_paths", config.HostPaths.Validate()},
+		{"secret_scan", ValidateSecretScan(config.SecretScan)},
+		{"license_headers", config.LicenseHeaders.Validate()},
+		{"webhooks", config.Webhooks.Validate()},
+		{"approvals", config.Approvals.Validate()},
+	} {
+		if check.err != nil {
+			add(check.field, "%s", check.err)
+		}
+	}
+
+	switch {
+	case config.BaseImage == "":
+		add("base_image", "no base image")
+	case !host && !imageRefRe.MatchString(config.BaseImage):
+		add("base_image", "invalid image reference %q", config.BaseImage)
+	}
+	if !host && !strings.HasPrefix(config.Workdir, "/") {
+		add("workdir", "workdir %q must be absolute", config.Workdir)
+	}
+
+	if config.Kubernetes != nil {
+		if err := config.Kubernetes.validate(); err != nil {
+			add("kubernetes", "%s", err)
+		}
+		// Pods only get what kubectl can pass them: dagger secrets, services and cache volumes can't be
+		if host {
+			add("kubernetes", "host environments can't run in Kubernetes")
+		}
+		if len(config.Secrets) > 0 {
+			add("secrets", "secrets aren't supported in Kubernetes mode")
+		}
+		if len(config.Services) > 0 {
+			add("services", "services aren't supported in Kubernetes mode")
+		}
+		if len(config.Caches) > 0 {
+			add("caches", "caches aren't supported in Kubernetes mode")
+		}
+	}
+
+	for i, variable := range config.Env {
+		if err := validateEnvVar(variable); err != nil {
+			add(fmt.Sprintf("env[%d]", i), "%s", err)
+		}
+	}
+	for _, kind := range []struct {
+		field   string
+		secrets KVList
+	}{{"secrets", config.Secrets}, {"plan_secrets", config.PlanSecrets}} {
+		for i, secret := range kind.secrets {
+			name, ref, _ := strings.Cut(secret, "=")
+			field := fmt.Sprintf("%s[%s]", kind.field, name)
+			if !validEnvVarName(name) {
+				add(fmt.Sprintf("%s[%d]", kind.field, i), "invalid secret %q: expected NAME=reference, e.g. TOKEN=env://GITHUB_TOKEN", secret)
+				continue
+			}
+			if err := validateSecretRef(ref); err != nil {
+				add(field, "%s", err)
+			}
+		}
+	}
+
+	if !host {
+		for i, command := range config.SetupCommands {
+			for _, path := range commandPaths(command) {
+				if strings.HasPrefix(path, strings.TrimSuffix(config.Workdir, "/")+"/") {
+					// Setup results are shared by environments, so they run before the source is copied
+					add(fmt.Sprintf("setup_commands[%d]", i), "%s is not available to setup commands, which run before the source is copied: use an install command", path)
+				}
+			}
+		}
+	}
+
+	for i, port := range config.Ports {
+		if port < 1 || port > 65535 {
+			add(fmt.Sprintf("ports[%d]", i), "invalid port %d", port)
+		}
+	}
+
+	names := map[string]bool{}
+	for i, svc := range config.Services {
+		field := fmt.Sprintf("services[%d]", i)
+		if svc.Name != "" {
+			field = fmt.Sprintf("services[%s]", svc.Name)
+		}
+		switch {
+		case svc.Name == "":
+			add(field, "service has no name")
+		case names[svc.Name]:
+			add(field, "duplicate service name: services are reached using their name as hostname")
+		}
+		names[svc.Name] = true
+		switch {
+		case svc.Image == "":
+			add(field, "service has no image")
+		case !imageRefRe.MatchString(svc.Image):
+			add(field, "invalid image reference %q", svc.Image)
+		}
+		for _, variable := range svc.Env {
+			if err := validateEnvVar(variable); err != nil {
+				add(field, "%s", err)
+			}
+		}
+		for _, port := range svc.ExposedPorts {
+			if port < 1 || port > 65535 {
+				add(field, "invalid port %d", port)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return &InvalidConfigError{Errors: errs}
+	}
+	return nil
+}
+
+// validateEnvVar checks that a variable is NAME=value
+func validateEnvVar(variable string) error {
+	name, _, found := strings.Cut(variable, "=")
+	if !found {
+		return fmt.Errorf("invalid variable %q: expected NAME=value", variable)
+	}
+	if !validEnvVarName(name) {
+		return fmt.Errorf("invalid variable name %q", name)
+	}
+	return nil
+}
+
+// validEnvVarName tells whether a name can be set in the environment of processes.
+// Names shells can't reference, like discovery.type, are still read by programs.
+func validEnvVarName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " \t\n")
+}
+
+// validateSecretRef checks that a secret reference has a provider, without resolving it
+func validateSecretRef(ref string) error {
+	scheme, path := parseSecretRef(ref)
+	secretProvidersMu.RLock()
+	_, ok := secretProviders[scheme]
+	secretProvidersMu.RUnlock()
+	switch {
+	case !ok:
+		return fmt.Errorf("unsupported secret reference %q: no provider for %s://", ref, scheme)
+	case path == "":
+		return fmt.Errorf("empty secret reference")
+	}
+	return nil
+}
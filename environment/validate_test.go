@@ -0,0 +1,55 @@
+package environment
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigValidate(t *testing.T) {
+	assert.NoError(t, DefaultConfig().Validate())
+	for _, template := range Templates() {
+		assert.NoError(t, template.Config().Validate(), template.Name)
+	}
+
+	config := DefaultConfig()
+	config.BaseImage = "Python:3.12"
+	config.Env = KVList{"DEBUG=1", "MY VAR=x", "NOVALUE"}
+	config.Secrets = KVList{"TOKEN=env://GITHUB_TOKEN", "OTHER=keychain://other", "EMPTY=op://"}
+	config.SetupCommands = []string{"apt-get install -y git", "pip install -r /workdir/requirements.txt", "curl -o /workdir/x.sh https://example.com"}
+	config.Ports = []int{8080, 70000}
+	config.Services = ServiceConfigs{
+		{Name: "db", Image: "postgres:17", ExposedPorts: []int{0}, Env: []string{"POSTGRES_PASSWORD=x"}},
+		{Name: "db", Image: "postgres:17"},
+		{Image: ""},
+	}
+
+	var configErr *InvalidConfigError
+	require.True(t, errors.As(config.Validate(), &configErr))
+	fields := []string{}
+	for _, err := range configErr.Errors {
+		fields = append(fields, err.Field)
+	}
+	assert.Equal(t, []string{
+		"base_image",
+		"env[1]",
+		"env[2]",
+		"secrets[OTHER]",
+		"secrets[EMPTY]",
+		"setup_commands[1]",
+		"ports[1]",
+		"services[db]",
+		"services[db]",
+		"services[2]",
+		"services[2]",
+	}, fields)
+	assert.Contains(t, configErr.Error(), "invalid configuration: base_image: ")
+
+	host := DefaultConfig()
+	host.BaseImage = "host"
+	host.Workdir = ""
+	host.SetupCommands = []string{"cat /workdir/notes.txt"}
+	assert.NoError(t, host.Validate())
+}
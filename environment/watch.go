@@ -0,0 +1,259 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"dagger.io/dagger"
+	petname "github.com/dustinkirkland/golang-petname"
+)
+
+const (
+	// maxWatchChanges is the number of paths reported per kind of change
+	maxWatchChanges = 500
+	// maxWatchMarkers is the number of markers kept per environment
+	maxWatchMarkers = 16
+)
+
+// Watch markers record the files of environments at a point in time. Like jobs, they live as long as the server
+// that created them: the container of the environment in container mode, the size and modification time of its files
+// in host mode.
+var (
+	watchesMu sync.Mutex
+	watches   = map[string]*envWatch{}
+)
+
+type envWatch struct {
+	// last is the marker of the last call
+	last    string
+	markers map[string]*watchMarker
+}
+
+type watchMarker struct {
+	createdAt time.Time
+	path      string
+	container string
+	files     map[string]fileStamp
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// WatchResult lists the files changed between a marker and now
+type WatchResult struct {
+	// Marker records now, to get the changes since now in a later call
+	Marker string `json:"marker"`
+	// Since is the marker changes are reported since, empty when watching starts
+	Since     string   `json:"since,omitempty"`
+	Added     []string `json:"added"`
+	Modified  []string `json:"modified"`
+	Deleted   []string `json:"deleted"`
+	Truncated bool     `json:"truncated,omitempty"`
+}
+
+// Watch reports the files of a directory changed since a marker, or since the last call without one.
+// The first call only starts watching. Paths are relative to the directory, which defaults to the workdir.
+func (env *Environment) Watch(ctx context.Context, since, path string) (*WatchResult, error) {
+	watchesMu.Lock()
+	watch := watches[env.ID]
+	if watch == nil {
+		watch = &envWatch{markers: map[string]*watchMarker{}}
+		watches[env.ID] = watch
+	}
+	if since == "" {
+		since = watch.last
+	}
+	previous, ok := watch.markers[since]
+	watchesMu.Unlock()
+	if since != "" && !ok {
+		return nil, fmt.Errorf("unknown watch marker %q: markers are kept for the last %d calls of this server", since, maxWatchMarkers)
+	}
+	if previous != nil && previous.path != path {
+		return nil, fmt.Errorf("watch marker %q is for %q, not %q", since, previous.path, path)
+	}
+
+	current := &watchMarker{createdAt: time.Now(), path: path}
+	result := &WatchResult{Since: since, Added: []string{}, Modified: []string{}, Deleted: []string{}}
+	if env.IsHost() {
+		dir, err := env.hostPath(path, false)
+		if err != nil {
+			return nil, err
+		}
+		files, err := stampFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+		current.files = files
+		if previous != nil {
+			result.Added, result.Modified, result.Deleted = compareStamps(previous.files, files)
+		}
+	} else {
+		env.mu.RLock()
+		current.container = env.State.Container
+		env.mu.RUnlock()
+		if previous != nil && previous.container != current.container {
+			if err := env.diffContainers(ctx, previous.container, current.container, path, result); err != nil {
+				return nil, err
+			}
+		}
+	}
+	result.Added, result.Truncated = truncateChanges(result.Added, result.Truncated)
+	result.Modified, result.Truncated = truncateChanges(result.Modified, result.Truncated)
+	result.Deleted, result.Truncated = truncateChanges(result.Deleted, result.Truncated)
+
+	result.Marker = petname.Generate(2, "-")
+	watchesMu.Lock()
+	defer watchesMu.Unlock()
+	if len(watch.markers) >= maxWatchMarkers {
+		oldest := ""
+		for id, marker := range watch.markers {
+			if oldest == "" || marker.createdAt.Before(watch.markers[oldest].createdAt) {
+				oldest = id
+			}
+		}
+		delete(watch.markers, oldest)
+	}
+	watch.markers[result.Marker] = current
+	watch.last = result.Marker
+	return result, nil
+}
+
+// diffContainers lists the files of a directory changed between two containers of the environment
+func (env *Environment) diffContainers(ctx context.Context, from, to, path string, result *WatchResult) error {
+	dir := path
+	if dir == "" {
+		dir = env.State.Config.Workdir
+	}
+	before := env.dag.LoadContainerFromID(dagger.ContainerID(from)).Directory(dir)
+	after := env.dag.LoadContainerFromID(dagger.ContainerID(to)).Directory(dir)
+
+	beforePaths, err := before.Glob(ctx, "**/*")
+	if err != nil {
+		return fmt.Errorf("failed to list files: %w", err)
+	}
+	afterPaths, err := after.Glob(ctx, "**/*")
+	if err != nil {
+		return fmt.Errorf("failed to list files: %w", err)
+	}
+	// The diff only has the files added or changed
+	diffPaths, err := before.Diff(after).Glob(ctx, "**/*")
+	if err != nil {
+		return fmt.Errorf("failed to diff files: %w", err)
+	}
+	result.Added, result.Modified, result.Deleted = classifyChanges(beforePaths, afterPaths, diffPaths)
+	return nil
+}
+
+// classifyChanges sorts the files of a diff into added and modified ones, and finds the deleted ones.
+// Directories are left out: they're listed along with the files they contain.
+func classifyChanges(before, after, diff []string) (added, modified, deleted []string) {
+	beforeFiles := watchedFiles(before)
+	afterFiles := watchedFiles(after)
+
+	added, modified, deleted = []string{}, []string{}, []string{}
+	for _, path := range slices.Sorted(maps.Keys(watchedFiles(diff))) {
+		if !afterFiles[path] {
+			continue
+		}
+		if beforeFiles[path] {
+			modified = append(modified, path)
+		} else {
+			added = append(added, path)
+		}
+	}
+	for _, path := range slices.Sorted(maps.Keys(beforeFiles)) {
+		if !afterFiles[path] {
+			deleted = append(deleted, path)
+		}
+	}
+	return added, modified, deleted
+}
+
+// watchedFiles returns the files of a listing, without directories and git metadata
+func watchedFiles(paths []string) map[string]bool {
+	dirs := map[string]bool{}
+	for _, path := range paths {
+		if strings.HasSuffix(path, "/") {
+			dirs[strings.TrimSuffix(path, "/")] = true
+		}
+		for dir := filepath.Dir(strings.TrimSuffix(path, "/")); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	files := map[string]bool{}
+	for _, path := range paths {
+		path = strings.TrimSuffix(path, "/")
+		if dirs[path] || path == ".git" || strings.HasPrefix(path, ".git/") {
+			continue
+		}
+		files[path] = true
+	}
+	return files
+}
+
+// stampFiles records the size and modification time of the files of a host directory
+func stampFiles(root string) (map[string]fileStamp, error) {
+	files := map[string]fileStamp{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Name() == ".git" {
+			// .git is a file in worktrees
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	return files, err
+}
+
+// compareStamps lists the files added, modified and deleted between two records of a host directory
+func compareStamps(before, after map[string]fileStamp) (added, modified, deleted []string) {
+	added, modified, deleted = []string{}, []string{}, []string{}
+	for _, path := range slices.Sorted(maps.Keys(after)) {
+		stamp, ok := before[path]
+		switch {
+		case !ok:
+			added = append(added, path)
+		case stamp != after[path]:
+			modified = append(modified, path)
+		}
+	}
+	for _, path := range slices.Sorted(maps.Keys(before)) {
+		if _, ok := after[path]; !ok {
+			deleted = append(deleted, path)
+		}
+	}
+	return added, modified, deleted
+}
+
+func truncateChanges(paths []string, truncated bool) ([]string, bool) {
+	if len(paths) > maxWatchChanges {
+		return paths[:maxWatchChanges], true
+	}
+	return paths, truncated
+}
@@ -0,0 +1,61 @@
+package environment
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyChanges(t *testing.T) {
+	before := []string{"README.md", "cmd", "cmd/main.go", "old.txt", ".git"}
+	after := []string{"README.md", "cmd", "cmd/main.go", "gen", "gen/api.go", ".git"}
+	diff := []string{"cmd", "cmd/main.go", "gen", "gen/api.go"}
+
+	added, modified, deleted := classifyChanges(before, after, diff)
+	assert.Equal(t, []string{"gen/api.go"}, added)
+	assert.Equal(t, []string{"cmd/main.go"}, modified)
+	assert.Equal(t, []string{"old.txt"}, deleted)
+}
+
+func TestWatchHost(t *testing.T) {
+	ctx := context.Background()
+	env := newHostEnvironment(t, "env-watch")
+	workdir := env.State.Config.Workdir
+	writeSearchFile(t, workdir, "main.go", "package main\n")
+	writeSearchFile(t, workdir, "old.txt", "old")
+
+	started, err := env.Watch(ctx, "", "")
+	require.NoError(t, err)
+	assert.Empty(t, started.Since)
+	assert.Empty(t, started.Added)
+
+	writeSearchFile(t, workdir, "main.go", "package main\n\nfunc main() {}\n")
+	writeSearchFile(t, workdir, "gen/api.go", "package gen\n")
+	require.NoError(t, os.Remove(filepath.Join(workdir, "old.txt")))
+
+	changes, err := env.Watch(ctx, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, started.Marker, changes.Since)
+	assert.Equal(t, []string{"gen/api.go"}, changes.Added)
+	assert.Equal(t, []string{"main.go"}, changes.Modified)
+	assert.Equal(t, []string{"old.txt"}, changes.Deleted)
+
+	unchanged, err := env.Watch(ctx, "", "")
+	require.NoError(t, err)
+	assert.Empty(t, unchanged.Added)
+	assert.Empty(t, unchanged.Modified)
+	assert.Empty(t, unchanged.Deleted)
+
+	again, err := env.Watch(ctx, started.Marker, "")
+	require.NoError(t, err)
+	assert.Equal(t, changes.Added, again.Added, "changes since an older marker")
+
+	_, err = env.Watch(ctx, "unknown-marker", "")
+	assert.Error(t, err)
+	_, err = env.Watch(ctx, started.Marker, "gen")
+	assert.Error(t, err, "markers are for a directory")
+}
@@ -0,0 +1,49 @@
+package environment
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+)
+
+// Lifecycle events of environments sent to webhooks
+const (
+	WebhookEventCreated      = "environment.created"
+	WebhookEventDeleted      = "environment.deleted"
+	WebhookEventCheckpointed = "environment.checkpointed"
+)
+
+var webhookEvents = []string{WebhookEventCreated, WebhookEventDeleted, WebhookEventCheckpointed}
+
+// Webhook is called back with the metadata of environments when they are created, deleted or checkpointed,
+// for external systems (asset inventories, cost trackers, CMDBs) to track them
+type Webhook struct {
+	URL string `json:"url"`
+	// Secret is the reference of the secret signing the callbacks (e.g. env://CU_WEBHOOK_SECRET)
+	Secret string `json:"secret,omitempty"`
+	// Events are the events sent to the webhook, all of them if empty
+	Events []string `json:"events,omitempty"`
+}
+
+// Wants tells whether the webhook is sent the event
+func (w Webhook) Wants(event string) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, event)
+}
+
+type Webhooks []Webhook
+
+// Validate checks the webhooks
+func (webhooks Webhooks) Validate() error {
+	for _, w := range webhooks {
+		u, err := url.Parse(w.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("invalid webhook URL %q: expected an http or https URL", w.URL)
+		}
+		for _, event := range w.Events {
+			if !slices.Contains(webhookEvents, event) {
+				return fmt.Errorf("invalid webhook event %q: expected %s, %s or %s", event, WebhookEventCreated, WebhookEventDeleted, WebhookEventCheckpointed)
+			}
+		}
+	}
+	return nil
+}
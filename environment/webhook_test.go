@@ -0,0 +1,22 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhooks(t *testing.T) {
+	assert.NoError(t, Webhooks{
+		{URL: "https://cmdb.example.com/hooks/container-use", Secret: "env://CU_WEBHOOK_SECRET"},
+		{URL: "http://localhost:8080", Events: []string{WebhookEventCreated, WebhookEventDeleted}},
+	}.Validate())
+	assert.Error(t, Webhooks{{URL: "cmdb.example.com"}}.Validate())
+	assert.Error(t, Webhooks{{URL: "ftp://cmdb.example.com"}}.Validate())
+	assert.Error(t, Webhooks{{URL: "https://cmdb.example.com", Events: []string{"created"}}}.Validate())
+
+	assert.True(t, Webhook{}.Wants(WebhookEventCheckpointed))
+	hook := Webhook{Events: []string{WebhookEventCreated}}
+	assert.True(t, hook.Wants(WebhookEventCreated))
+	assert.False(t, hook.Wants(WebhookEventDeleted))
+}
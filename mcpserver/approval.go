@@ -0,0 +1,83 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// ApprovalTimeout is how long tools wait for the user to approve the operations the repository requires approval for
+var ApprovalTimeout = 10 * time.Minute
+
+// requireApproval waits for the user to approve the operation on the environment when the configuration of the
+// repository requires it. It returns a *repository.ApprovalError unless the user approved it.
+func requireApproval(ctx context.Context, repo *repository.Repository, envID, operation, summary string) error {
+	// The configuration is read from the user's repository, where agents can't change it
+	config := environment.DefaultConfig()
+	if err := config.Load(repo.SourcePath()); err != nil {
+		return err
+	}
+	if !config.Approvals.Requires(operation) {
+		return nil
+	}
+	return waitApproval(ctx, repo, envID, operation, summary)
+}
+
+// confirmCommand asks the user to approve a command the command policy of the repository requires confirmation for
+func confirmCommand(repo *repository.Repository, envID string) environment.CommandConfirmer {
+	return func(ctx context.Context, command string) error {
+		return waitApproval(ctx, repo, envID, environment.ApprovalCommand, fmt.Sprintf("Run %q in environment %s", command, envID))
+	}
+}
+
+// waitApproval requests the user to approve the operation, and waits for them to decide
+func waitApproval(ctx context.Context, repo *repository.Repository, envID, operation, summary string) error {
+	approval, err := repo.RequestApproval(ctx, envID, operation, summary)
+	if err != nil {
+		return fmt.Errorf("failed to request approval: %w", err)
+	}
+	slog.InfoContext(ctx, "Waiting for approval", "environment.id", envID, "approval.id", approval.ID, "operation", operation)
+	notifyApproval(ctx, approval)
+
+	waitCtx, cancel := context.WithTimeout(ctx, ApprovalTimeout)
+	defer cancel()
+	// The user may take minutes: the other calls can use the environment meanwhile
+	var waitErr error
+	err = waitUnlocked(ctx, func() error {
+		approval, waitErr = repo.WaitApproval(waitCtx, approval.ID)
+		return waitErr
+	})
+	if waitErr != nil {
+		return fmt.Errorf("failed to wait for approval: %w", waitErr)
+	}
+	if err := ctx.Err(); err != nil {
+		// The call was cancelled or timed out before the user decided
+		return err
+	}
+	if approval.Status != repository.ApprovalApproved {
+		return &repository.ApprovalError{Approval: approval}
+	}
+	return err
+}
+
+// notifyApproval tells the client of the tool call the user must approve it, for it to show the user
+func notifyApproval(ctx context.Context, approval *repository.Approval) {
+	srv := server.ServerFromContext(ctx)
+	if srv == nil {
+		return
+	}
+	err := srv.SendNotificationToClient(ctx, "notifications/message", map[string]any{
+		"level":  "warning",
+		"logger": "container-use",
+		"data": fmt.Sprintf("%s: waiting for approval. Approve it with `container-use approve %s`, or deny it with `container-use approve %s --deny`.",
+			approval.Summary, approval.ID, approval.ID),
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to notify approval request", "approval.id", approval.ID, "err", err)
+	}
+}
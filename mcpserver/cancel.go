@@ -0,0 +1,125 @@
+package mcpserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	// requestIDMeta is the _meta field the ID of tool calls is passed to handlers in, since mcp-go doesn't pass it
+	requestIDMeta = "container-use/request-id"
+	// stdioSessionID is the ID mcp-go gives the session of the stdio server
+	stdioSessionID = "stdio"
+
+	methodNotificationCancelled = "notifications/cancelled"
+)
+
+// Tool calls running, by session and request ID, to cancel them when clients ask to
+var (
+	callsMu sync.Mutex
+	calls   = map[string]context.CancelFunc{}
+)
+
+func callKey(sessionID string, requestID any) string {
+	return fmt.Sprintf("%s/%v", sessionID, requestID)
+}
+
+func sessionID(ctx context.Context) string {
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		return session.SessionID()
+	}
+	return ""
+}
+
+// tagRequestID passes the ID of tool calls to their handlers, see withCancellation
+func tagRequestID(_ context.Context, id any, request *mcp.CallToolRequest) {
+	if request.Params.Meta == nil {
+		request.Params.Meta = &mcp.Meta{}
+	}
+	if request.Params.Meta.AdditionalFields == nil {
+		request.Params.Meta.AdditionalFields = map[string]any{}
+	}
+	request.Params.Meta.AdditionalFields[requestIDMeta] = id
+}
+
+// withCancellation returns the context of a tool call, canceled when the client cancels the call.
+// The returned function must be called once the call is over.
+func withCancellation(ctx context.Context, request mcp.CallToolRequest) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	if request.Params.Meta == nil {
+		return ctx, cancel
+	}
+	id, ok := request.Params.Meta.AdditionalFields[requestIDMeta]
+	if !ok {
+		return ctx, cancel
+	}
+
+	key := callKey(sessionID(ctx), id)
+	callsMu.Lock()
+	calls[key] = cancel
+	callsMu.Unlock()
+	return ctx, func() {
+		callsMu.Lock()
+		delete(calls, key)
+		callsMu.Unlock()
+		cancel()
+	}
+}
+
+// cancelCall cancels a running tool call, if any
+func cancelCall(sessionID string, requestID any, reason string) {
+	callsMu.Lock()
+	cancel, ok := calls[callKey(sessionID, requestID)]
+	callsMu.Unlock()
+	if ok {
+		slog.Info("Tool call cancelled", "request_id", requestID, "reason", reason)
+		cancel()
+	}
+}
+
+// handleCancelled handles the notifications clients send to cancel their requests
+func handleCancelled(ctx context.Context, notification mcp.JSONRPCNotification) {
+	reason, _ := notification.Params.AdditionalFields["reason"].(string)
+	cancelCall(sessionID(ctx), notification.Params.AdditionalFields["requestId"], reason)
+}
+
+// cancellationReader watches the messages read by the stdio server for cancellations.
+// The stdio server reads the next message only once the current one is handled,
+// so the cancellation of a tool call would only be seen once the call is over.
+func cancellationReader(r io.Reader) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		reader := bufio.NewReader(r)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(line) > 0 {
+				var message struct {
+					Method string `json:"method"`
+					Params struct {
+						RequestID any    `json:"requestId"`
+						Reason    string `json:"reason"`
+					} `json:"params"`
+				}
+				if json.Unmarshal(line, &message) == nil && message.Method == methodNotificationCancelled {
+					cancelCall(stdioSessionID, message.Params.RequestID, message.Params.Reason)
+				}
+				if _, err := pw.Write(line); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	return pr
+}
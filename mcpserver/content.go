@@ -0,0 +1,44 @@
+package mcpserver
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// imageMIMETypes are the images clients render from image contents. SVG files are text, and read as such.
+var imageMIMETypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// imageMIMEType returns the MIME type of an image file, or an empty string when it isn't one
+func imageMIMEType(file string) string {
+	return imageMIMETypes[strings.ToLower(path.Ext(file))]
+}
+
+// fileContent returns the contents of a file of an environment as an image, or as an embedded resource
+// clients can show or save without the contents going through the text of the result.
+// Chunks of images aren't images: they are returned as resources when the contents aren't complete.
+func fileContent(envID, file string, contents []byte, complete bool) mcp.Content {
+	encoded := base64.StdEncoding.EncodeToString(contents)
+	if mimeType := imageMIMEType(file); mimeType != "" && complete {
+		return mcp.NewImageContent(encoded, mimeType)
+	}
+	mimeType := mime.TypeByExtension(path.Ext(file))
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	return mcp.NewEmbeddedResource(mcp.BlobResourceContents{
+		URI:      fmt.Sprintf("container-use://environments/%s/%s", envID, strings.TrimPrefix(file, "/")),
+		MIMEType: mimeType,
+		Blob:     encoded,
+	})
+}
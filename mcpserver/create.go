@@ -0,0 +1,146 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// creationJobRetention is how long the result of a background creation can be polled once it is over
+const creationJobRetention = time.Hour
+
+// creationJob is an environment created in the background, for agents whose tool calls time out before heavyweight
+// environments are set up
+type creationJob struct {
+	id        string
+	startedAt time.Time
+	done      chan struct{}
+
+	// Set once done: the call collects what the creation reported besides its result, like warnings
+	call       *toolCall
+	result     *mcp.CallToolResult
+	err        error
+	finishedAt time.Time
+}
+
+var (
+	creationJobsMu sync.Mutex
+	creationJobs   = map[string]*creationJob{}
+)
+
+// CreationStatus is the result of environment_create calls returning before the environment is created, and of
+// environment_create_status while it is
+type CreationStatus struct {
+	JobID          string  `json:"job_id"`
+	Status         string  `json:"status"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	// PollAfterSeconds is when to call environment_create_status next
+	PollAfterSeconds int `json:"poll_after_seconds"`
+}
+
+// startCreationJob runs create in the background, detached from the tool call starting it
+func startCreationJob(ctx context.Context, create func(ctx context.Context) (*mcp.CallToolResult, error)) *creationJob {
+	job := &creationJob{
+		id:        "create-" + newRequestID(),
+		startedAt: time.Now(),
+		done:      make(chan struct{}),
+		call:      &toolCall{tool: "environment_create", started: time.Now()},
+	}
+
+	creationJobsMu.Lock()
+	for id, other := range creationJobs {
+		if !other.finishedAt.IsZero() && time.Since(other.finishedAt) > creationJobRetention {
+			delete(creationJobs, id)
+		}
+	}
+	creationJobs[job.id] = job
+	creationJobsMu.Unlock()
+
+	ctx = withLogAttrs(context.WithoutCancel(ctx), slog.String("job.id", job.id))
+	ctx = context.WithValue(ctx, toolCallKey{}, job.call)
+	go func() {
+		defer close(job.done)
+		defer job.call.unlockEnvironments()
+		result, err := create(ctx)
+		if err != nil {
+			slog.WarnContext(ctx, "Background environment creation failed", "err", err)
+		}
+		creationJobsMu.Lock()
+		defer creationJobsMu.Unlock()
+		job.result, job.err, job.finishedAt = result, err, time.Now()
+	}()
+	return job
+}
+
+// status reports the progress of the creation while it runs
+func (job *creationJob) status() *mcp.CallToolResult {
+	elapsed := time.Since(job.startedAt)
+	// Poll less often as the creation takes longer, without waiting more than half a minute
+	poll := min(max(int(elapsed.Seconds()/4), 2), 30)
+	out, _ := json.Marshal(CreationStatus{
+		JobID:            job.id,
+		Status:           "creating",
+		ElapsedSeconds:   elapsed.Round(time.Second).Seconds(),
+		PollAfterSeconds: poll,
+	})
+	return mcp.NewToolResultText(string(out))
+}
+
+var EnvironmentCreateStatusTool = &Tool{
+	Definition: newRepositoryTool(
+		"environment_create_status",
+		`Polls an environment created in the background by environment_create with async: its progress while it is created, then the result environment_create would have returned, including its errors.`,
+		mcp.WithString("job_id",
+			mcp.Description("The job_id returned by environment_create."),
+			mcp.Required(),
+		),
+		mcp.WithNumber("wait_seconds",
+			mcp.Description("Wait up to this many seconds for the creation to finish before returning its progress (default 0, at most 60)."),
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		jobID, err := request.RequireString("job_id")
+		if err != nil {
+			return nil, err
+		}
+		creationJobsMu.Lock()
+		job, ok := creationJobs[jobID]
+		creationJobsMu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("creation job %q not found: results are kept for %s after the creation is over", jobID, creationJobRetention)
+		}
+
+		wait := time.Duration(min(max(request.GetFloat("wait_seconds", 0), 0), 60) * float64(time.Second))
+		if wait > 0 {
+			select {
+			case <-job.done:
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		select {
+		case <-job.done:
+		default:
+			return job.status(), nil
+		}
+
+		// Report what the creation reported, as if environment_create returned it
+		job.call.mu.Lock()
+		env, warnings := job.call.environment, job.call.warnings
+		job.call.mu.Unlock()
+		if env != nil {
+			recordEnvironment(ctx, env)
+		}
+		for _, warning := range warnings {
+			addWarning(ctx, "%s", warning)
+		}
+		return job.result, job.err
+	},
+}
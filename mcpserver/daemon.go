@@ -0,0 +1,243 @@
+package mcpserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"dagger.io/dagger"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// daemonBaseURL is the URL the daemon tells front-ends to post their messages at. They reach it through its socket,
+// whatever the host.
+const daemonBaseURL = "http://container-use"
+
+// RunDaemon serves the MCP server on the listener, a socket of the user, to the stdio front-ends of their agents
+// (see ProxyStdio): they share its dagger connection, locks and state instead of each holding their own.
+// The daemon stops once no front-end has been connected for idleTimeout, unless it is 0.
+func RunDaemon(ctx context.Context, dag *dagger.Client, listener net.Listener, idleTimeout time.Duration) error {
+	s := newMCPServer(dag)
+	sseSrv := server.NewSSEServer(s,
+		server.WithBaseURL(daemonBaseURL),
+		server.WithSSEEndpoint(sseEndpoint),
+		server.WithMessageEndpoint(messageEndpoint),
+	)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	frontends := &frontends{lastSeen: time.Now()}
+	if idleTimeout > 0 {
+		go frontends.stopWhenIdle(ctx, idleTimeout, cancel)
+	}
+	return serveSSE(ctx, sseSrv, frontends.track(sseSrv), listener)
+}
+
+// frontends counts the front-ends connected to the daemon, to stop it once there are none left
+type frontends struct {
+	mu        sync.Mutex
+	connected int
+	lastSeen  time.Time
+}
+
+// track counts the front-ends connected to the SSE endpoint of the handler: they stay connected for their whole session
+func (f *frontends) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != sseEndpoint {
+			next.ServeHTTP(w, r)
+			return
+		}
+		f.mu.Lock()
+		f.connected++
+		f.mu.Unlock()
+		defer func() {
+			f.mu.Lock()
+			f.connected--
+			f.lastSeen = time.Now()
+			f.mu.Unlock()
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (f *frontends) stopWhenIdle(ctx context.Context, idleTimeout time.Duration, stop context.CancelFunc) {
+	ticker := time.NewTicker(max(idleTimeout/10, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		f.mu.Lock()
+		idle := f.connected == 0 && time.Since(f.lastSeen) >= idleTimeout
+		f.mu.Unlock()
+		if idle {
+			slog.InfoContext(ctx, "Stopping idle daemon", "idle_timeout", idleTimeout)
+			stop()
+			return
+		}
+	}
+}
+
+// daemonClient returns an HTTP client reaching the daemon listening on the unix socket
+func daemonClient(socket string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+}
+
+// ProxyStdio relays the MCP messages of a stdio client to the daemon listening on the unix socket, and the messages
+// of the daemon back, until the client closes its input or the daemon stops.
+func ProxyStdio(ctx context.Context, socket string, in io.Reader, out io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	client := daemonClient(socket)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, daemonBaseURL+sseEndpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the daemon: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to connect to the daemon: %s", resp.Status)
+	}
+
+	events := bufio.NewReader(resp.Body)
+	event, data, err := readSSEEvent(events)
+	if err != nil {
+		return fmt.Errorf("failed to open a session with the daemon: %w", err)
+	}
+	if event != "endpoint" {
+		return fmt.Errorf("failed to open a session with the daemon: unexpected %q event", event)
+	}
+	endpoint, err := url.Parse(data)
+	if err != nil {
+		return fmt.Errorf("invalid message endpoint %q: %w", data, err)
+	}
+	base, _ := url.Parse(daemonBaseURL)
+	messages := base.ResolveReference(endpoint).String()
+
+	// Responses come from the event stream, and from rejected posts
+	var outMu sync.Mutex
+	write := func(message []byte) error {
+		outMu.Lock()
+		defer outMu.Unlock()
+		_, err := out.Write(append(bytes.TrimSpace(message), '\n'))
+		return err
+	}
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- postMessages(ctx, client, messages, bufio.NewReader(in), write)
+	}()
+	go func() {
+		for {
+			event, data, err := readSSEEvent(events)
+			if err != nil {
+				errs <- fmt.Errorf("lost the connection to the daemon: %w", err)
+				return
+			}
+			if event != "message" {
+				continue
+			}
+			if err := write([]byte(data)); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// postMessages posts the messages read from the client, one JSON-RPC message per line, to the endpoint of its session.
+// It returns nil once the client closes its input.
+func postMessages(ctx context.Context, client *http.Client, endpoint string, in *bufio.Reader, write func([]byte) error) error {
+	for {
+		line, err := in.ReadBytes('\n')
+		if message := bytes.TrimSpace(line); len(message) > 0 {
+			if err := postMessage(ctx, client, endpoint, message, write); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func postMessage(ctx context.Context, client *http.Client, endpoint string, message []byte, write func([]byte) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send a message to the daemon: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	// Invalid messages are answered with a JSON-RPC error, which the client is waiting for
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+		return write(body)
+	}
+	return fmt.Errorf("the daemon rejected a message: %s", resp.Status)
+}
+
+// readSSEEvent reads the next event of a Server-Sent Events stream, skipping comments
+func readSSEEvent(r *bufio.Reader) (event, data string, err error) {
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			if event != "" || len(lines) > 0 {
+				return event, strings.Join(lines, "\n"), nil
+			}
+		case strings.HasPrefix(line, ":"):
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			lines = append(lines, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package mcpserver
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const payloadAlgorithm = "AES-256-GCM"
+
+// PayloadKey, when set, encrypts the data of the results of the tools returning file contents,
+// for the remote clients sharing the key. TLS protects the connection, the key protects the contents
+// from the proxies terminating it.
+var PayloadKey []byte
+
+// fileContentTools are the tools whose results hold the contents of files
+var fileContentTools = map[string]bool{
+	"environment_file_read":     true,
+	"environment_file_download": true,
+	"environment_file_search":   true,
+	"environment_data_preview":  true,
+	"environment_diff":          true,
+}
+
+// EncryptedPayload replaces the data of the envelope when it is encrypted.
+// The ciphertext is the JSON encoding of the data, sealed with the nonce and the payload key.
+type EncryptedPayload struct {
+	Algorithm  string `json:"alg"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// ParsePayloadKey parses a base64 encoded 256-bit key
+func ParsePayloadKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid payload key: expected 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+func encryptPayload(key []byte, data any) (*EncryptedPayload, error) {
+	plaintext, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &EncryptedPayload{
+		Algorithm:  payloadAlgorithm,
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plaintext, nil)),
+	}, nil
+}
@@ -0,0 +1,163 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Capabilities describe what this server allows agents to do, for them to adapt instead of failing by trial and error
+type Capabilities struct {
+	Modes    CapabilityModes     `json:"modes"`
+	Tools    []ToolCapability    `json:"tools"`
+	Limits   CapabilityLimits    `json:"limits"`
+	Policies *CapabilityPolicies `json:"policies,omitempty"`
+}
+
+// CapabilityModes are the ways environments run on this server
+type CapabilityModes struct {
+	// Default is the mode of new environments: container, host when they run on the host directly, or kubernetes
+	// when they run in pods of a Kubernetes cluster
+	Default string `json:"default"`
+	// Offline servers refuse operations needing network access: pulling and publishing images, and infrastructure plans
+	Offline bool `json:"offline"`
+}
+
+// ToolCapability summarizes a tool of the server
+type ToolCapability struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	ReadOnly    bool   `json:"read_only"`
+}
+
+// CapabilityLimits are the limits the server enforces, 0 for no limit
+type CapabilityLimits struct {
+	Environments       int `json:"environments"`
+	ConcurrentCommands int `json:"concurrent_commands"`
+	BackgroundServices int `json:"background_services"`
+	// CommandTimeoutSeconds is the timeout of environment_run_cmd calls not setting one
+	CommandTimeoutSeconds int `json:"command_timeout_seconds"`
+	// CloudRoles are the roles environment_grant_cloud_access may grant credentials of
+	CloudRoles []string `json:"cloud_roles"`
+}
+
+// CapabilityPolicies are the policies the configuration of a repository enforces on its environments
+type CapabilityPolicies struct {
+	// Approvals are the operations the user must approve, waiting for up to ApprovalTimeoutSeconds
+	Approvals              []string `json:"approvals"`
+	ApprovalTimeoutSeconds int      `json:"approval_timeout_seconds"`
+	// SecretScan is what happens to files with secrets written by agents: warn, block or off
+	SecretScan string `json:"secret_scan"`
+	// HostPaths are the host directories outside the repository files may be copied from, and to when writable
+	HostPaths environment.HostPaths `json:"host_paths"`
+	// Resources are the limits of the processes of commands
+	Resources *environment.ResourceLimits `json:"resources,omitempty"`
+}
+
+// serverCapabilities describes the capabilities of the server, along with the policies of the repository if any
+func serverCapabilities(repo *repository.Repository) (*Capabilities, error) {
+	capabilities := &Capabilities{
+		Modes: CapabilityModes{Default: "container", Offline: environment.IsOffline()},
+		Limits: CapabilityLimits{
+			Environments:          ServerLimits.Environments,
+			ConcurrentCommands:    ServerLimits.ConcurrentCommands,
+			BackgroundServices:    ServerLimits.BackgroundServices,
+			CommandTimeoutSeconds: int(CommandTimeout.Seconds()),
+			CloudRoles:            slices.Clone(CloudRoles),
+		},
+	}
+	if capabilities.Limits.CloudRoles == nil {
+		capabilities.Limits.CloudRoles = []string{}
+	}
+	if os.Getenv("CONTAINER_USE_DEFAULT_HOST") == "1" {
+		capabilities.Modes.Default = "host"
+	}
+
+	// The kill tool is added to the server on its own
+	for _, tool := range append(slices.Clone(tools), EnvironmentKillBackgroundTool) {
+		description, _, _ := strings.Cut(tool.Definition.Description, "\n")
+		capabilities.Tools = append(capabilities.Tools, ToolCapability{
+			Name:        tool.Definition.Name,
+			Description: description,
+			ReadOnly:    readOnly(tool.Definition),
+		})
+	}
+	slices.SortFunc(capabilities.Tools, func(a, b ToolCapability) int { return strings.Compare(a.Name, b.Name) })
+
+	if repo == nil {
+		return capabilities, nil
+	}
+	// The configuration is read from the user's repository, where agents can't change it
+	config := environment.DefaultConfig()
+	if err := config.Load(repo.SourcePath()); err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(config.BaseImage, "host") {
+		capabilities.Modes.Default = "host"
+	} else if config.Kubernetes != nil {
+		capabilities.Modes.Default = "kubernetes"
+	}
+	capabilities.Policies = &CapabilityPolicies{
+		Approvals:              []string{},
+		ApprovalTimeoutSeconds: int(ApprovalTimeout.Seconds()),
+		SecretScan:             environment.SecretScanWarn,
+		HostPaths:              environment.HostPaths{},
+	}
+	for _, operation := range environment.ApprovalOperations {
+		if config.Approvals.Requires(operation) {
+			capabilities.Policies.Approvals = append(capabilities.Policies.Approvals, operation)
+		}
+	}
+	if config.SecretScan != "" {
+		capabilities.Policies.SecretScan = config.SecretScan
+	}
+	if len(config.HostPaths) > 0 {
+		capabilities.Policies.HostPaths = config.HostPaths
+	}
+	if !config.Resources.IsZero() {
+		capabilities.Policies.Resources = config.Resources
+	}
+	return capabilities, nil
+}
+
+var HelpTool = &Tool{
+	Definition: mcp.NewTool(
+		"container_use_help",
+		mcp.WithDescription(`Describes what this container-use server allows: the mode of new environments, the available tools, the limits it enforces and, given the repository, the policies of its configuration (operations requiring approval, secret scanning, host paths and resource limits).
+Call it before starting work, or after an unexpected refusal, to adapt to what this deployment allows rather than finding out by trial and error.`),
+		explanationArgument,
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository, to include the policies of its configuration."),
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		var repo *repository.Repository
+		if request.GetString("environment_source", "") != "" {
+			var err error
+			if repo, err = openRepository(ctx, request); err != nil {
+				return nil, err
+			}
+		}
+		capabilities, err := serverCapabilities(repo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe the capabilities: %w", err)
+		}
+		out, err := json.Marshal(capabilities)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal capabilities: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+func init() {
+	registerTool(HelpTool)
+}
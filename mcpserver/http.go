@@ -0,0 +1,84 @@
+package mcpserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os/signal"
+	"strings"
+	"time"
+
+	"dagger.io/dagger"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	sseEndpoint     = "/sse"
+	messageEndpoint = "/message"
+
+	httpShutdownTimeout = 5 * time.Second
+)
+
+// RunSSEServer serves the MCP server over Server-Sent Events on the listener until interrupted,
+// so several agents and remote IDEs can share a long-lived server.
+// Clients authenticate with the token, sent as a bearer token or as the token query parameter of the SSE endpoint.
+// baseURL is the URL clients reach the server at, used to tell them where to post their messages.
+func RunSSEServer(ctx context.Context, dag *dagger.Client, listener net.Listener, baseURL, token string) error {
+	s := newMCPServer(dag)
+	sseSrv := server.NewSSEServer(s,
+		server.WithBaseURL(strings.TrimSuffix(baseURL, "/")),
+		server.WithSSEEndpoint(sseEndpoint),
+		server.WithMessageEndpoint(messageEndpoint),
+	)
+	return serveSSE(ctx, sseSrv, requireToken(token, sseSrv), listener)
+}
+
+// serveSSE serves the handler of the SSE server on the listener until interrupted, then closes the sessions
+func serveSSE(ctx context.Context, sseSrv *server.SSEServer, handler http.Handler, listener net.Listener) error {
+	httpSrv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, getNotifySignals()...)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		if err := sseSrv.Shutdown(shutdownCtx); err != nil {
+			slog.WarnContext(ctx, "Failed to close SSE sessions", "err", err)
+		}
+		httpSrv.Shutdown(shutdownCtx)
+	}()
+
+	slog.InfoContext(ctx, "starting server", "address", listener.Addr().String())
+	if err := httpSrv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// requireToken rejects the requests without the token.
+// Messages are posted to a session of the SSE connection: the unguessable session ID they carry authenticates them too.
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			provided = r.URL.Query().Get("token")
+		}
+		if provided == "" && r.URL.Path == messageEndpoint {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
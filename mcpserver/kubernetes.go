@@ -0,0 +1,43 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// kubernetesTools are the tools supported by environments running in Kubernetes pods: the others need the container
+// of the Dagger engine
+var kubernetesTools = []string{
+	"environment_open",
+	"environment_update_metadata",
+	"environment_config",
+	"environment_run_cmd",
+	"environment_file_read",
+	"environment_file_write",
+	"environment_file_edit",
+	"environment_file_delete",
+	"environment_file_list",
+	"environment_ps",
+	"environment_logs",
+	"environment_stop_service",
+	"environment_stats",
+	"environment_diff",
+	"environment_history",
+	"environment_review",
+	"environment_review_comment",
+	"environment_merge",
+	"environment_delete",
+	"environment_send",
+	"environment_receive",
+}
+
+// checkKubernetesTool checks the tool being called supports environments running in Kubernetes pods
+func checkKubernetesTool(ctx context.Context) error {
+	call, ok := ctx.Value(toolCallKey{}).(*toolCall)
+	if !ok || slices.Contains(kubernetesTools, call.tool) {
+		return nil
+	}
+	return fmt.Errorf("%s isn't supported for environments running in Kubernetes, use: %s", call.tool, strings.Join(kubernetesTools, ", "))
+}
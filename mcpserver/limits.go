@@ -0,0 +1,115 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+)
+
+// Limits protect hosts shared by many agents from runaway ones. Zero is no limit.
+type Limits struct {
+	// Environments is the number of environments of a repository
+	Environments int
+	// ConcurrentCommands is the number of commands a client runs at the same time with environment_run_cmd
+	ConcurrentCommands int
+	// BackgroundServices is the number of background commands and services running in an environment
+	BackgroundServices int
+}
+
+// ServerLimits are the limits the server enforces on the tool calls of its clients
+var ServerLimits Limits
+
+// Names of the limits, in the details of the errors of the calls exceeding them
+const (
+	LimitEnvironments       = "environments"
+	LimitConcurrentCommands = "concurrent_commands"
+	LimitBackgroundServices = "background_services"
+)
+
+// LimitExceededError is returned by the tool calls the limits of the server don't allow
+type LimitExceededError struct {
+	Limit string `json:"limit"`
+	Max   int    `json:"max"`
+	// Suggestion is what the agent can do instead of retrying right away
+	Suggestion string `json:"suggestion"`
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("limit of %d %s reached: %s", e.Max, e.Limit, e.Suggestion)
+}
+
+// checkEnvironmentLimit checks a new environment can be created in the repository
+func checkEnvironmentLimit(ctx context.Context, repo *repository.Repository) error {
+	if ServerLimits.Environments <= 0 {
+		return nil
+	}
+	envs, err := repo.List(ctx)
+	if err != nil {
+		return err
+	}
+	if len(envs) >= ServerLimits.Environments {
+		return &LimitExceededError{
+			Limit:      LimitEnvironments,
+			Max:        ServerLimits.Environments,
+			Suggestion: "reuse an existing environment with environment_open, or ask the user to delete the environments they are done with",
+		}
+	}
+	return nil
+}
+
+// checkBackgroundLimit checks a background command or service can be started in the environment
+func checkBackgroundLimit(env *environment.Environment) error {
+	if ServerLimits.BackgroundServices <= 0 {
+		return nil
+	}
+	running := 0
+	for _, process := range env.Processes() {
+		if process.Running {
+			running++
+		}
+	}
+	if running >= ServerLimits.BackgroundServices {
+		return &LimitExceededError{
+			Limit:      LimitBackgroundServices,
+			Max:        ServerLimits.BackgroundServices,
+			Suggestion: "stop a background command or service listed by environment_ps with environment_stop_service or environment_kill_background first",
+		}
+	}
+	return nil
+}
+
+// Commands running, by client session
+var (
+	runningCommandsMu sync.Mutex
+	runningCommands   = map[string]int{}
+)
+
+// acquireCommandSlot reserves one of the commands the client may run at the same time.
+// The returned function releases it once the command is over.
+func acquireCommandSlot(ctx context.Context) (func(), error) {
+	if ServerLimits.ConcurrentCommands <= 0 {
+		return func() {}, nil
+	}
+	session := sessionID(ctx)
+
+	runningCommandsMu.Lock()
+	defer runningCommandsMu.Unlock()
+	if runningCommands[session] >= ServerLimits.ConcurrentCommands {
+		return nil, &LimitExceededError{
+			Limit:      LimitConcurrentCommands,
+			Max:        ServerLimits.ConcurrentCommands,
+			Suggestion: "wait for the commands already running to finish before running more",
+		}
+	}
+	runningCommands[session]++
+	return func() {
+		runningCommandsMu.Lock()
+		defer runningCommandsMu.Unlock()
+		if runningCommands[session]--; runningCommands[session] <= 0 {
+			delete(runningCommands, session)
+		}
+	}, nil
+}
@@ -0,0 +1,204 @@
+package mcpserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Tool calls load the state of environments, change it and save it. Calls changing the same environment are serialized,
+// so they don't overwrite the changes of each other, while calls only reading it run in parallel.
+var (
+	envLocksMu sync.Mutex
+	envLocks   = map[string]*envLock{}
+)
+
+// envLock is the readers-writer lock of an environment. Unlike sync.RWMutex, calls stop waiting for it when they are
+// cancelled: the write semaphore is held by the writer, or by the readers as a whole.
+type envLock struct {
+	write chan struct{}
+	// readersMu is the semaphore guarding readers, the number of calls holding the lock for reading
+	readersMu chan struct{}
+	readers   int
+}
+
+func environmentLock(repo *repository.Repository, envID string) *envLock {
+	envLocksMu.Lock()
+	defer envLocksMu.Unlock()
+
+	key := repo.SourcePath() + "/" + envID
+	lock, ok := envLocks[key]
+	if !ok {
+		lock = &envLock{write: make(chan struct{}, 1), readersMu: make(chan struct{}, 1)}
+		envLocks[key] = lock
+	}
+	return lock
+}
+
+// acquire waits for the lock, for writing or reading, until the context is done
+func (l *envLock) acquire(ctx context.Context, write bool) error {
+	if write {
+		select {
+		case l.write <- struct{}{}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	select {
+	case l.readersMu <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-l.readersMu }()
+	if l.readers == 0 {
+		// The first reader takes the lock from the writers for all readers
+		select {
+		case l.write <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	l.readers++
+	return nil
+}
+
+func (l *envLock) release(write bool) {
+	if write {
+		<-l.write
+		return
+	}
+	l.readersMu <- struct{}{}
+	defer func() { <-l.readersMu }()
+	l.readers--
+	if l.readers == 0 {
+		<-l.write
+	}
+}
+
+// Lock and Unlock make the lock a sync.Locker for writing, for the holders that can't be cancelled
+func (l *envLock) Lock() {
+	_ = l.acquire(context.Background(), true)
+}
+
+func (l *envLock) Unlock() {
+	l.release(true)
+}
+
+// readOnly tells whether a tool only reads environments, from its annotations
+func readOnly(tool mcp.Tool) bool {
+	return tool.Annotations.ReadOnlyHint != nil && *tool.Annotations.ReadOnlyHint
+}
+
+// lockedEnvironment is an environment locked by a tool call
+type lockedEnvironment struct {
+	repo  *repository.Repository
+	envID string
+	lock  *envLock
+	write bool
+}
+
+// lock waits for the environment to be locked for the tool call, and returns how long it waited for the other calls
+func (call *toolCall) lock(ctx context.Context, env lockedEnvironment) (time.Duration, error) {
+	started := time.Now()
+	err := env.lock.acquire(ctx, env.write)
+	return time.Since(started), err
+}
+
+// lockEnvironment locks an environment until the end of the tool call, for reading only when the tool is read-only
+// and the call doesn't change it anyway, with exclusive. It must be called before loading the environment, so the
+// call sees the changes of the previous ones. It fails once the call is cancelled.
+func lockEnvironment(ctx context.Context, repo *repository.Repository, envID string, exclusive bool) error {
+	call, ok := ctx.Value(toolCallKey{}).(*toolCall)
+	if !ok {
+		return nil
+	}
+	locked := lockedEnvironment{repo: repo, envID: envID, lock: environmentLock(repo, envID), write: !call.readOnly || exclusive}
+	wait, err := call.lock(ctx, locked)
+
+	call.mu.Lock()
+	defer call.mu.Unlock()
+	call.queueWait += wait
+	if err != nil {
+		return fmt.Errorf("cancelled while waiting for the other calls using environment %s: %w", envID, err)
+	}
+	call.locked = append(call.locked, locked)
+	return nil
+}
+
+// unlockEnvironments releases the environments locked by the tool call
+func (call *toolCall) unlockEnvironments() {
+	call.mu.Lock()
+	defer call.mu.Unlock()
+
+	for _, locked := range call.locked {
+		locked.lock.release(locked.write)
+	}
+	call.locked = nil
+}
+
+// waitUnlocked runs wait, e.g. waiting for the user, with the environments locked by the tool call released so the
+// other calls don't wait too, then locks them again. It fails if other calls changed them meanwhile: the call would
+// overwrite their changes with the state it loaded.
+func waitUnlocked(ctx context.Context, wait func() error) error {
+	call, ok := ctx.Value(toolCallKey{}).(*toolCall)
+	if !ok {
+		return wait()
+	}
+	call.mu.Lock()
+	locked := call.locked
+	call.mu.Unlock()
+
+	states := make([][]byte, len(locked))
+	for i, env := range locked {
+		state, err := environmentState(ctx, env)
+		if err != nil {
+			return err
+		}
+		states[i] = state
+	}
+	call.unlockEnvironments()
+
+	waitErr := wait()
+
+	for _, env := range locked {
+		queueWait, err := call.lock(ctx, env)
+		call.mu.Lock()
+		call.queueWait += queueWait
+		if err == nil {
+			call.locked = append(call.locked, env)
+		}
+		call.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	if waitErr != nil {
+		return waitErr
+	}
+	for i, env := range locked {
+		state, err := environmentState(ctx, env)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(state, states[i]) {
+			return fmt.Errorf("environment %s changed while waiting: call the tool again", env.envID)
+		}
+	}
+	return nil
+}
+
+// environmentState is the saved state of a locked environment
+func environmentState(ctx context.Context, env lockedEnvironment) ([]byte, error) {
+	info, err := env.repo.Info(ctx, env.envID)
+	if err != nil {
+		return nil, err
+	}
+	return info.State.Marshal()
+}
@@ -0,0 +1,137 @@
+package mcpserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvLock(t *testing.T) {
+	ctx := context.Background()
+	lock := &envLock{write: make(chan struct{}, 1), readersMu: make(chan struct{}, 1)}
+	timeout := func() context.Context {
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		t.Cleanup(cancel)
+		return ctx
+	}
+
+	// Readers share the lock, writers wait for all of them
+	require.NoError(t, lock.acquire(ctx, false))
+	require.NoError(t, lock.acquire(timeout(), false))
+	assert.ErrorIs(t, lock.acquire(timeout(), true), context.DeadlineExceeded)
+	lock.release(false)
+	assert.ErrorIs(t, lock.acquire(timeout(), true), context.DeadlineExceeded)
+	lock.release(false)
+
+	// Writers have the lock to themselves
+	require.NoError(t, lock.acquire(timeout(), true))
+	assert.ErrorIs(t, lock.acquire(timeout(), true), context.DeadlineExceeded)
+	assert.ErrorIs(t, lock.acquire(timeout(), false), context.DeadlineExceeded)
+
+	// Waiting calls get the lock once released
+	acquired := make(chan error)
+	go func() { acquired <- lock.acquire(ctx, false) }()
+	lock.release(true)
+	require.NoError(t, <-acquired)
+	lock.release(false)
+	require.NoError(t, lock.acquire(timeout(), true))
+	lock.release(true)
+}
+
+// TestToolCallLocks tests that the calls of tools changing an environment wait for the other calls using it, until
+// they are cancelled, and release it once over
+func TestToolCallLocks(t *testing.T) {
+	ctx := context.Background()
+	repo := setupTestRepository(t)
+	ToolMetrics = true
+	t.Cleanup(func() { ToolMetrics = false })
+
+	// lockingTool locks env-a, signals it on locked and holds it until release is closed
+	lockingTool := func(name string, readOnlyHint bool, locked chan<- struct{}, release <-chan struct{}) *Tool {
+		return &Tool{
+			Definition: mcp.NewTool(name, mcp.WithReadOnlyHintAnnotation(readOnlyHint)),
+			Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+				if err := lockEnvironment(ctx, repo, "env-a", false); err != nil {
+					return nil, err
+				}
+				locked <- struct{}{}
+				<-release
+				return mcp.NewToolResultText("done"), nil
+			},
+		}
+	}
+	released := make(chan struct{})
+	close(released)
+
+	writerLocked := make(chan struct{}, 1)
+	releaseWriter := make(chan struct{})
+	writer := make(chan *mcp.CallToolResult, 1)
+	go func() {
+		writer <- runTool(ctx, lockingTool("environment_file_write", false, writerLocked, releaseWriter), nil)
+	}()
+	<-writerLocked
+
+	// A call waiting for the environment stops waiting once cancelled
+	readerLocked := make(chan struct{}, 1)
+	reader := make(chan *mcp.CallToolResult, 1)
+	go func() {
+		reader <- runTool(ctx, lockingTool("environment_file_read", true, readerLocked, released), "read-1")
+	}()
+	require.Eventually(t, func() bool {
+		callsMu.Lock()
+		defer callsMu.Unlock()
+		_, ok := calls[callKey("", "read-1")]
+		return ok
+	}, 5*time.Second, time.Millisecond)
+	cancelCall("", "read-1", "the user interrupted the agent")
+	resp := envelope(t, <-reader)
+	require.NotNil(t, resp.Error)
+	assert.Equal(t, "cancelled", resp.Error.Code)
+	assert.Contains(t, resp.Error.Message, "waiting for the other calls using environment env-a")
+	assert.Empty(t, readerLocked, "the cancelled call didn't get the lock")
+
+	// A call waiting for the environment gets it once the call holding it is over
+	go func() {
+		reader <- runTool(ctx, lockingTool("environment_file_read", true, readerLocked, released), nil)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(t, readerLocked)
+	close(releaseWriter)
+	assert.Equal(t, ResponseStatusOK, envelope(t, <-writer).Status)
+	resp = envelope(t, <-reader)
+	assert.Equal(t, ResponseStatusOK, resp.Status)
+	require.NotNil(t, resp.Metrics)
+	assert.GreaterOrEqual(t, resp.Metrics.QueueWaitMs, int64(20))
+
+	// Calls only reading the environment share it
+	readersLocked := make(chan struct{}, 2)
+	releaseReaders := make(chan struct{})
+	readers := make(chan *mcp.CallToolResult, 2)
+	for range 2 {
+		go func() {
+			readers <- runTool(ctx, lockingTool("environment_file_read", true, readersLocked, releaseReaders), nil)
+		}()
+	}
+	for range 2 {
+		select {
+		case <-readersLocked:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the readers didn't share the environment")
+		}
+	}
+	close(releaseReaders)
+	for range 2 {
+		assert.Equal(t, ResponseStatusOK, envelope(t, <-readers).Status)
+	}
+
+	// Every lock was released with the calls
+	lock := environmentLock(repo, "env-a")
+	timeout, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	require.NoError(t, lock.acquire(timeout, true))
+	lock.release(true)
+}
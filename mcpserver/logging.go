@@ -0,0 +1,67 @@
+package mcpserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+type logAttrsKey struct{}
+
+// withLogAttrs returns a context whose log records carry the attributes, see LogHandler
+func withLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	inherited, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	return context.WithValue(ctx, logAttrsKey{}, append(inherited[:len(inherited):len(inherited)], attrs...))
+}
+
+// newRequestID identifies a tool call in the logs. Unlike the IDs of JSON-RPC requests, it is unique across clients.
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// toolCallLogAttrs are the attributes of the log records of a tool call, to correlate them when many agents share a server
+func toolCallLogAttrs(ctx context.Context, tool string, request mcp.CallToolRequest) []slog.Attr {
+	attrs := []slog.Attr{slog.String("request.id", newRequestID()), slog.String("tool", tool)}
+	if session := sessionID(ctx); session != "" {
+		attrs = append(attrs, slog.String("session.id", session))
+	}
+	if request.Params.Meta != nil {
+		if id, ok := request.Params.Meta.AdditionalFields[requestIDMeta]; ok {
+			attrs = append(attrs, slog.String("rpc.id", fmt.Sprint(id)))
+		}
+	}
+	return attrs
+}
+
+// LogHandler adds the attributes of the tool call being handled, like its request ID, to the records logged with
+// its context
+func LogHandler(handler slog.Handler) slog.Handler {
+	return &logHandler{handler}
+}
+
+type logHandler struct {
+	slog.Handler
+}
+
+func (h *logHandler) Handle(ctx context.Context, record slog.Record) error {
+	if attrs, ok := ctx.Value(logAttrsKey{}).([]slog.Attr); ok {
+		record.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &logHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	return &logHandler{h.Handler.WithGroup(name)}
+}
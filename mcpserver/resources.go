@@ -0,0 +1,65 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// instructionsURI is the resource of the instructions of the repository the server runs in.
+// The instructions of other repositories are at instructionsURI followed by their absolute path.
+const instructionsURI = "container-use://instructions"
+
+func addResources(s *server.MCPServer) {
+	s.AddResource(mcp.NewResource(
+		instructionsURI,
+		"Repository instructions",
+		mcp.WithResourceDescription("The conventions and guardrails of the repository for agents, from .container-use/instructions.md or AGENTS.md"),
+		mcp.WithMIMEType("text/markdown"),
+	), readInstructions)
+	s.AddResourceTemplate(mcp.NewResourceTemplate(
+		instructionsURI+"{+environment_source}",
+		"Repository instructions",
+		mcp.WithTemplateDescription("The conventions and guardrails of the repository at the given absolute path for agents, from .container-use/instructions.md or AGENTS.md"),
+		mcp.WithTemplateMIMEType("text/markdown"),
+	), readInstructions)
+}
+
+// instructionsResource returns the URI of the instructions of a repository
+func instructionsResource(source string) string {
+	return instructionsURI + source
+}
+
+func readInstructions(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	source := strings.TrimPrefix(request.Params.URI, instructionsURI)
+	if source == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		source = wd
+	}
+	repo, err := repository.Open(ctx, source)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open repository: %w", err)
+	}
+	file, instructions, err := repo.AgentInstructions()
+	if err != nil {
+		return nil, err
+	}
+	if file == "" {
+		instructions = "This repository has no instructions for agents."
+	}
+	return []mcp.ResourceContents{
+		mcp.TextResourceContents{
+			URI:      request.Params.URI,
+			MIMEType: "text/markdown",
+			Text:     instructions,
+		},
+	}, nil
+}
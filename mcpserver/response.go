@@ -0,0 +1,301 @@
+package mcpserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// ResponseVersion is the version of the envelope tool results are wrapped in.
+// It changes when fields are removed or change meaning, not when fields are added.
+const ResponseVersion = 1
+
+const (
+	ResponseStatusOK    = "ok"
+	ResponseStatusError = "error"
+)
+
+// Response is the envelope of the results of every tool, so clients can parse them the same way
+type Response struct {
+	Version int    `json:"version"`
+	Status  string `json:"status"`
+	// Data is the result of the tool: JSON for the tools returning JSON objects, a string otherwise, e.g. file contents
+	Data any `json:"data,omitempty"`
+	// Encrypted replaces Data for the results holding file contents when the server encrypts them, see PayloadKey
+	Encrypted   *EncryptedPayload    `json:"encrypted,omitempty"`
+	Error       *ResponseError       `json:"error,omitempty"`
+	Warnings    []string             `json:"warnings,omitempty"`
+	Environment *ResponseEnvironment `json:"environment,omitempty"`
+	// Failure tells why the command run by the tool failed, when it exited with a non-zero code
+	Failure *environment.FailureAnalysis `json:"failure,omitempty"`
+	// Tests compares the results of the tests run by the command with its previous run, when it's a rerun
+	Tests *environment.TestRunDiff `json:"tests,omitempty"`
+	// Metrics trails the envelope when the server reports them, see ToolMetrics
+	Metrics *ResponseMetrics `json:"metrics,omitempty"`
+}
+
+type ResponseError struct {
+	// Code is a stable identifier of the error for clients to act on, e.g. budget_exceeded
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// ToolMetrics adds the metrics of each tool call to the envelope of its result
+var ToolMetrics bool
+
+// ResponseMetrics tells how long a tool call took and how much it returned, for clients adapting to the latency of
+// the server, e.g. by batching more
+type ResponseMetrics struct {
+	// QueueWaitMs is the time spent waiting for calls changing the same environments to finish
+	QueueWaitMs int64 `json:"queue_wait_ms"`
+	// EngineMs is the time spent running the commands of the tool in the environment
+	EngineMs int64 `json:"engine_ms"`
+	TotalMs  int64 `json:"total_ms"`
+	// StateRevision identifies the state of the environment: it only changes when the state does
+	StateRevision string `json:"state_revision,omitempty"`
+	// Bytes is the size of the result of the tool, the envelope aside
+	Bytes int `json:"bytes"`
+}
+
+// ResponseEnvironment identifies the environment the tool ran in
+type ResponseEnvironment struct {
+	ID        string    `json:"id"`
+	Branch    string    `json:"branch"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+}
+
+type toolCallKey struct{}
+
+// toolCall collects what handlers report besides their result while a tool runs
+type toolCall struct {
+	mu          sync.Mutex
+	tool        string
+	started     time.Time
+	environment *environment.Environment
+	// commandTime is the command time of the environment when the call started using it
+	commandTime time.Duration
+	queueWait   time.Duration
+	warnings    []string
+	failure     *environment.FailureAnalysis
+	tests       *environment.TestRunDiff
+
+	// readOnly tells whether the tool only reads environments, locked are the environments it locked
+	readOnly bool
+	locked   []lockedEnvironment
+}
+
+// recordEnvironment reports the environment the tool runs in, for the envelope of its result
+func recordEnvironment(ctx context.Context, env *environment.Environment) {
+	if call, ok := ctx.Value(toolCallKey{}).(*toolCall); ok {
+		call.mu.Lock()
+		defer call.mu.Unlock()
+		call.environment = env
+		call.commandTime = env.State.BudgetUsage.CommandTime
+	}
+}
+
+// addWarning reports something the agent must be told about, for the envelope of the result
+func addWarning(ctx context.Context, format string, args ...any) {
+	if call, ok := ctx.Value(toolCallKey{}).(*toolCall); ok {
+		call.mu.Lock()
+		defer call.mu.Unlock()
+		call.warnings = append(call.warnings, fmt.Sprintf(format, args...))
+	}
+}
+
+// recordFailure reports why the command run by the tool failed, for the envelope of the result
+func recordFailure(ctx context.Context, failure *environment.FailureAnalysis) {
+	if call, ok := ctx.Value(toolCallKey{}).(*toolCall); ok {
+		call.mu.Lock()
+		defer call.mu.Unlock()
+		call.failure = failure
+	}
+}
+
+// recordTests reports how the tests run by the tool changed since the previous run, for the envelope of the result
+func recordTests(ctx context.Context, tests *environment.TestRunDiff) {
+	if call, ok := ctx.Value(toolCallKey{}).(*toolCall); ok {
+		call.mu.Lock()
+		defer call.mu.Unlock()
+		call.tests = tests
+	}
+}
+
+// response wraps the result of a tool, or the error it failed with, in the envelope
+func (call *toolCall) response(result *mcp.CallToolResult, err error) *mcp.CallToolResult {
+	call.mu.Lock()
+	defer call.mu.Unlock()
+
+	resp := &Response{
+		Version:  ResponseVersion,
+		Status:   ResponseStatusOK,
+		Warnings: call.warnings,
+		Failure:  call.failure,
+		Tests:    call.tests,
+	}
+	if env := call.environment; env != nil {
+		resp.Environment = &ResponseEnvironment{
+			ID:        env.ID,
+			Branch:    fmt.Sprintf("container-use/%s", env.ID),
+			UpdatedAt: env.State.UpdatedAt,
+		}
+	}
+
+	// Contents other than text, like images, are passed along after the envelope
+	var text string
+	var contents []mcp.Content
+	if result != nil {
+		for _, content := range result.Content {
+			if t, ok := content.(mcp.TextContent); ok && text == "" {
+				text = t.Text
+				continue
+			}
+			contents = append(contents, content)
+		}
+	}
+
+	var budgetErr *environment.BudgetExceededError
+	var limitErr *LimitExceededError
+	var configErr *environment.InvalidConfigError
+	var policyErr *environment.CommandPolicyError
+	var approvalErr *repository.ApprovalError
+	switch {
+	case errors.As(err, &budgetErr):
+		resp.Status = ResponseStatusError
+		resp.Error = &ResponseError{Code: "budget_exceeded", Message: budgetErr.Error(), Details: budgetErr}
+	case errors.As(err, &limitErr):
+		resp.Status = ResponseStatusError
+		resp.Error = &ResponseError{Code: "limit_exceeded", Message: limitErr.Error(), Details: limitErr}
+	case errors.As(err, &policyErr):
+		resp.Status = ResponseStatusError
+		resp.Error = &ResponseError{Code: "policy_violation", Message: policyErr.Error(), Details: policyErr}
+	case errors.As(err, &approvalErr):
+		resp.Status = ResponseStatusError
+		resp.Error = &ResponseError{Code: "approval_denied", Message: approvalErr.Error(), Details: approvalErr.Approval}
+	case errors.As(err, &configErr):
+		resp.Status = ResponseStatusError
+		resp.Error = &ResponseError{Code: "invalid_config", Message: err.Error(), Details: configErr.Errors}
+	case errors.Is(err, context.Canceled):
+		resp.Status = ResponseStatusError
+		resp.Error = &ResponseError{Code: "cancelled", Message: err.Error()}
+	case errors.Is(err, context.DeadlineExceeded):
+		resp.Status = ResponseStatusError
+		resp.Error = &ResponseError{Code: "timeout", Message: err.Error()}
+	case err != nil:
+		resp.Status = ResponseStatusError
+		resp.Error = &ResponseError{Code: "tool_error", Message: err.Error()}
+	case result != nil && result.IsError:
+		resp.Status = ResponseStatusError
+		resp.Error = &ResponseError{Code: "tool_error", Message: text}
+	case text != "":
+		resp.Data = responseData(call.tool, text)
+	}
+	if resp.Data != nil && PayloadKey != nil && fileContentTools[call.tool] {
+		encrypted, err := encryptPayload(PayloadKey, resp.Data)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("failed to encrypt response: %s", err))
+		}
+		resp.Data, resp.Encrypted = nil, encrypted
+		if len(contents) > 0 {
+			// Images and resources would hold the contents in the clear
+			contents = nil
+			resp.Warnings = append(resp.Warnings, "images and embedded resources are not returned when payloads are encrypted")
+		}
+	}
+
+	if ToolMetrics {
+		resp.Metrics = call.metrics(text, contents)
+	}
+
+	out, marshalErr := json.Marshal(resp)
+	if marshalErr != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("failed to marshal response: %s", marshalErr))
+	}
+	return &mcp.CallToolResult{
+		Content: append([]mcp.Content{mcp.NewTextContent(string(out))}, contents...),
+		IsError: resp.Status == ResponseStatusError,
+	}
+}
+
+// metrics measures the call, once its result is known
+func (call *toolCall) metrics(text string, contents []mcp.Content) *ResponseMetrics {
+	metrics := &ResponseMetrics{
+		QueueWaitMs: call.queueWait.Milliseconds(),
+		TotalMs:     time.Since(call.started).Milliseconds(),
+		Bytes:       len(text),
+	}
+	for _, content := range contents {
+		if data, err := json.Marshal(content); err == nil {
+			metrics.Bytes += len(data)
+		}
+	}
+	if env := call.environment; env != nil {
+		metrics.EngineMs = (env.State.BudgetUsage.CommandTime - call.commandTime).Milliseconds()
+		if state, err := env.State.Marshal(); err == nil {
+			sum := sha256.Sum256(state)
+			metrics.StateRevision = hex.EncodeToString(sum[:6])
+		}
+	}
+	return metrics
+}
+
+// jsonTools are the tools returning JSON objects and arrays, embedded as is in the envelope.
+// Results of other tools, like file contents and command outputs, are strings even when they happen to be JSON.
+var jsonTools = map[string]bool{
+	"container_use_help":              true,
+	"environment_open":                true,
+	"environment_create":              true,
+	"environment_create_status":       true,
+	"environment_update_metadata":     true,
+	"environment_config":              true,
+	"environment_list":                true,
+	"environment_install_deps":        true,
+	"environment_format":              true,
+	"environment_matrix_run":          true,
+	"environment_verify_reproducible": true,
+	"environment_job_start":           true,
+	"environment_job_status":          true,
+	"environment_job_result":          true,
+	"environment_respond":             true,
+	"environment_schedule_list":       true,
+	"environment_file_list":           true,
+	"environment_watch":               true,
+	"environment_file_download":       true,
+	"environment_file_search":         true,
+	"environment_data_preview":        true,
+	"environment_netstat":             true,
+	"environment_terminal":            true,
+	"environment_stats":               true,
+	"environment_import":              true,
+	"environment_add_service":         true,
+	"environment_ps":                  true,
+	"environment_build_image":         true,
+	"environment_iac_plan":            true,
+	"environment_grant_cloud_access":  true,
+	"environment_send":                true,
+	"environment_receive":             true,
+	"environment_history":             true,
+	"environment_review":              true,
+}
+
+// responseData embeds the JSON objects and arrays returned by the JSON tools as is, and other results as strings.
+// Some of these tools return text too, e.g. environment_file_list without recursive.
+func responseData(tool, text string) any {
+	trimmed := strings.TrimSpace(text)
+	object := strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")
+	if jsonTools[tool] && object && json.Valid([]byte(trimmed)) {
+		return json.RawMessage(trimmed)
+	}
+	return text
+}
@@ -0,0 +1,139 @@
+package mcpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseEnvelope(t *testing.T) {
+	ctx := context.Background()
+	tool := func(name string, handler server.ToolHandlerFunc) *Tool {
+		return &Tool{Definition: mcp.NewTool(name), Handler: handler}
+	}
+	result := func(text string) server.ToolHandlerFunc {
+		return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultText(text), nil
+		}
+	}
+	failure := func(err error) server.ToolHandlerFunc {
+		return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return nil, err
+		}
+	}
+
+	t.Run("json", func(t *testing.T) {
+		env := newHostEnvironment(t, "env-a")
+		resp := envelope(t, runTool(ctx, tool("environment_open", func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			recordEnvironment(ctx, env)
+			addWarning(ctx, "%s was started again after a server restart", "db")
+			return mcp.NewToolResultText(`{"id": "env-a"}`), nil
+		}), nil))
+		assert.Equal(t, ResponseStatusOK, resp.Status)
+		assert.Equal(t, map[string]any{"id": "env-a"}, resp.Data, "the results of JSON tools are embedded as is")
+		assert.Equal(t, []string{"db was started again after a server restart"}, resp.Warnings)
+		require.NotNil(t, resp.Environment)
+		assert.Equal(t, "env-a", resp.Environment.ID)
+		assert.Equal(t, "container-use/env-a", resp.Environment.Branch)
+		assert.Nil(t, resp.Error)
+	})
+
+	t.Run("text", func(t *testing.T) {
+		resp := envelope(t, runTool(ctx, tool("environment_file_read", result(`{"id": "env-a"}`)), nil))
+		assert.Equal(t, `{"id": "env-a"}`, resp.Data, "file contents stay strings even when they are JSON")
+		assert.Nil(t, resp.Environment)
+
+		resp = envelope(t, runTool(ctx, tool("environment_file_list", result("main.go\n")), nil))
+		assert.Equal(t, "main.go\n", resp.Data, "JSON tools may return text too")
+	})
+
+	t.Run("contents", func(t *testing.T) {
+		out := runTool(ctx, tool("environment_file_read", func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return &mcp.CallToolResult{Content: []mcp.Content{
+				mcp.NewTextContent("screenshot.png"),
+				mcp.NewImageContent("aW1hZ2U=", "image/png"),
+			}}, nil
+		}), nil)
+		resp := envelope(t, out)
+		assert.Equal(t, "screenshot.png", resp.Data)
+		require.Len(t, out.Content, 2, "images follow the envelope")
+		assert.IsType(t, mcp.ImageContent{}, out.Content[1])
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for _, tc := range []struct {
+			name    string
+			handler server.ToolHandlerFunc
+			code    string
+			message string
+		}{
+			{
+				name:    "policy",
+				handler: failure(fmt.Errorf("failed to run command: %w", &environment.CommandPolicyError{Command: "git push", Rule: environment.CommandPolicyDeny, Pattern: "git push"})),
+				code:    "policy_violation",
+				message: "command denied by the policy of the repository",
+			},
+			{
+				name:    "budget",
+				handler: failure(&environment.BudgetExceededError{EnvironmentID: "env-a", Exceeded: "tool_calls"}),
+				code:    "budget_exceeded",
+			},
+			{
+				name:    "cancelled",
+				handler: failure(fmt.Errorf("cancelled while waiting for the other calls using environment env-a: %w", context.Canceled)),
+				code:    "cancelled",
+				message: "env-a",
+			},
+			{
+				name:    "timeout",
+				handler: failure(context.DeadlineExceeded),
+				code:    "timeout",
+			},
+			{
+				name:    "error",
+				handler: failure(errors.New("unable to get environment")),
+				code:    "tool_error",
+				message: "unable to get environment",
+			},
+			{
+				name: "result",
+				handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+					return mcp.NewToolResultError("invalid path"), nil
+				},
+				code:    "tool_error",
+				message: "invalid path",
+			},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				resp := envelope(t, runTool(ctx, tool("environment_run_cmd", tc.handler), nil))
+				assert.Equal(t, ResponseStatusError, resp.Status)
+				require.NotNil(t, resp.Error)
+				assert.Equal(t, tc.code, resp.Error.Code)
+				assert.Contains(t, resp.Error.Message, tc.message)
+				assert.Nil(t, resp.Data)
+			})
+		}
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		PayloadKey = make([]byte, 32)
+		t.Cleanup(func() { PayloadKey = nil })
+
+		resp := envelope(t, runTool(ctx, tool("environment_file_read", result("password=hunter2")), nil))
+		assert.Nil(t, resp.Data)
+		require.NotNil(t, resp.Encrypted)
+		assert.Equal(t, payloadAlgorithm, resp.Encrypted.Algorithm)
+		assert.NotContains(t, resp.Encrypted.Ciphertext, "hunter2")
+
+		resp = envelope(t, runTool(ctx, tool("environment_list", result(`[]`)), nil))
+		assert.Equal(t, []any{}, resp.Data, "only file contents are encrypted")
+		assert.Nil(t, resp.Encrypted)
+	})
+}
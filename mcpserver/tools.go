@@ -1,8 +1,10 @@
 package mcpserver
 
 import (
+	"bytes"
 	"context"
 	_ "embed"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +12,10 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"dagger.io/dagger"
 	"github.com/dagger/container-use/environment"
@@ -21,6 +27,12 @@ import (
 
 type daggerClientKey struct{}
 
+// CommandTimeout is how long commands run by environment_run_cmd may take when the call doesn't set a timeout, 0 for no limit
+var CommandTimeout = 30 * time.Minute
+
+// CloudRoles are the AWS role ARNs and GCP service accounts agents may be granted credentials of by environment_grant_cloud_access
+var CloudRoles []string
+
 func openRepository(ctx context.Context, request mcp.CallToolRequest) (*repository.Repository, error) {
 	source, err := request.RequireString("environment_source")
 	if err != nil {
@@ -42,15 +54,96 @@ func openEnvironment(ctx context.Context, request mcp.CallToolRequest) (*reposit
 	if err != nil {
 		return nil, nil, err
 	}
+	env, err := loadEnvironment(ctx, repo, envID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return repo, env, nil
+}
+
+// loadEnvironment opens an environment of the repository for the tool call: it's locked, charged to its budget and
+// subject to the command policy of the repository
+func loadEnvironment(ctx context.Context, repo *repository.Repository, envID string) (*environment.Environment, error) {
 	dag, ok := ctx.Value(daggerClientKey{}).(*dagger.Client)
 	if !ok {
-		return nil, nil, fmt.Errorf("dagger client not found in context")
+		return nil, fmt.Errorf("dagger client not found in context")
+	}
+	// The services of the environment are resumed by the first call after a server restart, changing the environment
+	// even if the call only reads it
+	resume, err := repo.ClaimServices(envID)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to claim the services of the environment", "environment", envID, "error", err)
+	}
+	if err := lockEnvironment(ctx, repo, envID, resume); err != nil {
+		return nil, err
 	}
 	env, err := repo.Get(ctx, dag, envID)
 	if err != nil {
-		return nil, nil, fmt.Errorf("unable to get environment: %w", err)
+		return nil, fmt.Errorf("unable to get environment: %w", err)
+	}
+	if env.IsKubernetes() {
+		if err := checkKubernetesTool(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if err := setCommandPolicy(repo, env); err != nil {
+		return nil, err
+	}
+	if resume {
+		resumeEnvironment(ctx, env)
+	}
+	recordEnvironment(ctx, env)
+	if err := env.ChargeToolCall(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// setCommandPolicy enforces the command policy of the repository on the commands run in the environment
+func setCommandPolicy(repo *repository.Repository, env *environment.Environment) error {
+	// The policy is read from the user's repository, where agents can't change it
+	policy, err := environment.LoadCommandPolicy(repo.SourcePath())
+	if err != nil {
+		return err
+	}
+	env.SetCommandPolicy(policy, confirmCommand(repo, env.ID))
+	return nil
+}
+
+// resumeEnvironment starts the services of an environment again when they were lost with the server that ran them,
+// e.g. when the stdio process of the agent was restarted, and tells the agent about their new endpoints.
+// The call must have claimed the services of the environment (see repository.ClaimServices).
+func resumeEnvironment(ctx context.Context, env *environment.Environment) {
+	services, err := env.Resume(ctx)
+	for _, svc := range services {
+		if len(svc.Endpoints) == 0 {
+			addWarning(ctx, "%s was started again after a server restart", svc.ID)
+			continue
+		}
+		endpoints, _ := json.Marshal(svc.Endpoints)
+		addWarning(ctx, "%s was started again after a server restart, its endpoints are now %s", svc.ID, endpoints)
+	}
+	if err != nil {
+		addWarning(ctx, "Failed to start services again after a server restart: %s", err)
 	}
-	return repo, env, nil
+}
+
+// checkTransferPath resolves a host path tools copy files from or to. It must be in the repository, in the worktree of
+// a host environment or in a host path the configuration of the repository allows, writable for the files written.
+func checkTransferPath(repo *repository.Repository, env *environment.Environment, path string, write bool) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("host_path must be absolute: %s", path)
+	}
+	// The configuration is read from the user's repository, where agents can't change it
+	config := environment.DefaultConfig()
+	if err := config.Load(repo.SourcePath()); err != nil {
+		return "", err
+	}
+	dirs := []string{repo.SourcePath()}
+	if env != nil && env.IsHost() {
+		dirs = append(dirs, env.State.Config.Workdir)
+	}
+	return config.HostPaths.Check(path, write, dirs...)
 }
 
 type Tool struct {
@@ -58,21 +151,32 @@ type Tool struct {
 	Handler    server.ToolHandlerFunc
 }
 
-func RunStdioServer(ctx context.Context, dag *dagger.Client) error {
+func newMCPServer(dag *dagger.Client) *server.MCPServer {
+	hooks := &server.Hooks{}
+	hooks.AddBeforeCallTool(tagRequestID)
 	s := server.NewMCPServer(
 		"Dagger",
 		"1.0.0",
 		server.WithInstructions(rules.AgentRules),
+		server.WithResourceCapabilities(false, false),
+		server.WithHooks(hooks),
 	)
+	s.AddNotificationHandler(methodNotificationCancelled, handleCancelled)
 
 	for _, t := range tools {
 		s.AddTool(t.Definition, wrapToolWithClient(t, dag).Handler)
 	}
+	addResources(s)
 
 	// Add kill tool
 	s.AddTool(EnvironmentKillBackgroundTool.Definition, wrapToolWithClient(EnvironmentKillBackgroundTool, dag).Handler)
+	return s
+}
+
+func RunStdioServer(ctx context.Context, dag *dagger.Client) error {
+	s := newMCPServer(dag)
 
-	slog.Info("starting server")
+	slog.InfoContext(ctx, "starting server")
 
 	stdioSrv := server.NewStdioServer(s)
 	stdioSrv.SetErrorLogger(log.Default()) // this should re-use our `slog` handler
@@ -80,7 +184,7 @@ func RunStdioServer(ctx context.Context, dag *dagger.Client) error {
 	ctx, cancel := signal.NotifyContext(ctx, getNotifySignals()...)
 	defer cancel()
 
-	err := stdioSrv.Listen(ctx, os.Stdin, os.Stdout)
+	err := stdioSrv.Listen(ctx, cancellationReader(os.Stdin), os.Stdout)
 	if err != nil && !errors.Is(err, context.Canceled) {
 		return err
 	}
@@ -103,15 +207,18 @@ func wrapTool(tool *Tool) *Tool {
 	return &Tool{
 		Definition: tool.Definition,
 		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-			slog.Info("Tool called", "tool", tool.Definition.Name)
+			ctx = withLogAttrs(ctx, toolCallLogAttrs(ctx, tool.Definition.Name, request)...)
+			slog.InfoContext(ctx, "Tool called")
 			defer func() {
-				slog.Info("Tool finished", "tool", tool.Definition.Name)
+				slog.InfoContext(ctx, "Tool finished")
 			}()
+			ctx, done := withCancellation(ctx, request)
+			defer done()
+			call := &toolCall{tool: tool.Definition.Name, started: time.Now(), readOnly: readOnly(tool.Definition)}
+			defer call.unlockEnvironments()
+			ctx = context.WithValue(ctx, toolCallKey{}, call)
 			response, err := tool.Handler(ctx, request)
-			if err != nil {
-				return mcp.NewToolResultError(err.Error()), nil
-			}
-			return response, nil
+			return call.response(response, err), nil
 		},
 	}
 }
@@ -131,44 +238,101 @@ func init() {
 	registerTool(
 		EnvironmentOpenTool,
 		EnvironmentCreateTool,
+		EnvironmentCreateStatusTool,
 		EnvironmentUpdateMetadataTool,
 		EnvironmentConfigTool,
 
 		EnvironmentRunCmdTool,
+		EnvironmentInstallDepsTool,
+		EnvironmentFormatTool,
+		EnvironmentCommandOutputTool,
+		EnvironmentJobStartTool,
+		EnvironmentJobStatusTool,
+		EnvironmentJobResultTool,
+		EnvironmentRespondTool,
+		EnvironmentMatrixRunTool,
+		EnvironmentVerifyReproducibleTool,
+		EnvironmentScheduleAddTool,
+		EnvironmentScheduleListTool,
+		EnvironmentScheduleRemoveTool,
 
 		EnvironmentFileReadTool,
 		EnvironmentFileListTool,
+		EnvironmentWatchTool,
 		EnvironmentFileWriteTool,
+		EnvironmentFileUploadTool,
+		EnvironmentFileDownloadTool,
+		EnvironmentCopyFileTool,
 		EnvironmentFileEditTool,
+		EnvironmentApplyPatchTool,
 		EnvironmentFileDeleteTool,
+		EnvironmentFileSearchTool,
+		EnvironmentDataPreviewTool,
 
 		EnvironmentAddServiceTool,
+		EnvironmentPsTool,
+		EnvironmentLogsTool,
+		EnvironmentStopServiceTool,
+
+		EnvironmentBuildImageTool,
+
+		EnvironmentPreviewUpTool,
+		EnvironmentPreviewDownTool,
+
+		EnvironmentIaCPlanTool,
+		EnvironmentGrantCloudAccessTool,
+
+		EnvironmentStatsTool,
+		EnvironmentNetstatTool,
+		EnvironmentTerminalTool,
 
 		EnvironmentCheckpointTool,
+		EnvironmentExportTool,
+		EnvironmentImportTool,
+
+		EnvironmentSendTool,
+		EnvironmentReceiveTool,
+
+		EnvironmentDiffTool,
+		EnvironmentHistoryTool,
+		EnvironmentMergeTool,
+		EnvironmentDeleteTool,
+		EnvironmentReviewTool,
+		EnvironmentReviewCommentTool,
 	)
 }
 
 type EnvironmentResponse struct {
 	ID              string                         `json:"id"`
 	Title           string                         `json:"title"`
+	Description     string                         `json:"description,omitempty"`
 	Config          *environment.EnvironmentConfig `json:"config"`
 	RemoteRef       string                         `json:"remote_ref"`
 	CheckoutCommand string                         `json:"checkout_command_to_share_with_user"`
 	LogCommand      string                         `json:"log_command_to_share_with_user"`
 	DiffCommand     string                         `json:"diff_command_to_share_with_user"`
 	Services        []*environment.Service         `json:"services,omitempty"`
+	// DetectedStack tells agents what was chosen for projects without configuration
+	DetectedStack *environment.DetectedStack `json:"detected_stack,omitempty"`
+	// InstructionsFile is the file of the repository with instructions for agents, read through InstructionsResource
+	InstructionsFile     string `json:"instructions_file,omitempty"`
+	InstructionsResource string `json:"instructions_resource_to_read,omitempty"`
+	// Staleness tells how far behind the default branch the environment is, in environment_list
+	Staleness *repository.Staleness `json:"staleness,omitempty"`
 }
 
 func environmentResponseFromEnvInfo(envInfo *environment.EnvironmentInfo) *EnvironmentResponse {
 	return &EnvironmentResponse{
 		ID:              envInfo.ID,
 		Title:           envInfo.State.Title,
+		Description:     envInfo.State.Description,
 		Config:          envInfo.State.Config,
 		RemoteRef:       fmt.Sprintf("container-use/%s", envInfo.ID),
 		CheckoutCommand: fmt.Sprintf("container-use checkout %s", envInfo.ID),
 		LogCommand:      fmt.Sprintf("container-use log %s", envInfo.ID),
 		DiffCommand:     fmt.Sprintf("container-use diff %s", envInfo.ID),
 		Services:        nil, // EnvironmentInfo doesn't have "active" services, specifically useful for EndpointMappings
+		DetectedStack:   envInfo.State.DetectedStack,
 	}
 }
 
@@ -210,10 +374,19 @@ func EnvironmentInfoToCallResult(envInfo *environment.EnvironmentInfo) (*mcp.Cal
 	return mcp.NewToolResultText(out), nil
 }
 
+func templateNames() string {
+	names := []string{}
+	for _, t := range environment.Templates() {
+		names = append(names, fmt.Sprintf("%s (%s)", t.Name, t.Description))
+	}
+	return strings.Join(names, ", ")
+}
+
 var EnvironmentOpenTool = &Tool{
 	Definition: newEnvironmentTool(
 		"environment_open",
 		"Opens an existing environment. Return format is same as environment_create.",
+		mcp.WithReadOnlyHintAnnotation(true),
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		_, env, err := openEnvironment(ctx, request)
@@ -229,11 +402,61 @@ var EnvironmentCreateTool = &Tool{
 		"environment_create",
 		`Creates a new development environment.
 The environment is the result of a the setups commands on top of the base image.
-Environment configuration is managed by the user via cu config commands.`,
+Environment configuration is managed by the user via cu config commands, overrides only apply to the new environment.`,
 		mcp.WithString("title",
 			mcp.Description("Short description of the work that is happening in this environment."),
 			mcp.Required(),
 		),
+		mcp.WithString("template",
+			mcp.Description("Preset configuration to start from, when the user asks for one: "+templateNames()+". The user's configuration still applies on top of it."),
+		),
+		mcp.WithObject("overrides",
+			mcp.Description("Tweaks of the configuration for this environment only, applied on top of the user's configuration. Use it rather than environment_config to change a few settings."),
+			mcp.Properties(map[string]any{
+				"base_image": map[string]any{
+					"type":        "string",
+					"description": "Base image replacing the configured one",
+				},
+				"workdir": map[string]any{
+					"type":        "string",
+					"description": "Absolute workdir replacing the configured one",
+				},
+				"setup_commands": map[string]any{
+					"type":        "array",
+					"description": "Commands run after the configured setup commands",
+					"items":       map[string]any{"type": "string"},
+				},
+				"install_commands": map[string]any{
+					"type":        "array",
+					"description": "Commands run after the configured install commands",
+					"items":       map[string]any{"type": "string"},
+				},
+				"env": map[string]any{
+					"type":        "array",
+					"description": "Environment variables to set, keeping the other configured ones (e.g. `[\"LOG_LEVEL=debug\"]`).",
+					"items":       map[string]any{"type": "string"},
+				},
+				"unset_env": map[string]any{
+					"type":        "array",
+					"description": "Names of configured environment variables to remove",
+					"items":       map[string]any{"type": "string"},
+				},
+				"ports": map[string]any{
+					"type":        "array",
+					"description": "Ports the application listens on, in addition to the configured ones",
+					"items":       map[string]any{"type": "number"},
+				},
+			}),
+		),
+		mcp.WithNumber("max_tool_calls",
+			mcp.Description("Budget of tool calls on the environment, when the user asks for one. Once used up, only the user can extend it."),
+		),
+		mcp.WithNumber("max_command_minutes",
+			mcp.Description("Budget of command run time on the environment, in minutes, when the user asks for one. Once used up, only the user can extend it."),
+		),
+		mcp.WithBoolean("async",
+			mcp.Description("Return a job_id right away while the environment is set up in the background, then poll environment_create_status. Use it when tool calls time out before heavyweight environments are created."),
+		),
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		repo, err := openRepository(ctx, request)
@@ -250,43 +473,90 @@ Environment configuration is managed by the user via cu config commands.`,
 			return nil, fmt.Errorf("dagger client not found in context")
 		}
 
-		env, err := repo.Create(ctx, dag, title, request.GetString("explanation", ""))
-		if err != nil {
-			return nil, fmt.Errorf("failed to create environment: %w", err)
+		if err := checkEnvironmentLimit(ctx, repo); err != nil {
+			return nil, err
 		}
 
-		out, err := marshalEnvironment(env)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal environment: %w", err)
+		opts := repository.CreateOpts{Template: request.GetString("template", "")}
+		if overrides, ok := request.GetArguments()["overrides"]; ok && overrides != nil {
+			raw, err := json.Marshal(overrides)
+			if err != nil {
+				return nil, err
+			}
+			if opts.Overrides, err = environment.ParseConfigOverlay(raw); err != nil {
+				return nil, err
+			}
 		}
 
-		dirty, status, err := repo.IsDirty(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("unable to check if environment is dirty: %w", err)
+		create := func(ctx context.Context) (*mcp.CallToolResult, error) {
+			return createEnvironment(ctx, repo, dag, title, opts, request)
 		}
+		if request.GetBool("async", false) {
+			return startCreationJob(ctx, create).status(), nil
+		}
+		return create(ctx)
+	},
+}
+
+// createEnvironment creates an environment as requested to environment_create, once the request is validated
+func createEnvironment(ctx context.Context, repo *repository.Repository, dag *dagger.Client, title string, opts repository.CreateOpts, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	env, err := repo.Create(ctx, dag, title, request.GetString("explanation", ""), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create environment: %w", err)
+	}
+	recordEnvironment(ctx, env)
 
-		if !dirty {
-			return mcp.NewToolResultText(out), nil
+	budget := environment.Budget{
+		MaxToolCalls:   request.GetInt("max_tool_calls", 0),
+		MaxCommandTime: time.Duration(request.GetFloat("max_command_minutes", 0) * float64(time.Minute)),
+	}
+	if budget != (environment.Budget{}) {
+		if _, err := repo.SetBudget(ctx, env.ID, budget); err != nil {
+			return nil, err
 		}
+		env.State.Budget = &budget
+	}
 
-		return mcp.NewToolResultText(fmt.Sprintf(`%s
+	resp := environmentResponseFromEnv(env)
+	if file, _, err := repo.AgentInstructions(); err != nil {
+		return nil, fmt.Errorf("failed to load repository instructions: %w", err)
+	} else if file != "" {
+		resp.InstructionsFile = file
+		resp.InstructionsResource = instructionsResource(repo.SourcePath())
+		addWarning(ctx, "This repository has instructions for agents in %s. Read the resource %s and follow them while working in this environment.",
+			file, resp.InstructionsResource)
+	}
 
-CRITICAL: You MUST inform the user that the repository %s has uncommitted changes that are NOT included in this environment. The environment was created from the last committed state only.
+	dirty, status, err := repo.IsDirty(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to check if environment is dirty: %w", err)
+	}
+	if dirty {
+		addWarning(ctx, `CRITICAL: You MUST inform the user that the repository %s has uncommitted changes that are NOT included in this environment. The environment was created from the last committed state only.
 
 Uncommitted changes detected:
 %s
 
-You MUST tell the user: To include these changes in the environment, they need to commit them first using git commands outside the environment.`, out, request.GetString("environment_source", ""), status)), nil
-	},
+You MUST tell the user: To include these changes in the environment, they need to commit them first using git commands outside the environment.`, request.GetString("environment_source", ""), status)
+	}
+
+	out, err := json.Marshal(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal environment: %w", err)
+	}
+	return mcp.NewToolResultText(string(out)), nil
 }
 
 var EnvironmentUpdateMetadataTool = &Tool{
 	Definition: newEnvironmentTool(
 		"environment_update_metadata",
-		"Update environment metadata such as title. This updates the descriptive information about what work is being done in the environment.",
+		"Update environment metadata such as title and description. This updates the descriptive information about what work is being done in the environment.",
 		mcp.WithString("title",
 			mcp.Description("Updated title describing the work being done in this environment."),
 		),
+		mcp.WithString("description",
+			mcp.Description("Updated longer description of the work being done in this environment."),
+		),
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		repo, env, err := openEnvironment(ctx, request)
@@ -298,6 +568,9 @@ var EnvironmentUpdateMetadataTool = &Tool{
 		if title := request.GetString("title", ""); title != "" {
 			env.State.Title = title
 		}
+		if description := request.GetString("description", ""); description != "" {
+			env.State.Description = description
+		}
 
 		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
 			return nil, fmt.Errorf("unable to update the environment: %w", err)
@@ -307,7 +580,7 @@ var EnvironmentUpdateMetadataTool = &Tool{
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal environment: %w", err)
 		}
-		return mcp.NewToolResultText(fmt.Sprintf("Environment metadata updated successfully.\n%s", out)), nil
+		return mcp.NewToolResultText(out), nil
 	},
 }
 
@@ -336,6 +609,9 @@ var EnvironmentConfigTool = &Tool{
 				},
 			}),
 		),
+		mcp.WithBoolean("dry_run",
+			mcp.Description("Only validate the config (images exist, commands parse, ports are valid) and return a plan of what applying it would change, without touching the environment."),
+		),
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		repo, env, err := openEnvironment(ctx, request)
@@ -368,6 +644,18 @@ var EnvironmentConfigTool = &Tool{
 			}
 		}
 
+		if request.GetBool("dry_run", false) {
+			plan, err := env.PlanConfig(ctx, updatedConfig, repo.SourcePath())
+			if err != nil {
+				return nil, fmt.Errorf("unable to plan the config: %w", err)
+			}
+			out, err := json.Marshal(plan)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal plan: %w", err)
+			}
+			return mcp.NewToolResultText(string(out)), nil
+		}
+
 		if err := env.UpdateConfig(ctx, updatedConfig); err != nil {
 			return nil, fmt.Errorf("unable to update the environment: %w", err)
 		}
@@ -395,7 +683,7 @@ TELL THE USER: To make these changes persistent, they will have to run "cu confi
 var EnvironmentListTool = &Tool{
 	Definition: newRepositoryTool(
 		"environment_list",
-		"List available environments",
+		"List available environments. Environments the default branch moved on from have a staleness: stale ones are far behind, with a suggestion to share with the user to bring them up to date.",
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		repo, err := openRepository(ctx, request)
@@ -411,6 +699,10 @@ var EnvironmentListTool = &Tool{
 		responses := make([]EnvironmentResponse, len(envInfos))
 		for i, envInfo := range envInfos {
 			responses[i] = *environmentResponseFromEnvInfo(envInfo)
+			// Repositories without a default branch have nothing to compare with
+			if staleness, err := repo.Staleness(ctx, envInfo); err == nil {
+				responses[i].Staleness = staleness
+			}
 		}
 
 		out, err := json.Marshal(responses)
@@ -444,6 +736,13 @@ Failure to do so will result in the tool being stuck, awaiting for the command t
 			mcp.Description("Ports to expose. Only works with background environments. For each port, returns the environment_internal (for use inside environments) and host_external (for use by the user) addresses."),
 			mcp.Items(map[string]any{"type": "number"}),
 		),
+		mcp.WithNumber("timeout",
+			mcp.Description("Seconds after which the command is interrupted, for commands not run in the background. Defaults to the timeout of the server."),
+		),
+		mcp.WithArray("outputs",
+			mcp.Description("Files and directories the command produces, relative to the workdir (e.g. `[\"dist\"]`), for commands not run in the background. Once the command succeeds, the hashes of their files are recorded in a manifest, for environment_verify_reproducible to check that the command is deterministic."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		repo, env, err := openEnvironment(ctx, request)
@@ -455,7 +754,8 @@ Failure to do so will result in the tool being stuck, awaiting for the command t
 		shell := request.GetString("shell", "sh")
 
 		updateRepo := func() error {
-			if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
+			// The changes made by interrupted commands are kept too
+			if err := repo.Update(context.WithoutCancel(ctx), env, request.GetString("explanation", "")); err != nil {
 				return fmt.Errorf("failed to update repository: %w", err)
 			}
 			return nil
@@ -469,6 +769,9 @@ Failure to do so will result in the tool being stuck, awaiting for the command t
 					ports = append(ports, int(port.(float64)))
 				}
 			}
+			if err := checkBackgroundLimit(env); err != nil {
+				return nil, err
+			}
 			endpoints, runErr := env.RunBackground(ctx, command, shell, ports, request.GetBool("use_entrypoint", false))
 			// We want to update the repository even if the command failed.
 			if err := updateRepo(); err != nil {
@@ -493,100 +796,165 @@ Background commands are unaffected by filesystem and any other kind of changes.
 				string(out), env.State.Config.Workdir, env.ID)), nil
 		}
 
-		stdout, runErr := env.Run(ctx, command, shell, request.GetBool("use_entrypoint", false))
+		release, err := acquireCommandSlot(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+
+		timeout := CommandTimeout
+		if seconds := request.GetFloat("timeout", 0); seconds > 0 {
+			timeout = time.Duration(seconds * float64(time.Second))
+		}
+		runCtx := ctx
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			runCtx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		stdout, failure, runErr := env.Run(runCtx, command, shell, request.GetBool("use_entrypoint", false))
+		var tests *environment.TestRunDiff
+		var manifest *environment.OutputManifest
+		if runErr == nil {
+			tests = env.RecordTestRun(command, stdout)
+			if outputs := request.GetStringSlice("outputs", nil); len(outputs) > 0 && failure == nil {
+				var err error
+				if manifest, err = env.RecordManifest(ctx, command, shell, outputs); err != nil {
+					addWarning(ctx, "The output manifest wasn't recorded: %s", err)
+				}
+			}
+		}
 		// We want to update the repository even if the command failed.
 		if err := updateRepo(); err != nil {
 			return nil, err
 		}
 		if runErr != nil {
+			if errors.Is(runErr, context.DeadlineExceeded) && ctx.Err() == nil {
+				return nil, fmt.Errorf("command timed out after %s, run long commands in the background or with a longer timeout: %w", timeout, runErr)
+			}
 			return nil, fmt.Errorf("failed to run command: %w", runErr)
 		}
+		recordFailure(ctx, failure)
+		recordTests(ctx, tests)
 
+		if manifest != nil {
+			stdout += fmt.Sprintf("\n\nRecorded the hashes of %d output files: check the command is reproducible with environment_verify_reproducible", len(manifest.Files))
+		}
 		return mcp.NewToolResultText(fmt.Sprintf("%s\n\nAny changes to the container workdir (%s) have been committed and pushed to container-use/ remote", stdout, env.State.Config.Workdir)), nil
 	},
 }
 
-var EnvironmentFileReadTool = &Tool{
+var EnvironmentInstallDepsTool = &Tool{
 	Definition: newEnvironmentTool(
-		"environment_file_read",
-		"Read the contents of a file, specifying a line range or the entire file.",
-		mcp.WithString("target_file",
-			mcp.Description("Path of the file to read, absolute or relative to the workdir"),
-			mcp.Required(),
-		),
-		mcp.WithBoolean("should_read_entire_file",
-			mcp.Description("Whether to read the entire file. Defaults to false."),
-		),
-		mcp.WithNumber("start_line_one_indexed_inclusive",
-			mcp.Description("The one-indexed line number to start reading from (inclusive)."),
+		"environment_install_deps",
+		`Install the dependencies of a project of the environment with its package manager, detected from its files: go mod, cargo, npm, pnpm or yarn, pip, poetry, uv, pipenv or bundler.
+The dependency caches of the project are mounted during the install, so reinstalling is fast. Use it instead of guessing the install commands of an ecosystem.
+Returns the package managers and commands used, and the output of the install.`,
+		mcp.WithString("dir",
+			mcp.Description("The directory of the project, relative to the workdir. Defaults to the workdir."),
 		),
-		mcp.WithNumber("end_line_one_indexed_inclusive",
-			mcp.Description("The one-indexed line number to end reading at (inclusive)."),
+		mcp.WithString("language",
+			mcp.Description("The language to install the dependencies of, when the project uses several. Defaults to all of them."),
+			mcp.Enum("go", "rust", "node", "python", "ruby"),
 		),
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		_, env, err := openEnvironment(ctx, request)
+		repo, env, err := openEnvironment(ctx, request)
 		if err != nil {
 			return nil, err
 		}
 
-		targetFile, err := request.RequireString("target_file")
-		if err != nil {
-			return nil, err
+		install, installErr := env.InstallDependencies(ctx, request.GetString("dir", ""), request.GetString("language", ""), environment.ProjectCacheKey(repo.SourcePath()))
+		// We want to update the repository even if the install failed.
+		if err := repo.Update(context.WithoutCancel(ctx), env, request.GetString("explanation", "")); err != nil {
+			return nil, fmt.Errorf("failed to update env: %w", err)
 		}
-		shouldReadEntireFile := request.GetBool("should_read_entire_file", false)
-		startLineOneIndexedInclusive := request.GetInt("start_line_one_indexed_inclusive", 0)
-		endLineOneIndexedInclusive := request.GetInt("end_line_one_indexed_inclusive", 0)
+		if installErr != nil {
+			return nil, fmt.Errorf("failed to install dependencies: %w", installErr)
+		}
+		recordFailure(ctx, install.Failure)
 
-		fileContents, err := env.FileRead(ctx, targetFile, shouldReadEntireFile, startLineOneIndexedInclusive, endLineOneIndexedInclusive)
+		out, err := json.Marshal(install)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read file: %w", err)
+			return nil, fmt.Errorf("failed to marshal install: %w", err)
 		}
-
-		return mcp.NewToolResultText(fileContents), nil
+		return mcp.NewToolResultText(string(out)), nil
 	},
 }
 
-var EnvironmentFileListTool = &Tool{
+var EnvironmentFormatTool = &Tool{
 	Definition: newEnvironmentTool(
-		"environment_file_list",
-		"List the contents of a directory",
-		mcp.WithString("path",
-			mcp.Description("Path of the directory to list contents of, absolute or relative to the workdir"),
-			mcp.Required(),
+		"environment_format",
+		`Format the files changed in the environment with the formatter of their language: gofmt (goimports when installed), ruff format (black when installed instead), prettier and rustfmt.
+Run it before finishing, so the changes pass the formatting checks of the project. Only files the environment changed are formatted, unless files are given.
+Returns the files reformatted, and the formatters not installed in the environment.`,
+		mcp.WithArray("files",
+			mcp.Description("The files to format, relative to the workdir. Defaults to the files changed in the environment."),
+			mcp.Items(map[string]any{"type": "string"}),
 		),
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		_, env, err := openEnvironment(ctx, request)
+		repo, env, err := openEnvironment(ctx, request)
 		if err != nil {
 			return nil, err
 		}
 
-		path, err := request.RequireString("path")
-		if err != nil {
-			return nil, err
+		files := request.GetStringSlice("files", nil)
+		if len(files) == 0 {
+			if files, err = repo.ChangedFiles(ctx, env.ID); err != nil {
+				return nil, fmt.Errorf("failed to list changed files: %w", err)
+			}
+		}
+		result, formatErr := env.Format(ctx, files)
+		// We want to update the repository even if formatting failed.
+		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
+			return nil, fmt.Errorf("failed to update env: %w", err)
+		}
+		if formatErr != nil {
+			return nil, fmt.Errorf("failed to format: %w", formatErr)
 		}
 
-		out, err := env.FileList(ctx, path)
+		out, err := json.Marshal(result)
 		if err != nil {
-			return nil, fmt.Errorf("failed to list directory: %w", err)
+			return nil, fmt.Errorf("failed to marshal result: %w", err)
 		}
-
-		return mcp.NewToolResultText(out), nil
+		return mcp.NewToolResultText(string(out)), nil
 	},
 }
 
-var EnvironmentFileWriteTool = &Tool{
+var EnvironmentMatrixRunTool = &Tool{
 	Definition: newEnvironmentTool(
-		"environment_file_write",
-		"Write the contents of a file.",
-		mcp.WithString("target_file",
-			mcp.Description("Path of the file to write, absolute or relative to the workdir."),
-			mcp.Required(),
+		"environment_matrix_run",
+		`Run a command in parallel in clones of the environment, one per variant of parameters (env vars, commands), e.g. a test suite against several versions or configurations.
+Variants are isolated from each other and their changes are discarded: use environment_run_cmd to change the environment.
+Returns the exit code and the tail of the output of each variant.`,
+		mcp.WithString("command",
+			mcp.Description("The terminal command to execute in each variant, unless the variant sets its own."),
 		),
-		mcp.WithString("contents",
-			mcp.Description("Full text content of the file you want to write."),
+		mcp.WithArray("variants",
+			mcp.Description(fmt.Sprintf("The variants to run, at most %d.", environment.MaxMatrixVariants)),
 			mcp.Required(),
+			mcp.Items(map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"name": map[string]any{
+						"type":        "string",
+						"description": "Name of the variant in the results (default: variant-<n>)",
+					},
+					"env": map[string]any{
+						"type":        "array",
+						"items":       map[string]any{"type": "string"},
+						"description": "Environment variables of the variant (e.g. `[\"PYTHON_VERSION=3.12\"]`)",
+					},
+					"command": map[string]any{
+						"type":        "string",
+						"description": "Command of the variant, replacing the shared command",
+					},
+				},
+			}),
+		),
+		mcp.WithString("shell",
+			mcp.Description("The shell that will be interpreting the commands (default: sh)"),
 		),
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -595,102 +963,1634 @@ var EnvironmentFileWriteTool = &Tool{
 			return nil, err
 		}
 
-		targetFile, err := request.RequireString("target_file")
+		var variants []environment.MatrixVariant
+		raw, err := json.Marshal(request.GetArguments()["variants"])
 		if err != nil {
 			return nil, err
 		}
-		contents, err := request.RequireString("contents")
-		if err != nil {
-			return nil, err
+		if err := json.Unmarshal(raw, &variants); err != nil {
+			return nil, fmt.Errorf("invalid variants: %w", err)
 		}
 
-		if err := env.FileWrite(ctx, request.GetString("explanation", ""), targetFile, contents); err != nil {
-			return nil, fmt.Errorf("failed to write file: %w", err)
+		results, err := env.RunMatrix(ctx, request.GetString("command", ""), request.GetString("shell", "sh"), variants)
+		if err != nil {
+			return nil, fmt.Errorf("failed to run matrix: %w", err)
 		}
-
 		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
-			return nil, fmt.Errorf("unable to update the environment: %w", err)
+			return nil, fmt.Errorf("failed to update env: %w", err)
 		}
 
-		return mcp.NewToolResultText(fmt.Sprintf("file %s written successfully and committed to container-use/ remote", targetFile)), nil
+		out, err := json.Marshal(results)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal results: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
 	},
 }
 
-var EnvironmentFileEditTool = &Tool{
-	Definition: mcp.NewTool("environment_file_edit",
-		mcp.WithDescription("Find and replace text in a file."),
-		mcp.WithString("explanation",
-			mcp.Description("One sentence explanation for why this file is being edited."),
-		),
-		mcp.WithString("environment_source",
-			mcp.Description("Absolute path to the source git repository for the environment."),
-			mcp.Required(),
-		),
-		mcp.WithString("environment_id",
-			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
-			mcp.Required(),
-		),
-		mcp.WithString("target_file",
-			mcp.Description("Path of the file to write, absolute or relative to the workdir."),
-			mcp.Required(),
-		),
-		mcp.WithString("search_text",
-			mcp.Description("The text to find and replace."),
-			mcp.Required(),
-		),
-		mcp.WithString("replace_text",
-			mcp.Description("The text to insert."),
+var EnvironmentVerifyReproducibleTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_verify_reproducible",
+		`Verify that a command produces the same files when run again from scratch, e.g. that a build is deterministic.
+The command must have been run with environment_run_cmd and its outputs, which recorded the hashes of the files it produced in a manifest.
+It runs again in a fresh fork of the environment, set up with its configuration from its last commit without the outputs, and the files it produces are compared with the manifest. The fork is discarded and the environment is left untouched. Only works in container mode.
+Returns whether the command is reproducible, with the files whose contents differ, the files missing and the unexpected files.`,
+		mcp.WithString("command",
+			mcp.Description("The command to verify, as run with environment_run_cmd."),
 			mcp.Required(),
 		),
-		mcp.WithString("which_match",
-			mcp.Description("The ID of the match to replace, if there were multiple matches."),
-		),
+		mcp.WithReadOnlyHintAnnotation(true),
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		repo, env, err := openEnvironment(ctx, request)
 		if err != nil {
-			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+			return nil, err
 		}
-
-		targetFile, err := request.RequireString("target_file")
+		command, err := request.RequireString("command")
 		if err != nil {
 			return nil, err
 		}
-		search, err := request.RequireString("search_text")
+		dag, ok := ctx.Value(daggerClientKey{}).(*dagger.Client)
+		if !ok {
+			return nil, fmt.Errorf("dagger client not found in context")
+		}
+		source, err := repo.Tree(ctx, dag, env.ID)
 		if err != nil {
 			return nil, err
 		}
-		replace, err := request.RequireString("replace_text")
+
+		release, err := acquireCommandSlot(ctx)
 		if err != nil {
 			return nil, err
 		}
+		defer release()
 
-		if err := env.FileEdit(ctx,
-			request.GetString("explanation", ""),
-			targetFile,
-			search,
-			replace,
-			request.GetString("which_match", ""),
-		); err != nil {
-			return mcp.NewToolResultErrorFromErr("failed to write file", err), nil
+		report, err := env.VerifyReproducible(ctx, source, command)
+		if err != nil {
+			return nil, err
+		}
+		out, err := json.Marshal(report)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal report: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentCommandOutputTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_command_output",
+		`Read the full output of a command recorded in the environment log.
+Large outputs are truncated in the log, which refers to the full output by ID (e.g. "[120000 bytes truncated, full output 1234567]").`,
+		mcp.WithString("output_id",
+			mcp.Description("The ID of the full output."),
+			mcp.Required(),
+		),
+		mcp.WithString("stream",
+			mcp.Description("The output stream to read."),
+			mcp.Enum("stdout", "stderr"),
+		),
+		mcp.WithNumber("offset",
+			mcp.Description("The byte offset to start reading from (default: 0)."),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("The maximum number of bytes to read (default: 65536)."),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		id, err := request.RequireString("output_id")
+		if err != nil {
+			return nil, err
+		}
+		offset := int64(request.GetInt("offset", 0))
+		output, size, err := environment.CommandOutput(id, request.GetString("stream", "stdout"), offset, int64(request.GetInt("limit", 64*1024)))
+		if err != nil {
+			return nil, err
+		}
+
+		return mcp.NewToolResultText(fmt.Sprintf("%s\n\n[bytes %d-%d of %d]", output, offset, offset+int64(len(output)), size)), nil
+	},
+}
+
+var EnvironmentJobStartTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_job_start",
+		`Start a long running command as a job, detached from this tool call, and return its job ID immediately.
+Use this for commands that take longer than a few minutes (model training, large builds, full test suites).
+Poll the job with environment_job_status, then collect it with environment_job_result: changes the job made to the workdir are only committed when its result is collected.`,
+		mcp.WithString("command",
+			mcp.Description("The terminal command to execute."),
+			mcp.Required(),
+		),
+		mcp.WithString("shell",
+			mcp.Description("The shell that will be interpreting this command (default: sh)"),
+		),
+		mcp.WithBoolean("interactive",
+			mcp.Description(`Keep the standard input of the command open (host mode only). When the command prompts for input (e.g. a y/N confirmation),
+the job waits with state "waiting_input" and its prompt, reported by environment_job_status and a notification: reply with environment_respond.`),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		command, err := request.RequireString("command")
+		if err != nil {
+			return nil, err
+		}
+
+		var job *environment.Job
+		if request.GetBool("interactive", false) {
+			job, err = env.StartInteractiveJob(ctx, command, request.GetString("shell", "sh"), notifyPrompt(ctx, env.ID))
+		} else {
+			job, err = env.StartJob(ctx, command, request.GetString("shell", "sh"))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to start job: %w", err)
 		}
-
 		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
-			return mcp.NewToolResultErrorFromErr("unable to update the environment", err), nil
+			return nil, fmt.Errorf("failed to update env: %w", err)
 		}
 
-		return mcp.NewToolResultText(fmt.Sprintf("file %s edited successfully and committed to container-use/ remote", targetFile)), nil
+		out, err := json.Marshal(job)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal job: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
 	},
 }
 
-var EnvironmentFileDeleteTool = &Tool{
+var EnvironmentJobStatusTool = &Tool{
 	Definition: newEnvironmentTool(
-		"environment_file_delete",
-		"Deletes a file at the specified path.",
+		"environment_job_status",
+		`Get the state of a job started with environment_job_start, along with the tail of its output so far.
+Without a job ID, lists the jobs of the environment whose results haven't been collected.`,
+		mcp.WithString("job_id",
+			mcp.Description("The ID of the job."),
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		var status any = env.Jobs()
+		if id := request.GetString("job_id", ""); id != "" {
+			if status, err = env.JobStatus(id); err != nil {
+				return nil, err
+			}
+		}
+
+		out, err := json.Marshal(status)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal job status: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentJobResultTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_job_result",
+		`Collect the result of a finished job: its exit code and output.
+Changes the job made to the workdir are committed, unless the environment changed since the job started.
+Once collected, the job is forgotten.`,
+		mcp.WithString("job_id",
+			mcp.Description("The ID of the job."),
+			mcp.Required(),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		id, err := request.RequireString("job_id")
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := env.JobResult(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
+			return nil, fmt.Errorf("failed to update env: %w", err)
+		}
+
+		out, err := json.Marshal(result)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal job result: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentRespondTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_respond",
+		"Reply to the prompt of an interactive job waiting for input (state \"waiting_input\"), e.g. `y` to a y/N confirmation. The reply is sent followed by a newline.",
+		mcp.WithString("job_id",
+			mcp.Description("The ID of the job."),
+			mcp.Required(),
+		),
+		mcp.WithString("reply",
+			mcp.Description("The reply to the prompt, without the trailing newline. May be empty to accept the default answer."),
+			mcp.Required(),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		id, err := request.RequireString("job_id")
+		if err != nil {
+			return nil, err
+		}
+		reply, err := request.RequireString("reply")
+		if err != nil {
+			return nil, err
+		}
+
+		job, err := env.RespondJob(ctx, id, reply)
+		if err != nil {
+			return nil, err
+		}
+		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
+			return nil, fmt.Errorf("failed to update env: %w", err)
+		}
+
+		out, err := json.Marshal(job)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal job: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+// notifyPrompt returns the function notifying the client of the tool call when an interactive job waits for input
+func notifyPrompt(ctx context.Context, envID string) func(*environment.Job) {
+	srv := server.ServerFromContext(ctx)
+	// The job outlives the tool call, the notification goes to the same client
+	ctx = context.WithoutCancel(ctx)
+	return func(job *environment.Job) {
+		if srv == nil {
+			return
+		}
+		err := srv.SendNotificationToClient(ctx, "notifications/message", map[string]any{
+			"level":  "warning",
+			"logger": "container-use",
+			"data": fmt.Sprintf("Job %s of environment %s is waiting for input: %q. Reply with environment_respond.",
+				job.ID, envID, job.Prompt),
+		})
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to notify job prompt", "environment.id", envID, "job.id", job.ID, "err", err)
+		}
+	}
+}
+
+var EnvironmentScheduleAddTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_schedule_add",
+		`Schedule a command to run periodically in the environment, e.g. refreshing fixtures nightly.
+Scheduled commands run as jobs while the user runs the scheduler (container-use schedule run) and their changes are committed.
+Failures are delivered to the environment mailbox: check them with environment_receive.`,
+		mcp.WithString("cron",
+			mcp.Description("When to run the command, as a cron expression (minute hour day-of-month month day-of-week, e.g. \"0 3 * * *\") or an alias (@hourly, @daily, @weekly, @monthly)."),
+			mcp.Required(),
+		),
+		mcp.WithString("command",
+			mcp.Description("The terminal command to execute."),
+			mcp.Required(),
+		),
+		mcp.WithString("shell",
+			mcp.Description("The shell that will be interpreting this command (default: sh)"),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		cron, err := request.RequireString("cron")
+		if err != nil {
+			return nil, err
+		}
+		command, err := request.RequireString("command")
+		if err != nil {
+			return nil, err
+		}
+
+		schedule, err := env.AddSchedule(ctx, cron, command, request.GetString("shell", "sh"))
+		if err != nil {
+			return nil, err
+		}
+		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
+			return nil, fmt.Errorf("failed to update env: %w", err)
+		}
+
+		return mcp.NewToolResultText(fmt.Sprintf("Command scheduled as %s, next run at %s", schedule.ID, schedule.Next().Format(time.RFC3339))), nil
+	},
+}
+
+var EnvironmentScheduleListTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_schedule_list",
+		"List the commands scheduled in the environment with their recent runs.",
+		mcp.WithReadOnlyHintAnnotation(true),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		schedules := env.State.Schedules
+		if schedules == nil {
+			schedules = []*environment.Schedule{}
+		}
+		out, err := json.Marshal(schedules)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal schedules: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentScheduleRemoveTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_schedule_remove",
+		"Stop running a command scheduled with environment_schedule_add.",
+		mcp.WithString("schedule_id",
+			mcp.Description("The ID of the scheduled command."),
+			mcp.Required(),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		id, err := request.RequireString("schedule_id")
+		if err != nil {
+			return nil, err
+		}
+
+		if err := env.RemoveSchedule(id); err != nil {
+			return nil, err
+		}
+		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
+			return nil, fmt.Errorf("failed to update env: %w", err)
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("Schedule %s removed", id)), nil
+	},
+}
+
+var EnvironmentFileReadTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_file_read",
+		"Read the contents of a file, specifying a line range or the entire file. PNG, JPEG, GIF and WebP images are returned as images, for clients to show them.",
 		mcp.WithString("target_file",
-			mcp.Description("Path of the file to delete, absolute or relative to the workdir."),
+			mcp.Description("Path of the file to read, absolute or relative to the workdir"),
+			mcp.Required(),
+		),
+		mcp.WithBoolean("should_read_entire_file",
+			mcp.Description("Whether to read the entire file. Defaults to false."),
+		),
+		mcp.WithNumber("start_line_one_indexed_inclusive",
+			mcp.Description("The one-indexed line number to start reading from (inclusive)."),
+		),
+		mcp.WithNumber("end_line_one_indexed_inclusive",
+			mcp.Description("The one-indexed line number to end reading at (inclusive)."),
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		targetFile, err := request.RequireString("target_file")
+		if err != nil {
+			return nil, err
+		}
+		if imageMIMEType(targetFile) != "" {
+			contents, size, err := env.FileReadBytes(ctx, targetFile, 0, 0)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read file: %w", err)
+			}
+			if int64(len(contents)) < size {
+				return nil, fmt.Errorf("image %s is too large to be returned (%d bytes): use environment_file_download with a host_path", targetFile, size)
+			}
+			return &mcp.CallToolResult{
+				Content: []mcp.Content{
+					mcp.NewTextContent(fmt.Sprintf("image %s (%d bytes)", targetFile, size)),
+					fileContent(env.ID, targetFile, contents, true),
+				},
+			}, nil
+		}
+
+		shouldReadEntireFile := request.GetBool("should_read_entire_file", false)
+		startLineOneIndexedInclusive := request.GetInt("start_line_one_indexed_inclusive", 0)
+		endLineOneIndexedInclusive := request.GetInt("end_line_one_indexed_inclusive", 0)
+
+		fileContents, err := env.FileRead(ctx, targetFile, shouldReadEntireFile, startLineOneIndexedInclusive, endLineOneIndexedInclusive)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+
+		return mcp.NewToolResultText(fileContents), nil
+	},
+}
+
+var EnvironmentFileListTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_file_list",
+		"List the contents of a directory. In recursive mode, returns the tree below the directory as JSON, with the size, mode and modification time of every entry.",
+		mcp.WithString("path",
+			mcp.Description("Path of the directory to list contents of, absolute or relative to the workdir"),
 			mcp.Required(),
 		),
+		mcp.WithBoolean("recursive",
+			mcp.Description("Whether to list the subdirectories too, with the metadata of the entries. Defaults to false."),
+		),
+		mcp.WithNumber("max_depth",
+			mcp.Description("In recursive mode, the number of levels to list, 1 being the direct entries of the directory. Defaults to 3."),
+		),
+		mcp.WithArray("include",
+			mcp.Description("In recursive mode, only list the files matching these glob patterns (e.g. `[\"*.go\", \"src/**/*.ts\"]`)."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
+		mcp.WithArray("exclude",
+			mcp.Description("In recursive mode, skip files and directories matching these glob patterns (e.g. `[\"node_modules\", \"dist\"]`)."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
+		mcp.WithNumber("max_entries",
+			mcp.Description("In recursive mode, the maximum number of entries to return. Defaults to 1000."),
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		path, err := request.RequireString("path")
+		if err != nil {
+			return nil, err
+		}
+
+		if request.GetBool("recursive", false) {
+			result, err := env.FileListTree(ctx, environment.FileListOptions{
+				Path:       path,
+				MaxDepth:   request.GetInt("max_depth", 0),
+				Include:    request.GetStringSlice("include", nil),
+				Exclude:    request.GetStringSlice("exclude", nil),
+				MaxEntries: request.GetInt("max_entries", 0),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to list directory: %w", err)
+			}
+			out, err := json.Marshal(result)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal directory listing: %w", err)
+			}
+			return mcp.NewToolResultText(string(out)), nil
+		}
+
+		out, err := env.FileList(ctx, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list directory: %w", err)
+		}
+
+		return mcp.NewToolResultText(out), nil
+	},
+}
+
+var EnvironmentWatchTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_watch",
+		`Report the files added, modified and deleted in the environment since the last call, or since a marker returned by a previous call.
+Call it before a build or code generation step, then again after it to verify what the step changed. The first call only starts watching.`,
+		mcp.WithString("since",
+			mcp.Description("Marker returned by a previous call to report the changes since. Defaults to the last call."),
+		),
+		mcp.WithString("path",
+			mcp.Description("Directory to watch, absolute or relative to the workdir. Defaults to the workdir. Markers are for the directory they were returned for."),
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := env.Watch(ctx, request.GetString("since", ""), request.GetString("path", ""))
+		if err != nil {
+			return nil, fmt.Errorf("failed to watch files: %w", err)
+		}
+
+		out, err := json.Marshal(result)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal changes: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentFileWriteTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_file_write",
+		"Write the contents of a file.",
+		mcp.WithString("target_file",
+			mcp.Description("Path of the file to write, absolute or relative to the workdir."),
+			mcp.Required(),
+		),
+		mcp.WithString("contents",
+			mcp.Description("Full text content of the file you want to write."),
+			mcp.Required(),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		targetFile, err := request.RequireString("target_file")
+		if err != nil {
+			return nil, err
+		}
+		contents, err := request.RequireString("contents")
+		if err != nil {
+			return nil, err
+		}
+
+		if err := env.FileWrite(ctx, request.GetString("explanation", ""), targetFile, contents); err != nil {
+			return nil, fmt.Errorf("failed to write file: %w", err)
+		}
+
+		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
+			return nil, fmt.Errorf("unable to update the environment: %w", err)
+		}
+
+		warnSecrets(ctx, env)
+		return mcp.NewToolResultText(fmt.Sprintf("file %s written successfully and committed to container-use/ remote", targetFile)), nil
+	},
+}
+
+// warnSecrets warns agents about the credentials they just wrote, when the secret scan doesn't block them
+func warnSecrets(ctx context.Context, env *environment.Environment) {
+	findings := env.PopSecretFindings()
+	if len(findings) == 0 {
+		return
+	}
+	addWarning(ctx, "possible secrets written to the repository:\n%s\n"+
+		"Unless they are fake, replace them with environment variables or secrets before the changes are merged.",
+		environment.FormatSecretFindings(findings))
+}
+
+var EnvironmentFileUploadTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_file_upload",
+		`Copy a binary file (model weights, images, archives, ...) into the environment, either from a file on the host or from base64 encoded contents.
+Large contents can be uploaded in chunks with append. Binary files are kept in the environment but not committed to its branch.`,
+		mcp.WithString("target_file",
+			mcp.Description("Path of the file to write, absolute or relative to the workdir."),
+			mcp.Required(),
+		),
+		mcp.WithString("host_path",
+			mcp.Description("Absolute path of the file to copy from the host, in the repository or in a host path the user allowed."),
+		),
+		mcp.WithString("contents_base64",
+			mcp.Description("Base64 encoded contents of the file, if not copied from the host."),
+		),
+		mcp.WithBoolean("append",
+			mcp.Description("Append the contents to the file instead of replacing it, to upload large files in chunks. Defaults to false."),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		targetFile, err := request.RequireString("target_file")
+		if err != nil {
+			return nil, err
+		}
+		hostPath := request.GetString("host_path", "")
+		encoded, hasContents := request.GetArguments()["contents_base64"].(string)
+		switch {
+		case hostPath != "" && hasContents:
+			return nil, errors.New("host_path and contents_base64 are mutually exclusive")
+		case hostPath != "":
+			source, pathErr := checkTransferPath(repo, env, hostPath, false)
+			if pathErr != nil {
+				return nil, pathErr
+			}
+			err = env.FileUpload(ctx, source, targetFile)
+		case hasContents:
+			contents, decodeErr := base64.StdEncoding.DecodeString(encoded)
+			if decodeErr != nil {
+				return nil, fmt.Errorf("invalid contents_base64: %w", decodeErr)
+			}
+			err = env.FileWriteBytes(ctx, targetFile, contents, request.GetBool("append", false))
+		default:
+			return nil, errors.New("either host_path or contents_base64 is required")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload file: %w", err)
+		}
+
+		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
+			return nil, fmt.Errorf("unable to update the environment: %w", err)
+		}
+
+		return mcp.NewToolResultText(fmt.Sprintf("file %s uploaded successfully", targetFile)), nil
+	},
+}
+
+var EnvironmentFileDownloadTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_file_download",
+		`Copy a binary file out of the environment, either to a file on the host or as base64 encoded contents.
+Base64 contents are returned in chunks of up to 4MB: use offset to read the next chunk.
+With embed, the chunk is returned as an image or an embedded resource instead of base64 text, for clients to show or save it.`,
+		mcp.WithString("target_file",
+			mcp.Description("Path of the file to read, absolute or relative to the workdir."),
+			mcp.Required(),
+		),
+		mcp.WithString("host_path",
+			mcp.Description("Absolute path of the host file to copy to, in the repository or in a writable host path the user allowed. If not set, the contents are returned base64 encoded."),
+		),
+		mcp.WithNumber("offset",
+			mcp.Description("The byte offset to start reading from when returning the contents (default: 0)."),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("The maximum number of bytes to return (default and maximum: 4MB)."),
+		),
+		mcp.WithBoolean("embed",
+			mcp.Description("Return the contents as an image content for images, and as an embedded resource for other files, instead of base64 text. Defaults to false."),
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		targetFile, err := request.RequireString("target_file")
+		if err != nil {
+			return nil, err
+		}
+
+		if hostPath := request.GetString("host_path", ""); hostPath != "" {
+			target, err := checkTransferPath(repo, env, hostPath, true)
+			if err != nil {
+				return nil, err
+			}
+			if err := env.FileDownload(ctx, targetFile, target); err != nil {
+				return nil, fmt.Errorf("failed to download file: %w", err)
+			}
+			return mcp.NewToolResultText(fmt.Sprintf("file %s downloaded to %s", targetFile, hostPath)), nil
+		}
+
+		offset := int64(request.GetInt("offset", 0))
+		contents, size, err := env.FileReadBytes(ctx, targetFile, offset, int64(request.GetInt("limit", 0)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to download file: %w", err)
+		}
+		embed := request.GetBool("embed", false)
+		var encoded *string
+		if !embed {
+			contentsBase64 := base64.StdEncoding.EncodeToString(contents)
+			encoded = &contentsBase64
+		}
+		out, err := json.Marshal(struct {
+			ContentsBase64 *string `json:"contents_base64,omitempty"`
+			Offset         int64   `json:"offset"`
+			Length         int     `json:"length"`
+			Size           int64   `json:"size"`
+		}{encoded, offset, len(contents), size})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal file contents: %w", err)
+		}
+		if embed {
+			return &mcp.CallToolResult{
+				Content: []mcp.Content{mcp.NewTextContent(string(out)), fileContent(env.ID, targetFile, contents, offset == 0 && int64(len(contents)) == size)},
+			}, nil
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentCopyFileTool = &Tool{
+	Definition: newRepositoryTool(
+		"environment_copy_file",
+		`Copy a file from an environment to another environment of the same repository, e.g. when the output of one agent is the input of another.
+Between containers, the file is copied without going through the host, and binary files survive the copy.`,
+		mcp.WithString("from",
+			mcp.Description("The source file, as <environment_id>:<path>. The path is absolute or relative to the workdir."),
+			mcp.Required(),
+		),
+		mcp.WithString("to",
+			mcp.Description("The destination file, as <environment_id>:<path>. The path is absolute or relative to the workdir."),
+			mcp.Required(),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, err := openRepository(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		dag, ok := ctx.Value(daggerClientKey{}).(*dagger.Client)
+		if !ok {
+			return nil, fmt.Errorf("dagger client not found in context")
+		}
+		from, err := request.RequireString("from")
+		if err != nil {
+			return nil, err
+		}
+		to, err := request.RequireString("to")
+		if err != nil {
+			return nil, err
+		}
+		sourceID, sourceFile, err := parseEnvironmentPath(from)
+		if err != nil {
+			return nil, err
+		}
+		destID, targetFile, err := parseEnvironmentPath(to)
+		if err != nil {
+			return nil, err
+		}
+
+		// Only the destination changes, and is opened like the environment of any other tool call: the file is read
+		// from the last saved state of the source
+		dest, err := loadEnvironment(ctx, repo, destID)
+		if err != nil {
+			return nil, err
+		}
+		source, err := repo.Get(ctx, dag, sourceID)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get environment %s: %w", sourceID, err)
+		}
+
+		if err := source.CopyFileTo(ctx, sourceFile, dest, targetFile); err != nil {
+			return nil, fmt.Errorf("failed to copy file: %w", err)
+		}
+		if err := repo.Update(ctx, dest, request.GetString("explanation", "")); err != nil {
+			return nil, fmt.Errorf("unable to update the environment: %w", err)
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("file %s copied to %s", from, to)), nil
+	},
+}
+
+// parseEnvironmentPath splits <environment_id>:<path> references to files of environments
+func parseEnvironmentPath(s string) (string, string, error) {
+	envID, path, ok := strings.Cut(s, ":")
+	if !ok || envID == "" || path == "" {
+		return "", "", fmt.Errorf("invalid file reference %q: expected <environment_id>:<path>", s)
+	}
+	return envID, path, nil
+}
+
+var EnvironmentFileEditTool = &Tool{
+	Definition: mcp.NewTool("environment_file_edit",
+		mcp.WithDescription("Find and replace text in a file."),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this file is being edited."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithString("target_file",
+			mcp.Description("Path of the file to write, absolute or relative to the workdir."),
+			mcp.Required(),
+		),
+		mcp.WithString("search_text",
+			mcp.Description("The text to find and replace."),
+			mcp.Required(),
+		),
+		mcp.WithString("replace_text",
+			mcp.Description("The text to insert."),
+			mcp.Required(),
+		),
+		mcp.WithString("which_match",
+			mcp.Description("The ID of the match to replace, if there were multiple matches."),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+		}
+
+		targetFile, err := request.RequireString("target_file")
+		if err != nil {
+			return nil, err
+		}
+		search, err := request.RequireString("search_text")
+		if err != nil {
+			return nil, err
+		}
+		replace, err := request.RequireString("replace_text")
+		if err != nil {
+			return nil, err
+		}
+
+		if err := env.FileEdit(ctx,
+			request.GetString("explanation", ""),
+			targetFile,
+			search,
+			replace,
+			request.GetString("which_match", ""),
+		); err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to write file", err), nil
+		}
+
+		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to update the environment", err), nil
+		}
+
+		warnSecrets(ctx, env)
+		return mcp.NewToolResultText(fmt.Sprintf("file %s edited successfully and committed to container-use/ remote", targetFile)), nil
+	},
+}
+
+var EnvironmentApplyPatchTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_apply_patch",
+		`Apply a unified diff (as produced by git diff or diff -u) changing one or more files.
+Each file's diff is checked against the file: hunks are found even if the lines moved, and line counts in hunk headers don't need to be exact.
+Files whose diff applies are changed, the others are left untouched and reported with the reason. Renames and binary diffs are not supported.`,
+		mcp.WithString("patch",
+			mcp.Description("The unified diff to apply. Paths are relative to the workdir, with or without a/ and b/ prefixes."),
+			mcp.Required(),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+		}
+		patch, err := request.RequireString("patch")
+		if err != nil {
+			return nil, err
+		}
+
+		results, err := env.ApplyPatch(ctx, request.GetString("explanation", ""), patch)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to apply patch", err), nil
+		}
+
+		var out strings.Builder
+		applied := 0
+		for _, r := range results {
+			if r.Applied {
+				applied++
+				fmt.Fprintf(&out, "applied %s (+%d -%d)\n", r.File, r.Added, r.Removed)
+			} else {
+				fmt.Fprintf(&out, "FAILED %s: %s\n", r.File, r.Error)
+			}
+		}
+		if applied == 0 {
+			return mcp.NewToolResultError("no file of the patch could be applied:\n" + out.String()), nil
+		}
+		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to update the environment", err), nil
+		}
+		fmt.Fprintf(&out, "\n%d of %d files patched and committed to container-use/ remote", applied, len(results))
+		warnSecrets(ctx, env)
+		return mcp.NewToolResultText(out.String()), nil
+	},
+}
+
+var EnvironmentFileDeleteTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_file_delete",
+		"Deletes a file at the specified path.",
+		mcp.WithString("target_file",
+			mcp.Description("Path of the file to delete, absolute or relative to the workdir."),
+			mcp.Required(),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		targetFile, err := request.RequireString("target_file")
+		if err != nil {
+			return nil, err
+		}
+		if err := requireApproval(ctx, repo, env.ID, environment.ApprovalFileDelete, fmt.Sprintf("Delete %s in environment %s", targetFile, env.ID)); err != nil {
+			return nil, err
+		}
+
+		if err := env.FileDelete(ctx, request.GetString("explanation", ""), targetFile); err != nil {
+			return nil, fmt.Errorf("failed to delete file: %w", err)
+		}
+
+		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
+			return nil, fmt.Errorf("failed to update env: %w", err)
+		}
+
+		return mcp.NewToolResultText(fmt.Sprintf("file %s deleted successfully and committed to container-use/ remote", targetFile)), nil
+	},
+}
+
+var EnvironmentFileSearchTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_file_search",
+		"Search the contents of the files of the environment for a regular expression. Prefer this over running grep with environment_run_cmd.",
+		mcp.WithString("pattern",
+			mcp.Description("Regular expression to search for (RE2 syntax)."),
+			mcp.Required(),
+		),
+		mcp.WithString("path",
+			mcp.Description("Directory to search in, absolute or relative to the workdir. Defaults to the workdir."),
+		),
+		mcp.WithBoolean("case_insensitive",
+			mcp.Description("Whether the search is case insensitive. Defaults to false."),
+		),
+		mcp.WithArray("include",
+			mcp.Description("Only search files matching these glob patterns (e.g. `[\"*.go\", \"src/**/*.ts\"]`)."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
+		mcp.WithArray("exclude",
+			mcp.Description("Skip files and directories matching these glob patterns (e.g. `[\"node_modules\", \"*_test.go\"]`)."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
+		mcp.WithNumber("max_results",
+			mcp.Description("Maximum number of matches to return. Defaults to 100."),
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		pattern, err := request.RequireString("pattern")
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := env.FileSearch(ctx, environment.FileSearchOptions{
+			Pattern:         pattern,
+			Path:            request.GetString("path", ""),
+			CaseInsensitive: request.GetBool("case_insensitive", false),
+			Include:         request.GetStringSlice("include", nil),
+			Exclude:         request.GetStringSlice("exclude", nil),
+			MaxResults:      request.GetInt("max_results", 0),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to search files: %w", err)
+		}
+
+		out, err := json.Marshal(result)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal search results: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentDataPreviewTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_data_preview",
+		`Preview a data file: returns its columns with their types, its row count and its first rows.
+Supports CSV, TSV, JSON (array of records), JSONL and Parquet (schema and row count only) without any tool installed in the environment.`,
+		mcp.WithString("target_file",
+			mcp.Description("Path of the data file, absolute or relative to the workdir"),
+			mcp.Required(),
+		),
+		mcp.WithString("format",
+			mcp.Description("The format of the file. Detected from the file extension if not provided."),
+			mcp.Enum(environment.DataFormatCSV, environment.DataFormatTSV, environment.DataFormatJSON, environment.DataFormatJSONL, environment.DataFormatParquet),
+		),
+		mcp.WithNumber("rows",
+			mcp.Description("The number of rows to return (default: 10, max: 100)."),
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		targetFile, err := request.RequireString("target_file")
+		if err != nil {
+			return nil, err
+		}
+
+		preview, err := env.DataPreview(ctx, targetFile, request.GetString("format", ""), request.GetInt("rows", 0))
+		if err != nil {
+			return nil, fmt.Errorf("failed to preview data: %w", err)
+		}
+
+		out, err := json.Marshal(preview)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal preview: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentNetstatTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_netstat",
+		`Get the network state of the environment to debug "connection refused" errors: listening ports, established connections, and whether endpoints are reachable.
+In container mode, each service is resolved and its exposed ports connected to from the environment. Since no process outlives a command, listening ports only appear in services, not in the environment container.
+In host mode, sockets are those of the host (Linux only), and the ports of background processes are connected to.`,
+		mcp.WithReadOnlyHintAnnotation(true),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := env.Netstat(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		out, err := json.Marshal(info)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal network state: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentTerminalTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_terminal",
+		`Serve a terminal to the environment over WebSocket, and return its URL for the user to connect from their editor.
+The client gets an interactive shell in the environment, keeping its working directory and variables until it exits. Its changes are saved once it exits, and the other tool calls on the environment wait for it meanwhile.
+The URL includes the token authenticating clients: only share it with the user. Calling this again returns the same URL.`,
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		dag, ok := ctx.Value(daggerClientKey{}).(*dagger.Client)
+		if !ok {
+			return nil, fmt.Errorf("dagger client not found in context")
+		}
+
+		terminal, err := environment.StartRemoteTerminal(env.ID, "127.0.0.1:0", environment.RemoteTerminalSession{
+			Lock: environmentLock(repo, env.ID),
+			Open: func(ctx context.Context) (*environment.Environment, error) {
+				env, err := repo.Get(ctx, dag, env.ID)
+				if err != nil {
+					return nil, err
+				}
+				return env, setCommandPolicy(repo, env)
+			},
+			Save: func(ctx context.Context, env *environment.Environment) error {
+				return repo.Update(ctx, env, "Run shell from remote terminal")
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to start remote terminal: %w", err)
+		}
+
+		out, err := json.Marshal(terminal)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal terminal: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentStatsTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_stats",
+		`Get the resource usage of the environment: size of the workdir, available disk and memory, CPUs and load, along with the resource limits configured by the user.
+While background processes or services run, their current and peak CPU, memory and disk usage is reported too.
+Commands exceeding the limits are killed: check this before running resource intensive commands.`,
+		mcp.WithReadOnlyHintAnnotation(true),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		stats, err := env.Stats(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		out, err := json.Marshal(stats)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal stats: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentCheckpointTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_checkpoint",
+		`Checkpoints an environment in its current state as a container. The image is annotated with the environment ID, title and commit it comes from.
+Without registry credentials, load it in the user's local Docker or Podman daemon, or export it as an OCI tarball.`,
+		mcp.WithString("destination",
+			mcp.Description("Where to checkpoint to: a registry image (e.g. registry.com/user/image:tag), the local Docker or Podman daemon (docker://image:tag, podman://image:tag), or an OCI tarball on the host (oci:///absolute/path/image.tar)."),
+			mcp.Required(),
+		),
+		mcp.WithString("name",
+			mcp.Description("Name of the checkpoint (e.g. deps-installed), unique in the environment. Only named checkpoints can be restored by the user with `container-use checkpoints`."),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		destination, err := request.RequireString("destination")
+		if err != nil {
+			return nil, err
+		}
+		if err := requireApproval(ctx, repo, env.ID, environment.ApprovalCheckpoint, fmt.Sprintf("Checkpoint environment %s to %s", env.ID, destination)); err != nil {
+			return nil, err
+		}
+		sourceCommit, err := repo.HeadCommit(ctx, env.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get environment commit: %w", err)
+		}
+
+		checkpoint, err := env.Checkpoint(ctx, destination, request.GetString("name", ""), sourceCommit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to checkpoint environment: %w", err)
+		}
+		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
+			return nil, fmt.Errorf("failed to update repository: %w", err)
+		}
+		repo.NotifyCheckpoint(ctx, env, checkpoint)
+		switch {
+		case strings.HasPrefix(destination, environment.CheckpointOCIScheme):
+			return mcp.NewToolResultText(fmt.Sprintf("Checkpoint exported to %q. Load it with `docker load -i` or `podman load -i`. The entrypoint is set to `sh`, keep that in mind when giving commands to the container.", strings.TrimPrefix(checkpoint.Ref, environment.CheckpointOCIScheme))), nil
+		case strings.HasPrefix(destination, environment.CheckpointDockerScheme), strings.HasPrefix(destination, environment.CheckpointPodmanScheme):
+			_, image, _ := strings.Cut(checkpoint.Ref, "://")
+			return mcp.NewToolResultText(fmt.Sprintf("Checkpoint loaded as %q (image ID %s). The entrypoint is set to `sh`, keep that in mind when giving commands to the container.", image, checkpoint.Digest)), nil
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("Checkpoint pushed to %q. You MUST use the full content addressed (@sha256:...) reference in `docker` commands. The entrypoint is set to `sh`, keep that in mind when giving commands to the container.", checkpoint.Ref)), nil
+	},
+}
+
+var EnvironmentExportTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_export",
+		`Exports the environment to a bundle on the host, to hand the work off to another developer: its configuration and state, its branch and its log.
+They import it with environment_import or `+"`container-use import`"+`, in a clone of the same repository. Running services and cloud credentials aren't exported.`,
+		mcp.WithString("host_path",
+			mcp.Description("Absolute path of the bundle to write on the host, in the repository or in a writable host path the user allowed (e.g. /home/user/project/handoff.cu.tar.gz)."),
+			mcp.Required(),
+		),
+		mcp.WithString("checkpoint",
+			mcp.Description("Name of a checkpoint pushed to a registry with environment_checkpoint, for the environment to be imported with its image. Without it, the container is built again from the configuration."),
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		hostPath, err := request.RequireString("host_path")
+		if err != nil {
+			return nil, err
+		}
+		target, err := checkTransferPath(repo, env, hostPath, true)
+		if err != nil {
+			return nil, err
+		}
+
+		f, err := os.Create(target)
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := repo.ExportBundle(ctx, env.ID, request.GetString("checkpoint", ""), f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(target)
+			return nil, fmt.Errorf("failed to export environment: %w", err)
+		}
+		out := fmt.Sprintf("Environment %s exported to %s. Import it with `container-use import %s`.", env.ID, hostPath, hostPath)
+		if manifest.BaseCommit != "" {
+			out += fmt.Sprintf(" The importing repository must have commit %s.", manifest.BaseCommit)
+		}
+		return mcp.NewToolResultText(out), nil
+	},
+}
+
+var EnvironmentImportTool = &Tool{
+	Definition: newRepositoryTool(
+		"environment_import",
+		`Imports an environment from a bundle exported with environment_export or `+"`container-use export`"+`, possibly on another machine, to pick up the work where it was left.
+The environment keeps its ID unless it is taken. Its container is the exported checkpoint image, or is built again from the configuration.
+The secrets and host paths of the exported configuration are left out: ask the user to configure them again if they trust the bundle.`,
+		mcp.WithString("host_path",
+			mcp.Description("Absolute path of the bundle on the host, in the repository or in a host path the user allowed."),
+			mcp.Required(),
+		),
+		mcp.WithString("into",
+			mcp.Description("ID of the imported environment (default: the exported ID, or a random one if taken)."),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, err := openRepository(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		hostPath, err := request.RequireString("host_path")
+		if err != nil {
+			return nil, err
+		}
+		source, err := checkTransferPath(repo, nil, hostPath, false)
+		if err != nil {
+			return nil, err
+		}
+		dag, ok := ctx.Value(daggerClientKey{}).(*dagger.Client)
+		if !ok {
+			return nil, fmt.Errorf("dagger client not found in context")
+		}
+		if err := checkEnvironmentLimit(ctx, repo); err != nil {
+			return nil, err
+		}
+
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		env, manifest, err := repo.ImportBundle(ctx, dag, f, request.GetString("into", ""), request.GetString("explanation", ""))
+		if err != nil {
+			return nil, fmt.Errorf("failed to import environment: %w", err)
+		}
+		if len(manifest.Dropped) > 0 {
+			addWarning(ctx, "Left out of the imported configuration, for the user to set again if they trust the bundle: %s", strings.Join(manifest.Dropped, ", "))
+		}
+		recordEnvironment(ctx, env)
+		return EnvironmentToCallResult(env)
+	},
+}
+
+var EnvironmentAddServiceTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_add_service",
+		`Add a service to the environment (e.g. database, cache, etc.)
+Without an image, the name is a preset service of the catalog, optionally with a version (e.g. "postgres@16"): `+catalogServices()+`.
+Preset services come with a healthcheck and set the environment variables to connect to them (e.g. DATABASE_URL).`,
+		mcp.WithString("name",
+			mcp.Description("The name of the service to start, or the preset service to start when no image is provided (e.g. \"postgres@16\", \"redis\")."),
+			mcp.Required(),
+		),
+		mcp.WithString("image",
+			mcp.Description("The image of the service to start. Required unless starting a preset service."),
+		),
+		mcp.WithString("command",
+			mcp.Description("The command to start the service. If not provided the image default command will be used."),
+		),
+		mcp.WithArray("ports",
+			mcp.Description("Ports to expose. For each port, returns the container_internal (for use by environments) and host_external (for use by the user) address."),
+			mcp.Items(map[string]any{"type": "number"}),
+		),
+		mcp.WithArray("envs",
+			mcp.Description("The environment variables to set (e.g. `[\"FOO=bar\", \"BAZ=qux\"]`)."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		serviceName, err := request.RequireString("name")
+		if err != nil {
+			return nil, err
+		}
+		command := request.GetString("command", "")
+		ports := []int{}
+		if portList, ok := request.GetArguments()["ports"].([]any); ok {
+			for _, port := range portList {
+				ports = append(ports, int(port.(float64)))
+			}
+		}
+
+		envs := request.GetStringSlice("envs", []string{})
+
+		cfg := &environment.ServiceConfig{
+			Name:         serviceName,
+			Image:        request.GetString("image", ""),
+			Command:      command,
+			ExposedPorts: ports,
+			Env:          envs,
+		}
+		var vars environment.KVList
+		if cfg.Image == "" {
+			preset, version, err := environment.GetCatalogService(serviceName)
+			if err != nil {
+				return nil, fmt.Errorf("no image provided: %w", err)
+			}
+			cfg, vars = preset.Config(version)
+			if command != "" {
+				cfg.Command = command
+				cfg.NoEntrypoint = false
+			}
+			if len(ports) > 0 {
+				cfg.ExposedPorts = ports
+			}
+			cfg.Env = append(cfg.Env, envs...)
+		}
+
+		if err := checkBackgroundLimit(env); err != nil {
+			return nil, err
+		}
+		service, err := env.AddService(ctx, request.GetString("explanation", ""), cfg, vars)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add service: %w", err)
+		}
+
+		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
+			return nil, fmt.Errorf("failed to update env: %w", err)
+		}
+
+		result := struct {
+			*environment.Service
+			// ConnectionVariables are the variables set in the environment to connect to the service, unless already set
+			ConnectionVariables []string `json:"connection_variables,omitempty"`
+		}{Service: service}
+		if !env.IsHost() {
+			result.ConnectionVariables = vars.Keys()
+		}
+		output, err := json.Marshal(result)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal service: %w", err)
+		}
+		return mcp.NewToolResultText(string(output)), nil
+	},
+}
+
+// catalogServices describes the preset services for agents
+func catalogServices() string {
+	services := []string{}
+	for _, preset := range environment.Catalog() {
+		services = append(services, fmt.Sprintf("%s (%s, default version %s)", preset.Name, preset.Description, preset.DefaultVersion))
+	}
+	return strings.Join(services, "; ")
+}
+
+var EnvironmentPsTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_ps",
+		`List the background commands and services of the environment: services and background commands in container mode, started again when the server restarted, background processes in host mode.
+In host mode, each background process comes with the processes it runs (pid, parent pid, command) and their CPU and memory usage: use it to find lingering servers holding ports.
+Use the IDs with environment_logs and environment_stop_service.`,
+		mcp.WithReadOnlyHintAnnotation(true),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		processes, err := env.ProcessesWithUsage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		out, err := json.Marshal(processes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal processes: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentLogsTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_logs",
+		`Get the tail of the output of a background process listed by environment_ps.
+Only available in host and Kubernetes modes: the output of container services isn't available while they run.`,
+		mcp.WithString("id",
+			mcp.Description("The ID of the process, as listed by environment_ps."),
+			mcp.Required(),
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		id, err := request.RequireString("id")
+		if err != nil {
+			return nil, err
+		}
+
+		logs, err := env.ProcessLogs(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return mcp.NewToolResultText(logs), nil
+	},
+}
+
+var EnvironmentStopServiceTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_stop_service",
+		"Stop a background process or service listed by environment_ps.",
+		mcp.WithString("id",
+			mcp.Description("The ID of the process, as listed by environment_ps."),
+			mcp.Required(),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		id, err := request.RequireString("id")
+		if err != nil {
+			return nil, err
+		}
+
+		if err := env.StopProcess(ctx, id); err != nil {
+			return nil, err
+		}
+		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
+			return nil, fmt.Errorf("failed to update env: %w", err)
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("Stopped %s", id)), nil
+	},
+}
+
+var EnvironmentBuildImageTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_build_image",
+		`Build a Dockerfile from the environment's workdir.
+Optionally start the resulting image as a service reachable from the environment, and/or publish it to a registry.
+Use this to test the container images of the application being worked on.`,
+		mcp.WithString("context",
+			mcp.Description("Build context directory, relative to the workdir. Defaults to the workdir."),
+		),
+		mcp.WithString("dockerfile",
+			mcp.Description("Path of the Dockerfile, relative to the build context. Defaults to Dockerfile."),
+		),
+		mcp.WithString("target",
+			mcp.Description("Target build stage to build."),
+		),
+		mcp.WithArray("build_args",
+			mcp.Description("Build arguments (e.g. `[\"VERSION=1.0\"]`)."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
+		mcp.WithString("service_name",
+			mcp.Description("If set, start the built image as a service with this name. The service is reachable from the environment using its name as hostname."),
+		),
+		mcp.WithString("service_command",
+			mcp.Description("The command to start the service. If not provided the image default command will be used."),
+		),
+		mcp.WithArray("service_ports",
+			mcp.Description("Ports exposed by the service. For each port, returns the environment_internal (for use by environments) and host_external (for use by the user) address."),
+			mcp.Items(map[string]any{"type": "number"}),
+		),
+		mcp.WithArray("service_envs",
+			mcp.Description("The environment variables to set in the service (e.g. `[\"FOO=bar\", \"BAZ=qux\"]`)."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
+		mcp.WithString("destination",
+			mcp.Description("If set, publish the built image to this address (e.g. registry.com/user/image:tag)."),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		opts := environment.BuildImageOptions{
+			Context:     request.GetString("context", ""),
+			Dockerfile:  request.GetString("dockerfile", ""),
+			Target:      request.GetString("target", ""),
+			BuildArgs:   request.GetStringSlice("build_args", nil),
+			Destination: request.GetString("destination", ""),
+		}
+		if name := request.GetString("service_name", ""); name != "" {
+			ports := []int{}
+			if portList, ok := request.GetArguments()["service_ports"].([]any); ok {
+				for _, port := range portList {
+					ports = append(ports, int(port.(float64)))
+				}
+			}
+			opts.Service = &environment.ServiceConfig{
+				Name:         name,
+				Command:      request.GetString("service_command", ""),
+				ExposedPorts: ports,
+				Env:          request.GetStringSlice("service_envs", []string{}),
+			}
+		}
+
+		result, buildErr := env.BuildImage(ctx, request.GetString("explanation", ""), opts)
+		// We want to update the repository even if the build failed.
+		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
+			return nil, fmt.Errorf("failed to update env: %w", err)
+		}
+		if buildErr != nil {
+			return nil, fmt.Errorf("failed to build image: %w", buildErr)
+		}
+
+		out, err := json.Marshal(result)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal build result: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentPreviewUpTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_preview_up",
+		`Bring up an ephemeral preview of the application: start the application along with the environment's services, with all their ports exposed on the user's machine.
+Returns the endpoints of the application and of each service, and the preview URLs to share with the user.
+Only one preview per environment can run at a time. Stop it with environment_preview_down.`,
+		mcp.WithString("command",
+			mcp.Description("The command starting the application. If empty, the environment's default command is used."),
+		),
+		mcp.WithString("shell",
+			mcp.Description("The shell that will be interpreting this command (default: sh)"),
+		),
+		mcp.WithBoolean("use_entrypoint",
+			mcp.Description("Use the image entrypoint, if present, by prepending it to the args."),
+		),
+		mcp.WithArray("ports",
+			mcp.Description("Ports the application listens on. Defaults to the ports of the environment configuration."),
+			mcp.Items(map[string]any{"type": "number"}),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		ports := []int{}
+		if portList, ok := request.GetArguments()["ports"].([]any); ok {
+			for _, port := range portList {
+				ports = append(ports, int(port.(float64)))
+			}
+		}
+
+		preview, previewErr := env.PreviewUp(ctx, request.GetString("explanation", ""), environment.PreviewOptions{
+			Command:       request.GetString("command", ""),
+			Shell:         request.GetString("shell", "sh"),
+			Ports:         ports,
+			UseEntrypoint: request.GetBool("use_entrypoint", false),
+		})
+		// We want to update the repository even if the preview failed.
+		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
+			return nil, fmt.Errorf("failed to update env: %w", err)
+		}
+		if previewErr != nil {
+			return nil, fmt.Errorf("failed to start preview: %w", previewErr)
+		}
+
+		out, err := json.Marshal(preview)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal preview: %w", err)
+		}
+		return mcp.NewToolResultText(fmt.Sprintf(`Preview started: %s
+
+Share the urls with the user. The preview runs from the current state of the environment: restart it for changes to take effect.`, string(out))), nil
+	},
+}
+
+var EnvironmentPreviewDownTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_preview_down",
+		"Stop the preview of the environment started with environment_preview_up.",
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		repo, env, err := openEnvironment(ctx, request)
@@ -698,118 +2598,122 @@ var EnvironmentFileDeleteTool = &Tool{
 			return nil, err
 		}
 
-		targetFile, err := request.RequireString("target_file")
-		if err != nil {
+		if err := env.PreviewDown(ctx, request.GetString("explanation", "")); err != nil {
 			return nil, err
 		}
-
-		if err := env.FileDelete(ctx, request.GetString("explanation", ""), targetFile); err != nil {
-			return nil, fmt.Errorf("failed to delete file: %w", err)
-		}
-
 		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
 			return nil, fmt.Errorf("failed to update env: %w", err)
 		}
-
-		return mcp.NewToolResultText(fmt.Sprintf("file %s deleted successfully and committed to container-use/ remote", targetFile)), nil
+		return mcp.NewToolResultText("Preview stopped."), nil
 	},
 }
 
-var EnvironmentCheckpointTool = &Tool{
+var EnvironmentIaCPlanTool = &Tool{
 	Definition: newEnvironmentTool(
-		"environment_checkpoint",
-		"Checkpoints an environment in its current state as a container.",
-		mcp.WithString("destination",
-			mcp.Description("Container image destination to checkpoint to (e.g. registry.com/user/image:tag"),
-			mcp.Required(),
+		"environment_iac_plan",
+		`Run a terraform plan or pulumi preview of infrastructure code in the environment and return a summary of the proposed changes.
+The plan runs with the provider credentials configured by the user, which are not available anywhere else in the environment: changes can be proposed but never applied.
+Nothing is committed to the environment.`,
+		mcp.WithString("tool",
+			mcp.Description("The infrastructure tool, terraform or pulumi. Detected from the files in dir if not provided."),
+			mcp.Enum(environment.IaCToolTerraform, environment.IaCToolPulumi),
+		),
+		mcp.WithString("dir",
+			mcp.Description("The directory of the terraform module or pulumi project, relative to the workdir. Defaults to the workdir."),
+		),
+		mcp.WithString("stack",
+			mcp.Description("The pulumi stack to preview."),
 		),
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		_, env, err := openEnvironment(ctx, request)
+		repo, env, err := openEnvironment(ctx, request)
 		if err != nil {
 			return nil, err
 		}
-		destination, err := request.RequireString("destination")
-		if err != nil {
-			return nil, err
+
+		plan, planErr := env.PlanIaC(ctx, environment.IaCPlanOptions{
+			Tool:  request.GetString("tool", ""),
+			Dir:   request.GetString("dir", ""),
+			Stack: request.GetString("stack", ""),
+		})
+		// We want to update the repository even if the plan failed.
+		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
+			return nil, fmt.Errorf("failed to update env: %w", err)
+		}
+		if planErr != nil {
+			return nil, fmt.Errorf("failed to plan: %w", planErr)
 		}
 
-		endpoint, err := env.Checkpoint(ctx, destination)
+		out, err := json.Marshal(plan)
 		if err != nil {
-			return nil, fmt.Errorf("failed to checkpoint environment: %w", err)
+			return nil, fmt.Errorf("failed to marshal plan: %w", err)
 		}
-		return mcp.NewToolResultText(fmt.Sprintf("Checkpoint pushed to %q. You MUST use the full content addressed (@sha256:...) reference in `docker` commands. The entrypoint is set to `sh`, keep that in mind when giving commands to the container.", endpoint)), nil
+		return mcp.NewToolResultText(string(out)), nil
 	},
 }
 
-var EnvironmentAddServiceTool = &Tool{
+var EnvironmentGrantCloudAccessTool = &Tool{
 	Definition: newEnvironmentTool(
-		"environment_add_service",
-		"Add a service to the environment (e.g. database, cache, etc.)",
-		mcp.WithString("name",
-			mcp.Description("The name of the service to start."),
+		"environment_grant_cloud_access",
+		`Grant the commands of the environment short-lived credentials of an AWS role or a GCP service account, to run cloud CLIs without long-lived keys.
+The credentials are set in the environment variables of the commands run with environment_run_cmd until they expire: AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN for AWS, CLOUDSDK_AUTH_ACCESS_TOKEN and GOOGLE_OAUTH_ACCESS_TOKEN for GCP.
+Only the roles the user allowed when starting the server can be granted. Grant them again once expired.`,
+		mcp.WithString("provider",
+			mcp.Description("The cloud of the role."),
+			mcp.Enum(environment.CloudAWS, environment.CloudGCP),
 			mcp.Required(),
 		),
-		mcp.WithString("image",
-			mcp.Description("The image of the service to start."),
+		mcp.WithString("role",
+			mcp.Description("The ARN of the AWS role to assume, or the email of the GCP service account to impersonate."),
 			mcp.Required(),
 		),
-		mcp.WithString("command",
-			mcp.Description("The command to start the service. If not provided the image default command will be used."),
-		),
-		mcp.WithArray("ports",
-			mcp.Description("Ports to expose. For each port, returns the container_internal (for use by environments) and host_external (for use by the user) address."),
-			mcp.Items(map[string]any{"type": "number"}),
+		mcp.WithString("policy",
+			mcp.Description("For AWS, a JSON session policy narrowing the permissions of the role to what the task needs."),
 		),
-		mcp.WithArray("envs",
-			mcp.Description("The environment variables to set (e.g. `[\"FOO=bar\", \"BAZ=qux\"]`)."),
+		mcp.WithArray("scopes",
+			mcp.Description("For GCP, the OAuth scopes of the access token. Defaults to the scopes of gcloud."),
 			mcp.Items(map[string]any{"type": "string"}),
 		),
+		mcp.WithNumber("duration_minutes",
+			mcp.Description("How long the credentials are valid: 15 minutes by default, at most 60. AWS credentials last at least 15 minutes."),
+		),
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		repo, env, err := openEnvironment(ctx, request)
 		if err != nil {
 			return nil, err
 		}
-		serviceName, err := request.RequireString("name")
+		provider, err := request.RequireString("provider")
 		if err != nil {
 			return nil, err
 		}
-		image, err := request.RequireString("image")
+		role, err := request.RequireString("role")
 		if err != nil {
 			return nil, err
 		}
-		command := request.GetString("command", "")
-		ports := []int{}
-		if portList, ok := request.GetArguments()["ports"].([]any); ok {
-			for _, port := range portList {
-				ports = append(ports, int(port.(float64)))
-			}
+		if !slices.Contains(CloudRoles, role) {
+			return nil, fmt.Errorf("role %s is not allowed: ask the user to start the server with --cloud-role %s", role, role)
 		}
 
-		envs := request.GetStringSlice("envs", []string{})
-
-		service, err := env.AddService(ctx, request.GetString("explanation", ""), &environment.ServiceConfig{
-			Name:         serviceName,
-			Image:        image,
-			Command:      command,
-			ExposedPorts: ports,
-			Env:          envs,
-		})
+		grant, err := env.GrantCloudAccess(ctx, environment.CloudGrant{
+			Provider: provider,
+			Role:     role,
+			Policy:   request.GetString("policy", ""),
+			Scopes:   request.GetStringSlice("scopes", nil),
+		}, time.Duration(request.GetFloat("duration_minutes", 0)*float64(time.Minute)))
 		if err != nil {
-			return nil, fmt.Errorf("failed to add service: %w", err)
+			return nil, fmt.Errorf("failed to grant cloud access: %w", err)
 		}
-
+		env.Notes.Add("Granted %s access to %s until %s", provider, role, grant.ExpiresAt.Format(time.RFC3339))
 		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
 			return nil, fmt.Errorf("failed to update env: %w", err)
 		}
 
-		output, err := json.Marshal(service)
+		out, err := json.Marshal(grant)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal service: %w", err)
+			return nil, fmt.Errorf("failed to marshal grant: %w", err)
 		}
-
-		return mcp.NewToolResultText(fmt.Sprintf("Service added and started successfully: %s", string(output))), nil
+		return mcp.NewToolResultText(string(out)), nil
 	},
 }
 
@@ -845,3 +2749,334 @@ var EnvironmentKillBackgroundTool = &Tool{
 		return mcp.NewToolResultText(fmt.Sprintf("Stopped process %d", pid)), nil
 	},
 }
+
+var EnvironmentSendTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_send",
+		`Send a structured message from this environment to another environment of the same repository.
+Use this to coordinate with agents working in parallel environments (e.g. "API contract changed, see branch container-use/fancy-mallard").`,
+		mcp.WithString("to",
+			mcp.Description("The ID of the recipient environment. If empty, the message is broadcast to every other environment of the repository."),
+		),
+		mcp.WithString("topic",
+			mcp.Description("Short topic for the message (e.g. \"api-contract\")."),
+		),
+		mcp.WithString("body",
+			mcp.Description("The message content."),
+			mcp.Required(),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, err := openRepository(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		envID, err := request.RequireString("environment_id")
+		if err != nil {
+			return nil, err
+		}
+		body, err := request.RequireString("body")
+		if err != nil {
+			return nil, err
+		}
+
+		messages, err := repo.Send(ctx, envID, request.GetString("to", ""), request.GetString("topic", ""), body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to send message: %w", err)
+		}
+
+		out, err := json.Marshal(messages)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal messages: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentReceiveTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_receive",
+		"Receive the pending messages sent to this environment by other environments. Messages are returned oldest first and removed from the mailbox once received.",
+		mcp.WithBoolean("peek",
+			mcp.Description("Return pending messages without removing them from the mailbox. Defaults to false."),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, err := openRepository(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		envID, err := request.RequireString("environment_id")
+		if err != nil {
+			return nil, err
+		}
+
+		messages, err := repo.Receive(ctx, envID, request.GetBool("peek", false))
+		if err != nil {
+			return nil, fmt.Errorf("failed to receive messages: %w", err)
+		}
+		if len(messages) == 0 {
+			return mcp.NewToolResultText("No pending messages."), nil
+		}
+
+		out, err := json.Marshal(messages)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal messages: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentDiffTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_diff",
+		"Get the unified diff of the changes made in the environment against the branch it was forked from. Use this to inspect the changes before they are merged.",
+		mcp.WithArray("paths",
+			mcp.Description("Only diff these files and directories, relative to the repository root."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
+		mcp.WithBoolean("stat",
+			mcp.Description("Only return the list of changed files with their number of changed lines. Defaults to false."),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, err := openRepository(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		envID, err := request.RequireString("environment_id")
+		if err != nil {
+			return nil, err
+		}
+
+		diff, err := repo.UnifiedDiff(ctx, envID, repository.DiffOpts{
+			Paths: request.GetStringSlice("paths", nil),
+			Stat:  request.GetBool("stat", false),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to diff environment: %w", err)
+		}
+		if diff == "" {
+			return mcp.NewToolResultText("No changes"), nil
+		}
+		return mcp.NewToolResultText(diff), nil
+	},
+}
+
+var EnvironmentHistoryTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_history",
+		"Get the activity log of the environment, most recent first: commands with their exit codes, file operations, configuration changes and snapshots, with their timestamps and the commit they were recorded on.",
+		mcp.WithString("kind",
+			mcp.Description("Only return the activities of this kind."),
+			mcp.Enum(environment.ActivityCommand, environment.ActivityFile, environment.ActivityConfig, environment.ActivitySnapshot, environment.ActivityEvent),
+		),
+		mcp.WithNumber("offset",
+			mcp.Description("The number of activities to skip, from the next_offset of the previous page (default: 0)."),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("The maximum number of activities to return (default: 50, maximum: 500)."),
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, err := openRepository(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		envID, err := request.RequireString("environment_id")
+		if err != nil {
+			return nil, err
+		}
+
+		page, err := repo.Activity(ctx, envID, repository.ActivityOpts{
+			Kind:   request.GetString("kind", ""),
+			Offset: request.GetInt("offset", 0),
+			Limit:  request.GetInt("limit", 0),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get environment history: %w", err)
+		}
+		out, err := json.Marshal(page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal history: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentMergeTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_merge",
+		`Bring the changes made in the environment into the user's current branch, or export them as a patch series.
+This modifies the user's repository: only use it when the user asked for the changes to be merged.`,
+		mcp.WithString("strategy",
+			mcp.Description("merge creates a merge commit preserving the environment's history, squash stages the changes for the user to commit, rebase replays the environment's commits on top of the user's branch, patch exports the changes for git am without touching the user's branch. Defaults to merge."),
+			mcp.Enum("merge", "squash", "rebase", "patch"),
+		),
+		mcp.WithString("patch_path",
+			mcp.Description("Absolute path of the file to write the patch to with the patch strategy, in the repository or in a writable host path the user allowed. If empty, the patch is returned."),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, err := openRepository(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		envID, err := request.RequireString("environment_id")
+		if err != nil {
+			return nil, err
+		}
+
+		// Patches leave the user's branch untouched
+		strategy := request.GetString("strategy", "merge")
+		if strategy != "patch" {
+			summary := fmt.Sprintf("Merge environment %s into the current branch (%s)", envID, strategy)
+			if err := requireApproval(ctx, repo, envID, environment.ApprovalMerge, summary); err != nil {
+				return nil, err
+			}
+		}
+
+		out := &bytes.Buffer{}
+		switch strategy {
+		case "merge":
+			err = repo.Merge(ctx, envID, out)
+		case "squash":
+			err = repo.Apply(ctx, envID, out)
+		case "rebase":
+			err = repo.Rebase(ctx, envID, out)
+		case "patch":
+			patchPath := request.GetString("patch_path", "")
+			if patchPath == "" {
+				err = repo.Patch(ctx, envID, out)
+				break
+			}
+			if !filepath.IsAbs(patchPath) {
+				return nil, fmt.Errorf("patch_path must be an absolute path, got %q", patchPath)
+			}
+			target, err := checkTransferPath(repo, nil, patchPath, true)
+			if err != nil {
+				return nil, err
+			}
+			if err := writePatchFile(ctx, repo, envID, target); err != nil {
+				return nil, err
+			}
+			return mcp.NewToolResultText(fmt.Sprintf("Patch written to %s, apply it with: git am %s", patchPath, patchPath)), nil
+		default:
+			return nil, fmt.Errorf("unknown merge strategy %q: expected merge, squash, rebase or patch", strategy)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge environment: %w\n%s", err, out.String())
+		}
+		return mcp.NewToolResultText(out.String()), nil
+	},
+}
+
+func writePatchFile(ctx context.Context, repo *repository.Repository, envID, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := repo.Patch(ctx, envID, f); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write patch: %w", err)
+	}
+	return f.Close()
+}
+
+var EnvironmentDeleteTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_delete",
+		`Delete the environment: its background processes and services are stopped, and its worktree, branch and messages are removed.
+This is permanent: only use it when the user asked for it, or for environments whose work was merged or abandoned.`,
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		summary := fmt.Sprintf("Delete environment %s (%s)", env.ID, env.State.Title)
+		if err := requireApproval(ctx, repo, env.ID, environment.ApprovalDelete, summary); err != nil {
+			return nil, err
+		}
+
+		for _, process := range env.Processes() {
+			if err := env.StopProcess(ctx, process.ID); err != nil {
+				slog.WarnContext(ctx, "Failed to stop process of deleted environment", "environment", env.ID, "process", process.ID, "err", err)
+			}
+		}
+		if err := repo.Delete(ctx, env.ID); err != nil {
+			return nil, fmt.Errorf("failed to delete environment: %w", err)
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("Environment %s deleted", env.ID)), nil
+	},
+}
+
+var EnvironmentReviewTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_review",
+		`Review the changes made in the environment against the user's current branch.
+Returns the diff chunked per file and hunk. Each chunk has a stable ID that can be used with environment_review_comment, along with the review comments already attached to it.`,
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, err := openRepository(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		envID, err := request.RequireString("environment_id")
+		if err != nil {
+			return nil, err
+		}
+
+		review, err := repo.Review(ctx, envID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to review environment: %w", err)
+		}
+
+		out, err := json.Marshal(review)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal review: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentReviewCommentTool = &Tool{
+	Definition: newEnvironmentTool(
+		"environment_review_comment",
+		"Attach a review comment to a chunk of the environment's diff. Use environment_review to get the chunk IDs.",
+		mcp.WithString("chunk_id",
+			mcp.Description("The ID of the chunk to comment on, as returned by environment_review."),
+			mcp.Required(),
+		),
+		mcp.WithString("body",
+			mcp.Description("The comment."),
+			mcp.Required(),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, err := openRepository(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		envID, err := request.RequireString("environment_id")
+		if err != nil {
+			return nil, err
+		}
+		chunkID, err := request.RequireString("chunk_id")
+		if err != nil {
+			return nil, err
+		}
+		body, err := request.RequireString("body")
+		if err != nil {
+			return nil, err
+		}
+
+		comment, err := repo.AddReviewComment(ctx, envID, chunkID, "agent", body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add review comment: %w", err)
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("Comment added to chunk %s of %s", comment.ChunkID, comment.File)), nil
+	},
+}
@@ -0,0 +1,156 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupTestRepository initializes a git repository with a single commit and opens it with an isolated base path for
+// container-use data
+func setupTestRepository(t *testing.T) *repository.Repository {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"init"},
+		{"config", "user.email", "test@example.com"},
+		{"config", "user.name", "Test User"},
+		{"config", "commit.gpgsign", "false"},
+		{"commit", "--allow-empty", "-m", "Initial commit"},
+	} {
+		_, err := repository.RunGitCommand(ctx, dir, args...)
+		require.NoError(t, err)
+	}
+	repo, err := repository.OpenWithBasePath(ctx, dir, t.TempDir())
+	require.NoError(t, err)
+	return repo
+}
+
+// newHostEnvironment returns a host environment working in a temporary directory, for the handlers under test to use
+// without a dagger engine
+func newHostEnvironment(t *testing.T, id string) *environment.Environment {
+	return &environment.Environment{
+		EnvironmentInfo: &environment.EnvironmentInfo{
+			ID: id,
+			State: &environment.State{
+				Config: &environment.EnvironmentConfig{BaseImage: "host", Workdir: t.TempDir()},
+			},
+		},
+	}
+}
+
+// runTool calls the tool the way the server does, with the request ID of the call if any
+func runTool(ctx context.Context, tool *Tool, requestID any) *mcp.CallToolResult {
+	request := mcp.CallToolRequest{}
+	request.Params.Name = tool.Definition.Name
+	if requestID != nil {
+		tagRequestID(ctx, requestID, &request)
+	}
+	// Handlers report their errors in the envelope of their result
+	result, _ := wrapTool(tool).Handler(ctx, request)
+	return result
+}
+
+// envelope decodes the envelope of the result of a tool
+func envelope(t *testing.T, result *mcp.CallToolResult) *Response {
+	t.Helper()
+	require.NotNil(t, result)
+	require.NotEmpty(t, result.Content)
+	text, ok := result.Content[0].(mcp.TextContent)
+	require.True(t, ok, "the envelope comes first")
+	resp := &Response{}
+	require.NoError(t, json.Unmarshal([]byte(text.Text), resp))
+	assert.Equal(t, ResponseVersion, resp.Version)
+	assert.Equal(t, resp.Status == ResponseStatusError, result.IsError)
+	return resp
+}
+
+// TestCommandPolicyEnforced tests that the tools running commands enforce the command policy of the repository, and
+// wait for the user to confirm the commands it requires confirmation for until the call is cancelled
+func TestCommandPolicyEnforced(t *testing.T) {
+	ctx := context.Background()
+	repo := setupTestRepository(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(repo.SourcePath(), ".container-use"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(repo.SourcePath(), ".container-use", "policy.yaml"),
+		[]byte("deny:\n  - ^touch denied\nconfirm:\n  - ^touch confirmed\n"), 0644))
+	// Approvals are requested for the branch of the environment
+	_, err := repository.RunGitCommand(ctx, repo.SourcePath(), "push", "container-use", "HEAD:refs/heads/env-a")
+	require.NoError(t, err)
+
+	env := newHostEnvironment(t, "env-a")
+	runCmd := func(command string) *Tool {
+		return &Tool{
+			Definition: mcp.NewTool("environment_run_cmd"),
+			Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+				if err := setCommandPolicy(repo, env); err != nil {
+					return nil, err
+				}
+				recordEnvironment(ctx, env)
+				output, _, err := env.Run(ctx, command, "sh", false)
+				if err != nil {
+					return nil, err
+				}
+				return mcp.NewToolResultText(output), nil
+			},
+		}
+	}
+	ran := func(name string) bool {
+		_, err := os.Stat(filepath.Join(env.State.Config.Workdir, name))
+		return err == nil
+	}
+
+	resp := envelope(t, runTool(ctx, runCmd("touch allowed"), nil))
+	assert.Equal(t, ResponseStatusOK, resp.Status)
+	assert.True(t, ran("allowed"))
+
+	resp = envelope(t, runTool(ctx, runCmd("touch denied"), nil))
+	require.NotNil(t, resp.Error)
+	assert.Equal(t, "policy_violation", resp.Error.Code)
+	assert.Equal(t, map[string]any{"command": "touch denied", "rule": "deny", "pattern": "^touch denied"}, resp.Error.Details)
+	assert.Equal(t, "env-a", resp.Environment.ID)
+	assert.False(t, ran("denied"))
+
+	// pendingApproval waits for the command to wait for the user
+	pendingApproval := func() *repository.Approval {
+		var approvals []*repository.Approval
+		require.Eventually(t, func() bool {
+			var err error
+			approvals, err = repo.Approvals(ctx)
+			return err == nil && len(approvals) == 1
+		}, 10*time.Second, 10*time.Millisecond)
+		return approvals[0]
+	}
+
+	results := make(chan *mcp.CallToolResult)
+	go func() { results <- runTool(ctx, runCmd("touch confirmed"), 1) }()
+	approval := pendingApproval()
+	assert.Equal(t, environment.ApprovalCommand, approval.Operation)
+	_, err = repo.DecideApproval(ctx, approval.ID, false, "not now")
+	require.NoError(t, err)
+	resp = envelope(t, <-results)
+	require.NotNil(t, resp.Error)
+	assert.Equal(t, "approval_denied", resp.Error.Code)
+	assert.Contains(t, resp.Error.Message, "not now")
+	assert.False(t, ran("confirmed"))
+
+	// Cancelling the call gives up the approval request
+	go func() { results <- runTool(ctx, runCmd("touch confirmed"), 2) }()
+	pendingApproval()
+	cancelCall("", 2, "the user interrupted the agent")
+	resp = envelope(t, <-results)
+	require.NotNil(t, resp.Error)
+	assert.Equal(t, "cancelled", resp.Error.Code)
+	assert.False(t, ran("confirmed"))
+	approvals, err := repo.Approvals(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, approvals)
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+)
+
+const (
+	// gitNotesActivityRef holds the activities of environments, one JSON object per line, see environment.Activity
+	gitNotesActivityRef = "container-use-activity"
+
+	defaultActivityLimit = 50
+	maxActivityLimit     = 500
+)
+
+// ActivityOpts selects the page of the activity of an environment to return
+type ActivityOpts struct {
+	// Kind only returns the activities of a kind, e.g. command. All kinds are returned when empty.
+	Kind   string
+	Offset int
+	// Limit is the size of the page, defaultActivityLimit when zero
+	Limit int
+}
+
+// ActivityEntry is an activity of an environment, with the commit it was recorded on
+type ActivityEntry struct {
+	environment.Activity
+	Commit string `json:"commit"`
+}
+
+// ActivityPage is a page of the activity of an environment, most recent first
+type ActivityPage struct {
+	Entries []ActivityEntry `json:"entries"`
+	// Total is the number of activities matching the options, on all pages
+	Total int `json:"total"`
+	// NextOffset is the offset of the next page, when there is one
+	NextOffset int `json:"next_offset,omitempty"`
+}
+
+// addActivityNote records activities of an environment on its HEAD.
+// Callers must hold the LockTypeGitNotes lock.
+func (r *Repository) addActivityNote(ctx context.Context, env *environment.Environment, activities []environment.Activity) error {
+	lines := make([]string, 0, len(activities))
+	for _, activity := range activities {
+		line, err := json.Marshal(activity)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, string(line))
+	}
+	worktreePath, err := r.WorktreePath(env.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get worktree path: %w", err)
+	}
+	if _, err := RunGitCommand(ctx, worktreePath, "notes", "--ref", gitNotesActivityRef, "append", "-m", strings.Join(lines, "\n")); err != nil {
+		return err
+	}
+	return r.propagateGitNotes(ctx, gitNotesActivityRef)
+}
+
+// Activity returns a page of the commands, file operations, configuration changes and snapshots of an environment
+func (r *Repository) Activity(ctx context.Context, id string, opts ActivityOpts) (*ActivityPage, error) {
+	if opts.Offset < 0 {
+		return nil, fmt.Errorf("invalid offset %d", opts.Offset)
+	}
+	limit := opts.Limit
+	switch {
+	case limit <= 0:
+		limit = defaultActivityLimit
+	case limit > maxActivityLimit:
+		limit = maxActivityLimit
+	}
+
+	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	revisionRange, err := r.revisionRange(ctx, envInfo)
+	if err != nil {
+		return nil, err
+	}
+	out, err := RunGitCommand(ctx, r.userRepoPath, "log", "--notes="+gitNotesActivityRef, "--format=%x1e%H%x00%s%x00%cI%x00%N", revisionRange)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := parseActivityLog(out)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Kind != "" {
+		entries = slices.DeleteFunc(entries, func(entry ActivityEntry) bool {
+			return entry.Kind != opts.Kind
+		})
+	}
+	page := &ActivityPage{Entries: []ActivityEntry{}, Total: len(entries)}
+	if opts.Offset < len(entries) {
+		end := min(opts.Offset+limit, len(entries))
+		page.Entries = entries[opts.Offset:end]
+		if end < len(entries) {
+			page.NextOffset = end
+		}
+	}
+	return page, nil
+}
+
+// parseActivityLog reads the activities of the commits logged with their activity notes, most recent first.
+// Commits without activities, like the ones made before activities were recorded, are activities of their own.
+func parseActivityLog(log string) ([]ActivityEntry, error) {
+	entries := []ActivityEntry{}
+	for record := range strings.SplitSeq(log, "\x1e") {
+		fields := strings.SplitN(record, "\x00", 4)
+		if len(fields) != 4 {
+			continue
+		}
+		commit, subject, notes := fields[0], fields[1], fields[3]
+		commitTime, err := time.Parse(time.RFC3339, fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid date of commit %s: %w", commit, err)
+		}
+
+		recorded := false
+		for line := range strings.SplitSeq(notes, "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			var activity environment.Activity
+			if err := json.Unmarshal([]byte(line), &activity); err != nil {
+				return nil, fmt.Errorf("invalid activity of commit %s: %w", commit, err)
+			}
+			entries = append(entries, ActivityEntry{Activity: activity, Commit: commit})
+			recorded = true
+		}
+		if !recorded {
+			entries = append(entries, ActivityEntry{
+				Activity: environment.Activity{Kind: environment.ActivityEvent, Time: commitTime, Summary: subject},
+				Commit:   commit,
+			})
+		}
+	}
+	slices.SortStableFunc(entries, func(a, b ActivityEntry) int {
+		return b.Time.Compare(a.Time)
+	})
+	return entries, nil
+}
@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseActivityLog(t *testing.T) {
+	log := "\x1ebbb\x00Fix tests\x002025-07-01T12:10:00Z\x00" +
+		`{"kind":"command","time":"2025-07-01T12:05:00Z","summary":"$ go test ./...","exit_code":1}` + "\n\n" +
+		`{"kind":"file","time":"2025-07-01T12:09:00Z","summary":"Write main.go","files":["main.go"]}` + "\n\n" +
+		"\x1eaaa\x00Create environment\x002025-07-01T12:00:00Z\x00\n"
+
+	entries, err := parseActivityLog(log)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "Write main.go", entries[0].Summary)
+	assert.Equal(t, "bbb", entries[0].Commit)
+	assert.Equal(t, environment.ActivityCommand, entries[1].Kind)
+	require.NotNil(t, entries[1].ExitCode)
+	assert.Equal(t, 1, *entries[1].ExitCode)
+	// Commits without activities are activities of their own
+	assert.Equal(t, environment.ActivityEvent, entries[2].Kind)
+	assert.Equal(t, "Create environment", entries[2].Summary)
+	assert.Equal(t, "aaa", entries[2].Commit)
+
+	_, err = parseActivityLog("\x1eccc\x00Broken\x002025-07-01T12:00:00Z\x00{\n")
+	assert.Error(t, err)
+}
@@ -0,0 +1,194 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	petname "github.com/dustinkirkland/golang-petname"
+)
+
+const (
+	approvalsRefPrefix = "refs/container-use/approvals/"
+
+	// approvalPollInterval is how often agents waiting for approval check whether the user decided
+	approvalPollInterval = time.Second
+)
+
+// Statuses of approval requests
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalDenied   = "denied"
+	// ApprovalExpired requests were given up by the agent before the user decided
+	ApprovalExpired = "expired"
+)
+
+// Approval is a destructive operation of an agent waiting for the user to approve it
+type Approval struct {
+	ID            string `json:"id"`
+	EnvironmentID string `json:"environment_id"`
+	Operation     string `json:"operation"`
+	// Summary tells the user what the operation will do, e.g. the file it deletes
+	Summary     string     `json:"summary"`
+	Status      string     `json:"status"`
+	Reason      string     `json:"reason,omitempty"`
+	RequestedAt time.Time  `json:"requested_at"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+}
+
+// ApprovalError is returned for the operations the user didn't approve
+type ApprovalError struct {
+	*Approval
+}
+
+func (e *ApprovalError) Error() string {
+	if e.Status == ApprovalDenied {
+		msg := fmt.Sprintf("the user denied %s on environment %s", e.Operation, e.EnvironmentID)
+		if e.Reason != "" {
+			msg += ": " + e.Reason
+		}
+		return msg + ". Don't retry it, ask the user how to proceed"
+	}
+	return fmt.Sprintf("the user didn't approve %s on environment %s in time and the request expired: retry once the user is around to approve it",
+		e.Operation, e.EnvironmentID)
+}
+
+// RequestApproval records a pending approval request for an operation of an environment
+func (r *Repository) RequestApproval(ctx context.Context, id, operation, summary string) (*Approval, error) {
+	if err := r.exists(ctx, id); err != nil {
+		return nil, err
+	}
+	approval := &Approval{
+		ID:            petname.Generate(3, "-"),
+		EnvironmentID: id,
+		Operation:     operation,
+		Summary:       summary,
+		Status:        ApprovalPending,
+		RequestedAt:   time.Now(),
+	}
+	err := r.lockManager.WithLock(ctx, LockTypeApprovals, func() error {
+		return r.saveApproval(ctx, approval)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return approval, nil
+}
+
+// Approvals returns the approval requests waiting for the user, oldest first
+func (r *Repository) Approvals(ctx context.Context) ([]*Approval, error) {
+	refs, err := RunGitCommand(ctx, r.forkRepoPath, "for-each-ref", "--format", "%(refname)", approvalsRefPrefix)
+	if err != nil {
+		return nil, err
+	}
+	approvals := []*Approval{}
+	for _, ref := range strings.Fields(refs) {
+		approval, err := r.loadApproval(ctx, strings.TrimPrefix(ref, approvalsRefPrefix))
+		if err != nil {
+			return nil, err
+		}
+		if approval.Status == ApprovalPending {
+			approvals = append(approvals, approval)
+		}
+	}
+	slices.SortFunc(approvals, func(a, b *Approval) int { return a.RequestedAt.Compare(b.RequestedAt) })
+	return approvals, nil
+}
+
+// DecideApproval approves or denies a pending approval request
+func (r *Repository) DecideApproval(ctx context.Context, approvalID string, approved bool, reason string) (*Approval, error) {
+	var approval *Approval
+	err := r.lockManager.WithLock(ctx, LockTypeApprovals, func() error {
+		var err error
+		approval, err = r.loadApproval(ctx, approvalID)
+		if err != nil {
+			return err
+		}
+		if approval.Status != ApprovalPending {
+			return fmt.Errorf("approval request %s is %s", approvalID, approval.Status)
+		}
+		approval.Status = ApprovalDenied
+		if approved {
+			approval.Status = ApprovalApproved
+		}
+		approval.Reason = reason
+		now := time.Now()
+		approval.DecidedAt = &now
+		return r.saveApproval(ctx, approval)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return approval, nil
+}
+
+// WaitApproval waits for the user to decide on an approval request, and removes it once decided.
+// If the context is done first, the request expires.
+func (r *Repository) WaitApproval(ctx context.Context, approvalID string) (*Approval, error) {
+	ticker := time.NewTicker(approvalPollInterval)
+	defer ticker.Stop()
+	// Once the context is done, the request is still read to expire it
+	bg := context.WithoutCancel(ctx)
+	for {
+		var approval *Approval
+		err := r.lockManager.WithLock(bg, LockTypeApprovals, func() error {
+			var err error
+			approval, err = r.loadApproval(bg, approvalID)
+			if err != nil {
+				return err
+			}
+			if approval.Status == ApprovalPending {
+				if ctx.Err() == nil {
+					return nil
+				}
+				approval.Status = ApprovalExpired
+			}
+			return r.deleteApproval(bg, approvalID)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if approval.Status != ApprovalPending {
+			return approval, nil
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Repository) loadApproval(ctx context.Context, approvalID string) (*Approval, error) {
+	ref := approvalsRefPrefix + approvalID
+	if _, err := RunGitCommand(ctx, r.forkRepoPath, "rev-parse", "--verify", "--quiet", ref); err != nil {
+		return nil, fmt.Errorf("approval request %s: %w", approvalID, errNotFound)
+	}
+	buff, err := RunGitCommand(ctx, r.forkRepoPath, "cat-file", "blob", ref)
+	if err != nil {
+		return nil, err
+	}
+	approval := &Approval{}
+	if err := json.Unmarshal([]byte(buff), approval); err != nil {
+		return nil, fmt.Errorf("failed to load approval request %s: %w", approvalID, err)
+	}
+	return approval, nil
+}
+
+func (r *Repository) saveApproval(ctx context.Context, approval *Approval) error {
+	data, err := json.MarshalIndent(approval, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return r.writeBlobRef(ctx, approvalsRefPrefix+approval.ID, data)
+}
+
+func (r *Repository) deleteApproval(ctx context.Context, approvalID string) error {
+	_, err := RunGitCommand(ctx, r.forkRepoPath, "update-ref", "-d", approvalsRefPrefix+approvalID)
+	return err
+}
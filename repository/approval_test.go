@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryApprovals(t *testing.T) {
+	ctx := context.Background()
+	repo := setupTestRepository(t)
+
+	worktree, err := repo.initializeWorktree(ctx, "env-a")
+	require.NoError(t, err)
+	require.NoError(t, repo.createInitialCommit(ctx, worktree, "env-a", "env-a"))
+
+	_, err = repo.RequestApproval(ctx, "missing", "merge", "Merge missing")
+	assert.Error(t, err, "approvals are requested for existing environments")
+
+	t.Run("approved", func(t *testing.T) {
+		approval, err := repo.RequestApproval(ctx, "env-a", "merge", "Merge env-a into main")
+		require.NoError(t, err)
+		assert.Equal(t, ApprovalPending, approval.Status)
+
+		pending, err := repo.Approvals(ctx)
+		require.NoError(t, err)
+		require.Len(t, pending, 1)
+		assert.Equal(t, approval.ID, pending[0].ID)
+
+		decided, err := repo.DecideApproval(ctx, approval.ID, true, "")
+		require.NoError(t, err)
+		assert.Equal(t, ApprovalApproved, decided.Status)
+		_, err = repo.DecideApproval(ctx, approval.ID, false, "")
+		assert.Error(t, err, "requests are decided once")
+
+		waited, err := repo.WaitApproval(ctx, approval.ID)
+		require.NoError(t, err)
+		assert.Equal(t, ApprovalApproved, waited.Status)
+
+		pending, err = repo.Approvals(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, pending, "decided requests are removed once the agent got the decision")
+	})
+
+	t.Run("denied_while_waiting", func(t *testing.T) {
+		approval, err := repo.RequestApproval(ctx, "env-a", "file_delete", "Delete go.mod")
+		require.NoError(t, err)
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			repo.DecideApproval(ctx, approval.ID, false, "go.mod is needed")
+		}()
+
+		waited, err := repo.WaitApproval(ctx, approval.ID)
+		require.NoError(t, err)
+		assert.Equal(t, ApprovalDenied, waited.Status)
+		assert.Equal(t, "go.mod is needed", waited.Reason)
+		assert.Contains(t, (&ApprovalError{waited}).Error(), "go.mod is needed")
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		approval, err := repo.RequestApproval(ctx, "env-a", "delete", "Delete env-a")
+		require.NoError(t, err)
+
+		waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		waited, err := repo.WaitApproval(waitCtx, approval.ID)
+		require.NoError(t, err)
+		assert.Equal(t, ApprovalExpired, waited.Status)
+		assert.NotContains(t, (&ApprovalError{waited}).Error(), approval.ID, "expired requests are gone")
+
+		_, err = repo.DecideApproval(ctx, approval.ID, true, "")
+		assert.Error(t, err, "expired requests can't be approved anymore")
+	})
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dagger/container-use/environment"
+)
+
+// SetBudget sets the budget of the environment, replacing its limits.
+// Running agents pick it up with their next update, see mergeStoredState.
+func (r *Repository) SetBudget(ctx context.Context, id string, budget environment.Budget) (*environment.EnvironmentInfo, error) {
+	if budget.MaxToolCalls < 0 || budget.MaxCommandTime < 0 {
+		return nil, fmt.Errorf("budget limits cannot be negative")
+	}
+	if err := r.exists(ctx, id); err != nil {
+		return nil, err
+	}
+	worktree, err := r.WorktreePath(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var envInfo *environment.EnvironmentInfo
+	// Reload the state under the lock so concurrent updates don't overwrite the budget
+	err = r.lockManager.WithLock(ctx, LockTypeGitNotes, func() error {
+		state, err := r.readState(ctx, worktree)
+		if err != nil {
+			return err
+		}
+		envInfo, err = environment.LoadInfo(ctx, id, state, worktree)
+		if err != nil {
+			return err
+		}
+		envInfo.State.Budget = &budget
+		if err := r.saveState(ctx, envInfo); err != nil {
+			return err
+		}
+		return r.propagateGitNotes(ctx, gitNotesStateRef)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save budget: %w", err)
+	}
+	return envInfo, nil
+}
@@ -0,0 +1,367 @@
+package repository
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"dagger.io/dagger"
+	"github.com/dagger/container-use/environment"
+	petname "github.com/dustinkirkland/golang-petname"
+)
+
+const (
+	// bundleVersion is the version of the bundle format, for imports to reject bundles they can't read
+	bundleVersion = 1
+
+	bundleManifestFile = "bundle.json"
+	bundleBranchFile   = "branch.bundle"
+)
+
+// BundleManifest describes an environment exported to a bundle, an archive of the manifest and of a git bundle of
+// the environment's branch
+type BundleManifest struct {
+	Version     int       `json:"version"`
+	Environment string    `json:"environment"`
+	Title       string    `json:"title"`
+	ExportedAt  time.Time `json:"exported_at"`
+	// Branch is the ref of the environment's branch in the git bundle, and Head its last commit
+	Branch string `json:"branch"`
+	Head   string `json:"head"`
+	// BaseCommit is the commit the environment was forked from: the git bundle starts after it, so the repository
+	// it is imported in must have it
+	BaseCommit string `json:"base_commit,omitempty"`
+	// Image is the reference of a published checkpoint of the container, which the environment is imported with.
+	// Without it, the container is built again from the configuration.
+	Image string `json:"image,omitempty"`
+	// State is the portable state of the environment (see environment.State.Portable)
+	State json.RawMessage `json:"state"`
+	// Notes are the log notes of the commits of the branch, by commit
+	Notes map[string]string `json:"notes,omitempty"`
+
+	// Dropped is what ImportBundle left out of the configuration of the environment because it gives access to the
+	// host (see environment.EnvironmentConfig.DropHostAccess)
+	Dropped []string `json:"-"`
+}
+
+// portableImage tells whether another machine can pull an image: local daemon images and tarballs stay on this one
+func portableImage(ref string) bool {
+	for _, scheme := range []string{environment.CheckpointDockerScheme, environment.CheckpointPodmanScheme, environment.CheckpointOCIScheme} {
+		if strings.HasPrefix(ref, scheme) {
+			return false
+		}
+	}
+	return true
+}
+
+// ExportBundle writes a bundle of an environment to w: its configuration and portable state, its branch, and the log
+// notes of its commits. If checkpoint is set, the environment is imported with the image of this named checkpoint,
+// which must have been published to a registry.
+func (r *Repository) ExportBundle(ctx context.Context, id, checkpoint string, w io.Writer) (*BundleManifest, error) {
+	info, err := r.Info(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	state, err := info.State.Portable()
+	if err != nil {
+		return nil, err
+	}
+	stateData, err := state.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	head, err := r.HeadCommit(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &BundleManifest{
+		Version:     bundleVersion,
+		Environment: id,
+		Title:       info.State.Title,
+		ExportedAt:  time.Now(),
+		Branch:      "refs/heads/" + id,
+		Head:        head,
+		State:       stateData,
+	}
+	if checkpoint != "" {
+		cp := info.State.FindCheckpoint(checkpoint)
+		if cp == nil {
+			return nil, fmt.Errorf("environment %s has no checkpoint named %q", id, checkpoint)
+		}
+		if !portableImage(cp.Ref) {
+			return nil, fmt.Errorf("checkpoint %q was saved to %s, which other machines can't pull: publish it to a registry to export it", checkpoint, cp.Ref)
+		}
+		manifest.Image = cp.Ref
+	}
+
+	// The bundle starts after the fork point, when the branch still descends from it
+	revisions := []string{id}
+	if base := info.State.BaseCommit; base != "" {
+		if _, err := RunGitCommand(ctx, r.forkRepoPath, "merge-base", "--is-ancestor", base, id); err == nil {
+			manifest.BaseCommit = base
+			revisions = append(revisions, "^"+base)
+		}
+	}
+
+	tmp, err := os.MkdirTemp("", "container-use-bundle-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	bundlePath := filepath.Join(tmp, bundleBranchFile)
+	if _, err := RunGitCommand(ctx, r.forkRepoPath, append([]string{"bundle", "create", bundlePath}, revisions...)...); err != nil {
+		return nil, fmt.Errorf("failed to bundle the branch of %s: %w", id, err)
+	}
+
+	manifest.Notes, err = r.branchNotes(ctx, manifest.BaseCommit, id)
+	if err != nil {
+		return nil, err
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeBundle(w, manifestData, bundlePath); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// branchNotes returns the log notes of the commits of an environment's branch after its fork point
+func (r *Repository) branchNotes(ctx context.Context, base, id string) (map[string]string, error) {
+	revisions := id
+	if base != "" {
+		revisions = base + ".." + id
+	}
+	commits, err := RunGitCommand(ctx, r.forkRepoPath, "rev-list", revisions)
+	if err != nil {
+		return nil, err
+	}
+	noted, err := RunGitCommand(ctx, r.forkRepoPath, "notes", "--ref", gitNotesLogRef, "list")
+	if err != nil {
+		// There is no notes ref before the first note
+		noted = ""
+	}
+	hasNote := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(noted), "\n") {
+		if _, commit, ok := strings.Cut(line, " "); ok {
+			hasNote[commit] = true
+		}
+	}
+
+	notes := map[string]string{}
+	for _, commit := range strings.Fields(commits) {
+		if !hasNote[commit] {
+			continue
+		}
+		note, err := RunGitCommand(ctx, r.forkRepoPath, "notes", "--ref", gitNotesLogRef, "show", commit)
+		if err != nil {
+			return nil, err
+		}
+		notes[commit] = note
+	}
+	return notes, nil
+}
+
+// writeBundle writes the gzipped tar archive of a bundle
+func writeBundle(w io.Writer, manifest []byte, bundlePath string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: bundleManifestFile, Mode: 0644, Size: int64(len(manifest)), ModTime: time.Now()}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return err
+	}
+
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: bundleBranchFile, Mode: 0644, Size: fi.Size(), ModTime: fi.ModTime()}); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// readBundle extracts a bundle archive to dir and returns its manifest
+func readBundle(r io.Reader, dir string) (*BundleManifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not an environment bundle: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	var manifest *BundleManifest
+	hasBranch := false
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("not an environment bundle: %w", err)
+		}
+		switch hdr.Name {
+		case bundleManifestFile:
+			manifest = &BundleManifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, fmt.Errorf("invalid bundle manifest: %w", err)
+			}
+		case bundleBranchFile:
+			f, err := os.Create(filepath.Join(dir, bundleBranchFile))
+			if err != nil {
+				return nil, err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			hasBranch = true
+		}
+	}
+	if manifest == nil || !hasBranch {
+		return nil, errors.New("not an environment bundle: the manifest or the branch is missing")
+	}
+	if manifest.Version != bundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d: upgrade container-use to import it", manifest.Version)
+	}
+	return manifest, nil
+}
+
+// ImportBundle creates an environment from a bundle exported by ExportBundle, possibly on another machine: its branch
+// is the exported one, with its log, and its container is the exported image or is built again from the configuration.
+// The environment keeps its ID, unless another environment has it: into sets the ID, else a random one is generated.
+// The secrets and host paths of its configuration are left out, see BundleManifest.Dropped.
+func (r *Repository) ImportBundle(ctx context.Context, dag *dagger.Client, bundle io.Reader, into, explanation string) (*environment.Environment, *BundleManifest, error) {
+	tmp, err := os.MkdirTemp("", "container-use-bundle-")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(tmp)
+	manifest, err := readBundle(bundle, tmp)
+	if err != nil {
+		return nil, nil, err
+	}
+	state := &environment.State{}
+	if err := state.Unmarshal(manifest.State); err != nil {
+		return nil, nil, fmt.Errorf("invalid bundle state: %w", err)
+	}
+	if state.Config == nil {
+		return nil, nil, errors.New("invalid bundle state: the configuration is missing")
+	}
+	// Bundles may come from anyone: they don't get to read the files and secrets of this host
+	manifest.Dropped = state.Config.DropHostAccess()
+
+	newID := into
+	if newID == "" {
+		newID = manifest.Environment
+		if validateEnvironmentID(newID) != nil || r.exists(ctx, newID) == nil {
+			newID = petname.Generate(2, "-")
+		}
+	}
+	if err := validateEnvironmentID(newID); err != nil {
+		return nil, nil, err
+	}
+	if err := r.exists(ctx, newID); err == nil {
+		return nil, nil, fmt.Errorf("environment %q already exists", newID)
+	} else if !errors.Is(err, errNotFound) {
+		return nil, nil, err
+	}
+
+	worktree, err := r.initializeWorktree(ctx, newID)
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		if err := r.Delete(context.WithoutCancel(ctx), newID); err != nil {
+			slog.ErrorContext(ctx, "Failed to clean up imported environment", "id", newID, "err", err)
+		}
+	}
+
+	bundlePath := filepath.Join(tmp, bundleBranchFile)
+	if _, err := RunGitCommand(ctx, worktree, "bundle", "verify", bundlePath); err != nil {
+		cleanup()
+		if manifest.BaseCommit != "" {
+			return nil, nil, fmt.Errorf("the bundle starts after commit %s, which this repository doesn't have: fetch it first: %w", manifest.BaseCommit, err)
+		}
+		return nil, nil, err
+	}
+	if _, err := RunGitCommand(ctx, worktree, "fetch", bundlePath, manifest.Branch); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	if _, err := RunGitCommand(ctx, worktree, "reset", "--hard", manifest.Head); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	// Host environments run in their worktree, containers start from the files of the branch
+	var source *dagger.Directory
+	if strings.EqualFold(state.Config.BaseImage, "host") {
+		state.Config.Workdir = worktree
+	} else if source, err = r.Tree(ctx, dag, newID); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	env, err := environment.Import(ctx, dag, newID, state, manifest.Image, source)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	r.ClaimServices(newID)
+	env.SetRepository(r.forkRepoPath)
+	r.watchState(env)
+	if len(manifest.Dropped) > 0 {
+		env.Notes.Add("Left out of the imported configuration: %s", strings.Join(manifest.Dropped, ", "))
+	}
+
+	if err := r.lockManager.WithLock(ctx, LockTypeGitNotes, func() error {
+		return r.restoreNotes(ctx, worktree, manifest.Notes)
+	}); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	if err := r.Update(ctx, env, explanation); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return env, manifest, nil
+}
+
+// restoreNotes adds the log notes of an imported branch to its commits.
+// Callers must hold the LockTypeGitNotes lock.
+func (r *Repository) restoreNotes(ctx context.Context, worktree string, notes map[string]string) error {
+	if len(notes) == 0 {
+		return nil
+	}
+	for commit, note := range notes {
+		if _, err := RunGitCommand(ctx, worktree, "notes", "--ref", gitNotesLogRef, "add", "-f", "-m", strings.TrimSuffix(note, "\n"), commit); err != nil {
+			return fmt.Errorf("failed to restore the log of commit %s: %w", commit, err)
+		}
+	}
+	return r.propagateGitNotes(ctx, gitNotesLogRef)
+}
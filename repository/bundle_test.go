@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	repo := setupTestRepository(t)
+
+	env, worktree := createHostEnvironment(t, repo, "env-a")
+	env.State.Title = "Add main"
+	env.State.Config.Secrets = environment.KVList{"TOKEN=file:///home/user/.ssh/id_ed25519"}
+	env.State.Config.HostPaths = environment.HostPaths{{Path: "/home/user/datasets"}}
+	writeFile(t, worktree, "main.go", "package main\n")
+	env.Notes.Add("$ go build")
+	require.NoError(t, repo.Update(ctx, env, "Add main"))
+	head, err := repo.HeadCommit(ctx, "env-a")
+	require.NoError(t, err)
+
+	_, err = repo.ExportBundle(ctx, "env-a", "deps", &bytes.Buffer{})
+	assert.ErrorContains(t, err, `no checkpoint named "deps"`)
+
+	bundle := &bytes.Buffer{}
+	manifest, err := repo.ExportBundle(ctx, "env-a", "", bundle)
+	require.NoError(t, err)
+	assert.Equal(t, "env-a", manifest.Environment)
+	assert.Equal(t, head, manifest.Head)
+	assert.Equal(t, env.State.BaseCommit, manifest.BaseCommit)
+	assert.Empty(t, manifest.Image)
+	require.Contains(t, manifest.Notes, head)
+	assert.Contains(t, manifest.Notes[head], "$ go build")
+
+	// The environment keeps its ID on import, unless it is taken
+	require.NoError(t, repo.Delete(ctx, "env-a"))
+	imported, importManifest, err := repo.ImportBundle(ctx, nil, bytes.NewReader(bundle.Bytes()), "", "Import env-a")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"secret TOKEN", "host path /home/user/datasets"}, importManifest.Dropped)
+	assert.Equal(t, "env-a", imported.ID)
+	assert.Equal(t, "Add main", imported.State.Title)
+	importedWorktree, err := repo.WorktreePath("env-a")
+	require.NoError(t, err)
+	assert.Equal(t, importedWorktree, imported.State.Config.Workdir)
+	content, err := os.ReadFile(filepath.Join(importedWorktree, "main.go"))
+	require.NoError(t, err)
+	assert.Equal(t, "package main\n", string(content))
+	assert.Empty(t, imported.State.Config.Secrets, "bundles don't read the secrets of this host")
+	assert.Empty(t, imported.State.Config.HostPaths)
+	note, err := RunGitCommand(ctx, importedWorktree, "notes", "--ref", gitNotesLogRef, "show", head)
+	require.NoError(t, err)
+	assert.Contains(t, note, "$ go build", "the log is imported")
+
+	_, _, err = repo.ImportBundle(ctx, nil, bytes.NewReader(bundle.Bytes()), "env-a", "Import env-a")
+	assert.ErrorContains(t, err, "already exists")
+	_, _, err = repo.ImportBundle(ctx, nil, bytes.NewReader(bundle.Bytes()), "../../escape", "Import env-a")
+	assert.ErrorContains(t, err, "invalid environment ID")
+	again, _, err := repo.ImportBundle(ctx, nil, bytes.NewReader(bundle.Bytes()), "", "Import env-a")
+	require.NoError(t, err)
+	assert.NotEqual(t, "env-a", again.ID)
+
+	_, _, err = repo.ImportBundle(ctx, nil, strings.NewReader("not a bundle"), "", "Import")
+	assert.ErrorContains(t, err, "not an environment bundle")
+}
+
+func TestExportBundleLocalCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	repo := setupTestRepository(t)
+
+	env, _ := createHostEnvironment(t, repo, "env-a")
+	env.State.Checkpoints = []environment.Checkpoint{
+		{Ref: "docker://deps:latest", Name: "local"},
+		{Ref: "registry.example.com/app@sha256:01", Name: "published"},
+	}
+	require.NoError(t, repo.Update(ctx, env, "checkpoint"))
+
+	_, err := repo.ExportBundle(ctx, "env-a", "local", &bytes.Buffer{})
+	assert.ErrorContains(t, err, "publish it to a registry")
+
+	manifest, err := repo.ExportBundle(ctx, "env-a", "published", &bytes.Buffer{})
+	require.NoError(t, err)
+	assert.Equal(t, "registry.example.com/app@sha256:01", manifest.Image)
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"dagger.io/dagger"
+	"github.com/dagger/container-use/environment"
+	petname "github.com/dustinkirkland/golang-petname"
+)
+
+// CheckpointLineage is how the checkpoints of an environment relate to other environments
+type CheckpointLineage struct {
+	// Ancestors are the named checkpoints the environment descends from, the one it was restored from first
+	Ancestors []environment.CheckpointOrigin `json:"ancestors"`
+	// Checkpoints are the checkpoints of the environment, the oldest first
+	Checkpoints []environment.Checkpoint `json:"checkpoints"`
+	// Restored are the environments restored from the checkpoints of the environment, by checkpoint name
+	Restored map[string][]string `json:"restored,omitempty"`
+}
+
+// CheckpointLineage returns the checkpoints of an environment, the checkpoints it was restored from and the
+// environments restored from its checkpoints
+func (r *Repository) CheckpointLineage(ctx context.Context, id string) (*CheckpointLineage, error) {
+	info, err := r.Info(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	lineage := &CheckpointLineage{
+		Ancestors:   []environment.CheckpointOrigin{},
+		Checkpoints: info.State.Checkpoints,
+		Restored:    map[string][]string{},
+	}
+	if lineage.Checkpoints == nil {
+		lineage.Checkpoints = []environment.Checkpoint{}
+	}
+
+	// Deleted environments end the lineage
+	seen := map[string]bool{id: true}
+	for origin := info.State.RestoredFrom; origin != nil; {
+		lineage.Ancestors = append(lineage.Ancestors, *origin)
+		if seen[origin.Environment] {
+			break
+		}
+		seen[origin.Environment] = true
+		parent, err := r.Info(ctx, origin.Environment)
+		if err != nil {
+			if !errors.Is(err, errNotFound) {
+				return nil, err
+			}
+			break
+		}
+		origin = parent.State.RestoredFrom
+	}
+
+	envs, err := r.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, env := range envs {
+		if origin := env.State.RestoredFrom; origin != nil && origin.Environment == id {
+			lineage.Restored[origin.Checkpoint] = append(lineage.Restored[origin.Checkpoint], env.ID)
+		}
+	}
+	return lineage, nil
+}
+
+// RestoreCheckpoint creates a new environment from a named checkpoint of an environment: its branch starts at the
+// commit the checkpoint was taken at, and its container is the checkpointed one.
+// If into is empty, a random environment ID is generated.
+func (r *Repository) RestoreCheckpoint(ctx context.Context, dag *dagger.Client, id, name, into, explanation string) (*environment.Environment, error) {
+	info, err := r.Info(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := info.State.FindCheckpoint(name)
+	if checkpoint == nil {
+		return nil, fmt.Errorf("environment %s has no checkpoint named %q", id, name)
+	}
+	if checkpoint.SourceCommit == "" {
+		return nil, fmt.Errorf("checkpoint %q of environment %s doesn't record the commit it was taken at", name, id)
+	}
+	origin := environment.CheckpointOrigin{Environment: id, Checkpoint: name}
+
+	newID := into
+	if newID == "" {
+		newID = petname.Generate(2, "-")
+	}
+	if err := validateEnvironmentID(newID); err != nil {
+		return nil, err
+	}
+	if err := r.exists(ctx, newID); err == nil {
+		return nil, fmt.Errorf("environment %q already exists", newID)
+	} else if !errors.Is(err, errNotFound) {
+		return nil, err
+	}
+	title := fmt.Sprintf("%s (restored from %s)", info.State.Title, origin)
+
+	worktree, err := r.initializeWorktree(ctx, newID)
+	if err != nil {
+		return nil, err
+	}
+	cleanup := func() {
+		if err := r.Delete(context.WithoutCancel(ctx), newID); err != nil {
+			slog.ErrorContext(ctx, "Failed to clean up restored environment", "id", newID, "err", err)
+		}
+	}
+
+	if _, err := RunGitCommand(ctx, worktree, "reset", "--hard", checkpoint.SourceCommit); err != nil {
+		cleanup()
+		return nil, err
+	}
+	if err := r.createInitialCommit(ctx, worktree, newID, title); err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to create initial commit: %w", err)
+	}
+
+	config := info.State.Config.Copy()
+	env, err := environment.Restore(ctx, dag, newID, title, config, origin, checkpoint)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	r.ClaimServices(newID)
+	env.SetRepository(r.forkRepoPath)
+	r.watchState(env)
+	// The restored changes are diffed against the fork point of the environment of the checkpoint
+	env.State.BaseBranch = info.State.BaseBranch
+	env.State.BaseCommit = info.State.BaseCommit
+
+	if err := r.Update(ctx, env, explanation); err != nil {
+		cleanup()
+		return nil, err
+	}
+	return env, nil
+}
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpointLineage(t *testing.T) {
+	ctx := context.Background()
+	repo := setupTestRepository(t)
+
+	base, _ := createHostEnvironment(t, repo, "env-a")
+	base.State.Checkpoints = []environment.Checkpoint{{Ref: "registry.example.com/app@sha256:01", Name: "deps"}}
+	require.NoError(t, repo.Update(ctx, base, "checkpoint"))
+
+	restored, _ := createHostEnvironment(t, repo, "env-b")
+	restored.State.RestoredFrom = &environment.CheckpointOrigin{Environment: "env-a", Checkpoint: "deps"}
+	restored.State.Checkpoints = []environment.Checkpoint{{
+		Ref:    "registry.example.com/app@sha256:02",
+		Name:   "green",
+		Parent: restored.State.RestoredFrom,
+	}}
+	require.NoError(t, repo.Update(ctx, restored, "checkpoint"))
+
+	again, _ := createHostEnvironment(t, repo, "env-c")
+	again.State.RestoredFrom = &environment.CheckpointOrigin{Environment: "env-b", Checkpoint: "green"}
+	require.NoError(t, repo.Update(ctx, again, "restore"))
+
+	lineage, err := repo.CheckpointLineage(ctx, "env-b")
+	require.NoError(t, err)
+	assert.Equal(t, []environment.CheckpointOrigin{{Environment: "env-a", Checkpoint: "deps"}}, lineage.Ancestors)
+	require.Len(t, lineage.Checkpoints, 1)
+	assert.Equal(t, "green", lineage.Checkpoints[0].Name)
+	assert.Equal(t, map[string][]string{"green": {"env-c"}}, lineage.Restored)
+
+	lineage, err = repo.CheckpointLineage(ctx, "env-c")
+	require.NoError(t, err)
+	assert.Equal(t, []environment.CheckpointOrigin{
+		{Environment: "env-b", Checkpoint: "green"},
+		{Environment: "env-a", Checkpoint: "deps"},
+	}, lineage.Ancestors)
+	assert.Empty(t, lineage.Checkpoints)
+
+	// Only named checkpoints can be restored
+	_, err = repo.RestoreCheckpoint(ctx, nil, "env-a", "missing", "", "restore")
+	assert.ErrorContains(t, err, "no checkpoint named")
+}
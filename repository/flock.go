@@ -22,6 +22,10 @@ const (
 	LockTypeWorktree LockType = "worktree"
 	// LockTypeGitNotes - Git notes operations (state saves, log updates)
 	LockTypeGitNotes LockType = "notes"
+	// LockTypeMessages - Inter-environment mailbox operations (send, receive)
+	LockTypeMessages LockType = "messages"
+	// LockTypeApprovals - Approval requests of destructive operations (request, decide, wait)
+	LockTypeApprovals LockType = "approvals"
 )
 
 // RepositoryLockManager provides granular process-level locking for repository operations
@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+)
+
+// gcPruneExpiry protects the git objects of operations in progress from being pruned
+const gcPruneExpiry = "1.hour.ago"
+
+// GCPolicy selects the environments deleted by GC
+type GCPolicy struct {
+	// MaxAge deletes the environments not updated for longer than MaxAge. Zero deletes none.
+	MaxAge time.Duration
+	// KeepLatest protects the most recently updated environments whatever their age
+	KeepLatest int
+	// DryRun reports what would be deleted without deleting anything
+	DryRun bool
+}
+
+// GCResult reports what GC deleted, or would delete in a dry run
+type GCResult struct {
+	Environments []string `json:"environments"`
+	// OrphanWorktrees are the worktrees left behind by environments of the repository that no longer exist
+	OrphanWorktrees []string `json:"orphan_worktrees"`
+}
+
+// GC deletes the environments expired according to the policy, then reclaims what deleted environments
+// left behind: orphan worktrees, and the git objects and notes of their branches.
+func (r *Repository) GC(ctx context.Context, policy GCPolicy, now time.Time) (*GCResult, error) {
+	envs, err := r.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &GCResult{
+		Environments:    []string{},
+		OrphanWorktrees: []string{},
+	}
+	// Environments are listed most recently updated first
+	for i, env := range envs {
+		if i < policy.KeepLatest || !expired(env, policy.MaxAge, now) {
+			continue
+		}
+		result.Environments = append(result.Environments, env.ID)
+	}
+	orphans, err := r.orphanWorktrees(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result.OrphanWorktrees = orphans
+
+	if policy.DryRun {
+		return result, nil
+	}
+
+	for _, id := range result.Environments {
+		if err := r.Delete(ctx, id); err != nil {
+			return result, fmt.Errorf("failed to delete environment %s: %w", id, err)
+		}
+	}
+	for _, worktree := range result.OrphanWorktrees {
+		slog.InfoContext(ctx, "Deleting orphan worktree", "path", worktree)
+		if err := os.RemoveAll(worktree); err != nil {
+			return result, err
+		}
+	}
+
+	if err := r.lockManager.WithLock(ctx, LockTypeGitNotes, func() error {
+		return r.pruneGitData(ctx)
+	}); err != nil {
+		return result, fmt.Errorf("failed to prune git data: %w", err)
+	}
+	return result, nil
+}
+
+func expired(env *environment.EnvironmentInfo, maxAge time.Duration, now time.Time) bool {
+	if maxAge <= 0 {
+		return false
+	}
+	lastActivity := env.State.UpdatedAt
+	if lastActivity.IsZero() {
+		lastActivity = env.State.CreatedAt
+	}
+	// Environments without timestamps are never considered expired
+	return !lastActivity.IsZero() && now.Sub(lastActivity) > maxAge
+}
+
+// orphanWorktrees returns the worktrees of the repository's fork whose environment branch no longer exists.
+// Worktrees of all repositories share a directory: the others are recognized by their .git file.
+func (r *Repository) orphanWorktrees(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(r.getWorktreePath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	gitdirPrefix := "gitdir: " + filepath.Join(r.forkRepoPath, "worktrees") + string(filepath.Separator)
+	orphans := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		worktree := filepath.Join(r.getWorktreePath(), entry.Name())
+		gitFile, err := os.ReadFile(filepath.Join(worktree, ".git"))
+		if err != nil || !strings.HasPrefix(strings.TrimSpace(string(gitFile)), gitdirPrefix) {
+			continue
+		}
+		if err := r.exists(ctx, entry.Name()); errors.Is(err, errNotFound) {
+			orphans = append(orphans, worktree)
+		}
+	}
+	return orphans, nil
+}
+
+// pruneGitData drops the notes of deleted environment commits and the git objects of their branches.
+// Callers must hold the LockTypeGitNotes lock.
+func (r *Repository) pruneGitData(ctx context.Context) error {
+	if _, err := RunGitCommand(ctx, r.forkRepoPath, "worktree", "prune"); err != nil {
+		return err
+	}
+	if _, err := RunGitCommand(ctx, r.forkRepoPath, "gc", "--quiet", "--prune="+gcPruneExpiry); err != nil {
+		return err
+	}
+	for _, ref := range []string{gitNotesLogRef, gitNotesStateRef, gitNotesJournalRef, gitNotesActivityRef} {
+		if _, err := RunGitCommand(ctx, r.forkRepoPath, "show-ref", "--verify", "--quiet", "refs/notes/"+ref); err != nil {
+			// No environment recorded notes yet
+			continue
+		}
+		if _, err := RunGitCommand(ctx, r.forkRepoPath, "notes", "--ref", ref, "prune"); err != nil {
+			return err
+		}
+		if ref == gitNotesJournalRef {
+			// Journals are only read from the fork
+			continue
+		}
+		if err := r.propagateGitNotes(ctx, ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
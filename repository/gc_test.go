@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryGC(t *testing.T) {
+	ctx := context.Background()
+	repo := setupTestRepository(t)
+	now := time.Now()
+
+	for id, updatedAt := range map[string]time.Time{
+		"env-old": now.Add(-60 * 24 * time.Hour),
+		"env-new": now.Add(-time.Hour),
+	} {
+		env, _ := createHostEnvironment(t, repo, id)
+		env.State.CreatedAt = updatedAt
+		env.State.UpdatedAt = updatedAt
+		require.NoError(t, repo.Update(ctx, env, "work"))
+	}
+
+	// Worktree of an environment deleted without its worktree, and of another repository
+	orphan := filepath.Join(repo.getWorktreePath(), "env-gone")
+	writeFile(t, orphan, ".git", "gitdir: "+filepath.Join(repo.forkRepoPath, "worktrees", "env-gone"))
+	other := filepath.Join(repo.getWorktreePath(), "env-other")
+	writeFile(t, other, ".git", "gitdir: /elsewhere/worktrees/env-other")
+
+	result, err := repo.GC(ctx, GCPolicy{MaxAge: 30 * 24 * time.Hour, DryRun: true}, now)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"env-old"}, result.Environments)
+	assert.Equal(t, []string{orphan}, result.OrphanWorktrees)
+	assert.DirExists(t, orphan, "dry runs don't delete anything")
+	_, err = repo.Info(ctx, "env-old")
+	require.NoError(t, err)
+
+	result, err = repo.GC(ctx, GCPolicy{MaxAge: 30 * 24 * time.Hour, KeepLatest: 2, DryRun: true}, now)
+	require.NoError(t, err)
+	assert.Empty(t, result.Environments, "the latest environments are kept whatever their age")
+
+	result, err = repo.GC(ctx, GCPolicy{}, now)
+	require.NoError(t, err)
+	assert.Empty(t, result.Environments, "environments are only deleted by age")
+	assert.NoDirExists(t, orphan)
+	assert.DirExists(t, other)
+
+	result, err = repo.GC(ctx, GCPolicy{MaxAge: 30 * 24 * time.Hour}, now)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"env-old"}, result.Environments)
+	_, err = repo.Info(ctx, "env-old")
+	assert.Error(t, err)
+	_, err = repo.Info(ctx, "env-new")
+	assert.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(repo.getWorktreePath(), "env-old"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -16,6 +17,7 @@ import (
 
 	"dagger.io/dagger"
 	"github.com/dagger/container-use/environment"
+	"github.com/klauspost/compress/zstd"
 	"github.com/mitchellh/go-homedir"
 )
 
@@ -31,13 +33,19 @@ var (
 // RunGitCommand executes a git command in the specified directory.
 // This is exported for use in tests and other packages that need direct git access.
 func RunGitCommand(ctx context.Context, dir string, args ...string) (out string, rerr error) {
-	slog.Info(fmt.Sprintf("[%s] $ git %s", dir, strings.Join(args, " ")))
+	return runGitCommandWithInput(ctx, dir, nil, args...)
+}
+
+// runGitCommandWithInput executes a git command reading its standard input from input
+func runGitCommandWithInput(ctx context.Context, dir string, input io.Reader, args ...string) (out string, rerr error) {
+	slog.InfoContext(ctx, fmt.Sprintf("[%s] $ git %s", dir, strings.Join(args, " ")))
 	defer func() {
-		slog.Info(fmt.Sprintf("[%s] $ git %s (DONE)", dir, strings.Join(args, " ")), "err", rerr)
+		slog.InfoContext(ctx, fmt.Sprintf("[%s] $ git %s (DONE)", dir, strings.Join(args, " ")), "err", rerr)
 	}()
 
 	cmd := exec.CommandContext(ctx, "git", args...)
 	cmd.Dir = dir
+	cmd.Stdin = input
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -54,9 +62,9 @@ func RunGitCommand(ctx context.Context, dir string, args ...string) (out string,
 
 // RunInteractiveGitCommand executes a git command in the specified directory in interactive mode.
 func RunInteractiveGitCommand(ctx context.Context, dir string, w io.Writer, args ...string) (rerr error) {
-	slog.Info(fmt.Sprintf("[%s] $ git %s", dir, strings.Join(args, " ")))
+	slog.InfoContext(ctx, fmt.Sprintf("[%s] $ git %s", dir, strings.Join(args, " ")))
 	defer func() {
-		slog.Info(fmt.Sprintf("[%s] $ git %s (DONE)", dir, strings.Join(args, " ")), "err", rerr)
+		slog.InfoContext(ctx, fmt.Sprintf("[%s] $ git %s (DONE)", dir, strings.Join(args, " ")), "err", rerr)
 	}()
 
 	cmd := exec.CommandContext(ctx, "git", args...)
@@ -91,7 +99,7 @@ func (r *Repository) deleteWorktree(id string) error {
 	if err != nil {
 		return err
 	}
-	fmt.Printf("Deleting worktree at %s\n", worktreePath)
+	slog.Info("Deleting worktree", "path", worktreePath)
 	return os.RemoveAll(worktreePath)
 }
 
@@ -126,7 +134,7 @@ func (r *Repository) initializeWorktree(ctx context.Context, id string) (string,
 		return worktreePath, nil
 	}
 
-	slog.Info("Initializing worktree", "repository", r.userRepoPath, "container-id", id)
+	slog.InfoContext(ctx, "Initializing worktree", "repository", r.userRepoPath, "container-id", id)
 
 	return worktreePath, r.lockManager.WithLock(ctx, LockTypeWorktree, func() error {
 		if _, err := os.Stat(worktreePath); err == nil {
@@ -147,8 +155,7 @@ func (r *Repository) initializeWorktree(ctx context.Context, id string) (string,
 			}
 		}
 
-		_, err = RunGitCommand(ctx, r.forkRepoPath, "worktree", "add", worktreePath, id)
-		if err != nil {
+		if err := addWorktree(ctx, r.forkRepoPath, r.userRepoPath, worktreePath, id); err != nil {
 			return err
 		}
 
@@ -161,6 +168,31 @@ func (r *Repository) initializeWorktree(ctx context.Context, id string) (string,
 	})
 }
 
+// HeadCommit returns the latest commit of the environment's branch
+func (r *Repository) HeadCommit(ctx context.Context, id string) (string, error) {
+	head, err := RunGitCommand(ctx, r.forkRepoPath, "rev-parse", "--verify", id)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(head), nil
+}
+
+// recordForkPoint records the user's branch and commit the environment is forked from: its changes are diffed against them
+func (r *Repository) recordForkPoint(ctx context.Context, env *environment.Environment, worktreePath string) error {
+	// The worktree starts with the environment creation commit on top of the user's HEAD
+	baseCommit, err := RunGitCommand(ctx, worktreePath, "rev-parse", "HEAD^")
+	if err != nil {
+		return fmt.Errorf("failed to find the commit the environment is forked from: %w", err)
+	}
+	branch, err := r.currentUserBranch(ctx)
+	if err != nil {
+		return err
+	}
+	env.State.BaseCommit = strings.TrimSpace(baseCommit)
+	env.State.BaseBranch = strings.TrimSpace(branch)
+	return nil
+}
+
 // createInitialCommit creates an empty commit with the environment creation message - this prevents multiple environments from overwriting the container-use-state on the parent commit
 func (r *Repository) createInitialCommit(ctx context.Context, worktreePath, id, title string) error {
 	commitMessage := fmt.Sprintf("Create environment %s: %s", id, title)
@@ -169,35 +201,49 @@ func (r *Repository) createInitialCommit(ctx context.Context, worktreePath, id,
 }
 
 func (r *Repository) propagateToWorktree(ctx context.Context, env *environment.Environment, explanation string) (rerr error) {
-	slog.Info("Propagating to worktree...",
+	slog.InfoContext(ctx, "Propagating to worktree...",
 		"environment.id", env.ID,
 		"workdir", env.State.Config.Workdir,
 		"id", env.ID)
 	defer func() {
-		slog.Info("Propagating to worktree... (DONE)",
+		slog.InfoContext(ctx, "Propagating to worktree... (DONE)",
 			"environment.id", env.ID,
 			"workdir", env.State.Config.Workdir,
 			"id", env.ID,
 			"err", rerr)
 	}()
 
-	if err := r.exportEnvironment(ctx, env); err != nil {
+	worktreePath, err := r.WorktreePath(env.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get worktree path: %w", err)
+	}
+
+	// Review comments may have been added, or the budget extended, since the environment was loaded.
+	// Review comments are attached to the current HEAD, so pick them up before committing.
+	if err := r.mergeStoredState(ctx, env.EnvironmentInfo, worktreePath); err != nil {
 		return err
 	}
 
-	worktreePath, err := r.WorktreePath(env.ID)
+	if err := r.exportEnvironment(ctx, env); err != nil {
+		return err
+	}
+	// The journal of the state is attached to the current HEAD, drop it once the state is saved
+	head, err := RunGitCommand(ctx, worktreePath, "rev-parse", "HEAD")
 	if err != nil {
-		return fmt.Errorf("failed to get worktree path: %w", err)
+		return err
 	}
 	if err := r.commitWorktreeChanges(ctx, worktreePath, explanation); err != nil {
 		return fmt.Errorf("failed to commit worktree changes: %w", err)
 	}
 
-	if err := r.saveState(ctx, env); err != nil {
+	if err := r.saveState(ctx, env.EnvironmentInfo); err != nil {
 		return fmt.Errorf("failed to add notes: %w", err)
 	}
+	if err := r.dropStateJournal(ctx, worktreePath, strings.TrimSpace(head), "HEAD"); err != nil {
+		return err
+	}
 
-	slog.Info("Fetching container-use remote in source repository")
+	slog.InfoContext(ctx, "Fetching container-use remote in source repository")
 	if _, err := RunGitCommand(ctx, r.userRepoPath, "fetch", containerUseRemote, env.ID); err != nil {
 		return err
 	}
@@ -228,6 +274,17 @@ func (r *Repository) exportEnvironment(ctx context.Context, env *environment.Env
 		return nil
 	}
 
+	if env.IsKubernetes() {
+		if err := env.ExportPod(ctx, worktreePath); err != nil {
+			return err
+		}
+		// The workdir may have a .git of its own, replaced like the files of the pod replace those of the worktree
+		if err := os.RemoveAll(filepath.Join(worktreePath, ".git")); err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(worktreePath, ".git"), []byte(worktreePointer), 0644)
+	}
+
 	_, err = env.Workdir().
 		WithNewFile(".git", worktreePointer).
 		Export(
@@ -241,6 +298,9 @@ func (r *Repository) exportEnvironment(ctx context.Context, env *environment.Env
 
 	return nil
 }
+
+// propagateGitNotes fetches the given notes ref into the source repository.
+// Callers must hold the LockTypeGitNotes lock.
 func (r *Repository) propagateGitNotes(ctx context.Context, ref string) error {
 	fullRef := fmt.Sprintf("refs/notes/%s", ref)
 	fetch := func() error {
@@ -248,20 +308,39 @@ func (r *Repository) propagateGitNotes(ctx context.Context, ref string) error {
 		return err
 	}
 
-	return r.lockManager.WithLock(ctx, LockTypeGitNotes, func() error {
-		if err := fetch(); err != nil {
-			if strings.Contains(err.Error(), "[rejected]") {
-				if _, err := RunGitCommand(ctx, r.userRepoPath, "update-ref", "-d", fullRef); err == nil {
-					return fetch()
-				}
+	if err := fetch(); err != nil {
+		if strings.Contains(err.Error(), "[rejected]") {
+			if _, err := RunGitCommand(ctx, r.userRepoPath, "update-ref", "-d", fullRef); err == nil {
+				return fetch()
 			}
-			return err
 		}
-		return nil
-	})
+		return err
+	}
+	return nil
 }
 
-func (r *Repository) saveState(ctx context.Context, env *environment.Environment) error {
+// writeBlob stores data as a blob in the fork repository and returns its hash
+func (r *Repository) writeBlob(ctx context.Context, data []byte) (string, error) {
+	blob, err := runGitCommandWithInput(ctx, r.forkRepoPath, bytes.NewReader(data), "hash-object", "-w", "--stdin")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(blob), nil
+}
+
+// writeBlobRef stores data as a blob in the fork repository and points ref at it, e.g. for mailboxes and approvals
+func (r *Repository) writeBlobRef(ctx context.Context, ref string, data []byte) error {
+	blob, err := r.writeBlob(ctx, data)
+	if err != nil {
+		return err
+	}
+	_, err = RunGitCommand(ctx, r.forkRepoPath, "update-ref", ref, blob)
+	return err
+}
+
+// saveState stores the environment state in git notes, compressed with zstd: the notes of busy repositories pile up.
+// Callers must hold the LockTypeGitNotes lock.
+func (r *Repository) saveState(ctx context.Context, env *environment.EnvironmentInfo) error {
 	state, err := env.State.Marshal()
 	if err != nil {
 		return err
@@ -271,40 +350,64 @@ func (r *Repository) saveState(ctx context.Context, env *environment.Environment
 		return fmt.Errorf("failed to get worktree path: %w", err)
 	}
 
-	f, err := os.CreateTemp(os.TempDir(), ".container-use-git-notes-*")
+	// Notes added from a file are cleaned up like commit messages: the compressed state is added as a blob as is
+	blob, err := r.writeBlob(ctx, stateEncoder.EncodeAll(state, nil))
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	if _, err := f.Write(state); err != nil {
-		return err
-	}
-
-	return r.lockManager.WithLock(ctx, LockTypeGitNotes, func() error {
-		_, err = RunGitCommand(ctx, worktreePath, "notes", "--ref", gitNotesStateRef, "add", "-f", "-F", f.Name())
-		return err
-	})
+	_, err = RunGitCommand(ctx, worktreePath, "notes", "--ref", gitNotesStateRef, "add", "-f", "-C", blob)
+	return err
 }
 
 func (r *Repository) loadState(ctx context.Context, worktreePath string) ([]byte, error) {
 	var result []byte
 
 	err := r.lockManager.WithRLock(ctx, LockTypeGitNotes, func() error {
-		buff, err := RunGitCommand(ctx, worktreePath, "notes", "--ref", gitNotesStateRef, "show")
-		if err != nil {
-			if strings.Contains(err.Error(), "no note found") {
-				result = nil
-				return nil
-			}
-			return err
-		}
-		result = []byte(buff)
-		return nil
+		var err error
+		result, err = r.readState(ctx, worktreePath)
+		return err
 	})
 
 	return result, err
 }
 
+// readState is loadState for callers already holding the LockTypeGitNotes lock.
+// Changes journaled since the state was saved are applied to it.
+func (r *Repository) readState(ctx context.Context, worktreePath string) ([]byte, error) {
+	buff, err := RunGitCommand(ctx, worktreePath, "notes", "--ref", gitNotesStateRef, "show")
+	if err != nil {
+		if strings.Contains(err.Error(), "no note found") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	state, err := decompressState([]byte(buff))
+	if err != nil {
+		return nil, err
+	}
+	return r.readStateJournal(ctx, worktreePath, state)
+}
+
+var (
+	stateEncoder, _ = zstd.NewWriter(nil)
+	stateDecoder, _ = zstd.NewReader(nil)
+	// zstdMagic starts zstd frames
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompressState decompresses a state saved by saveState. The states of environments saved before they were
+// compressed are JSON, read as is.
+func decompressState(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, zstdMagic) {
+		return data, nil
+	}
+	state, err := stateDecoder.DecodeAll(data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress the environment state: %w", err)
+	}
+	return state, nil
+}
+
 func (r *Repository) addGitNote(ctx context.Context, env *environment.Environment, note string) error {
 	worktreePath, err := r.WorktreePath(env.ID)
 	if err != nil {
@@ -347,6 +450,24 @@ func (r *Repository) revisionRange(ctx context.Context, env *environment.Environ
 	return fmt.Sprintf("%s..%s", mergeBase, envGitRef), nil
 }
 
+// forkRevisionRange is the range of the changes of the environment since it was forked from the user's branch.
+// Environments created before the fork point was recorded fall back to the user's current branch.
+func (r *Repository) forkRevisionRange(ctx context.Context, env *environment.EnvironmentInfo) (string, error) {
+	envGitRef := fmt.Sprintf("%s/%s", containerUseRemote, env.ID)
+	if env.State.BaseBranch != "" {
+		// The branch may have moved on since: only diff what the environment changed
+		mergeBase, err := RunGitCommand(ctx, r.userRepoPath, "merge-base", "refs/heads/"+env.State.BaseBranch, envGitRef)
+		if err == nil {
+			return fmt.Sprintf("%s..%s", strings.TrimSpace(mergeBase), envGitRef), nil
+		}
+		slog.WarnContext(ctx, "Failed to find the branch the environment was forked from", "branch", env.State.BaseBranch, "err", err)
+	}
+	if env.State.BaseCommit != "" {
+		return fmt.Sprintf("%s..%s", env.State.BaseCommit, envGitRef), nil
+	}
+	return r.revisionRange(ctx, env)
+}
+
 func (r *Repository) commitWorktreeChanges(ctx context.Context, worktreePath, explanation string) error {
 	status, err := RunGitCommand(ctx, worktreePath, "status", "--porcelain")
 	if err != nil {
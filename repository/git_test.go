@@ -7,6 +7,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/dagger/container-use/environment"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -198,3 +199,34 @@ func createDir(t *testing.T, dir, name string) {
 	err := os.MkdirAll(path, 0755)
 	require.NoError(t, err)
 }
+
+// TestSaveState tests that states are compressed in the notes, and that uncompressed states are still read
+func TestSaveState(t *testing.T) {
+	ctx := context.Background()
+	repo := setupTestRepository(t)
+
+	id := "state-env"
+	worktree, err := repo.initializeWorktree(ctx, id)
+	require.NoError(t, err)
+	require.NoError(t, repo.createInitialCommit(ctx, worktree, id, id))
+
+	info := &environment.EnvironmentInfo{ID: id, State: &environment.State{Title: strings.Repeat("title ", 100)}}
+	require.NoError(t, repo.saveState(ctx, info))
+	stored, err := RunGitCommand(ctx, worktree, "notes", "--ref", gitNotesStateRef, "show")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stored, string(zstdMagic)))
+	assert.Less(t, len(stored), 200)
+
+	state, err := repo.loadState(ctx, worktree)
+	require.NoError(t, err)
+	loaded := &environment.State{}
+	require.NoError(t, loaded.Unmarshal(state))
+	assert.Equal(t, info.State.Title, loaded.Title)
+
+	// Environments saved before states were compressed
+	_, err = RunGitCommand(ctx, worktree, "notes", "--ref", gitNotesStateRef, "add", "-f", "-m", `{"title": "plain"}`)
+	require.NoError(t, err)
+	state, err = repo.loadState(ctx, worktree)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"title": "plain"}`, string(state))
+}
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// agentInstructionsFiles hold the conventions of repositories for agents, by precedence
+var agentInstructionsFiles = []string{filepath.Join(".container-use", "instructions.md"), "AGENTS.md"}
+
+// rulesMarker delimits the container-use rules added to agent rules files by `container-use agent`
+const rulesMarker = "<!-- container-use-rules -->"
+
+// AgentInstructions returns the instructions of the repository for agents and the file they come from,
+// or empty strings if the repository has none.
+func (r *Repository) AgentInstructions() (string, string, error) {
+	for _, file := range agentInstructionsFiles {
+		data, err := os.ReadFile(filepath.Join(r.userRepoPath, file))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", "", err
+		}
+		if instructions := withoutRules(string(data)); instructions != "" {
+			return filepath.ToSlash(file), instructions, nil
+		}
+	}
+	return "", "", nil
+}
+
+// withoutRules leaves out the container-use rules of an agent rules file: agents get them from the server
+func withoutRules(content string) string {
+	parts := strings.Split(content, rulesMarker)
+	if len(parts) == 3 {
+		content = parts[0] + parts[2]
+	}
+	return strings.TrimSpace(content)
+}
@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentInstructions(t *testing.T) {
+	repo := &Repository{userRepoPath: t.TempDir()}
+
+	file, instructions, err := repo.AgentInstructions()
+	require.NoError(t, err)
+	assert.Empty(t, file)
+	assert.Empty(t, instructions)
+
+	agents := "# Conventions\n\nRun make lint.\n\n" + rulesMarker + "\nALWAYS use container-use environments\n" + rulesMarker + "\n"
+	require.NoError(t, os.WriteFile(filepath.Join(repo.userRepoPath, "AGENTS.md"), []byte(agents), 0600))
+	file, instructions, err = repo.AgentInstructions()
+	require.NoError(t, err)
+	assert.Equal(t, "AGENTS.md", file)
+	assert.Equal(t, "# Conventions\n\nRun make lint.", instructions, "container-use rules are left out")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(repo.userRepoPath, ".container-use"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(repo.userRepoPath, ".container-use", "instructions.md"), []byte("Never touch migrations/\n"), 0600))
+	file, instructions, err = repo.AgentInstructions()
+	require.NoError(t, err)
+	assert.Equal(t, ".container-use/instructions.md", file)
+	assert.Equal(t, "Never touch migrations/", instructions)
+}
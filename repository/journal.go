@@ -0,0 +1,204 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/dagger/container-use/environment"
+)
+
+// gitNotesJournalRef holds the changes of the state of environments since it was last saved, one JSON object of the
+// changed fields per line. Environments get new containers many times during an operation, while the state is saved
+// when it's done: journaling the changes as they happen means a crash in-between doesn't lose the container chain.
+//
+// New containers are journaled in two phases: an intent, the changed fields along with the new container under
+// journalIntentField, written before the container is synced, then a commit naming the container under
+// journalCommitField once it is. Intents without commit are discarded when replaying, so a crash mid-apply leaves the
+// environment with the last container synced.
+const gitNotesJournalRef = "container-use-state-journal"
+
+const (
+	journalIntentField = "$intent"
+	journalCommitField = "$commit"
+)
+
+// journalState records the changes of the state of an environment since it was last saved, or journaled.
+// Failures are logged: the state is saved anyway at the end of the operation.
+func (r *Repository) journalState(ctx context.Context, id, phase string, state []byte) {
+	err := r.lockManager.WithLock(ctx, LockTypeGitNotes, func() error {
+		worktreePath, err := r.WorktreePath(id)
+		if err != nil {
+			return fmt.Errorf("failed to get worktree path: %w", err)
+		}
+		stored, err := r.readState(ctx, worktreePath)
+		if err != nil || stored == nil {
+			// Environments being created are saved in full first
+			return err
+		}
+		record, err := journalRecord(stored, phase, state)
+		if err != nil || record == nil {
+			return err
+		}
+		_, err = RunGitCommand(ctx, worktreePath, "notes", "--ref", gitNotesJournalRef, "append", "-m", string(record))
+		return err
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to journal environment state", "environment.id", id, "phase", phase, "err", err)
+	}
+}
+
+// journalRecord returns the line of the journal of a phase of an apply, nil when there's nothing to journal
+func journalRecord(stored []byte, phase string, state []byte) ([]byte, error) {
+	var container struct {
+		Container string `json:"container"`
+	}
+	if err := json.Unmarshal(state, &container); err != nil {
+		return nil, err
+	}
+	if phase == environment.ApplyCommit {
+		return json.Marshal(map[string]string{journalCommitField: container.Container})
+	}
+	delta, err := stateDelta(stored, state)
+	if err != nil || delta == nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(delta, &fields); err != nil {
+		return nil, err
+	}
+	if fields[journalIntentField], err = json.Marshal(container.Container); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// watchState journals the state of the environment every time it gets a new container
+func (r *Repository) watchState(env *environment.Environment) {
+	env.OnApply(func(ctx context.Context, phase string, state []byte) {
+		r.journalState(ctx, env.ID, phase, state)
+	})
+}
+
+// readStateJournal replays the journal of HEAD over the saved state.
+// Callers must hold the LockTypeGitNotes lock.
+func (r *Repository) readStateJournal(ctx context.Context, worktreePath string, state []byte) ([]byte, error) {
+	journal, err := RunGitCommand(ctx, worktreePath, "notes", "--ref", gitNotesJournalRef, "show")
+	if err != nil {
+		if strings.Contains(err.Error(), "no note found") {
+			return state, nil
+		}
+		return nil, err
+	}
+	return replayStateJournal(state, journal)
+}
+
+// dropStateJournal forgets the journals of the commits, once the state is saved.
+// Callers must hold the LockTypeGitNotes lock.
+func (r *Repository) dropStateJournal(ctx context.Context, worktreePath string, commits ...string) error {
+	args := append([]string{"notes", "--ref", gitNotesJournalRef, "remove", "--ignore-missing"}, commits...)
+	_, err := RunGitCommand(ctx, worktreePath, args...)
+	return err
+}
+
+// stateDelta returns the top-level fields of the state changed from the stored one, removed fields being null.
+// It returns nil when nothing changed.
+func stateDelta(stored, state []byte) ([]byte, error) {
+	var before, after map[string]json.RawMessage
+	if err := json.Unmarshal(stored, &before); err != nil {
+		// Legacy states aren't journaled, they get saved in full
+		return nil, nil
+	}
+	if err := json.Unmarshal(state, &after); err != nil {
+		return nil, err
+	}
+
+	delta := map[string]json.RawMessage{}
+	for field, value := range after {
+		if !jsonEqual(before[field], value) {
+			delta[field] = value
+		}
+	}
+	for field := range before {
+		if _, ok := after[field]; !ok {
+			delta[field] = json.RawMessage("null")
+		}
+	}
+	if len(delta) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(delta)
+}
+
+// replayStateJournal applies the changes of the journal to the state, in order.
+// The changes of intents are only applied once their container is committed.
+func replayStateJournal(state []byte, journal string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(state, &fields); err != nil {
+		return state, nil
+	}
+	apply := func(delta map[string]json.RawMessage) {
+		for field, value := range delta {
+			if string(value) == "null" {
+				delete(fields, field)
+			} else {
+				fields[field] = value
+			}
+		}
+	}
+	// intents are the changes of the containers not committed yet
+	intents := map[string]map[string]json.RawMessage{}
+	replayed := false
+	for _, line := range strings.Split(journal, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var delta map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(line), &delta); err != nil {
+			return nil, fmt.Errorf("invalid state journal: %w", err)
+		}
+		var container string
+		switch {
+		case delta[journalIntentField] != nil:
+			if err := json.Unmarshal(delta[journalIntentField], &container); err != nil {
+				return nil, fmt.Errorf("invalid state journal: %w", err)
+			}
+			delete(delta, journalIntentField)
+			intents[container] = delta
+		case delta[journalCommitField] != nil:
+			if err := json.Unmarshal(delta[journalCommitField], &container); err != nil {
+				return nil, fmt.Errorf("invalid state journal: %w", err)
+			}
+			if intent, ok := intents[container]; ok {
+				apply(intent)
+				delete(intents, container)
+				replayed = true
+			}
+		default:
+			apply(delta)
+			replayed = true
+		}
+	}
+	if len(intents) > 0 {
+		slog.Warn("Discarding containers of interrupted operations, which were never synced", "count", len(intents))
+	}
+	if !replayed {
+		return state, nil
+	}
+	return json.MarshalIndent(fields, "", "  ")
+}
+
+// jsonEqual compares JSON values regardless of their formatting
+func jsonEqual(a, b json.RawMessage) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	var ca, cb bytes.Buffer
+	if json.Compact(&ca, a) != nil || json.Compact(&cb, b) != nil {
+		return false
+	}
+	return bytes.Equal(ca.Bytes(), cb.Bytes())
+}
@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateDelta(t *testing.T) {
+	stored := []byte(`{"container": "a", "title": "x", "tags": ["b"]}`)
+
+	delta, err := stateDelta(stored, []byte(`{"container":"a","title":"x","tags":["b"]}`))
+	require.NoError(t, err)
+	assert.Nil(t, delta)
+
+	delta, err = stateDelta(stored, []byte(`{"container": "b", "tags": ["b"]}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"container": "b", "title": null}`, string(delta))
+
+	replayed, err := replayStateJournal(stored, string(delta)+"\n\n"+`{"container": "c"}`+"\n")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"container": "c", "tags": ["b"]}`, string(replayed))
+
+	_, err = replayStateJournal(stored, "{")
+	assert.Error(t, err)
+}
+
+func TestReplayStateJournalIntents(t *testing.T) {
+	stored := []byte(`{"container": "a", "title": "x"}`)
+
+	intent, err := journalRecord(stored, environment.ApplyIntent, []byte(`{"container": "b", "title": "x"}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"$intent": "b", "container": "b"}`, string(intent))
+	commit, err := journalRecord(stored, environment.ApplyCommit, []byte(`{"container": "b", "title": "x"}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"$commit": "b"}`, string(commit))
+
+	replayed, err := replayStateJournal(stored, string(intent)+"\n"+string(commit)+"\n")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"container": "b", "title": "x"}`, string(replayed))
+
+	// The operation was interrupted before the container was synced
+	replayed, err = replayStateJournal(stored, string(intent)+"\n"+`{"title": "y"}`+"\n"+`{"$intent": "c", "container": "c"}`)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"container": "a", "title": "y"}`, string(replayed))
+}
+
+// TestStateJournal tests that containers applied between updates survive without a full save
+func TestStateJournal(t *testing.T) {
+	ctx := context.Background()
+	repo := setupTestRepository(t)
+
+	id := "journal-env"
+	worktree, err := repo.initializeWorktree(ctx, id)
+	require.NoError(t, err)
+	require.NoError(t, repo.createInitialCommit(ctx, worktree, id, id))
+
+	info := &environment.EnvironmentInfo{ID: id, State: &environment.State{Title: id, Container: "first"}}
+	// Nothing is journaled before the environment is saved
+	repo.journalState(ctx, id, environment.ApplyIntent, []byte(`{"container": "ignored"}`))
+	require.NoError(t, repo.saveState(ctx, info))
+
+	for _, container := range []string{"second", "third"} {
+		info.State.Container = container
+		state, err := info.State.Marshal()
+		require.NoError(t, err)
+		repo.journalState(ctx, id, environment.ApplyIntent, state)
+		repo.journalState(ctx, id, environment.ApplyCommit, state)
+	}
+	// Interrupted before the container was synced
+	info.State.Container = "unsynced"
+	state, err := info.State.Marshal()
+	require.NoError(t, err)
+	repo.journalState(ctx, id, environment.ApplyIntent, state)
+
+	loaded, err := repo.Info(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "third", loaded.State.Container)
+	assert.Equal(t, id, loaded.State.Title)
+
+	require.NoError(t, repo.saveState(ctx, info))
+	require.NoError(t, repo.dropStateJournal(ctx, worktree, "HEAD"))
+	_, err = RunGitCommand(ctx, worktree, "notes", "--ref", gitNotesJournalRef, "show")
+	assert.Error(t, err)
+}
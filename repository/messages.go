@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	petname "github.com/dustinkirkland/golang-petname"
+)
+
+const (
+	messagesRefPrefix = "refs/container-use/messages/"
+)
+
+// Message is a structured note sent from one environment to another.
+// Messages let cooperating agents working in different environments of the
+// same repository coordinate (e.g. "API contract changed, see branch X").
+type Message struct {
+	ID        string    `json:"id"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Topic     string    `json:"topic,omitempty"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Send delivers a message from one environment to another.
+// If to is empty, the message is broadcast to every other environment in the repository.
+// Returns the messages that were delivered (one per recipient).
+func (r *Repository) Send(ctx context.Context, from, to, topic, body string) ([]*Message, error) {
+	if err := r.exists(ctx, from); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(body) == "" {
+		return nil, fmt.Errorf("message body cannot be empty")
+	}
+
+	recipients := []string{}
+	if to != "" {
+		if err := r.exists(ctx, to); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, to)
+	} else {
+		envs, err := r.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, env := range envs {
+			if env.ID != from {
+				recipients = append(recipients, env.ID)
+			}
+		}
+		if len(recipients) == 0 {
+			return nil, fmt.Errorf("no other environment to broadcast to")
+		}
+	}
+
+	return r.deliver(ctx, from, recipients, topic, body)
+}
+
+// deliver appends a message to the mailbox of each recipient
+func (r *Repository) deliver(ctx context.Context, from string, recipients []string, topic, body string) ([]*Message, error) {
+	sent := []*Message{}
+	err := r.lockManager.WithLock(ctx, LockTypeMessages, func() error {
+		for _, recipient := range recipients {
+			mailbox, err := r.loadMailbox(ctx, recipient)
+			if err != nil {
+				return err
+			}
+			msg := &Message{
+				ID:        petname.Generate(3, "-"),
+				From:      from,
+				To:        recipient,
+				Topic:     topic,
+				Body:      body,
+				CreatedAt: time.Now(),
+			}
+			mailbox = append(mailbox, msg)
+			if err := r.saveMailbox(ctx, recipient, mailbox); err != nil {
+				return err
+			}
+			sent = append(sent, msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sent, nil
+}
+
+// Receive returns the pending messages for an environment, oldest first.
+// Unless peek is set, the returned messages are removed from the mailbox.
+func (r *Repository) Receive(ctx context.Context, id string, peek bool) ([]*Message, error) {
+	if err := r.exists(ctx, id); err != nil {
+		return nil, err
+	}
+
+	var mailbox []*Message
+	err := r.lockManager.WithLock(ctx, LockTypeMessages, func() error {
+		var err error
+		mailbox, err = r.loadMailbox(ctx, id)
+		if err != nil {
+			return err
+		}
+		if peek || len(mailbox) == 0 {
+			return nil
+		}
+		return r.deleteMailbox(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return mailbox, nil
+}
+
+func (r *Repository) loadMailbox(ctx context.Context, id string) ([]*Message, error) {
+	ref := messagesRefPrefix + id
+	if _, err := RunGitCommand(ctx, r.forkRepoPath, "rev-parse", "--verify", "--quiet", ref); err != nil {
+		return []*Message{}, nil
+	}
+
+	buff, err := RunGitCommand(ctx, r.forkRepoPath, "cat-file", "blob", ref)
+	if err != nil {
+		return nil, err
+	}
+
+	mailbox := []*Message{}
+	if err := json.Unmarshal([]byte(buff), &mailbox); err != nil {
+		return nil, fmt.Errorf("failed to load mailbox for %s: %w", id, err)
+	}
+	return mailbox, nil
+}
+
+func (r *Repository) saveMailbox(ctx context.Context, id string, mailbox []*Message) error {
+	data, err := json.MarshalIndent(mailbox, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return r.writeBlobRef(ctx, messagesRefPrefix+id, data)
+}
+
+func (r *Repository) deleteMailbox(ctx context.Context, id string) error {
+	_, err := RunGitCommand(ctx, r.forkRepoPath, "update-ref", "-d", messagesRefPrefix+id)
+	return err
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRepositoryMessages tests sending and receiving messages between environments
+func TestRepositoryMessages(t *testing.T) {
+	ctx := context.Background()
+	repo := setupTestRepository(t)
+
+	for _, id := range []string{"env-a", "env-b", "env-c"} {
+		worktree, err := repo.initializeWorktree(ctx, id)
+		require.NoError(t, err)
+		require.NoError(t, repo.createInitialCommit(ctx, worktree, id, id))
+	}
+
+	t.Run("send_and_receive", func(t *testing.T) {
+		sent, err := repo.Send(ctx, "env-a", "env-b", "api-contract", "API changed, see container-use/env-a")
+		require.NoError(t, err)
+		require.Len(t, sent, 1)
+		assert.Equal(t, "env-a", sent[0].From)
+		assert.Equal(t, "env-b", sent[0].To)
+
+		peeked, err := repo.Receive(ctx, "env-b", true)
+		require.NoError(t, err)
+		require.Len(t, peeked, 1)
+
+		received, err := repo.Receive(ctx, "env-b", false)
+		require.NoError(t, err)
+		require.Len(t, received, 1)
+		assert.Equal(t, "api-contract", received[0].Topic)
+		assert.Equal(t, "API changed, see container-use/env-a", received[0].Body)
+
+		received, err = repo.Receive(ctx, "env-b", false)
+		require.NoError(t, err)
+		assert.Empty(t, received)
+	})
+
+	t.Run("broadcast", func(t *testing.T) {
+		// Environments without state are not listed, so there is nobody to broadcast to yet
+		_, err := repo.Send(ctx, "env-a", "", "", "hello")
+		assert.Error(t, err)
+
+		for _, id := range []string{"env-a", "env-b", "env-c"} {
+			require.NoError(t, repo.saveState(ctx, &environment.EnvironmentInfo{
+				ID:    id,
+				State: &environment.State{Title: id},
+			}))
+		}
+
+		sent, err := repo.Send(ctx, "env-a", "", "", "hello")
+		require.NoError(t, err)
+		require.Len(t, sent, 2)
+
+		for _, id := range []string{"env-b", "env-c"} {
+			received, err := repo.Receive(ctx, id, false)
+			require.NoError(t, err)
+			require.Len(t, received, 1)
+			assert.Equal(t, "env-a", received[0].From)
+		}
+		received, err := repo.Receive(ctx, "env-a", false)
+		require.NoError(t, err)
+		assert.Empty(t, received, "the sender should not receive its own broadcast")
+	})
+
+	t.Run("delete_removes_mailbox", func(t *testing.T) {
+		_, err := repo.Send(ctx, "env-a", "env-c", "", "hello")
+		require.NoError(t, err)
+		_, err = RunGitCommand(ctx, repo.forkRepoPath, "rev-parse", "--verify", messagesRefPrefix+"env-c")
+		require.NoError(t, err)
+
+		require.NoError(t, repo.Delete(ctx, "env-c"))
+
+		_, err = RunGitCommand(ctx, repo.forkRepoPath, "rev-parse", "--verify", messagesRefPrefix+"env-c")
+		assert.Error(t, err)
+	})
+
+	t.Run("unknown_recipient", func(t *testing.T) {
+		_, err := repo.Send(ctx, "env-a", "does-not-exist", "", "hello")
+		assert.Error(t, err)
+	})
+
+	t.Run("empty_body", func(t *testing.T) {
+		_, err := repo.Send(ctx, "env-a", "env-b", "", "  ")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dagger/container-use/environment"
+)
+
+// Policy rules checked on the changes of environments
+const (
+	PolicyLicenseHeader = "license-header"
+	PolicyCodeowners    = "codeowners"
+)
+
+// codeownersPaths are where GitHub looks for the CODEOWNERS file, in order
+var codeownersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// PolicyViolation is a change of an environment the repository governance asks to look at
+type PolicyViolation struct {
+	File    string `json:"file"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	// Owners are the code owners who must approve changes to the file
+	Owners []string `json:"owners,omitempty"`
+}
+
+// codeownersRule is a line of a CODEOWNERS file
+type codeownersRule struct {
+	pattern string
+	owners  []string
+}
+
+// parseCodeowners parses a CODEOWNERS file. Rules are returned in file order: the last matching rule wins.
+func parseCodeowners(r io.Reader) ([]codeownersRule, error) {
+	rules := []codeownersRule{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		rules = append(rules, codeownersRule{pattern: fields[0], owners: fields[1:]})
+	}
+	return rules, scanner.Err()
+}
+
+// codeowners returns the owners of file. A matching rule without owners leaves the file unowned.
+func codeowners(rules []codeownersRule, file string) []string {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if environment.MatchPathPattern(rules[i].pattern, file) {
+			return rules[i].owners
+		}
+	}
+	return nil
+}
+
+// loadCodeowners reads the CODEOWNERS file of the user's repository, if any
+func (r *Repository) loadCodeowners() ([]codeownersRule, error) {
+	for _, path := range codeownersPaths {
+		f, err := os.Open(filepath.Join(r.userRepoPath, path))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return parseCodeowners(f)
+	}
+	return nil, nil
+}
+
+// CheckPolicies checks the files changed by the environment against the governance of the user's repository:
+// license headers required by the configuration, and paths protected by the CODEOWNERS file.
+// The user's configuration and CODEOWNERS file are used, agents can't change them.
+func (r *Repository) CheckPolicies(ctx context.Context, id string) ([]PolicyViolation, error) {
+	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	config := environment.DefaultConfig()
+	if err := config.Load(r.userRepoPath); err != nil {
+		return nil, err
+	}
+	owners, err := r.loadCodeowners()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CODEOWNERS: %w", err)
+	}
+	if len(config.LicenseHeaders) == 0 && len(owners) == 0 {
+		return nil, nil
+	}
+
+	revisionRange, err := r.forkRevisionRange(ctx, envInfo)
+	if err != nil {
+		return nil, err
+	}
+	// Deleted files need their owners' approval too, but have no header to keep
+	changes, err := RunGitCommand(ctx, r.userRepoPath, "diff", "--name-status", "--no-renames", revisionRange)
+	if err != nil {
+		return nil, err
+	}
+
+	violations := []PolicyViolation{}
+	for line := range strings.SplitSeq(strings.TrimSpace(changes), "\n") {
+		status, file, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		if fileOwners := codeowners(owners, file); len(fileOwners) > 0 {
+			violations = append(violations, PolicyViolation{
+				File:    file,
+				Rule:    PolicyCodeowners,
+				Message: "protected path, requires approval from " + strings.Join(fileOwners, ", "),
+				Owners:  fileOwners,
+			})
+		}
+		if status == "D" || len(config.LicenseHeaders) == 0 {
+			continue
+		}
+		contents, err := RunGitCommand(ctx, r.userRepoPath, "show", fmt.Sprintf("%s/%s:%s", containerUseRemote, envInfo.ID, file))
+		if err != nil {
+			return nil, err
+		}
+		for _, header := range config.LicenseHeaders.Missing(file, contents) {
+			violations = append(violations, PolicyViolation{
+				File:    file,
+				Rule:    PolicyLicenseHeader,
+				Message: fmt.Sprintf("missing license header %q", header),
+			})
+		}
+	}
+	return violations, nil
+}
+
+// checkChanges checks the changes of an environment before they leave it: secrets can block them, policy
+// violations are reported. Reports are written to w, or logged if w is nil.
+func (r *Repository) checkChanges(ctx context.Context, id string, w io.Writer) error {
+	if err := r.checkSecrets(ctx, id, w); err != nil {
+		return err
+	}
+	violations, err := r.CheckPolicies(ctx, id)
+	if err != nil {
+		return err
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	if w == nil {
+		slog.WarnContext(ctx, "Policy violations in the changes of the environment", "environment.id", id, "violations", violations)
+		return nil
+	}
+	fmt.Fprintf(w, "Warning: policy violations in the changes of environment %s:\n", id)
+	for _, v := range violations {
+		fmt.Fprintf(w, "  %s: %s: %s\n", v.File, v.Rule, v.Message)
+	}
+	return nil
+}
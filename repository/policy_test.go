@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodeowners(t *testing.T) {
+	rules, err := parseCodeowners(strings.NewReader(`# Owners of the repository
+*                @acme/maintainers
+/docs/           @acme/docs   # technical writers
+*.sql            @acme/dba @alice
+/docs/generated/
+`))
+	require.NoError(t, err)
+	require.Len(t, rules, 4)
+
+	assert.Equal(t, []string{"@acme/maintainers"}, codeowners(rules, "main.go"))
+	assert.Equal(t, []string{"@acme/docs"}, codeowners(rules, "docs/index.md"))
+	assert.Equal(t, []string{"@acme/dba", "@alice"}, codeowners(rules, "docs/schema.sql"), "the last matching rule wins")
+	assert.Empty(t, codeowners(rules, "docs/generated/api.md"), "rules without owners unprotect paths")
+	assert.Empty(t, codeowners(nil, "main.go"))
+}
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -9,9 +10,11 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"sort"
 	"strings"
+	"time"
 
 	"dagger.io/dagger"
 	"github.com/dagger/container-use/environment"
@@ -50,6 +53,8 @@ func getDefaultConfigPath() string {
 
 var (
 	cuGlobalConfigPath = getDefaultConfigPath()
+
+	errNotFound = errors.New("not found")
 )
 
 type Repository struct {
@@ -57,6 +62,7 @@ type Repository struct {
 	forkRepoPath string
 	basePath     string // defaults to OS-appropriate config path if empty
 	lockManager  *RepositoryLockManager
+	summarizer   *environment.Summarizer
 }
 
 // getRepoPath returns the path for storing repository data
@@ -107,11 +113,17 @@ func OpenWithBasePath(ctx context.Context, repo string, basePath string) (*Repos
 		}
 	}
 
+	summarizer, err := environment.SummarizerFromEnv()
+	if err != nil {
+		slog.WarnContext(ctx, "Ignoring environment summarizer", "err", err)
+	}
+
 	r := &Repository{
 		userRepoPath: userRepoPath,
 		forkRepoPath: forkRepoPath,
 		basePath:     expandedBasePath,
 		lockManager:  NewRepositoryLockManager(userRepoPath),
+		summarizer:   summarizer,
 	}
 
 	err = r.lockManager.WithLock(ctx, LockTypeRepo, func() error {
@@ -137,7 +149,7 @@ func (r *Repository) ensureFork(ctx context.Context) error {
 		return err
 	}
 
-	slog.Info("Initializing local remote", "user-repo", r.userRepoPath, "fork-repo", r.forkRepoPath)
+	slog.InfoContext(ctx, "Initializing local remote", "user-repo", r.userRepoPath, "fork-repo", r.forkRepoPath)
 	if err := os.MkdirAll(r.forkRepoPath, 0755); err != nil {
 		return err
 	}
@@ -174,16 +186,54 @@ func (r *Repository) SourcePath() string {
 func (r *Repository) exists(ctx context.Context, id string) error {
 	if _, err := RunGitCommand(ctx, r.forkRepoPath, "rev-parse", "--verify", id); err != nil {
 		if strings.Contains(err.Error(), "Needed a single revision") {
-			return fmt.Errorf("environment %q not found", id)
+			return fmt.Errorf("environment %q %w", id, errNotFound)
 		}
 		return err
 	}
 	return nil
 }
 
+var environmentIDRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// validateEnvironmentID checks an environment ID chosen by the user or read from a bundle before it names a branch
+// and a worktree directory
+func validateEnvironmentID(id string) error {
+	if !environmentIDRe.MatchString(id) {
+		return fmt.Errorf("invalid environment ID %q: use letters, digits, '-' and '_'", id)
+	}
+	return nil
+}
+
+// CreateOpts are the options of Create
+type CreateOpts struct {
+	// Template is the preset configuration to start from
+	Template string
+	// Overrides tweak the configuration of this environment only
+	Overrides *environment.ConfigOverlay
+}
+
 // Create creates a new environment with the given description and explanation.
 // Requires a dagger client for container operations during environment initialization.
-func (r *Repository) Create(ctx context.Context, dag *dagger.Client, description, explanation string) (*environment.Environment, error) {
+//
+// The configuration is built in layers, each applying on top of the previous ones:
+//  1. the defaults, or the template's configuration
+//  2. the container-use configuration of the project
+//  3. without configuration nor template, the project's dev container or, failing that, its detected stack
+//  4. the services of the project's compose file
+//  5. the overrides
+//
+// Caches of the project's languages are added last, unless caches are configured.
+func (r *Repository) Create(ctx context.Context, dag *dagger.Client, description, explanation string, opts CreateOpts) (*environment.Environment, error) {
+	template := opts.Template
+	config := environment.DefaultConfig()
+	if template != "" {
+		t, err := environment.GetTemplate(template)
+		if err != nil {
+			return nil, err
+		}
+		config = t.Config()
+	}
+
 	id := petname.Generate(2, "-")
 	worktree, err := r.initializeWorktree(ctx, id)
 	if err != nil {
@@ -211,10 +261,39 @@ func (r *Repository) Create(ctx context.Context, dag *dagger.Client, description
 		return nil, fmt.Errorf("failed loading initial source directory: %w", err)
 	}
 
-	config := environment.DefaultConfig()
 	if err := config.Load(r.userRepoPath); err != nil {
 		return nil, err
 	}
+	// Without a container-use configuration or template, start from the project's dev container, if any
+	var devcontainerWarnings []string
+	if !environment.HasConfig(r.userRepoPath) && template == "" {
+		devcontainerWarnings, err = config.ImportDevcontainer(r.userRepoPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import dev container configuration: %w", err)
+		}
+	}
+	// Without any configuration at all, set up the environment for the languages of the project
+	var stack *environment.DetectedStack
+	if !environment.HasConfig(r.userRepoPath) && template == "" && environment.FindDevcontainerFile(r.userRepoPath) == "" &&
+		os.Getenv("CONTAINER_USE_DEFAULT_HOST") != "1" {
+		stack = environment.DetectStack(r.userRepoPath)
+		if stack != nil {
+			config.ApplyStack(stack)
+		}
+	}
+	// Bring up the dependencies declared in the project's compose file, if any
+	composeWarnings, err := config.ImportCompose(r.userRepoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import compose file: %w", err)
+	}
+	if opts.Overrides != nil {
+		if err := opts.Overrides.Validate(); err != nil {
+			return nil, err
+		}
+		config.ApplyOverlay(opts.Overrides)
+	}
+	// Keep the dependencies of the project's languages across environments
+	config.AddDefaultCaches(r.userRepoPath, environment.ProjectCacheKey(r.userRepoPath))
 	// For host mode, set workdir to the actual worktree path
 	if strings.EqualFold(config.BaseImage, "host") {
 		config.Workdir = worktree
@@ -224,12 +303,31 @@ func (r *Repository) Create(ctx context.Context, dag *dagger.Client, description
 	if err != nil {
 		return nil, err
 	}
+	// This process runs the services it just started
+	r.ClaimServices(id)
+	env.SetRepository(r.forkRepoPath)
+	r.watchState(env)
+	if err := r.recordForkPoint(ctx, env, worktree); err != nil {
+		return nil, err
+	}
+	env.State.DetectedStack = stack
+	env.State.ConfigOverrides = opts.Overrides
+	if stack != nil {
+		env.Notes.Add("Detected %s from %s: using %s", strings.Join(stack.Languages, ", "), strings.Join(stack.Manifests, ", "), stack.BaseImage)
+	}
+	for _, warning := range devcontainerWarnings {
+		env.Notes.Add("Dev container import: %s", warning)
+	}
+	for _, warning := range composeWarnings {
+		env.Notes.Add("Compose import: %s", warning)
+	}
 
 	if err := r.lockManager.WithLock(ctx, LockTypeGitNotes, func() error {
 		return r.propagateToWorktree(ctx, env, explanation)
 	}); err != nil {
 		return nil, err
 	}
+	r.notifyWebhooks(ctx, r.newWebhookPayload(environment.WebhookEventCreated, env.EnvironmentInfo, time.Now()))
 
 	return env, nil
 }
@@ -260,6 +358,8 @@ func (r *Repository) Get(ctx context.Context, dag *dagger.Client, id string) (*e
 	if env.State.Config != nil && strings.EqualFold(env.State.Config.BaseImage, "host") && env.State.Config.Workdir == "" {
 		env.State.Config.Workdir = worktree
 	}
+	env.SetRepository(r.forkRepoPath)
+	r.watchState(env)
 
 	return env, nil
 }
@@ -364,15 +464,78 @@ func (r *Repository) isDescendantOfCommit(ctx context.Context, ancestorCommit, e
 // Update saves the provided environment to the repository.
 // Writes configuration and source code changes to the worktree and history + state to git notes.
 func (r *Repository) Update(ctx context.Context, env *environment.Environment, explanation string) error {
-	return r.lockManager.WithLock(ctx, LockTypeGitNotes, func() error {
+	summarize := false
+	err := r.lockManager.WithLock(ctx, LockTypeGitNotes, func() error {
+		env.State.CommandCount += env.Notes.Commands()
+		env.RecordPeakUsage()
+		env.RecordToolCalls()
+		// Mark the environment before saving it so the summarizer only runs once
+		if r.summarizer != nil && r.summarizer.ShouldRun(env.State) {
+			env.State.Summarized = true
+			summarize = true
+		}
 		if err := r.propagateToWorktree(ctx, env, explanation); err != nil {
 			return err
 		}
 		if note := env.Notes.Pop(); note != "" {
-			return r.addGitNote(ctx, env, note)
+			if err := r.addGitNote(ctx, env, note); err != nil {
+				return err
+			}
+		}
+		if activities := env.Notes.PopActivities(); len(activities) > 0 {
+			if err := r.addActivityNote(ctx, env, activities); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	// The summarizer may be slow (e.g. an LLM call): run it without holding the lock
+	if summarize {
+		return r.summarize(ctx, env)
+	}
+	return nil
+}
+
+// summarize runs the summarizer hook and saves the new title and description.
+// Summarizer failures are logged but never fail the update.
+func (r *Repository) summarize(ctx context.Context, env *environment.Environment) error {
+	var log bytes.Buffer
+	if err := r.Log(ctx, env.ID, LogOpts{}, &log); err != nil {
+		slog.WarnContext(ctx, "Failed to load environment log for summarization", "id", env.ID, "err", err)
+	}
+
+	summary, err := r.summarizer.Summarize(ctx, &environment.SummaryInput{
+		ID:          env.ID,
+		Title:       env.State.Title,
+		Description: env.State.Description,
+		Log:         log.String(),
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to summarize environment", "id", env.ID, "err", err)
+		return nil
+	}
+
+	worktree, err := r.WorktreePath(env.ID)
+	if err != nil {
+		return err
+	}
+	return r.lockManager.WithLock(ctx, LockTypeGitNotes, func() error {
+		env.State.Title = summary.Title
+		if summary.Description != "" {
+			env.State.Description = summary.Description
+		}
+		if err := r.mergeStoredState(ctx, env.EnvironmentInfo, worktree); err != nil {
+			return err
+		}
+		if err := r.saveState(ctx, env.EnvironmentInfo); err != nil {
+			return err
+		}
+		return r.propagateGitNotes(ctx, gitNotesStateRef)
+	})
 }
 
 // Delete removes an environment from the repository.
@@ -380,13 +543,30 @@ func (r *Repository) Delete(ctx context.Context, id string) error {
 	if err := r.exists(ctx, id); err != nil {
 		return err
 	}
+	// The state is read before it's gone, for webhooks to know what was deleted. Broken environments are deleted anyway.
+	info, err := r.Info(ctx, id)
+	if err != nil {
+		info = &environment.EnvironmentInfo{ID: id, State: &environment.State{}}
+	}
 
+	if info.State.Pod != nil {
+		if err := info.State.Pod.Delete(ctx); err != nil {
+			slog.WarnContext(ctx, "Failed to delete the pod of the environment", "environment", id, "err", err)
+		}
+	}
 	if err := r.deleteWorktree(id); err != nil {
 		return err
 	}
 	if err := r.deleteLocalRemoteBranch(id); err != nil {
 		return err
 	}
+	if err := r.lockManager.WithLock(ctx, LockTypeMessages, func() error {
+		return r.deleteMailbox(ctx, id)
+	}); err != nil {
+		return err
+	}
+	r.releaseServices(id)
+	r.notifyWebhooks(ctx, r.newWebhookPayload(environment.WebhookEventDeleted, info, time.Now()))
 	return nil
 }
 
@@ -443,7 +623,15 @@ func (r *Repository) Checkout(ctx context.Context, id, branch string) (string, e
 	return branch, err
 }
 
-func (r *Repository) Log(ctx context.Context, id string, patch bool, w io.Writer) error {
+// LogOpts selects how an environment's log is written
+type LogOpts struct {
+	// Patch includes the changes of each commit
+	Patch bool
+	// Color forces colored output, which git otherwise only uses for terminals
+	Color bool
+}
+
+func (r *Repository) Log(ctx context.Context, id string, opts LogOpts, w io.Writer) error {
 	envInfo, err := r.Info(ctx, id)
 	if err != nil {
 		return err
@@ -453,9 +641,13 @@ func (r *Repository) Log(ctx context.Context, id string, patch bool, w io.Writer
 		"log",
 		fmt.Sprintf("--notes=%s", gitNotesLogRef),
 	}
+	if opts.Color {
+		logArgs = append(logArgs, "--color=always")
+	}
 
-	if patch {
-		logArgs = append(logArgs, "--patch")
+	if opts.Patch {
+		// Show dates in the user's timezone rather than the committer's
+		logArgs = append(logArgs, "--patch", "--date=local")
 	} else {
 		logArgs = append(logArgs, "--format=%C(yellow)%h%Creset  %s %Cgreen(%cr)%Creset %+N")
 	}
@@ -470,6 +662,58 @@ func (r *Repository) Log(ctx context.Context, id string, patch bool, w io.Writer
 	return RunInteractiveGitCommand(ctx, r.userRepoPath, w, logArgs...)
 }
 
+// History returns the commits of the environment, oldest first, with the files they changed
+func (r *Repository) History(ctx context.Context, id string) ([]HistoryCommit, error) {
+	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	revisionRange, err := r.revisionRange(ctx, envInfo)
+	if err != nil {
+		return nil, err
+	}
+	// Each commit starts with a record separator, followed by its files
+	out, err := RunGitCommand(ctx, r.userRepoPath, "log", "--reverse", "--name-only", "--format=%x1e%H%x00%s%x00%cI", revisionRange)
+	if err != nil {
+		return nil, err
+	}
+
+	commits := []HistoryCommit{}
+	for record := range strings.SplitSeq(out, "\x1e") {
+		header, files, _ := strings.Cut(record, "\n")
+		fields := strings.Split(header, "\x00")
+		if len(fields) != 3 {
+			continue
+		}
+		commitTime, err := time.Parse(time.RFC3339, fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid date of commit %s: %w", fields[0], err)
+		}
+		commit := HistoryCommit{Hash: fields[0], Subject: fields[1], Time: commitTime}
+		for file := range strings.SplitSeq(files, "\n") {
+			if file = strings.TrimSpace(file); file != "" {
+				commit.Files = append(commit.Files, file)
+			}
+		}
+		commits = append(commits, commit)
+	}
+	return commits, nil
+}
+
+// Annotate links the tool calls of an agent conversation transcript to the history of the environment
+func (r *Repository) Annotate(ctx context.Context, id string, transcript []byte) (*AnnotatedTimeline, error) {
+	messages, err := ParseTranscript(transcript)
+	if err != nil {
+		return nil, err
+	}
+	commits, err := r.History(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return AnnotateHistory(id, commits, messages), nil
+}
+
+// Diff writes the diff of the environment against the branch it was forked from
 func (r *Repository) Diff(ctx context.Context, id string, w io.Writer) error {
 	envInfo, err := r.Info(ctx, id)
 	if err != nil {
@@ -480,7 +724,7 @@ func (r *Repository) Diff(ctx context.Context, id string, w io.Writer) error {
 		"diff",
 	}
 
-	revisionRange, err := r.revisionRange(ctx, envInfo)
+	revisionRange, err := r.forkRevisionRange(ctx, envInfo)
 	if err != nil {
 		return err
 	}
@@ -490,11 +734,84 @@ func (r *Repository) Diff(ctx context.Context, id string, w io.Writer) error {
 	return RunInteractiveGitCommand(ctx, r.userRepoPath, w, diffArgs...)
 }
 
+// DiffOpts selects the part of an environment's diff to return
+type DiffOpts struct {
+	// Paths limits the diff to the given files and directories
+	Paths []string
+	// Stat returns a summary of the changed files instead of the patch
+	Stat bool
+}
+
+// UnifiedDiff returns the unified diff of the environment against the branch it was forked from
+func (r *Repository) UnifiedDiff(ctx context.Context, id string, opts DiffOpts) (string, error) {
+	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	revisionRange, err := r.forkRevisionRange(ctx, envInfo)
+	if err != nil {
+		return "", err
+	}
+
+	diffArgs := []string{"diff", "--no-color", "--no-ext-diff"}
+	if opts.Stat {
+		diffArgs = append(diffArgs, "--stat")
+	}
+	diffArgs = append(diffArgs, revisionRange, "--")
+	diffArgs = append(diffArgs, opts.Paths...)
+
+	return RunGitCommand(ctx, r.userRepoPath, diffArgs...)
+}
+
+// ChangedFiles returns the files the environment added or modified since it was forked, deleted files aside
+func (r *Repository) ChangedFiles(ctx context.Context, id string) ([]string, error) {
+	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	revisionRange, err := r.forkRevisionRange(ctx, envInfo)
+	if err != nil {
+		return nil, err
+	}
+	out, err := RunGitCommand(ctx, r.userRepoPath, "diff", "--name-only", "-z", "--no-renames", "--diff-filter=d", revisionRange)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for file := range strings.SplitSeq(out, "\x00") {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// Tree returns the files of the last commit of the environment, which a fresh environment forked from it starts from
+func (r *Repository) Tree(ctx context.Context, dag *dagger.Client, id string) (*dagger.Directory, error) {
+	if err := r.exists(ctx, id); err != nil {
+		return nil, err
+	}
+	head, err := RunGitCommand(ctx, r.forkRepoPath, "rev-parse", "--verify", id)
+	if err != nil {
+		return nil, err
+	}
+	return dag.
+		Host().
+		Directory(r.forkRepoPath, dagger.HostDirectoryOpts{NoCache: true}).
+		AsGit().
+		Ref(strings.TrimSpace(head)).
+		Tree(dagger.GitRefTreeOpts{DiscardGitDir: true}), nil
+}
+
 func (r *Repository) Merge(ctx context.Context, id string, w io.Writer) error {
 	envInfo, err := r.Info(ctx, id)
 	if err != nil {
 		return err
 	}
+	if err := r.checkChanges(ctx, envInfo.ID, w); err != nil {
+		return err
+	}
 
 	return RunInteractiveGitCommand(ctx, r.userRepoPath, w, "merge", "--no-ff", "--autostash", "-m", "Merge environment "+envInfo.ID, "--", "container-use/"+envInfo.ID)
 }
@@ -504,6 +821,226 @@ func (r *Repository) Apply(ctx context.Context, id string, w io.Writer) error {
 	if err != nil {
 		return err
 	}
+	if err := r.checkChanges(ctx, envInfo.ID, w); err != nil {
+		return err
+	}
 
 	return RunInteractiveGitCommand(ctx, r.userRepoPath, w, "merge", "--autostash", "--squash", "--", "container-use/"+envInfo.ID)
 }
+
+// Rebase replays the commits of the environment on top of the user's current branch, keeping a linear history.
+// The commits are rebased in a temporary worktree, so conflicts leave the user's working tree untouched.
+func (r *Repository) Rebase(ctx context.Context, id string, w io.Writer) error {
+	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := r.checkChanges(ctx, envInfo.ID, w); err != nil {
+		return err
+	}
+
+	branch, err := r.currentUserBranch(ctx)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(branch) == "" {
+		return errors.New("cannot rebase onto a detached HEAD, check out a branch first")
+	}
+	head, err := RunGitCommand(ctx, r.userRepoPath, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.MkdirTemp("", "container-use-rebase-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if _, err := RunGitCommand(ctx, r.userRepoPath, "worktree", "add", "--detach", tmp, containerUseRemote+"/"+envInfo.ID); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := RunGitCommand(context.WithoutCancel(ctx), r.userRepoPath, "worktree", "remove", "--force", tmp); err != nil {
+			slog.ErrorContext(ctx, "Failed to remove rebase worktree", "path", tmp, "err", err)
+		}
+	}()
+
+	// Empty commits only record the environment's history, they are dropped
+	if _, err := RunGitCommand(ctx, tmp, "rebase", "--no-keep-empty", strings.TrimSpace(head)); err != nil {
+		conflicts, diffErr := RunGitCommand(ctx, tmp, "diff", "--name-only", "--diff-filter=U")
+		_, _ = RunGitCommand(ctx, tmp, "rebase", "--abort")
+		if diffErr != nil || strings.TrimSpace(conflicts) == "" {
+			return fmt.Errorf("failed to rebase environment %s: %w", envInfo.ID, err)
+		}
+		return &MergeConflictError{
+			Source: envInfo.ID,
+			Files:  strings.Fields(conflicts),
+		}
+	}
+	rebased, err := RunGitCommand(ctx, tmp, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+
+	return RunInteractiveGitCommand(ctx, r.userRepoPath, w, "merge", "--ff-only", "--autostash", strings.TrimSpace(rebased))
+}
+
+// Patch writes the changes of the environment since it was forked as a patch series, to be applied with git am
+func (r *Repository) Patch(ctx context.Context, id string, w io.Writer) error {
+	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := r.checkChanges(ctx, envInfo.ID, nil); err != nil {
+		return err
+	}
+
+	revisionRange, err := r.forkRevisionRange(ctx, envInfo)
+	if err != nil {
+		return err
+	}
+
+	patch, err := RunGitCommand(ctx, r.userRepoPath, "format-patch", "--stdout", "--no-color", revisionRange)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, patch)
+	return err
+}
+
+// MergeConflictError is returned when the branches of the environments being combined
+// cannot be merged automatically.
+type MergeConflictError struct {
+	Source string
+	Files  []string
+}
+
+func (e *MergeConflictError) Error() string {
+	return fmt.Sprintf("merging environment %s produced conflicts in:\n  %s", e.Source, strings.Join(e.Files, "\n  "))
+}
+
+// Combine creates a new environment whose branch merges the branches of all source environments
+// and whose configuration is the union of their configurations.
+// If into is empty, a random environment ID is generated.
+// Configuration conflicts don't prevent the combination and are returned alongside the environment;
+// branch merge conflicts abort the combination with a *MergeConflictError.
+func (r *Repository) Combine(ctx context.Context, dag *dagger.Client, sources []string, into, title, explanation string) (*environment.Environment, []string, error) {
+	if len(sources) < 2 {
+		return nil, nil, errors.New("at least two environments are required to combine")
+	}
+
+	sourceInfos := make([]*environment.EnvironmentInfo, 0, len(sources))
+	for _, source := range sources {
+		info, err := r.Info(ctx, source)
+		if err != nil {
+			return nil, nil, err
+		}
+		sourceInfos = append(sourceInfos, info)
+	}
+
+	id := into
+	if id == "" {
+		id = petname.Generate(2, "-")
+	}
+	if err := r.exists(ctx, id); err == nil {
+		return nil, nil, fmt.Errorf("environment %q already exists", id)
+	} else if !errors.Is(err, errNotFound) {
+		return nil, nil, err
+	}
+	if title == "" {
+		title = "Combine " + strings.Join(sources, ", ")
+	}
+
+	worktree, err := r.initializeWorktree(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		if err := r.Delete(context.WithoutCancel(ctx), id); err != nil {
+			slog.ErrorContext(ctx, "Failed to clean up combined environment", "id", id, "err", err)
+		}
+	}
+
+	if _, err := RunGitCommand(ctx, worktree, "reset", "--hard", sources[0]); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	for _, source := range sources[1:] {
+		_, err := RunGitCommand(ctx, worktree, "merge", "--no-ff", "-m", fmt.Sprintf("Merge environment %s into %s", source, id), source)
+		if err == nil {
+			continue
+		}
+		conflicts, diffErr := RunGitCommand(ctx, worktree, "diff", "--name-only", "--diff-filter=U")
+		_, _ = RunGitCommand(ctx, worktree, "merge", "--abort")
+		cleanup()
+		if diffErr != nil || strings.TrimSpace(conflicts) == "" {
+			return nil, nil, fmt.Errorf("failed to merge environment %s: %w", source, err)
+		}
+		return nil, nil, &MergeConflictError{
+			Source: source,
+			Files:  strings.Fields(conflicts),
+		}
+	}
+	if err := r.createInitialCommit(ctx, worktree, id, title); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to create initial commit: %w", err)
+	}
+
+	// Legacy states have no config
+	sourceConfig := func(info *environment.EnvironmentInfo) *environment.EnvironmentConfig {
+		if info.State.Config == nil {
+			return environment.DefaultConfig()
+		}
+		return info.State.Config
+	}
+	config := sourceConfig(sourceInfos[0]).Copy()
+	configConflicts := []string{}
+	for _, info := range sourceInfos[1:] {
+		for _, conflict := range config.Union(sourceConfig(info)) {
+			configConflicts = append(configConflicts, fmt.Sprintf("%s: %s", info.ID, conflict))
+		}
+	}
+	if strings.EqualFold(config.BaseImage, "host") {
+		config.Workdir = worktree
+	}
+
+	worktreeHead, err := RunGitCommand(ctx, worktree, "rev-parse", "HEAD")
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	baseSourceDir, err := dag.
+		Host().
+		Directory(r.forkRepoPath, dagger.HostDirectoryOpts{NoCache: true}).
+		AsGit().
+		Ref(strings.TrimSpace(worktreeHead)).
+		Tree(dagger.GitRefTreeOpts{DiscardGitDir: true}).
+		Sync(ctx)
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed loading combined source directory: %w", err)
+	}
+
+	env, err := environment.New(ctx, dag, id, title, config, baseSourceDir)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	env.SetRepository(r.forkRepoPath)
+	r.ClaimServices(id)
+	// The combined changes are diffed against the fork point of the first environment
+	env.State.BaseBranch = sourceInfos[0].State.BaseBranch
+	env.State.BaseCommit = sourceInfos[0].State.BaseCommit
+	for _, conflict := range configConflicts {
+		env.Notes.Add("Config conflict: %s", conflict)
+	}
+
+	if err := r.Update(ctx, env, explanation); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	r.notifyWebhooks(ctx, r.newWebhookPayload(environment.WebhookEventCreated, env.EnvironmentInfo, time.Now()))
+
+	return env, configConflicts, nil
+}
@@ -2,11 +2,14 @@ package repository
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/dagger/container-use/environment"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -66,3 +69,221 @@ func TestRepositoryOpen(t *testing.T) {
 		assert.Equal(t, repo.forkRepoPath, strings.TrimSpace(remote))
 	})
 }
+
+// TestRepositorySummarize tests that the summarizer hook runs once enough commands were recorded
+func TestRepositorySummarize(t *testing.T) {
+	ctx := context.Background()
+	repo := setupTestRepository(t)
+
+	runs := filepath.Join(t.TempDir(), "runs")
+	repo.summarizer = &environment.Summarizer{
+		Command: fmt.Sprintf("cat > /dev/null; echo run >> %s; echo 'Add login page'; echo 'With OAuth'", runs),
+		After:   2,
+	}
+
+	worktree, err := repo.initializeWorktree(ctx, "env-a")
+	require.NoError(t, err)
+	require.NoError(t, repo.createInitialCommit(ctx, worktree, "env-a", "work"))
+
+	// Host mode doesn't need a dagger client to be propagated
+	env := &environment.Environment{
+		EnvironmentInfo: &environment.EnvironmentInfo{
+			ID: "env-a",
+			State: &environment.State{
+				Title:  "work",
+				Config: &environment.EnvironmentConfig{BaseImage: "host", Workdir: worktree},
+			},
+		},
+	}
+
+	env.Notes.AddCommand("ls", 0, "README.md", "")
+	require.NoError(t, repo.Update(ctx, env, "first command"))
+	assert.Equal(t, 1, env.State.CommandCount)
+	assert.False(t, env.State.Summarized)
+	assert.Equal(t, "work", env.State.Title)
+
+	env.Notes.AddCommand("go test ./...", 0, "ok", "")
+	env.Notes.Add("not a command")
+	require.NoError(t, repo.Update(ctx, env, "second command"))
+	assert.Equal(t, 2, env.State.CommandCount)
+	assert.True(t, env.State.Summarized)
+	assert.Equal(t, "Add login page", env.State.Title)
+	assert.Equal(t, "With OAuth", env.State.Description)
+
+	env.Notes.AddCommand("go build ./...", 0, "", "")
+	require.NoError(t, repo.Update(ctx, env, "third command"))
+	assert.Equal(t, 3, env.State.CommandCount)
+
+	out, err := os.ReadFile(runs)
+	require.NoError(t, err)
+	assert.Equal(t, "run\n", string(out), "the summarizer should only run once")
+
+	// The summary is persisted
+	info, err := repo.Info(ctx, "env-a")
+	require.NoError(t, err)
+	assert.Equal(t, "Add login page", info.State.Title)
+	assert.True(t, info.State.Summarized)
+}
+
+// TestRepositoryUnifiedDiff tests that environments are diffed against the branch they were forked from
+func TestRepositoryUnifiedDiff(t *testing.T) {
+	ctx := context.Background()
+	repo := setupTestRepository(t)
+
+	env, worktree := createHostEnvironment(t, repo, "env-a")
+	branch, err := repo.currentUserBranch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, strings.TrimSpace(branch), env.State.BaseBranch)
+	assert.NotEmpty(t, env.State.BaseCommit)
+
+	writeFile(t, worktree, "main.go", "package main\n")
+	writeFile(t, worktree, "docs/guide.md", "# Guide\n")
+	require.NoError(t, repo.Update(ctx, env, "add files"))
+
+	// The changes made on the user's branches after the fork aren't part of the environment's diff
+	commitUserFile(t, repo, "CHANGELOG.md")
+	_, err = RunGitCommand(ctx, repo.userRepoPath, "checkout", "-b", "other")
+	require.NoError(t, err)
+	commitUserFile(t, repo, "OTHER.md")
+
+	diff, err := repo.UnifiedDiff(ctx, "env-a", DiffOpts{})
+	require.NoError(t, err)
+	assert.Contains(t, diff, "+++ b/main.go")
+	assert.Contains(t, diff, "+++ b/docs/guide.md")
+	assert.NotContains(t, diff, "CHANGELOG.md")
+	assert.NotContains(t, diff, "OTHER.md")
+
+	diff, err = repo.UnifiedDiff(ctx, "env-a", DiffOpts{Paths: []string{"docs"}})
+	require.NoError(t, err)
+	assert.Contains(t, diff, "+++ b/docs/guide.md")
+	assert.NotContains(t, diff, "main.go")
+
+	diff, err = repo.UnifiedDiff(ctx, "env-a", DiffOpts{Stat: true})
+	require.NoError(t, err)
+	assert.Contains(t, diff, "main.go")
+	assert.NotContains(t, diff, "+package main")
+}
+
+func TestRepositoryChangedFiles(t *testing.T) {
+	ctx := context.Background()
+	repo := setupTestRepository(t)
+
+	env, worktree := createHostEnvironment(t, repo, "env-a")
+	writeFile(t, worktree, "cmd/main file.go", "package main\n")
+	require.NoError(t, os.Remove(filepath.Join(worktree, "README.md")))
+	require.NoError(t, repo.Update(ctx, env, "change files"))
+	commitUserFile(t, repo, "CHANGELOG.md")
+
+	files, err := repo.ChangedFiles(ctx, "env-a")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cmd/main file.go"}, files, "deleted files and the changes of the user's branch are left out")
+}
+
+// TestRepositoryRebase tests that rebased environments keep the history linear and the user's changes
+func TestRepositoryRebase(t *testing.T) {
+	ctx := context.Background()
+	repo := setupTestRepository(t)
+
+	env, worktree := createHostEnvironment(t, repo, "env-a")
+	writeFile(t, worktree, "main.go", "package main\n")
+	require.NoError(t, repo.Update(ctx, env, "Add main"))
+
+	commitUserFile(t, repo, "CHANGELOG.md")
+	writeFile(t, repo.userRepoPath, "README.md", "# Work in progress")
+
+	patch := &strings.Builder{}
+	require.NoError(t, repo.Patch(ctx, "env-a", patch))
+	assert.Contains(t, patch.String(), "Subject: [PATCH")
+	assert.Contains(t, patch.String(), "+++ b/main.go")
+	assert.NotContains(t, patch.String(), "CHANGELOG.md")
+
+	require.NoError(t, repo.Rebase(ctx, "env-a", io.Discard))
+	log, err := RunGitCommand(ctx, repo.userRepoPath, "log", "--format=%s")
+	require.NoError(t, err)
+	assert.Equal(t, "Add main\nAdd CHANGELOG.md\nInitial commit\n", log, "empty commits are dropped")
+	assert.FileExists(t, filepath.Join(repo.userRepoPath, "main.go"))
+	readme, err := os.ReadFile(filepath.Join(repo.userRepoPath, "README.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "# Work in progress", string(readme), "uncommitted changes are restored")
+
+	t.Run("conflict", func(t *testing.T) {
+		env, worktree := createHostEnvironment(t, repo, "env-b")
+		writeFile(t, worktree, "CHANGELOG.md", "from env-b\n")
+		require.NoError(t, repo.Update(ctx, env, "Update changelog"))
+		writeFile(t, repo.userRepoPath, "CHANGELOG.md", "from the user\n")
+		_, err := RunGitCommand(ctx, repo.userRepoPath, "commit", "-m", "Update changelog", "CHANGELOG.md")
+		require.NoError(t, err)
+
+		var conflictErr *MergeConflictError
+		require.ErrorAs(t, repo.Rebase(ctx, "env-b", io.Discard), &conflictErr)
+		assert.Equal(t, []string{"CHANGELOG.md"}, conflictErr.Files)
+		status, err := RunGitCommand(ctx, repo.userRepoPath, "status", "--porcelain")
+		require.NoError(t, err)
+		assert.Equal(t, " M README.md\n", status, "conflicts leave the user's working tree untouched")
+	})
+}
+
+// createHostEnvironment forks a host environment from the user's current branch
+func createHostEnvironment(t *testing.T, repo *Repository, id string) (*environment.Environment, string) {
+	ctx := context.Background()
+	worktree, err := repo.initializeWorktree(ctx, id)
+	require.NoError(t, err)
+	require.NoError(t, repo.createInitialCommit(ctx, worktree, id, "work"))
+
+	// Host mode doesn't need a dagger client to be propagated
+	env := &environment.Environment{
+		EnvironmentInfo: &environment.EnvironmentInfo{
+			ID: id,
+			State: &environment.State{
+				Config: &environment.EnvironmentConfig{BaseImage: "host", Workdir: worktree},
+			},
+		},
+	}
+	require.NoError(t, repo.recordForkPoint(ctx, env, worktree))
+	return env, worktree
+}
+
+func commitUserFile(t *testing.T, repo *Repository, name string) {
+	t.Helper()
+	ctx := context.Background()
+	writeFile(t, repo.userRepoPath, name, "content\n")
+	_, err := RunGitCommand(ctx, repo.userRepoPath, "add", name)
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, repo.userRepoPath, "commit", "-m", "Add "+name)
+	require.NoError(t, err)
+}
+
+// setupTestRepository initializes a git repository with a single commit and opens it
+// with an isolated base path for container-use data
+func setupTestRepository(t *testing.T) *Repository {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	configDir := t.TempDir() // Separate dir for container-use config
+
+	// Initialize a git repo
+	_, err := RunGitCommand(ctx, tempDir, "init")
+	require.NoError(t, err)
+
+	// Set git config
+	_, err = RunGitCommand(ctx, tempDir, "config", "user.email", "test@example.com")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "config", "user.name", "Test User")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "config", "commit.gpgsign", "false")
+	require.NoError(t, err)
+
+	// Make initial commit
+	testFile := filepath.Join(tempDir, "README.md")
+	err = os.WriteFile(testFile, []byte("# Test"), 0644)
+	require.NoError(t, err)
+
+	_, err = RunGitCommand(ctx, tempDir, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "commit", "-m", "Initial commit")
+	require.NoError(t, err)
+
+	// Open repository with isolated base path
+	repo, err := OpenWithBasePath(ctx, tempDir, configDir)
+	require.NoError(t, err)
+	return repo
+}
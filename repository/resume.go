@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gofrs/flock"
+)
+
+// servicesClaims are the locks of the environments whose services this process runs, by lock file.
+// They are held until the process exits, releasing them for a restarted server to resume the environments.
+var (
+	servicesClaimsMu sync.Mutex
+	servicesClaims   = map[string]*flock.Flock{}
+)
+
+// servicesLockPath is the lock file held by the server running the services of an environment
+func (r *Repository) servicesLockPath(id string) string {
+	lockFileName := fmt.Sprintf("container-use-%x-services-%s.lock", hashString(r.forkRepoPath), id)
+	return filepath.Join(os.TempDir(), "container-use-locks", lockFileName)
+}
+
+// ClaimServices puts this process in charge of running the services of an environment, for as long as it runs.
+// It tells whether the environment was unclaimed: no running server runs its services, so they must be resumed
+// (see environment.Resume). It returns false once this process claimed the environment, or while another process
+// has it claimed.
+func (r *Repository) ClaimServices(id string) (bool, error) {
+	path := r.servicesLockPath(id)
+	servicesClaimsMu.Lock()
+	defer servicesClaimsMu.Unlock()
+
+	if _, ok := servicesClaims[path]; ok {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	lock := flock.New(path)
+	locked, err := lock.TryLock()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim the services of environment %s: %w", id, err)
+	}
+	if !locked {
+		return false, nil
+	}
+	servicesClaims[path] = lock
+	return true, nil
+}
+
+// releaseServices releases the claim of this process on the services of a deleted environment
+func (r *Repository) releaseServices(id string) {
+	path := r.servicesLockPath(id)
+	servicesClaimsMu.Lock()
+	defer servicesClaimsMu.Unlock()
+
+	if lock, ok := servicesClaims[path]; ok {
+		lock.Unlock()
+		delete(servicesClaims, path)
+		os.Remove(path)
+	}
+}
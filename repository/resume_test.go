@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/gofrs/flock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaimServices(t *testing.T) {
+	repo := &Repository{forkRepoPath: t.TempDir()}
+
+	claimed, err := repo.ClaimServices("env-a")
+	require.NoError(t, err)
+	assert.True(t, claimed)
+	claimed, err = repo.ClaimServices("env-a")
+	require.NoError(t, err)
+	assert.False(t, claimed, "environments are claimed once")
+
+	// Another server holding the claim runs the services
+	other := flock.New(repo.servicesLockPath("env-b"))
+	locked, err := other.TryLock()
+	require.NoError(t, err)
+	require.True(t, locked)
+	claimed, err = repo.ClaimServices("env-b")
+	require.NoError(t, err)
+	assert.False(t, claimed, "environments claimed by other servers aren't resumed")
+
+	// Once it stopped, its environments are resumed
+	require.NoError(t, other.Unlock())
+	claimed, err = repo.ClaimServices("env-b")
+	require.NoError(t, err)
+	assert.True(t, claimed)
+
+	repo.releaseServices("env-a")
+	assert.NoFileExists(t, repo.servicesLockPath("env-a"))
+	claimed, err = repo.ClaimServices("env-a")
+	require.NoError(t, err)
+	assert.True(t, claimed)
+	repo.releaseServices("env-a")
+	repo.releaseServices("env-b")
+}
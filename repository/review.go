@@ -0,0 +1,245 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+)
+
+// ReviewChunk is a single hunk of an environment's diff against the user's current branch.
+// Chunk IDs only depend on the file and the hunk contents, so they remain stable
+// across reviews as long as the hunk itself doesn't change.
+type ReviewChunk struct {
+	ID       string                      `json:"id"`
+	File     string                      `json:"file"`
+	Header   string                      `json:"header,omitempty"`
+	Patch    string                      `json:"patch"`
+	Comments []environment.ReviewComment `json:"comments,omitempty"`
+}
+
+// Review is the diff of an environment split in chunks, along with the review comments attached to them.
+type Review struct {
+	Chunks []*ReviewChunk `json:"chunks"`
+	// OutdatedComments are comments attached to chunks that are no longer part of the diff.
+	OutdatedComments []environment.ReviewComment `json:"outdated_comments,omitempty"`
+	// Policy are the changes to look at according to the repository governance
+	Policy []PolicyViolation `json:"policy,omitempty"`
+}
+
+// Review returns the diff of the environment chunked per file and hunk, with review comments attached.
+func (r *Repository) Review(ctx context.Context, id string) (*Review, error) {
+	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	revisionRange, err := r.revisionRange(ctx, envInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	diff, err := RunGitCommand(ctx, r.userRepoPath, "diff", "--no-color", "--no-ext-diff", revisionRange)
+	if err != nil {
+		return nil, err
+	}
+
+	review := &Review{
+		Chunks: parseDiffChunks(diff),
+	}
+	known := map[string]bool{}
+	for _, chunk := range review.Chunks {
+		chunk.Comments = envInfo.State.CommentsFor(chunk.ID)
+		known[chunk.ID] = true
+	}
+	for _, comment := range envInfo.State.ReviewComments {
+		if !known[comment.ChunkID] {
+			review.OutdatedComments = append(review.OutdatedComments, comment)
+		}
+	}
+
+	review.Policy, err = r.CheckPolicies(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check policies: %w", err)
+	}
+
+	return review, nil
+}
+
+// AddReviewComment attaches a comment to a chunk of the environment's diff and saves it in the environment state.
+func (r *Repository) AddReviewComment(ctx context.Context, id, chunkID, author, body string) (*environment.ReviewComment, error) {
+	if strings.TrimSpace(body) == "" {
+		return nil, fmt.Errorf("comment cannot be empty")
+	}
+
+	review, err := r.Review(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var chunk *ReviewChunk
+	for _, c := range review.Chunks {
+		if c.ID == chunkID {
+			chunk = c
+			break
+		}
+	}
+	if chunk == nil {
+		return nil, fmt.Errorf("chunk %q not found in the diff of environment %s", chunkID, id)
+	}
+
+	worktree, err := r.WorktreePath(id)
+	if err != nil {
+		return nil, err
+	}
+	comment := environment.ReviewComment{
+		ChunkID:   chunk.ID,
+		File:      chunk.File,
+		Author:    author,
+		Body:      body,
+		CreatedAt: time.Now(),
+	}
+
+	// Reload the state under the lock so concurrent comments and updates don't overwrite each other
+	err = r.lockManager.WithLock(ctx, LockTypeGitNotes, func() error {
+		state, err := r.readState(ctx, worktree)
+		if err != nil {
+			return err
+		}
+		envInfo, err := environment.LoadInfo(ctx, id, state, worktree)
+		if err != nil {
+			return err
+		}
+		envInfo.State.ReviewComments = append(envInfo.State.ReviewComments, comment)
+		if err := r.saveState(ctx, envInfo); err != nil {
+			return err
+		}
+		return r.propagateGitNotes(ctx, gitNotesStateRef)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save review comment: %w", err)
+	}
+
+	return &comment, nil
+}
+
+// mergeStoredState adds the changes users saved since the environment was loaded to its state:
+// review comments, and the budget they extended.
+// Callers must hold the LockTypeGitNotes lock.
+func (r *Repository) mergeStoredState(ctx context.Context, env *environment.EnvironmentInfo, worktree string) error {
+	state, err := r.readState(ctx, worktree)
+	if err != nil || state == nil {
+		return err
+	}
+	stored := &environment.State{}
+	if err := stored.Unmarshal(state); err != nil {
+		return err
+	}
+	env.State.MergeReviewComments(stored.ReviewComments)
+	if stored.Budget != nil {
+		env.State.Budget = stored.Budget
+	}
+	return nil
+}
+
+// parseDiffChunks splits a unified git diff into one chunk per hunk.
+// Files without hunks (binary files, mode changes, pure renames) produce a single chunk.
+func parseDiffChunks(diff string) []*ReviewChunk {
+	chunks := []*ReviewChunk{}
+
+	for _, section := range splitDiffFiles(diff) {
+		lines := strings.Split(strings.TrimRight(section, "\n"), "\n")
+		file := diffFileName(lines)
+
+		headerEnd := len(lines)
+		for i, line := range lines {
+			if strings.HasPrefix(line, "@@") {
+				headerEnd = i
+				break
+			}
+		}
+		if headerEnd == len(lines) {
+			patch := strings.Join(lines, "\n")
+			chunks = append(chunks, &ReviewChunk{
+				ID:    chunkID(file, patch, 0),
+				File:  file,
+				Patch: patch,
+			})
+			continue
+		}
+
+		seen := map[string]int{}
+		var hunk []string
+		flush := func() {
+			if len(hunk) == 0 {
+				return
+			}
+			body := strings.Join(hunk[1:], "\n")
+			chunks = append(chunks, &ReviewChunk{
+				ID:     chunkID(file, body, seen[body]),
+				File:   file,
+				Header: hunk[0],
+				Patch:  strings.Join(hunk, "\n"),
+			})
+			seen[body]++
+			hunk = nil
+		}
+		for _, line := range lines[headerEnd:] {
+			if strings.HasPrefix(line, "@@") {
+				flush()
+			}
+			hunk = append(hunk, line)
+		}
+		flush()
+	}
+
+	return chunks
+}
+
+func splitDiffFiles(diff string) []string {
+	sections := []string{}
+	var current strings.Builder
+	for line := range strings.Lines(diff) {
+		if strings.HasPrefix(line, "diff --git ") && current.Len() > 0 {
+			sections = append(sections, current.String())
+			current.Reset()
+		}
+		current.WriteString(line)
+	}
+	if strings.TrimSpace(current.String()) != "" {
+		sections = append(sections, current.String())
+	}
+	return sections
+}
+
+func diffFileName(lines []string) string {
+	var oldName string
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			if name := strings.TrimPrefix(line, "+++ "); name != "/dev/null" {
+				return strings.TrimPrefix(name, "b/")
+			}
+			return oldName
+		case strings.HasPrefix(line, "--- "):
+			oldName = strings.TrimPrefix(strings.TrimPrefix(line, "--- "), "a/")
+		case strings.HasPrefix(line, "@@"):
+			return oldName
+		}
+	}
+	// No ---/+++ header (binary files, mode changes): fall back to `diff --git a/<file> b/<file>`
+	if len(lines) > 0 {
+		if _, after, found := strings.Cut(lines[0], " b/"); found {
+			return after
+		}
+	}
+	return oldName
+}
+
+// chunkID creates a stable ID for a chunk from its file, contents and occurrence index
+func chunkID(file, body string, index int) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s:%s:%d", file, body, index)))
+	return fmt.Sprintf("%x", hash)[:8]
+}
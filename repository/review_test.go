@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const reviewTestDiff = `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -1,3 +1,4 @@
+ package main
++
+ import "fmt"
+@@ -10,2 +11,2 @@ func main() {
+-	fmt.Println("hello")
++	fmt.Println("hello, world")
+diff --git a/old.txt b/old.txt
+deleted file mode 100644
+index 3333333..0000000
+--- a/old.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-bye
+diff --git a/logo.png b/logo.png
+new file mode 100644
+index 0000000..4444444
+Binary files /dev/null and b/logo.png differ
+`
+
+func TestParseDiffChunks(t *testing.T) {
+	chunks := parseDiffChunks(reviewTestDiff)
+	require.Len(t, chunks, 4)
+
+	assert.Equal(t, "main.go", chunks[0].File)
+	assert.Equal(t, "@@ -1,3 +1,4 @@", chunks[0].Header)
+	assert.Equal(t, "main.go", chunks[1].File)
+	assert.Contains(t, chunks[1].Patch, `+	fmt.Println("hello, world")`)
+	assert.Equal(t, "old.txt", chunks[2].File)
+	assert.Equal(t, "logo.png", chunks[3].File)
+	assert.Empty(t, chunks[3].Header)
+
+	ids := map[string]bool{}
+	for _, chunk := range chunks {
+		assert.Len(t, chunk.ID, 8)
+		ids[chunk.ID] = true
+	}
+	assert.Len(t, ids, 4, "chunk IDs should be unique")
+
+	t.Run("stable_ids_when_line_numbers_move", func(t *testing.T) {
+		shifted := parseDiffChunks(`diff --git a/main.go b/main.go
+--- a/main.go
++++ b/main.go
+@@ -20,2 +21,2 @@ func main() {
+-	fmt.Println("hello")
++	fmt.Println("hello, world")
+`)
+		require.Len(t, shifted, 1)
+		assert.Equal(t, chunks[1].ID, shifted[0].ID)
+	})
+
+	t.Run("empty_diff", func(t *testing.T) {
+		assert.Empty(t, parseDiffChunks(""))
+	})
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"dagger.io/dagger"
+	"github.com/dagger/container-use/environment"
+)
+
+// schedulerSender is the sender of the failure notifications of scheduled commands
+const schedulerSender = "scheduler"
+
+// ScheduledRun is a run of a scheduled command performed by RunDueSchedules
+type ScheduledRun struct {
+	EnvironmentID string `json:"environment_id"`
+	ScheduleID    string `json:"schedule_id"`
+	Command       string `json:"command"`
+	*environment.ScheduleRun
+}
+
+// RunDueSchedules runs the scheduled commands of every environment that are due at the given time.
+// Each run is recorded in the environment history. When a command fails, a notification is
+// delivered to the environment mailbox so the agent working in it sees it with environment_receive.
+func (r *Repository) RunDueSchedules(ctx context.Context, dag *dagger.Client, now time.Time) ([]*ScheduledRun, error) {
+	envs, err := r.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := []*ScheduledRun{}
+	for _, info := range envs {
+		due := false
+		for _, s := range info.State.Schedules {
+			due = due || s.Due(now)
+		}
+		if !due {
+			continue
+		}
+
+		env, err := r.Get(ctx, dag, info.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to open environment for scheduled commands", "id", info.ID, "err", err)
+			continue
+		}
+		for _, schedule := range env.DueSchedules(now) {
+			run, err := env.RunSchedule(ctx, schedule.ID)
+			if err != nil {
+				return runs, err
+			}
+			runs = append(runs, &ScheduledRun{
+				EnvironmentID: env.ID,
+				ScheduleID:    schedule.ID,
+				Command:       schedule.Command,
+				ScheduleRun:   run,
+			})
+
+			// We want to update the repository even if the command failed.
+			if err := r.Update(ctx, env, fmt.Sprintf("Scheduled: %s", schedule.Command)); err != nil {
+				return runs, fmt.Errorf("failed to update env %s: %w", env.ID, err)
+			}
+			if run.Failed() {
+				if err := r.notifyScheduleFailure(ctx, env.ID, schedule, run); err != nil {
+					slog.ErrorContext(ctx, "Failed to notify scheduled command failure", "id", env.ID, "schedule", schedule.ID, "err", err)
+				}
+			}
+		}
+	}
+	return runs, nil
+}
+
+func (r *Repository) notifyScheduleFailure(ctx context.Context, id string, schedule *environment.Schedule, run *environment.ScheduleRun) error {
+	body := fmt.Sprintf("Scheduled command %s (%s) failed with exit code %d:\n$ %s\n", schedule.ID, schedule.Cron, run.ExitCode, schedule.Command)
+	if run.Error != "" {
+		body += run.Error + "\n"
+	}
+	if run.Output != "" {
+		body += "\n" + run.Output
+	}
+	_, err := r.deliver(ctx, schedulerSender, []string{id}, "schedule-failed", body)
+	return err
+}
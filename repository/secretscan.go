@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/dagger/container-use/environment"
+)
+
+// ScanSecrets returns the secrets found in the lines added by the environment since it was forked
+func (r *Repository) ScanSecrets(ctx context.Context, id string) ([]environment.SecretFinding, error) {
+	diff, err := r.UnifiedDiff(ctx, id, DiffOpts{})
+	if err != nil {
+		return nil, err
+	}
+	return environment.ScanDiffSecrets(diff), nil
+}
+
+// checkSecrets scans the changes of an environment before they leave it, according to the secret scan mode of the
+// user's configuration: agents can't turn it off. Warnings are written to w, or logged if w is nil.
+func (r *Repository) checkSecrets(ctx context.Context, id string, w io.Writer) error {
+	config := environment.DefaultConfig()
+	if err := config.Load(r.userRepoPath); err != nil {
+		return err
+	}
+	if config.SecretScan == environment.SecretScanOff {
+		return nil
+	}
+	findings, err := r.ScanSecrets(ctx, id)
+	if err != nil {
+		return err
+	}
+	if len(findings) == 0 {
+		return nil
+	}
+	if config.SecretScan == environment.SecretScanBlock {
+		return &environment.SecretsFoundError{Findings: findings}
+	}
+	if w == nil {
+		slog.WarnContext(ctx, "Possible secrets in the changes of the environment", "environment.id", id, "findings", findings)
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "Warning: possible secrets in the changes of environment %s:\n%s\n", id, environment.FormatSecretFindings(findings))
+	return err
+}
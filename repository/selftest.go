@@ -0,0 +1,262 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+	petname "github.com/dustinkirkland/golang-petname"
+	"github.com/gofrs/flock"
+)
+
+// SelfTestOpts are the options of SelfTest
+type SelfTestOpts struct {
+	// Concurrency is the number of workers running at the same time, like agents sharing a repository
+	Concurrency int
+	// Iterations is the number of environments each worker creates, changes and deletes
+	Iterations int
+}
+
+// SelfTestResult reports what SelfTest exercised and the invariants it found broken
+type SelfTestResult struct {
+	Workers      int           `json:"workers"`
+	Environments int           `json:"environments"`
+	Duration     time.Duration `json:"duration"`
+	// Violations are the failed operations and broken invariants. None means the setup supports concurrent agents.
+	Violations []string `json:"violations"`
+}
+
+// selfTest records the environments created by the workers and what went wrong
+type selfTest struct {
+	repo *Repository
+
+	mu         sync.Mutex
+	owners     map[string]int
+	violations []string
+}
+
+func (t *selfTest) violation(format string, args ...any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.violations = append(t.violations, fmt.Sprintf(format, args...))
+}
+
+// SelfTest creates a temporary repository in dir and runs the workers against it, each creating host environments,
+// running a command, writing a file and deleting them. It then checks the invariants concurrent agents rely on:
+// environment IDs are unique, every lock is released and the git state of the repository is consistent.
+// Errors are only returned when the temporary repository can't be set up.
+func SelfTest(ctx context.Context, dir string, opts SelfTestOpts) (*SelfTestResult, error) {
+	if opts.Concurrency < 1 {
+		return nil, fmt.Errorf("invalid concurrency %d: at least one worker is needed", opts.Concurrency)
+	}
+	if opts.Iterations < 1 {
+		opts.Iterations = 1
+	}
+
+	repoPath := filepath.Join(dir, "repo")
+	if err := initSelfTestRepository(ctx, repoPath); err != nil {
+		return nil, fmt.Errorf("failed to create the self-test repository: %w", err)
+	}
+	repo, err := OpenWithBasePath(ctx, repoPath, filepath.Join(dir, "config"))
+	if err != nil {
+		return nil, err
+	}
+
+	t := &selfTest{repo: repo, owners: map[string]int{}}
+	start := time.Now()
+	var wg sync.WaitGroup
+	for worker := range opts.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for iteration := range opts.Iterations {
+				if ctx.Err() != nil {
+					return
+				}
+				if err := t.exercise(ctx, worker, iteration); err != nil {
+					t.violation("worker %d: %v", worker, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	duration := time.Since(start)
+
+	t.checkLocks()
+	t.checkGitState(ctx)
+
+	return &SelfTestResult{
+		Workers:      opts.Concurrency,
+		Environments: len(t.owners),
+		Duration:     duration,
+		Violations:   append([]string{}, t.violations...),
+	}, nil
+}
+
+// initSelfTestRepository initializes a git repository with a single commit
+func initSelfTestRepository(ctx context.Context, path string) error {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	for _, args := range [][]string{
+		{"init"},
+		{"config", "user.email", "selftest@container-use.local"},
+		{"config", "user.name", "container-use selftest"},
+		{"config", "commit.gpgsign", "false"},
+	} {
+		if _, err := RunGitCommand(ctx, path, args...); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(filepath.Join(path, "README.md"), []byte("# Self-test\n"), 0644); err != nil {
+		return err
+	}
+	if _, err := RunGitCommand(ctx, path, "add", "README.md"); err != nil {
+		return err
+	}
+	_, err := RunGitCommand(ctx, path, "commit", "-m", "Initial commit")
+	return err
+}
+
+// exercise runs the flow of an agent: create an environment, run a command, write a file and delete the environment
+func (t *selfTest) exercise(ctx context.Context, worker, iteration int) error {
+	env, err := t.create(ctx, fmt.Sprintf("Self-test worker %d #%d", worker, iteration))
+	if err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+
+	t.mu.Lock()
+	owner, duplicate := t.owners[env.ID]
+	t.owners[env.ID] = worker
+	t.mu.Unlock()
+	if duplicate {
+		t.violation("environment ID %s was given to workers %d and %d", env.ID, owner, worker)
+	}
+
+	if _, _, err := env.Run(ctx, "echo selftest", "sh", false); err != nil {
+		return fmt.Errorf("run in %s: %w", env.ID, err)
+	}
+	file := fmt.Sprintf("worker-%d.txt", worker)
+	contents := fmt.Sprintf("written by worker %d in %s\n", worker, env.ID)
+	if err := env.FileWrite(ctx, "Write self-test file", file, contents); err != nil {
+		return fmt.Errorf("write in %s: %w", env.ID, err)
+	}
+	if err := t.repo.Update(ctx, env, "Write self-test file"); err != nil {
+		return fmt.Errorf("update %s: %w", env.ID, err)
+	}
+
+	// What was saved is what the worker wrote, whatever the other workers did meanwhile
+	info, err := t.repo.Info(ctx, env.ID)
+	if err != nil {
+		return fmt.Errorf("info of %s: %w", env.ID, err)
+	}
+	if info.State.BaseCommit != env.State.BaseCommit {
+		t.violation("environment %s is forked from %s instead of %s", env.ID, info.State.BaseCommit, env.State.BaseCommit)
+	}
+	saved, err := RunGitCommand(ctx, t.repo.forkRepoPath, "show", env.ID+":"+file)
+	if err != nil {
+		t.violation("environment %s is missing %s: %v", env.ID, file, err)
+	} else if saved != contents {
+		t.violation("environment %s has %q in %s instead of %q", env.ID, saved, file, contents)
+	}
+
+	if err := t.repo.Delete(ctx, env.ID); err != nil {
+		return fmt.Errorf("delete %s: %w", env.ID, err)
+	}
+	return nil
+}
+
+// create creates a host environment like Create, without a dagger client
+func (t *selfTest) create(ctx context.Context, title string) (*environment.Environment, error) {
+	r := t.repo
+	id := petname.Generate(2, "-")
+	worktree, err := r.initializeWorktree(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.createInitialCommit(ctx, worktree, id, title); err != nil {
+		return nil, fmt.Errorf("failed to create initial commit: %w", err)
+	}
+
+	config := environment.DefaultConfig()
+	config.BaseImage = "host"
+	config.Workdir = worktree
+	env, err := environment.New(ctx, nil, id, title, config, nil)
+	if err != nil {
+		return nil, err
+	}
+	env.SetRepository(r.forkRepoPath)
+	r.watchState(env)
+	if err := r.recordForkPoint(ctx, env, worktree); err != nil {
+		return nil, err
+	}
+	if err := r.lockManager.WithLock(ctx, LockTypeGitNotes, func() error {
+		return r.propagateToWorktree(ctx, env, "Create self-test environment")
+	}); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// checkLocks checks no lock is left held once the workers are done
+func (t *selfTest) checkLocks() {
+	for _, lockType := range []LockType{LockTypeRepo, LockTypeWorktree, LockTypeGitNotes, LockTypeMessages, LockTypeApprovals} {
+		// Locks are held by open files: another one can only be locked if no worker kept its own
+		probe := flock.New(t.repo.lockManager.GetLock(lockType).flock.Path())
+		locked, err := probe.TryLock()
+		if err != nil {
+			t.violation("checking the %s lock: %v", lockType, err)
+			continue
+		}
+		if !locked {
+			t.violation("the %s lock is still held", lockType)
+			continue
+		}
+		probe.Unlock()
+	}
+}
+
+// checkGitState checks the deleted environments left nothing behind and the repositories aren't corrupted
+func (t *selfTest) checkGitState(ctx context.Context) {
+	r := t.repo
+	for _, path := range []string{r.userRepoPath, r.forkRepoPath} {
+		if _, err := RunGitCommand(ctx, path, "fsck", "--no-progress", "--no-dangling"); err != nil {
+			t.violation("git fsck of %s failed: %v", path, err)
+		}
+	}
+
+	if branches, err := RunGitCommand(ctx, r.forkRepoPath, "branch", "--format", "%(refname:short)"); err != nil {
+		t.violation("listing the branches of environments: %v", err)
+	} else if branches := strings.Fields(branches); len(branches) > 0 {
+		t.violation("branches of deleted environments are left: %s", strings.Join(branches, ", "))
+	}
+	if refs, err := RunGitCommand(ctx, r.userRepoPath, "for-each-ref", "--format", "%(refname:short)", "refs/remotes/"+containerUseRemote); err != nil {
+		t.violation("listing the remote branches of environments: %v", err)
+	} else if refs := strings.Fields(refs); len(refs) > 0 {
+		t.violation("remote branches of deleted environments are left: %s", strings.Join(refs, ", "))
+	}
+
+	worktrees, err := os.ReadDir(r.getWorktreePath())
+	if err != nil && !os.IsNotExist(err) {
+		t.violation("listing worktrees: %v", err)
+	}
+	for _, worktree := range worktrees {
+		t.violation("worktree of deleted environment %s is left", worktree.Name())
+	}
+
+	envs, err := r.List(ctx)
+	if err != nil {
+		t.violation("listing environments: %v", err)
+	}
+	for _, env := range envs {
+		t.violation("deleted environment %s is still listed", env.ID)
+	}
+}
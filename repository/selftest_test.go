@@ -0,0 +1,20 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfTest(t *testing.T) {
+	result, err := SelfTest(context.Background(), t.TempDir(), SelfTestOpts{Concurrency: 3, Iterations: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.Workers)
+	assert.Equal(t, 6, result.Environments)
+	assert.Empty(t, result.Violations)
+
+	_, err = SelfTest(context.Background(), t.TempDir(), SelfTestOpts{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dagger/container-use/environment"
+)
+
+// StaleThreshold is the number of commits the default branch must be ahead of an environment for it to be stale
+const StaleThreshold = 20
+
+// Staleness tells how far the default branch of the repository moved on since an environment was forked
+type Staleness struct {
+	// Branch is the default branch, e.g. origin/main
+	Branch string `json:"branch"`
+	// Behind is the number of commits of the branch the environment doesn't have, Ahead the number of commits of
+	// the environment the branch doesn't have
+	Behind int  `json:"behind"`
+	Ahead  int  `json:"ahead"`
+	Stale  bool `json:"stale"`
+	// Suggestion is how to bring a stale environment up to date
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+// Staleness compares an environment with the default branch of the repository
+func (r *Repository) Staleness(ctx context.Context, env *environment.EnvironmentInfo) (*Staleness, error) {
+	branch, err := r.defaultBranch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	envGitRef := fmt.Sprintf("%s/%s", containerUseRemote, env.ID)
+	out, err := RunGitCommand(ctx, r.userRepoPath, "rev-list", "--left-right", "--count", branch+"..."+envGitRef)
+	if err != nil {
+		return nil, err
+	}
+	counts := strings.Fields(out)
+	if len(counts) != 2 {
+		return nil, fmt.Errorf("unexpected output of git rev-list: %q", out)
+	}
+	staleness := &Staleness{Branch: branch}
+	if staleness.Behind, err = strconv.Atoi(counts[0]); err != nil {
+		return nil, err
+	}
+	if staleness.Ahead, err = strconv.Atoi(counts[1]); err != nil {
+		return nil, err
+	}
+	if staleness.Behind >= StaleThreshold {
+		staleness.Stale = true
+		staleness.Suggestion = fmt.Sprintf("%s is %d commits ahead: review the environment, then replay its commits on top of %s with `container-use merge --strategy rebase %s` from %s, or delete it with `container-use delete %s`",
+			branch, staleness.Behind, branch, env.ID, branch, env.ID)
+	}
+	return staleness, nil
+}
+
+// defaultBranch returns the default branch of the repository: the HEAD of origin when known,
+// otherwise the first local branch of init.defaultBranch, main and master.
+func (r *Repository) defaultBranch(ctx context.Context) (string, error) {
+	if ref, err := RunGitCommand(ctx, r.userRepoPath, "symbolic-ref", "--quiet", "--short", "refs/remotes/origin/HEAD"); err == nil {
+		return strings.TrimSpace(ref), nil
+	}
+	candidates := []string{"main", "master"}
+	if configured, err := RunGitCommand(ctx, r.userRepoPath, "config", "init.defaultBranch"); err == nil && strings.TrimSpace(configured) != "" {
+		candidates = append([]string{strings.TrimSpace(configured)}, candidates...)
+	}
+	for _, branch := range candidates {
+		if _, err := RunGitCommand(ctx, r.userRepoPath, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch); err == nil {
+			return branch, nil
+		}
+	}
+	return "", fmt.Errorf("no default branch: origin/HEAD, main and master don't exist")
+}
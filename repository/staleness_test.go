@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryStaleness(t *testing.T) {
+	ctx := context.Background()
+	repo := setupTestRepository(t)
+
+	env, worktree := createHostEnvironment(t, repo, "env-a")
+	writeFile(t, worktree, "main.go", "package main\n")
+	require.NoError(t, repo.Update(ctx, env, "Add main"))
+
+	staleness, err := repo.Staleness(ctx, env.EnvironmentInfo)
+	require.NoError(t, err)
+	assert.Equal(t, 0, staleness.Behind)
+	assert.Positive(t, staleness.Ahead)
+	assert.False(t, staleness.Stale)
+	assert.Empty(t, staleness.Suggestion)
+
+	for i := range StaleThreshold {
+		commitUserFile(t, repo, fmt.Sprintf("file%d.txt", i))
+	}
+	staleness, err = repo.Staleness(ctx, env.EnvironmentInfo)
+	require.NoError(t, err)
+	assert.Equal(t, StaleThreshold, staleness.Behind)
+	assert.True(t, staleness.Stale)
+	assert.Contains(t, staleness.Suggestion, "container-use merge --strategy rebase env-a")
+}
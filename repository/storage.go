@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// StorageDriver is how the files of environment worktrees are stored
+type StorageDriver string
+
+const (
+	// StorageAuto uses reflinks where the platform supports them, and checkouts elsewhere
+	StorageAuto StorageDriver = "auto"
+	// StorageCheckout checks out every file of worktrees from git
+	StorageCheckout StorageDriver = "checkout"
+	// StorageReflink clones the files of the user's checkout into worktrees, sharing their blocks on file systems
+	// supporting it (btrfs, XFS). Files that can't be cloned are checked out.
+	StorageReflink StorageDriver = "reflink"
+)
+
+var storageDriver = StorageAuto
+
+// SetStorageDriver sets how the worktrees of new environments are stored
+func SetStorageDriver(driver string) error {
+	switch d := StorageDriver(driver); d {
+	case StorageAuto, StorageCheckout, StorageReflink:
+		storageDriver = d
+		return nil
+	default:
+		return fmt.Errorf("unknown storage driver %q: expected %s, %s or %s", driver, StorageAuto, StorageCheckout, StorageReflink)
+	}
+}
+
+// addWorktree adds the worktree of a branch of the fork, starting at the user's HEAD
+func addWorktree(ctx context.Context, forkRepoPath, userRepoPath, worktreePath, branch string) error {
+	driver := storageDriver
+	if driver == StorageAuto {
+		driver = StorageCheckout
+		if reflinkSupported {
+			driver = StorageReflink
+		}
+	}
+	if driver == StorageCheckout {
+		_, err := RunGitCommand(ctx, forkRepoPath, "worktree", "add", worktreePath, branch)
+		return err
+	}
+
+	start := time.Now()
+	if _, err := RunGitCommand(ctx, forkRepoPath, "worktree", "add", "--no-checkout", worktreePath, branch); err != nil {
+		return err
+	}
+	cloned, err := cloneTrackedFiles(ctx, userRepoPath, worktreePath)
+	if err != nil {
+		level := slog.LevelInfo
+		if storageDriver == StorageReflink {
+			level = slog.LevelWarn
+		}
+		slog.Log(ctx, level, "Unable to clone files, checking them out", "worktree", worktreePath, "cloned", cloned, "err", err)
+	}
+
+	// Reset indexes the cloned files, hashing them: the ones differing from the branch, changed by the user
+	// or since the push, are then checked out with the ones that weren't cloned.
+	if _, err := RunGitCommand(ctx, worktreePath, "reset", "--quiet"); err != nil {
+		return err
+	}
+	if _, err := RunGitCommand(ctx, worktreePath, "checkout", "--", "."); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Worktree populated", "driver", driver, "cloned", cloned, "duration", time.Since(start))
+	return nil
+}
+
+// cloneTrackedFiles clones the files tracked in a checkout into a worktree, until one can't be cloned.
+// It returns the number of files cloned.
+func cloneTrackedFiles(ctx context.Context, checkoutPath, worktreePath string) (int, error) {
+	out, err := RunGitCommand(ctx, checkoutPath, "ls-files", "-z")
+	if err != nil {
+		return 0, err
+	}
+
+	cloned := 0
+	for _, name := range strings.Split(out, "\x00") {
+		if name == "" {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return cloned, err
+		}
+		src := filepath.Join(checkoutPath, name)
+		dst := filepath.Join(worktreePath, name)
+		info, err := os.Lstat(src)
+		if err != nil {
+			// Deleted by the user
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return cloned, err
+		}
+
+		switch {
+		case info.Mode().IsRegular():
+			if err := cloneFile(src, dst, info.Mode().Perm()); err != nil {
+				return cloned, fmt.Errorf("failed to clone %s: %w", name, err)
+			}
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(src)
+			if err != nil {
+				return cloned, err
+			}
+			if err := os.Symlink(target, dst); err != nil {
+				return cloned, err
+			}
+		default:
+			// Submodules are checked out
+			continue
+		}
+		cloned++
+	}
+	return cloned, nil
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+package repository
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	reflinkSupported = true
+
+	// ficlone is the FICLONE ioctl, sharing the blocks of a file with another on the same file system
+	ficlone = 0x40049409
+)
+
+// cloneFile creates a file sharing the blocks of another. It fails on file systems without reflinks,
+// and across file systems.
+func cloneFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, out.Fd(), ficlone, in.Fd()); errno != 0 {
+		out.Close()
+		os.Remove(dst)
+		return errno
+	}
+	return out.Close()
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package repository
+
+import (
+	"errors"
+	"os"
+)
+
+const reflinkSupported = false
+
+func cloneFile(_, _ string, _ os.FileMode) error {
+	return errors.ErrUnsupported
+}
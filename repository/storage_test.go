@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAddWorktree tests that every storage driver populates worktrees with the files of the user's HEAD
+func TestAddWorktree(t *testing.T) {
+	ctx := context.Background()
+
+	userRepo := t.TempDir()
+	_, err := RunGitCommand(ctx, userRepo, "init", "-q")
+	require.NoError(t, err)
+	files := map[string]string{
+		"README.md":   "hello\n",
+		"src/main.go": "package main\n",
+		"changed.txt": "committed\n",
+		"deleted.txt": "deleted\n",
+	}
+	for name, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(userRepo, name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(userRepo, name), []byte(content), 0644))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(userRepo, "run.sh"), []byte("#!/bin/sh\n"), 0755))
+	require.NoError(t, os.Symlink("README.md", filepath.Join(userRepo, "link.md")))
+	_, err = RunGitCommand(ctx, userRepo, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, userRepo, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init")
+	require.NoError(t, err)
+
+	// Uncommitted changes don't make it to worktrees
+	require.NoError(t, os.WriteFile(filepath.Join(userRepo, "changed.txt"), []byte("uncommitted\n"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(userRepo, "deleted.txt")))
+	require.NoError(t, os.WriteFile(filepath.Join(userRepo, "untracked.txt"), []byte("untracked\n"), 0644))
+
+	forkRepo := filepath.Join(t.TempDir(), "fork")
+	_, err = RunGitCommand(ctx, userRepo, "clone", "-q", "--bare", ".", forkRepo)
+	require.NoError(t, err)
+
+	defer SetStorageDriver(string(StorageAuto))
+	for _, driver := range []StorageDriver{StorageAuto, StorageCheckout, StorageReflink} {
+		t.Run(string(driver), func(t *testing.T) {
+			require.NoError(t, SetStorageDriver(string(driver)))
+			worktree := filepath.Join(t.TempDir(), "worktree")
+			branch := "env-" + string(driver)
+			_, err := RunGitCommand(ctx, forkRepo, "branch", branch, "HEAD")
+			require.NoError(t, err)
+
+			require.NoError(t, addWorktree(ctx, forkRepo, userRepo, worktree, branch))
+
+			for name, content := range files {
+				data, err := os.ReadFile(filepath.Join(worktree, name))
+				require.NoError(t, err, name)
+				assert.Equal(t, content, string(data), name)
+			}
+			info, err := os.Stat(filepath.Join(worktree, "run.sh"))
+			require.NoError(t, err)
+			assert.NotZero(t, info.Mode().Perm()&0100)
+			target, err := os.Readlink(filepath.Join(worktree, "link.md"))
+			require.NoError(t, err)
+			assert.Equal(t, "README.md", target)
+			assert.NoFileExists(t, filepath.Join(worktree, "untracked.txt"))
+
+			status, err := RunGitCommand(ctx, worktree, "status", "--porcelain")
+			require.NoError(t, err)
+			assert.Empty(t, status)
+		})
+	}
+
+	assert.Error(t, SetStorageDriver("overlay"))
+}
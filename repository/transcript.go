@@ -0,0 +1,275 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TranscriptMessage is a message of an agent conversation
+type TranscriptMessage struct {
+	Role      string               `json:"role"`
+	Text      string               `json:"text,omitempty"`
+	Time      time.Time            `json:"time,omitzero"`
+	ToolCalls []TranscriptToolCall `json:"tool_calls,omitempty"`
+}
+
+// TranscriptToolCall is a tool called by the agent in a conversation
+type TranscriptToolCall struct {
+	Name  string         `json:"name"`
+	Input map[string]any `json:"input,omitempty"`
+}
+
+// transcriptEntry covers the conversation exports of the supported agents:
+// Claude API messages ({"role", "content": [blocks]}), Claude session logs ({"type", "timestamp", "message"}),
+// and OpenAI-style exports such as Cursor's ({"role", "content": "text", "tool_calls": [...]}).
+type transcriptEntry struct {
+	Type      string           `json:"type"`
+	Role      string           `json:"role"`
+	Timestamp string           `json:"timestamp"`
+	CreatedAt string           `json:"created_at"`
+	Message   *transcriptEntry `json:"message"`
+	Content   json.RawMessage  `json:"content"`
+	ToolCalls []struct {
+		Function struct {
+			Name      string `json:"name"`
+			Arguments string `json:"arguments"`
+		} `json:"function"`
+	} `json:"tool_calls"`
+}
+
+type transcriptBlock struct {
+	Type  string         `json:"type"`
+	Text  string         `json:"text"`
+	Name  string         `json:"name"`
+	Input map[string]any `json:"input"`
+}
+
+// ParseTranscript parses an agent conversation exported as a JSON array of messages, an object with a "messages"
+// array, or JSON lines. Tool results and system messages are skipped.
+func ParseTranscript(data []byte) ([]TranscriptMessage, error) {
+	entries := []transcriptEntry{}
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case len(trimmed) == 0:
+		return nil, fmt.Errorf("empty transcript")
+	case trimmed[0] == '[':
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return nil, fmt.Errorf("invalid transcript: %w", err)
+		}
+	default:
+		var export struct {
+			Messages []transcriptEntry `json:"messages"`
+		}
+		if err := json.Unmarshal(trimmed, &export); err == nil && export.Messages != nil {
+			entries = export.Messages
+			break
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			var entry transcriptEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				return nil, fmt.Errorf("invalid transcript line %d: %w", line, err)
+			}
+			entries = append(entries, entry)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	messages := []TranscriptMessage{}
+	for _, entry := range entries {
+		message, err := entry.toMessage()
+		if err != nil {
+			return nil, err
+		}
+		if message.Role != "user" && message.Role != "assistant" {
+			continue
+		}
+		if message.Text == "" && len(message.ToolCalls) == 0 {
+			// Tool results
+			continue
+		}
+		messages = append(messages, message)
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("no messages found in transcript")
+	}
+	return messages, nil
+}
+
+func (entry transcriptEntry) toMessage() (TranscriptMessage, error) {
+	message := TranscriptMessage{Role: entry.Role}
+	for _, ts := range []string{entry.Timestamp, entry.CreatedAt} {
+		if t, err := time.Parse(time.RFC3339, ts); err == nil {
+			message.Time = t
+			break
+		}
+	}
+	if entry.Message != nil {
+		inner, err := entry.Message.toMessage()
+		if err != nil {
+			return message, err
+		}
+		inner.Time = message.Time
+		if inner.Role == "" {
+			inner.Role = entry.Type
+		}
+		return inner, nil
+	}
+
+	var text string
+	var blocks []transcriptBlock
+	switch {
+	case len(entry.Content) == 0 || string(entry.Content) == "null":
+	case json.Unmarshal(entry.Content, &text) == nil:
+		message.Text = strings.TrimSpace(text)
+	case json.Unmarshal(entry.Content, &blocks) == nil:
+		texts := []string{}
+		for _, block := range blocks {
+			switch block.Type {
+			case "text":
+				if t := strings.TrimSpace(block.Text); t != "" {
+					texts = append(texts, t)
+				}
+			case "tool_use":
+				message.ToolCalls = append(message.ToolCalls, TranscriptToolCall{Name: block.Name, Input: block.Input})
+			}
+		}
+		message.Text = strings.Join(texts, "\n\n")
+	default:
+		return message, fmt.Errorf("invalid content of %s message: %s", entry.Role, entry.Content)
+	}
+
+	for _, call := range entry.ToolCalls {
+		input := map[string]any{}
+		if call.Function.Arguments != "" {
+			if err := json.Unmarshal([]byte(call.Function.Arguments), &input); err != nil {
+				return message, fmt.Errorf("invalid arguments of tool call %s: %w", call.Function.Name, err)
+			}
+		}
+		message.ToolCalls = append(message.ToolCalls, TranscriptToolCall{Name: call.Function.Name, Input: input})
+	}
+	return message, nil
+}
+
+// HistoryCommit is a change recorded in the history of an environment
+type HistoryCommit struct {
+	Hash    string    `json:"hash"`
+	Subject string    `json:"subject"`
+	Time    time.Time `json:"time"`
+	Files   []string  `json:"files,omitempty"`
+}
+
+// TimelineEntry is a container-use tool call of the transcript, with the conversation explaining it and the change it made
+type TimelineEntry struct {
+	Tool        string    `json:"tool"`
+	Explanation string    `json:"explanation,omitempty"`
+	Time        time.Time `json:"time,omitzero"`
+	// Request is the last user message before the tool call
+	Request string `json:"request,omitempty"`
+	// Reasoning is what the agent said since that message or its previous tool calls, up to the tool call
+	Reasoning string         `json:"reasoning,omitempty"`
+	Commit    *HistoryCommit `json:"commit,omitempty"`
+}
+
+// AnnotatedTimeline is the history of an environment annotated with the conversation of the agent that made it
+type AnnotatedTimeline struct {
+	EnvironmentID string          `json:"environment_id"`
+	Entries       []TimelineEntry `json:"entries"`
+	// Unexplained are the changes of the environment no tool call of the transcript was linked to
+	Unexplained []HistoryCommit `json:"unexplained,omitempty"`
+}
+
+// containerUseTool returns the name of a container-use tool, without the MCP server prefix agents add to it
+// (e.g. "mcp__container-use__environment_file_write"). It returns an empty string for other tools.
+func containerUseTool(name string) string {
+	if i := strings.LastIndex(name, "__"); i >= 0 {
+		name = name[i+2:]
+	}
+	if !strings.HasPrefix(name, "environment_") {
+		return ""
+	}
+	return name
+}
+
+// commitSubject is the part of a commit message git shows as its subject: the first paragraph, on one line
+func commitSubject(message string) string {
+	paragraph, _, _ := strings.Cut(strings.TrimSpace(message), "\n\n")
+	return strings.Join(strings.Fields(paragraph), " ")
+}
+
+// AnnotateHistory links the container-use tool calls of a transcript to the commits of an environment, oldest first.
+// A tool call is linked to the next commit whose message is its explanation. Tool calls for other environments are
+// left out, as are tool calls without an environment (environment_create) that no commit was linked to.
+func AnnotateHistory(id string, commits []HistoryCommit, messages []TranscriptMessage) *AnnotatedTimeline {
+	timeline := &AnnotatedTimeline{EnvironmentID: id, Entries: []TimelineEntry{}}
+	linked := make([]bool, len(commits))
+	// Commits are linked in order: a tool call can't explain a change made before an earlier tool call's
+	next := 0
+
+	request := ""
+	reasoning := []string{}
+	for _, message := range messages {
+		if message.Role == "user" {
+			request = message.Text
+			reasoning = reasoning[:0]
+			continue
+		}
+		if message.Text != "" {
+			reasoning = append(reasoning, message.Text)
+		}
+		for _, call := range message.ToolCalls {
+			tool := containerUseTool(call.Name)
+			if tool == "" {
+				continue
+			}
+			envID, _ := call.Input["environment_id"].(string)
+			if envID != "" && envID != id {
+				continue
+			}
+			explanation, _ := call.Input["explanation"].(string)
+			entry := TimelineEntry{
+				Tool:        tool,
+				Explanation: strings.TrimSpace(explanation),
+				Time:        message.Time,
+				Request:     request,
+				Reasoning:   strings.Join(reasoning, "\n\n"),
+			}
+			if subject := commitSubject(explanation); subject != "" {
+				for i := next; i < len(commits); i++ {
+					if !linked[i] && commits[i].Subject == subject {
+						linked[i] = true
+						next = i + 1
+						entry.Commit = &commits[i]
+						break
+					}
+				}
+			}
+			if envID == "" && entry.Commit == nil {
+				// Can't tell which environment it was for
+				continue
+			}
+			timeline.Entries = append(timeline.Entries, entry)
+		}
+		if len(message.ToolCalls) > 0 {
+			// Later tool calls are explained by what the agent says next
+			reasoning = reasoning[:0]
+		}
+	}
+
+	for i, commit := range commits {
+		if !linked[i] {
+			timeline.Unexplained = append(timeline.Unexplained, commit)
+		}
+	}
+	return timeline
+}
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const claudeSessionTranscript = `
+{"type":"user","timestamp":"2025-07-01T10:00:00Z","message":{"role":"user","content":"Add an email column to users"}}
+{"type":"assistant","timestamp":"2025-07-01T10:00:05Z","message":{"role":"assistant","content":[{"type":"text","text":"The schema lives in schema.sql, I'll extend it."},{"type":"tool_use","id":"t1","name":"mcp__container-use__environment_file_write","input":{"environment_id":"fancy-mallard","explanation":"Add the email column","target_file":"schema.sql"}}]}}
+{"type":"user","timestamp":"2025-07-01T10:00:06Z","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":"ok"}]}}
+{"type":"assistant","timestamp":"2025-07-01T10:00:10Z","message":{"role":"assistant","content":[{"type":"tool_use","id":"t2","name":"Read","input":{"file_path":"README.md"}},{"type":"tool_use","id":"t3","name":"mcp__container-use__environment_run_cmd","input":{"environment_id":"other-env","explanation":"Run the tests","command":"go test"}}]}}
+`
+
+func TestParseTranscript(t *testing.T) {
+	messages, err := ParseTranscript([]byte(claudeSessionTranscript))
+	require.NoError(t, err)
+	require.Len(t, messages, 3, "tool results are skipped")
+	assert.Equal(t, "user", messages[0].Role)
+	assert.Equal(t, "Add an email column to users", messages[0].Text)
+	assert.Equal(t, time.Date(2025, 7, 1, 10, 0, 5, 0, time.UTC), messages[1].Time)
+	assert.Equal(t, "The schema lives in schema.sql, I'll extend it.", messages[1].Text)
+	require.Len(t, messages[1].ToolCalls, 1)
+	assert.Equal(t, "schema.sql", messages[1].ToolCalls[0].Input["target_file"])
+	assert.Len(t, messages[2].ToolCalls, 2)
+
+	t.Run("openai_style", func(t *testing.T) {
+		messages, err := ParseTranscript([]byte(`{"messages": [
+			{"role": "system", "content": "You are a coding agent"},
+			{"role": "user", "content": "Fix the build"},
+			{"role": "assistant", "content": null, "tool_calls": [{"id": "c1", "type": "function", "function": {"name": "environment_run_cmd", "arguments": "{\"environment_id\": \"fancy-mallard\", \"command\": \"make\"}"}}]}
+		]}`))
+		require.NoError(t, err)
+		require.Len(t, messages, 2)
+		assert.Equal(t, "Fix the build", messages[0].Text)
+		assert.Equal(t, []TranscriptToolCall{{Name: "environment_run_cmd", Input: map[string]any{"environment_id": "fancy-mallard", "command": "make"}}}, messages[1].ToolCalls)
+	})
+
+	_, err = ParseTranscript([]byte("  "))
+	assert.Error(t, err)
+	_, err = ParseTranscript([]byte(`[{"role": "system", "content": "nothing else"}]`))
+	assert.Error(t, err)
+}
+
+func TestAnnotateHistory(t *testing.T) {
+	commits := []HistoryCommit{
+		{Hash: "aaaaaaa1", Subject: "Create environment"},
+		{Hash: "bbbbbbb2", Subject: "Add the email column", Files: []string{"schema.sql"}},
+		{Hash: "ccccccc3", Subject: "Manual fix"},
+	}
+	messages := []TranscriptMessage{
+		{Role: "user", Text: "Add an email column to users"},
+		{Role: "assistant", Text: "Let me set up an environment.", ToolCalls: []TranscriptToolCall{
+			{Name: "mcp__container-use__environment_create", Input: map[string]any{"explanation": "Create environment"}},
+		}},
+		{Role: "assistant", Text: "Now the schema.", ToolCalls: []TranscriptToolCall{
+			{Name: "mcp__container-use__environment_file_write", Input: map[string]any{"environment_id": "fancy-mallard", "explanation": "Add the email column\n\nUsers asked for it"}},
+			{Name: "mcp__container-use__environment_run_cmd", Input: map[string]any{"environment_id": "fancy-mallard", "explanation": "Check the schema"}},
+			{Name: "mcp__container-use__environment_file_write", Input: map[string]any{"environment_id": "other-env", "explanation": "Manual fix"}},
+			{Name: "Bash", Input: map[string]any{"command": "ls"}},
+		}},
+	}
+
+	timeline := AnnotateHistory("fancy-mallard", commits, messages)
+	require.Len(t, timeline.Entries, 3)
+
+	assert.Equal(t, "environment_create", timeline.Entries[0].Tool)
+	assert.Equal(t, "aaaaaaa1", timeline.Entries[0].Commit.Hash, "environment_create is linked by its explanation")
+	assert.Equal(t, "Let me set up an environment.", timeline.Entries[0].Reasoning)
+
+	write := timeline.Entries[1]
+	assert.Equal(t, "environment_file_write", write.Tool)
+	assert.Equal(t, "Add an email column to users", write.Request)
+	assert.Equal(t, "Now the schema.", write.Reasoning, "reasoning restarts after each tool call")
+	require.NotNil(t, write.Commit)
+	assert.Equal(t, []string{"schema.sql"}, write.Commit.Files)
+
+	assert.Equal(t, "environment_run_cmd", timeline.Entries[2].Tool)
+	assert.Nil(t, timeline.Entries[2].Commit)
+
+	assert.Equal(t, []HistoryCommit{commits[2]}, timeline.Unexplained, "tool calls of other environments don't explain changes")
+}
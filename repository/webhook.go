@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+)
+
+// Headers of webhook callbacks
+const (
+	webhookEventHeader     = "X-Container-Use-Event"
+	webhookDeliveryHeader  = "X-Container-Use-Delivery"
+	webhookSignatureHeader = "X-Container-Use-Signature-256"
+)
+
+const (
+	webhookTimeout  = 10 * time.Second
+	webhookAttempts = 3
+)
+
+// WebhookPayload is the body of the callbacks sent to webhooks
+type WebhookPayload struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	// Repository is the path of the user's repository on the host
+	Repository  string             `json:"repository"`
+	Host        string             `json:"host,omitempty"`
+	User        string             `json:"user,omitempty"`
+	Environment WebhookEnvironment `json:"environment"`
+	// Checkpoint is set for environment.checkpointed events
+	Checkpoint *environment.Checkpoint `json:"checkpoint,omitempty"`
+}
+
+// WebhookEnvironment is the metadata of an environment sent to webhooks
+type WebhookEnvironment struct {
+	ID         string                      `json:"id"`
+	Title      string                      `json:"title,omitempty"`
+	Branch     string                      `json:"branch"`
+	BaseBranch string                      `json:"base_branch,omitempty"`
+	BaseCommit string                      `json:"base_commit,omitempty"`
+	BaseImage  string                      `json:"base_image,omitempty"`
+	Services   []string                    `json:"services,omitempty"`
+	Resources  *environment.ResourceLimits `json:"resources,omitempty"`
+	CreatedAt  time.Time                   `json:"created_at"`
+	UpdatedAt  time.Time                   `json:"updated_at"`
+}
+
+// newWebhookPayload describes an event of an environment
+func (r *Repository) newWebhookPayload(event string, info *environment.EnvironmentInfo, now time.Time) *WebhookPayload {
+	payload := &WebhookPayload{
+		Event:      event,
+		Time:       now,
+		Repository: r.userRepoPath,
+		Environment: WebhookEnvironment{
+			ID:         info.ID,
+			Title:      info.State.Title,
+			Branch:     "container-use/" + info.ID,
+			BaseBranch: info.State.BaseBranch,
+			BaseCommit: info.State.BaseCommit,
+			CreatedAt:  info.State.CreatedAt,
+			UpdatedAt:  info.State.UpdatedAt,
+		},
+	}
+	payload.Host, _ = os.Hostname()
+	if u, err := user.Current(); err == nil {
+		payload.User = u.Username
+	}
+	if config := info.State.Config; config != nil {
+		payload.Environment.BaseImage = config.BaseImage
+		payload.Environment.Resources = config.Resources
+		for _, svc := range config.Services {
+			payload.Environment.Services = append(payload.Environment.Services, svc.Name+"="+svc.Image)
+		}
+	}
+	return payload
+}
+
+// notifyWebhooks calls back the webhooks of the user's configuration wanting the event. Agents can't change them.
+// Failed deliveries are logged: they don't fail the operation the event is about.
+func (r *Repository) notifyWebhooks(ctx context.Context, payload *WebhookPayload) {
+	config := environment.DefaultConfig()
+	if err := config.Load(r.userRepoPath); err != nil {
+		slog.ErrorContext(ctx, "Failed to load webhooks", "err", err)
+		return
+	}
+	if len(config.Webhooks) == 0 {
+		return
+	}
+	if environment.IsOffline() {
+		slog.WarnContext(ctx, "Not calling back webhooks while offline", "event", payload.Event, "environment.id", payload.Environment.ID)
+		return
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode webhook payload", "err", err)
+		return
+	}
+	for _, hook := range config.Webhooks {
+		if !hook.Wants(payload.Event) {
+			continue
+		}
+		if err := deliverWebhook(ctx, http.DefaultClient, hook, payload.Event, body); err != nil {
+			slog.ErrorContext(ctx, "Failed to deliver webhook", "url", hook.URL, "event", payload.Event, "environment.id", payload.Environment.ID, "err", err)
+		}
+	}
+}
+
+// NotifyCheckpoint calls back the webhooks with a checkpoint of an environment
+func (r *Repository) NotifyCheckpoint(ctx context.Context, env *environment.Environment, checkpoint *environment.Checkpoint) {
+	payload := r.newWebhookPayload(environment.WebhookEventCheckpointed, env.EnvironmentInfo, time.Now())
+	payload.Checkpoint = checkpoint
+	r.notifyWebhooks(ctx, payload)
+}
+
+// signWebhookPayload is the HMAC-SHA256 of the body keyed by the secret of the webhook, as sent in the signature header
+func signWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhook posts a payload to a webhook, retrying on network and server errors.
+// Every attempt has the same delivery ID, for the receiver to ignore duplicates.
+func deliverWebhook(ctx context.Context, client *http.Client, hook environment.Webhook, event string, body []byte) error {
+	signature := ""
+	if hook.Secret != "" {
+		secret, err := environment.ResolveSecret(ctx, hook.Secret)
+		if err != nil {
+			return err
+		}
+		signature = signWebhookPayload(secret, body)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	delivery := hex.EncodeToString(id)
+
+	var lastErr error
+	for attempt := range webhookAttempts {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * time.Second):
+			}
+		}
+		retry, err := postWebhook(ctx, client, hook.URL, event, delivery, signature, body)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+	return lastErr
+}
+
+// postWebhook makes a delivery attempt, and tells whether a failed one is worth retrying
+func postWebhook(ctx context.Context, client *http.Client, url, event, delivery, signature string, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "container-use")
+	req.Header.Set(webhookEventHeader, event)
+	req.Header.Set(webhookDeliveryHeader, delivery)
+	if signature != "" {
+		req.Header.Set(webhookSignatureHeader, signature)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return false, nil
+}
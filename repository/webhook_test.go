@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeliverWebhook(t *testing.T) {
+	t.Setenv("CU_TEST_WEBHOOK_SECRET", "s3cret")
+	body := []byte(`{"event":"environment.created"}`)
+
+	var attempts atomic.Int32
+	deliveries := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ := io.ReadAll(r.Body)
+		assert.Equal(t, body, received)
+		assert.Equal(t, environment.WebhookEventCreated, r.Header.Get(webhookEventHeader))
+		assert.Equal(t, signWebhookPayload("s3cret", body), r.Header.Get(webhookSignatureHeader))
+		deliveries[r.Header.Get(webhookDeliveryHeader)] = true
+		// The first attempt fails on the server side, and is retried
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	hook := environment.Webhook{URL: server.URL, Secret: "env://CU_TEST_WEBHOOK_SECRET"}
+	require.NoError(t, deliverWebhook(context.Background(), server.Client(), hook, environment.WebhookEventCreated, body))
+	assert.EqualValues(t, 2, attempts.Load())
+	assert.Len(t, deliveries, 1, "retries keep the delivery ID")
+}
+
+func TestDeliverWebhookClientError(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get(webhookSignatureHeader))
+		attempts.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	err := deliverWebhook(context.Background(), server.Client(), environment.Webhook{URL: server.URL}, environment.WebhookEventDeleted, []byte(`{}`))
+	assert.ErrorContains(t, err, "401")
+	assert.EqualValues(t, 1, attempts.Load(), "client errors aren't retried")
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	// echo -n 'hello' | openssl dgst -sha256 -hmac key
+	assert.Equal(t, "sha256=9307b3b915efb5171ff14d8cb55fbcc798c6c0ef1456d66ded1a6aa723a58b7b", signWebhookPayload("key", []byte("hello")))
+}